@@ -5,8 +5,12 @@ import (
 	"io/ioutil"
 	"net/http"
 	"strings"
+	"time"
 
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
 	"github.com/gorilla/mux"
+	"github.com/gorilla/securecookie"
+	"go.uber.org/zap"
 )
 
 // handleAppStats gets the applications stats
@@ -25,27 +29,60 @@ func (a *api) handleAppStats() http.HandlerFunc {
 			a.Failure(w, r, http.StatusInternalServerError, err)
 			return
 		}
+		AppStats.StoryboardReapedConnectionCount = a.storyboardService.ReapedConnections()
 
 		a.Success(w, r, http.StatusOK, AppStats, nil)
 	}
 }
 
-// handleGetRegisteredUsers gets a list of registered users
-// @Summary Get Registered Users
-// @Description get list of registered users
+// handleGetInstanceStats gets the instance wide usage stats
+// @Summary Get Instance Stats
+// @Description get instance usage stats such as active users, votes cast, and signups over time
+// @Tags admin
+// @Produce  json
+// @Param from query string false "Start date (YYYY-MM-DD) for active users and signups, defaults to 30 days ago"
+// @Param to query string false "End date (YYYY-MM-DD) inclusive for active users and signups, defaults to today"
+// @Success 200 object standardJsonResponse{data=model.InstanceStats}
+// @Failure 400 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /admin/instance-stats [get]
+func (a *api) handleGetInstanceStats() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		From, To, err := getDateRangeFromRequest(r, time.Now())
+		if err != nil {
+			a.Failure(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		Stats, err := a.db.GetInstanceStats(From, To)
+		if err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		a.Success(w, r, http.StatusOK, Stats, nil)
+	}
+}
+
+// handleGetUsers gets a page of registered users
+// @Summary Get Users
+// @Description get a page of registered users, optionally searching by name or email
 // @Tags admin
 // @Produce  json
 // @Param limit query int false "Max number of results to return"
 // @Param offset query int false "Starting point to return rows from, should be multiplied by limit or 0"
+// @Param search query string false "Case-insensitive search of the users name or email"
 // @Success 200 object standardJsonResponse{data=[]model.User}
 // @Failure 500 object standardJsonResponse{}
 // @Security ApiKeyAuth
 // @Router /admin/users [get]
-func (a *api) handleGetRegisteredUsers() http.HandlerFunc {
+func (a *api) handleGetUsers() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		Limit, Offset := getLimitOffsetFromRequest(r)
+		Search := r.URL.Query().Get("search")
 
-		Users, Count, err := a.db.GetRegisteredUsers(Limit, Offset)
+		Users, Count, err := a.db.GetUsers(Search, Limit, Offset)
 		if err != nil {
 			a.Failure(w, r, http.StatusInternalServerError, err)
 			return
@@ -99,6 +136,7 @@ func (a *api) handleUserCreate() http.HandlerFunc {
 			strings.ToLower(user.Email),
 			user.Password1,
 			user.Password2,
+			a.passwordPolicy(),
 		)
 
 		if accountErr != nil {
@@ -106,6 +144,11 @@ func (a *api) handleUserCreate() http.HandlerFunc {
 			return
 		}
 
+		if err := a.db.CheckEmailAvailable(UserEmail, ""); err != nil {
+			a.emailUnavailableFailure(w, r, err)
+			return
+		}
+
 		newUser, VerifyID, err := a.db.CreateUser(UserName, UserEmail, UserPassword)
 		if err != nil {
 			a.Failure(w, r, http.StatusInternalServerError, err)
@@ -139,6 +182,7 @@ func (a *api) handleUserPromote() http.HandlerFunc {
 			a.Failure(w, r, http.StatusInternalServerError, err)
 			return
 		}
+		a.endUserSessions(UserID)
 
 		a.Success(w, r, http.StatusOK, nil, nil)
 	}
@@ -164,11 +208,22 @@ func (a *api) handleUserDemote() http.HandlerFunc {
 			a.Failure(w, r, http.StatusInternalServerError, err)
 			return
 		}
+		a.endUserSessions(UserID)
 
 		a.Success(w, r, http.StatusOK, nil, nil)
 	}
 }
 
+// userDisabledFailure responds with the failure for a disable or enable user error
+func (a *api) userDisabledFailure(w http.ResponseWriter, r *http.Request, err error) {
+	if err.Error() == "USER_NOT_FOUND" {
+		a.Failure(w, r, http.StatusNotFound, Errorf(ENOTFOUND, err.Error()))
+		return
+	}
+
+	a.Failure(w, r, http.StatusInternalServerError, err)
+}
+
 // handleUserDisable handles disabling a user
 // @Summary Disable User
 // @Description Disable a user from logging in
@@ -176,6 +231,8 @@ func (a *api) handleUserDemote() http.HandlerFunc {
 // @Produce  json
 // @Param userId path string true "the user ID to disable"
 // @Success 200 object standardJsonResponse{}
+// @Failure 400 object standardJsonResponse{}
+// @Failure 404 object standardJsonResponse{}
 // @Failure 500 object standardJsonResponse{}
 // @Security ApiKeyAuth
 // @Router /admin/users/{userId}/disable [patch]
@@ -184,11 +241,22 @@ func (a *api) handleUserDisable() http.HandlerFunc {
 		vars := mux.Vars(r)
 		UserID := vars["userId"]
 
-		err := a.db.DisableUser(UserID)
+		// an admin disabling themselves could lock everyone out
+		if UserID == r.Context().Value(contextKeyUserID).(string) {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, "CANNOT_DISABLE_SELF"))
+			return
+		}
+
+		SessionIDs, err := a.db.SetUserDisabled(UserID, true)
 		if err != nil {
-			a.Failure(w, r, http.StatusInternalServerError, err)
+			a.userDisabledFailure(w, r, err)
 			return
 		}
+		// sessions are already ended, close any sockets still open with them or as a guest
+		for _, SessionID := range SessionIDs {
+			a.evictSession(SessionID)
+		}
+		a.evictUser(UserID)
 
 		a.Success(w, r, http.StatusOK, nil, nil)
 	}
@@ -201,6 +269,7 @@ func (a *api) handleUserDisable() http.HandlerFunc {
 // @Produce  json
 // @Param userId path string true "the user ID to enable"
 // @Success 200 object standardJsonResponse{}
+// @Failure 404 object standardJsonResponse{}
 // @Failure 500 object standardJsonResponse{}
 // @Security ApiKeyAuth
 // @Router /admin/users/{userId}/enable [patch]
@@ -209,8 +278,36 @@ func (a *api) handleUserEnable() http.HandlerFunc {
 		vars := mux.Vars(r)
 		UserID := vars["userId"]
 
-		err := a.db.EnableUser(UserID)
-		if err != nil {
+		if _, err := a.db.SetUserDisabled(UserID, false); err != nil {
+			a.userDisabledFailure(w, r, err)
+			return
+		}
+
+		a.Success(w, r, http.StatusOK, nil, nil)
+	}
+}
+
+// handleRestoreUser restores a deleted user within the recovery window
+// @Summary Restore User
+// @Description Restores a deleted user that hasn't yet been purged after the recovery window
+// @Tags admin
+// @Produce  json
+// @Param userId path string true "the user ID to restore"
+// @Success 200 object standardJsonResponse{}
+// @Failure 404 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /admin/users/{userId}/restore [patch]
+func (a *api) handleRestoreUser() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		UserID := vars["userId"]
+
+		if err := a.db.RestoreUser(UserID); err != nil {
+			if err.Error() == "DELETED_USER_NOT_FOUND" {
+				a.Failure(w, r, http.StatusNotFound, Errorf(ENOTFOUND, err.Error()))
+				return
+			}
 			a.Failure(w, r, http.StatusInternalServerError, err)
 			return
 		}
@@ -238,15 +335,20 @@ func (a *api) handleAdminUpdateUserPassword() http.HandlerFunc {
 		UserPassword, passwordErr := validateUserPassword(
 			keyVal["password1"].(string),
 			keyVal["password2"].(string),
+			a.passwordPolicy(),
 		)
 
 		if passwordErr != nil {
-			a.Failure(w, r, http.StatusBadRequest, passwordErr)
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, passwordErr.Error()))
 			return
 		}
 
 		UserName, UserEmail, updateErr := a.db.UserUpdatePassword(UserID, UserPassword)
 		if updateErr != nil {
+			if updateErr.Error() == "PASSWORD_RECENTLY_USED" {
+				a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, updateErr.Error()))
+				return
+			}
 			a.Failure(w, r, http.StatusInternalServerError, updateErr)
 			return
 		}
@@ -361,3 +463,278 @@ func (a *api) handleSearchRegisteredUsersByEmail() http.HandlerFunc {
 		a.Success(w, r, http.StatusOK, Users, Meta)
 	}
 }
+
+type planLimitRequestBody struct {
+	MaxPlansPerBattle *int `json:"maxPlansPerBattle" minimum:"0"`
+}
+
+// getPlanLimitFromRequest reads the plan limit override, null reverts to the default
+func getPlanLimitFromRequest(r *http.Request) (*int, error) {
+	var pl = planLimitRequestBody{}
+	body, bodyErr := ioutil.ReadAll(r.Body)
+	if bodyErr != nil {
+		return nil, Errorf(EINVALID, bodyErr.Error())
+	}
+
+	jsonErr := json.Unmarshal(body, &pl)
+	if jsonErr != nil {
+		return nil, Errorf(EINVALID, jsonErr.Error())
+	}
+
+	if pl.MaxPlansPerBattle != nil && *pl.MaxPlansPerBattle < 0 {
+		return nil, Errorf(EINVALID, "INVALID_PLAN_LIMIT")
+	}
+
+	return pl.MaxPlansPerBattle, nil
+}
+
+// handleTeamSetPlanLimit sets the teams max plans per battle override
+// @Summary Set Team Plan Limit
+// @Description Overrides the max plans per battle for battles associated to the team, null reverts to the default
+// @Tags admin
+// @Produce  json
+// @Param teamId path string true "the team ID"
+// @Param limit body planLimitRequestBody true "plan limit override"
+// @Success 200 object standardJsonResponse{}
+// @Failure 400 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /admin/teams/{teamId}/plan-limit [put]
+func (a *api) handleTeamSetPlanLimit() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		TeamID := vars["teamId"]
+
+		Limit, err := getPlanLimitFromRequest(r)
+		if err != nil {
+			a.Failure(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := a.db.TeamSetPlanLimit(TeamID, Limit); err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		a.Success(w, r, http.StatusOK, nil, nil)
+	}
+}
+
+// handleOrganizationSetPlanLimit sets the organizations max plans per battle override
+// @Summary Set Organization Plan Limit
+// @Description Overrides the max plans per battle for battles associated to the organizations teams, null reverts to the default
+// @Tags admin
+// @Produce  json
+// @Param orgId path string true "the organization ID"
+// @Param limit body planLimitRequestBody true "plan limit override"
+// @Success 200 object standardJsonResponse{}
+// @Failure 400 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /admin/organizations/{orgId}/plan-limit [put]
+func (a *api) handleOrganizationSetPlanLimit() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		OrgID := vars["orgId"]
+
+		Limit, err := getPlanLimitFromRequest(r)
+		if err != nil {
+			a.Failure(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		if err := a.db.OrganizationSetPlanLimit(OrgID, Limit); err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		a.Success(w, r, http.StatusOK, nil, nil)
+	}
+}
+
+type storageUsageResponse struct {
+	TotalBytes      int64                 `json:"totalBytes"`
+	TotalQuotaBytes int64                 `json:"totalQuotaBytes"`
+	Users           []*model.StorageUsage `json:"users"`
+}
+
+// handleGetStorageUsage gets the instances upload storage usage by user
+// @Summary Get Storage Usage
+// @Description get the instance upload storage usage and quota along with usage per user, a quota of 0 is unlimited
+// @Tags admin
+// @Produce  json
+// @Param limit query int false "Max number of results to return"
+// @Param offset query int false "Starting point to return rows from, should be multiplied by limit or 0"
+// @Success 200 object standardJsonResponse{data=storageUsageResponse}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /admin/storage [get]
+func (a *api) handleGetStorageUsage() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		Limit, Offset := getLimitOffsetFromRequest(r)
+
+		Usage, TotalBytes, Count, err := a.db.GetStorageUsage(Limit, Offset)
+		if err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		Meta := &pagination{
+			Count:  Count,
+			Offset: Offset,
+			Limit:  Limit,
+		}
+
+		a.Success(w, r, http.StatusOK, &storageUsageResponse{
+			TotalBytes:      TotalBytes,
+			TotalQuotaBytes: a.config.StorageQuotaTotal,
+			Users:           Usage,
+		}, Meta)
+	}
+}
+
+type storageQuotaRequestBody struct {
+	QuotaBytes *int64 `json:"quotaBytes" minimum:"0"`
+}
+
+// handleUserSetStorageQuota sets the users upload storage quota
+// @Summary Set User Storage Quota
+// @Description Overrides the users upload storage quota in bytes, 0 is unlimited and null reverts to the default
+// @Tags admin
+// @Produce  json
+// @Param userId path string true "the user ID"
+// @Param quota body storageQuotaRequestBody true "storage quota override"
+// @Success 200 object standardJsonResponse{}
+// @Failure 400 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /admin/users/{userId}/storage-quota [put]
+func (a *api) handleUserSetStorageQuota() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		UserID := vars["userId"]
+
+		var sq = storageQuotaRequestBody{}
+		body, bodyErr := ioutil.ReadAll(r.Body)
+		if bodyErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, bodyErr.Error()))
+			return
+		}
+
+		jsonErr := json.Unmarshal(body, &sq)
+		if jsonErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, jsonErr.Error()))
+			return
+		}
+
+		if sq.QuotaBytes != nil && *sq.QuotaBytes < 0 {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, "INVALID_STORAGE_QUOTA"))
+			return
+		}
+
+		if err := a.db.SetUserStorageQuota(UserID, sq.QuotaBytes); err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		a.Success(w, r, http.StatusOK, nil, nil)
+	}
+}
+
+type cookieKeysResponse struct {
+	// ConfiguredKeys the number of configured keys verified after the rotated keys
+	ConfiguredKeys int                       `json:"configuredKeys"`
+	RotatedKeys    []*model.CookieSigningKey `json:"rotatedKeys"`
+}
+
+// getRotatedCookieKeys gets the current rotated cookie signing key and the previous keys within retention, newest first
+func (a *api) getRotatedCookieKeys() ([][]byte, error) {
+	Keys, err := a.db.GetCookieSigningKeys(a.config.CookieKeyRetentionDays)
+	if err != nil {
+		return nil, err
+	}
+
+	RotatedKeys := make([][]byte, 0, len(Keys))
+	for _, k := range Keys {
+		RotatedKeys = append(RotatedKeys, k.Key)
+	}
+
+	return RotatedKeys, nil
+}
+
+// getCookieKeys gets the cookie signing keys metadata
+func (a *api) getCookieKeys() (*cookieKeysResponse, error) {
+	Keys, err := a.db.GetCookieSigningKeys(a.config.CookieKeyRetentionDays)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cookieKeysResponse{
+		ConfiguredKeys: len(a.cookie.configKeys),
+		RotatedKeys:    Keys,
+	}, nil
+}
+
+// handleGetCookieKeys gets the cookie signing keys in use
+// @Summary Get Cookie Signing Keys
+// @Description get the rotated cookie signing keys (never the key values), the newest is the current signer while older keys only verify
+// @Tags admin
+// @Produce  json
+// @Success 200 object standardJsonResponse{data=cookieKeysResponse}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /admin/cookie-keys [get]
+func (a *api) handleGetCookieKeys() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		Keys, err := a.getCookieKeys()
+		if err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		a.Success(w, r, http.StatusOK, Keys, nil)
+	}
+}
+
+// handleRotateCookieKey rotates the cookie signing key
+// @Summary Rotate Cookie Signing Key
+// @Description adds a new current cookie signing key, older keys remain valid for verifying existing cookies until the retention expires
+// @Tags admin
+// @Produce  json
+// @Success 200 object standardJsonResponse{data=cookieKeysResponse}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /admin/cookie-keys/rotate [post]
+func (a *api) handleRotateCookieKey() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		Key := securecookie.GenerateRandomKey(64)
+		if Key == nil {
+			a.Failure(w, r, http.StatusInternalServerError, Errorf(EINVALID, "UNABLE_TO_GENERATE_KEY"))
+			return
+		}
+
+		if _, err := a.db.AddCookieSigningKey(Key); err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		RotatedKeys, err := a.getRotatedCookieKeys()
+		if err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		a.cookie.setRotatedKeys(RotatedKeys)
+
+		a.logger.Info("cookie signing key rotated",
+			zap.String("acting_user_id", r.Context().Value(contextKeyUserID).(string)),
+		)
+
+		Keys, err := a.getCookieKeys()
+		if err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		a.Success(w, r, http.StatusOK, Keys, nil)
+	}
+}
@@ -2,6 +2,8 @@
 package api
 
 import (
+	"time"
+
 	"github.com/StevenWeathers/thunderdome-planning-poker/api/battle"
 	"github.com/StevenWeathers/thunderdome-planning-poker/api/retro"
 	"github.com/StevenWeathers/thunderdome-planning-poker/api/storyboard"
@@ -9,7 +11,6 @@ import (
 	"github.com/StevenWeathers/thunderdome-planning-poker/email"
 	"github.com/StevenWeathers/thunderdome-planning-poker/swaggerdocs"
 	"github.com/gorilla/mux"
-	"github.com/gorilla/securecookie"
 	"github.com/spf13/viper"
 	httpSwagger "github.com/swaggo/http-swagger"
 	"go.uber.org/zap"
@@ -35,6 +36,31 @@ type Config struct {
 	SecureCookieFlag bool
 	// Whether LDAP is enabled for authentication
 	LdapEnabled bool
+	// Profile fields managed by the LDAP directory that LDAP users can't change
+	LdapManagedFields []string
+	// LDAP group DNs whose members are admins, empty leaves user types unmanaged by LDAP
+	LdapAdminGroups []string
+	// Whether OpenID Connect is enabled for authentication
+	OIDCEnabled bool
+	// OpenID Connect issuer URL used for discovery
+	OIDCIssuer string
+	// OpenID Connect client credentials
+	OIDCClientID     string
+	OIDCClientSecret string
+	// OpenID Connect callback URL registered with the provider
+	OIDCRedirectURL string
+	// Whether GitHub login is enabled alongside normal or OpenID Connect authentication
+	GithubLoginEnabled bool
+	// GitHub OAuth app credentials and callback URL
+	GithubClientID     string
+	GithubClientSecret string
+	GithubRedirectURL  string
+	// Whether Google login is enabled alongside normal or OpenID Connect authentication
+	GoogleLoginEnabled bool
+	// Google OAuth client credentials and callback URL
+	GoogleClientID     string
+	GoogleClientSecret string
+	GoogleRedirectURL  string
 	// Feature flag for Poker Planning
 	FeaturePoker bool
 	// Feature flag for Retrospectives
@@ -43,15 +69,157 @@ type Config struct {
 	FeatureStoryboard bool
 	// Whether Organizations (and Departments) feature is enabled
 	OrganizationsEnabled bool
+	// Whether users must have a valid name before joining a battle
+	RequireNameToJoin bool
+	// List of words not allowed in user names
+	NameDenylist []string
+	// List of origins allowed to connect in addition to the same origin, e.g. for websockets
+	AllowedOrigins []string
+	// Features guest users are allowed to use keyed by capability e.g. can_create_battle
+	GuestCapabilities map[string]bool
+	// Hours a guest user account can be used before being logged out and removed, 0 is unlimited
+	GuestMaxSessionLifetime int
+	// Minutes before a guest session expires to prompt the guest to register
+	GuestSessionExpiryWarning int
+	// Days a deleted user can be restored by an admin before being purged, 0 deletes immediately
+	UserDeleteGraceDays int
+	// Minimum seconds between a user creating battles, retros, or storyboards, 0 is disabled
+	CreateCooldown int
+	// Max total upload storage in bytes for the instance, 0 is unlimited
+	StorageQuotaTotal int64
+	// Days rotated cookie signing keys remain valid for verification
+	CookieKeyRetentionDays int
+	// Header set by a trusted reverse proxy with the client ip, empty uses the connections remote address
+	ClientIPHeader string
+	// Whether accounts must be verified before a password reset link is issued
+	RequireVerifiedPasswordReset bool
+	// Days a team admin can be inactive before being demoted to member, 0 disables the policy
+	DemoteInactiveTeamAdminsDays int
+	// Days before demotion an inactive team admin is notified
+	DemoteInactiveTeamAdminsNoticeDays int
+	// Whether the weekly team digest is emailed
+	TeamDigestEnabled bool
+	// Day of the week (0 is Sunday) the team digest is sent
+	TeamDigestWeekday int
+	// Hour of the day (UTC) the team digest is sent
+	TeamDigestHour int
+	// Whether the team digest includes the battles completed
+	TeamDigestIncludeBattles bool
+	// Whether the team digest includes the stories and points estimated
+	TeamDigestIncludePoints bool
+	// Minutes between automatic snapshots of changed active storyboards, 0 is disabled
+	StoryboardSnapshotInterval int
+	// Number of automatic snapshots kept per storyboard
+	StoryboardSnapshotRetention int
+	// Onboarding checklist steps shown to new users
+	OnboardingSteps []string
+	// Maximum concurrent login sessions per user, 0 is unlimited
+	MaxUserSessions int
+	// Whether admins are exempt from the maximum login sessions
+	MaxUserSessionsAdminExempt bool
+	// Days after closing a battle its leaders can reopen it, 0 is unrestricted
+	BattleReopenWindowDays int
+	// Whether login, password and role changes end the pre-existing session issuing a fresh session id
+	RotateSessionOnPrivilegeChange bool
+	// Failed logins for an email within the lockout window before further attempts are rejected, 0 disables
+	LoginLockoutThreshold int
+	// Failed logins from a client ip within the lockout window before further attempts are rejected, 0 disables
+	LoginLockoutIPThreshold int
+	// Minutes of the sliding window failed logins are counted in
+	LoginLockoutWindow int
+	// Minimum password length
+	PasswordMinLength int
+	// Whether passwords must contain a symbol
+	PasswordRequireSymbol bool
+	// Whether passwords must contain a number
+	PasswordRequireNumber bool
+	// Whether passwords must contain both upper and lower case letters
+	PasswordRequireMixedCase bool
+	// CAPTCHA provider (hcaptcha, recaptcha, turnstile), empty disables CAPTCHA
+	CaptchaProvider string
+	// CAPTCHA provider secret key
+	CaptchaSecret string
+	// Whether a CAPTCHA is required to register, create a guest user or request a password reset
+	CaptchaEnabled bool
+	// Whether anyone can create unlisted throwaway battles without an account
+	AllowQuickBattles bool
+	// Minutes until a quick battle expires
+	QuickBattleTTL int
+	// Max quick battles a client ip can create per hour without an account, 0 is unlimited
+	QuickBattleIPLimit int
+	// Whether users can import battle plans from Jira
+	AllowJiraImport bool
+	// Jira hostnames connections are limited to, when empty any host resolving to a public address
+	JiraAllowedHosts []string
+	// Proxy URL of outbound integration requests, empty uses the HTTP_PROXY and HTTPS_PROXY environment
+	HTTPClientProxy string
+	// Seconds until outbound integration requests time out
+	HTTPClientTimeout int
+	// PEM file of additional CA certificates trusted by outbound integration requests, e.g. an intercepting proxies CA
+	HTTPClientCACertFile string
+	// Whether outbound integration requests skip TLS certificate verification
+	HTTPClientTLSInsecureSkipVerify bool
+	// Seconds between pings sent to storyboard websocket connections
+	WebsocketPingInterval int
+	// Seconds a storyboard websocket connection can go without a pong before it's closed
+	WebsocketPongTimeout int
+	// Messages per second each storyboard websocket connection can send, 0 is unlimited
+	WebsocketMessageRate float64
+	// Messages a storyboard websocket connection can send in a burst above the rate
+	WebsocketMessageBurst int
+	// Rate limited messages dropped before a storyboard websocket connection is closed, 0 never closes
+	WebsocketMaxDroppedMessages int
+	// Where uploaded avatars are stored (local, s3), empty disables avatar uploads
+	AvatarUploadStorage string
+	// Max size in bytes of an uploaded avatar image
+	AvatarUploadMaxSize int64
+	// Width and height in pixels uploaded avatars are resized to
+	AvatarUploadThumbnailSize int
+	// Directory uploaded avatars are stored in with local storage
+	AvatarUploadLocalDir string
+	// S3 bucket uploaded avatars are stored in
+	AvatarUploadS3Bucket string
+	// S3 bucket region
+	AvatarUploadS3Region string
+	// S3 compatible endpoint, empty uses the AWS endpoint of the region
+	AvatarUploadS3Endpoint string
+	// S3 access key ID
+	AvatarUploadS3AccessKeyID string
+	// S3 secret access key
+	AvatarUploadS3SecretAccessKey string
+	// Base URL uploaded avatars are served from, empty uses the buckets endpoint URL
+	AvatarUploadS3PublicURL string
 }
 
 type api struct {
 	config *Config
 	router *mux.Router
 	email  *email.Email
-	cookie *securecookie.SecureCookie
+	cookie *cookieKeyring
 	db     *db.Database
 	logger *zap.Logger
+	// battleService used to notify battle websocket clients of their session ending
+	battleService *battle.Service
+	// storyboardService used to report the storyboard websocket connections reaped and notify clients of their session ending
+	storyboardService *storyboard.Service
+	// retroService used to notify retro websocket clients of their session ending
+	retroService *retro.Service
+	// captcha verifies CAPTCHA tokens, nil when no provider is configured
+	captcha captchaVerifier
+	// oidc performs OpenID Connect logins, nil when OIDC isn't enabled
+	oidc *oidcProvider
+	// github performs GitHub logins, nil when GitHub login isn't enabled
+	github *socialProvider
+	// google performs Google logins, nil when Google login isn't enabled
+	google *socialProvider
+	// loginAttempts tracks failed logins per client ip
+	loginAttempts *loginAttemptLimiter
+	// quickBattleCreates tracks quick battles created without an account per client ip
+	quickBattleCreates *loginAttemptLimiter
+	// avatars stores uploaded avatars, nil when avatar uploads are disabled
+	avatars avatarStore
+	// jira searches Jira issues to import as battle plans
+	jira *jiraClient
 }
 
 // standardJsonResponse structure used for all restful APIs response body
@@ -78,7 +246,14 @@ const (
 	contextKeyOrgRole        contextKey = "orgRole"
 	contextKeyDepartmentRole contextKey = "departmentRole"
 	contextKeyTeamRole       contextKey = "teamRole"
+	contextKeyTeamAPIKey     contextKey = "teamApiKey"
 	adminUserType            string     = "ADMIN"
+	teamAPIKeyUserType       string     = "TEAM_APIKEY"
+	guestUserType            string     = "GUEST"
+	registeredUserType       string     = "REGISTERED"
+	guestCanCreateBattle     string     = "can_create_battle"
+	guestCanCreateRetro      string     = "can_create_retro"
+	guestCanCreateStoryboard string     = "can_create_storyboard"
 )
 
 // @title Thunderdome API
@@ -93,18 +268,77 @@ const (
 // @in header
 // @name X-API-Key
 // @version BETA
-func Init(config *Config, router *mux.Router, database *db.Database, email *email.Email, cookie *securecookie.SecureCookie, logger *zap.Logger) *api {
+func Init(config *Config, router *mux.Router, database *db.Database, email *email.Email, cookieKeys [][]byte, logger *zap.Logger) *api {
 	var a = &api{
 		config: config,
 		router: router,
 		db:     database,
 		email:  email,
-		cookie: cookie,
 		logger: logger,
 	}
-	b := battle.New(database, logger, a.validateSessionCookie, a.validateUserCookie)
-	rs := retro.New(database, logger, a.validateSessionCookie, a.validateUserCookie)
-	sb := storyboard.New(database, logger, a.validateSessionCookie, a.validateUserCookie)
+	a.cookie = newCookieKeyring(cookieKeys, a.getRotatedCookieKeys)
+	a.loginAttempts = newLoginAttemptLimiter(time.Duration(a.config.LoginLockoutWindow) * time.Minute)
+	a.quickBattleCreates = newLoginAttemptLimiter(time.Hour)
+	a.cookie.reloadKeys()
+
+	httpClient, err := newOutboundHTTPClient(config)
+	if err != nil {
+		logger.Fatal("error configuring outbound http client", zap.Error(err))
+	}
+	captcha, err := newCaptchaVerifier(config.CaptchaProvider, config.CaptchaSecret, httpClient)
+	if err != nil {
+		logger.Fatal("error configuring captcha", zap.Error(err))
+	}
+	a.captcha = captcha
+	if a.config.OIDCEnabled {
+		oidc, err := newOIDCProvider(config.OIDCIssuer, config.OIDCClientID, config.OIDCClientSecret, config.OIDCRedirectURL, httpClient)
+		if err != nil {
+			logger.Fatal("error configuring oidc", zap.Error(err))
+		}
+		a.oidc = oidc
+	}
+	if a.config.GithubLoginEnabled && !a.config.LdapEnabled {
+		github, err := newGithubProvider(config.GithubClientID, config.GithubClientSecret, config.GithubRedirectURL, httpClient)
+		if err != nil {
+			logger.Fatal("error configuring github login", zap.Error(err))
+		}
+		a.github = github
+	}
+	if a.config.GoogleLoginEnabled && !a.config.LdapEnabled {
+		google, err := newGoogleProvider(config.GoogleClientID, config.GoogleClientSecret, config.GoogleRedirectURL, httpClient)
+		if err != nil {
+			logger.Fatal("error configuring google login", zap.Error(err))
+		}
+		a.google = google
+	}
+	avatars, err := newAvatarStore(config, httpClient)
+	if err != nil {
+		logger.Fatal("error configuring avatar uploads", zap.Error(err))
+	}
+	a.avatars = avatars
+	// periodically purge deleted users past their recovery window along with their uploaded avatars
+	if a.config.UserDeleteGraceDays > 0 {
+		go a.db.DeletedUserSweeper(time.Hour, a.deleteUploadedAvatar)
+	}
+	// periodically purge guests past the max session lifetime that haven't made a request since
+	if a.config.GuestMaxSessionLifetime > 0 {
+		go a.db.ExpiredGuestSweeper(15*time.Minute, a.config.GuestMaxSessionLifetime, a.deleteUploadedAvatar)
+	}
+	a.jira = newJiraClient(httpClient, config.JiraAllowedHosts)
+	checkOrigin := websocketOriginChecker(a.config.AllowedOrigins)
+	b := battle.New(
+		database, logger, a.validateSessionCookie, a.validateUserCookie, a.validateJoinName, checkOrigin,
+		a.guestSessionExpiry, time.Duration(a.config.GuestSessionExpiryWarning)*time.Minute,
+	)
+	a.battleService = b
+	rs := retro.New(database, logger, a.validateSessionCookie, a.validateUserCookie, checkOrigin)
+	a.retroService = rs
+	sb := storyboard.New(
+		database, logger, a.validateSessionCookie, a.validateUserCookie, checkOrigin,
+		time.Duration(a.config.WebsocketPingInterval)*time.Second, time.Duration(a.config.WebsocketPongTimeout)*time.Second,
+		a.config.WebsocketMessageRate, a.config.WebsocketMessageBurst, a.config.WebsocketMaxDroppedMessages,
+	)
+	a.storyboardService = sb
 	swaggerJsonPath := "/" + a.config.PathPrefix + "swagger/doc.json"
 
 	swaggerdocs.SwaggerInfo.BasePath = a.config.PathPrefix + "/api"
@@ -119,17 +353,43 @@ func Init(config *Config, router *mux.Router, database *db.Database, email *emai
 	teamRouter := apiRouter.PathPrefix("/teams").Subrouter()
 	adminRouter := apiRouter.PathPrefix("/admin").Subrouter()
 
+	apiRouter.HandleFunc("/batch", a.userOnly(a.handleBatch())).Methods("POST")
 	// user authentication, profile
 	if a.config.LdapEnabled {
 		apiRouter.HandleFunc("/auth/ldap", a.handleLdapLogin()).Methods("POST")
+	} else if a.config.OIDCEnabled {
+		apiRouter.HandleFunc("/auth/oidc", a.handleOAuth2Login()).Methods("GET")
+		apiRouter.HandleFunc("/auth/oidc/callback", a.handleOAuth2Callback()).Methods("GET")
 	} else {
 		apiRouter.HandleFunc("/auth", a.handleLogin()).Methods("POST")
+		apiRouter.HandleFunc("/auth/mfa", a.handleLoginVerifyMFA()).Methods("POST")
+		userRouter.HandleFunc("/{userId}/mfa/setup", a.userOnly(a.entityUserOnly(a.handleUserMFASetup()))).Methods("POST")
+		userRouter.HandleFunc("/{userId}/mfa", a.userOnly(a.entityUserOnly(a.handleUserEnableMFA()))).Methods("POST")
+		userRouter.HandleFunc("/{userId}/mfa", a.userOnly(a.entityUserOnly(a.handleUserDisableMFA()))).Methods("DELETE")
 		apiRouter.HandleFunc("/auth/forgot-password", a.handleForgotPassword()).Methods("POST")
 		apiRouter.HandleFunc("/auth/reset-password", a.handleResetPassword()).Methods("PATCH")
+		apiRouter.HandleFunc("/auth/reset-password/{resetId}", a.handleValidateResetToken()).Methods("GET")
 		apiRouter.HandleFunc("/auth/update-password", a.userOnly(a.handleUpdatePassword())).Methods("PATCH")
 		apiRouter.HandleFunc("/auth/verify", a.handleAccountVerification()).Methods("PATCH")
+		apiRouter.HandleFunc("/auth/email-change", a.handleConfirmEmailChange()).Methods("PATCH")
+		userRouter.HandleFunc("/{userId}/email-change", a.userOnly(a.entityUserOnly(a.handleRequestEmailChange()))).Methods("POST")
 		apiRouter.HandleFunc("/auth/register", a.handleUserRegistration()).Methods("POST")
 	}
+	if a.avatars != nil {
+		userRouter.HandleFunc("/{userId}/avatar", a.userOnly(a.entityUserOnly(a.handleUploadAvatar()))).Methods("POST")
+	}
+	if _, ok := a.avatars.(*localAvatarStore); ok {
+		a.router.HandleFunc(uploadedAvatarPath+"{avatarFile}", a.handleUploadedAvatar()).Methods("GET")
+	}
+	// social logins, LDAP users are managed by the directory
+	if a.github != nil {
+		apiRouter.HandleFunc("/auth/github", a.handleGithubLogin()).Methods("GET")
+		apiRouter.HandleFunc("/auth/github/callback", a.handleGithubCallback()).Methods("GET")
+	}
+	if a.google != nil {
+		apiRouter.HandleFunc("/auth/google", a.handleGoogleLogin()).Methods("GET")
+		apiRouter.HandleFunc("/auth/google/callback", a.handleGoogleCallback()).Methods("GET")
+	}
 	apiRouter.HandleFunc("/auth/guest", a.handleCreateGuestUser()).Methods("POST")
 	apiRouter.HandleFunc("/auth/user", a.userOnly(a.handleSessionUserProfile())).Methods("GET")
 	apiRouter.HandleFunc("/auth/logout", a.handleLogout()).Methods("DELETE")
@@ -137,6 +397,18 @@ func Init(config *Config, router *mux.Router, database *db.Database, email *emai
 	userRouter.HandleFunc("/{userId}", a.userOnly(a.entityUserOnly(a.handleUserProfile()))).Methods("GET")
 	userRouter.HandleFunc("/{userId}", a.userOnly(a.entityUserOnly(a.handleUserProfileUpdate()))).Methods("PUT")
 	userRouter.HandleFunc("/{userId}", a.userOnly(a.entityUserOnly(a.handleUserDelete()))).Methods("DELETE")
+	userRouter.HandleFunc("/{userId}/anonymize", a.userOnly(a.entityUserOnly(a.handleAnonymizeUser()))).Methods("PATCH")
+	userRouter.HandleFunc("/{userId}/export", a.userOnly(a.entityUserOnly(a.handleUserDataExport()))).Methods("GET")
+	userRouter.HandleFunc("/{userId}/export/archive", a.userOnly(a.entityUserOnly(a.handleUserDataExportArchive()))).Methods("GET")
+	userRouter.HandleFunc("/{userId}/onboarding", a.userOnly(a.entityUserOnly(a.handleUpdateOnboarding()))).Methods("PUT")
+	userRouter.HandleFunc("/{userId}/quiet-hours", a.userOnly(a.entityUserOnly(a.handleGetUserQuietHours()))).Methods("GET")
+	userRouter.HandleFunc("/{userId}/quiet-hours", a.userOnly(a.entityUserOnly(a.handleUpdateUserQuietHours()))).Methods("PUT")
+	userRouter.HandleFunc("/{userId}/tags", a.userOnly(a.entityUserOnly(a.handleGetUserTags()))).Methods("GET")
+	userRouter.HandleFunc("/{userId}/views", a.userOnly(a.entityUserOnly(a.handleGetUserViews()))).Methods("GET")
+	userRouter.HandleFunc("/{userId}/views", a.userOnly(a.entityUserOnly(a.handleSaveUserView()))).Methods("POST")
+	userRouter.HandleFunc("/{userId}/views/{viewId}", a.userOnly(a.entityUserOnly(a.handleDeleteUserView()))).Methods("DELETE")
+	userRouter.HandleFunc("/{userId}/sessions", a.userOnly(a.entityUserOnly(a.handleGetUserSessions()))).Methods("GET")
+	userRouter.HandleFunc("/{userId}/sessions/{sessionId}", a.userOnly(a.entityUserOnly(a.handleDeleteSession()))).Methods("DELETE")
 	userRouter.HandleFunc("/{userId}/request-verify", a.userOnly(a.entityUserOnly(a.handleVerifyRequest()))).Methods("POST")
 	userRouter.HandleFunc("/{userId}/organizations", a.userOnly(a.entityUserOnly(a.handleGetOrganizationsByUser()))).Methods("GET")
 	userRouter.HandleFunc("/{userId}/organizations", a.userOnly(a.entityUserOnly(a.handleCreateOrganization()))).Methods("POST")
@@ -148,6 +420,10 @@ func Init(config *Config, router *mux.Router, database *db.Database, email *emai
 		userRouter.HandleFunc("/{userId}/apikeys", a.userOnly(a.verifiedUserOnly(a.handleAPIKeyGenerate()))).Methods("POST")
 		userRouter.HandleFunc("/{userId}/apikeys/{keyID}", a.userOnly(a.entityUserOnly(a.handleUserAPIKeyUpdate()))).Methods("PUT")
 		userRouter.HandleFunc("/{userId}/apikeys/{keyID}", a.userOnly(a.entityUserOnly(a.handleUserAPIKeyDelete()))).Methods("DELETE")
+		teamRouter.HandleFunc("/{teamId}/apikeys", a.userOnly(a.teamAdminOnly(a.handleTeamAPIKeys()))).Methods("GET")
+		teamRouter.HandleFunc("/{teamId}/apikeys", a.userOnly(a.teamAdminOnly(a.handleTeamAPIKeyGenerate()))).Methods("POST")
+		teamRouter.HandleFunc("/{teamId}/apikeys/{keyID}", a.userOnly(a.teamAdminOnly(a.handleTeamAPIKeyUpdate()))).Methods("PUT")
+		teamRouter.HandleFunc("/{teamId}/apikeys/{keyID}", a.userOnly(a.teamAdminOnly(a.handleTeamAPIKeyDelete()))).Methods("DELETE")
 	}
 	// country(s)
 	if viper.GetBool("config.show_active_countries") {
@@ -201,6 +477,11 @@ func Init(config *Config, router *mux.Router, database *db.Database, email *emai
 	teamRouter.HandleFunc("/{teamId}/users", a.userOnly(a.teamUserOnly(a.handleGetTeamUsers()))).Methods("GET")
 	teamRouter.HandleFunc("/{teamId}/users", a.userOnly(a.teamAdminOnly(a.handleTeamAddUser()))).Methods("POST")
 	teamRouter.HandleFunc("/{teamId}/users/{userId}", a.userOnly(a.teamAdminOnly(a.handleTeamRemoveUser()))).Methods("DELETE")
+	teamRouter.HandleFunc("/{teamId}/members", a.userOnly(a.teamAdminOnly(a.handleGetTeamMembers()))).Methods("GET")
+	teamRouter.HandleFunc("/{teamId}/manifest", a.userOnly(a.teamAdminOnly(a.handleGetTeamManifest()))).Methods("GET")
+	teamRouter.HandleFunc("/{teamId}/members", a.userOnly(a.teamAdminOnly(a.handleAddTeamMember()))).Methods("POST")
+	teamRouter.HandleFunc("/{teamId}/members/{memberId}", a.userOnly(a.teamAdminOnly(a.handleUpdateTeamMemberRole()))).Methods("PUT")
+	teamRouter.HandleFunc("/{teamId}/members/{memberId}", a.userOnly(a.teamAdminOnly(a.handleRemoveTeamMember()))).Methods("DELETE")
 	teamRouter.HandleFunc("/{teamId}/checkins", a.userOnly(a.teamUserOnly(a.handleCheckinsGet()))).Methods("GET")
 	teamRouter.HandleFunc("/{teamId}/checkins", a.userOnly(a.teamUserOnly(a.handleCheckinCreate()))).Methods("POST")
 	teamRouter.HandleFunc("/{teamId}/checkins/{checkinId}", a.userOnly(a.teamUserOnly(a.handleCheckinUpdate()))).Methods("PUT")
@@ -209,16 +490,29 @@ func Init(config *Config, router *mux.Router, database *db.Database, email *emai
 	teamRouter.HandleFunc("/{teamId}/checkins/{checkinId}/comments/{commentId}", a.userOnly(a.teamUserOnly(a.handleCheckinCommentDelete()))).Methods("DELETE")
 	// admin
 	adminRouter.HandleFunc("/stats", a.userOnly(a.adminOnly(a.handleAppStats()))).Methods("GET")
-	adminRouter.HandleFunc("/users", a.userOnly(a.adminOnly(a.handleGetRegisteredUsers()))).Methods("GET")
+	adminRouter.HandleFunc("/instance-stats", a.userOnly(a.adminOnly(a.handleGetInstanceStats()))).Methods("GET")
+	adminRouter.HandleFunc("/users", a.userOnly(a.adminOnly(a.handleGetUsers()))).Methods("GET")
 	adminRouter.HandleFunc("/users", a.userOnly(a.adminOnly(a.handleUserCreate()))).Methods("POST")
 	adminRouter.HandleFunc("/users/{userId}/promote", a.userOnly(a.adminOnly(a.handleUserPromote()))).Methods("PATCH")
 	adminRouter.HandleFunc("/users/{userId}/demote", a.userOnly(a.adminOnly(a.handleUserDemote()))).Methods("PATCH")
 	adminRouter.HandleFunc("/users/{userId}/disable", a.userOnly(a.adminOnly(a.handleUserDisable()))).Methods("PATCH")
 	adminRouter.HandleFunc("/users/{userId}/enable", a.userOnly(a.adminOnly(a.handleUserEnable()))).Methods("PATCH")
+	adminRouter.HandleFunc("/users/{userId}/restore", a.userOnly(a.adminOnly(a.handleRestoreUser()))).Methods("PATCH")
+	adminRouter.HandleFunc("/users/{userId}/storage-quota", a.userOnly(a.adminOnly(a.handleUserSetStorageQuota()))).Methods("PUT")
+	adminRouter.HandleFunc("/cookie-keys", a.userOnly(a.adminOnly(a.handleGetCookieKeys()))).Methods("GET")
+	adminRouter.HandleFunc("/cookie-keys/rotate", a.userOnly(a.adminOnly(a.handleRotateCookieKey()))).Methods("POST")
+	adminRouter.HandleFunc("/storage", a.userOnly(a.adminOnly(a.handleGetStorageUsage()))).Methods("GET")
 	adminRouter.HandleFunc("/users/{userId}/password", a.userOnly(a.adminOnly(a.handleAdminUpdateUserPassword()))).Methods("PATCH")
 	adminRouter.HandleFunc("/organizations", a.userOnly(a.adminOnly(a.handleGetOrganizations()))).Methods("GET")
+	adminRouter.HandleFunc("/organizations/{orgId}/plan-limit", a.userOnly(a.adminOnly(a.handleOrganizationSetPlanLimit()))).Methods("PUT")
 	adminRouter.HandleFunc("/teams", a.userOnly(a.adminOnly(a.handleGetTeams()))).Methods("GET")
+	adminRouter.HandleFunc("/teams/{teamId}/plan-limit", a.userOnly(a.adminOnly(a.handleTeamSetPlanLimit()))).Methods("PUT")
+	adminRouter.HandleFunc("/teams/{teamId}/digest", a.userOnly(a.adminOnly(a.handleSendTeamDigest()))).Methods("POST")
 	adminRouter.HandleFunc("/apikeys", a.userOnly(a.adminOnly(a.handleGetAPIKeys()))).Methods("GET")
+	adminRouter.HandleFunc("/feature-flags", a.userOnly(a.adminOnly(a.handleGetFeatureFlags()))).Methods("GET")
+	adminRouter.HandleFunc("/feature-flags", a.userOnly(a.adminOnly(a.handleFeatureFlagCreate()))).Methods("POST")
+	adminRouter.HandleFunc("/feature-flags/{flagId}", a.userOnly(a.adminOnly(a.handleFeatureFlagUpdate()))).Methods("PUT")
+	adminRouter.HandleFunc("/feature-flags/{flagId}", a.userOnly(a.adminOnly(a.handleFeatureFlagDelete()))).Methods("DELETE")
 	adminRouter.HandleFunc("/search/users/email", a.userOnly(a.adminOnly(a.handleSearchRegisteredUsersByEmail()))).Methods("GET")
 	// alert
 	apiRouter.HandleFunc("/alerts", a.userOnly(a.adminOnly(a.handleGetAlerts()))).Methods("GET")
@@ -227,42 +521,66 @@ func Init(config *Config, router *mux.Router, database *db.Database, email *emai
 	apiRouter.HandleFunc("/alerts/{alertId}", a.userOnly(a.adminOnly(a.handleAlertDelete()))).Methods("DELETE")
 	// maintenance
 	apiRouter.HandleFunc("/maintenance/clean-guests", a.userOnly(a.adminOnly(a.handleCleanGuests()))).Methods("DELETE")
+	apiRouter.HandleFunc("/maintenance/clean-deleted-emails", a.userOnly(a.adminOnly(a.handleCleanDeletedUserEmails()))).Methods("DELETE")
 	apiRouter.HandleFunc("/maintenance/lowercase-emails", a.userOnly(a.adminOnly(a.handleLowercaseUserEmails()))).Methods("PATCH")
 	// battle(s)
 	if a.config.FeaturePoker {
-		userRouter.HandleFunc("/{userId}/battles", a.userOnly(a.entityUserOnly(a.handleBattleCreate()))).Methods("POST")
+		apiRouter.HandleFunc("/quick-battles", a.quickBattleOnly(a.guestCapabilityOnly(guestCanCreateBattle, a.createCooldownOnly(a.handleQuickBattleCreate())))).Methods("POST")
+		userRouter.HandleFunc("/{userId}/battles", a.userOnly(a.entityUserOnly(a.guestCapabilityOnly(guestCanCreateBattle, a.createCooldownOnly(a.handleBattleCreate()))))).Methods("POST")
 		userRouter.HandleFunc("/{userId}/battles", a.userOnly(a.entityUserOnly(a.handleGetUserBattles()))).Methods("GET")
+		userRouter.HandleFunc("/{userId}/battles/{battleId}/tags", a.userOnly(a.entityUserOnly(a.handleAddBattleTag()))).Methods("POST")
+		userRouter.HandleFunc("/{userId}/battles/{battleId}/tags/{tag}", a.userOnly(a.entityUserOnly(a.handleRemoveBattleTag()))).Methods("DELETE")
 		orgRouter.HandleFunc("/{orgId}/departments/{departmentId}/teams/{teamId}/battles", a.userOnly(a.departmentTeamUserOnly(a.handleGetTeamBattles()))).Methods("GET")
 		orgRouter.HandleFunc("/{orgId}/departments/{departmentId}/teams/{teamId}/battles/{battleId}", a.userOnly(a.departmentTeamAdminOnly(a.handleTeamRemoveBattle()))).Methods("DELETE")
-		orgRouter.HandleFunc("/{orgId}/departments/{departmentId}/teams/{teamId}/users/{userId}/battles", a.userOnly(a.departmentTeamUserOnly(a.handleBattleCreate()))).Methods("POST")
+		orgRouter.HandleFunc("/{orgId}/departments/{departmentId}/teams/{teamId}/users/{userId}/battles", a.userOnly(a.departmentTeamUserOnly(a.guestCapabilityOnly(guestCanCreateBattle, a.createCooldownOnly(a.handleBattleCreate()))))).Methods("POST")
 		orgRouter.HandleFunc("/{orgId}/teams/{teamId}/battles", a.userOnly(a.orgTeamOnly(a.handleGetTeamBattles()))).Methods("GET")
 		orgRouter.HandleFunc("/{orgId}/teams/{teamId}/battles/{battleId}", a.userOnly(a.orgTeamAdminOnly(a.handleTeamRemoveBattle()))).Methods("DELETE")
-		orgRouter.HandleFunc("/{orgId}/teams/{teamId}/users/{userId}/battles", a.userOnly(a.orgTeamOnly(a.handleBattleCreate()))).Methods("POST")
+		orgRouter.HandleFunc("/{orgId}/teams/{teamId}/users/{userId}/battles", a.userOnly(a.orgTeamOnly(a.guestCapabilityOnly(guestCanCreateBattle, a.createCooldownOnly(a.handleBattleCreate()))))).Methods("POST")
 		teamRouter.HandleFunc("/{teamId}/battles", a.userOnly(a.teamUserOnly(a.handleGetTeamBattles()))).Methods("GET")
 		teamRouter.HandleFunc("/{teamId}/battles/{battleId}", a.userOnly(a.teamAdminOnly(a.handleTeamRemoveBattle()))).Methods("DELETE")
-		teamRouter.HandleFunc("/{teamId}/users/{userId}/battles", a.userOnly(a.teamUserOnly(a.handleBattleCreate()))).Methods("POST")
+		teamRouter.HandleFunc("/{teamId}/battle-states", a.userOnly(a.teamUserOnly(a.handleGetTeamBattleStates()))).Methods("GET")
+		teamRouter.HandleFunc("/{teamId}/battle-states", a.userOnly(a.teamAdminOnly(a.handleUpdateTeamBattleStates()))).Methods("PUT")
+		teamRouter.HandleFunc("/{teamId}/estimation-accuracy", a.userOnly(a.teamUserOnly(a.handleGetTeamEstimationAccuracy()))).Methods("GET")
+		teamRouter.HandleFunc("/{teamId}/estimation-policy", a.userOnly(a.teamUserOnly(a.handleGetTeamEstimationPolicy()))).Methods("GET")
+		teamRouter.HandleFunc("/{teamId}/estimation-policy", a.userOnly(a.teamAdminOnly(a.handleUpdateTeamEstimationPolicy()))).Methods("PUT")
+		teamRouter.HandleFunc("/{teamId}/users/{userId}/battles", a.userOnly(a.teamUserOnly(a.guestCapabilityOnly(guestCanCreateBattle, a.createCooldownOnly(a.handleBattleCreate()))))).Methods("POST")
 		apiRouter.HandleFunc("/maintenance/clean-battles", a.userOnly(a.adminOnly(a.handleCleanBattles()))).Methods("DELETE")
+		apiRouter.HandleFunc("/maintenance/close-stale-battles", a.userOnly(a.adminOnly(a.handleBulkCloseStaleBattles(b)))).Methods("POST")
 		apiRouter.HandleFunc("/battles", a.userOnly(a.adminOnly(a.handleGetBattles()))).Methods("GET")
+		apiRouter.HandleFunc("/battles/code/{code}", a.userOnly(a.handleResolveBattleCode())).Methods("GET")
 		apiRouter.HandleFunc("/battles/{battleId}", a.userOnly(a.handleGetBattle())).Methods("GET")
 		apiRouter.HandleFunc("/battles/{battleId}/plans", a.userOnly(a.handleBattlePlanAdd(b))).Methods("POST")
+		apiRouter.HandleFunc("/battles/{battleId}/plans/{planId}/actual", a.userOnly(a.handleBattlePlanActual(b))).Methods("PUT")
+		if a.config.AllowJiraImport {
+			apiRouter.HandleFunc("/battles/{battleId}/plans/jira", a.userOnly(a.handleBattleImportJira(b))).Methods("POST")
+			userRouter.HandleFunc("/{userId}/jira-instance", a.userOnly(a.entityUserOnly(a.handleGetJiraInstance()))).Methods("GET")
+			userRouter.HandleFunc("/{userId}/jira-instance", a.userOnly(a.entityUserOnly(a.handleSaveJiraInstance()))).Methods("PUT")
+			userRouter.HandleFunc("/{userId}/jira-instance", a.userOnly(a.entityUserOnly(a.handleDeleteJiraInstance()))).Methods("DELETE")
+			teamRouter.HandleFunc("/{teamId}/jira-instance", a.userOnly(a.teamUserOnly(a.handleGetJiraInstance()))).Methods("GET")
+			teamRouter.HandleFunc("/{teamId}/jira-instance", a.userOnly(a.teamAdminOnly(a.handleSaveJiraInstance()))).Methods("PUT")
+			teamRouter.HandleFunc("/{teamId}/jira-instance", a.userOnly(a.teamAdminOnly(a.handleDeleteJiraInstance()))).Methods("DELETE")
+		}
+		apiRouter.HandleFunc("/battles/{battleId}/reopen", a.userOnly(a.handleReopenBattle(b))).Methods("POST")
+		apiRouter.HandleFunc("/battles/{battleId}/replay", a.userOnly(a.handleReplayBattle())).Methods("GET")
+		apiRouter.HandleFunc("/battles/{battleId}/export", a.userOnly(a.handleBattleExport())).Methods("GET")
 		apiRouter.HandleFunc("/arena/{battleId}", b.ServeBattleWs())
 	}
 	// retro(s)
 	if a.config.FeatureRetro {
-		userRouter.HandleFunc("/{userId}/retros", a.userOnly(a.entityUserOnly(a.handleRetroCreate()))).Methods("POST")
+		userRouter.HandleFunc("/{userId}/retros", a.userOnly(a.entityUserOnly(a.guestCapabilityOnly(guestCanCreateRetro, a.createCooldownOnly(a.handleRetroCreate()))))).Methods("POST")
 		userRouter.HandleFunc("/{userId}/retros", a.userOnly(a.entityUserOnly(a.handleRetrosGetByUser()))).Methods("GET")
 		orgRouter.HandleFunc("/{orgId}/departments/{departmentId}/teams/{teamId}/retros", a.userOnly(a.departmentTeamUserOnly(a.handleGetTeamRetros()))).Methods("GET")
 		orgRouter.HandleFunc("/{orgId}/departments/{departmentId}/teams/{teamId}/retros/{retroId}", a.userOnly(a.departmentTeamAdminOnly(a.handleTeamRemoveRetro()))).Methods("DELETE")
 		orgRouter.HandleFunc("/{orgId}/departments/{departmentId}/teams/{teamId}/retro-actions", a.userOnly(a.departmentTeamUserOnly(a.handleGetTeamRetroActions()))).Methods("GET")
-		orgRouter.HandleFunc("/{orgId}/departments/{departmentId}/teams/{teamId}/users/{userId}/retros", a.userOnly(a.departmentTeamUserOnly(a.handleRetroCreate()))).Methods("POST")
+		orgRouter.HandleFunc("/{orgId}/departments/{departmentId}/teams/{teamId}/users/{userId}/retros", a.userOnly(a.departmentTeamUserOnly(a.guestCapabilityOnly(guestCanCreateRetro, a.createCooldownOnly(a.handleRetroCreate()))))).Methods("POST")
 		orgRouter.HandleFunc("/{orgId}/teams/{teamId}/retros", a.userOnly(a.orgTeamOnly(a.handleGetTeamRetros()))).Methods("GET")
 		orgRouter.HandleFunc("/{orgId}/teams/{teamId}/retro-actions", a.userOnly(a.orgTeamOnly(a.handleGetTeamRetroActions()))).Methods("GET")
 		orgRouter.HandleFunc("/{orgId}/teams/{teamId}/retros/{retroId}", a.userOnly(a.orgTeamAdminOnly(a.handleTeamRemoveRetro()))).Methods("DELETE")
-		orgRouter.HandleFunc("/{orgId}/teams/{teamId}/users/{userId}/retros", a.userOnly(a.orgTeamOnly(a.handleRetroCreate()))).Methods("POST")
+		orgRouter.HandleFunc("/{orgId}/teams/{teamId}/users/{userId}/retros", a.userOnly(a.orgTeamOnly(a.guestCapabilityOnly(guestCanCreateRetro, a.createCooldownOnly(a.handleRetroCreate()))))).Methods("POST")
 		teamRouter.HandleFunc("/{teamId}/retros", a.userOnly(a.teamUserOnly(a.handleGetTeamRetros()))).Methods("GET")
 		teamRouter.HandleFunc("/{teamId}/retros/{retroId}", a.userOnly(a.teamAdminOnly(a.handleTeamRemoveRetro()))).Methods("DELETE")
 		teamRouter.HandleFunc("/{teamId}/retro-actions", a.userOnly(a.teamUserOnly(a.handleGetTeamRetroActions()))).Methods("GET")
-		teamRouter.HandleFunc("/{teamId}/users/{userId}/retros", a.userOnly(a.teamUserOnly(a.handleRetroCreate()))).Methods("POST")
+		teamRouter.HandleFunc("/{teamId}/users/{userId}/retros", a.userOnly(a.teamUserOnly(a.guestCapabilityOnly(guestCanCreateRetro, a.createCooldownOnly(a.handleRetroCreate()))))).Methods("POST")
 		apiRouter.HandleFunc("/maintenance/clean-retros", a.userOnly(a.adminOnly(a.handleCleanRetros()))).Methods("DELETE")
 		apiRouter.HandleFunc("/retros", a.userOnly(a.adminOnly(a.handleGetRetros()))).Methods("GET")
 		apiRouter.HandleFunc("/retros/{retroId}", a.userOnly(a.handleRetroGet())).Methods("GET")
@@ -271,22 +589,45 @@ func Init(config *Config, router *mux.Router, database *db.Database, email *emai
 	}
 	// storyboard(s)
 	if a.config.FeatureRetro {
-		userRouter.HandleFunc("/{userId}/storyboards", a.userOnly(a.entityUserOnly(a.handleStoryboardCreate()))).Methods("POST")
+		userRouter.HandleFunc("/{userId}/storyboards", a.userOnly(a.entityUserOnly(a.guestCapabilityOnly(guestCanCreateStoryboard, a.createCooldownOnly(a.handleStoryboardCreate()))))).Methods("POST")
+		userRouter.HandleFunc("/{userId}/storyboards/import", a.userOnly(a.entityUserOnly(a.guestCapabilityOnly(guestCanCreateStoryboard, a.createCooldownOnly(a.handleStoryboardImport()))))).Methods("POST")
 		userRouter.HandleFunc("/{userId}/storyboards", a.userOnly(a.entityUserOnly(a.handleGetUserStoryboards()))).Methods("GET")
+		userRouter.HandleFunc("/{userId}/storyboard-templates", a.userOnly(a.entityUserOnly(a.handleListStoryboardTemplates()))).Methods("GET")
+		userRouter.HandleFunc("/{userId}/storyboard-templates", a.userOnly(a.entityUserOnly(a.handleCreateStoryboardTemplate()))).Methods("POST")
+		userRouter.HandleFunc("/{userId}/storyboards/{storyboardId}/tags", a.userOnly(a.entityUserOnly(a.handleAddStoryboardTag()))).Methods("POST")
+		userRouter.HandleFunc("/{userId}/storyboards/{storyboardId}/tags/{tag}", a.userOnly(a.entityUserOnly(a.handleRemoveStoryboardTag()))).Methods("DELETE")
 		orgRouter.HandleFunc("/{orgId}/departments/{departmentId}/teams/{teamId}/storyboards", a.userOnly(a.departmentTeamUserOnly(a.handleGetTeamStoryboards()))).Methods("GET")
 		orgRouter.HandleFunc("/{orgId}/departments/{departmentId}/teams/{teamId}/storyboards/{storyboardId}", a.userOnly(a.departmentTeamAdminOnly(a.handleTeamRemoveStoryboard()))).Methods("DELETE")
-		orgRouter.HandleFunc("/{orgId}/departments/{departmentId}/teams/{teamId}/users/{userId}/storyboards", a.userOnly(a.departmentTeamUserOnly(a.handleStoryboardCreate()))).Methods("POST")
+		orgRouter.HandleFunc("/{orgId}/departments/{departmentId}/teams/{teamId}/users/{userId}/storyboards", a.userOnly(a.departmentTeamUserOnly(a.guestCapabilityOnly(guestCanCreateStoryboard, a.createCooldownOnly(a.handleStoryboardCreate()))))).Methods("POST")
 		orgRouter.HandleFunc("/{orgId}/teams/{teamId}/storyboards", a.userOnly(a.orgTeamOnly(a.handleGetTeamStoryboards()))).Methods("GET")
 		orgRouter.HandleFunc("/{orgId}/teams/{teamId}/storyboards/{storyboardId}", a.userOnly(a.orgTeamAdminOnly(a.handleTeamRemoveStoryboard()))).Methods("DELETE")
-		orgRouter.HandleFunc("/{orgId}/teams/{teamId}/users/{userId}/storyboards", a.userOnly(a.orgTeamOnly(a.handleStoryboardCreate()))).Methods("POST")
+		orgRouter.HandleFunc("/{orgId}/teams/{teamId}/users/{userId}/storyboards", a.userOnly(a.orgTeamOnly(a.guestCapabilityOnly(guestCanCreateStoryboard, a.createCooldownOnly(a.handleStoryboardCreate()))))).Methods("POST")
 		teamRouter.HandleFunc("/{teamId}/storyboards", a.userOnly(a.teamUserOnly(a.handleGetTeamStoryboards()))).Methods("GET")
+		teamRouter.HandleFunc("/{teamId}/storyboard-templates", a.userOnly(a.teamAdminOnly(a.handleCreateStoryboardTemplate()))).Methods("POST")
 		teamRouter.HandleFunc("/{teamId}/storyboards/{storyboardId}", a.userOnly(a.teamAdminOnly(a.handleTeamRemoveStoryboard()))).Methods("DELETE")
-		teamRouter.HandleFunc("/{teamId}/users/{userId}/storyboards", a.userOnly(a.teamUserOnly(a.handleStoryboardCreate()))).Methods("POST")
+		teamRouter.HandleFunc("/{teamId}/users/{userId}/storyboards", a.userOnly(a.teamUserOnly(a.guestCapabilityOnly(guestCanCreateStoryboard, a.createCooldownOnly(a.handleStoryboardCreate()))))).Methods("POST")
 		apiRouter.HandleFunc("/maintenance/clean-storyboards", a.userOnly(a.adminOnly(a.handleCleanStoryboards()))).Methods("DELETE")
 		apiRouter.HandleFunc("/storyboards", a.userOnly(a.adminOnly(a.handleGetStoryboards()))).Methods("GET")
 		apiRouter.HandleFunc("/storyboards/{storyboardId}", a.userOnly(a.handleStoryboardGet())).Methods("GET")
+		apiRouter.HandleFunc("/storyboards/{storyboardId}/export", a.userOnly(a.handleStoryboardExport())).Methods("GET")
+		apiRouter.HandleFunc("/storyboards/{storyboardId}/snapshots", a.userOnly(a.handleGetStoryboardSnapshots())).Methods("GET")
+		apiRouter.HandleFunc("/storyboards/{storyboardId}/snapshots/{snapshotId}", a.userOnly(a.handleGetStoryboardSnapshot())).Methods("GET")
+		apiRouter.HandleFunc("/storyboards/{storyboardId}/history", a.userOnly(a.handleGetStoryboardHistory())).Methods("GET")
 		apiRouter.HandleFunc("/storyboard/{storyboardId}", sb.ServeWs())
 	}
 
+	// periodically demote inactive team admins when the policy is enabled
+	if a.config.DemoteInactiveTeamAdminsDays > 0 {
+		go a.inactiveTeamAdminSweeper(time.Hour)
+	}
+
+	if a.config.TeamDigestEnabled {
+		go a.teamDigestScheduler()
+	}
+
+	if a.config.FeatureStoryboard && a.config.StoryboardSnapshotInterval > 0 {
+		go a.storyboardSnapshotScheduler(time.Duration(a.config.StoryboardSnapshotInterval) * time.Minute)
+	}
+
 	return a
 }
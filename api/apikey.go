@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 )
@@ -35,17 +36,19 @@ func (a *api) handleUserAPIKeys() http.HandlerFunc {
 }
 
 type apikeyGenerateRequestBody struct {
-	Name string `json:"name"`
+	Name       string     `json:"name"`
+	ExpireDate *time.Time `json:"expireDate,omitempty"`
 }
 
 // handleAPIKeyGenerate handles generating an API key for a user
 // @Summary Generate API Key
-// @Description Generates an API key for the user
+// @Description Generates an API key for the user, the key is only returned this once and optionally expires at expireDate
 // @Tags apikey
 // @Produce  json
 // @Param userId path string true "the user ID to generate API key for"
 // @Param key body apikeyGenerateRequestBody true "new api key object"
 // @Success 200 object standardJsonResponse{data=model.APIKey}
+// @Failure 400 object standardJsonResponse{}
 // @Failure 403 object standardJsonResponse{}
 // @Failure 500 object standardJsonResponse{}
 // @Security ApiKeyAuth
@@ -79,8 +82,12 @@ func (a *api) handleAPIKeyGenerate() http.HandlerFunc {
 			return
 		}
 
-		APIKey, keyErr := a.db.GenerateApiKey(UserID, k.Name)
+		APIKey, keyErr := a.db.GenerateApiKey(UserID, k.Name, k.ExpireDate)
 		if keyErr != nil {
+			if keyErr.Error() == "INVALID_APIKEY_EXPIRY" {
+				a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, keyErr.Error()))
+				return
+			}
 			a.Failure(w, r, http.StatusInternalServerError, keyErr)
 			return
 		}
@@ -162,3 +169,164 @@ func (a *api) handleUserAPIKeyDelete() http.HandlerFunc {
 		a.Success(w, r, http.StatusOK, APIKeys, nil)
 	}
 }
+
+// handleTeamAPIKeys handles getting team API keys
+// @Summary Get Team API Keys
+// @Description get list of service API keys for the team
+// @Tags team
+// @Produce  json
+// @Param teamId path string true "the team ID to get API keys for"
+// @Success 200 object standardJsonResponse{data=[]model.TeamAPIKey}
+// @Failure 403 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /teams/{teamId}/apikeys [get]
+func (a *api) handleTeamAPIKeys() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		TeamID := vars["teamId"]
+
+		APIKeys, keysErr := a.db.GetTeamAPIKeys(TeamID)
+		if keysErr != nil {
+			a.Failure(w, r, http.StatusInternalServerError, keysErr)
+			return
+		}
+
+		a.Success(w, r, http.StatusOK, APIKeys, nil)
+	}
+}
+
+type teamAPIKeyGenerateRequestBody struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes" enums:"read,write,admin"`
+}
+
+// handleTeamAPIKeyGenerate handles generating a service API key for a team
+// @Summary Generate Team API Key
+// @Description Generates a service API key for the team with access to only the team's resources
+// @Tags team
+// @Produce  json
+// @Param teamId path string true "the team ID to generate API key for"
+// @Param key body teamAPIKeyGenerateRequestBody true "new team api key object"
+// @Success 200 object standardJsonResponse{data=model.TeamAPIKey}
+// @Failure 400 object standardJsonResponse{}
+// @Failure 403 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /teams/{teamId}/apikeys [post]
+func (a *api) handleTeamAPIKeyGenerate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		TeamID := vars["teamId"]
+		UserID := r.Context().Value(contextKeyUserID).(string)
+		UserType := r.Context().Value(contextKeyUserType).(string)
+
+		// team api keys can't be used to generate more team api keys
+		if UserType == teamAPIKeyUserType {
+			a.Failure(w, r, http.StatusForbidden, Errorf(EUNAUTHORIZED, "REQUIRES_USER"))
+			return
+		}
+
+		body, bodyErr := ioutil.ReadAll(r.Body)
+		if bodyErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, bodyErr.Error()))
+			return
+		}
+
+		var k = teamAPIKeyGenerateRequestBody{}
+		jsonErr := json.Unmarshal(body, &k)
+		if jsonErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, jsonErr.Error()))
+			return
+		}
+
+		if len(k.Scopes) == 0 {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, "INVALID_APIKEY_SCOPES"))
+			return
+		}
+		for _, scope := range k.Scopes {
+			if scope != "read" && scope != "write" && scope != "admin" {
+				a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, "INVALID_APIKEY_SCOPES"))
+				return
+			}
+		}
+
+		APIKey, keyErr := a.db.CreateTeamAPIKey(TeamID, UserID, k.Name, k.Scopes)
+		if keyErr != nil {
+			a.Failure(w, r, http.StatusInternalServerError, keyErr)
+			return
+		}
+
+		a.Success(w, r, http.StatusOK, APIKey, nil)
+	}
+}
+
+// handleTeamAPIKeyUpdate handles updating a team API key
+// @Summary Update Team API Key
+// @Description Updates the team API key, e.g. revoking by setting active false
+// @Tags team
+// @Produce  json
+// @Param teamId path string true "the team ID"
+// @Param keyID path string true "the API Key ID to update"
+// @Param key body apikeyUpdateRequestBody true "api key object to update"
+// @Success 200 object standardJsonResponse{data=[]model.TeamAPIKey}
+// @Failure 403 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /teams/{teamId}/apikeys/{keyID} [put]
+func (a *api) handleTeamAPIKeyUpdate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		TeamID := vars["teamId"]
+		APK := vars["keyID"]
+
+		body, bodyErr := ioutil.ReadAll(r.Body)
+		if bodyErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, bodyErr.Error()))
+			return
+		}
+
+		var k = apikeyUpdateRequestBody{}
+		jsonErr := json.Unmarshal(body, &k)
+		if jsonErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, jsonErr.Error()))
+			return
+		}
+
+		APIKeys, keysErr := a.db.UpdateTeamAPIKey(TeamID, APK, k.Active)
+		if keysErr != nil {
+			a.Failure(w, r, http.StatusInternalServerError, keysErr)
+			return
+		}
+
+		a.Success(w, r, http.StatusOK, APIKeys, nil)
+	}
+}
+
+// handleTeamAPIKeyDelete handles deleting a team API key
+// @Summary Delete Team API Key
+// @Description Deletes the team API key
+// @Tags team
+// @Produce  json
+// @Param teamId path string true "the team ID"
+// @Param keyID path string true "the API Key ID to delete"
+// @Success 200 object standardJsonResponse{data=[]model.TeamAPIKey}
+// @Failure 403 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /teams/{teamId}/apikeys/{keyID} [delete]
+func (a *api) handleTeamAPIKeyDelete() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		TeamID := vars["teamId"]
+		APK := vars["keyID"]
+
+		APIKeys, keysErr := a.db.DeleteTeamAPIKey(TeamID, APK)
+		if keysErr != nil {
+			a.Failure(w, r, http.StatusInternalServerError, keysErr)
+			return
+		}
+
+		a.Success(w, r, http.StatusOK, APIKeys, nil)
+	}
+}
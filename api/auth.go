@@ -5,8 +5,11 @@ import (
 	"io/ioutil"
 	"net/http"
 	"strings"
+	"time"
 
+	"github.com/gorilla/mux"
 	"github.com/spf13/viper"
+	"go.uber.org/zap"
 )
 
 type userLoginRequestBody struct {
@@ -14,15 +17,29 @@ type userLoginRequestBody struct {
 	Password string `json:"password"`
 }
 
+type mfaLoginResponse struct {
+	MFARequired bool   `json:"mfaRequired"`
+	MFAToken    string `json:"mfaToken"`
+}
+
+type mfaLoginRequestBody struct {
+	MFAToken string `json:"mfaToken"`
+	// Passcode the 6 digit authenticator code or a recovery code
+	Passcode string `json:"passcode"`
+}
+
 // handleLogin attempts to log in the user
 // @Summary Login
 // @Description attempts to log the user in with provided credentials
+// @Description when the user has MFA enabled a pre-auth token is returned instead to complete login with /auth/mfa
 // @Description *Endpoint only available when LDAP is not enabled
 // @Tags auth
 // @Produce  json
 // @Param credentials body userLoginRequestBody false "user login object"
 // @Success 200 object standardJsonResponse{data=model.User}
+// @Success 202 object standardJsonResponse{data=mfaLoginResponse}
 // @Failure 401 object standardJsonResponse{}
+// @Failure 429 object standardJsonResponse{}
 // @Failure 500 object standardJsonResponse{}
 // @Router /auth [post]
 func (a *api) handleLogin() http.HandlerFunc {
@@ -40,13 +57,81 @@ func (a *api) handleLogin() http.HandlerFunc {
 			return
 		}
 
-		authedUser, sessionId, err := a.db.AuthUser(strings.ToLower(u.Email), u.Password)
+		UserEmail := strings.ToLower(u.Email)
+		if a.loginLockedOut(r, UserEmail) {
+			a.Failure(w, r, http.StatusTooManyRequests, Errorf(EINVALID, "TOO_MANY_ATTEMPTS"))
+			return
+		}
+
+		authedUser, sessionId, err := a.db.AuthUser(UserEmail, u.Password)
+		if err != nil {
+			if err.Error() == "MFA_LOCKED" {
+				a.Failure(w, r, http.StatusTooManyRequests, Errorf(EINVALID, "TOO_MANY_ATTEMPTS"))
+				return
+			}
+			a.recordLoginAttempt(r, UserEmail, false)
+			a.Failure(w, r, http.StatusUnauthorized, loginFailure(err))
+			return
+		}
+
+		// no session until the MFA passcode is verified, the failed logins are only reset once it is
+		if authedUser.MFAEnabled {
+			a.Success(w, r, http.StatusAccepted, mfaLoginResponse{MFARequired: true, MFAToken: sessionId}, nil)
+			return
+		}
+		a.recordLoginAttempt(r, UserEmail, true)
+		a.enforceSessionLimit(authedUser)
+
+		cookieErr := a.rotateSession(w, r, sessionId)
+		if cookieErr != nil {
+			a.Failure(w, r, http.StatusInternalServerError, Errorf(EINVALID, "INVALID_COOKIE"))
+			return
+		}
+
+		a.Success(w, r, http.StatusOK, authedUser, nil)
+	}
+}
+
+// handleLoginVerifyMFA completes login of a user with MFA enabled by verifying their passcode
+// @Summary Verify Login MFA
+// @Description completes login by verifying the authenticator passcode (or a single use recovery code) for the pre-auth token from /auth
+// @Description *Endpoint only available when LDAP is not enabled
+// @Tags auth
+// @Produce  json
+// @Param mfa body mfaLoginRequestBody true "pre-auth token and passcode"
+// @Success 200 object standardJsonResponse{data=model.User}
+// @Failure 401 object standardJsonResponse{}
+// @Failure 429 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Router /auth/mfa [post]
+func (a *api) handleLoginVerifyMFA() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, bodyErr := ioutil.ReadAll(r.Body)
+		if bodyErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, bodyErr.Error()))
+			return
+		}
+
+		var u = mfaLoginRequestBody{}
+		jsonErr := json.Unmarshal(body, &u)
+		if jsonErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, jsonErr.Error()))
+			return
+		}
+
+		authedUser, sessionId, err := a.db.ValidateMFA(u.MFAToken, u.Passcode)
 		if err != nil {
+			if err.Error() == "MFA_LOCKED" {
+				a.Failure(w, r, http.StatusTooManyRequests, Errorf(EINVALID, "TOO_MANY_ATTEMPTS"))
+				return
+			}
 			a.Failure(w, r, http.StatusUnauthorized, Errorf(EINVALID, "INVALID_LOGIN"))
 			return
 		}
+		a.recordLoginAttempt(r, strings.ToLower(authedUser.Email), true)
+		a.enforceSessionLimit(authedUser)
 
-		cookieErr := a.createSessionCookie(w, sessionId)
+		cookieErr := a.rotateSession(w, r, sessionId)
 		if cookieErr != nil {
 			a.Failure(w, r, http.StatusInternalServerError, Errorf(EINVALID, "INVALID_COOKIE"))
 			return
@@ -66,6 +151,7 @@ func (a *api) handleLogin() http.HandlerFunc {
 // @Param credentials body userLoginRequestBody false "user login object"
 // @Success 200 object standardJsonResponse{data=model.User}
 // @Failure 401 object standardJsonResponse{}
+// @Failure 429 object standardJsonResponse{}
 // @Failure 500 object standardJsonResponse{}
 // @Router /auth/ldap [post]
 func (a *api) handleLdapLogin() http.HandlerFunc {
@@ -83,13 +169,22 @@ func (a *api) handleLdapLogin() http.HandlerFunc {
 			return
 		}
 
-		authedUser, sessionId, err := a.authAndCreateUserLdap(strings.ToLower(u.Email), u.Password)
+		UserName := strings.ToLower(u.Email)
+		if a.loginLockedOut(r, UserName) {
+			a.Failure(w, r, http.StatusTooManyRequests, Errorf(EINVALID, "TOO_MANY_ATTEMPTS"))
+			return
+		}
+
+		authedUser, sessionId, err := a.authAndCreateUserLdap(UserName, u.Password)
 		if err != nil {
-			a.Failure(w, r, http.StatusUnauthorized, Errorf(EINVALID, "INVALID_LOGIN"))
+			a.recordLoginAttempt(r, UserName, false)
+			a.Failure(w, r, http.StatusUnauthorized, loginFailure(err))
 			return
 		}
+		a.recordLoginAttempt(r, UserName, true)
+		a.enforceSessionLimit(authedUser)
 
-		cookieErr := a.createSessionCookie(w, sessionId)
+		cookieErr := a.rotateSession(w, r, sessionId)
 		if cookieErr != nil {
 			a.Failure(w, r, http.StatusInternalServerError, Errorf(EINVALID, "INVALID_COOKIE"))
 			return
@@ -99,6 +194,226 @@ func (a *api) handleLdapLogin() http.HandlerFunc {
 	}
 }
 
+// handleOAuth2Login redirects the user to the OpenID Connect providers login
+// @Summary OIDC Login
+// @Description Redirects to the OpenID Connect providers login, storing the state and nonce for the callback
+// @Tags auth
+// @Success 302
+// @Failure 500 object standardJsonResponse{}
+// @Router /auth/oidc [get]
+func (a *api) handleOAuth2Login() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		State, stateErr := randomOIDCValue()
+		Nonce, nonceErr := randomOIDCValue()
+		if stateErr != nil || nonceErr != nil {
+			a.Failure(w, r, http.StatusInternalServerError, Errorf(EINVALID, "OIDC_LOGIN_FAILED"))
+			return
+		}
+
+		AuthURL, err := a.oidc.AuthCodeURL(State, Nonce)
+		if err != nil {
+			a.logger.Error("error getting oidc authorization url", zap.Error(err))
+			a.Failure(w, r, http.StatusInternalServerError, Errorf(EINVALID, "OIDC_LOGIN_FAILED"))
+			return
+		}
+
+		if err := a.createOIDCStateCookie(w, oidcLoginPath, State, Nonce); err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, Errorf(EINVALID, "INVALID_COOKIE"))
+			return
+		}
+
+		http.Redirect(w, r, AuthURL, http.StatusFound)
+	}
+}
+
+// handleOAuth2Callback completes the OpenID Connect login creating the user if they don't exist
+// @Summary OIDC Login Callback
+// @Description Validates the state, exchanges the code and verifies the ID token, then creates the users session and redirects to the app
+// @Tags auth
+// @Param code query string true "the authorization code"
+// @Param state query string true "the state from the login redirect"
+// @Success 302
+// @Failure 401 object standardJsonResponse{}
+// @Router /auth/oidc/callback [get]
+func (a *api) handleOAuth2Callback() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		AuthState, stateErr := a.validateOIDCStateCookie(w, r, oidcLoginPath)
+		if stateErr != nil || AuthState.State != r.URL.Query().Get("state") {
+			a.Failure(w, r, http.StatusUnauthorized, Errorf(EINVALID, "INVALID_OIDC_STATE"))
+			return
+		}
+
+		Code := r.URL.Query().Get("code")
+		if Code == "" {
+			a.Failure(w, r, http.StatusUnauthorized, Errorf(EINVALID, "INVALID_LOGIN"))
+			return
+		}
+
+		IDToken, err := a.oidc.Exchange(Code)
+		if err != nil {
+			a.logger.Error("error exchanging oidc code", zap.Error(err))
+			a.Failure(w, r, http.StatusUnauthorized, Errorf(EINVALID, "INVALID_LOGIN"))
+			return
+		}
+
+		Claims, err := a.oidc.VerifyIDToken(IDToken, AuthState.Nonce, time.Now())
+		if err != nil {
+			a.logger.Error("error verifying oidc id token", zap.Error(err))
+			a.Failure(w, r, http.StatusUnauthorized, Errorf(EINVALID, "INVALID_LOGIN"))
+			return
+		}
+
+		authedUser, sessionId, err := a.authAndCreateUserOIDC(Claims)
+		if err != nil {
+			if err.Error() == "MFA_REQUIRED" {
+				a.Failure(w, r, http.StatusUnauthorized, Errorf(EINVALID, err.Error()))
+				return
+			}
+			a.Failure(w, r, http.StatusUnauthorized, loginFailure(err))
+			return
+		}
+		a.enforceSessionLimit(authedUser)
+
+		if err := a.rotateSession(w, r, sessionId); err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, Errorf(EINVALID, "INVALID_COOKIE"))
+			return
+		}
+		if err := a.createFrontendCookie(w, authedUser); err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, Errorf(EINVALID, "INVALID_COOKIE"))
+			return
+		}
+
+		http.Redirect(w, r, a.config.PathPrefix+"/", http.StatusFound)
+	}
+}
+
+// socialLogin redirects the user to the social login providers login
+func (a *api) socialLogin(Provider *socialProvider, LoginPath string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		State, stateErr := randomOIDCValue()
+		if stateErr != nil {
+			a.Failure(w, r, http.StatusInternalServerError, Errorf(EINVALID, "OAUTH2_LOGIN_FAILED"))
+			return
+		}
+
+		if err := a.createOIDCStateCookie(w, LoginPath, State, ""); err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, Errorf(EINVALID, "INVALID_COOKIE"))
+			return
+		}
+
+		http.Redirect(w, r, Provider.AuthCodeURL(State), http.StatusFound)
+	}
+}
+
+// socialCallback completes the social login creating the user if they don't exist
+func (a *api) socialCallback(Provider *socialProvider, LoginPath string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		AuthState, stateErr := a.validateOIDCStateCookie(w, r, LoginPath)
+		if stateErr != nil || AuthState.State != r.URL.Query().Get("state") {
+			a.Failure(w, r, http.StatusUnauthorized, Errorf(EINVALID, "INVALID_OAUTH2_STATE"))
+			return
+		}
+
+		Code := r.URL.Query().Get("code")
+		if Code == "" {
+			a.Failure(w, r, http.StatusUnauthorized, Errorf(EINVALID, "INVALID_LOGIN"))
+			return
+		}
+
+		AccessToken, err := Provider.Exchange(Code)
+		if err != nil {
+			a.logger.Error("error exchanging oauth2 code", zap.Error(err))
+			a.Failure(w, r, http.StatusUnauthorized, Errorf(EINVALID, "INVALID_LOGIN"))
+			return
+		}
+
+		Identity, err := Provider.identity(Provider, AccessToken)
+		if err != nil {
+			a.logger.Error("error getting oauth2 user identity", zap.Error(err))
+			a.Failure(w, r, http.StatusUnauthorized, Errorf(EINVALID, "INVALID_LOGIN"))
+			return
+		}
+		if Identity.Email == "" || !Identity.EmailVerified {
+			a.Failure(w, r, http.StatusUnauthorized, Errorf(EINVALID, "EMAIL_NOT_VERIFIED"))
+			return
+		}
+
+		authedUser, sessionId, err := a.authAndCreateUserSocial(Identity)
+		if err != nil {
+			if err.Error() == "MFA_REQUIRED" {
+				a.Failure(w, r, http.StatusUnauthorized, Errorf(EINVALID, err.Error()))
+				return
+			}
+			a.Failure(w, r, http.StatusUnauthorized, loginFailure(err))
+			return
+		}
+		a.enforceSessionLimit(authedUser)
+
+		if err := a.rotateSession(w, r, sessionId); err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, Errorf(EINVALID, "INVALID_COOKIE"))
+			return
+		}
+		if err := a.createFrontendCookie(w, authedUser); err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, Errorf(EINVALID, "INVALID_COOKIE"))
+			return
+		}
+
+		http.Redirect(w, r, a.config.PathPrefix+"/", http.StatusFound)
+	}
+}
+
+// handleGithubLogin redirects the user to the GitHub login
+// @Summary GitHub Login
+// @Description Redirects to the GitHub login, storing the state for the callback
+// @Description *Endpoint only available when GitHub login is enabled
+// @Tags auth
+// @Success 302
+// @Failure 500 object standardJsonResponse{}
+// @Router /auth/github [get]
+func (a *api) handleGithubLogin() http.HandlerFunc {
+	return a.socialLogin(a.github, githubLoginPath)
+}
+
+// handleGithubCallback completes the GitHub login creating the user if they don't exist
+// @Summary GitHub Login Callback
+// @Description Validates the state, exchanges the code and gets the users verified primary email, then creates the users session and redirects to the app
+// @Description *Endpoint only available when GitHub login is enabled
+// @Tags auth
+// @Param code query string true "the authorization code"
+// @Param state query string true "the state from the login redirect"
+// @Success 302
+// @Failure 401 object standardJsonResponse{}
+// @Router /auth/github/callback [get]
+func (a *api) handleGithubCallback() http.HandlerFunc {
+	return a.socialCallback(a.github, githubLoginPath)
+}
+
+// handleGoogleLogin redirects the user to the Google login
+// @Summary Google Login
+// @Description Redirects to the Google login, storing the state for the callback
+// @Description *Endpoint only available when Google login is enabled
+// @Tags auth
+// @Success 302
+// @Failure 500 object standardJsonResponse{}
+// @Router /auth/google [get]
+func (a *api) handleGoogleLogin() http.HandlerFunc {
+	return a.socialLogin(a.google, googleLoginPath)
+}
+
+// handleGoogleCallback completes the Google login creating the user if they don't exist
+// @Summary Google Login Callback
+// @Description Validates the state, exchanges the code and gets the users verified email, then creates the users session and redirects to the app
+// @Description *Endpoint only available when Google login is enabled
+// @Tags auth
+// @Param code query string true "the authorization code"
+// @Param state query string true "the state from the login redirect"
+// @Success 302
+// @Failure 401 object standardJsonResponse{}
+// @Router /auth/google/callback [get]
+func (a *api) handleGoogleCallback() http.HandlerFunc {
+	return a.socialCallback(a.google, googleLoginPath)
+}
+
 // handleLogout clears the user cookie(s) ending session
 // @Summary Logout
 // @Description Logs the user out by deleting session cookies
@@ -126,6 +441,8 @@ func (a *api) handleLogout() http.HandlerFunc {
 
 type guestUserCreateRequestBody struct {
 	Name string `json:"name"`
+	// CaptchaToken required when auth.captcha.enabled is enabled
+	CaptchaToken string `json:"captchaToken"`
 }
 
 // handleCreateGuestUser registers a user as a guest user
@@ -159,6 +476,15 @@ func (a *api) handleCreateGuestUser() http.HandlerFunc {
 			return
 		}
 
+		if !a.captchaGate(w, r, u.CaptchaToken) {
+			return
+		}
+
+		if nameErr := a.validateJoinName(u.Name); nameErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, nameErr.Error()))
+			return
+		}
+
 		newUser, err := a.db.CreateUserGuest(u.Name)
 		if err != nil {
 			a.Failure(w, r, http.StatusInternalServerError, err)
@@ -180,6 +506,8 @@ type userRegisterRequestBody struct {
 	Email     string `json:"email"`
 	Password1 string `json:"password1"`
 	Password2 string `json:"password2"`
+	// CaptchaToken required when auth.captcha.enabled is enabled
+	CaptchaToken string `json:"captchaToken"`
 }
 
 // handleUserRegistration registers a new authenticated user
@@ -212,6 +540,10 @@ func (a *api) handleUserRegistration() http.HandlerFunc {
 			return
 		}
 
+		if !a.captchaGate(w, r, u.CaptchaToken) {
+			return
+		}
+
 		ActiveUserID, _ := a.validateUserCookie(w, r)
 
 		UserName, UserEmail, UserPassword, accountErr := validateUserAccountWithPasswords(
@@ -219,10 +551,16 @@ func (a *api) handleUserRegistration() http.HandlerFunc {
 			strings.ToLower(u.Email),
 			u.Password1,
 			u.Password2,
+			a.passwordPolicy(),
 		)
 
 		if accountErr != nil {
-			a.Failure(w, r, http.StatusBadRequest, accountErr)
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, accountErr.Error()))
+			return
+		}
+
+		if err := a.db.CheckEmailAvailable(UserEmail, ""); err != nil {
+			a.emailUnavailableFailure(w, r, err)
 			return
 		}
 
@@ -238,7 +576,7 @@ func (a *api) handleUserRegistration() http.HandlerFunc {
 			a.clearUserCookies(w)
 		}
 
-		cookieErr := a.createSessionCookie(w, SessionID)
+		cookieErr := a.rotateSession(w, r, SessionID)
 		if cookieErr != nil {
 			a.Failure(w, r, http.StatusInternalServerError, Errorf(EINVALID, "INVALID_COOKIE"))
 			return
@@ -250,11 +588,13 @@ func (a *api) handleUserRegistration() http.HandlerFunc {
 
 type forgotPasswordRequestBody struct {
 	Email string `json:"email"`
+	// CaptchaToken required when auth.captcha.enabled is enabled
+	CaptchaToken string `json:"captchaToken"`
 }
 
 // handleForgotPassword attempts to send a password reset email
 // @Summary Forgot Password
-// @Description Sends a forgot password reset email to user
+// @Description Sends a forgot password reset email to user, or a verification email instead for unverified accounts when config.require_verified_password_reset is enabled
 // @Tags auth
 // @Produce json
 // @Param user body forgotPasswordRequestBody false "forgot password object"
@@ -277,6 +617,24 @@ func (a *api) handleForgotPassword() http.HandlerFunc {
 
 		UserEmail := strings.ToLower(u.Email)
 
+		// the token is only verified once as providers reject a reused token
+		if !a.captchaGate(w, r, u.CaptchaToken) {
+			return
+		}
+
+		// unverified accounts get a verification email instead of a reset link when required,
+		// the response is the same either way to avoid revealing account status
+		if a.config.RequireVerifiedPasswordReset {
+			User, userErr := a.db.GetUserByEmail(UserEmail)
+			if userErr == nil && !User.Verified {
+				if _, VerifyID, err := a.db.UserVerifyRequest(User.Id); err == nil {
+					a.email.SendEmailVerification(User.Name, User.Email, VerifyID)
+				}
+				a.Success(w, r, http.StatusOK, nil, nil)
+				return
+			}
+		}
+
 		ResetID, UserName, resetErr := a.db.UserResetRequest(UserEmail)
 		if resetErr == nil {
 			a.email.SendForgotPassword(UserName, UserEmail, ResetID)
@@ -292,6 +650,31 @@ type resetPasswordRequestBody struct {
 	Password2 string `json:"password2"`
 }
 
+// handleValidateResetToken checks the reset token is still valid without consuming it,
+// the token is only consumed on password submission
+// @Summary Validate Reset Token
+// @Description Checks the reset password token is still valid without consuming it
+// @Tags auth
+// @Produce json
+// @Param resetId path string true "the reset token ID"
+// @Success 200 object standardJsonResponse{}
+// @Success 400 object standardJsonResponse{}
+// @Success 500 object standardJsonResponse{}
+// @Router /auth/reset-password/{resetId} [get]
+func (a *api) handleValidateResetToken() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		if err := a.db.ValidateResetToken(vars["resetId"]); err != nil {
+			a.tokenFailure(w, r, err)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		a.Success(w, r, http.StatusOK, nil, nil)
+	}
+}
+
 // handleResetPassword attempts to reset a user's password
 // @Summary Reset Password
 // @Description Resets the user's password
@@ -320,16 +703,17 @@ func (a *api) handleResetPassword() http.HandlerFunc {
 		UserPassword, passwordErr := validateUserPassword(
 			u.Password1,
 			u.Password2,
+			a.passwordPolicy(),
 		)
 
 		if passwordErr != nil {
-			a.Failure(w, r, http.StatusBadRequest, passwordErr)
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, passwordErr.Error()))
 			return
 		}
 
 		UserName, UserEmail, resetErr := a.db.UserResetPassword(u.ResetID, UserPassword)
 		if resetErr != nil {
-			a.Failure(w, r, http.StatusInternalServerError, resetErr)
+			a.tokenFailure(w, r, resetErr)
 			return
 		}
 
@@ -374,21 +758,39 @@ func (a *api) handleUpdatePassword() http.HandlerFunc {
 		UserPassword, passwordErr := validateUserPassword(
 			u.Password1,
 			u.Password2,
+			a.passwordPolicy(),
 		)
 
 		if passwordErr != nil {
-			a.Failure(w, r, http.StatusBadRequest, passwordErr)
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, passwordErr.Error()))
 			return
 		}
 
 		UserName, UserEmail, updateErr := a.db.UserUpdatePassword(UserID, UserPassword)
 		if updateErr != nil {
+			if updateErr.Error() == "PASSWORD_RECENTLY_USED" {
+				a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, updateErr.Error()))
+				return
+			}
 			a.Failure(w, r, http.StatusInternalServerError, updateErr)
 			return
 		}
 
 		a.email.SendPasswordUpdate(UserName, UserEmail)
 
+		// api key requests have no session to rotate
+		if a.config.RotateSessionOnPrivilegeChange && a.requestSessionID(r) != "" {
+			SessionID, err := a.db.CreateSession(UserID)
+			if err != nil {
+				a.Failure(w, r, http.StatusInternalServerError, err)
+				return
+			}
+			if err := a.rotateSession(w, r, SessionID); err != nil {
+				a.Failure(w, r, http.StatusInternalServerError, Errorf(EINVALID, "INVALID_COOKIE"))
+				return
+			}
+		}
+
 		a.Success(w, r, http.StatusOK, nil, nil)
 	}
 }
@@ -423,7 +825,7 @@ func (a *api) handleAccountVerification() http.HandlerFunc {
 
 		verifyErr := a.db.VerifyUserAccount(u.VerifyID)
 		if verifyErr != nil {
-			a.Failure(w, r, http.StatusInternalServerError, verifyErr)
+			a.tokenFailure(w, r, verifyErr)
 			return
 		}
 
@@ -0,0 +1,222 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/sigv4"
+)
+
+// uploadedAvatarPath the path locally stored avatars are served from
+const uploadedAvatarPath = "/uploads/avatars/"
+
+// avatarFileNamePattern the names uploaded avatars are stored under, anything else is never read or deleted
+var avatarFileNamePattern = regexp.MustCompile(`^[0-9a-zA-Z-]+\.png$`)
+
+// avatarStore stores uploaded avatar images
+type avatarStore interface {
+	// Put stores the avatar returning the URL it's served from
+	Put(Name string, Data []byte) (string, error)
+	// Name gets the stored avatar name from the URL it's served from, empty when the URL isn't from the store
+	Name(URL string) string
+	// Delete removes the users avatar served from the URL returning the bytes freed, URLs not from the store
+	// e.g. avatar styles and avatars uploaded by other users are ignored
+	Delete(UserID string, URL string) (int64, error)
+}
+
+// avatarOwnedBy checks the stored avatar was uploaded by the user, names are prefixed with their ID
+func avatarOwnedBy(Name string, UserID string) bool {
+	return UserID != "" && strings.HasPrefix(Name, UserID+"-")
+}
+
+// newAvatarStore creates the store for the configured storage, nil when avatar uploads are disabled
+func newAvatarStore(config *Config, Client *http.Client) (avatarStore, error) {
+	if config.AvatarUploadStorage == "" {
+		return nil, nil
+	}
+	if config.AvatarUploadThumbnailSize <= 0 || config.AvatarUploadMaxSize <= 0 {
+		return nil, errors.New("avatar upload thumbnail_size and max_size_kb must be positive")
+	}
+
+	switch strings.ToLower(config.AvatarUploadStorage) {
+	case "local":
+		if config.AvatarUploadLocalDir == "" {
+			return nil, errors.New("avatar upload local_dir is required")
+		}
+		return &localAvatarStore{
+			dir:       config.AvatarUploadLocalDir,
+			urlPrefix: config.PathPrefix + uploadedAvatarPath,
+		}, nil
+	case "s3":
+		if config.AvatarUploadS3Bucket == "" || config.AvatarUploadS3AccessKeyID == "" || config.AvatarUploadS3SecretAccessKey == "" {
+			return nil, errors.New("avatar upload s3_bucket, s3_access_key_id and s3_secret_access_key are required")
+		}
+		Endpoint := strings.TrimSuffix(config.AvatarUploadS3Endpoint, "/")
+		if Endpoint == "" {
+			Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", config.AvatarUploadS3Region)
+		}
+		PublicURL := strings.TrimSuffix(config.AvatarUploadS3PublicURL, "/")
+		if PublicURL == "" {
+			PublicURL = Endpoint + "/" + config.AvatarUploadS3Bucket
+		}
+		return &s3AvatarStore{
+			endpoint:        Endpoint,
+			bucket:          config.AvatarUploadS3Bucket,
+			region:          config.AvatarUploadS3Region,
+			accessKeyID:     config.AvatarUploadS3AccessKeyID,
+			secretAccessKey: config.AvatarUploadS3SecretAccessKey,
+			publicURL:       PublicURL,
+			client:          Client,
+		}, nil
+	default:
+		return nil, errors.New("unsupported avatar upload storage " + config.AvatarUploadStorage)
+	}
+}
+
+// localAvatarStore stores avatars on the local disk, served by handleUploadedAvatar
+type localAvatarStore struct {
+	dir       string
+	urlPrefix string
+}
+
+// Put writes the avatar to the directory
+func (s *localAvatarStore) Put(Name string, Data []byte) (string, error) {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(filepath.Join(s.dir, Name), Data, 0644); err != nil {
+		return "", err
+	}
+
+	return s.urlPrefix + Name, nil
+}
+
+// Name gets the avatar file name from its URL
+func (s *localAvatarStore) Name(URL string) string {
+	Name := strings.TrimPrefix(URL, s.urlPrefix)
+	if Name == URL || !avatarFileNamePattern.MatchString(Name) {
+		return ""
+	}
+
+	return Name
+}
+
+// Delete removes the users avatar from the directory
+func (s *localAvatarStore) Delete(UserID string, URL string) (int64, error) {
+	Name := s.Name(URL)
+	if !avatarOwnedBy(Name, UserID) {
+		return 0, nil
+	}
+
+	Path := filepath.Join(s.dir, Name)
+	Info, err := os.Stat(Path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if err := os.Remove(Path); err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+
+	return Info.Size(), nil
+}
+
+// s3AvatarStore stores avatars in an S3 compatible bucket using path style requests signed with AWS Signature Version 4
+type s3AvatarStore struct {
+	endpoint        string
+	bucket          string
+	region          string
+	accessKeyID     string
+	secretAccessKey string
+	publicURL       string
+	client          *http.Client
+}
+
+// Put uploads the avatar to the bucket
+func (s *s3AvatarStore) Put(Name string, Data []byte) (string, error) {
+	req, err := http.NewRequest("PUT", s.endpoint+"/"+s.bucket+"/"+Name, bytes.NewReader(Data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "image/png")
+	// names are unique per upload so the avatar never changes
+	req.Header.Set("Cache-Control", "public, max-age=31536000, immutable")
+
+	if _, err := s.do(req, Data); err != nil {
+		return "", err
+	}
+
+	return s.publicURL + "/" + Name, nil
+}
+
+// Name gets the avatar object name from its URL
+func (s *s3AvatarStore) Name(URL string) string {
+	Name := strings.TrimPrefix(URL, s.publicURL+"/")
+	if Name == URL || !avatarFileNamePattern.MatchString(Name) {
+		return ""
+	}
+
+	return Name
+}
+
+// Delete removes the users avatar from the bucket, its size is read first as the delete doesn't return it
+func (s *s3AvatarStore) Delete(UserID string, URL string) (int64, error) {
+	Name := s.Name(URL)
+	if !avatarOwnedBy(Name, UserID) {
+		return 0, nil
+	}
+
+	req, err := http.NewRequest("HEAD", s.endpoint+"/"+s.bucket+"/"+Name, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := s.do(req, nil)
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return 0, nil
+	}
+	Size := resp.ContentLength
+	if Size < 0 {
+		Size = 0
+	}
+
+	req, err = http.NewRequest("DELETE", s.endpoint+"/"+s.bucket+"/"+Name, nil)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := s.do(req, nil); err != nil {
+		return 0, err
+	}
+
+	return Size, nil
+}
+
+// do signs and sends the request, a missing object isn't an error
+func (s *s3AvatarStore) do(req *http.Request, Payload []byte) (*http.Response, error) {
+	req.Header.Set("X-Amz-Content-Sha256", sigv4.PayloadHash(Payload))
+	sigv4.Sign(req, Payload, time.Now(), s.region, "s3", s.accessKeyID, s.secretAccessKey)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotFound {
+		return nil, errors.New("unexpected s3 response " + resp.Status)
+	}
+
+	return resp, nil
+}
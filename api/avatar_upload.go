@@ -0,0 +1,211 @@
+package api
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+
+	"github.com/anthonynsimon/bild/transform"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// avatarMaxPixels the max pixels of an uploaded avatar, images are rejected before decoding
+// so a small compressed file can't expand into a huge image
+const avatarMaxPixels = 40000000
+
+// avatarContentTypes the image types accepted as avatars
+var avatarContentTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+}
+
+// createAvatarThumbnail validates the uploaded image then center crops it to a square
+// and resizes it to the thumbnail size, returning it PNG encoded
+func createAvatarThumbnail(Data []byte, Size int) ([]byte, error) {
+	if !avatarContentTypes[http.DetectContentType(Data)] {
+		return nil, errors.New("INVALID_AVATAR_TYPE")
+	}
+
+	Config, _, err := image.DecodeConfig(bytes.NewReader(Data))
+	if err != nil || Config.Width <= 0 || Config.Height <= 0 || Config.Width*Config.Height > avatarMaxPixels {
+		return nil, errors.New("INVALID_AVATAR_IMAGE")
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(Data))
+	if err != nil {
+		return nil, errors.New("INVALID_AVATAR_IMAGE")
+	}
+
+	Bounds := img.Bounds()
+	Side := Bounds.Dx()
+	if Bounds.Dy() < Side {
+		Side = Bounds.Dy()
+	}
+	Min := image.Pt(Bounds.Min.X+(Bounds.Dx()-Side)/2, Bounds.Min.Y+(Bounds.Dy()-Side)/2)
+	Square := transform.Crop(img, image.Rectangle{Min: Min, Max: Min.Add(image.Pt(Side, Side))})
+	Thumbnail := transform.Resize(Square, Size, Size, transform.Linear)
+
+	buffer := new(bytes.Buffer)
+	if err := png.Encode(buffer, Thumbnail); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// avatarFileName creates a unique name for the users uploaded avatar so a new upload is never served from cache
+func avatarFileName(UserID string) (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return UserID + "-" + hex.EncodeToString(b) + ".png", nil
+}
+
+// handleUploadAvatar uploads an avatar image for the user
+// @Summary Upload Avatar
+// @Description Uploads a png, jpeg or gif avatar image which is cropped to a square thumbnail,
+// @Description the users avatar is set to the thumbnails URL and any previously uploaded avatar removed,
+// @Description uploads that would exceed the users or instances storage quota are rejected
+// @Tags user
+// @Accept  multipart/form-data
+// @Produce  json
+// @Param userId path string true "the user ID"
+// @Param avatar formData file true "the avatar image"
+// @Success 200 object standardJsonResponse{data=model.User}
+// @Failure 400 object standardJsonResponse{}
+// @Failure 403 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /users/{userId}/avatar [post]
+func (a *api) handleUploadAvatar() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		UserID := vars["userId"]
+
+		// allow for the multipart encoding overhead on top of the image itself
+		r.Body = http.MaxBytesReader(w, r.Body, a.config.AvatarUploadMaxSize+64*1024)
+		if err := r.ParseMultipartForm(a.config.AvatarUploadMaxSize); err != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, "AVATAR_TOO_LARGE"))
+			return
+		}
+		defer r.MultipartForm.RemoveAll()
+
+		File, Header, err := r.FormFile("avatar")
+		if err != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, "AVATAR_REQUIRED"))
+			return
+		}
+		defer File.Close()
+		if Header.Size > a.config.AvatarUploadMaxSize {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, "AVATAR_TOO_LARGE"))
+			return
+		}
+
+		Data, err := ioutil.ReadAll(File)
+		if err != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, err.Error()))
+			return
+		}
+
+		Thumbnail, err := createAvatarThumbnail(Data, a.config.AvatarUploadThumbnailSize)
+		if err != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, err.Error()))
+			return
+		}
+
+		User, err := a.db.GetUser(UserID)
+		if err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		Name, err := avatarFileName(UserID)
+		if err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		// the new avatar counts towards the users storage until the replaced one is removed
+		Size := int64(len(Thumbnail))
+		if err := a.db.ReserveStorage(UserID, Size); err != nil {
+			if err.Error() == "STORAGE_QUOTA_EXCEEDED" {
+				a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, err.Error()))
+				return
+			}
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		AvatarURL, err := a.avatars.Put(Name, Thumbnail)
+		if err != nil {
+			_ = a.db.ReleaseStorage(UserID, Size)
+			a.logger.Error("error storing uploaded avatar", zap.String("user_id", UserID), zap.Error(err))
+			a.Failure(w, r, http.StatusInternalServerError, errors.New("unable to store avatar"))
+			return
+		}
+
+		if err := a.db.UpdateUserProfile(
+			UserID, User.Name, AvatarURL, User.NotificationsEnabled, User.Country, User.Locale, User.Company, User.JobTitle,
+		); err != nil {
+			a.deleteUploadedAvatar(UserID, AvatarURL)
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		// the replaced avatar is only removed once the new one is saved
+		a.deleteUploadedAvatar(UserID, User.Avatar)
+
+		UpdatedUser, err := a.db.GetUser(UserID)
+		if err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		a.Success(w, r, http.StatusOK, UpdatedUser, nil)
+	}
+}
+
+// deleteUploadedAvatar removes the users uploaded avatar from storage releasing its bytes from their usage,
+// avatars that weren't uploaded are left alone
+func (a *api) deleteUploadedAvatar(UserID string, Avatar string) {
+	if a.avatars == nil {
+		return
+	}
+
+	Freed, err := a.avatars.Delete(UserID, Avatar)
+	if err != nil {
+		a.logger.Error("error deleting uploaded avatar", zap.String("user_id", UserID), zap.Error(err))
+		return
+	}
+	if Freed > 0 {
+		_ = a.db.ReleaseStorage(UserID, Freed)
+	}
+}
+
+// handleUploadedAvatar serves an avatar uploaded to local storage
+func (a *api) handleUploadedAvatar() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		Name := mux.Vars(r)["avatarFile"]
+		if !avatarFileNamePattern.MatchString(Name) {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		http.ServeFile(w, r, filepath.Join(a.config.AvatarUploadLocalDir, Name))
+	}
+}
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// maxBatchRequests the max number of sub-requests allowed in a single batch
+const maxBatchRequests = 25
+
+// maxBatchRequestSize the max size in bytes of a batch request body
+const maxBatchRequestSize = 1 << 20
+
+type batchSubRequest struct {
+	Method string          `json:"method" enums:"GET,POST,PUT,PATCH,DELETE"`
+	Path   string          `json:"path" example:"/users/{userId}/battles"`
+	Body   json.RawMessage `json:"body" swaggertype:"object"`
+}
+
+type batchRequestBody struct {
+	Requests []batchSubRequest `json:"requests"`
+	// AbortOnError stops executing the remaining sub-requests after the first failure
+	AbortOnError bool `json:"abortOnError"`
+}
+
+type batchSubResponse struct {
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body" swaggertype:"object"`
+}
+
+// batchResponseWriter captures a sub-requests response to include in the batch response
+type batchResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBatchResponseWriter() *batchResponseWriter {
+	return &batchResponseWriter{header: make(http.Header)}
+}
+
+func (bw *batchResponseWriter) Header() http.Header {
+	return bw.header
+}
+
+func (bw *batchResponseWriter) WriteHeader(status int) {
+	if bw.status == 0 {
+		bw.status = status
+	}
+}
+
+func (bw *batchResponseWriter) Write(b []byte) (int, error) {
+	bw.WriteHeader(http.StatusOK)
+	return bw.body.Write(b)
+}
+
+// Status the sub-requests response status, 200 when the handler never set one
+func (bw *batchResponseWriter) Status() int {
+	if bw.status == 0 {
+		return http.StatusOK
+	}
+	return bw.status
+}
+
+var batchAllowedMethods = map[string]bool{
+	http.MethodGet:    true,
+	http.MethodPost:   true,
+	http.MethodPut:    true,
+	http.MethodPatch:  true,
+	http.MethodDelete: true,
+}
+
+// validateBatchRequest validates the batch size and each sub-requests method and path
+func validateBatchRequest(b batchRequestBody) error {
+	if len(b.Requests) == 0 || len(b.Requests) > maxBatchRequests {
+		return Errorf(EINVALID, "INVALID_BATCH_SIZE")
+	}
+
+	for _, sr := range b.Requests {
+		if !batchAllowedMethods[strings.ToUpper(sr.Method)] {
+			return Errorf(EINVALID, "INVALID_BATCH_METHOD")
+		}
+		if !strings.HasPrefix(sr.Path, "/") || strings.HasPrefix(sr.Path, "//") || strings.HasPrefix(sr.Path, "/batch") {
+			return Errorf(EINVALID, "INVALID_BATCH_PATH")
+		}
+	}
+
+	return nil
+}
+
+// handleBatch executes multiple API requests in sequence to reduce round trips
+// @Summary Batch Requests
+// @Description Executes an array of API sub-requests in sequence under the same authentication, returning an array of sub-responses
+// @Tags batch
+// @Produce  json
+// @Param batch body batchRequestBody true "batch of sub-requests, paths are relative to the API base path"
+// @Success 200 object standardJsonResponse{data=[]batchSubResponse}
+// @Failure 400 object standardJsonResponse{}
+// @Failure 401 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /batch [post]
+func (a *api) handleBatch() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, bodyErr := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBatchRequestSize))
+		if bodyErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, bodyErr.Error()))
+			return
+		}
+
+		var b = batchRequestBody{}
+		jsonErr := json.Unmarshal(body, &b)
+		if jsonErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, jsonErr.Error()))
+			return
+		}
+
+		if err := validateBatchRequest(b); err != nil {
+			a.Failure(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		basePath := strings.TrimSuffix(r.URL.Path, "/batch")
+		responses := make([]*batchSubResponse, 0, len(b.Requests))
+
+		for _, sr := range b.Requests {
+			subReq, err := http.NewRequestWithContext(
+				r.Context(), strings.ToUpper(sr.Method), basePath+sr.Path, bytes.NewReader(sr.Body),
+			)
+			if err != nil {
+				a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, "INVALID_BATCH_PATH"))
+				return
+			}
+			// sub-requests authenticate the same as the batch request e.g. session cookie or api key
+			for k, v := range r.Header {
+				if k != "Content-Length" {
+					subReq.Header[k] = v
+				}
+			}
+			subReq.RemoteAddr = r.RemoteAddr
+			subReq.Host = r.Host
+
+			bw := newBatchResponseWriter()
+			a.router.ServeHTTP(bw, subReq)
+
+			subBody := bytes.TrimSpace(bw.body.Bytes())
+			if !json.Valid(subBody) {
+				subBody, _ = json.Marshal(string(subBody))
+			}
+			responses = append(responses, &batchSubResponse{
+				Status: bw.Status(),
+				Body:   subBody,
+			})
+
+			if b.AbortOnError && bw.Status() >= http.StatusBadRequest {
+				break
+			}
+		}
+
+		a.Success(w, r, http.StatusOK, responses, nil)
+	}
+}
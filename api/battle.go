@@ -2,13 +2,18 @@ package api
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/StevenWeathers/thunderdome-planning-poker/api/battle"
 	"io/ioutil"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/StevenWeathers/thunderdome-planning-poker/model"
 	"github.com/gorilla/mux"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
 )
 
 // handleGetUserBattles looks up battles associated with UserID
@@ -19,7 +24,11 @@ import (
 // @Param userId path string true "the user ID to get battles for"
 // @Param limit query int false "Max number of results to return"
 // @Param offset query int false "Starting point to return rows from, should be multiplied by limit or 0"
+// @Param state query string false "only return battles in the state"
+// @Param tag query string false "only return battles the user tagged with the tag"
+// @Param view query string false "the ID of a saved view to filter by"
 // @Success 200 object standardJsonResponse{data=[]model.Battle}
+// @Failure 400 object standardJsonResponse{}
 // @Failure 403 object standardJsonResponse{}
 // @Failure 404 object standardJsonResponse{}
 // @Security ApiKeyAuth
@@ -30,7 +39,25 @@ func (a *api) handleGetUserBattles() http.HandlerFunc {
 		vars := mux.Vars(r)
 		UserID := vars["userId"]
 
-		battles, Count, err := a.db.GetBattlesByUser(UserID, Limit, Offset)
+		Filter, ok := a.getRequestViewFilter(w, r, UserID, "battles")
+		if !ok {
+			return
+		}
+		if State := r.URL.Query().Get("state"); State != "" {
+			Filter.State = State
+		}
+		if Tag := r.URL.Query().Get("tag"); Tag != "" {
+			Filter.Tag = Tag
+		}
+
+		// team views list the teams battles rather than the users own
+		if Filter.TeamId != "" {
+			battles := a.db.TeamBattleList(Filter.TeamId, Filter.State, Limit, Offset)
+			a.Success(w, r, http.StatusOK, battles, nil)
+			return
+		}
+
+		battles, Count, err := a.db.GetBattlesByUser(UserID, Filter.State, Filter.Tag, Limit, Offset)
 		if err != nil {
 			a.Failure(w, r, http.StatusNotFound, Errorf(ENOTFOUND, "BATTLE_NOT_FOUND"))
 			return
@@ -48,11 +75,15 @@ func (a *api) handleGetUserBattles() http.HandlerFunc {
 
 type battleRequestBody struct {
 	BattleName           string        `json:"name"`
+	PointScale           string        `json:"pointScale" enums:"fibonacci,tshirt,powers_of_two,custom"`
 	PointValuesAllowed   []string      `json:"pointValuesAllowed"`
 	AutoFinishVoting     bool          `json:"autoFinishVoting"`
 	Plans                []*model.Plan `json:"plans"`
 	PointAverageRounding string        `json:"pointAverageRounding"`
 	BattleLeaders        []string      `json:"battleLeaders"`
+	RecordingEnabled     bool          `json:"recordingEnabled"`
+	PermanentAnonymity   bool          `json:"permanentAnonymity"`
+	MinParticipants      int           `json:"minParticipants"`
 }
 
 // handleBattleCreate handles creating a battle (arena)
@@ -66,6 +97,7 @@ type battleRequestBody struct {
 // @Param teamId path string false "the team ID"
 // @Param battle body battleRequestBody false "new battle object"
 // @Success 200 object standardJsonResponse{data=model.Battle}
+// @Failure 400 object standardJsonResponse{}
 // @Failure 403 object standardJsonResponse{}
 // @Failure 500 object standardJsonResponse{}
 // @Security ApiKeyAuth
@@ -92,17 +124,66 @@ func (a *api) handleBattleCreate() http.HandlerFunc {
 			return
 		}
 
-		newBattle, err := a.db.CreateBattle(UserID, b.BattleName, b.PointValuesAllowed, b.Plans, b.AutoFinishVoting, b.PointAverageRounding)
+		// plans are checked against the limit before creating so an oversized import creates nothing
+		PlanLimit := a.db.GetTeamPlanLimit(vars["teamId"])
+		if PlanLimit > 0 && len(b.Plans) > PlanLimit {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, "PLAN_LIMIT_REACHED"))
+			return
+		}
+
+		if b.PointScale == "" && len(b.PointValuesAllowed) == 0 {
+			b.PointValuesAllowed = viper.GetStringSlice("config.defaultPointValues")
+		}
+
+		newBattle, err := a.db.CreateBattle(UserID, b.BattleName, b.PointScale, b.PointValuesAllowed, b.Plans, b.AutoFinishVoting, b.PointAverageRounding)
 		if err != nil {
+			if err.Error() == "INVALID_POINT_SCALE" || err.Error() == "INVALID_POINT_VALUES" {
+				a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, err.Error()))
+				return
+			}
 			a.Failure(w, r, http.StatusInternalServerError, err)
 			return
 		}
 
+		// human friendly short code for sharing verbally, battle is still joinable by ID without one
+		if ShortCode, err := a.db.CreateBattleShortCode(newBattle.Id); err != nil {
+			a.logger.Error("error creating battle short code", zap.Error(err))
+		} else {
+			newBattle.ShortCode = ShortCode
+		}
+
+		// recording of the battle session is opt-in
+		if b.RecordingEnabled {
+			if err := a.db.SetBattleRecording(newBattle.Id, true); err != nil {
+				a.logger.Error("error enabling battle recording", zap.Error(err))
+			} else {
+				newBattle.RecordingEnabled = true
+			}
+		}
+
+		// votes stay anonymous after reveal, only their distribution is kept
+		if b.PermanentAnonymity {
+			if _, err := a.db.EnableBattlePermanentAnonymity(newBattle.Id); err != nil {
+				a.logger.Error("error enabling battle permanent anonymity", zap.Error(err))
+			} else {
+				newBattle.PermanentAnonymity = true
+			}
+		}
+
+		// voting can't start until the minimum participants have joined, defaults to no minimum
+		if b.MinParticipants != 0 {
+			if err := a.db.SetBattleMinParticipants(newBattle.Id, b.MinParticipants); err != nil {
+				a.logger.Error("error setting battle min participants", zap.Error(err))
+			} else {
+				newBattle.MinParticipants = b.MinParticipants
+			}
+		}
+
 		// when battleLeaders array is passed add additional leaders to battle
 		if len(b.BattleLeaders) > 0 {
 			updatedLeaders, err := a.db.AddBattleLeadersByEmail(newBattle.Id, b.BattleLeaders)
 			if err != nil {
-				a.logger.Error("error adding additional battle leaders")
+				a.logger.Error("error adding additional battle leaders", zap.Error(err))
 			} else {
 				newBattle.Leaders = updatedLeaders
 			}
@@ -132,6 +213,84 @@ func (a *api) handleBattleCreate() http.HandlerFunc {
 			}
 		}
 
+		a.completeOnboardingStep(UserID, onboardingStepCreateBattle)
+
+		a.Success(w, r, http.StatusOK, newBattle, nil)
+	}
+}
+
+type quickBattleRequestBody struct {
+	// Name the guest leaders name when creating without an account
+	Name               string   `json:"name"`
+	BattleName         string   `json:"battleName"`
+	PointScale         string   `json:"pointScale" enums:"fibonacci,tshirt,powers_of_two,custom"`
+	PointValuesAllowed []string `json:"pointValuesAllowed"`
+	// CaptchaToken required when auth.captcha.enabled is enabled and creating without an account
+	CaptchaToken string `json:"captchaToken"`
+}
+
+// handleQuickBattleCreate handles creating an unlisted throwaway battle that expires, creating a guest leader when needed
+// @Summary Create Quick Battle
+// @Description Create an unlisted battle that expires after a short time, no account required as the creator becomes a guest leader
+// @Tags battle
+// @Produce  json
+// @Param battle body quickBattleRequestBody true "new quick battle object"
+// @Success 200 object standardJsonResponse{data=model.Battle}
+// @Failure 400 object standardJsonResponse{}
+// @Failure 403 object standardJsonResponse{}
+// @Failure 429 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Router /quick-battles [post]
+func (a *api) handleQuickBattleCreate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		UserID := r.Context().Value(contextKeyUserID).(string)
+
+		body, bodyErr := ioutil.ReadAll(r.Body)
+		if bodyErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, bodyErr.Error()))
+			return
+		}
+
+		var b = quickBattleRequestBody{}
+		jsonErr := json.Unmarshal(body, &b)
+		if jsonErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, jsonErr.Error()))
+			return
+		}
+
+		if b.BattleName == "" {
+			b.BattleName = "Quick Battle"
+		}
+		if b.PointScale == "" && len(b.PointValuesAllowed) == 0 {
+			b.PointValuesAllowed = viper.GetStringSlice("config.defaultPointValues")
+		}
+
+		// plans aren't accepted at creation, they're added in battle subject to the plan limit
+		newBattle, err := a.db.CreateBattle(UserID, b.BattleName, b.PointScale, b.PointValuesAllowed, make([]*model.Plan, 0), true, "ceil")
+		if err != nil {
+			if err.Error() == "INVALID_POINT_SCALE" || err.Error() == "INVALID_POINT_VALUES" {
+				a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, err.Error()))
+				return
+			}
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		ExpireDate, err := a.db.SetBattleQuick(newBattle.Id, time.Duration(a.config.QuickBattleTTL)*time.Minute)
+		if err != nil {
+			_ = a.db.DeleteBattle(newBattle.Id)
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		newBattle.Quick = true
+		newBattle.ExpireDate = &ExpireDate
+
+		if ShortCode, err := a.db.CreateBattleShortCode(newBattle.Id); err != nil {
+			a.logger.Error("error creating battle short code", zap.Error(err))
+		} else {
+			newBattle.ShortCode = ShortCode
+		}
+
 		a.Success(w, r, http.StatusOK, newBattle, nil)
 	}
 }
@@ -215,6 +374,163 @@ func (a *api) handleGetBattle() http.HandlerFunc {
 	}
 }
 
+// handleResolveBattleCode resolves a battle short code to the battle ID for joining
+// @Summary Resolve Battle Short Code
+// @Description get the battle ID for a human friendly battle short code (case-insensitive)
+// @Tags battle
+// @Produce  json
+// @Param code path string true "the battle short code"
+// @Success 200 object standardJsonResponse{data=string}
+// @Failure 404 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /battles/code/{code} [get]
+func (a *api) handleResolveBattleCode() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		Code := vars["code"]
+
+		BattleID, err := a.db.GetBattleIDByShortCode(Code)
+		if err != nil {
+			a.Failure(w, r, http.StatusNotFound, Errorf(ENOTFOUND, "BATTLE_NOT_FOUND"))
+			return
+		}
+
+		a.Success(w, r, http.StatusOK, BattleID, nil)
+	}
+}
+
+// handleReplayBattle gets the recorded battle session events for replay
+// @Summary Replay Battle
+// @Description get the ordered recorded battle session events followed by the final plan estimates, restricted to battle leaders
+// @Tags battle
+// @Produce  json
+// @Param battleId path string true "the battle ID to replay"
+// @Param anonymize query boolean false "pseudonymize participant identities"
+// @Success 200 object standardJsonResponse{data=model.BattleReplay}
+// @Failure 403 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /battles/{battleId}/replay [get]
+func (a *api) handleReplayBattle() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		BattleId := vars["battleId"]
+		UserId := r.Context().Value(contextKeyUserID).(string)
+		UserType := r.Context().Value(contextKeyUserType).(string)
+		Anonymize, _ := strconv.ParseBool(r.URL.Query().Get("anonymize"))
+
+		if UserType != adminUserType {
+			if err := a.db.ConfirmLeader(BattleId, UserId); err != nil {
+				a.Failure(w, r, http.StatusForbidden, Errorf(EUNAUTHORIZED, "REQUIRES_BATTLE_LEADER"))
+				return
+			}
+		}
+
+		// buffered events are written first so the replay includes the latest
+		a.battleService.FlushEvents()
+		Events, err := a.db.GetBattleEvents(BattleId)
+		if err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		Replay := &model.BattleReplay{
+			BattleId: BattleId,
+			Events:   Events,
+			Plans:    a.db.GetPlans(BattleId, ""),
+		}
+
+		if Anonymize {
+			anonymizeBattleReplay(Replay)
+		}
+
+		a.Success(w, r, http.StatusOK, Replay, nil)
+	}
+}
+
+// anonymizeBattleReplay replaces participant identities with pseudonyms consistent throughout the replay
+func anonymizeBattleReplay(Replay *model.BattleReplay) {
+	pseudonyms := make(map[string]string)
+	pseudonym := func(UserId string) string {
+		if UserId == "" {
+			return ""
+		}
+		if _, ok := pseudonyms[UserId]; !ok {
+			pseudonyms[UserId] = fmt.Sprintf("participant-%d", len(pseudonyms)+1)
+		}
+		return pseudonyms[UserId]
+	}
+
+	for _, e := range Replay.Events {
+		e.UserId = pseudonym(e.UserId)
+		e.UserName = e.UserId
+		e.Value = anonymizeReplayEventValue(e.Value, pseudonym)
+	}
+
+	for _, p := range Replay.Plans {
+		for _, v := range p.Votes {
+			v.UserId = pseudonym(v.UserId)
+			v.DelegateId = pseudonym(v.DelegateId)
+		}
+		for _, dg := range p.VoteDelegations {
+			dg.UserId = pseudonym(dg.UserId)
+			dg.DelegateId = pseudonym(dg.DelegateId)
+		}
+	}
+}
+
+// replayUserIDKeys the json keys of recorded event values holding a user ID e.g. a delegate_vote target
+var replayUserIDKeys = map[string]bool{
+	"warriorId":   true,
+	"userId":      true,
+	"delegateId":  true,
+	"leaderId":    true,
+	"noteTakerId": true,
+}
+
+// anonymizeReplayEventValue replaces the user IDs within a json event value with their pseudonyms,
+// values that aren't json e.g. a plan ID are returned as is
+func anonymizeReplayEventValue(Value string, pseudonym func(UserId string) string) string {
+	if !json.Valid([]byte(Value)) {
+		return Value
+	}
+	dec := json.NewDecoder(strings.NewReader(Value))
+	dec.UseNumber()
+	var Data interface{}
+	if err := dec.Decode(&Data); err != nil {
+		return Value
+	}
+	if _, ok := Data.(string); ok {
+		return Value
+	}
+
+	var scrub func(v interface{})
+	scrub = func(v interface{}) {
+		switch t := v.(type) {
+		case map[string]interface{}:
+			for k, child := range t {
+				if id, ok := child.(string); ok && replayUserIDKeys[k] {
+					t[k] = pseudonym(id)
+					continue
+				}
+				scrub(child)
+			}
+		case []interface{}:
+			for _, child := range t {
+				scrub(child)
+			}
+		}
+	}
+	scrub(Data)
+
+	Scrubbed, err := json.Marshal(Data)
+	if err != nil {
+		return ""
+	}
+
+	return string(Scrubbed)
+}
+
 type planRequestBody struct {
 	Name               string `json:"planName"`
 	Type               string `json:"type"`
@@ -257,3 +573,126 @@ func (a *api) handleBattlePlanAdd(b *battle.Service) http.HandlerFunc {
 		a.Success(w, r, http.StatusOK, nil, nil)
 	}
 }
+
+type planActualRequestBody struct {
+	// ActualPoints the actual points/hours, null clears it
+	ActualPoints *float64 `json:"actualPoints"`
+}
+
+// handleBattlePlanActual records a plans actual effort after the sprint
+// @Summary Record Plan Actual
+// @Description Records the actual points/hours of a plan after the sprint for estimation accuracy, only battle leaders (and admins) can record actuals
+// @Tags battle
+// @Produce  json
+// @Param battleId path string true "the battle ID"
+// @Param planId path string true "the plan ID"
+// @Param actual body planActualRequestBody true "the actual points/hours"
+// @Success 200 object standardJsonResponse{}
+// @Failure 400 object standardJsonResponse{}
+// @Failure 403 object standardJsonResponse{}
+// @Failure 404 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /battles/{battleId}/plans/{planId}/actual [put]
+func (a *api) handleBattlePlanActual(b *battle.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		BattleID := vars["battleId"]
+		PlanID := vars["planId"]
+		UserID := r.Context().Value(contextKeyUserID).(string)
+		UserType := r.Context().Value(contextKeyUserType).(string)
+
+		if UserType != adminUserType {
+			if err := a.db.ConfirmLeader(BattleID, UserID); err != nil {
+				a.Failure(w, r, http.StatusForbidden, Errorf(EUNAUTHORIZED, "REQUIRES_BATTLE_LEADER"))
+				return
+			}
+		}
+
+		var pa = planActualRequestBody{}
+		body, bodyErr := ioutil.ReadAll(r.Body)
+		if bodyErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, bodyErr.Error()))
+			return
+		}
+
+		jsonErr := json.Unmarshal(body, &pa)
+		if jsonErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, jsonErr.Error()))
+			return
+		}
+
+		if err := a.db.RecordPlanActual(BattleID, PlanID, pa.ActualPoints); err != nil {
+			switch err.Error() {
+			case "INVALID_ACTUAL_POINTS":
+				a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, err.Error()))
+			case "PLAN_NOT_FOUND":
+				a.Failure(w, r, http.StatusNotFound, Errorf(ENOTFOUND, err.Error()))
+			default:
+				a.Failure(w, r, http.StatusInternalServerError, err)
+			}
+			return
+		}
+
+		updatedPlans, _ := json.Marshal(a.db.GetPlans(BattleID, ""))
+		b.BroadcastEvent(BattleID, "plan_actual_recorded", string(updatedPlans))
+
+		a.Success(w, r, http.StatusOK, nil, nil)
+	}
+}
+
+// handleReopenBattle handles reopening a closed battle
+// @Summary Reopen Battle
+// @Description Reopens a closed battle keeping its finalized estimates, battle leaders can reopen within {config.battle_reopen_window_days} days of closing while admins can always reopen
+// @Param battleId path string true "the battle ID"
+// @Tags battle
+// @Produce  json
+// @Success 200 object standardJsonResponse{data=model.Battle}
+// @Failure 400 object standardJsonResponse{}
+// @Failure 403 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /battles/{battleId}/reopen [post]
+func (a *api) handleReopenBattle(b *battle.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		BattleID := vars["battleId"]
+		UserID := r.Context().Value(contextKeyUserID).(string)
+		UserType := r.Context().Value(contextKeyUserType).(string)
+		isAdmin := UserType == adminUserType
+
+		if !isAdmin {
+			if err := a.db.ConfirmLeader(BattleID, UserID); err != nil {
+				a.Failure(w, r, http.StatusForbidden, Errorf(EUNAUTHORIZED, "REQUIRES_BATTLE_LEADER"))
+				return
+			}
+		}
+
+		if err := a.db.ReopenBattle(BattleID, UserID, a.config.BattleReopenWindowDays, isAdmin); err != nil {
+			errMsg := err.Error()
+			if errMsg == "BATTLE_NOT_CLOSED" || errMsg == "BATTLE_REOPEN_WINDOW_EXPIRED" {
+				a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, errMsg))
+				return
+			}
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		a.logger.Info("battle reopened",
+			zap.String("battle_id", BattleID),
+			zap.String("acting_user_id", UserID),
+			zap.String("acting_user_type", UserType),
+		)
+
+		Battle, err := a.db.GetBattle(BattleID, "")
+		if err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		updatedBattle, _ := json.Marshal(Battle)
+		b.BroadcastEvent(BattleID, "battle_reopened", string(updatedBattle))
+
+		a.Success(w, r, http.StatusOK, Battle, nil)
+	}
+}
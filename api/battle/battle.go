@@ -2,9 +2,12 @@
 package battle
 
 import (
+	"encoding/json"
 	"net/http"
+	"time"
 
 	"github.com/StevenWeathers/thunderdome-planning-poker/db"
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
 	"go.uber.org/zap"
 )
 
@@ -14,7 +17,12 @@ type Service struct {
 	logger                *zap.Logger
 	validateSessionCookie func(w http.ResponseWriter, r *http.Request) (string, error)
 	validateUserCookie    func(w http.ResponseWriter, r *http.Request) (string, error)
+	validateJoinName      func(name string) error
+	guestSessionExpiry    func(User *model.User) (time.Time, bool)
+	guestExpiryWarning    time.Duration
 	eventHandlers         map[string]func(string, string, string) ([]byte, error, bool)
+	// recorder buffers the events recorded for session replay
+	recorder eventRecorder
 }
 
 // New returns a new battle with websocket hub/client and event handlers
@@ -23,35 +31,117 @@ func New(
 	logger *zap.Logger,
 	validateSessionCookie func(w http.ResponseWriter, r *http.Request) (string, error),
 	validateUserCookie func(w http.ResponseWriter, r *http.Request) (string, error),
+	validateJoinName func(name string) error,
+	checkOrigin func(r *http.Request) bool,
+	guestSessionExpiry func(User *model.User) (time.Time, bool),
+	guestExpiryWarning time.Duration,
 ) *Service {
 	b := &Service{
 		db:                    db,
 		logger:                logger,
 		validateSessionCookie: validateSessionCookie,
 		validateUserCookie:    validateUserCookie,
+		validateJoinName:      validateJoinName,
+		guestSessionExpiry:    guestSessionExpiry,
+		guestExpiryWarning:    guestExpiryWarning,
 	}
 
 	b.eventHandlers = map[string]func(string, string, string) ([]byte, error, bool){
-		"jab_warrior":      b.UserNudge,
-		"vote":             b.UserVote,
-		"retract_vote":     b.UserVoteRetract,
-		"end_voting":       b.PlanVoteEnd,
-		"add_plan":         b.PlanAdd,
-		"revise_plan":      b.PlanRevise,
-		"burn_plan":        b.PlanDelete,
-		"activate_plan":    b.PlanActivate,
-		"skip_plan":        b.PlanSkip,
-		"finalize_plan":    b.PlanFinalize,
-		"promote_leader":   b.UserPromote,
-		"demote_leader":    b.UserDemote,
-		"become_leader":    b.UserPromoteSelf,
-		"spectator_toggle": b.UserSpectatorToggle,
-		"revise_battle":    b.Revise,
-		"concede_battle":   b.Delete,
-		"abandon_battle":   b.Abandon,
+		"jab_warrior":                  b.UserNudge,
+		"vote":                         b.UserVote,
+		"retract_vote":                 b.UserVoteRetract,
+		"delegate_vote":                b.UserVoteDelegate,
+		"revoke_vote_delegation":       b.UserVoteDelegationRevoke,
+		"end_voting":                   b.PlanVoteEnd,
+		"add_plan":                     b.PlanAdd,
+		"revise_plan":                  b.PlanRevise,
+		"burn_plan":                    b.PlanDelete,
+		"activate_plan":                b.PlanActivate,
+		"force_activate_plan":          b.PlanActivateOverride,
+		"skip_plan":                    b.PlanSkip,
+		"finalize_plan":                b.PlanFinalize,
+		"promote_leader":               b.UserPromote,
+		"demote_leader":                b.UserDemote,
+		"become_leader":                b.UserPromoteSelf,
+		"spectator_toggle":             b.UserSpectatorToggle,
+		"set_spectator":                b.UserSpectatorSet,
+		"revise_battle":                b.Revise,
+		"concede_battle":               b.Delete,
+		"abandon_battle":               b.Abandon,
+		"set_recording":                b.SetRecording,
+		"set_active_plan":              b.PlanSetCurrent,
+		"next_plan":                    b.PlanNext,
+		"previous_plan":                b.PlanPrevious,
+		"set_auto_start_voting":        b.SetAutoStartVoting,
+		"pause_battle":                 b.Pause,
+		"resume_battle":                b.Resume,
+		"add_acceptance_criterion":     b.PlanAcceptanceCriterionAdd,
+		"toggle_acceptance_criterion":  b.PlanAcceptanceCriterionToggle,
+		"remove_acceptance_criterion":  b.PlanAcceptanceCriterionRemove,
+		"set_require_ready_to_reveal":  b.SetRequireReadyToReveal,
+		"enable_permanent_anonymity":   b.EnablePermanentAnonymity,
+		"set_estimate_cap":             b.SetEstimateCap,
+		"set_min_participants":         b.SetMinParticipants,
+		"toggle_ready_to_reveal":       b.ToggleReadyToReveal,
+		"merge_plans":                  b.PlanMerge,
+		"close_battle":                 b.Close,
+		"set_battle_state":             b.SetState,
+		"start_plan_poll":              b.PlanPollStart,
+		"respond_plan_poll":            b.PlanPollRespond,
+		"close_plan_poll":              b.PlanPollClose,
+		"add_parking_lot_item":         b.ParkingLotItemAdd,
+		"toggle_parking_lot_item":      b.ParkingLotItemToggle,
+		"remove_parking_lot_item":      b.ParkingLotItemRemove,
+		"set_note_taker":               b.NoteTakerSet,
+		"add_plan_discussion_entry":    b.PlanDiscussionAdd,
+		"edit_plan_discussion_entry":   b.PlanDiscussionEdit,
+		"delete_plan_discussion_entry": b.PlanDiscussionDelete,
+		"toggle_raised_hand":           b.HandToggle,
+		"lower_raised_hand":            b.HandLower,
+		"clear_raised_hands":           b.HandsClear,
+		"start_vote_timer":             b.VoteTimerStart,
+		"extend_vote_timer":            b.VoteTimerExtend,
+		"cancel_vote_timer":            b.VoteTimerCancel,
+		"set_vote_timer_default":       b.SetVoteTimerDefault,
 	}
 
+	upgrader.CheckOrigin = checkOrigin
+
 	go h.run()
+	go b.eventFlusher(eventFlushInterval)
 
 	return b
 }
+
+// EvictSession notifies and disconnects battle clients connected with the ended session
+func (b *Service) EvictSession(SessionID string) {
+	if SessionID == "" {
+		return
+	}
+
+	h.evict <- eviction{sessionID: SessionID}
+}
+
+// EvictUser notifies and disconnects the users battle clients e.g. once they're disabled
+func (b *Service) EvictUser(UserID string) {
+	if UserID == "" {
+		return
+	}
+
+	h.evict <- eviction{userID: UserID}
+}
+
+// TeardownBattle notifies the battles connected clients (if active) that it closed and disconnects them
+func (b *Service) TeardownBattle(BattleID string) {
+	plans := b.db.GetPlans(BattleID, "")
+	updatedPlans, _ := json.Marshal(plans)
+
+	h.teardown <- message{createSocketEvent("battle_closed", string(updatedPlans), ""), BattleID}
+}
+
+// BroadcastEvent broadcasts an event to the battles connected clients (if active)
+func (b *Service) BroadcastEvent(BattleID string, EventType string, EventValue string) {
+	if _, ok := h.arenas[BattleID]; ok {
+		h.broadcast <- message{createSocketEvent(EventType, EventValue, ""), BattleID}
+	}
+}
@@ -28,18 +28,66 @@ const (
 
 // leaderOnlyOperations contains a map of operations that only a battle leader can execute
 var leaderOnlyOperations = map[string]struct{}{
-	"add_plan":       {},
-	"revise_plan":    {},
-	"burn_plan":      {},
-	"activate_plan":  {},
-	"skip_plan":      {},
-	"end_voting":     {},
-	"finalize_plan":  {},
-	"jab_warrior":    {},
-	"promote_leader": {},
-	"demote_leader":  {},
-	"revise_battle":  {},
-	"concede_battle": {},
+	"add_plan":                    {},
+	"revise_plan":                 {},
+	"burn_plan":                   {},
+	"activate_plan":               {},
+	"force_activate_plan":         {},
+	"skip_plan":                   {},
+	"end_voting":                  {},
+	"finalize_plan":               {},
+	"jab_warrior":                 {},
+	"promote_leader":              {},
+	"demote_leader":               {},
+	"revise_battle":               {},
+	"concede_battle":              {},
+	"set_recording":               {},
+	"set_active_plan":             {},
+	"next_plan":                   {},
+	"previous_plan":               {},
+	"set_auto_start_voting":       {},
+	"add_acceptance_criterion":    {},
+	"pause_battle":                {},
+	"resume_battle":               {},
+	"remove_acceptance_criterion": {},
+	"set_require_ready_to_reveal": {},
+	"enable_permanent_anonymity":  {},
+	"set_estimate_cap":            {},
+	"set_min_participants":        {},
+	"merge_plans":                 {},
+	"start_plan_poll":             {},
+	"close_plan_poll":             {},
+	"close_battle":                {},
+	"set_battle_state":            {},
+	"set_note_taker":              {},
+	"lower_raised_hand":           {},
+	"clear_raised_hands":          {},
+	"start_vote_timer":            {},
+	"extend_vote_timer":           {},
+	"cancel_vote_timer":           {},
+	"set_vote_timer_default":      {},
+	"set_spectator":               {},
+}
+
+// recordableEvents contains a map of events recorded for battle session replay
+var recordableEvents = map[string]struct{}{
+	"vote":                   {},
+	"retract_vote":           {},
+	"delegate_vote":          {},
+	"revoke_vote_delegation": {},
+	"end_voting":             {},
+	"add_plan":               {},
+	"revise_plan":            {},
+	"burn_plan":              {},
+	"merge_plans":            {},
+	"activate_plan":          {},
+	"force_activate_plan":    {},
+	"skip_plan":              {},
+	"finalize_plan":          {},
+	"pause_battle":           {},
+	"resume_battle":          {},
+	"close_battle":           {},
+	"set_battle_state":       {},
 }
 
 var upgrader = websocket.Upgrader{
@@ -54,6 +102,15 @@ type connection struct {
 
 	// Buffered channel of outbound messages.
 	send chan []byte
+
+	// sessionID the authenticated users session, empty for guests
+	sessionID string
+
+	// userID the connected user
+	userID string
+
+	// guestExpiryTimers the guest session expiry prompt and logout, stopped once disconnected
+	guestExpiryTimers []*time.Timer
 }
 
 // readPump pumps messages from the websocket connection to the hub.
@@ -64,6 +121,11 @@ func (sub subscription) readPump(b *Service) {
 	BattleID := sub.arena
 
 	defer func() {
+		for _, timer := range c.guestExpiryTimers {
+			timer.Stop()
+		}
+		b.recordEvent(BattleID, UserID, "warrior_retreated", "")
+
 		Users := b.db.RetreatUser(BattleID, UserID)
 		UpdatedUsers, _ := json.Marshal(Users)
 
@@ -71,6 +133,19 @@ func (sub subscription) readPump(b *Service) {
 		m := message{retreatEvent, BattleID}
 		h.broadcast <- m
 
+		if handsEvent := b.lowerLeavingUsersHand(BattleID, UserID); handsEvent != nil {
+			h.broadcast <- message{handsEvent, BattleID}
+		}
+
+		// the leaving user no longer counts towards readiness which may now have everyone ready
+		if readinessEvent := b.recomputeRevealReadiness(BattleID); readinessEvent != nil {
+			h.broadcast <- message{readinessEvent, BattleID}
+		}
+		// nor towards everyone having voted
+		if endedEvent := b.finishVotingWhenAllVoted(BattleID); endedEvent != nil {
+			h.broadcast <- message{endedEvent, BattleID}
+		}
+
 		h.unregister <- sub
 		if forceClosed {
 			cm := websocket.FormatCloseMessage(4002, "abandoned")
@@ -111,6 +186,11 @@ func (sub subscription) readPump(b *Service) {
 			}
 		}
 
+		// closed battles are read only until reopened
+		if closed, err := b.db.IsBattleClosed(BattleID); (err != nil || closed) && eventType != "abandon_battle" {
+			badEvent = true
+		}
+
 		// find event handler and execute otherwise invalid event
 		if _, ok := b.eventHandlers[eventType]; ok && !badEvent {
 			msg, eventErr, forceClosed = b.eventHandlers[eventType](BattleID, UserID, eventValue)
@@ -125,8 +205,19 @@ func (sub subscription) readPump(b *Service) {
 		}
 
 		if !badEvent {
+			if _, ok := recordableEvents[eventType]; ok {
+				b.recordEvent(BattleID, UserID, eventType, eventValue)
+			}
+
 			m := message{msg, sub.arena}
 			h.broadcast <- m
+
+			// a participant becoming a spectator may leave everyone remaining having voted
+			if eventType == "spectator_toggle" || eventType == "set_spectator" {
+				if endedEvent := b.finishVotingWhenAllVoted(BattleID); endedEvent != nil {
+					h.broadcast <- message{endedEvent, BattleID}
+				}
+			}
 		}
 
 		if forceClosed {
@@ -178,6 +269,41 @@ func (b *Service) handleSocketClose(ws *websocket.Conn, closeCode int, text stri
 	}
 }
 
+// scheduleGuestExpiry prompts a guest to register to save their data as their session approaches expiry,
+// then gracefully logs them out once it has expired unless they've since registered
+func (b *Service) scheduleGuestExpiry(sub subscription, User *model.User) {
+	ExpiresAt, ok := b.guestSessionExpiry(User)
+	if !ok {
+		return
+	}
+
+	remaining := time.Until(ExpiresAt)
+	warnIn := remaining - b.guestExpiryWarning
+	if warnIn < 0 {
+		warnIn = 0
+	}
+
+	stillGuest := func() bool {
+		_, err := b.db.GetGuestUser(sub.UserID)
+		return err == nil
+	}
+
+	sub.conn.guestExpiryTimers = []*time.Timer{
+		time.AfterFunc(warnIn, func() {
+			if stillGuest() {
+				expiringEvent := createSocketEvent("guest_session_expiring", ExpiresAt.Format(time.RFC3339), sub.UserID)
+				h.direct <- directMessage{sub, expiringEvent, false}
+			}
+		}),
+		time.AfterFunc(remaining, func() {
+			if stillGuest() {
+				expiredEvent := createSocketEvent("guest_session_expired", "", sub.UserID)
+				h.direct <- directMessage{sub, expiredEvent, true}
+			}
+		}),
+	}
+}
+
 // ServeBattleWs handles websocket requests from the peer.
 func (b *Service) ServeBattleWs() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -199,6 +325,7 @@ func (b *Service) ServeBattleWs() http.HandlerFunc {
 			b.handleSocketClose(ws, 4001, "unauthorized")
 			return
 		}
+		c.sessionID = SessionId
 
 		if SessionId != "" {
 			var userErr error
@@ -207,6 +334,7 @@ func (b *Service) ServeBattleWs() http.HandlerFunc {
 				b.handleSocketClose(ws, 4001, "unauthorized")
 				return
 			}
+			_ = b.db.TouchSession(SessionId)
 		} else {
 			UserID, err := b.validateUserCookie(w, r)
 			if err != nil {
@@ -222,6 +350,14 @@ func (b *Service) ServeBattleWs() http.HandlerFunc {
 			}
 		}
 
+		c.userID = User.Id
+
+		// make sure user has a valid name when required before joining
+		if nameErr := b.validateJoinName(User.Name); nameErr != nil {
+			b.handleSocketClose(ws, 4006, nameErr.Error())
+			return
+		}
+
 		// make sure battle is legit
 		battle, battleErr := b.db.GetBattle(battleID, User.Id)
 		if battleErr != nil {
@@ -274,14 +410,39 @@ func (b *Service) ServeBattleWs() http.HandlerFunc {
 			if UserAuthed == true {
 				ss := subscription{c, battleID, User.Id}
 				h.register <- ss
+				if SessionId == "" {
+					b.scheduleGuestExpiry(ss, User)
+				}
 
 				Users, _ := b.db.AddUserToBattle(ss.arena, User.Id)
+				b.recordEvent(ss.arena, User.Id, "warrior_joined", "")
 				UpdatedUsers, _ := json.Marshal(Users)
 
+				// snapshot the battle again now the user has joined so the estimation scale and voting settings
+				// are current, a leader may have revised them while the user was entering the join code
+				if joinedBattle, err := b.db.GetBattle(ss.arena, User.Id); err == nil {
+					battle = joinedBattle
+				}
 				Battle, _ := json.Marshal(battle)
 				initEvent := createSocketEvent("init", string(Battle), User.Id)
 				_ = c.write(websocket.TextMessage, initEvent)
 
+				// replay only the users own votes so their UI restores after a reconnect mid round
+				if Votes, err := b.db.GetUserActiveVotes(ss.arena, User.Id); err == nil {
+					UserVotes, _ := json.Marshal(Votes)
+					votesEvent := createSocketEvent("user_votes", string(UserVotes), User.Id)
+					_ = c.write(websocket.TextMessage, votesEvent)
+				}
+
+				// raised hands aren't persisted so the joining user gets the current queue directly
+				handsEvent := raisedHandsEvent(hands.get(ss.arena))
+				_ = c.write(websocket.TextMessage, handsEvent)
+
+				// late joiners get the running voting timers remaining time
+				if Timer := b.resumeVoteTimer(ss.arena); Timer != nil {
+					_ = c.write(websocket.TextMessage, voteTimerEvent("timer_started", Timer))
+				}
+
 				joinedEvent := createSocketEvent("warrior_joined", string(UpdatedUsers), User.Id)
 				m := message{joinedEvent, ss.arena}
 				h.broadcast <- m
@@ -0,0 +1,61 @@
+package battle
+
+import (
+	"sync"
+	"time"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"go.uber.org/zap"
+)
+
+// eventFlushInterval how often buffered battle events are written for session replay
+const eventFlushInterval = time.Second
+
+// eventBufferSize the number of buffered battle events that triggers writing them before the next interval
+const eventBufferSize = 100
+
+// eventRecorder buffers recordable battle events so they're written in batches instead of a query per message
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []*model.BattleEvent
+}
+
+// recordEvent buffers the battle event for session replay, it's only kept when the battle has recording enabled
+func (b *Service) recordEvent(BattleID string, UserID string, EventType string, EventValue string) {
+	b.recorder.mu.Lock()
+	b.recorder.events = append(b.recorder.events, &model.BattleEvent{
+		BattleId:    BattleID,
+		UserId:      UserID,
+		Type:        EventType,
+		Value:       EventValue,
+		CreatedDate: time.Now(),
+	})
+	full := len(b.recorder.events) >= eventBufferSize
+	b.recorder.mu.Unlock()
+
+	if full {
+		go b.FlushEvents()
+	}
+}
+
+// FlushEvents writes the buffered battle events e.g. before reading a battles replay
+func (b *Service) FlushEvents() {
+	b.recorder.mu.Lock()
+	Events := b.recorder.events
+	b.recorder.events = nil
+	b.recorder.mu.Unlock()
+
+	if err := b.db.RecordBattleEvents(Events); err != nil {
+		b.logger.Error("record battle events error", zap.Int("events", len(Events)), zap.Error(err))
+	}
+}
+
+// eventFlusher periodically writes the buffered battle events, intended to be run as a goroutine
+func (b *Service) eventFlusher(Interval time.Duration) {
+	ticker := time.NewTicker(Interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		b.FlushEvents()
+	}
+}
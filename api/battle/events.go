@@ -3,6 +3,9 @@ package battle
 import (
 	"encoding/json"
 	"errors"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/db"
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
 )
 
 // UserNudge handles notifying user that they need to vote
@@ -13,28 +16,31 @@ func (b *Service) UserNudge(BattleID string, UserID string, EventValue string) (
 }
 
 // UserVote handles the participants vote event by setting their vote
-// and checks if AutoFinishVoting && AllVoted if so ends voting
+// and ends voting when the battle auto finishes voting and everyone has voted
 func (b *Service) UserVote(BattleID string, UserID string, EventValue string) ([]byte, error, bool) {
 	var msg []byte
 	var wv struct {
-		VoteValue        string `json:"voteValue"`
-		PlanID           string `json:"planId"`
-		AutoFinishVoting bool   `json:"autoFinishVoting"`
+		VoteValue string `json:"voteValue"`
+		PlanID    string `json:"planId"`
 	}
 	json.Unmarshal([]byte(EventValue), &wv)
 
-	Plans, AllVoted := b.db.SetVote(BattleID, UserID, wv.PlanID, wv.VoteValue)
+	if err := b.rejectWhenPaused(BattleID); err != nil {
+		return nil, err, false
+	}
+
+	Plans, AllVoted, err := b.db.SetVote(BattleID, UserID, wv.PlanID, wv.VoteValue)
+	if err != nil {
+		return nil, err, false
+	}
 
 	updatedPlans, _ := json.Marshal(Plans)
 	msg = createSocketEvent("vote_activity", string(updatedPlans), UserID)
 
-	if AllVoted && wv.AutoFinishVoting {
-		plans, err := b.db.EndPlanVoting(BattleID, wv.PlanID)
-		if err != nil {
-			return nil, err, false
+	if AllVoted {
+		if endedEvent := b.finishVotingWhenAllVoted(BattleID); endedEvent != nil {
+			msg = endedEvent
 		}
-		updatedPlans, _ := json.Marshal(plans)
-		msg = createSocketEvent("voting_ended", string(updatedPlans), "")
 	}
 
 	return msg, nil, false
@@ -44,6 +50,10 @@ func (b *Service) UserVote(BattleID string, UserID string, EventValue string) ([
 func (b *Service) UserVoteRetract(BattleID string, UserID string, EventValue string) ([]byte, error, bool) {
 	PlanID := EventValue
 
+	if err := b.rejectWhenPaused(BattleID); err != nil {
+		return nil, err, false
+	}
+
 	plans, err := b.db.RetractVote(BattleID, UserID, PlanID)
 	if err != nil {
 		return nil, err, false
@@ -55,6 +65,44 @@ func (b *Service) UserVoteRetract(BattleID string, UserID string, EventValue str
 	return msg, nil, false
 }
 
+// UserVoteDelegate handles a participant delegating their vote for the active plan to another participant
+func (b *Service) UserVoteDelegate(BattleID string, UserID string, EventValue string) ([]byte, error, bool) {
+	var dv struct {
+		PlanID     string `json:"planId"`
+		DelegateID string `json:"delegateId"`
+	}
+	json.Unmarshal([]byte(EventValue), &dv)
+
+	if err := b.rejectWhenPaused(BattleID); err != nil {
+		return nil, err, false
+	}
+
+	plans, err := b.db.DelegatePlanVote(BattleID, UserID, dv.PlanID, dv.DelegateID)
+	if err != nil {
+		return nil, err, false
+	}
+
+	updatedPlans, _ := json.Marshal(plans)
+	msg := createSocketEvent("vote_delegation_updated", string(updatedPlans), UserID)
+
+	return msg, nil, false
+}
+
+// UserVoteDelegationRevoke handles a participant revoking their vote delegation for the plan
+func (b *Service) UserVoteDelegationRevoke(BattleID string, UserID string, EventValue string) ([]byte, error, bool) {
+	PlanID := EventValue
+
+	plans, err := b.db.RevokePlanVoteDelegation(BattleID, UserID, PlanID)
+	if err != nil {
+		return nil, err, false
+	}
+
+	updatedPlans, _ := json.Marshal(plans)
+	msg := createSocketEvent("vote_delegation_updated", string(updatedPlans), UserID)
+
+	return msg, nil, false
+}
+
 // UserPromote handles promoting a user to a leader
 func (b *Service) UserPromote(BattleID string, UserID string, EventValue string) ([]byte, error, bool) {
 	leaders, err := b.db.SetBattleLeader(BattleID, EventValue)
@@ -109,10 +157,32 @@ func (b *Service) UserSpectatorToggle(BattleID string, UserID string, EventValue
 		Spectator bool `json:"spectator"`
 	}
 	json.Unmarshal([]byte(EventValue), &st)
-	users, err := b.db.ToggleSpectator(BattleID, UserID, st.Spectator)
+
+	return b.setSpectator(BattleID, UserID, st.Spectator)
+}
+
+// UserSpectatorSet handles the leader making a participant a spectator or a voter again
+func (b *Service) UserSpectatorSet(BattleID string, UserID string, EventValue string) ([]byte, error, bool) {
+	var st struct {
+		UserID    string `json:"warriorId"`
+		Spectator bool   `json:"spectator"`
+	}
+	json.Unmarshal([]byte(EventValue), &st)
+
+	return b.setSpectator(BattleID, st.UserID, st.Spectator)
+}
+
+// setSpectator changes the users spectator status, when their vote on the active plan
+// or their vote delegations are removed the updated plans are broadcast as well
+func (b *Service) setSpectator(BattleID string, UserID string, Spectator bool) ([]byte, error, bool) {
+	users, PlansChanged, err := b.db.ToggleSpectator(BattleID, UserID, Spectator)
 	if err != nil {
 		return nil, err, false
 	}
+	if PlansChanged {
+		updatedPlans, _ := json.Marshal(b.db.GetPlans(BattleID, ""))
+		h.broadcast <- message{createSocketEvent("vote_retracted", string(updatedPlans), UserID), BattleID}
+	}
 	usersJson, _ := json.Marshal(users)
 
 	msg := createSocketEvent("users_updated", string(usersJson), "")
@@ -128,6 +198,8 @@ func (b *Service) PlanVoteEnd(BattleID string, UserID string, EventValue string)
 	}
 	updatedPlans, _ := json.Marshal(plans)
 	msg := createSocketEvent("voting_ended", string(updatedPlans), "")
+	b.lowerHandsOnReveal(BattleID)
+	timers.stop(BattleID)
 
 	return msg, nil, false
 }
@@ -136,6 +208,7 @@ func (b *Service) PlanVoteEnd(BattleID string, UserID string, EventValue string)
 func (b *Service) Revise(BattleID string, UserID string, EventValue string) ([]byte, error, bool) {
 	var rb struct {
 		BattleName           string   `json:"battleName"`
+		PointScale           string   `json:"pointScale"`
 		PointValuesAllowed   []string `json:"pointValuesAllowed"`
 		AutoFinishVoting     bool     `json:"autoFinishVoting"`
 		PointAverageRounding string   `json:"pointAverageRounding"`
@@ -147,6 +220,7 @@ func (b *Service) Revise(BattleID string, UserID string, EventValue string) ([]b
 	err := b.db.ReviseBattle(
 		BattleID,
 		rb.BattleName,
+		rb.PointScale,
 		rb.PointValuesAllowed,
 		rb.AutoFinishVoting,
 		rb.PointAverageRounding,
@@ -158,6 +232,8 @@ func (b *Service) Revise(BattleID string, UserID string, EventValue string) ([]b
 	}
 
 	rb.LeaderCode = ""
+	// the broadcast includes the point values of preset scales
+	rb.PointValuesAllowed, rb.PointScale, _ = db.ResolvePointScale(rb.PointScale, rb.PointValuesAllowed)
 
 	updatedBattle, _ := json.Marshal(rb)
 	msg := createSocketEvent("battle_revised", string(updatedBattle), "")
@@ -165,6 +241,24 @@ func (b *Service) Revise(BattleID string, UserID string, EventValue string) ([]b
 	return msg, nil, false
 }
 
+// SetRecording handles enabling or disabling the battle session recording
+func (b *Service) SetRecording(BattleID string, UserID string, EventValue string) ([]byte, error, bool) {
+	var rb struct {
+		RecordingEnabled bool `json:"recordingEnabled"`
+	}
+	json.Unmarshal([]byte(EventValue), &rb)
+
+	err := b.db.SetBattleRecording(BattleID, rb.RecordingEnabled)
+	if err != nil {
+		return nil, err, false
+	}
+
+	updatedRecording, _ := json.Marshal(rb)
+	msg := createSocketEvent("recording_set", string(updatedRecording), "")
+
+	return msg, nil, false
+}
+
 // Delete handles deleting the battle
 func (b *Service) Delete(BattleID string, UserID string, EventValue string) ([]byte, error, bool) {
 	err := b.db.DeleteBattle(BattleID)
@@ -188,6 +282,10 @@ func (b *Service) PlanAdd(BattleID string, UserID string, EventValue string) ([]
 	}
 	json.Unmarshal([]byte(EventValue), &p)
 
+	if err := b.db.CheckBattlePlanCapacity(BattleID, 1); err != nil {
+		return nil, err, false
+	}
+
 	plans, err := b.db.CreatePlan(BattleID, p.Name, p.Type, p.ReferenceId, p.Link, p.Description, p.AcceptanceCriteria)
 	if err != nil {
 		return nil, err, false
@@ -235,16 +333,315 @@ func (b *Service) PlanDelete(BattleID string, UserID string, EventValue string)
 
 // PlanActivate handles activating a plan for voting
 func (b *Service) PlanActivate(BattleID string, UserID string, EventValue string) ([]byte, error, bool) {
+	if err := b.db.ConfirmBattleParticipants(BattleID); err != nil {
+		return nil, err, false
+	}
+
+	return b.PlanActivateOverride(BattleID, UserID, EventValue)
+}
+
+// PlanActivateOverride handles the leader starting plan voting regardless of the battles minimum participants
+func (b *Service) PlanActivateOverride(BattleID string, UserID string, EventValue string) ([]byte, error, bool) {
 	plans, err := b.db.ActivatePlanVoting(BattleID, EventValue)
 	if err != nil {
 		return nil, err, false
 	}
 	updatedPlans, _ := json.Marshal(plans)
 	msg := createSocketEvent("plan_activated", string(updatedPlans), "")
+	timers.stop(BattleID)
+
+	return msg, nil, false
+}
+
+// PlanSetCurrent handles setting the plan the battle should focus on
+func (b *Service) PlanSetCurrent(BattleID string, UserID string, EventValue string) ([]byte, error, bool) {
+	return b.focusPlan(BattleID, EventValue)
+}
+
+// PlanNext handles advancing the battle to the next plan
+func (b *Service) PlanNext(BattleID string, UserID string, EventValue string) ([]byte, error, bool) {
+	PlanID, err := b.db.GetAdjacentPlanID(BattleID, 1)
+	if err != nil {
+		return nil, err, false
+	}
+
+	return b.focusPlan(BattleID, PlanID)
+}
+
+// PlanPrevious handles moving the battle back to the previous plan
+func (b *Service) PlanPrevious(BattleID string, UserID string, EventValue string) ([]byte, error, bool) {
+	PlanID, err := b.db.GetAdjacentPlanID(BattleID, -1)
+	if err != nil {
+		return nil, err, false
+	}
+
+	return b.focusPlan(BattleID, PlanID)
+}
+
+// focusPlan sets the battle's current plan and starts voting when the battle is set to auto start voting
+func (b *Service) focusPlan(BattleID string, PlanID string) ([]byte, error, bool) {
+	AutoStartVoting, err := b.db.SetCurrentPlan(BattleID, PlanID)
+	if err != nil {
+		return nil, err, false
+	}
+
+	var currentPlan struct {
+		CurrentPlanID string        `json:"currentPlanId"`
+		VotingStarted bool          `json:"votingStarted"`
+		Plans         []*model.Plan `json:"plans"`
+	}
+	currentPlan.CurrentPlanID = PlanID
+
+	// voting isn't auto started until enough participants have joined, the leader can still start it
+	if AutoStartVoting && b.db.ConfirmBattleParticipants(BattleID) == nil {
+		plans, err := b.db.ActivatePlanVoting(BattleID, PlanID)
+		if err != nil {
+			return nil, err, false
+		}
+		currentPlan.VotingStarted = true
+		currentPlan.Plans = plans
+	} else {
+		currentPlan.Plans = b.db.GetPlans(BattleID, "")
+	}
+
+	updatedCurrentPlan, _ := json.Marshal(currentPlan)
+	msg := createSocketEvent("current_plan_set", string(updatedCurrentPlan), "")
+
+	return msg, nil, false
+}
+
+// SetAutoStartVoting handles setting whether voting starts when the current plan changes
+func (b *Service) SetAutoStartVoting(BattleID string, UserID string, EventValue string) ([]byte, error, bool) {
+	var rb struct {
+		AutoStartVoting bool `json:"autoStartVoting"`
+	}
+	json.Unmarshal([]byte(EventValue), &rb)
+
+	err := b.db.SetBattleAutoStartVoting(BattleID, rb.AutoStartVoting)
+	if err != nil {
+		return nil, err, false
+	}
+
+	updatedAutoStart, _ := json.Marshal(rb)
+	msg := createSocketEvent("auto_start_voting_set", string(updatedAutoStart), "")
+
+	return msg, nil, false
+}
+
+// SetMinParticipants handles setting the minimum active participants required before voting can start
+func (b *Service) SetMinParticipants(BattleID string, UserID string, EventValue string) ([]byte, error, bool) {
+	var rb struct {
+		MinParticipants int `json:"minParticipants"`
+	}
+	json.Unmarshal([]byte(EventValue), &rb)
+
+	if err := b.db.SetBattleMinParticipants(BattleID, rb.MinParticipants); err != nil {
+		return nil, err, false
+	}
+
+	updatedMinParticipants, _ := json.Marshal(rb)
+	msg := createSocketEvent("min_participants_set", string(updatedMinParticipants), "")
+
+	return msg, nil, false
+}
+
+// SetEstimateCap handles setting the battles own estimate cap, null falls back to its teams estimation policy
+func (b *Service) SetEstimateCap(BattleID string, UserID string, EventValue string) ([]byte, error, bool) {
+	var rb struct {
+		EstimateCap *float64 `json:"estimateCap"`
+	}
+	json.Unmarshal([]byte(EventValue), &rb)
+
+	Cap, err := b.db.SetBattleEstimateCap(BattleID, rb.EstimateCap)
+	if err != nil {
+		return nil, err, false
+	}
+
+	rb.EstimateCap = Cap
+	updatedCap, _ := json.Marshal(rb)
+	msg := createSocketEvent("estimate_cap_updated", string(updatedCap), "")
+
+	return msg, nil, false
+}
+
+// EnablePermanentAnonymity handles making the battles votes permanently anonymous,
+// revealed votes are reduced to their distribution for good so it can't be disabled
+func (b *Service) EnablePermanentAnonymity(BattleID string, UserID string, EventValue string) ([]byte, error, bool) {
+	plans, err := b.db.EnableBattlePermanentAnonymity(BattleID)
+	if err != nil {
+		return nil, err, false
+	}
+
+	updatedPlans, _ := json.Marshal(plans)
+	msg := createSocketEvent("permanent_anonymity_enabled", string(updatedPlans), "")
+
+	return msg, nil, false
+}
+
+// Pause handles pausing the battle, voting is rejected until resumed
+func (b *Service) Pause(BattleID string, UserID string, EventValue string) ([]byte, error, bool) {
+	err := b.db.SetBattlePaused(BattleID, true)
+	if err != nil {
+		return nil, err, false
+	}
+	timers.stop(BattleID)
+
+	msg := createSocketEvent("battle_paused", "", "")
+
+	return msg, nil, false
+}
+
+// Close handles closing the battle making it read only until reopened
+func (b *Service) Close(BattleID string, UserID string, EventValue string) ([]byte, error, bool) {
+	err := b.db.CloseBattle(BattleID)
+	if err != nil {
+		return nil, err, false
+	}
+
+	plans := b.db.GetPlans(BattleID, "")
+	updatedPlans, _ := json.Marshal(plans)
+	msg := createSocketEvent("battle_closed", string(updatedPlans), "")
+
+	return msg, nil, false
+}
+
+// SetState handles moving the battle to another state of its teams workflow
+func (b *Service) SetState(BattleID string, UserID string, EventValue string) ([]byte, error, bool) {
+	err := b.db.SetBattleState(BattleID, EventValue)
+	if err != nil {
+		return nil, err, false
+	}
+
+	msg := createSocketEvent("battle_state_updated", EventValue, "")
+
+	return msg, nil, false
+}
+
+// Resume handles resuming a paused battle
+func (b *Service) Resume(BattleID string, UserID string, EventValue string) ([]byte, error, bool) {
+	err := b.db.SetBattlePaused(BattleID, false)
+	if err != nil {
+		return nil, err, false
+	}
+	b.resumeVoteTimer(BattleID)
+
+	plans := b.db.GetPlans(BattleID, "")
+	updatedPlans, _ := json.Marshal(plans)
+	msg := createSocketEvent("battle_resumed", string(updatedPlans), "")
+
+	return msg, nil, false
+}
+
+// SetRequireReadyToReveal handles setting whether all participants must be ready before votes are revealed
+func (b *Service) SetRequireReadyToReveal(BattleID string, UserID string, EventValue string) ([]byte, error, bool) {
+	var rb struct {
+		RequireReadyToReveal bool `json:"requireReadyToReveal"`
+	}
+	json.Unmarshal([]byte(EventValue), &rb)
+
+	err := b.db.SetBattleRequireReadyToReveal(BattleID, rb.RequireReadyToReveal)
+	if err != nil {
+		return nil, err, false
+	}
+
+	updatedRequireReady, _ := json.Marshal(rb)
+	msg := createSocketEvent("require_ready_to_reveal_set", string(updatedRequireReady), "")
 
 	return msg, nil, false
 }
 
+// ToggleReadyToReveal handles a participant signaling ready to reveal the active plans votes,
+// revealing the votes once every active participant is ready
+func (b *Service) ToggleReadyToReveal(BattleID string, UserID string, EventValue string) ([]byte, error, bool) {
+	var rb struct {
+		Ready bool `json:"ready"`
+	}
+	json.Unmarshal([]byte(EventValue), &rb)
+
+	if err := b.rejectWhenPaused(BattleID); err != nil {
+		return nil, err, false
+	}
+
+	RequireReady, ActivePlanID, err := b.db.GetBattleRevealState(BattleID)
+	if err != nil {
+		return nil, err, false
+	}
+	if !RequireReady || ActivePlanID == "" {
+		return nil, errors.New("READY_TO_REVEAL_NOT_ACTIVE"), false
+	}
+
+	if err := b.db.SetPlanRevealReady(ActivePlanID, UserID, rb.Ready); err != nil {
+		return nil, err, false
+	}
+
+	return b.revealWhenReady(BattleID, ActivePlanID)
+}
+
+// revealWhenReady ends voting when every participant is ready otherwise returns the current readiness
+func (b *Service) revealWhenReady(BattleID string, PlanID string) ([]byte, error, bool) {
+	Readiness, err := b.db.GetPlanRevealReadiness(BattleID, PlanID)
+	if err != nil {
+		return nil, err, false
+	}
+
+	if Readiness.AllReady {
+		return b.PlanVoteEnd(BattleID, "", PlanID)
+	}
+
+	updatedReadiness, _ := json.Marshal(Readiness)
+	msg := createSocketEvent("reveal_readiness", string(updatedReadiness), "")
+
+	return msg, nil, false
+}
+
+// recomputeRevealReadiness gets the readiness event for the battles active plan when readiness is required
+func (b *Service) recomputeRevealReadiness(BattleID string) []byte {
+	RequireReady, ActivePlanID, err := b.db.GetBattleRevealState(BattleID)
+	if err != nil || !RequireReady || ActivePlanID == "" {
+		return nil
+	}
+
+	msg, err, _ := b.revealWhenReady(BattleID, ActivePlanID)
+	if err != nil {
+		return nil
+	}
+
+	return msg
+}
+
+// finishVotingWhenAllVoted ends voting on the active plan when the battle auto finishes voting
+// and every active non spectator participant has voted, returning the voting ended event or nil
+func (b *Service) finishVotingWhenAllVoted(BattleID string) []byte {
+	AutoFinishVoting, ActivePlanID, err := b.db.GetBattleAutoFinishState(BattleID)
+	if err != nil || !AutoFinishVoting || ActivePlanID == "" {
+		return nil
+	}
+
+	if AllVoted, err := b.db.PlanAllVoted(BattleID, ActivePlanID); err != nil || !AllVoted {
+		return nil
+	}
+
+	msg, err, _ := b.PlanVoteEnd(BattleID, "", ActivePlanID)
+	if err != nil {
+		return nil
+	}
+
+	return msg
+}
+
+// rejectWhenPaused returns BATTLE_PAUSED error when the battle is paused
+func (b *Service) rejectWhenPaused(BattleID string) error {
+	Paused, err := b.db.GetBattlePaused(BattleID)
+	if err != nil {
+		return err
+	}
+	if Paused {
+		return errors.New("BATTLE_PAUSED")
+	}
+
+	return nil
+}
+
 // PlanSkip handles skipping a plan voting
 func (b *Service) PlanSkip(BattleID string, UserID string, EventValue string) ([]byte, error, bool) {
 	plans, err := b.db.SkipPlan(BattleID, EventValue)
@@ -253,6 +650,7 @@ func (b *Service) PlanSkip(BattleID string, UserID string, EventValue string) ([
 	}
 	updatedPlans, _ := json.Marshal(plans)
 	msg := createSocketEvent("plan_skipped", string(updatedPlans), "")
+	timers.stop(BattleID)
 
 	return msg, nil, false
 }
@@ -275,6 +673,250 @@ func (b *Service) PlanFinalize(BattleID string, UserID string, EventValue string
 	return msg, nil, false
 }
 
+// PlanAcceptanceCriterionAdd handles adding an acceptance criteria checklist item to a plan
+func (b *Service) PlanAcceptanceCriterionAdd(BattleID string, UserID string, EventValue string) ([]byte, error, bool) {
+	var c struct {
+		PlanId  string `json:"planId"`
+		Content string `json:"content"`
+	}
+	json.Unmarshal([]byte(EventValue), &c)
+
+	plans, err := b.db.AddPlanAcceptanceCriterion(BattleID, c.PlanId, c.Content)
+	if err != nil {
+		return nil, err, false
+	}
+	updatedPlans, _ := json.Marshal(plans)
+	msg := createSocketEvent("acceptance_criteria_updated", string(updatedPlans), "")
+
+	return msg, nil, false
+}
+
+// PlanAcceptanceCriterionToggle handles checking or unchecking a plans acceptance criteria checklist item
+func (b *Service) PlanAcceptanceCriterionToggle(BattleID string, UserID string, EventValue string) ([]byte, error, bool) {
+	var c struct {
+		PlanId      string `json:"planId"`
+		CriterionId string `json:"criterionId"`
+	}
+	json.Unmarshal([]byte(EventValue), &c)
+
+	plans, err := b.db.TogglePlanAcceptanceCriterion(BattleID, c.PlanId, c.CriterionId)
+	if err != nil {
+		return nil, err, false
+	}
+	updatedPlans, _ := json.Marshal(plans)
+	msg := createSocketEvent("acceptance_criteria_updated", string(updatedPlans), "")
+
+	return msg, nil, false
+}
+
+// PlanAcceptanceCriterionRemove handles removing an acceptance criteria checklist item from a plan
+func (b *Service) PlanAcceptanceCriterionRemove(BattleID string, UserID string, EventValue string) ([]byte, error, bool) {
+	var c struct {
+		PlanId      string `json:"planId"`
+		CriterionId string `json:"criterionId"`
+	}
+	json.Unmarshal([]byte(EventValue), &c)
+
+	plans, err := b.db.RemovePlanAcceptanceCriterion(BattleID, c.PlanId, c.CriterionId)
+	if err != nil {
+		return nil, err, false
+	}
+	updatedPlans, _ := json.Marshal(plans)
+	msg := createSocketEvent("acceptance_criteria_updated", string(updatedPlans), "")
+
+	return msg, nil, false
+}
+
+// PlanMerge handles merging a duplicate plan into the primary plan
+func (b *Service) PlanMerge(BattleID string, UserID string, EventValue string) ([]byte, error, bool) {
+	var m struct {
+		PrimaryId   string `json:"primaryId"`
+		SecondaryId string `json:"secondaryId"`
+	}
+	json.Unmarshal([]byte(EventValue), &m)
+
+	plans, err := b.db.MergeBattlePlans(BattleID, m.PrimaryId, m.SecondaryId)
+	if err != nil {
+		return nil, err, false
+	}
+	updatedPlans, _ := json.Marshal(plans)
+	msg := createSocketEvent("plans_merged", string(updatedPlans), "")
+
+	return msg, nil, false
+}
+
+// PlanPollStart handles the leader asking a warm-up poll question on a plan
+func (b *Service) PlanPollStart(BattleID string, UserID string, EventValue string) ([]byte, error, bool) {
+	var p struct {
+		PlanId   string `json:"planId"`
+		Question string `json:"question"`
+	}
+	json.Unmarshal([]byte(EventValue), &p)
+
+	plans, err := b.db.StartPlanPoll(BattleID, p.PlanId, p.Question)
+	if err != nil {
+		return nil, err, false
+	}
+	updatedPlans, _ := json.Marshal(plans)
+	msg := createSocketEvent("plan_poll_updated", string(updatedPlans), "")
+
+	return msg, nil, false
+}
+
+// PlanPollRespond handles a participant responding yes, no, or unsure to a plans warm-up poll
+func (b *Service) PlanPollRespond(BattleID string, UserID string, EventValue string) ([]byte, error, bool) {
+	var p struct {
+		PlanId   string `json:"planId"`
+		Response string `json:"response"`
+	}
+	json.Unmarshal([]byte(EventValue), &p)
+
+	plans, err := b.db.RespondPlanPoll(BattleID, p.PlanId, UserID, p.Response)
+	if err != nil {
+		return nil, err, false
+	}
+	updatedPlans, _ := json.Marshal(plans)
+	msg := createSocketEvent("plan_poll_updated", string(updatedPlans), UserID)
+
+	return msg, nil, false
+}
+
+// PlanPollClose handles the leader closing a plans warm-up poll
+func (b *Service) PlanPollClose(BattleID string, UserID string, EventValue string) ([]byte, error, bool) {
+	var p struct {
+		PlanId string `json:"planId"`
+	}
+	json.Unmarshal([]byte(EventValue), &p)
+
+	plans, err := b.db.ClosePlanPoll(BattleID, p.PlanId)
+	if err != nil {
+		return nil, err, false
+	}
+	updatedPlans, _ := json.Marshal(plans)
+	msg := createSocketEvent("plan_poll_updated", string(updatedPlans), "")
+
+	return msg, nil, false
+}
+
+// ParkingLotItemAdd handles adding a tangential topic to the battles parking lot
+func (b *Service) ParkingLotItemAdd(BattleID string, UserID string, EventValue string) ([]byte, error, bool) {
+	var i struct {
+		Content string `json:"content"`
+	}
+	json.Unmarshal([]byte(EventValue), &i)
+
+	items, err := b.db.AddBattleParkingLotItem(BattleID, UserID, i.Content)
+	if err != nil {
+		return nil, err, false
+	}
+	updatedItems, _ := json.Marshal(items)
+	msg := createSocketEvent("parking_lot_updated", string(updatedItems), "")
+
+	return msg, nil, false
+}
+
+// ParkingLotItemToggle handles marking a parking lot item resolved or unresolved
+func (b *Service) ParkingLotItemToggle(BattleID string, UserID string, EventValue string) ([]byte, error, bool) {
+	var i struct {
+		ItemId string `json:"itemId"`
+	}
+	json.Unmarshal([]byte(EventValue), &i)
+
+	items, err := b.db.ToggleBattleParkingLotItem(BattleID, i.ItemId)
+	if err != nil {
+		return nil, err, false
+	}
+	updatedItems, _ := json.Marshal(items)
+	msg := createSocketEvent("parking_lot_updated", string(updatedItems), "")
+
+	return msg, nil, false
+}
+
+// ParkingLotItemRemove handles removing a parking lot item by its author or a battle leader
+func (b *Service) ParkingLotItemRemove(BattleID string, UserID string, EventValue string) ([]byte, error, bool) {
+	var i struct {
+		ItemId string `json:"itemId"`
+	}
+	json.Unmarshal([]byte(EventValue), &i)
+
+	items, err := b.db.RemoveBattleParkingLotItem(BattleID, UserID, i.ItemId)
+	if err != nil {
+		return nil, err, false
+	}
+	updatedItems, _ := json.Marshal(items)
+	msg := createSocketEvent("parking_lot_updated", string(updatedItems), "")
+
+	return msg, nil, false
+}
+
+// NoteTakerSet handles designating the battle user that can take discussion notes alongside the leaders
+func (b *Service) NoteTakerSet(BattleID string, UserID string, EventValue string) ([]byte, error, bool) {
+	err := b.db.SetBattleNoteTaker(BattleID, EventValue)
+	if err != nil {
+		return nil, err, false
+	}
+	msg := createSocketEvent("note_taker_updated", EventValue, "")
+
+	return msg, nil, false
+}
+
+// planDiscussionUpdatedEvent creates the event broadcasting a plans updated discussion transcript
+func planDiscussionUpdatedEvent(PlanID string, Entries []*model.PlanDiscussionEntry) []byte {
+	updatedDiscussion, _ := json.Marshal(map[string]interface{}{
+		"planId":     PlanID,
+		"discussion": Entries,
+	})
+
+	return createSocketEvent("plan_discussion_updated", string(updatedDiscussion), "")
+}
+
+// PlanDiscussionAdd handles appending a note to a plans discussion transcript by a leader or the note-taker
+func (b *Service) PlanDiscussionAdd(BattleID string, UserID string, EventValue string) ([]byte, error, bool) {
+	var e struct {
+		PlanId  string `json:"planId"`
+		Content string `json:"content"`
+	}
+	json.Unmarshal([]byte(EventValue), &e)
+
+	entries, err := b.db.AddPlanDiscussionEntry(BattleID, UserID, e.PlanId, e.Content)
+	if err != nil {
+		return nil, err, false
+	}
+
+	return planDiscussionUpdatedEvent(e.PlanId, entries), nil, false
+}
+
+// PlanDiscussionEdit handles editing a plan discussion transcript entry by its author or a leader
+func (b *Service) PlanDiscussionEdit(BattleID string, UserID string, EventValue string) ([]byte, error, bool) {
+	var e struct {
+		EntryId string `json:"entryId"`
+		Content string `json:"content"`
+	}
+	json.Unmarshal([]byte(EventValue), &e)
+
+	PlanID, entries, err := b.db.EditPlanDiscussionEntry(BattleID, UserID, e.EntryId, e.Content)
+	if err != nil {
+		return nil, err, false
+	}
+
+	return planDiscussionUpdatedEvent(PlanID, entries), nil, false
+}
+
+// PlanDiscussionDelete handles deleting a plan discussion transcript entry by its author or a leader
+func (b *Service) PlanDiscussionDelete(BattleID string, UserID string, EventValue string) ([]byte, error, bool) {
+	var e struct {
+		EntryId string `json:"entryId"`
+	}
+	json.Unmarshal([]byte(EventValue), &e)
+
+	PlanID, entries, err := b.db.DeletePlanDiscussionEntry(BattleID, UserID, e.EntryId)
+	if err != nil {
+		return nil, err, false
+	}
+
+	return planDiscussionUpdatedEvent(PlanID, entries), nil, false
+}
+
 // Abandon handles setting abandoned true so battle doesn't show up in users battle list, then leaves battle
 func (b *Service) Abandon(BattleID string, UserID string, EventValue string) ([]byte, error, bool) {
 	b.db.AbandonBattle(BattleID, UserID)
@@ -5,6 +5,24 @@ type message struct {
 	arena string
 }
 
+// directMessage a message for a single connection, optionally closing it afterwards
+type directMessage struct {
+	sub        subscription
+	data       []byte
+	disconnect bool
+}
+
+// eviction closes the connections of an ended session, or of a user e.g. once disabled
+type eviction struct {
+	sessionID string
+	userID    string
+}
+
+// matches whether the connection belongs to the evicted session or user
+func (e eviction) matches(c *connection) bool {
+	return (e.sessionID != "" && c.sessionID == e.sessionID) || (e.userID != "" && c.userID == e.userID)
+}
+
 type subscription struct {
 	conn   *connection
 	arena  string
@@ -25,12 +43,24 @@ type hub struct {
 
 	// Unregister requests from connections.
 	unregister chan subscription
+
+	// Evict requests closing the connections of an ended session or a user.
+	evict chan eviction
+
+	// Teardown requests sending a final message and closing all of an arenas connections.
+	teardown chan message
+
+	// Direct messages for a single connection if it's still registered.
+	direct chan directMessage
 }
 
 var h = hub{
 	broadcast:  make(chan message),
 	register:   make(chan subscription),
 	unregister: make(chan subscription),
+	evict:      make(chan eviction),
+	teardown:   make(chan message),
+	direct:     make(chan directMessage),
 	arenas:     make(map[string]map[*connection]struct{}),
 }
 
@@ -55,6 +85,49 @@ func (h *hub) run() {
 					}
 				}
 			}
+		case e := <-h.evict:
+			evictedEvent := createSocketEvent("session_evicted", "", "")
+			for arena, connections := range h.arenas {
+				for c := range connections {
+					if !e.matches(c) {
+						continue
+					}
+					select {
+					case c.send <- evictedEvent:
+					default:
+					}
+					close(c.send)
+					delete(connections, c)
+				}
+				if len(connections) == 0 {
+					delete(h.arenas, arena)
+				}
+			}
+		case m := <-h.teardown:
+			for c := range h.arenas[m.arena] {
+				select {
+				case c.send <- m.data:
+				default:
+				}
+				close(c.send)
+			}
+			delete(h.arenas, m.arena)
+		case d := <-h.direct:
+			connections := h.arenas[d.sub.arena]
+			if _, ok := connections[d.sub.conn]; !ok {
+				continue
+			}
+			select {
+			case d.sub.conn.send <- d.data:
+			default:
+			}
+			if d.disconnect {
+				close(d.sub.conn.send)
+				delete(connections, d.sub.conn)
+				if len(connections) == 0 {
+					delete(h.arenas, d.sub.arena)
+				}
+			}
 		case m := <-h.broadcast:
 			connections := h.arenas[m.arena]
 			for c := range connections {
@@ -0,0 +1,138 @@
+package battle
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+// raisedHand a participant waiting to speak
+type raisedHand struct {
+	UserID     string    `json:"warriorId"`
+	RaisedDate time.Time `json:"raisedDate"`
+}
+
+// raisedHandQueues the battles raised hands in the order they were raised, kept in memory only
+// as they are only meaningful to the participants currently in the session
+type raisedHandQueues struct {
+	mu     sync.Mutex
+	arenas map[string][]raisedHand
+}
+
+var hands = raisedHandQueues{
+	arenas: make(map[string][]raisedHand),
+}
+
+// list gets a copy of the battles raised hands queue
+func (q *raisedHandQueues) list(BattleID string) []raisedHand {
+	Queue := make([]raisedHand, len(q.arenas[BattleID]))
+	copy(Queue, q.arenas[BattleID])
+
+	return Queue
+}
+
+// toggle raises the users hand at the back of the queue or lowers it if already raised
+func (q *raisedHandQueues) toggle(BattleID string, UserID string) []raisedHand {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.remove(BattleID, UserID) {
+		q.arenas[BattleID] = append(q.arenas[BattleID], raisedHand{UserID: UserID, RaisedDate: time.Now()})
+	}
+
+	return q.list(BattleID)
+}
+
+// lower lowers the users hand, returns whether it was raised
+func (q *raisedHandQueues) lower(BattleID string, UserID string) ([]raisedHand, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	lowered := q.remove(BattleID, UserID)
+
+	return q.list(BattleID), lowered
+}
+
+// clear lowers all the battles hands, returns whether any were raised
+func (q *raisedHandQueues) clear(BattleID string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	_, raised := q.arenas[BattleID]
+	delete(q.arenas, BattleID)
+
+	return raised
+}
+
+// get gets the battles raised hands queue
+func (q *raisedHandQueues) get(BattleID string) []raisedHand {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.list(BattleID)
+}
+
+// remove removes the users hand from the queue keeping the order of the rest, must hold the lock
+func (q *raisedHandQueues) remove(BattleID string, UserID string) bool {
+	Queue := q.arenas[BattleID]
+	for i, hand := range Queue {
+		if hand.UserID == UserID {
+			Queue = append(Queue[:i], Queue[i+1:]...)
+			if len(Queue) == 0 {
+				delete(q.arenas, BattleID)
+			} else {
+				q.arenas[BattleID] = Queue
+			}
+			return true
+		}
+	}
+
+	return false
+}
+
+// raisedHandsEvent creates the event with the battles current raised hands queue
+func raisedHandsEvent(Queue []raisedHand) []byte {
+	QueueJSON, _ := json.Marshal(Queue)
+
+	return createSocketEvent("raised_hands_updated", string(QueueJSON), "")
+}
+
+// HandToggle handles a participant raising or lowering their hand to speak
+func (b *Service) HandToggle(BattleID string, UserID string, EventValue string) ([]byte, error, bool) {
+	return raisedHandsEvent(hands.toggle(BattleID, UserID)), nil, false
+}
+
+// HandLower handles the leader lowering a participants hand e.g. once they've been called on
+func (b *Service) HandLower(BattleID string, UserID string, EventValue string) ([]byte, error, bool) {
+	Queue, lowered := hands.lower(BattleID, EventValue)
+	if !lowered {
+		return nil, errors.New("HAND_NOT_RAISED"), false
+	}
+
+	return raisedHandsEvent(Queue), nil, false
+}
+
+// HandsClear handles the leader lowering all raised hands
+func (b *Service) HandsClear(BattleID string, UserID string, EventValue string) ([]byte, error, bool) {
+	hands.clear(BattleID)
+
+	return raisedHandsEvent([]raisedHand{}), nil, false
+}
+
+// lowerHandsOnReveal lowers all raised hands once a plans votes are revealed as discussion moves on
+func (b *Service) lowerHandsOnReveal(BattleID string) {
+	if hands.clear(BattleID) {
+		h.broadcast <- message{raisedHandsEvent([]raisedHand{}), BattleID}
+	}
+}
+
+// lowerLeavingUsersHand lowers the hand of a user leaving the battle so the queue only holds those present
+func (b *Service) lowerLeavingUsersHand(BattleID string, UserID string) []byte {
+	Queue, lowered := hands.lower(BattleID, UserID)
+	if !lowered {
+		return nil
+	}
+
+	return raisedHandsEvent(Queue)
+}
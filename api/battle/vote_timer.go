@@ -0,0 +1,163 @@
+package battle
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"go.uber.org/zap"
+)
+
+// voteTimers the battles running voting timers, the timers end is persisted so they're
+// scheduled again when missing e.g. after a restart once someone joins the battle
+type voteTimers struct {
+	mu     sync.Mutex
+	arenas map[string]*time.Timer
+}
+
+var timers = voteTimers{
+	arenas: make(map[string]*time.Timer),
+}
+
+// schedule runs expire once the battles timer ends, replacing any timer already scheduled
+func (t *voteTimers) schedule(BattleID string, Remaining int, expire func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if timer, ok := t.arenas[BattleID]; ok {
+		timer.Stop()
+	}
+	t.arenas[BattleID] = time.AfterFunc(time.Duration(Remaining)*time.Second, expire)
+}
+
+// scheduled whether the battle has a timer scheduled
+func (t *voteTimers) scheduled(BattleID string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_, ok := t.arenas[BattleID]
+
+	return ok
+}
+
+// stop stops the battles scheduled timer if any
+func (t *voteTimers) stop(BattleID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if timer, ok := t.arenas[BattleID]; ok {
+		timer.Stop()
+		delete(t.arenas, BattleID)
+	}
+}
+
+// voteTimerEvent creates the event with the battles voting timer
+func voteTimerEvent(EventType string, Timer *model.VoteTimer) []byte {
+	TimerJSON, _ := json.Marshal(Timer)
+
+	return createSocketEvent(EventType, string(TimerJSON), "")
+}
+
+// scheduleVoteTimer schedules the automatic reveal of the active plans votes when the timer ends
+func (b *Service) scheduleVoteTimer(BattleID string, Timer *model.VoteTimer) {
+	timers.schedule(BattleID, Timer.Remaining, func() {
+		b.expireVoteTimer(BattleID, Timer.PlanId)
+	})
+}
+
+// expireVoteTimer reveals the plans votes once its timer has ended, the timer is checked again
+// as it may have been cancelled, extended, or paused since it was scheduled
+func (b *Service) expireVoteTimer(BattleID string, PlanID string) {
+	timers.stop(BattleID)
+
+	Timer, err := b.db.GetBattleVoteTimer(BattleID)
+	if err != nil || Timer == nil || Timer.PlanId != PlanID || Timer.Paused {
+		return
+	}
+	if Timer.Remaining > 0 {
+		b.scheduleVoteTimer(BattleID, Timer)
+		return
+	}
+
+	msg, err, _ := b.PlanVoteEnd(BattleID, "", PlanID)
+	if err != nil {
+		b.logger.Error("vote timer end voting error", zap.String("battle_id", BattleID), zap.Error(err))
+		return
+	}
+	b.recordEvent(BattleID, "", "end_voting", PlanID)
+	h.broadcast <- message{msg, BattleID}
+}
+
+// VoteTimerStart handles the leader starting a countdown to reveal the active plans votes,
+// without a duration the battles default is used
+func (b *Service) VoteTimerStart(BattleID string, UserID string, EventValue string) ([]byte, error, bool) {
+	var tv struct {
+		Duration int `json:"duration"`
+	}
+	json.Unmarshal([]byte(EventValue), &tv)
+
+	Timer, err := b.db.StartPlanVoteTimer(BattleID, tv.Duration)
+	if err != nil {
+		return nil, err, false
+	}
+	b.scheduleVoteTimer(BattleID, Timer)
+
+	return voteTimerEvent("timer_started", Timer), nil, false
+}
+
+// VoteTimerExtend handles the leader adding seconds to the running voting timer
+func (b *Service) VoteTimerExtend(BattleID string, UserID string, EventValue string) ([]byte, error, bool) {
+	var tv struct {
+		Seconds int `json:"seconds"`
+	}
+	json.Unmarshal([]byte(EventValue), &tv)
+
+	Timer, err := b.db.ExtendPlanVoteTimer(BattleID, tv.Seconds)
+	if err != nil {
+		return nil, err, false
+	}
+	b.scheduleVoteTimer(BattleID, Timer)
+
+	return voteTimerEvent("timer_extended", Timer), nil, false
+}
+
+// VoteTimerCancel handles the leader cancelling the running voting timer
+func (b *Service) VoteTimerCancel(BattleID string, UserID string, EventValue string) ([]byte, error, bool) {
+	PlanID, err := b.db.CancelPlanVoteTimer(BattleID)
+	if err != nil {
+		return nil, err, false
+	}
+	timers.stop(BattleID)
+
+	return createSocketEvent("timer_cancelled", PlanID, ""), nil, false
+}
+
+// SetVoteTimerDefault handles the leader setting the duration timers run for when started without one
+func (b *Service) SetVoteTimerDefault(BattleID string, UserID string, EventValue string) ([]byte, error, bool) {
+	var tv struct {
+		Duration int `json:"duration"`
+	}
+	json.Unmarshal([]byte(EventValue), &tv)
+
+	if err := b.db.SetBattleVoteTimerDefault(BattleID, tv.Duration); err != nil {
+		return nil, err, false
+	}
+
+	updatedDefault, _ := json.Marshal(tv)
+
+	return createSocketEvent("vote_timer_default_set", string(updatedDefault), ""), nil, false
+}
+
+// resumeVoteTimer schedules the battles running voting timer when it isn't already, returning the timer
+func (b *Service) resumeVoteTimer(BattleID string) *model.VoteTimer {
+	Timer, err := b.db.GetBattleVoteTimer(BattleID)
+	if err != nil || Timer == nil {
+		return nil
+	}
+	if !Timer.Paused && !timers.scheduled(BattleID) {
+		b.scheduleVoteTimer(BattleID, Timer)
+	}
+
+	return Timer
+}
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// battleExportContentTypes the content type and file extension of each battle export format
+var battleExportContentTypes = map[string][2]string{
+	"csv":  {"text/csv; charset=utf-8", "csv"},
+	"json": {"application/json", "json"},
+}
+
+// writeBattleCSV writes the battles plans as one row per plan with the plans votes joined
+// followed by a column per voter, anonymous votes only appear in the votes column
+func writeBattleCSV(w io.Writer, Plans []*model.BattleExportPlan, Format exportFormat) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = Format.FieldDelimiter
+
+	// voters in name order so the columns are stable across exports
+	Voters := make([]*model.VoteDetail, 0)
+	VoterColumns := make(map[string]int)
+	for _, plan := range Plans {
+		for _, vote := range plan.Votes {
+			if _, ok := VoterColumns[vote.UserId]; !ok && vote.UserId != "" {
+				VoterColumns[vote.UserId] = 0
+				Voters = append(Voters, vote)
+			}
+		}
+	}
+	sort.SliceStable(Voters, func(i, j int) bool {
+		return Voters[i].UserName < Voters[j].UserName
+	})
+
+	Header := []string{"Plan", "Type", "Reference ID", "Link", "Points", "Skipped", "Votes"}
+	for i, voter := range Voters {
+		VoterColumns[voter.UserId] = len(Header) + i
+	}
+	for _, voter := range Voters {
+		Header = append(Header, voter.UserName)
+	}
+	if err := writeCSVRow(cw, Header); err != nil {
+		return err
+	}
+
+	for _, plan := range Plans {
+		Row := make([]string, len(Header))
+		Votes := make([]string, 0, len(plan.Votes))
+		for _, vote := range plan.Votes {
+			Votes = append(Votes, vote.VoteValue)
+			if Column, ok := VoterColumns[vote.UserId]; ok {
+				Row[Column] = vote.VoteValue
+			}
+		}
+		copy(Row, []string{
+			plan.Name,
+			plan.Type,
+			plan.ReferenceId,
+			plan.Link,
+			plan.Points,
+			strconv.FormatBool(plan.Skipped),
+			strings.Join(Votes, ", "),
+		})
+		if err := writeCSVRow(cw, Row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+
+	return cw.Error()
+}
+
+// handleBattleExport downloads the battles plans with their points and votes in the requested format
+// @Summary Export Battle
+// @Description Downloads the battles plans with their reference ID, final points, and individual votes as csv with a row per plan and
+// @Description a column per voter, or json. Plans without points have empty point columns and plans still being voted on have no votes.
+// @Description Only the battles leaders and users who have joined it can export.
+// @Tags battle
+// @Produce  text/csv
+// @Produce  json
+// @Param battleId path string true "the battle ID"
+// @Param format query string false "the export format csv or json, defaults to csv"
+// @Param locale query string false "the locale to use for the csv delimiter, defaults to the users locale"
+// @Success 200 {file} binary
+// @Failure 400 object standardJsonResponse{}
+// @Failure 403 object standardJsonResponse{}
+// @Failure 404 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /battles/{battleId}/export [get]
+func (a *api) handleBattleExport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		BattleID := vars["battleId"]
+		UserID := r.Context().Value(contextKeyUserID).(string)
+
+		Format := strings.ToLower(r.URL.Query().Get("format"))
+		if Format == "" {
+			Format = "csv"
+		}
+		ContentType, ok := battleExportContentTypes[Format]
+		if !ok {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, "INVALID_EXPORT_FORMAT"))
+			return
+		}
+
+		if err := a.db.ConfirmBattleUser(BattleID, UserID); err != nil {
+			if err.Error() == "BATTLE_NOT_FOUND" {
+				a.Failure(w, r, http.StatusNotFound, Errorf(ENOTFOUND, err.Error()))
+				return
+			}
+			a.Failure(w, r, http.StatusForbidden, Errorf(EUNAUTHORIZED, err.Error()))
+			return
+		}
+
+		Plans, err := a.db.GetBattlePlans(BattleID)
+		if err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", ContentType[0])
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="thunderdome-battle-%s.%s"`, BattleID, ContentType[1]))
+		w.Header().Set("Cache-Control", "no-store")
+
+		switch Format {
+		case "csv":
+			err = writeBattleCSV(w, Plans, a.getExportFormatFromRequest(r))
+		default:
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			err = enc.Encode(Plans)
+		}
+		if err != nil {
+			a.logger.Error("error writing battle export", zap.String("battle_id", BattleID), zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gorilla/securecookie"
+)
+
+// cookieKeyReloadInterval the minimum time between reloading rotated keys after a failed decode
+const cookieKeyReloadInterval = time.Minute
+
+// cookieKeyring signs cookies with the current key while verifying with older keys,
+// allowing the signing key to be rotated without invalidating existing sessions
+type cookieKeyring struct {
+	mu sync.RWMutex
+	// configKeys the configured current and previous hash keys, verified after any rotated keys
+	configKeys [][]byte
+	codecs     []securecookie.Codec
+	// reload gets the rotated keys newest first, e.g. when another instance rotated the key
+	reload     func() ([][]byte, error)
+	lastReload time.Time
+}
+
+// newCookieKeyring creates a keyring from the configured keys, current key first
+func newCookieKeyring(ConfigKeys [][]byte, reload func() ([][]byte, error)) *cookieKeyring {
+	k := &cookieKeyring{
+		configKeys: ConfigKeys,
+		reload:     reload,
+	}
+	k.setRotatedKeys(nil)
+
+	return k
+}
+
+// setRotatedKeys sets the rotated keys (newest first) ahead of the configured keys
+func (k *cookieKeyring) setRotatedKeys(RotatedKeys [][]byte) {
+	keys := append(append(make([][]byte, 0), RotatedKeys...), k.configKeys...)
+	codecs := make([]securecookie.Codec, 0, len(keys))
+	for _, key := range keys {
+		codecs = append(codecs, securecookie.New(key, nil))
+	}
+
+	k.mu.Lock()
+	k.codecs = codecs
+	k.mu.Unlock()
+}
+
+// reloadKeys reloads the rotated keys at most once per reload interval
+func (k *cookieKeyring) reloadKeys() bool {
+	k.mu.Lock()
+	if k.reload == nil || time.Since(k.lastReload) < cookieKeyReloadInterval {
+		k.mu.Unlock()
+		return false
+	}
+	k.lastReload = time.Now()
+	k.mu.Unlock()
+
+	RotatedKeys, err := k.reload()
+	if err != nil {
+		return false
+	}
+	k.setRotatedKeys(RotatedKeys)
+
+	return true
+}
+
+// Encode signs the cookie value with the current key
+func (k *cookieKeyring) Encode(name string, value interface{}) (string, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	return securecookie.EncodeMulti(name, value, k.codecs...)
+}
+
+// Decode verifies the cookie value against the current then older keys
+func (k *cookieKeyring) Decode(name string, value string, dst interface{}) error {
+	k.mu.RLock()
+	err := securecookie.DecodeMulti(name, value, dst, k.codecs...)
+	k.mu.RUnlock()
+
+	if err != nil && k.reloadKeys() {
+		k.mu.RLock()
+		err = securecookie.DecodeMulti(name, value, dst, k.codecs...)
+		k.mu.RUnlock()
+	}
+
+	return err
+}
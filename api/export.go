@@ -0,0 +1,97 @@
+package api
+
+import (
+	"encoding/csv"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportFormat the locale specific number and date formatting used in exports
+type exportFormat struct {
+	Locale           string
+	DecimalSeparator string
+	// FieldDelimiter the CSV field delimiter, semicolon when the decimal separator is a comma
+	FieldDelimiter rune
+	DateLayout     string
+}
+
+// isoExportFormat the default export format when no locale is resolvable
+var isoExportFormat = exportFormat{
+	Locale:           "",
+	DecimalSeparator: ".",
+	FieldDelimiter:   ',',
+	DateLayout:       time.RFC3339,
+}
+
+// exportLocaleFormats the export formats for the supported UI locales
+var exportLocaleFormats = map[string]exportFormat{
+	"en": {Locale: "en", DecimalSeparator: ".", FieldDelimiter: ',', DateLayout: "01/02/2006 15:04:05"},
+	"de": {Locale: "de", DecimalSeparator: ",", FieldDelimiter: ';', DateLayout: "02.01.2006 15:04:05"},
+	"es": {Locale: "es", DecimalSeparator: ",", FieldDelimiter: ';', DateLayout: "02/01/2006 15:04:05"},
+	"fr": {Locale: "fr", DecimalSeparator: ",", FieldDelimiter: ';', DateLayout: "02/01/2006 15:04:05"},
+	"pt": {Locale: "pt", DecimalSeparator: ",", FieldDelimiter: ';', DateLayout: "02/01/2006 15:04:05"},
+	"ru": {Locale: "ru", DecimalSeparator: ",", FieldDelimiter: ';', DateLayout: "02.01.2006 15:04:05"},
+}
+
+// resolveExportFormat gets the export format for the locale by exact or primary language match e.g. pt-BR,
+// defaulting to ISO formats
+func resolveExportFormat(Locale string) exportFormat {
+	Locale = strings.ToLower(strings.TrimSpace(Locale))
+	if f, ok := exportLocaleFormats[Locale]; ok {
+		return f
+	}
+	if f, ok := exportLocaleFormats[strings.Split(strings.Replace(Locale, "_", "-", 1), "-")[0]]; ok {
+		return f
+	}
+
+	return isoExportFormat
+}
+
+// getExportFormatFromRequest gets the export format from the ?locale= override
+// otherwise the requesting users profile locale
+func (a *api) getExportFormatFromRequest(r *http.Request) exportFormat {
+	if Locale := r.URL.Query().Get("locale"); Locale != "" {
+		return resolveExportFormat(Locale)
+	}
+
+	if UserID, ok := r.Context().Value(contextKeyUserID).(string); ok && UserID != "" {
+		if User, err := a.db.GetUser(UserID); err == nil {
+			return resolveExportFormat(User.Locale)
+		}
+	}
+
+	return isoExportFormat
+}
+
+// FormatDecimal formats the number with the locales decimal separator
+func (f exportFormat) FormatDecimal(Value float64, Precision int) string {
+	return strings.Replace(strconv.FormatFloat(Value, 'f', Precision, 64), ".", f.DecimalSeparator, 1)
+}
+
+// FormatDate formats the date in UTC using the locales date layout
+func (f exportFormat) FormatDate(Value time.Time) string {
+	return Value.UTC().Format(f.DateLayout)
+}
+
+// csvFormulaPrefixes the leading characters spreadsheets treat as the start of a formula
+const csvFormulaPrefixes = "=+-@\t\r"
+
+// csvSafeCell prefixes a cell that would be evaluated as a formula with a single quote
+// so user supplied values can't run formulas when the export is opened in a spreadsheet
+func csvSafeCell(Value string) string {
+	if Value != "" && strings.ContainsRune(csvFormulaPrefixes, rune(Value[0])) {
+		return "'" + Value
+	}
+	return Value
+}
+
+// writeCSVRow writes the row with each cell made safe to open in a spreadsheet
+func writeCSVRow(cw *csv.Writer, Row []string) error {
+	Cells := make([]string, len(Row))
+	for i, cell := range Row {
+		Cells[i] = csvSafeCell(cell)
+	}
+	return cw.Write(Cells)
+}
@@ -0,0 +1,174 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"github.com/gorilla/mux"
+)
+
+type featureFlagRequestBody struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Enabled     bool     `json:"enabled"`
+	Roles       []string `json:"roles"`
+	UserIds     []string `json:"userIds"`
+	Percentage  int      `json:"percentage" minimum:"0" maximum:"100"`
+}
+
+// validateFeatureFlag reads and validates the feature flag request body
+func validateFeatureFlag(r *http.Request) (*model.FeatureFlag, error) {
+	var flag = featureFlagRequestBody{}
+	body, bodyErr := ioutil.ReadAll(r.Body)
+	if bodyErr != nil {
+		return nil, Errorf(EINVALID, bodyErr.Error())
+	}
+
+	jsonErr := json.Unmarshal(body, &flag)
+	if jsonErr != nil {
+		return nil, Errorf(EINVALID, jsonErr.Error())
+	}
+
+	if flag.Name == "" || len(flag.Name) > 64 {
+		return nil, Errorf(EINVALID, "INVALID_FEATURE_FLAG_NAME")
+	}
+	if flag.Percentage < 0 || flag.Percentage > 100 {
+		return nil, Errorf(EINVALID, "INVALID_FEATURE_FLAG_PERCENTAGE")
+	}
+
+	return &model.FeatureFlag{
+		Name:        flag.Name,
+		Description: flag.Description,
+		Enabled:     flag.Enabled,
+		Roles:       flag.Roles,
+		UserIds:     flag.UserIds,
+		Percentage:  flag.Percentage,
+	}, nil
+}
+
+// handleGetFeatureFlags gets a list of feature flags
+// @Summary Get Feature Flags
+// @Description get list of feature flags and their targeting rules
+// @Tags admin
+// @Produce  json
+// @Success 200 object standardJsonResponse{data=[]model.FeatureFlag}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /admin/feature-flags [get]
+func (a *api) handleGetFeatureFlags() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		Flags, err := a.db.GetFeatureFlags()
+		if err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		a.Success(w, r, http.StatusOK, Flags, nil)
+	}
+}
+
+// handleFeatureFlagCreate creates a new feature flag
+// @Summary Create Feature Flag
+// @Description Creates a feature flag, targeting by roles, user ids, or percentage of users
+// @Tags admin
+// @Produce  json
+// @Param flag body featureFlagRequestBody true "new feature flag object"
+// @Success 200 object standardJsonResponse{data=[]model.FeatureFlag} "returns feature flags"
+// @Failure 400 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /admin/feature-flags [post]
+func (a *api) handleFeatureFlagCreate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		Flag, vErr := validateFeatureFlag(r)
+		if vErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, vErr)
+			return
+		}
+
+		err := a.db.CreateFeatureFlag(Flag)
+		if err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		Flags, err := a.db.GetFeatureFlags()
+		if err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		a.Success(w, r, http.StatusOK, Flags, nil)
+	}
+}
+
+// handleFeatureFlagUpdate updates a feature flag
+// @Summary Update Feature Flag
+// @Description Updates a feature flag
+// @Tags admin
+// @Produce  json
+// @Param flagId path string true "the feature flag ID to update"
+// @Param flag body featureFlagRequestBody true "feature flag object to update"
+// @Success 200 object standardJsonResponse{data=[]model.FeatureFlag} "returns feature flags"
+// @Failure 400 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /admin/feature-flags/{flagId} [put]
+func (a *api) handleFeatureFlagUpdate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		Flag, vErr := validateFeatureFlag(r)
+		if vErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, vErr)
+			return
+		}
+		vars := mux.Vars(r)
+		Flag.Id = vars["flagId"]
+
+		err := a.db.UpdateFeatureFlag(Flag)
+		if err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		Flags, err := a.db.GetFeatureFlags()
+		if err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		a.Success(w, r, http.StatusOK, Flags, nil)
+	}
+}
+
+// handleFeatureFlagDelete handles deleting a feature flag
+// @Summary Delete Feature Flag
+// @Description Deletes a feature flag
+// @Tags admin
+// @Produce  json
+// @Param flagId path string true "the feature flag ID to delete"
+// @Success 200 object standardJsonResponse{data=[]model.FeatureFlag} "returns feature flags"
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /admin/feature-flags/{flagId} [delete]
+func (a *api) handleFeatureFlagDelete() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		FlagID := vars["flagId"]
+
+		err := a.db.DeleteFeatureFlag(FlagID)
+		if err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		Flags, err := a.db.GetFeatureFlags()
+		if err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		a.Success(w, r, http.StatusOK, Flags, nil)
+	}
+}
@@ -0,0 +1,564 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/api/battle"
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// jiraPageSize the issues requested per page of Jira search results
+	jiraPageSize = 50
+	// maxJiraImportIssues the most issues a single JQL import creates plans for
+	maxJiraImportIssues = 200
+	// jiraMaxRetries the times a rate limited Jira request is retried
+	jiraMaxRetries = 3
+	// jiraMaxRetryWait the longest a Retry-After from Jira is waited for
+	jiraMaxRetryWait = 10 * time.Second
+	// jiraMaxResponseBytes the most of a Jira response body that is read
+	jiraMaxResponseBytes = 5 << 20
+	// jiraMaxErrorMessages the most JQL error messages from Jira returned to the user
+	jiraMaxErrorMessages = 3
+	// jiraMaxErrorMessageLength the most characters of each JQL error message returned to the user
+	jiraMaxErrorMessageLength = 200
+)
+
+// jiraBlockedNetworks the loopback, private, link-local and other non public networks Jira hosts can't resolve to
+var jiraBlockedNetworks = func() []*net.IPNet {
+	var Networks []*net.IPNet
+	for _, CIDR := range []string{
+		"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16", "172.16.0.0/12",
+		"192.0.0.0/24", "192.168.0.0/16", "198.18.0.0/15", "224.0.0.0/4", "240.0.0.0/4",
+		"::/128", "::1/128", "64:ff9b::/96", "2002::/16", "fc00::/7", "fe80::/10", "ff00::/8",
+	} {
+		_, Network, _ := net.ParseCIDR(CIDR)
+		Networks = append(Networks, Network)
+	}
+	return Networks
+}()
+
+// errJiraAddressBlocked a Jira connection was refused as the address it dialed isn't public
+var errJiraAddressBlocked = errors.New("JIRA_HOST_NOT_ALLOWED")
+
+// jiraPlanTypes the battle plan type of each Jira issue type, other issue types import as stories
+var jiraPlanTypes = map[string]string{
+	"story":    "Story",
+	"bug":      "Bug",
+	"spike":    "Spike",
+	"epic":     "Epic",
+	"task":     "Task",
+	"sub-task": "Subtask",
+	"subtask":  "Subtask",
+}
+
+// jiraClient searches Jira issues with the Jira Cloud/Server REST API using basic auth with an API token
+type jiraClient struct {
+	client *http.Client
+	// retryWait the wait before retrying a rate limited request without a Retry-After
+	retryWait time.Duration
+	// allowedHosts the hostnames that can be connected to, when empty any host resolving to a public address
+	allowedHosts []string
+}
+
+// newJiraClient creates the Jira client on a copy of the outbound HTTP client that doesn't follow redirects,
+// so a redirect can't take requests past the host checks. Without an allow list every connection is checked
+// against the address actually dialed, so a host re-resolving to an internal address (DNS rebinding) is refused,
+// which means connecting directly rather than through the outbound proxy
+func newJiraClient(client *http.Client, AllowedHosts []string) *jiraClient {
+	jiraHTTP := *client
+	jiraHTTP.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	if len(AllowedHosts) == 0 {
+		Transport, ok := client.Transport.(*http.Transport)
+		if !ok {
+			Transport = http.DefaultTransport.(*http.Transport)
+		}
+		Transport = Transport.Clone()
+		Transport.Proxy = nil
+		Transport.DialContext = (&net.Dialer{
+			Timeout:   client.Timeout,
+			KeepAlive: 30 * time.Second,
+			Control:   jiraDialControl,
+		}).DialContext
+		jiraHTTP.Transport = Transport
+	}
+
+	return &jiraClient{client: &jiraHTTP, retryWait: time.Second, allowedHosts: AllowedHosts}
+}
+
+// jiraDialControl refuses connections to addresses that aren't public, checked once resolved right before connecting
+func jiraDialControl(network string, address string, c syscall.RawConn) error {
+	Host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return errJiraAddressBlocked
+	}
+	if IP := net.ParseIP(Host); IP == nil || !jiraPublicIP(IP) {
+		return errJiraAddressBlocked
+	}
+
+	return nil
+}
+
+// jiraIssue the fields of a Jira issue imported as a plan
+type jiraIssue struct {
+	Key    string `json:"key"`
+	Fields struct {
+		Summary   string `json:"summary"`
+		IssueType struct {
+			Name string `json:"name"`
+		} `json:"issuetype"`
+	} `json:"fields"`
+}
+
+// jiraSearchResult a page of Jira search results
+type jiraSearchResult struct {
+	StartAt    int          `json:"startAt"`
+	MaxResults int          `json:"maxResults"`
+	Total      int          `json:"total"`
+	Issues     []*jiraIssue `json:"issues"`
+}
+
+// normalizeJiraHost validates the Jira base URL is https, returning it without a trailing slash
+func normalizeJiraHost(Host string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(Host))
+	if err != nil || u.Scheme != "https" || u.Host == "" || u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+		return "", errors.New("INVALID_JIRA_HOST")
+	}
+
+	return strings.TrimRight(u.String(), "/"), nil
+}
+
+// jiraPublicIP whether the IP is outside the loopback, private, link-local and other non public networks
+func jiraPublicIP(IP net.IP) bool {
+	if v4 := IP.To4(); v4 != nil {
+		IP = v4
+	}
+	for _, Network := range jiraBlockedNetworks {
+		if Network.Contains(IP) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// CheckHost makes sure the Jira base URL is one of the allowed hosts, or without an allow list,
+// that every address it currently resolves to is public, rejecting internal hosts up front
+// while every connection is still checked when dialed
+func (j *jiraClient) CheckHost(ctx context.Context, Host string) error {
+	u, err := url.Parse(Host)
+	if err != nil {
+		return errors.New("INVALID_JIRA_HOST")
+	}
+	Hostname := strings.ToLower(u.Hostname())
+
+	if len(j.allowedHosts) > 0 {
+		for _, Allowed := range j.allowedHosts {
+			if strings.ToLower(strings.TrimSpace(Allowed)) == Hostname {
+				return nil
+			}
+		}
+		return errors.New("JIRA_HOST_NOT_ALLOWED")
+	}
+
+	Addrs, err := net.DefaultResolver.LookupIPAddr(ctx, Hostname)
+	if err != nil || len(Addrs) == 0 {
+		return errors.New("JIRA_HOST_NOT_ALLOWED")
+	}
+	for _, Addr := range Addrs {
+		if !jiraPublicIP(Addr.IP) {
+			return errors.New("JIRA_HOST_NOT_ALLOWED")
+		}
+	}
+
+	return nil
+}
+
+// jiraIssuePlan converts the issue to a plan referencing and linking to it
+func jiraIssuePlan(Host string, Issue *jiraIssue) *model.Plan {
+	PlanType, ok := jiraPlanTypes[strings.ToLower(Issue.Fields.IssueType.Name)]
+	if !ok {
+		PlanType = "Story"
+	}
+
+	return &model.Plan{
+		Name:        Issue.Fields.Summary,
+		Type:        PlanType,
+		ReferenceId: Issue.Key,
+		Link:        Host + "/browse/" + url.PathEscape(Issue.Key),
+	}
+}
+
+// SearchIssues gets the issues matching the JQL up to Limit, following the search result pages
+func (j *jiraClient) SearchIssues(ctx context.Context, Host string, ClientMail string, AccessToken string, JQL string, Limit int) ([]*jiraIssue, error) {
+	if err := j.CheckHost(ctx, Host); err != nil {
+		return nil, err
+	}
+	Issues := make([]*jiraIssue, 0)
+
+	for len(Issues) < Limit {
+		PageSize := jiraPageSize
+		if Remaining := Limit - len(Issues); Remaining < PageSize {
+			PageSize = Remaining
+		}
+		query := url.Values{
+			"jql":        {JQL},
+			"startAt":    {strconv.Itoa(len(Issues))},
+			"maxResults": {strconv.Itoa(PageSize)},
+			"fields":     {"summary,issuetype"},
+		}
+
+		var Page jiraSearchResult
+		if err := j.get(ctx, Host+"/rest/api/2/search?"+query.Encode(), ClientMail, AccessToken, &Page); err != nil {
+			return nil, err
+		}
+		Issues = append(Issues, Page.Issues...)
+
+		if len(Page.Issues) == 0 || len(Issues) >= Page.Total {
+			break
+		}
+	}
+	if len(Issues) > Limit {
+		Issues = Issues[:Limit]
+	}
+
+	return Issues, nil
+}
+
+// get requests the Jira API decoding the JSON response, retrying when rate limited
+func (j *jiraClient) get(ctx context.Context, URL string, ClientMail string, AccessToken string, v interface{}) error {
+	Wait := j.retryWait
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, URL, nil)
+		if err != nil {
+			return err
+		}
+		req.SetBasicAuth(ClientMail, AccessToken)
+		req.Header.Set("Accept", "application/json")
+
+		resp, err := j.client.Do(req)
+		if err != nil {
+			if errors.Is(err, errJiraAddressBlocked) {
+				return errJiraAddressBlocked
+			}
+			return errors.New("JIRA_UNAVAILABLE")
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests && attempt < jiraMaxRetries {
+			resp.Body.Close()
+			if Seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && Seconds >= 0 {
+				Wait = time.Duration(Seconds) * time.Second
+			}
+			if Wait > jiraMaxRetryWait {
+				Wait = jiraMaxRetryWait
+			}
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(Wait):
+			}
+			Wait *= 2
+			continue
+		}
+
+		err = decodeJiraResponse(resp, v)
+		resp.Body.Close()
+
+		return err
+	}
+}
+
+// decodeJiraResponse decodes a successful Jira response mapping failures to errors
+func decodeJiraResponse(resp *http.Response, v interface{}) error {
+	body := io.LimitReader(resp.Body, jiraMaxResponseBytes)
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return errors.New("JIRA_UNAUTHORIZED")
+	case resp.StatusCode == http.StatusBadRequest:
+		// jira explains why the JQL is invalid
+		var jiraErr struct {
+			ErrorMessages []string `json:"errorMessages"`
+		}
+		if json.NewDecoder(body).Decode(&jiraErr) == nil && len(jiraErr.ErrorMessages) > 0 {
+			return fmt.Errorf("INVALID_JQL: %s", jiraErrorMessages(jiraErr.ErrorMessages))
+		}
+		return errors.New("INVALID_JQL")
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return errors.New("JIRA_RATE_LIMITED")
+	case resp.StatusCode != http.StatusOK:
+		return errors.New("JIRA_UNAVAILABLE")
+	}
+
+	return json.NewDecoder(body).Decode(v)
+}
+
+// jiraErrorMessages joins the first few JQL error messages from Jira, each truncated
+// and stripped of control characters, to be returned to the user
+func jiraErrorMessages(ErrorMessages []string) string {
+	if len(ErrorMessages) > jiraMaxErrorMessages {
+		ErrorMessages = ErrorMessages[:jiraMaxErrorMessages]
+	}
+
+	Messages := make([]string, 0, len(ErrorMessages))
+	for _, Message := range ErrorMessages {
+		Message = strings.Map(func(r rune) rune {
+			if unicode.IsControl(r) {
+				return -1
+			}
+			return r
+		}, Message)
+		if Runes := []rune(Message); len(Runes) > jiraMaxErrorMessageLength {
+			Message = string(Runes[:jiraMaxErrorMessageLength])
+		}
+		Messages = append(Messages, Message)
+	}
+
+	return strings.Join(Messages, " ")
+}
+
+type jiraInstanceRequestBody struct {
+	Host        string `json:"host" example:"https://example.atlassian.net"`
+	ClientMail  string `json:"clientMail"`
+	AccessToken string `json:"accessToken"`
+}
+
+// handleGetJiraInstance gets the users or teams Jira connection
+// @Summary Get Jira Instance
+// @Description Gets the Jira connection of the user or team, the API token is never returned
+// @Tags jira
+// @Produce  json
+// @Param userId path string false "the user ID"
+// @Param teamId path string false "the team ID"
+// @Success 200 object standardJsonResponse{data=model.JiraInstance}
+// @Failure 403 object standardJsonResponse{}
+// @Failure 404 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /users/{userId}/jira-instance [get]
+// @Router /teams/{teamId}/jira-instance [get]
+func (a *api) handleGetJiraInstance() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		Instance, err := a.db.GetJiraInstance(vars["userId"], vars["teamId"])
+		if err != nil {
+			if err.Error() == "JIRA_INSTANCE_NOT_FOUND" {
+				a.Failure(w, r, http.StatusNotFound, Errorf(ENOTFOUND, err.Error()))
+				return
+			}
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		a.Success(w, r, http.StatusOK, Instance, nil)
+	}
+}
+
+// handleSaveJiraInstance sets the users or teams Jira connection
+// @Summary Save Jira Instance
+// @Description Sets the Jira connection stories are imported from, replacing any existing one. The API token is stored encrypted.
+// @Tags jira
+// @Produce  json
+// @Param userId path string false "the user ID"
+// @Param teamId path string false "the team ID"
+// @Param instance body jiraInstanceRequestBody true "jira connection object"
+// @Success 200 object standardJsonResponse{data=model.JiraInstance}
+// @Failure 400 object standardJsonResponse{}
+// @Failure 403 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /users/{userId}/jira-instance [put]
+// @Router /teams/{teamId}/jira-instance [put]
+func (a *api) handleSaveJiraInstance() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		var j = jiraInstanceRequestBody{}
+		body, bodyErr := ioutil.ReadAll(r.Body)
+		if bodyErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, bodyErr.Error()))
+			return
+		}
+		if jsonErr := json.Unmarshal(body, &j); jsonErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, jsonErr.Error()))
+			return
+		}
+
+		Host, err := normalizeJiraHost(j.Host)
+		if err != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, err.Error()))
+			return
+		}
+		if err := a.jira.CheckHost(r.Context(), Host); err != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, err.Error()))
+			return
+		}
+		ClientMail := strings.TrimSpace(j.ClientMail)
+		if mailErr := validateUserEmail(ClientMail); mailErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, "INVALID_JIRA_CLIENT_MAIL"))
+			return
+		}
+		if strings.TrimSpace(j.AccessToken) == "" {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, "JIRA_ACCESS_TOKEN_REQUIRED"))
+			return
+		}
+
+		Instance, err := a.db.SaveJiraInstance(vars["userId"], vars["teamId"], Host, ClientMail, strings.TrimSpace(j.AccessToken))
+		if err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		a.Success(w, r, http.StatusOK, Instance, nil)
+	}
+}
+
+// handleDeleteJiraInstance removes the users or teams Jira connection
+// @Summary Delete Jira Instance
+// @Description Removes the Jira connection of the user or team
+// @Tags jira
+// @Produce  json
+// @Param userId path string false "the user ID"
+// @Param teamId path string false "the team ID"
+// @Success 200 object standardJsonResponse{}
+// @Failure 403 object standardJsonResponse{}
+// @Failure 404 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /users/{userId}/jira-instance [delete]
+// @Router /teams/{teamId}/jira-instance [delete]
+func (a *api) handleDeleteJiraInstance() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		if err := a.db.DeleteJiraInstance(vars["userId"], vars["teamId"]); err != nil {
+			if err.Error() == "JIRA_INSTANCE_NOT_FOUND" {
+				a.Failure(w, r, http.StatusNotFound, Errorf(ENOTFOUND, err.Error()))
+				return
+			}
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		a.Success(w, r, http.StatusOK, nil, nil)
+	}
+}
+
+type battleImportJiraRequestBody struct {
+	JQL string `json:"jql" example:"project = TD AND sprint in futureSprints()"`
+	// TeamID imports with the teams Jira connection instead of the users own
+	TeamID string `json:"teamId"`
+}
+
+// handleBattleImportJira imports the Jira issues matching a JQL query as battle plans
+// @Summary Import Battle Plans from Jira
+// @Description Creates a plan for each Jira issue matching the JQL query (up to 200) with its key, summary and a link,
+// @Description using the users own Jira connection or that of a team they belong to. Issues the battle already has are skipped.
+// @Description Only battle leaders can import.
+// @Tags battle
+// @Produce  json
+// @Param battleId path string true "the battle ID"
+// @Param import body battleImportJiraRequestBody true "jira import object"
+// @Success 200 object standardJsonResponse{data=[]model.Plan}
+// @Failure 400 object standardJsonResponse{}
+// @Failure 403 object standardJsonResponse{}
+// @Failure 404 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Failure 502 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /battles/{battleId}/plans/jira [post]
+func (a *api) handleBattleImportJira(b *battle.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		BattleID := vars["battleId"]
+		UserID := r.Context().Value(contextKeyUserID).(string)
+
+		if err := a.db.ConfirmLeader(BattleID, UserID); err != nil {
+			a.Failure(w, r, http.StatusForbidden, Errorf(EUNAUTHORIZED, "REQUIRES_BATTLE_LEADER"))
+			return
+		}
+
+		var ji = battleImportJiraRequestBody{}
+		body, bodyErr := ioutil.ReadAll(r.Body)
+		if bodyErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, bodyErr.Error()))
+			return
+		}
+		if jsonErr := json.Unmarshal(body, &ji); jsonErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, jsonErr.Error()))
+			return
+		}
+		if strings.TrimSpace(ji.JQL) == "" {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, "JQL_REQUIRED"))
+			return
+		}
+		if ji.TeamID != "" {
+			if _, err := a.db.TeamUserRole(UserID, ji.TeamID); err != nil {
+				a.Failure(w, r, http.StatusForbidden, Errorf(EUNAUTHORIZED, "REQUIRES_TEAM_USER"))
+				return
+			}
+		}
+
+		Instance, AccessToken, err := a.db.GetJiraInstanceToken(UserID, ji.TeamID)
+		if err != nil {
+			if err.Error() == "JIRA_INSTANCE_NOT_FOUND" {
+				a.Failure(w, r, http.StatusNotFound, Errorf(ENOTFOUND, err.Error()))
+				return
+			}
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		Issues, err := a.jira.SearchIssues(r.Context(), Instance.Host, Instance.ClientMail, AccessToken, ji.JQL, maxJiraImportIssues)
+		if err != nil {
+			if strings.HasPrefix(err.Error(), "INVALID_JQL") || err.Error() == "JIRA_HOST_NOT_ALLOWED" {
+				a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, err.Error()))
+				return
+			}
+			a.Failure(w, r, http.StatusBadGateway, Errorf(EINTERNAL, err.Error()))
+			return
+		}
+
+		Plans := make([]*model.Plan, 0, len(Issues))
+		for _, Issue := range Issues {
+			Plans = append(Plans, jiraIssuePlan(Instance.Host, Issue))
+		}
+		if err := a.db.CheckBattlePlanCapacity(BattleID, len(Plans)); err != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, err.Error()))
+			return
+		}
+
+		BattlePlans, Imported, err := a.db.ImportPlans(BattleID, Plans)
+		if err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		if Imported > 0 {
+			updatedPlans, _ := json.Marshal(BattlePlans)
+			b.BroadcastEvent(BattleID, "plan_added", string(updatedPlans))
+		}
+
+		a.Success(w, r, http.StatusOK, BattlePlans, nil)
+	}
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// loginAttemptLimiter tracks failed login attempts per key (client ip) in memory over a sliding window
+type loginAttemptLimiter struct {
+	mu        sync.Mutex
+	window    time.Duration
+	attempts  map[string][]time.Time
+	lastPrune time.Time
+}
+
+func newLoginAttemptLimiter(Window time.Duration) *loginAttemptLimiter {
+	return &loginAttemptLimiter{
+		window:   Window,
+		attempts: make(map[string][]time.Time),
+	}
+}
+
+// recent drops the keys attempts outside the window, must be called holding the lock
+func (l *loginAttemptLimiter) recent(Key string, Now time.Time) []time.Time {
+	attempts := l.attempts[Key]
+	i := 0
+	for i < len(attempts) && Now.Sub(attempts[i]) >= l.window {
+		i++
+	}
+	attempts = attempts[i:]
+	if len(attempts) == 0 {
+		delete(l.attempts, Key)
+	} else {
+		l.attempts[Key] = attempts
+	}
+
+	return attempts
+}
+
+// Count gets the keys failed attempts within the window
+func (l *loginAttemptLimiter) Count(Key string, Now time.Time) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return len(l.recent(Key, Now))
+}
+
+// Last gets the keys most recent attempt within the window, zero when there isn't one
+func (l *loginAttemptLimiter) Last(Key string, Now time.Time) time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	attempts := l.recent(Key, Now)
+	if len(attempts) == 0 {
+		return time.Time{}
+	}
+
+	return attempts[len(attempts)-1]
+}
+
+// Record records a failed attempt for the key, pruning keys without recent attempts once per window
+func (l *loginAttemptLimiter) Record(Key string, Now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.attempts[Key] = append(l.recent(Key, Now), Now)
+
+	if Now.Sub(l.lastPrune) >= l.window {
+		for k := range l.attempts {
+			l.recent(k, Now)
+		}
+		l.lastPrune = Now
+	}
+}
+
+// loginLockedOut checks whether the email or client ip has too many failed logins within the lockout window
+func (a *api) loginLockedOut(r *http.Request, Email string) bool {
+	if a.config.LoginLockoutIPThreshold > 0 &&
+		a.loginAttempts.Count(a.requestRemoteIP(r), time.Now()) >= a.config.LoginLockoutIPThreshold {
+		return true
+	}
+
+	if a.config.LoginLockoutThreshold > 0 {
+		Count, err := a.db.GetFailedLoginCount(Email, a.loginAttempts.window)
+		if err == nil && Count >= a.config.LoginLockoutThreshold {
+			return true
+		}
+	}
+
+	return false
+}
+
+// recordLoginAttempt records a failed login for the email and client ip, a successful login resets the emails failed logins
+func (a *api) recordLoginAttempt(r *http.Request, Email string, Success bool) {
+	if Success {
+		if a.config.LoginLockoutThreshold > 0 {
+			_ = a.db.ResetFailedLogins(Email)
+		}
+		return
+	}
+
+	if a.config.LoginLockoutIPThreshold > 0 {
+		a.loginAttempts.Record(a.requestRemoteIP(r), time.Now())
+	}
+	if a.config.LoginLockoutThreshold > 0 {
+		_ = a.db.RecordFailedLogin(Email)
+	}
+}
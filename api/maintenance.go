@@ -2,7 +2,10 @@ package api
 
 import (
 	"net/http"
+	"strconv"
 
+	"github.com/StevenWeathers/thunderdome-planning-poker/api/battle"
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
 )
@@ -99,6 +102,29 @@ func (a *api) handleCleanGuests() http.HandlerFunc {
 	}
 }
 
+// handleCleanDeletedUserEmails handles purging old deleted account emails (ADMIN Manaually Triggered)
+// @Summary Clean Deleted User Emails
+// @Description Purges emails of accounts deleted more than {config.cleanup_deleted_emails_days_old} days ago allowing them to be registered again
+// @Tags maintenance
+// @Produce  json
+// @Success 200 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /maintenance/clean-deleted-emails [delete]
+func (a *api) handleCleanDeletedUserEmails() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		DaysOld := viper.GetInt("config.cleanup_deleted_emails_days_old")
+
+		err := a.db.PurgeDeletedUserEmails(DaysOld)
+		if err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		a.Success(w, r, http.StatusOK, nil, nil)
+	}
+}
+
 // handleLowercaseUserEmails handles lowercasing any user emails that have any uppercase letters
 // @Summary Lowercase User Emails
 // @Description Lowercases any user emails that have uppercase letters to prevent duplicate email registration
@@ -118,7 +144,8 @@ func (a *api) handleLowercaseUserEmails() http.HandlerFunc {
 
 		a.logger.Info("Lowercased user emails", zap.Int("count", len(lowercasedUsers)))
 		for _, u := range lowercasedUsers {
-			a.email.SendEmailUpdate(u.Name, u.Email)
+			Quiet, _ := a.db.GetUserQuietHoursByEmail(u.Email)
+			a.email.SendEmailUpdate(u.Name, u.Email, Quiet)
 		}
 
 		mergedUsers, err := a.db.MergeDuplicateAccounts()
@@ -129,9 +156,76 @@ func (a *api) handleLowercaseUserEmails() http.HandlerFunc {
 
 		a.logger.Info("Merged user accounts", zap.Int("count", len(mergedUsers)))
 		for _, u := range mergedUsers {
-			a.email.SendMergedUpdate(u.Name, u.Email)
+			Quiet, _ := a.db.GetUserQuietHoursByEmail(u.Email)
+			a.email.SendMergedUpdate(u.Name, u.Email, Quiet)
 		}
 
 		a.Success(w, r, http.StatusOK, nil, nil)
 	}
 }
+
+type staleBattlesResponse struct {
+	DryRun    bool            `json:"dryRun"`
+	Count     int             `json:"count"`
+	BattleIDs []string        `json:"battleIds"`
+	Battles   []*model.Battle `json:"battles"`
+}
+
+// handleBulkCloseStaleBattles handles closing battles inactive beyond a threshold (ADMIN Manually Triggered)
+// @Summary Close Stale Battles
+// @Description Closes all open battles inactive for more than daysInactive days (defaults to {config.cleanup_battles_days_old}) disconnecting their clients,
+// @Description dryRun lists the battles that would be closed without closing them
+// @Tags maintenance
+// @Produce  json
+// @Param daysInactive query int false "days since the battles last activity"
+// @Param dryRun query boolean false "only list the battles that would be closed"
+// @Success 200 object standardJsonResponse{data=staleBattlesResponse}
+// @Failure 400 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /maintenance/close-stale-battles [post]
+func (a *api) handleBulkCloseStaleBattles(b *battle.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		DaysInactive := viper.GetInt("config.cleanup_battles_days_old")
+		if days := query.Get("daysInactive"); days != "" {
+			d, err := strconv.Atoi(days)
+			if err != nil || d < 1 {
+				a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, "INVALID_DAYS_INACTIVE"))
+				return
+			}
+			DaysInactive = d
+		}
+		DryRun, _ := strconv.ParseBool(query.Get("dryRun"))
+
+		Battles, err := a.db.GetStaleBattles(DaysInactive)
+		if err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		Result := &staleBattlesResponse{
+			DryRun:    DryRun,
+			BattleIDs: make([]string, 0),
+			Battles:   make([]*model.Battle, 0),
+		}
+		for _, Battle := range Battles {
+			if !DryRun {
+				if err := a.db.CloseBattle(Battle.Id); err != nil {
+					a.logger.Error("error closing stale battle", zap.String("battle_id", Battle.Id), zap.Error(err))
+					continue
+				}
+				b.TeardownBattle(Battle.Id)
+			}
+			Result.BattleIDs = append(Result.BattleIDs, Battle.Id)
+			Result.Battles = append(Result.Battles, Battle)
+		}
+		Result.Count = len(Result.BattleIDs)
+
+		if !DryRun {
+			a.logger.Info("Closed stale battles", zap.Int("count", Result.Count), zap.Int("days_inactive", DaysInactive))
+		}
+
+		a.Success(w, r, http.StatusOK, Result, nil)
+	}
+}
@@ -0,0 +1,196 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/db"
+	"github.com/gorilla/mux"
+)
+
+// mfaIssuer the issuer shown in authenticator apps
+const mfaIssuer = "Thunderdome"
+
+type mfaSetupResponse struct {
+	Secret string `json:"secret"`
+	// URL the otpauth URL to render as a QR code for authenticator apps
+	URL string `json:"url"`
+}
+
+type mfaEnableRequestBody struct {
+	Secret   string `json:"secret"`
+	Passcode string `json:"passcode"`
+	// Password the users current password, required unless re-enrolling with CurrentPasscode
+	Password string `json:"password"`
+	// CurrentPasscode a passcode or recovery code for the users current MFA when re-enrolling
+	CurrentPasscode string `json:"currentPasscode"`
+}
+
+type mfaDisableRequestBody struct {
+	// Password the users current password, required unless a Passcode is given
+	Password string `json:"password"`
+	// Passcode a current passcode or recovery code
+	Passcode string `json:"passcode"`
+}
+
+type mfaEnableResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// mfaOTPAuthURL builds the otpauth URL used to add the TOTP secret to an authenticator app
+func mfaOTPAuthURL(AccountName string, Secret string) string {
+	params := url.Values{}
+	params.Set("secret", Secret)
+	params.Set("issuer", mfaIssuer)
+
+	return "otpauth://totp/" + url.PathEscape(mfaIssuer+":"+AccountName) + "?" + params.Encode()
+}
+
+// handleUserMFASetup generates a new TOTP secret for the user to add to their authenticator app
+// @Summary Setup User MFA
+// @Description Generates a TOTP secret to add to an authenticator app, MFA isn't enabled until confirmed with a passcode
+// @Tags user
+// @Produce  json
+// @Param userId path string true "the user ID"
+// @Success 200 object standardJsonResponse{data=mfaSetupResponse}
+// @Failure 403 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /users/{userId}/mfa/setup [post]
+func (a *api) handleUserMFASetup() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		User, err := a.db.GetUser(vars["userId"])
+		if err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		Secret, err := db.NewMFASecret()
+		if err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		a.Success(w, r, http.StatusOK, &mfaSetupResponse{
+			Secret: Secret,
+			URL:    mfaOTPAuthURL(User.Email, Secret),
+		}, nil)
+	}
+}
+
+// handleUserEnableMFA enables TOTP two-factor authentication for the user
+// @Summary Enable User MFA
+// @Description Enables MFA once the passcode from the authenticator app confirms the secret, returns single use recovery codes that are only shown once.
+// @Description Requires the users password, or when re-enrolling a passcode or recovery code for their current MFA.
+// @Tags user
+// @Produce  json
+// @Param userId path string true "the user ID"
+// @Param mfa body mfaEnableRequestBody true "the secret from setup and a passcode"
+// @Success 200 object standardJsonResponse{data=mfaEnableResponse}
+// @Failure 400 object standardJsonResponse{}
+// @Failure 403 object standardJsonResponse{}
+// @Failure 429 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /users/{userId}/mfa [post]
+func (a *api) handleUserEnableMFA() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		var m = mfaEnableRequestBody{}
+		body, bodyErr := ioutil.ReadAll(r.Body)
+		if bodyErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, bodyErr.Error()))
+			return
+		}
+
+		jsonErr := json.Unmarshal(body, &m)
+		if jsonErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, jsonErr.Error()))
+			return
+		}
+
+		if !a.confirmMFAChange(w, r, vars["userId"], m.Password, m.CurrentPasscode) {
+			return
+		}
+
+		RecoveryCodes, err := a.db.UserEnableMFA(vars["userId"], m.Secret, m.Passcode)
+		if err != nil {
+			if err.Error() == "INVALID_MFA_PASSCODE" {
+				a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, err.Error()))
+				return
+			}
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		a.Success(w, r, http.StatusOK, &mfaEnableResponse{RecoveryCodes: RecoveryCodes}, nil)
+	}
+}
+
+// handleUserDisableMFA disables two-factor authentication for the user
+// @Summary Disable User MFA
+// @Description Disables MFA removing the TOTP secret and recovery codes, requires the users password or a passcode or recovery code
+// @Tags user
+// @Produce  json
+// @Param userId path string true "the user ID"
+// @Param mfa body mfaDisableRequestBody true "the password or a passcode"
+// @Success 200 object standardJsonResponse{}
+// @Failure 400 object standardJsonResponse{}
+// @Failure 403 object standardJsonResponse{}
+// @Failure 429 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /users/{userId}/mfa [delete]
+func (a *api) handleUserDisableMFA() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		var m = mfaDisableRequestBody{}
+		body, bodyErr := ioutil.ReadAll(r.Body)
+		if bodyErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, bodyErr.Error()))
+			return
+		}
+
+		jsonErr := json.Unmarshal(body, &m)
+		if jsonErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, jsonErr.Error()))
+			return
+		}
+
+		if !a.confirmMFAChange(w, r, vars["userId"], m.Password, m.Passcode) {
+			return
+		}
+
+		if err := a.db.UserDisableMFA(vars["userId"]); err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		a.Success(w, r, http.StatusOK, nil, nil)
+	}
+}
+
+// confirmMFAChange re-authenticates the user before their MFA is changed so a hijacked session or leaked
+// api key can't remove the second factor, writes the failure response and returns false when rejected
+func (a *api) confirmMFAChange(w http.ResponseWriter, r *http.Request, UserID string, Password string, Passcode string) bool {
+	if err := a.db.ConfirmMFAChange(UserID, Password, Passcode); err != nil {
+		if err.Error() == "INVALID_MFA_REAUTH" {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, err.Error()))
+			return false
+		}
+		if err.Error() == "MFA_LOCKED" {
+			a.Failure(w, r, http.StatusTooManyRequests, Errorf(EINVALID, "TOO_MANY_ATTEMPTS"))
+			return false
+		}
+		a.Failure(w, r, http.StatusInternalServerError, err)
+		return false
+	}
+
+	return true
+}
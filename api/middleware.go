@@ -1,11 +1,18 @@
 package api
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"io/ioutil"
 	"net/http"
 	"strings"
+	"time"
 
+	"github.com/StevenWeathers/thunderdome-planning-poker/db"
 	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
 
 	"github.com/gorilla/mux"
 )
@@ -17,6 +24,14 @@ func (a *api) userOnly(h http.HandlerFunc) http.HandlerFunc {
 		apiKey = strings.TrimSpace(apiKey)
 		var User *model.User
 
+		// team api keys authenticate as a team service principal instead of a user
+		if apiKey != "" && a.config.ExternalAPIEnabled == true && strings.HasPrefix(apiKey, db.TeamAPIKeyPrefix) {
+			if TeamAPIKey, err := a.db.GetTeamAPIKey(apiKey); err == nil {
+				a.teamAPIKeyOnly(TeamAPIKey, h)(w, r)
+				return
+			}
+		}
+
 		if apiKey != "" && a.config.ExternalAPIEnabled == true {
 			var apiKeyErr error
 			User, apiKeyErr = a.db.GetApiKeyUser(apiKey)
@@ -34,13 +49,23 @@ func (a *api) userOnly(h http.HandlerFunc) http.HandlerFunc {
 			if SessionId != "" {
 				var userErr error
 				User, userErr = a.db.GetSessionUser(SessionId)
+				if userErr != nil && (userErr.Error() == "USER_DISABLED" || userErr.Error() == "SESSION_IDLE_TIMEOUT") {
+					a.clearUserCookies(w)
+					a.Failure(w, r, http.StatusUnauthorized, Errorf(EUNAUTHORIZED, userErr.Error()))
+					return
+				}
 				if userErr != nil {
 					a.Failure(w, r, http.StatusUnauthorized, Errorf(EINVALID, "INVALID_USER"))
 					return
 				}
+				_ = a.db.TouchSession(SessionId)
 			} else {
 				UserID, err := a.validateUserCookie(w, r)
 				if err != nil {
+					if err.Error() == "GUEST_SESSION_EXPIRED" {
+						a.Failure(w, r, http.StatusUnauthorized, Errorf(EUNAUTHORIZED, err.Error()))
+						return
+					}
 					a.Failure(w, r, http.StatusUnauthorized, Errorf(EINVALID, "INVALID_USER"))
 					return
 				}
@@ -61,6 +86,65 @@ func (a *api) userOnly(h http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// teamAPIKeyOnly validates that the team api key request is for the owning team's resources and within the key's scopes
+func (a *api) teamAPIKeyOnly(TeamAPIKey *model.TeamAPIKey, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		TeamID := vars["teamId"]
+
+		a.logger.Info("team apikey request",
+			zap.String("apikey_prefix", TeamAPIKey.Prefix),
+			zap.String("team_id", TeamAPIKey.TeamId),
+			zap.String("method", r.Method),
+			zap.String("url_path", sanitizeUserInputForLogs(r.URL.Path)),
+		)
+
+		if TeamID == "" || TeamID != TeamAPIKey.TeamId {
+			a.Failure(w, r, http.StatusForbidden, Errorf(EUNAUTHORIZED, "TEAM_APIKEY_OUT_OF_SCOPE"))
+			return
+		}
+
+		if !teamAPIKeyAllowsMethod(TeamAPIKey.Scopes, r.Method) {
+			a.Failure(w, r, http.StatusForbidden, Errorf(EUNAUTHORIZED, "TEAM_APIKEY_SCOPE_REQUIRED"))
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), contextKeyUserID, "")
+		ctx = context.WithValue(ctx, contextKeyUserType, teamAPIKeyUserType)
+		ctx = context.WithValue(ctx, contextKeyTeamAPIKey, TeamAPIKey)
+
+		h(w, r.WithContext(ctx))
+	}
+}
+
+// teamAPIKeyAllowsMethod checks whether the team api key scopes allow the request method,
+// read scope allows safe methods while write (or admin) scope allows all methods
+func teamAPIKeyAllowsMethod(Scopes []string, Method string) bool {
+	for _, scope := range Scopes {
+		switch scope {
+		case "write", "admin":
+			return true
+		case "read":
+			if Method == http.MethodGet || Method == http.MethodHead {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// teamAPIKeyRole gets the team role of the team api key service principal based on its scopes
+func teamAPIKeyRole(TeamAPIKey *model.TeamAPIKey) string {
+	for _, scope := range TeamAPIKey.Scopes {
+		if scope == "admin" {
+			return "ADMIN"
+		}
+	}
+
+	return "MEMBER"
+}
+
 // entityUserOnly validates that the request was made by the session user matching the {userId} of the entity (or ADMIN)
 func (a *api) entityUserOnly(h http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -78,6 +162,158 @@ func (a *api) entityUserOnly(h http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// guestCapabilityOnly middleware checks if the user is a guest that isn't allowed the capability, otherwise continue
+func (a *api) guestCapabilityOnly(capability string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		UserType := r.Context().Value(contextKeyUserType).(string)
+
+		if UserType == guestUserType && !a.config.GuestCapabilities[capability] {
+			a.Failure(w, r, http.StatusForbidden, Errorf(EUNAUTHORIZED, guestNotAllowedError(capability)))
+			return
+		}
+
+		h(w, r)
+	}
+}
+
+// createCooldownOnly middleware checks that the user hasn't created a battle, retro, or storyboard within the cooldown, admins and team api keys are exempt
+func (a *api) createCooldownOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		UserID := r.Context().Value(contextKeyUserID).(string)
+		UserType := r.Context().Value(contextKeyUserType).(string)
+
+		if a.config.CreateCooldown > 0 && UserType != adminUserType && UserType != teamAPIKeyUserType {
+			LastCreated, err := a.db.GetLastCreateTime(UserID)
+			if err != nil {
+				a.Failure(w, r, http.StatusInternalServerError, err)
+				return
+			}
+
+			if time.Since(LastCreated) < time.Duration(a.config.CreateCooldown)*time.Second {
+				a.Failure(w, r, http.StatusTooManyRequests, Errorf(EINVALID, "CREATE_COOLDOWN"))
+				return
+			}
+		}
+
+		h(w, r)
+	}
+}
+
+// quickBattleOnly middleware checks quick battles are enabled, authenticating existing users as usual
+// otherwise creating a guest user to become the quick battles leader
+func (a *api) quickBattleOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !a.config.AllowQuickBattles || !viper.GetBool("config.allow_guests") {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, "QUICK_BATTLES_DISABLED"))
+			return
+		}
+
+		_, sessionErr := r.Cookie(a.config.SessionCookieName)
+		_, userErr := r.Cookie(a.config.SecureCookieName)
+		if r.Header.Get(apiKeyHeaderName) != "" || sessionErr == nil || userErr == nil {
+			a.userOnly(h)(w, r)
+			return
+		}
+
+		// every request without cookies gets a new guest so the create limits are applied per client ip instead
+		ClientIP := a.requestRemoteIP(r)
+		Now := time.Now()
+		if a.config.QuickBattleIPLimit > 0 && a.quickBattleCreates.Count(ClientIP, Now) >= a.config.QuickBattleIPLimit {
+			a.Failure(w, r, http.StatusTooManyRequests, Errorf(EINVALID, "QUICK_BATTLE_LIMIT"))
+			return
+		}
+		if a.config.CreateCooldown > 0 && Now.Sub(a.quickBattleCreates.Last(ClientIP, Now)) < time.Duration(a.config.CreateCooldown)*time.Second {
+			a.Failure(w, r, http.StatusTooManyRequests, Errorf(EINVALID, "CREATE_COOLDOWN"))
+			return
+		}
+
+		body, bodyErr := ioutil.ReadAll(r.Body)
+		if bodyErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, bodyErr.Error()))
+			return
+		}
+
+		var u = guestUserCreateRequestBody{}
+		if err := json.Unmarshal(body, &u); err != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, err.Error()))
+			return
+		}
+
+		// the guest is an account like any other so needs the same CAPTCHA as creating one directly
+		if !a.captchaGate(w, r, u.CaptchaToken) {
+			return
+		}
+
+		if nameErr := a.validateJoinName(u.Name); nameErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, nameErr.Error()))
+			return
+		}
+
+		User, err := a.db.CreateUserGuest(u.Name)
+		if err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		a.quickBattleCreates.Record(ClientIP, Now)
+
+		if err := a.createUserCookie(w, User.Id); err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, Errorf(EINVALID, "INVALID_COOKIE"))
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), contextKeyUserID, User.Id)
+		ctx = context.WithValue(ctx, contextKeyUserType, User.Type)
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		h(w, r.WithContext(ctx))
+	}
+}
+
+// guestNotAllowedError gets the feature specific error for a guest capability e.g. GUEST_NOT_ALLOWED_CREATE_BATTLE
+func guestNotAllowedError(capability string) string {
+	return "GUEST_NOT_ALLOWED_" + strings.ToUpper(strings.TrimPrefix(capability, "can_"))
+}
+
+// guestSessionExpiry gets when the guest users session expires, false for registered users or an unlimited lifetime,
+// the remaining lifetime comes from the database as created_date has no timezone
+func (a *api) guestSessionExpiry(User *model.User) (time.Time, bool) {
+	if User.Type != guestUserType || a.config.GuestMaxSessionLifetime <= 0 {
+		return time.Time{}, false
+	}
+
+	Remaining, err := a.db.GetGuestSessionRemaining(User.Id, a.config.GuestMaxSessionLifetime)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return time.Now().Add(Remaining), true
+}
+
+// guestSessionExpired deletes the guest along with their uploaded avatar once they've exceeded the max session lifetime,
+// returning whether they had
+func (a *api) guestSessionExpired(UserID string) bool {
+	if a.config.GuestMaxSessionLifetime <= 0 {
+		return false
+	}
+
+	Remaining, err := a.db.GetGuestSessionRemaining(UserID, a.config.GuestMaxSessionLifetime)
+	if err != nil || Remaining > 0 {
+		return false
+	}
+
+	User, err := a.db.GetGuestUser(UserID)
+	if err != nil {
+		return false
+	}
+	if err := a.db.DeleteUser(UserID); err != nil {
+		a.logger.Error("error deleting expired guest user", zap.Error(err))
+	} else {
+		a.deleteUploadedAvatar(UserID, User.Avatar)
+	}
+
+	return true
+}
+
 // adminOnly middleware checks if the user is an admin, otherwise reject their request
 func (a *api) adminOnly(h http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -321,6 +557,14 @@ func (a *api) teamUserOnly(h http.HandlerFunc) http.HandlerFunc {
 		UserType := r.Context().Value(contextKeyUserType).(string)
 		TeamID := vars["teamId"]
 
+		if UserType == teamAPIKeyUserType {
+			TeamAPIKey := r.Context().Value(contextKeyTeamAPIKey).(*model.TeamAPIKey)
+			ctx := context.WithValue(r.Context(), contextKeyTeamRole, teamAPIKeyRole(TeamAPIKey))
+
+			h(w, r.WithContext(ctx))
+			return
+		}
+
 		Role, UserErr := a.db.TeamUserRole(UserID, TeamID)
 		if UserType != adminUserType && UserErr != nil {
 			a.Failure(w, r, http.StatusForbidden, Errorf(EUNAUTHORIZED, "REQUIRES_TEAM_USER"))
@@ -341,6 +585,19 @@ func (a *api) teamAdminOnly(h http.HandlerFunc) http.HandlerFunc {
 		UserType := r.Context().Value(contextKeyUserType).(string)
 		TeamID := vars["teamId"]
 
+		if UserType == teamAPIKeyUserType {
+			TeamAPIKey := r.Context().Value(contextKeyTeamAPIKey).(*model.TeamAPIKey)
+			Role := teamAPIKeyRole(TeamAPIKey)
+			if Role != "ADMIN" {
+				a.Failure(w, r, http.StatusForbidden, Errorf(EUNAUTHORIZED, "REQUIRES_TEAM_ADMIN"))
+				return
+			}
+			ctx := context.WithValue(r.Context(), contextKeyTeamRole, Role)
+
+			h(w, r.WithContext(ctx))
+			return
+		}
+
 		Role, UserErr := a.db.TeamUserRole(UserID, TeamID)
 		if UserType != adminUserType && UserErr != nil {
 			a.Failure(w, r, http.StatusForbidden, Errorf(EUNAUTHORIZED, "REQUIRES_TEAM_USER"))
@@ -0,0 +1,430 @@
+package api
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// oidcScopes the scopes requested from the OpenID Connect provider
+	oidcScopes = "openid email profile"
+	// oidcStateCookieName the cookie holding the pending logins state and nonce
+	oidcStateCookieName = "oidc_state"
+	// oidcStateTTL how long the user has to complete the providers login
+	oidcStateTTL = 10 * time.Minute
+	// oidcLoginPath the path of the OpenID Connect login and callback the state cookie is scoped to
+	oidcLoginPath = "/api/auth/oidc"
+	// oidcMaxResponseSize the max size in bytes read from a provider response
+	oidcMaxResponseSize = 1 << 20
+	// oidcKeysRefetchInterval the min time between key set fetches for unknown signing keys
+	oidcKeysRefetchInterval = time.Minute
+)
+
+// oidcDiscovery the parts of the providers discovery document used for the authorization code flow
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcClaims the verified ID token claims
+type oidcClaims struct {
+	Issuer            string       `json:"iss"`
+	Audience          oidcAudience `json:"aud"`
+	Expiry            int64        `json:"exp"`
+	Nonce             string       `json:"nonce"`
+	Email             string       `json:"email"`
+	EmailVerified     *bool        `json:"email_verified"`
+	Name              string       `json:"name"`
+	PreferredUsername string       `json:"preferred_username"`
+}
+
+// oidcAudience the ID token aud claim which is either a single string or an array
+type oidcAudience []string
+
+func (a *oidcAudience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = oidcAudience{single}
+		return nil
+	}
+
+	var multiple []string
+	if err := json.Unmarshal(b, &multiple); err != nil {
+		return err
+	}
+	*a = multiple
+
+	return nil
+}
+
+// oidcAuthState the state and nonce of a pending login stored in a short-lived signed cookie
+type oidcAuthState struct {
+	State     string
+	Nonce     string
+	ExpiresAt time.Time
+}
+
+// oidcProvider performs the OpenID Connect authorization code flow against the issuers discovered endpoints
+type oidcProvider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	client       *http.Client
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]*rsa.PublicKey
+	// keysFetchedAt when the key set was last fetched, limiting refetches for tokens with unknown key IDs
+	keysFetchedAt time.Time
+}
+
+// newOIDCProvider creates the provider, discovery is deferred until the first login
+func newOIDCProvider(Issuer string, ClientID string, ClientSecret string, RedirectURL string, Client *http.Client) (*oidcProvider, error) {
+	if Issuer == "" || ClientID == "" || RedirectURL == "" {
+		return nil, errors.New("oidc issuer, client_id and redirect_url are required")
+	}
+
+	return &oidcProvider{
+		issuer:       strings.TrimSuffix(Issuer, "/"),
+		clientID:     ClientID,
+		clientSecret: ClientSecret,
+		redirectURL:  RedirectURL,
+		client:       Client,
+		keys:         make(map[string]*rsa.PublicKey),
+	}, nil
+}
+
+// getJSON fetches a JSON document from the provider
+func (p *oidcProvider) getJSON(URL string, dst interface{}) error {
+	resp, err := p.client.Get(URL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("unexpected oidc provider response " + resp.Status)
+	}
+
+	return decodeOIDCResponse(resp.Body, dst)
+}
+
+// decodeOIDCResponse decodes a provider response body up to oidcMaxResponseSize
+func decodeOIDCResponse(body io.Reader, dst interface{}) error {
+	return json.NewDecoder(io.LimitReader(body, oidcMaxResponseSize)).Decode(dst)
+}
+
+// getDiscovery gets the providers discovery document, cached after the first successful fetch
+func (p *oidcProvider) getDiscovery() (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	var discovery oidcDiscovery
+	if err := p.getJSON(p.issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != p.issuer {
+		return nil, errors.New("oidc discovery issuer mismatch")
+	}
+	p.discovery = &discovery
+
+	return p.discovery, nil
+}
+
+// AuthCodeURL gets the providers authorization URL to redirect the user to
+func (p *oidcProvider) AuthCodeURL(State string, Nonce string) (string, error) {
+	discovery, err := p.getDiscovery()
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{
+		"response_type": {"code"},
+		"client_id":     {p.clientID},
+		"redirect_uri":  {p.redirectURL},
+		"scope":         {oidcScopes},
+		"state":         {State},
+		"nonce":         {Nonce},
+	}
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return discovery.AuthorizationEndpoint + separator + params.Encode(), nil
+}
+
+// Exchange exchanges the authorization code for the raw ID token
+func (p *oidcProvider) Exchange(Code string) (string, error) {
+	discovery, err := p.getDiscovery()
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {Code},
+		"redirect_uri": {p.redirectURL},
+	}
+	req, err := http.NewRequest("POST", discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(p.clientID), url.QueryEscape(p.clientSecret))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var token struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	if err := decodeOIDCResponse(resp.Body, &token); err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK || token.IDToken == "" {
+		return "", errors.New("oidc token exchange failed " + token.Error)
+	}
+
+	return token.IDToken, nil
+}
+
+// parseJWK parses an RSA JSON web key
+func parseJWK(N string, E string) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(E)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}, nil
+}
+
+// getKey gets the providers signing key by ID, refetching the key set for unknown (rotated) keys
+// at most once every oidcKeysRefetchInterval so forged key IDs can't make every login hit the provider
+func (p *oidcProvider) getKey(KeyID string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	key, ok := p.keys[KeyID]
+	recentlyFetched := !p.keysFetchedAt.IsZero() && time.Since(p.keysFetchedAt) < oidcKeysRefetchInterval
+	if !ok && !recentlyFetched {
+		p.keysFetchedAt = time.Now()
+	}
+	p.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	if recentlyFetched {
+		return nil, errors.New("unknown oidc signing key")
+	}
+
+	discovery, err := p.getDiscovery()
+	if err != nil {
+		return nil, err
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(discovery.JWKSURI, &jwks); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		if parsed, err := parseJWK(k.N, k.E); err == nil {
+			keys[k.Kid] = parsed
+		}
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+
+	if key, ok := keys[KeyID]; ok {
+		return key, nil
+	}
+
+	return nil, errors.New("unknown oidc signing key")
+}
+
+// VerifyIDToken verifies the RS256 signed ID tokens signature and claims, returning the claims
+func (p *oidcProvider) VerifyIDToken(RawToken string, Nonce string, Now time.Time) (*oidcClaims, error) {
+	parts := strings.Split(RawToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed id token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(headerJSON, &header) != nil {
+		return nil, errors.New("malformed id token")
+	}
+	if header.Alg != "RS256" {
+		return nil, errors.New("unsupported id token algorithm " + header.Alg)
+	}
+
+	key, err := p.getKey(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed id token")
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, errors.New("invalid id token signature")
+	}
+
+	var claims oidcClaims
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(claimsJSON, &claims) != nil {
+		return nil, errors.New("malformed id token")
+	}
+
+	if err := validateOIDCClaims(&claims, p.issuer, p.clientID, Nonce, Now); err != nil {
+		return nil, err
+	}
+
+	return &claims, nil
+}
+
+// validateOIDCClaims validates the ID tokens issuer, audience, expiry and nonce, and that it has a verified email
+func validateOIDCClaims(Claims *oidcClaims, Issuer string, ClientID string, Nonce string, Now time.Time) error {
+	if strings.TrimSuffix(Claims.Issuer, "/") != Issuer {
+		return errors.New("invalid id token issuer")
+	}
+	if !contains(Claims.Audience, ClientID) {
+		return errors.New("invalid id token audience")
+	}
+	if Now.Unix() >= Claims.Expiry {
+		return errors.New("expired id token")
+	}
+	if Nonce == "" || Claims.Nonce != Nonce {
+		return errors.New("invalid id token nonce")
+	}
+	// an email without the email_verified claim isn't trusted as it would log in to the existing account using it
+	if Claims.Email == "" || Claims.EmailVerified == nil || !*Claims.EmailVerified {
+		return errors.New("id token missing verified email")
+	}
+
+	return nil
+}
+
+// oidcUserName gets the users name from the name claim falling back to their username or email
+func oidcUserName(Claims *oidcClaims) string {
+	Name := strings.TrimSpace(Claims.Name)
+	if Name == "" {
+		Name = strings.TrimSpace(Claims.PreferredUsername)
+	}
+	if Name == "" {
+		Name = strings.Split(Claims.Email, "@")[0]
+	}
+	if r := []rune(Name); len(r) > 64 {
+		Name = string(r[:64])
+	}
+
+	return Name
+}
+
+// randomOIDCValue generates a random state or nonce
+func randomOIDCValue() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// oidcStateCookie builds the state cookie scoped to the providers login path, SameSite lax as the
+// callback is a cross site redirect from the provider
+func (a *api) oidcStateCookie(Path string, Value string, MaxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     oidcStateCookieName,
+		Value:    Value,
+		Path:     a.config.PathPrefix + Path,
+		HttpOnly: true,
+		Domain:   a.config.AppDomain,
+		MaxAge:   MaxAge,
+		Secure:   a.config.SecureCookieFlag,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// createOIDCStateCookie stores the pending logins state and nonce in a signed cookie
+func (a *api) createOIDCStateCookie(w http.ResponseWriter, Path string, State string, Nonce string) error {
+	encoded, err := a.cookie.Encode(oidcStateCookieName, &oidcAuthState{
+		State:     State,
+		Nonce:     Nonce,
+		ExpiresAt: time.Now().Add(oidcStateTTL),
+	})
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, a.oidcStateCookie(Path, encoded, int(oidcStateTTL.Seconds())))
+
+	return nil
+}
+
+// validateOIDCStateCookie gets the pending logins state and nonce, clearing the cookie so it can only be used once
+func (a *api) validateOIDCStateCookie(w http.ResponseWriter, r *http.Request, Path string) (*oidcAuthState, error) {
+	cookie, err := r.Cookie(oidcStateCookieName)
+	if err != nil {
+		return nil, errors.New("NO_OIDC_STATE_COOKIE")
+	}
+	http.SetCookie(w, a.oidcStateCookie(Path, "", -1))
+
+	var AuthState oidcAuthState
+	if err := a.cookie.Decode(oidcStateCookieName, cookie.Value, &AuthState); err != nil {
+		return nil, errors.New("INVALID_OIDC_STATE_COOKIE")
+	}
+	if AuthState.State == "" || time.Now().After(AuthState.ExpiresAt) {
+		return nil, errors.New("INVALID_OIDC_STATE_COOKIE")
+	}
+
+	return &AuthState, nil
+}
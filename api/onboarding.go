@@ -0,0 +1,133 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// onboardingStepCreateBattle the user created their first battle
+	onboardingStepCreateBattle = "create_battle"
+	// onboardingStepInviteTeammate the user added someone to a team
+	onboardingStepInviteTeammate = "invite_teammate"
+)
+
+// buildOnboardingState builds the users onboarding checklist from the configured steps,
+// returning nil once the checklist is dismissed or every step is complete
+func buildOnboardingState(Steps []string, CompletedSteps []string, Dismissed bool) *model.OnboardingState {
+	if Dismissed || len(Steps) == 0 {
+		return nil
+	}
+
+	State := &model.OnboardingState{Steps: make([]*model.OnboardingStep, 0, len(Steps))}
+	allComplete := true
+	for _, step := range Steps {
+		completed := contains(CompletedSteps, step)
+		allComplete = allComplete && completed
+		State.Steps = append(State.Steps, &model.OnboardingStep{Name: step, Completed: completed})
+	}
+	if allComplete {
+		return nil
+	}
+
+	return State
+}
+
+// getOnboardingState gets the users onboarding checklist, nil when there is nothing to show
+func (a *api) getOnboardingState(UserID string) *model.OnboardingState {
+	CompletedSteps, Dismissed, err := a.db.GetOnboardingState(UserID)
+	if err != nil {
+		return nil
+	}
+
+	return buildOnboardingState(a.config.OnboardingSteps, CompletedSteps, Dismissed)
+}
+
+// completeOnboardingStep marks the users onboarding step complete, clearing the checklist once every step is complete
+func (a *api) completeOnboardingStep(UserID string, Step string) error {
+	if !contains(a.config.OnboardingSteps, Step) {
+		return nil
+	}
+
+	CompletedSteps, Dismissed, err := a.db.GetOnboardingState(UserID)
+	if err != nil {
+		return err
+	}
+	if Dismissed || contains(CompletedSteps, Step) {
+		return nil
+	}
+
+	CompletedSteps = append(CompletedSteps, Step)
+	if buildOnboardingState(a.config.OnboardingSteps, CompletedSteps, false) == nil {
+		return a.db.UpdateOnboardingState(UserID, []string{}, true)
+	}
+
+	return a.db.UpdateOnboardingState(UserID, CompletedSteps, false)
+}
+
+type onboardingUpdateRequestBody struct {
+	// Step the onboarding step to mark complete
+	Step string `json:"step"`
+	// Dismiss hides the onboarding checklist
+	Dismiss bool `json:"dismiss"`
+}
+
+// handleUpdateOnboarding marks a users onboarding step complete or dismisses the checklist
+// @Summary Update User Onboarding
+// @Description Marks an onboarding checklist step complete or dismisses the checklist, the checklist is cleared once every step is complete
+// @Tags user
+// @Produce  json
+// @Param userId path string true "the user ID"
+// @Param onboarding body onboardingUpdateRequestBody true "the step to complete or dismiss"
+// @Success 200 object standardJsonResponse{data=model.OnboardingState}
+// @Failure 400 object standardJsonResponse{}
+// @Failure 403 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /users/{userId}/onboarding [put]
+func (a *api) handleUpdateOnboarding() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		UserID := vars["userId"]
+
+		var u = onboardingUpdateRequestBody{}
+		body, bodyErr := ioutil.ReadAll(r.Body)
+		if bodyErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, bodyErr.Error()))
+			return
+		}
+
+		jsonErr := json.Unmarshal(body, &u)
+		if jsonErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, jsonErr.Error()))
+			return
+		}
+
+		if u.Dismiss {
+			CompletedSteps, _, err := a.db.GetOnboardingState(UserID)
+			if err != nil {
+				a.Failure(w, r, http.StatusInternalServerError, err)
+				return
+			}
+			if err := a.db.UpdateOnboardingState(UserID, CompletedSteps, true); err != nil {
+				a.Failure(w, r, http.StatusInternalServerError, err)
+				return
+			}
+		} else {
+			if !contains(a.config.OnboardingSteps, u.Step) {
+				a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, "INVALID_ONBOARDING_STEP"))
+				return
+			}
+			if err := a.completeOnboardingStep(UserID, u.Step); err != nil {
+				a.Failure(w, r, http.StatusInternalServerError, err)
+				return
+			}
+		}
+
+		a.Success(w, r, http.StatusOK, a.getOnboardingState(UserID), nil)
+	}
+}
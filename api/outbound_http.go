@@ -0,0 +1,19 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/httpclient"
+)
+
+// newOutboundHTTPClient creates the HTTP client shared by outbound integration calls (OIDC, CAPTCHA),
+// requests go through the configured proxy or otherwise the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment
+func newOutboundHTTPClient(config *Config) (*http.Client, error) {
+	return httpclient.New(httpclient.Config{
+		Proxy:                 config.HTTPClientProxy,
+		Timeout:               time.Duration(config.HTTPClientTimeout) * time.Second,
+		CACertFile:            config.HTTPClientCACertFile,
+		TLSInsecureSkipVerify: config.HTTPClientTLSInsecureSkipVerify,
+	})
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	_ "embed"
+	"errors"
+	"strings"
+	"unicode"
+)
+
+// maxPasswordLength bcrypt only uses the first 72 bytes of a password
+const maxPasswordLength = 72
+
+//go:embed common_passwords.txt
+var commonPasswordList string
+
+// commonPasswords the bundled common passwords rejected regardless of policy, lower cased
+var commonPasswords = func() map[string]struct{} {
+	passwords := make(map[string]struct{})
+	for _, p := range strings.Split(commonPasswordList, "\n") {
+		if p = strings.TrimSpace(p); p != "" {
+			passwords[strings.ToLower(p)] = struct{}{}
+		}
+	}
+	return passwords
+}()
+
+// passwordPolicy the password strength rules
+type passwordPolicy struct {
+	MinLength        int
+	RequireSymbol    bool
+	RequireNumber    bool
+	RequireMixedCase bool
+}
+
+// defaultPasswordPolicy the minimum policy when none is configured
+var defaultPasswordPolicy = passwordPolicy{MinLength: 6}
+
+// passwordPolicy gets the configured password strength policy
+func (a *api) passwordPolicy() passwordPolicy {
+	return passwordPolicy{
+		MinLength:        a.config.PasswordMinLength,
+		RequireSymbol:    a.config.PasswordRequireSymbol,
+		RequireNumber:    a.config.PasswordRequireNumber,
+		RequireMixedCase: a.config.PasswordRequireMixedCase,
+	}
+}
+
+// validatePasswordStrength checks the password against the policy returning the first rule it fails
+// e.g. PASSWORD_TOO_SHORT so the UI can show which rule failed
+func validatePasswordStrength(Password string, Policy passwordPolicy) error {
+	MinLength := Policy.MinLength
+	if MinLength < 1 {
+		MinLength = 1
+	}
+	if len([]rune(Password)) < MinLength {
+		return errors.New("PASSWORD_TOO_SHORT")
+	}
+	if len(Password) > maxPasswordLength {
+		return errors.New("PASSWORD_TOO_LONG")
+	}
+
+	var hasUpper, hasLower, hasNumber, hasSymbol bool
+	for _, c := range Password {
+		switch {
+		case unicode.IsUpper(c):
+			hasUpper = true
+		case unicode.IsLower(c):
+			hasLower = true
+		case unicode.IsDigit(c):
+			hasNumber = true
+		case unicode.IsPunct(c) || unicode.IsSymbol(c) || unicode.IsSpace(c):
+			hasSymbol = true
+		}
+	}
+
+	if Policy.RequireMixedCase && !(hasUpper && hasLower) {
+		return errors.New("PASSWORD_REQUIRES_MIXED_CASE")
+	}
+	if Policy.RequireNumber && !hasNumber {
+		return errors.New("PASSWORD_REQUIRES_NUMBER")
+	}
+	if Policy.RequireSymbol && !hasSymbol {
+		return errors.New("PASSWORD_REQUIRES_SYMBOL")
+	}
+	if _, common := commonPasswords[strings.ToLower(Password)]; common {
+		return errors.New("PASSWORD_TOO_COMMON")
+	}
+
+	return nil
+}
@@ -43,6 +43,12 @@ type connection struct {
 
 	// Buffered channel of outbound messages.
 	send chan []byte
+
+	// sessionID the authenticated users session, empty for guests
+	sessionID string
+
+	// userID the connected user
+	userID string
 }
 
 // readPump pumps messages from the websocket connection to the hub.
@@ -196,6 +202,8 @@ func (b *Service) ServeWs() http.HandlerFunc {
 				b.handleSocketClose(ws, 4001, "unauthorized")
 				return
 			}
+			c.sessionID = SessionId
+			_ = b.db.TouchSession(SessionId)
 		} else {
 			UserID, err := b.validateUserCookie(w, r)
 			if err != nil {
@@ -211,6 +219,8 @@ func (b *Service) ServeWs() http.HandlerFunc {
 			}
 		}
 
+		c.userID = User.Id
+
 		// make sure retro is legit
 		retro, retroErr := b.db.RetroGet(retroID)
 		if retroErr != nil {
@@ -5,6 +5,17 @@ type message struct {
 	arena string
 }
 
+// eviction closes the connections of an ended session, or of a user e.g. once disabled
+type eviction struct {
+	sessionID string
+	userID    string
+}
+
+// matches whether the connection belongs to the evicted session or user
+func (e eviction) matches(c *connection) bool {
+	return (e.sessionID != "" && c.sessionID == e.sessionID) || (e.userID != "" && c.userID == e.userID)
+}
+
 type subscription struct {
 	conn   *connection
 	arena  string
@@ -25,12 +36,16 @@ type hub struct {
 
 	// Unregister requests from connections.
 	unregister chan subscription
+
+	// Evict requests closing the connections of an ended session or a user.
+	evict chan eviction
 }
 
 var h = hub{
 	broadcast:  make(chan message),
 	register:   make(chan subscription),
 	unregister: make(chan subscription),
+	evict:      make(chan eviction),
 	arenas:     make(map[string]map[*connection]struct{}),
 }
 
@@ -55,6 +70,24 @@ func (h *hub) run() {
 					}
 				}
 			}
+		case e := <-h.evict:
+			evictedEvent := createSocketEvent("session_evicted", "", "")
+			for arena, connections := range h.arenas {
+				for c := range connections {
+					if !e.matches(c) {
+						continue
+					}
+					select {
+					case c.send <- evictedEvent:
+					default:
+					}
+					close(c.send)
+					delete(connections, c)
+				}
+				if len(connections) == 0 {
+					delete(h.arenas, arena)
+				}
+			}
 		case m := <-h.broadcast:
 			connections := h.arenas[m.arena]
 			for c := range connections {
@@ -22,6 +22,7 @@ func New(
 	logger *zap.Logger,
 	validateSessionCookie func(w http.ResponseWriter, r *http.Request) (string, error),
 	validateUserCookie func(w http.ResponseWriter, r *http.Request) (string, error),
+	checkOrigin func(r *http.Request) bool,
 ) *Service {
 	rs := &Service{
 		db:                    db,
@@ -46,7 +47,27 @@ func New(
 		"abandon_retro":       rs.Abandon,
 	}
 
+	upgrader.CheckOrigin = checkOrigin
+
 	go h.run()
 
 	return rs
 }
+
+// EvictSession notifies and disconnects retro clients connected with the ended session
+func (b *Service) EvictSession(SessionID string) {
+	if SessionID == "" {
+		return
+	}
+
+	h.evict <- eviction{sessionID: SessionID}
+}
+
+// EvictUser notifies and disconnects the users retro clients e.g. once they're disabled
+func (b *Service) EvictUser(UserID string) {
+	if UserID == "" {
+		return
+	}
+
+	h.evict <- eviction{userID: UserID}
+}
@@ -0,0 +1,213 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// githubLoginPath the path of the GitHub login and callback the state cookie is scoped to
+	githubLoginPath = "/api/auth/github"
+	// googleLoginPath the path of the Google login and callback the state cookie is scoped to
+	googleLoginPath = "/api/auth/google"
+)
+
+// socialIdentity the users identity as reported by the social login provider
+type socialIdentity struct {
+	Email         string
+	EmailVerified bool
+	Name          string
+	Username      string
+}
+
+// socialProvider performs the OAuth2 authorization code flow against a provider with well known endpoints
+// that doesn't issue verifiable ID tokens, the users identity is instead fetched from its API
+type socialProvider struct {
+	authURL      string
+	tokenURL     string
+	scopes       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	client       *http.Client
+	// identity fetches the users identity with the access token
+	identity func(p *socialProvider, AccessToken string) (*socialIdentity, error)
+}
+
+// githubEmail one of the users email addresses from the GitHub API
+type githubEmail struct {
+	Email    string `json:"email"`
+	Primary  bool   `json:"primary"`
+	Verified bool   `json:"verified"`
+}
+
+// githubAPIURL the GitHub REST API the users identity is fetched from
+var githubAPIURL = "https://api.github.com"
+
+// googleUserInfoURL the Google OpenID Connect userinfo endpoint
+var googleUserInfoURL = "https://openidconnect.googleapis.com/v1/userinfo"
+
+// newGithubProvider creates the GitHub login provider
+func newGithubProvider(ClientID string, ClientSecret string, RedirectURL string, Client *http.Client) (*socialProvider, error) {
+	if ClientID == "" || ClientSecret == "" || RedirectURL == "" {
+		return nil, errors.New("github client_id, client_secret and redirect_url are required")
+	}
+
+	return &socialProvider{
+		authURL:      "https://github.com/login/oauth/authorize",
+		tokenURL:     "https://github.com/login/oauth/access_token",
+		scopes:       "read:user user:email",
+		clientID:     ClientID,
+		clientSecret: ClientSecret,
+		redirectURL:  RedirectURL,
+		client:       Client,
+		identity:     githubIdentity,
+	}, nil
+}
+
+// newGoogleProvider creates the Google login provider
+func newGoogleProvider(ClientID string, ClientSecret string, RedirectURL string, Client *http.Client) (*socialProvider, error) {
+	if ClientID == "" || ClientSecret == "" || RedirectURL == "" {
+		return nil, errors.New("google client_id, client_secret and redirect_url are required")
+	}
+
+	return &socialProvider{
+		authURL:      "https://accounts.google.com/o/oauth2/v2/auth",
+		tokenURL:     "https://oauth2.googleapis.com/token",
+		scopes:       "openid email profile",
+		clientID:     ClientID,
+		clientSecret: ClientSecret,
+		redirectURL:  RedirectURL,
+		client:       Client,
+		identity:     googleIdentity,
+	}, nil
+}
+
+// AuthCodeURL gets the providers authorization URL to redirect the user to
+func (p *socialProvider) AuthCodeURL(State string) string {
+	params := url.Values{
+		"response_type": {"code"},
+		"client_id":     {p.clientID},
+		"redirect_uri":  {p.redirectURL},
+		"scope":         {p.scopes},
+		"state":         {State},
+	}
+
+	return p.authURL + "?" + params.Encode()
+}
+
+// Exchange exchanges the authorization code for an access token
+func (p *socialProvider) Exchange(Code string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {Code},
+		"redirect_uri":  {p.redirectURL},
+		"client_id":     {p.clientID},
+		"client_secret": {p.clientSecret},
+	}
+	req, err := http.NewRequest("POST", p.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	// GitHub responds form encoded unless asked for JSON
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK || token.AccessToken == "" {
+		return "", errors.New("oauth2 token exchange failed " + token.Error)
+	}
+
+	return token.AccessToken, nil
+}
+
+// getJSON fetches a JSON document from the providers API with the access token
+func (p *socialProvider) getJSON(URL string, AccessToken string, dst interface{}) error {
+	req, err := http.NewRequest("GET", URL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+AccessToken)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("unexpected oauth2 provider response " + resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(dst)
+}
+
+// githubPrimaryEmail gets the users primary email from their GitHub emails, along with whether GitHub verified it
+func githubPrimaryEmail(Emails []githubEmail) (string, bool) {
+	for _, e := range Emails {
+		if e.Primary {
+			return e.Email, e.Verified
+		}
+	}
+
+	return "", false
+}
+
+// githubIdentity fetches the users profile and primary email from the GitHub API,
+// the profiles public email isn't used as it may be unverified or not set at all
+func githubIdentity(p *socialProvider, AccessToken string) (*socialIdentity, error) {
+	var profile struct {
+		Login string `json:"login"`
+		Name  string `json:"name"`
+	}
+	if err := p.getJSON(githubAPIURL+"/user", AccessToken, &profile); err != nil {
+		return nil, err
+	}
+
+	var Emails []githubEmail
+	if err := p.getJSON(githubAPIURL+"/user/emails", AccessToken, &Emails); err != nil {
+		return nil, err
+	}
+	Email, Verified := githubPrimaryEmail(Emails)
+
+	return &socialIdentity{
+		Email:         Email,
+		EmailVerified: Verified,
+		Name:          profile.Name,
+		Username:      profile.Login,
+	}, nil
+}
+
+// googleIdentity fetches the users email and name from the Google userinfo endpoint
+func googleIdentity(p *socialProvider, AccessToken string) (*socialIdentity, error) {
+	var userInfo struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Name          string `json:"name"`
+	}
+	if err := p.getJSON(googleUserInfoURL, AccessToken, &userInfo); err != nil {
+		return nil, err
+	}
+
+	return &socialIdentity{
+		Email:         userInfo.Email,
+		EmailVerified: userInfo.EmailVerified,
+		Name:          userInfo.Name,
+	}, nil
+}
@@ -7,16 +7,19 @@ import (
 	"io/ioutil"
 	"net/http"
 	"strconv"
+	"strings"
 )
 
 type storyboardCreateRequestBody struct {
-	StoryboardName string `json:"storyboardName"`
-	JoinCode       string `json:"joinCode"`
+	StoryboardName     string `json:"storyboardName"`
+	JoinCode           string `json:"joinCode"`
+	PointValuesAllowed []int  `json:"pointValuesAllowed"`
+	TemplateID         string `json:"templateId"`
 }
 
 // handleStoryboardCreate handles creating a storyboard (arena)
 // @Summary Create Storyboard
-// @Description Create a storyboard associated to the user
+// @Description Create a storyboard associated to the user, pre-populated with the goals and columns of the template when a templateId is given
 // @Tags storyboard
 // @Produce  json
 // @Param userId path string true "the user ID"
@@ -25,7 +28,9 @@ type storyboardCreateRequestBody struct {
 // @Param teamId path string false "the team ID"
 // @Param storyboard body storyboardCreateRequestBody false "new storyboard object"
 // @Success 200 object standardJsonResponse{data=model.Storyboard}
+// @Failure 400 object standardJsonResponse{}
 // @Failure 403 object standardJsonResponse{}
+// @Failure 404 object standardJsonResponse{}
 // @Failure 500 object standardJsonResponse{}
 // @Security ApiKeyAuth
 // @Router /users/{userId}/storyboards [post]
@@ -51,8 +56,22 @@ func (a *api) handleStoryboardCreate() http.HandlerFunc {
 			return
 		}
 
-		newStoryboard, err := a.db.CreateStoryboard(UserID, s.StoryboardName, s.JoinCode)
+		var newStoryboard *model.Storyboard
+		var err error
+		if s.TemplateID != "" {
+			newStoryboard, err = a.db.CreateStoryboardFromTemplate(UserID, s.TemplateID, s.StoryboardName, s.JoinCode, s.PointValuesAllowed)
+		} else {
+			newStoryboard, err = a.db.CreateStoryboard(UserID, s.StoryboardName, s.JoinCode, s.PointValuesAllowed)
+		}
 		if err != nil {
+			if err.Error() == "TEMPLATE_NOT_FOUND" {
+				a.Failure(w, r, http.StatusNotFound, Errorf(ENOTFOUND, err.Error()))
+				return
+			}
+			if err.Error() == "INVALID_POINT_VALUES" {
+				a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, err.Error()))
+				return
+			}
 			a.Failure(w, r, http.StatusInternalServerError, err)
 			return
 		}
@@ -85,6 +104,54 @@ func (a *api) handleStoryboardCreate() http.HandlerFunc {
 	}
 }
 
+// maxStoryboardImportSize the max size in bytes of a storyboard import request body
+const maxStoryboardImportSize = 5 << 20
+
+// handleStoryboardImport handles creating a storyboard from an import
+// @Summary Import Storyboard
+// @Description Creates a storyboard owned by the user from goals, columns, and stories, columns and stories reference their goal and column by key
+// @Tags storyboard
+// @Produce  json
+// @Param userId path string true "the user ID"
+// @Param storyboard body model.StoryboardImport true "storyboard import object"
+// @Success 200 object standardJsonResponse{data=model.Storyboard}
+// @Failure 400 object standardJsonResponse{}
+// @Failure 403 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /users/{userId}/storyboards/import [post]
+func (a *api) handleStoryboardImport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		UserID := vars["userId"]
+
+		body, bodyErr := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxStoryboardImportSize))
+		if bodyErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, "STORYBOARD_IMPORT_TOO_LARGE"))
+			return
+		}
+
+		var s = model.StoryboardImport{}
+		jsonErr := json.Unmarshal(body, &s)
+		if jsonErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, jsonErr.Error()))
+			return
+		}
+
+		newStoryboard, problems, err := a.db.ImportStoryboard(UserID, &s)
+		if len(problems) > 0 {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, err.Error()+": "+strings.Join(problems, ", ")))
+			return
+		}
+		if err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		a.Success(w, r, http.StatusOK, newStoryboard, nil)
+	}
+}
+
 // handleStoryboardGet gets the storyboard by ID
 // @Summary Get Storyboard
 // @Description get storyboard by ID
@@ -130,7 +197,10 @@ func (a *api) handleStoryboardGet() http.HandlerFunc {
 // @Param userId path string true "the user ID to get storyboards for"
 // @Param limit query int false "Max number of results to return"
 // @Param offset query int false "Starting point to return rows from, should be multiplied by limit or 0"
+// @Param tag query string false "only return storyboards the user tagged with the tag"
+// @Param view query string false "the ID of a saved view to filter by"
 // @Success 200 object standardJsonResponse{data=[]model.Storyboard}
+// @Failure 400 object standardJsonResponse{}
 // @Failure 403 object standardJsonResponse{}
 // @Failure 404 object standardJsonResponse{}
 // @Security ApiKeyAuth
@@ -141,7 +211,22 @@ func (a *api) handleGetUserStoryboards() http.HandlerFunc {
 		vars := mux.Vars(r)
 		UserID := vars["userId"]
 
-		storyboards, Count, err := a.db.GetStoryboardsByUser(UserID)
+		Filter, ok := a.getRequestViewFilter(w, r, UserID, "storyboards")
+		if !ok {
+			return
+		}
+		if Tag := r.URL.Query().Get("tag"); Tag != "" {
+			Filter.Tag = Tag
+		}
+
+		// team views list the teams storyboards rather than the users own
+		if Filter.TeamId != "" {
+			storyboards := a.db.TeamStoryboardList(Filter.TeamId, Limit, Offset)
+			a.Success(w, r, http.StatusOK, storyboards, nil)
+			return
+		}
+
+		storyboards, Count, err := a.db.GetStoryboardsByUser(UserID, Filter.Tag)
 		if err != nil {
 			a.Failure(w, r, http.StatusNotFound, Errorf(ENOTFOUND, "STORYBOARDS_NOT_FOUND"))
 			return
@@ -2,9 +2,13 @@ package storyboard
 
 import (
 	"encoding/json"
+	"net"
 	"net/http"
+	"strconv"
+	"sync/atomic"
 	"time"
 
+	"github.com/StevenWeathers/thunderdome-planning-poker/db"
 	"github.com/StevenWeathers/thunderdome-planning-poker/model"
 	"go.uber.org/zap"
 
@@ -16,19 +20,146 @@ const (
 	// Time allowed to write a message to the peer.
 	writeWait = 10 * time.Second
 
-	// Time allowed to read the next pong message from the peer.
-	pongWait = 60 * time.Second
+	// Default time allowed to read the next pong message from the peer.
+	defaultPongWait = 60 * time.Second
 
-	// Send pings to peer with this period. Must be less than pongWait.
-	pingPeriod = (pongWait * 9) / 10
+	// Default period pings are sent to the peer. Must be less than pongWait.
+	defaultPingPeriod = (defaultPongWait * 9) / 10
 
 	// Maximum message size allowed from peer.
 	maxMessageSize = 1024 * 1024
 )
 
-// ownerOnlyOperations contains a map of operations that only a storyboard leader can execute
-var ownerOnlyOperations = map[string]struct{}{
-	"concede": struct{}{},
+// facilitatorOnlyOperations contains a map of operations that only a storyboard facilitator (owner) can execute
+var facilitatorOnlyOperations = map[string]struct{}{
+	"promote_owner":       {},
+	"revise_color_legend": {},
+	"revise_point_values": {},
+	"edit_storyboard":     {},
+	"concede_storyboard":  {},
+	"set_user_role":       {},
+	"create_snapshot":     {},
+	"restore_snapshot":    {},
+	"apply_template":      {},
+	"lock_goal":           {},
+	"unlock_goal":         {},
+}
+
+// rolePermissions contains a map of the operations restricted roles can execute,
+// facilitators can execute all operations and participants all but facilitator only operations
+var rolePermissions = map[string]map[string]struct{}{
+	db.StoryboardRoleContributor: {
+		"add_story":            {},
+		"update_story_name":    {},
+		"update_story_content": {},
+		"update_story_color":   {},
+		"set_story_tags":       {},
+		"update_story_points":  {},
+		"update_story_closed":  {},
+		"add_story_comment":    {},
+		"edit_story_comment":   {},
+		"delete_story_comment": {},
+		"undo":                 {},
+		"abandon_storyboard":   {},
+	},
+	db.StoryboardRoleViewer: {
+		"abandon_storyboard": {},
+	},
+}
+
+// revisionOperations contains a map of the operations that change the storyboards goals, columns, or stories,
+// each is recorded as a revision of the storyboard so it can be undone
+var revisionOperations = map[string]struct{}{
+	"add_goal":             {},
+	"revise_goal":          {},
+	"delete_goal":          {},
+	"add_column":           {},
+	"revise_column":        {},
+	"delete_column":        {},
+	"add_story":            {},
+	"update_story_name":    {},
+	"update_story_content": {},
+	"update_story_color":   {},
+	"set_story_tags":       {},
+	"update_story_points":  {},
+	"update_story_closed":  {},
+	"move_story":           {},
+	"delete_story":         {},
+}
+
+// reportedEventErrors contains a map of the operation errors the user is sent so they know why nothing happened
+var reportedEventErrors = map[string]struct{}{
+	"NOTHING_TO_UNDO":   {},
+	"UNDO_CONFLICT":     {},
+	"INVALID_COLOR":     {},
+	"INVALID_TAG":       {},
+	"TAG_LIMIT_REACHED": {},
+}
+
+// goalLockTarget gets the goal, column, or story targeted by operations that non facilitators
+// can't execute on a locked goal, ok is false for operations unaffected by goal locks
+func goalLockTarget(eventType string, eventValue string) (GoalID string, ColumnID string, StoryID string, ok bool) {
+	switch eventType {
+	case "add_story", "add_column":
+		keyVal := make(map[string]string)
+		json.Unmarshal([]byte(eventValue), &keyVal)
+		return keyVal["goalId"], "", "", true
+	case "move_story":
+		// both the stories current goal and the goal it's moving to
+		keyVal := make(map[string]string)
+		json.Unmarshal([]byte(eventValue), &keyVal)
+		return keyVal["goalId"], "", keyVal["storyId"], true
+	case "delete_story":
+		return "", "", eventValue, true
+	case "delete_column":
+		return "", eventValue, "", true
+	case "delete_goal":
+		return eventValue, "", "", true
+	}
+
+	return "", "", "", false
+}
+
+// revisionTarget gets the goal, column, or story changed by a revisable operation,
+// the goal or column being added to for operations that add one
+func revisionTarget(eventType string, eventValue string) (GoalID string, ColumnID string, StoryID string) {
+	switch eventType {
+	case "delete_goal":
+		return eventValue, "", ""
+	case "delete_column":
+		return "", eventValue, ""
+	case "delete_story":
+		return "", "", eventValue
+	}
+
+	var target struct {
+		GoalID   string `json:"goalId"`
+		ColumnID string `json:"columnId"`
+		StoryID  string `json:"storyId"`
+		ID       string `json:"id"`
+	}
+	json.Unmarshal([]byte(eventValue), &target)
+
+	// revise_column identifies its column by id
+	if eventType == "revise_column" {
+		return "", target.ID, ""
+	}
+
+	return target.GoalID, target.ColumnID, target.StoryID
+}
+
+// roleAllowsOperation checks the permission matrix for whether the storyboard role can execute the operation
+func roleAllowsOperation(Role string, eventType string) bool {
+	switch Role {
+	case db.StoryboardRoleFacilitator:
+		return true
+	case db.StoryboardRoleParticipant:
+		_, facilitatorOnly := facilitatorOnlyOperations[eventType]
+		return !facilitatorOnly
+	default:
+		_, ok := rolePermissions[Role][eventType]
+		return ok
+	}
 }
 
 var upgrader = websocket.Upgrader{
@@ -43,6 +174,12 @@ type connection struct {
 
 	// Buffered channel of outbound messages.
 	send chan []byte
+
+	// The users session ID, empty for guests.
+	sessionID string
+
+	// The connected users ID.
+	userID string
 }
 
 // readPump pumps messages from the websocket connection to the hub.
@@ -58,6 +195,7 @@ func (sub subscription) readPump(b *Service) {
 		"update_story_name":    b.UpdateStoryName,
 		"update_story_content": b.UpdateStoryContent,
 		"update_story_color":   b.UpdateStoryColor,
+		"set_story_tags":       b.UpdateStoryTags,
 		"update_story_points":  b.UpdateStoryPoints,
 		"update_story_closed":  b.UpdateStoryClosed,
 		"move_story":           b.MoveStory,
@@ -70,25 +208,35 @@ func (sub subscription) readPump(b *Service) {
 		"delete_persona":       b.DeletePersona,
 		"promote_owner":        b.PromoteOwner,
 		"revise_color_legend":  b.ReviseColorLegend,
+		"revise_point_values":  b.RevisePointValues,
 		"edit_storyboard":      b.EditStoryboard,
+		"set_user_role":        b.SetUserRole,
+		"create_snapshot":      b.CreateSnapshot,
+		"restore_snapshot":     b.RestoreSnapshot,
+		"apply_template":       b.ApplyTemplate,
+		"lock_goal":            b.LockGoal,
+		"unlock_goal":          b.UnlockGoal,
+		"undo":                 b.Undo,
 		"concede_storyboard":   b.Delete,
 		"abandon_storyboard":   b.Abandon,
 	}
 
 	var forceClosed bool
+	var rateLimited bool
 	c := sub.conn
 	UserID := sub.UserID
 	StoryboardID := sub.arena
+	limiter := newMessageLimiter(b.messageRate, b.messageBurst)
 
 	defer func() {
-		Users := b.db.RetreatStoryboardUser(StoryboardID, UserID)
-		UpdatedUsers, _ := json.Marshal(Users)
-
-		retreatEvent := createSocketEvent("user_left", string(UpdatedUsers), UserID)
-		m := message{retreatEvent, StoryboardID}
-		h.broadcast <- m
-
+		// the user is retreated by the hub once their last connection is gone
 		h.unregister <- sub
+		if rateLimited {
+			cm := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "rate limit exceeded")
+			if err := c.ws.WriteControl(websocket.CloseMessage, cm, time.Now().Add(writeWait)); err != nil {
+				b.logger.Error("rate limit close error", zap.Error(err))
+			}
+		}
 		if forceClosed {
 			cm := websocket.FormatCloseMessage(4002, "abandoned")
 			if err := c.ws.WriteControl(websocket.CloseMessage, cm, time.Now().Add(writeWait)); err != nil {
@@ -100,40 +248,102 @@ func (sub subscription) readPump(b *Service) {
 		}
 	}()
 	c.ws.SetReadLimit(maxMessageSize)
-	c.ws.SetReadDeadline(time.Now().Add(pongWait))
-	c.ws.SetPongHandler(func(string) error { c.ws.SetReadDeadline(time.Now().Add(pongWait)); return nil })
+	c.ws.SetReadDeadline(time.Now().Add(b.pongWait))
+	c.ws.SetPongHandler(func(string) error {
+		c.ws.SetReadDeadline(time.Now().Add(b.pongWait))
+		// an open storyboard keeps the users session from going idle, until the session is ended
+		if c.sessionID != "" {
+			if err := b.db.TouchSession(c.sessionID); err != nil && err.Error() == "SESSION_NOT_FOUND" {
+				return err
+			}
+		}
+		return nil
+	})
 
 	for {
 		var badEvent bool
 		var eventErr error
 		_, msg, err := c.ws.ReadMessage()
 		if err != nil {
+			// the peer missed the pong deadline e.g. behind a load balancer that dropped the close,
+			// the connection is reaped and the user leaves once it's unregistered
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				atomic.AddInt64(&b.reapedConnections, 1)
+				b.logger.Debug("reaped stale storyboard connection", zap.String("storyboard_id", StoryboardID))
+				break
+			}
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway) {
 				b.logger.Error("unexpected close error", zap.Error(err))
 			}
 			break
 		}
 
+		// messages over the rate limit are dropped, connections that keep flooding are closed
+		if !limiter.Allow(time.Now()) {
+			if limiter.dropped == 1 {
+				b.logger.Warn("storyboard websocket messages rate limited",
+					zap.String("user_id", UserID), zap.String("storyboard_id", StoryboardID))
+			}
+			if b.maxDroppedMessages > 0 && limiter.dropped >= b.maxDroppedMessages {
+				b.logger.Warn("storyboard websocket closed for exceeding the rate limit",
+					zap.String("user_id", UserID), zap.String("storyboard_id", StoryboardID),
+					zap.Int("dropped", limiter.dropped))
+				rateLimited = true
+				break
+			}
+			continue
+		}
+
 		keyVal := make(map[string]string)
 		json.Unmarshal(msg, &keyVal) // check for errors
 
 		eventType := keyVal["type"]
 		eventValue := keyVal["value"]
 
-		// confirm owner for any operation that requires it
-		if _, ok := ownerOnlyOperations[eventType]; ok {
-			err := b.db.ConfirmStoryboardOwner(StoryboardID, UserID)
-			if err != nil {
-				badEvent = true
-			}
+		// confirm the users role permits the operation, roles are checked per event so changes take effect immediately
+		Role, err := b.db.GetStoryboardUserRole(StoryboardID, UserID)
+		if err != nil {
+			badEvent = true
+		} else if _, known := eventHandlers[eventType]; known && !roleAllowsOperation(Role, eventType) {
+			// e.g. viewers observing the storyboard are told their changes aren't permitted
+			badEvent = true
+			Rejected, _ := json.Marshal(map[string]string{"type": eventType, "reason": "ROLE_NOT_PERMITTED"})
+			h.direct <- directMessage{createSocketEvent("event_error", string(Rejected), UserID), StoryboardID, c}
+		}
+
+		// locked goals are rejected with an error so the user knows why nothing happened
+		if GoalID, ColumnID, StoryID, lockable := goalLockTarget(eventType, eventValue); !badEvent && lockable &&
+			Role != db.StoryboardRoleFacilitator && b.db.StoryboardGoalLocked(StoryboardID, GoalID, ColumnID, StoryID) {
+			badEvent = true
+			Rejected, _ := json.Marshal(map[string]string{"type": eventType, "reason": "GOAL_LOCKED"})
+			h.direct <- directMessage{createSocketEvent("event_error", string(Rejected), UserID), StoryboardID, c}
 		}
 
 		// find event handler and execute otherwise invalid event
 		if _, ok := eventHandlers[eventType]; ok && !badEvent {
+			// how to undo the change is kept with its revision, scoped to the goal, column, or story it changes
+			var Undo *db.StoryboardRevisionUndo
+			_, revisable := revisionOperations[eventType]
+			if revisable {
+				GoalID, ColumnID, StoryID := revisionTarget(eventType, eventValue)
+				Undo = b.db.PrepareStoryboardRevisionUndo(StoryboardID, eventType, GoalID, ColumnID, StoryID)
+			}
+
 			msg, eventErr, forceClosed = eventHandlers[eventType](StoryboardID, UserID, eventValue)
+			if eventErr == nil && revisable {
+				if err := b.db.CreateStoryboardRevision(StoryboardID, UserID, eventType, Undo); err != nil {
+					b.logger.Error("storyboard revision error", zap.Error(err))
+				}
+			}
 			if eventErr != nil {
 				badEvent = true
 
+				// e.g. nothing to undo or an invalid tag
+				if _, reported := reportedEventErrors[eventErr.Error()]; reported {
+					Rejected, _ := json.Marshal(map[string]string{"type": eventType, "reason": eventErr.Error()})
+					h.direct <- directMessage{createSocketEvent("event_error", string(Rejected), UserID), StoryboardID, c}
+				}
+
 				// don't log forceClosed events e.g. Abandon
 				if !forceClosed {
 					b.logger.Error("unexpected close error", zap.Error(eventErr))
@@ -159,9 +369,9 @@ func (c *connection) write(mt int, payload []byte) error {
 }
 
 // writePump pumps messages from the hub to the websocket connection.
-func (sub *subscription) writePump() {
+func (sub *subscription) writePump(b *Service) {
 	c := sub.conn
-	ticker := time.NewTicker(pingPeriod)
+	ticker := time.NewTicker(b.pingPeriod)
 	defer func() {
 		ticker.Stop()
 		c.ws.Close()
@@ -200,6 +410,9 @@ func (b *Service) ServeWs() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		storyboardID := vars["storyboardId"]
+		// a reconnecting client sends the last event sequence it saw to have what it missed replayed
+		LastSeq, seqErr := strconv.ParseUint(r.URL.Query().Get("lastSeq"), 10, 64)
+		Resume := seqErr == nil
 		var User *model.User
 		var UserAuthed bool
 
@@ -224,6 +437,8 @@ func (b *Service) ServeWs() http.HandlerFunc {
 				b.handleSocketClose(ws, 4001, "unauthorized")
 				return
 			}
+			c.sessionID = SessionId
+			_ = b.db.TouchSession(SessionId)
 		} else {
 			UserID, err := b.validateUserCookie(w, r)
 			if err != nil {
@@ -239,6 +454,8 @@ func (b *Service) ServeWs() http.HandlerFunc {
 			}
 		}
 
+		c.userID = User.Id
+
 		// make sure storyboard is legit
 		storyboard, storyboardErr := b.db.GetStoryboard(storyboardID)
 		if storyboardErr != nil {
@@ -248,14 +465,10 @@ func (b *Service) ServeWs() http.HandlerFunc {
 
 		// check users storyboard active status
 		UserErr := b.db.GetStoryboardUserActiveStatus(storyboardID, User.Id)
-		if UserErr != nil && UserErr.Error() != "sql: no rows in result set" {
-			usrErrMsg := UserErr.Error()
+		// an already active user is opening another tab, their presence is tracked per connection
+		if UserErr != nil && UserErr.Error() != "sql: no rows in result set" && UserErr.Error() != "DUPLICATE_STORYBOARD_USER" {
 			b.logger.Error("error finding user", zap.Error(UserErr))
-			if usrErrMsg == "DUPLICATE_RETRO_USER" {
-				b.handleSocketClose(ws, 4003, "duplicate session")
-			} else {
-				b.handleSocketClose(ws, 4005, "internal error")
-			}
+			b.handleSocketClose(ws, 4005, "internal error")
 			return
 		}
 
@@ -289,21 +502,51 @@ func (b *Service) ServeWs() http.HandlerFunc {
 
 		for {
 			if UserAuthed == true {
-				ss := subscription{c, storyboardID, User.Id}
+				// the role is shown in presence, it's checked again on every event so changes take effect immediately
+				Role, _ := b.db.GetStoryboardUserRole(storyboardID, User.Id)
+
+				ss := subscription{
+					conn:   c,
+					arena:  storyboardID,
+					UserID: User.Id,
+					user: presenceUser{
+						UserID:       User.Id,
+						Name:         User.Name,
+						Avatar:       User.Avatar,
+						GravatarHash: User.GravatarHash,
+						Role:         Role,
+					},
+					resume:  Resume,
+					lastSeq: LastSeq,
+					ready:   make(chan registration, 1),
+				}
 				h.register <- ss
+				reg := <-ss.ready
 
 				Users, _ := b.db.AddUserToStoryboard(ss.arena, User.Id)
 				UpdatedUsers, _ := json.Marshal(Users)
 
-				Storyboard, _ := json.Marshal(storyboard)
-				initEvent := createSocketEvent("init", string(Storyboard), User.Id)
-				_ = c.write(websocket.TextMessage, initEvent)
+				// a reconnecting client has had the events it missed queued, otherwise it gets the full board
+				if !reg.replayed {
+					if Resume {
+						resyncEvent := createSocketEvent("resync", "", User.Id)
+						_ = c.write(websocket.TextMessage, stampSocketEvent(resyncEvent, reg.seq))
+					}
+
+					// the board is reloaded now registered so it includes every event up to reg.seq
+					if Latest, err := b.db.GetStoryboard(storyboardID); err == nil {
+						storyboard = Latest
+					}
+					Storyboard, _ := json.Marshal(storyboard)
+					initEvent := createSocketEvent("init", string(Storyboard), User.Id)
+					_ = c.write(websocket.TextMessage, stampSocketEvent(initEvent, reg.seq))
+				}
 
 				joinedEvent := createSocketEvent("user_joined", string(UpdatedUsers), User.Id)
 				m := message{joinedEvent, ss.arena}
 				h.broadcast <- m
 
-				go ss.writePump()
+				go ss.writePump(b)
 				go ss.readPump(b)
 
 				break
@@ -311,3 +554,12 @@ func (b *Service) ServeWs() http.HandlerFunc {
 		}
 	}
 }
+
+// retreatUser marks the user inactive once they've left the storyboard, called by the hub
+func (b *Service) retreatUser(StoryboardID string, UserID string) {
+	Users := b.db.RetreatStoryboardUser(StoryboardID, UserID)
+	UpdatedUsers, _ := json.Marshal(Users)
+
+	retreatEvent := createSocketEvent("user_left", string(UpdatedUsers), UserID)
+	h.broadcast <- message{retreatEvent, StoryboardID}
+}
@@ -160,6 +160,24 @@ func (b *Service) UpdateStoryColor(StoryboardID string, UserID string, EventValu
 	return msg, nil, false
 }
 
+// UpdateStoryTags handles replacing a storyboard story tags
+func (b *Service) UpdateStoryTags(StoryboardID string, UserID string, EventValue string) ([]byte, error, bool) {
+	var rs struct {
+		StoryID string   `json:"storyId"`
+		Tags    []string `json:"tags"`
+	}
+	json.Unmarshal([]byte(EventValue), &rs)
+
+	goals, err := b.db.ReviseStoryTags(StoryboardID, UserID, rs.StoryID, rs.Tags)
+	if err != nil {
+		return nil, err, false
+	}
+	updatedGoals, _ := json.Marshal(goals)
+	msg := createSocketEvent("story_updated", string(updatedGoals), "")
+
+	return msg, nil, false
+}
+
 // UpdateStoryPoints handles revising a storyboard story points
 func (b *Service) UpdateStoryPoints(StoryboardID string, UserID string, EventValue string) ([]byte, error, bool) {
 	var rs struct {
@@ -235,7 +253,7 @@ func (b *Service) AddStoryComment(StoryboardID string, UserID string, EventValue
 	}
 	json.Unmarshal([]byte(EventValue), &rs)
 
-	goals, err := b.db.AddStoryComment(StoryboardID, UserID, rs.StoryID, rs.Comment)
+	goals, err := b.db.CreateStoryComment(StoryboardID, UserID, rs.StoryID, rs.Comment)
 	if err != nil {
 		return nil, err, false
 	}
@@ -253,7 +271,7 @@ func (b *Service) EditStoryComment(StoryboardID string, UserID string, EventValu
 	}
 	json.Unmarshal([]byte(EventValue), &rs)
 
-	goals, err := b.db.EditStoryComment(StoryboardID, rs.CommentID, rs.Comment)
+	goals, err := b.db.EditStoryComment(StoryboardID, UserID, rs.CommentID, rs.Comment)
 	if err != nil {
 		return nil, err, false
 	}
@@ -270,7 +288,7 @@ func (b *Service) DeleteStoryComment(StoryboardID string, UserID string, EventVa
 	}
 	json.Unmarshal([]byte(EventValue), &rs)
 
-	goals, err := b.db.DeleteStoryComment(StoryboardID, rs.CommentID)
+	goals, err := b.db.DeleteStoryComment(StoryboardID, UserID, rs.CommentID)
 	if err != nil {
 		return nil, err, false
 	}
@@ -343,6 +361,93 @@ func (b *Service) PromoteOwner(StoryboardID string, UserID string, EventValue st
 	return msg, nil, false
 }
 
+// SetUserRole handles setting a storyboard users role e.g. CONTRIBUTOR or VIEWER
+func (b *Service) SetUserRole(StoryboardID string, UserID string, EventValue string) ([]byte, error, bool) {
+	var rb struct {
+		UserID string `json:"userId"`
+		Role   string `json:"role"`
+	}
+	json.Unmarshal([]byte(EventValue), &rb)
+
+	users, err := b.db.SetStoryboardUserRole(StoryboardID, rb.UserID, rb.Role)
+	if err != nil {
+		return nil, err, false
+	}
+	h.roles <- presenceRole{StoryboardID, rb.UserID, rb.Role}
+	updatedUsers, _ := json.Marshal(users)
+	msg := createSocketEvent("user_role_updated", string(updatedUsers), "")
+
+	return msg, nil, false
+}
+
+// CreateSnapshot handles snapshotting the storyboard to restore later
+func (b *Service) CreateSnapshot(StoryboardID string, UserID string, EventValue string) ([]byte, error, bool) {
+	snapshots, err := b.db.CreateStoryboardSnapshot(StoryboardID, UserID)
+	if err != nil {
+		return nil, err, false
+	}
+	updatedSnapshots, _ := json.Marshal(snapshots)
+	msg := createSocketEvent("snapshot_created", string(updatedSnapshots), "")
+
+	return msg, nil, false
+}
+
+// RestoreSnapshot handles restoring the storyboards goals, columns, and stories from a snapshot
+func (b *Service) RestoreSnapshot(StoryboardID string, UserID string, EventValue string) ([]byte, error, bool) {
+	goals, err := b.db.RestoreStoryboardSnapshot(StoryboardID, UserID, EventValue)
+	if err != nil {
+		return nil, err, false
+	}
+	updatedGoals, _ := json.Marshal(goals)
+	msg := createSocketEvent("snapshot_restored", string(updatedGoals), "")
+
+	return msg, nil, false
+}
+
+// ApplyTemplate handles adding a templates goals and columns after the storyboards existing goals
+func (b *Service) ApplyTemplate(StoryboardID string, UserID string, EventValue string) ([]byte, error, bool) {
+	goals, err := b.db.ApplyStoryboardTemplate(StoryboardID, UserID, EventValue)
+	if err != nil {
+		return nil, err, false
+	}
+	updatedGoals, _ := json.Marshal(goals)
+	msg := createSocketEvent("template_applied", string(updatedGoals), "")
+
+	return msg, nil, false
+}
+
+// LockGoal handles locking a goal so only facilitators can add, move, or delete its stories
+func (b *Service) LockGoal(StoryboardID string, UserID string, EventValue string) ([]byte, error, bool) {
+	if err := b.db.SetStoryboardGoalLock(StoryboardID, EventValue, true); err != nil {
+		return nil, err, false
+	}
+	msg := createSocketEvent("goal_locked", EventValue, UserID)
+
+	return msg, nil, false
+}
+
+// UnlockGoal handles unlocking a goal
+func (b *Service) UnlockGoal(StoryboardID string, UserID string, EventValue string) ([]byte, error, bool) {
+	if err := b.db.SetStoryboardGoalLock(StoryboardID, EventValue, false); err != nil {
+		return nil, err, false
+	}
+	msg := createSocketEvent("goal_unlocked", EventValue, UserID)
+
+	return msg, nil, false
+}
+
+// Undo handles reverting the users most recent change to the storyboards goals, columns, or stories
+func (b *Service) Undo(StoryboardID string, UserID string, EventValue string) ([]byte, error, bool) {
+	goals, err := b.db.UndoStoryboardRevision(StoryboardID, UserID)
+	if err != nil {
+		return nil, err, false
+	}
+	updatedGoals, _ := json.Marshal(goals)
+	msg := createSocketEvent("revision_undone", string(updatedGoals), UserID)
+
+	return msg, nil, false
+}
+
 // ReviseColorLegend handles revising a storyboard color legend
 func (b *Service) ReviseColorLegend(StoryboardID string, UserID string, EventValue string) ([]byte, error, bool) {
 	storyboard, err := b.db.StoryboardReviseColorLegend(StoryboardID, UserID, EventValue)
@@ -355,6 +460,24 @@ func (b *Service) ReviseColorLegend(StoryboardID string, UserID string, EventVal
 	return msg, nil, false
 }
 
+// RevisePointValues handles revising a storyboard allowed story point values
+func (b *Service) RevisePointValues(StoryboardID string, UserID string, EventValue string) ([]byte, error, bool) {
+	var pointValues []int
+	err := json.Unmarshal([]byte(EventValue), &pointValues)
+	if err != nil {
+		return nil, errors.New("INVALID_POINT_VALUES"), false
+	}
+
+	storyboard, err := b.db.StoryboardRevisePointValues(StoryboardID, UserID, pointValues)
+	if err != nil {
+		return nil, err, false
+	}
+	updatedStoryboard, _ := json.Marshal(storyboard)
+	msg := createSocketEvent("storyboard_updated", string(updatedStoryboard), "")
+
+	return msg, nil, false
+}
+
 // EditStoryboard handles editing the storyboard settings
 func (b *Service) EditStoryboard(StoryboardID string, UserID string, EventValue string) ([]byte, error, bool) {
 	var rb struct {
@@ -401,6 +524,8 @@ type socketEvent struct {
 	Type  string `json:"type"`
 	Value string `json:"value"`
 	User  string `json:"userId"`
+	// Seq the events broadcast sequence, clients send the last one they saw when reconnecting
+	Seq uint64 `json:"seq,omitempty"`
 }
 
 func createSocketEvent(Type string, Value string, User string) []byte {
@@ -414,3 +539,16 @@ func createSocketEvent(Type string, Value string, User string) []byte {
 
 	return event
 }
+
+// stampSocketEvent sets the sequence of the socket event
+func stampSocketEvent(event []byte, Seq uint64) []byte {
+	var e socketEvent
+	if err := json.Unmarshal(event, &e); err != nil {
+		return event
+	}
+	e.Seq = Seq
+
+	stamped, _ := json.Marshal(e)
+
+	return stamped
+}
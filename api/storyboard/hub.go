@@ -1,14 +1,107 @@
 package storyboard
 
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// presenceLeaveGrace how long after a users last connection drops before they're considered gone,
+// so a page reload or brief network drop doesn't flicker them out of the storyboard
+const presenceLeaveGrace = 5 * time.Second
+
+// eventLogSize the number of broadcast events kept per storyboard for replaying to reconnecting clients,
+// kept under the connections send buffer so a full replay can be queued at once
+const eventLogSize = 200
+
 type message struct {
 	data  []byte
 	arena string
 }
 
+// directMessage is sent to a single connection of the arena
+type directMessage struct {
+	data  []byte
+	arena string
+	conn  *connection
+}
+
+// eviction closes the connections of an ended session, or of a user e.g. once disabled
+type eviction struct {
+	sessionID string
+	userID    string
+}
+
+// matches whether the connection belongs to the evicted session or user
+func (e eviction) matches(c *connection) bool {
+	return (e.sessionID != "" && c.sessionID == e.sessionID) || (e.userID != "" && c.userID == e.userID)
+}
+
 type subscription struct {
 	conn   *connection
 	arena  string
 	UserID string
+	user   presenceUser
+	// resume when the client is reconnecting from lastSeq, the last event sequence it saw
+	resume  bool
+	lastSeq uint64
+	// ready receives the registration once the hub has registered the connection
+	ready chan registration
+}
+
+// registration is the hubs reply to a register request
+type registration struct {
+	// seq the sequence of the last event broadcast to the arena
+	seq uint64
+	// replayed whether the events missed since lastSeq were queued to the connection
+	replayed bool
+}
+
+// loggedEvent is a broadcast event kept for replay
+type loggedEvent struct {
+	seq  uint64
+	data []byte
+}
+
+// eventLog holds the most recent broadcast events of an arena
+type eventLog struct {
+	events []loggedEvent
+	// floor the sequence before the oldest kept event, events after it up to last are all kept
+	floor uint64
+	last  uint64
+}
+
+// presenceUser is a user currently viewing a storyboard
+type presenceUser struct {
+	UserID       string `json:"id"`
+	Name         string `json:"name"`
+	Avatar       string `json:"avatar"`
+	GravatarHash string `json:"gravatarHash"`
+	// Role the users storyboard role so viewers (observers) can be shown apart from editors
+	Role string `json:"role"`
+}
+
+// presenceRole is a users changed role to show in the arenas presence
+type presenceRole struct {
+	arena  string
+	UserID string
+	role   string
+}
+
+// presence tracks a users open connections to a storyboard
+type presence struct {
+	user        presenceUser
+	connections map[*connection]struct{}
+	leaveTimer  *time.Timer
+	// generation is bumped whenever a leave is scheduled so a stale leave is ignored
+	generation int
+}
+
+// presenceLeave is sent once a users leave grace period has passed
+type presenceLeave struct {
+	arena      string
+	UserID     string
+	generation int
 }
 
 // hub maintains the set of active connections and broadcasts messages to the
@@ -17,21 +110,51 @@ type hub struct {
 	// Registered connections.
 	arenas map[string]map[*connection]struct{}
 
+	// Users viewing each arena by user ID.
+	presence map[string]map[string]*presence
+
+	// Recent broadcast events of each arena.
+	logs map[string]*eventLog
+
+	// seq the last event sequence, shared by all arenas so sequences are never reused
+	seq uint64
+
 	// Inbound messages from the connections.
 	broadcast chan message
 
+	// Messages for a single connection e.g. rejected events.
+	direct chan directMessage
+
+	// Role changes of users in the arenas.
+	roles chan presenceRole
+
 	// Register requests from the connections.
 	register chan subscription
 
 	// Unregister requests from connections.
 	unregister chan subscription
+
+	// Leave requests from users whose grace period has passed.
+	leave chan presenceLeave
+
+	// Evict requests closing the connections of an ended session or a user.
+	evict chan eviction
+
+	// onLeave is called once a user has left an arena, run outside the hub so it can broadcast.
+	onLeave func(arena string, UserID string)
 }
 
 var h = hub{
 	broadcast:  make(chan message),
+	direct:     make(chan directMessage),
+	roles:      make(chan presenceRole),
 	register:   make(chan subscription),
 	unregister: make(chan subscription),
+	leave:      make(chan presenceLeave),
+	evict:      make(chan eviction),
 	arenas:     make(map[string]map[*connection]struct{}),
+	presence:   make(map[string]map[string]*presence),
+	logs:       make(map[string]*eventLog),
 }
 
 func (h *hub) run() {
@@ -44,6 +167,37 @@ func (h *hub) run() {
 				h.arenas[a.arena] = connections
 			}
 			h.arenas[a.arena][a.conn] = struct{}{}
+
+			users := h.presence[a.arena]
+			if users == nil {
+				users = make(map[string]*presence)
+				h.presence[a.arena] = users
+			}
+			p := users[a.UserID]
+			if p == nil {
+				p = &presence{connections: make(map[*connection]struct{})}
+				users[a.UserID] = p
+			}
+			p.user = a.user
+			p.connections[a.conn] = struct{}{}
+			if p.leaveTimer != nil {
+				p.leaveTimer.Stop()
+				p.leaveTimer = nil
+			}
+
+			log := h.eventLog(a.arena)
+			reg := registration{seq: log.last}
+			if a.resume && a.lastSeq >= log.floor && a.lastSeq <= log.last {
+				for _, e := range log.events {
+					if e.seq > a.lastSeq {
+						a.conn.send <- e.data
+					}
+				}
+				reg.replayed = true
+			}
+			a.ready <- reg
+
+			h.broadcastPresence(a.arena)
 		case a := <-h.unregister:
 			connections := h.arenas[a.arena]
 			if connections != nil {
@@ -55,19 +209,124 @@ func (h *hub) run() {
 					}
 				}
 			}
+
+			// presence is tracked apart from the connections as slow connections are dropped by broadcast
+			if p := h.presence[a.arena][a.UserID]; p != nil {
+				delete(p.connections, a.conn)
+				if len(p.connections) == 0 && p.leaveTimer == nil {
+					p.generation++
+					l := presenceLeave{a.arena, a.UserID, p.generation}
+					p.leaveTimer = time.AfterFunc(presenceLeaveGrace, func() { h.leave <- l })
+				}
+			}
+		case l := <-h.leave:
+			p := h.presence[l.arena][l.UserID]
+			if p == nil || len(p.connections) > 0 || p.generation != l.generation {
+				continue
+			}
+			delete(h.presence[l.arena], l.UserID)
+			if len(h.presence[l.arena]) == 0 {
+				delete(h.presence, l.arena)
+				delete(h.logs, l.arena)
+			}
+			h.broadcastPresence(l.arena)
+			if h.onLeave != nil {
+				go h.onLeave(l.arena, l.UserID)
+			}
+		case e := <-h.evict:
+			// presence is left to the unregister once the closed connections read pump ends
+			evictedEvent := createSocketEvent("session_evicted", "", "")
+			for arena, connections := range h.arenas {
+				for c := range connections {
+					if !e.matches(c) {
+						continue
+					}
+					select {
+					case c.send <- evictedEvent:
+					default:
+					}
+					close(c.send)
+					delete(connections, c)
+				}
+				if len(connections) == 0 {
+					delete(h.arenas, arena)
+				}
+			}
 		case m := <-h.broadcast:
-			connections := h.arenas[m.arena]
-			for c := range connections {
+			data := m.data
+			// only arenas someone is viewing keep a log
+			if h.presence[m.arena] != nil {
+				h.seq++
+				data = stampSocketEvent(data, h.seq)
+				h.eventLog(m.arena).append(h.seq, data)
+			}
+			h.send(m.arena, data)
+		case ro := <-h.roles:
+			if p := h.presence[ro.arena][ro.UserID]; p != nil {
+				p.user.Role = ro.role
+				h.broadcastPresence(ro.arena)
+			}
+		case d := <-h.direct:
+			if _, ok := h.arenas[d.arena][d.conn]; ok {
 				select {
-				case c.send <- m.data:
+				case d.conn.send <- d.data:
 				default:
-					close(c.send)
-					delete(connections, c)
-					if len(connections) == 0 {
-						delete(h.arenas, m.arena)
-					}
 				}
 			}
 		}
 	}
 }
+
+// eventLog gets the arenas event log, creating it at the current sequence
+func (h *hub) eventLog(arena string) *eventLog {
+	log := h.logs[arena]
+	if log == nil {
+		log = &eventLog{floor: h.seq, last: h.seq}
+		h.logs[arena] = log
+	}
+
+	return log
+}
+
+// append adds the event to the log dropping the oldest once full
+func (l *eventLog) append(seq uint64, data []byte) {
+	l.events = append(l.events, loggedEvent{seq, data})
+	if len(l.events) > eventLogSize {
+		l.floor = l.events[0].seq
+		l.events = append(l.events[:0], l.events[1:]...)
+	}
+	l.last = seq
+}
+
+// send delivers the data to the arenas connections, dropping any that can't keep up
+func (h *hub) send(arena string, data []byte) {
+	connections := h.arenas[arena]
+	for c := range connections {
+		select {
+		case c.send <- data:
+		default:
+			close(c.send)
+			delete(connections, c)
+			if len(connections) == 0 {
+				delete(h.arenas, arena)
+			}
+		}
+	}
+}
+
+// broadcastPresence sends the arenas online users, one entry per user however many connections they have open
+func (h *hub) broadcastPresence(arena string) {
+	var users = make([]presenceUser, 0, len(h.presence[arena]))
+	for _, p := range h.presence[arena] {
+		users = append(users, p.user)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].Name != users[j].Name {
+			return users[i].Name < users[j].Name
+		}
+		return users[i].UserID < users[j].UserID
+	})
+
+	Users, _ := json.Marshal(users)
+	h.send(arena, createSocketEvent("users", string(Users), ""))
+}
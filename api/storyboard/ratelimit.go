@@ -0,0 +1,51 @@
+package storyboard
+
+import "time"
+
+// messageLimiter is a token bucket limiting the messages a connection can send,
+// only used from the connections read pump so it isn't safe for concurrent use
+type messageLimiter struct {
+	rate    float64
+	burst   float64
+	tokens  float64
+	last    time.Time
+	dropped int
+}
+
+// newMessageLimiter creates a full bucket refilling Rate tokens per second up to Burst, nil when Rate is 0 (unlimited)
+func newMessageLimiter(Rate float64, Burst int) *messageLimiter {
+	if Rate <= 0 {
+		return nil
+	}
+	if Burst < 1 {
+		Burst = 1
+	}
+
+	return &messageLimiter{
+		rate:   Rate,
+		burst:  float64(Burst),
+		tokens: float64(Burst),
+		last:   time.Now(),
+	}
+}
+
+// Allow takes a token for the message returning false and counting the message as dropped when the bucket is empty
+func (l *messageLimiter) Allow(Now time.Time) bool {
+	if l == nil {
+		return true
+	}
+
+	l.tokens += Now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = Now
+
+	if l.tokens < 1 {
+		l.dropped++
+		return false
+	}
+	l.tokens--
+
+	return true
+}
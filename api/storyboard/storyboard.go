@@ -2,6 +2,8 @@ package storyboard
 
 import (
 	"net/http"
+	"sync/atomic"
+	"time"
 
 	"github.com/StevenWeathers/thunderdome-planning-poker/db"
 	"go.uber.org/zap"
@@ -9,6 +11,13 @@ import (
 
 // Service provides storyboard service
 type Service struct {
+	// reapedConnections the count of connections closed for missing the pong deadline, first for 64-bit atomic alignment
+	reapedConnections     int64
+	pingPeriod            time.Duration
+	pongWait              time.Duration
+	messageRate           float64
+	messageBurst          int
+	maxDroppedMessages    int
 	db                    *db.Database
 	logger                *zap.Logger
 	validateSessionCookie func(w http.ResponseWriter, r *http.Request) (string, error)
@@ -21,15 +30,58 @@ func New(
 	logger *zap.Logger,
 	validateSessionCookie func(w http.ResponseWriter, r *http.Request) (string, error),
 	validateUserCookie func(w http.ResponseWriter, r *http.Request) (string, error),
+	checkOrigin func(r *http.Request) bool,
+	pingPeriod time.Duration,
+	pongWait time.Duration,
+	messageRate float64,
+	messageBurst int,
+	maxDroppedMessages int,
 ) *Service {
+	if pongWait <= 0 || pingPeriod <= 0 || pingPeriod >= pongWait {
+		logger.Warn("invalid websocket ping interval or pong timeout, using the defaults",
+			zap.Duration("ping_interval", pingPeriod), zap.Duration("pong_timeout", pongWait))
+		pingPeriod, pongWait = defaultPingPeriod, defaultPongWait
+	}
+
 	sb := &Service{
+		pingPeriod:            pingPeriod,
+		pongWait:              pongWait,
+		messageRate:           messageRate,
+		messageBurst:          messageBurst,
+		maxDroppedMessages:    maxDroppedMessages,
 		db:                    db,
 		logger:                logger,
 		validateSessionCookie: validateSessionCookie,
 		validateUserCookie:    validateUserCookie,
 	}
 
+	upgrader.CheckOrigin = checkOrigin
+	h.onLeave = sb.retreatUser
+
 	go h.run()
 
 	return sb
 }
+
+// ReapedConnections gets the count of connections closed for missing the pong deadline since the service started
+func (b *Service) ReapedConnections() int64 {
+	return atomic.LoadInt64(&b.reapedConnections)
+}
+
+// EvictSession notifies and disconnects storyboard clients connected with the ended session
+func (b *Service) EvictSession(SessionID string) {
+	if SessionID == "" {
+		return
+	}
+
+	h.evict <- eviction{sessionID: SessionID}
+}
+
+// EvictUser notifies and disconnects the users storyboard clients e.g. once they're disabled
+func (b *Service) EvictUser(UserID string) {
+	if UserID == "" {
+		return
+	}
+
+	h.evict <- eviction{userID: UserID}
+}
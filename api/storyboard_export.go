@@ -0,0 +1,198 @@
+package api
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"github.com/gorilla/mux"
+	"github.com/microcosm-cc/bluemonday"
+	"go.uber.org/zap"
+)
+
+// storyboardExportText strips the rich text story content down to plain text for exports
+var storyboardExportText = bluemonday.StrictPolicy()
+
+// storyboardExportContentTypes the content type and file extension of each storyboard export format
+var storyboardExportContentTypes = map[string][2]string{
+	"markdown": {"text/markdown; charset=utf-8", "md"},
+	"csv":      {"text/csv; charset=utf-8", "csv"},
+	"json":     {"application/json", "json"},
+}
+
+// exportPlainText converts rich text to a single line of plain text
+func exportPlainText(Value string) string {
+	return strings.Join(strings.Fields(html.UnescapeString(storyboardExportText.Sanitize(Value))), " ")
+}
+
+// storyboardColorLabel gets the story color with its legend when the storyboard has one for it
+func storyboardColorLabel(Storyboard *model.Storyboard, Color string) string {
+	for _, c := range Storyboard.ColorLegend {
+		if c.Color == Color && c.Legend != "" {
+			return Color + " (" + c.Legend + ")"
+		}
+	}
+
+	return Color
+}
+
+// storyboardMarkdown renders the storyboard as markdown with its points total, goals as headings with their columns
+// as sub headings and the columns stories as bullet lists with their tags followed by their comments
+func storyboardMarkdown(Storyboard *model.Storyboard) []byte {
+	var b bytes.Buffer
+
+	Total := 0
+	for _, goal := range Storyboard.Goals {
+		Total += goal.Points
+	}
+
+	fmt.Fprintf(&b, "# %s\n\nTotal: %d points\n", exportPlainText(Storyboard.StoryboardName), Total)
+	for _, goal := range Storyboard.Goals {
+		fmt.Fprintf(&b, "\n## %s (%d points)\n", exportPlainText(goal.GoalName), goal.Points)
+		for _, column := range goal.Columns {
+			fmt.Fprintf(&b, "\n### %s (%d points)\n\n", exportPlainText(column.ColumnName), column.Points)
+			if len(column.Stories) == 0 {
+				b.WriteString("_No stories_\n")
+			}
+			for _, story := range column.Stories {
+				Details := []string{}
+				if story.StoryColor != "" {
+					Details = append(Details, storyboardColorLabel(Storyboard, story.StoryColor))
+				}
+				Details = append(Details, fmt.Sprintf("%d points", story.StoryPoints))
+				if story.StoryClosed {
+					Details = append(Details, "closed")
+				}
+				if len(story.StoryTags) > 0 {
+					Details = append(Details, "tags: "+exportPlainText(strings.Join(story.StoryTags, ", ")))
+				}
+				fmt.Fprintf(&b, "- **%s** (%s)\n", exportPlainText(story.StoryName), strings.Join(Details, ", "))
+
+				if Content := exportPlainText(story.StoryContent); Content != "" {
+					fmt.Fprintf(&b, "  %s\n", Content)
+				}
+				for _, comment := range story.Comments {
+					fmt.Fprintf(&b, "  - %s: %s\n", exportPlainText(comment.UserName), exportPlainText(comment.Comment))
+				}
+			}
+		}
+	}
+
+	return b.Bytes()
+}
+
+// writeStoryboardCSV writes the storyboard as one row per story with its goal and column
+func writeStoryboardCSV(w io.Writer, Storyboard *model.Storyboard, Format exportFormat) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = Format.FieldDelimiter
+
+	if err := writeCSVRow(cw, []string{"Goal", "Column", "Story", "Content", "Color", "Points", "Closed", "Tags", "Comments"}); err != nil {
+		return err
+	}
+	for _, goal := range Storyboard.Goals {
+		for _, column := range goal.Columns {
+			for _, story := range column.Stories {
+				Comments := make([]string, 0, len(story.Comments))
+				for _, comment := range story.Comments {
+					Comments = append(Comments, exportPlainText(comment.UserName)+": "+exportPlainText(comment.Comment))
+				}
+				if err := writeCSVRow(cw, []string{
+					goal.GoalName,
+					column.ColumnName,
+					story.StoryName,
+					exportPlainText(story.StoryContent),
+					storyboardColorLabel(Storyboard, story.StoryColor),
+					strconv.Itoa(story.StoryPoints),
+					strconv.FormatBool(story.StoryClosed),
+					strings.Join(story.StoryTags, ", "),
+					strings.Join(Comments, "\n"),
+				}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	cw.Flush()
+
+	return cw.Error()
+}
+
+// handleStoryboardExport downloads the storyboard in the requested format
+// @Summary Export Storyboard
+// @Description Downloads the storyboard with its goals, columns, stories, and comments as markdown for pasting into a wiki,
+// @Description csv with a row per story, or json. Only the storyboards owner and users who have joined it can export.
+// @Tags storyboard
+// @Produce  json
+// @Produce  text/markdown
+// @Produce  text/csv
+// @Param storyboardId path string true "the storyboard ID"
+// @Param format query string false "the export format markdown, csv, or json, defaults to json"
+// @Param locale query string false "the locale to use for the csv delimiter, defaults to the users locale"
+// @Success 200 {file} binary
+// @Failure 400 object standardJsonResponse{}
+// @Failure 403 object standardJsonResponse{}
+// @Failure 404 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /storyboards/{storyboardId}/export [get]
+func (a *api) handleStoryboardExport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		StoryboardID := vars["storyboardId"]
+		UserID := r.Context().Value(contextKeyUserID).(string)
+
+		Format := strings.ToLower(r.URL.Query().Get("format"))
+		if Format == "" {
+			Format = "json"
+		}
+		ContentType, ok := storyboardExportContentTypes[Format]
+		if !ok {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, "INVALID_EXPORT_FORMAT"))
+			return
+		}
+
+		if err := a.db.ConfirmStoryboardUser(StoryboardID, UserID); err != nil {
+			if err.Error() == "STORYBOARD_NOT_FOUND" {
+				a.Failure(w, r, http.StatusNotFound, Errorf(ENOTFOUND, err.Error()))
+				return
+			}
+			a.Failure(w, r, http.StatusForbidden, Errorf(EUNAUTHORIZED, err.Error()))
+			return
+		}
+
+		Storyboard, err := a.db.GetStoryboardFull(StoryboardID)
+		if err != nil {
+			if err.Error() == "STORYBOARD_NOT_FOUND" {
+				a.Failure(w, r, http.StatusNotFound, Errorf(ENOTFOUND, err.Error()))
+				return
+			}
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", ContentType[0])
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="thunderdome-storyboard-%s.%s"`, StoryboardID, ContentType[1]))
+		w.Header().Set("Cache-Control", "no-store")
+
+		switch Format {
+		case "markdown":
+			_, err = w.Write(storyboardMarkdown(Storyboard))
+		case "csv":
+			err = writeStoryboardCSV(w, Storyboard, a.getExportFormatFromRequest(r))
+		default:
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			err = enc.Encode(Storyboard)
+		}
+		if err != nil {
+			a.logger.Error("error writing storyboard export", zap.String("storyboard_id", StoryboardID), zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// handleGetStoryboardHistory gets the storyboards revision history
+// @Summary Get Storyboard History
+// @Description Gets who changed the storyboards goals, columns, and stories and when, newest first.
+// @Description Only the latest revisions are kept per storyboard, undone revisions are flagged.
+// @Tags storyboard
+// @Produce  json
+// @Param storyboardId path string true "the storyboard ID"
+// @Success 200 object standardJsonResponse{data=[]model.StoryboardRevision}
+// @Failure 403 object standardJsonResponse{}
+// @Failure 404 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /storyboards/{storyboardId}/history [get]
+func (a *api) handleGetStoryboardHistory() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		StoryboardID := vars["storyboardId"]
+
+		if !a.storyboardSnapshotAccess(w, r, StoryboardID) {
+			return
+		}
+
+		Revisions, err := a.db.GetStoryboardRevisions(StoryboardID)
+		if err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		a.Success(w, r, http.StatusOK, Revisions, nil)
+	}
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// storyboardSnapshotAccess confirms the user can view the storyboards snapshots,
+// writes the failure response and returns false when they can't
+func (a *api) storyboardSnapshotAccess(w http.ResponseWriter, r *http.Request, StoryboardID string) bool {
+	UserID := r.Context().Value(contextKeyUserID).(string)
+	UserType := r.Context().Value(contextKeyUserType).(string)
+
+	if err := a.db.ConfirmStoryboardUser(StoryboardID, UserID); err != nil && UserType != adminUserType {
+		if err.Error() == "STORYBOARD_NOT_FOUND" {
+			a.Failure(w, r, http.StatusNotFound, Errorf(ENOTFOUND, err.Error()))
+			return false
+		}
+		a.Failure(w, r, http.StatusForbidden, Errorf(EUNAUTHORIZED, err.Error()))
+		return false
+	}
+
+	return true
+}
+
+// handleGetStoryboardSnapshots gets the storyboards snapshots
+// @Summary Get Storyboard Snapshots
+// @Description Gets the storyboards manual and automatic snapshots newest first, without their contents
+// @Tags storyboard
+// @Produce  json
+// @Param storyboardId path string true "the storyboard ID"
+// @Success 200 object standardJsonResponse{data=[]model.StoryboardSnapshot}
+// @Failure 403 object standardJsonResponse{}
+// @Failure 404 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /storyboards/{storyboardId}/snapshots [get]
+func (a *api) handleGetStoryboardSnapshots() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		StoryboardID := vars["storyboardId"]
+
+		if !a.storyboardSnapshotAccess(w, r, StoryboardID) {
+			return
+		}
+
+		Snapshots, err := a.db.GetStoryboardSnapshots(StoryboardID)
+		if err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		a.Success(w, r, http.StatusOK, Snapshots, nil)
+	}
+}
+
+// handleGetStoryboardSnapshot gets a storyboard snapshot
+// @Summary Get Storyboard Snapshot
+// @Description Gets a storyboard snapshot including its goals, columns, and stories in the storyboard import schema
+// @Tags storyboard
+// @Produce  json
+// @Param storyboardId path string true "the storyboard ID"
+// @Param snapshotId path string true "the snapshot ID"
+// @Success 200 object standardJsonResponse{data=model.StoryboardSnapshot}
+// @Failure 403 object standardJsonResponse{}
+// @Failure 404 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /storyboards/{storyboardId}/snapshots/{snapshotId} [get]
+func (a *api) handleGetStoryboardSnapshot() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		StoryboardID := vars["storyboardId"]
+
+		if !a.storyboardSnapshotAccess(w, r, StoryboardID) {
+			return
+		}
+
+		Snapshot, err := a.db.GetStoryboardSnapshot(StoryboardID, vars["snapshotId"])
+		if err != nil {
+			if err.Error() == "SNAPSHOT_NOT_FOUND" {
+				a.Failure(w, r, http.StatusNotFound, Errorf(ENOTFOUND, err.Error()))
+				return
+			}
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		a.Success(w, r, http.StatusOK, Snapshot, nil)
+	}
+}
+
+// storyboardSnapshotScheduler periodically snapshots storyboards changed since their last snapshot,
+// runs outside the websocket path reading the storyboards as the interactive path left them
+func (a *api) storyboardSnapshotScheduler(Interval time.Duration) {
+	ticker := time.NewTicker(Interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		Snapshots, err := a.db.SnapshotChangedStoryboards(time.Now().Add(-Interval), a.config.StoryboardSnapshotRetention)
+		if err != nil {
+			continue
+		}
+		if Snapshots > 0 {
+			a.logger.Info("snapshot storyboards", zap.Int("storyboards", Snapshots))
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"github.com/gorilla/mux"
+)
+
+type storyboardTemplateRequestBody struct {
+	Name        string                          `json:"name"`
+	Description string                          `json:"description"`
+	IsDefault   bool                            `json:"isDefault"`
+	Goals       []*model.StoryboardImportGoal   `json:"goals"`
+	Columns     []*model.StoryboardImportColumn `json:"columns"`
+}
+
+// handleCreateStoryboardTemplate creates a storyboard template owned by the user or team
+// @Summary Create Storyboard Template
+// @Description Creates a storyboard template of goals and their columns, columns reference their goal by key.
+// @Description Created for the team when made from the team, only admins can make default templates available to everyone.
+// @Tags storyboard
+// @Produce  json
+// @Param userId path string false "the user ID"
+// @Param teamId path string false "the team ID"
+// @Param template body storyboardTemplateRequestBody true "storyboard template object"
+// @Success 200 object standardJsonResponse{data=model.StoryboardTemplate}
+// @Failure 400 object standardJsonResponse{}
+// @Failure 403 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /users/{userId}/storyboard-templates [post]
+// @Router /teams/{teamId}/storyboard-templates [post]
+func (a *api) handleCreateStoryboardTemplate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		UserID := r.Context().Value(contextKeyUserID).(string)
+		UserType := r.Context().Value(contextKeyUserType).(string)
+
+		body, bodyErr := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxStoryboardImportSize))
+		if bodyErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, bodyErr.Error()))
+			return
+		}
+
+		var t = storyboardTemplateRequestBody{}
+		jsonErr := json.Unmarshal(body, &t)
+		if jsonErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, jsonErr.Error()))
+			return
+		}
+
+		if t.IsDefault && UserType != adminUserType {
+			a.Failure(w, r, http.StatusForbidden, Errorf(EUNAUTHORIZED, "REQUIRES_ADMIN"))
+			return
+		}
+
+		Template := &model.StoryboardTemplate{
+			Name:        t.Name,
+			Description: t.Description,
+			TeamId:      vars["teamId"],
+			IsDefault:   t.IsDefault,
+			Goals:       t.Goals,
+			Columns:     t.Columns,
+		}
+		// team api keys aren't users so their templates are only owned by the team
+		if UserType != teamAPIKeyUserType {
+			Template.OwnerId = UserID
+		}
+
+		Template, problems, err := a.db.CreateStoryboardTemplate(Template)
+		if len(problems) > 0 {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, err.Error()+": "+strings.Join(problems, ", ")))
+			return
+		}
+		if err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		a.Success(w, r, http.StatusOK, Template, nil)
+	}
+}
+
+// handleListStoryboardTemplates gets the storyboard templates the user can use
+// @Summary Get Storyboard Templates
+// @Description Gets the users own storyboard templates, those of their teams, and the default templates
+// @Tags storyboard
+// @Produce  json
+// @Param userId path string true "the user ID"
+// @Success 200 object standardJsonResponse{data=[]model.StoryboardTemplate}
+// @Failure 403 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /users/{userId}/storyboard-templates [get]
+func (a *api) handleListStoryboardTemplates() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		UserID := vars["userId"]
+
+		Templates, err := a.db.GetStoryboardTemplates(UserID)
+		if err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		a.Success(w, r, http.StatusOK, Templates, nil)
+	}
+}
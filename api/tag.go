@@ -0,0 +1,202 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// maxUserTagsResults the max number of tags returned for autocompletion
+const maxUserTagsResults = 25
+
+type tagRequestBody struct {
+	Tag string `json:"tag"`
+}
+
+// tagFailure responds with the failure for a tag error
+func (a *api) tagFailure(w http.ResponseWriter, r *http.Request, err error) {
+	switch err.Error() {
+	case "INVALID_TAG", "TAG_LIMIT_REACHED":
+		a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, err.Error()))
+	case "BATTLE_NOT_FOUND", "STORYBOARD_NOT_FOUND":
+		a.Failure(w, r, http.StatusNotFound, Errorf(ENOTFOUND, err.Error()))
+	default:
+		a.Failure(w, r, http.StatusInternalServerError, err)
+	}
+}
+
+// getTagRequestBody gets the tag from the request body, writing the failure response when invalid
+func (a *api) getTagRequestBody(w http.ResponseWriter, r *http.Request) (string, bool) {
+	body, bodyErr := ioutil.ReadAll(r.Body)
+	if bodyErr != nil {
+		a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, bodyErr.Error()))
+		return "", false
+	}
+
+	var t = tagRequestBody{}
+	jsonErr := json.Unmarshal(body, &t)
+	if jsonErr != nil {
+		a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, jsonErr.Error()))
+		return "", false
+	}
+
+	return t.Tag, true
+}
+
+// handleGetUserTags gets the users tags for autocompletion
+// @Summary Get User Tags
+// @Description Gets the tags the user has put on battles and storyboards, most used first
+// @Tags user
+// @Produce  json
+// @Param userId path string true "the user ID"
+// @Param prefix query string false "only tags starting with the prefix"
+// @Param limit query int false "Max number of results to return"
+// @Success 200 object standardJsonResponse{data=[]string}
+// @Failure 403 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /users/{userId}/tags [get]
+func (a *api) handleGetUserTags() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		UserID := vars["userId"]
+
+		Limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil || Limit <= 0 || Limit > maxUserTagsResults {
+			Limit = maxUserTagsResults
+		}
+
+		Tags, err := a.db.GetUserTags(UserID, r.URL.Query().Get("prefix"), Limit)
+		if err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		a.Success(w, r, http.StatusOK, Tags, nil)
+	}
+}
+
+// handleAddBattleTag tags the battle for the user
+// @Summary Add Battle Tag
+// @Description Tags the battle for the user, tags are personal to the user
+// @Tags battle
+// @Produce  json
+// @Param userId path string true "the user ID"
+// @Param battleId path string true "the battle ID"
+// @Param tag body tagRequestBody true "the tag"
+// @Success 200 object standardJsonResponse{data=[]string}
+// @Failure 400 object standardJsonResponse{}
+// @Failure 403 object standardJsonResponse{}
+// @Failure 404 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /users/{userId}/battles/{battleId}/tags [post]
+func (a *api) handleAddBattleTag() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		Tag, ok := a.getTagRequestBody(w, r)
+		if !ok {
+			return
+		}
+
+		Tags, err := a.db.AddBattleTag(vars["battleId"], vars["userId"], Tag)
+		if err != nil {
+			a.tagFailure(w, r, err)
+			return
+		}
+
+		a.Success(w, r, http.StatusOK, Tags, nil)
+	}
+}
+
+// handleRemoveBattleTag removes the users tag from the battle
+// @Summary Remove Battle Tag
+// @Description Removes the users tag from the battle
+// @Tags battle
+// @Produce  json
+// @Param userId path string true "the user ID"
+// @Param battleId path string true "the battle ID"
+// @Param tag path string true "the tag"
+// @Success 200 object standardJsonResponse{data=[]string}
+// @Failure 403 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /users/{userId}/battles/{battleId}/tags/{tag} [delete]
+func (a *api) handleRemoveBattleTag() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		Tags, err := a.db.RemoveBattleTag(vars["battleId"], vars["userId"], vars["tag"])
+		if err != nil {
+			a.tagFailure(w, r, err)
+			return
+		}
+
+		a.Success(w, r, http.StatusOK, Tags, nil)
+	}
+}
+
+// handleAddStoryboardTag tags the storyboard for the user
+// @Summary Add Storyboard Tag
+// @Description Tags the storyboard for the user, tags are personal to the user
+// @Tags storyboard
+// @Produce  json
+// @Param userId path string true "the user ID"
+// @Param storyboardId path string true "the storyboard ID"
+// @Param tag body tagRequestBody true "the tag"
+// @Success 200 object standardJsonResponse{data=[]string}
+// @Failure 400 object standardJsonResponse{}
+// @Failure 403 object standardJsonResponse{}
+// @Failure 404 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /users/{userId}/storyboards/{storyboardId}/tags [post]
+func (a *api) handleAddStoryboardTag() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		Tag, ok := a.getTagRequestBody(w, r)
+		if !ok {
+			return
+		}
+
+		Tags, err := a.db.AddStoryboardTag(vars["storyboardId"], vars["userId"], Tag)
+		if err != nil {
+			a.tagFailure(w, r, err)
+			return
+		}
+
+		a.Success(w, r, http.StatusOK, Tags, nil)
+	}
+}
+
+// handleRemoveStoryboardTag removes the users tag from the storyboard
+// @Summary Remove Storyboard Tag
+// @Description Removes the users tag from the storyboard
+// @Tags storyboard
+// @Produce  json
+// @Param userId path string true "the user ID"
+// @Param storyboardId path string true "the storyboard ID"
+// @Param tag path string true "the tag"
+// @Success 200 object standardJsonResponse{data=[]string}
+// @Failure 403 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /users/{userId}/storyboards/{storyboardId}/tags/{tag} [delete]
+func (a *api) handleRemoveStoryboardTag() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		Tags, err := a.db.RemoveStoryboardTag(vars["storyboardId"], vars["userId"], vars["tag"])
+		if err != nil {
+			a.tagFailure(w, r, err)
+			return
+		}
+
+		a.Success(w, r, http.StatusOK, Tags, nil)
+	}
+}
@@ -1,6 +1,8 @@
 package api
 
 import (
+	"encoding/json"
+	"io/ioutil"
 	"net/http"
 	"strconv"
 	"strings"
@@ -162,6 +164,8 @@ func (a *api) handleTeamAddUser() http.HandlerFunc {
 			return
 		}
 
+		a.completeOnboardingStep(r.Context().Value(contextKeyUserID).(string), onboardingStepInviteTeammate)
+
 		a.Success(w, r, http.StatusOK, nil, nil)
 	}
 }
@@ -200,6 +204,7 @@ func (a *api) handleTeamRemoveUser() http.HandlerFunc {
 // @Tags team
 // @Produce  json
 // @Param teamId path string true "the team ID"
+// @Param state query string false "only return battles in the state"
 // @Success 200 object standardJsonResponse{data=[]model.Battle}
 // @Security ApiKeyAuth
 // @Router /teams/{teamId}/battles [get]
@@ -208,13 +213,48 @@ func (a *api) handleGetTeamBattles() http.HandlerFunc {
 		vars := mux.Vars(r)
 		TeamID := vars["teamId"]
 		Limit, Offset := getLimitOffsetFromRequest(r)
+		State := r.URL.Query().Get("state")
 
-		Battles := a.db.TeamBattleList(TeamID, Limit, Offset)
+		Battles := a.db.TeamBattleList(TeamID, State, Limit, Offset)
 
 		a.Success(w, r, http.StatusOK, Battles, nil)
 	}
 }
 
+// handleGetTeamManifest gets the combined inventory of battles and storyboards associated to the team
+// @Summary Get Team Manifest
+// @Description Get a paginated list of every battle and storyboard associated to the team with owners, status and counts
+// @Tags team
+// @Produce  json
+// @Param teamId path string true "the team ID"
+// @Param limit query int false "Max number of results to return"
+// @Param offset query int false "Starting point to return rows from, should be multiplied by limit or 0"
+// @Success 200 object standardJsonResponse{data=[]model.TeamManifestEntry}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /teams/{teamId}/manifest [get]
+func (a *api) handleGetTeamManifest() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		TeamID := vars["teamId"]
+		Limit, Offset := getLimitOffsetFromRequest(r)
+
+		Entries, Count, err := a.db.TeamManifest(TeamID, Limit, Offset)
+		if err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		Meta := &pagination{
+			Count:  Count,
+			Offset: Offset,
+			Limit:  Limit,
+		}
+
+		a.Success(w, r, http.StatusOK, Entries, Meta)
+	}
+}
+
 // handleTeamRemoveBattle handles removing battle from a team
 // @Summary Remove Team Battle
 // @Description Remove a battle from the team
@@ -406,3 +446,379 @@ func (a *api) handleGetTeamRetroActions() http.HandlerFunc {
 		a.Success(w, r, http.StatusOK, Actions, Meta)
 	}
 }
+
+// teamMembersSuccess responds with the team's updated member list
+func (a *api) teamMembersSuccess(w http.ResponseWriter, r *http.Request, TeamID string) {
+	Limit, Offset := getLimitOffsetFromRequest(r)
+
+	Members, MemberCount, err := a.db.TeamUserList(TeamID, Limit, Offset)
+	if err != nil {
+		a.Failure(w, r, http.StatusInternalServerError, err)
+		return
+	}
+
+	Meta := &pagination{
+		Count:  MemberCount,
+		Offset: Offset,
+		Limit:  Limit,
+	}
+
+	a.Success(w, r, http.StatusOK, Members, Meta)
+}
+
+// teamMemberFailure responds with the appropriate failure for team member management errors
+func (a *api) teamMemberFailure(w http.ResponseWriter, r *http.Request, err error) {
+	switch err.Error() {
+	case "TEAM_LAST_ADMIN":
+		a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, err.Error()))
+	case "TEAM_USER_NOT_FOUND":
+		a.Failure(w, r, http.StatusNotFound, Errorf(ENOTFOUND, err.Error()))
+	default:
+		a.Failure(w, r, http.StatusInternalServerError, err)
+	}
+}
+
+// handleGetTeamMembers gets a list of the team's members with their roles
+// @Summary Get Team Members
+// @Description Get a list of the team's members with their roles
+// @Tags team
+// @Produce  json
+// @Param teamId path string true "the team ID"
+// @Success 200 object standardJsonResponse{data=[]model.TeamUser}
+// @Failure 403 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /teams/{teamId}/members [get]
+func (a *api) handleGetTeamMembers() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		TeamID := vars["teamId"]
+
+		a.teamMembersSuccess(w, r, TeamID)
+	}
+}
+
+type teamMemberAddRequestBody struct {
+	Email string `json:"email"`
+	Role  string `json:"role" enums:"MEMBER,ADMIN"`
+}
+
+// handleAddTeamMember handles adding a member to the team by email, inviting them if not yet registered
+// @Summary Add Team Member
+// @Description Adds a member to the team by email, users that aren't registered are invited and added once they verify their email
+// @Tags team
+// @Produce  json
+// @Param teamId path string true "the team ID"
+// @Param member body teamMemberAddRequestBody true "the member to add"
+// @Success 200 object standardJsonResponse{data=[]model.TeamUser}
+// @Failure 400 object standardJsonResponse{}
+// @Failure 403 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /teams/{teamId}/members [post]
+func (a *api) handleAddTeamMember() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		TeamID := vars["teamId"]
+
+		body, bodyErr := ioutil.ReadAll(r.Body)
+		if bodyErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, bodyErr.Error()))
+			return
+		}
+
+		var m = teamMemberAddRequestBody{}
+		jsonErr := json.Unmarshal(body, &m)
+		if jsonErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, jsonErr.Error()))
+			return
+		}
+
+		UserEmail := strings.ToLower(strings.TrimSpace(m.Email))
+		if UserEmail == "" {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, "INVALID_EMAIL"))
+			return
+		}
+		if m.Role != "MEMBER" && m.Role != "ADMIN" {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, "INVALID_ROLE"))
+			return
+		}
+
+		User, UserErr := a.db.GetUserByEmail(UserEmail)
+		if UserErr != nil {
+			Team, TeamErr := a.db.TeamGet(TeamID)
+			if TeamErr != nil {
+				a.Failure(w, r, http.StatusInternalServerError, TeamErr)
+				return
+			}
+
+			err := a.db.TeamInviteUser(TeamID, UserEmail, m.Role)
+			if err != nil {
+				a.Failure(w, r, http.StatusInternalServerError, err)
+				return
+			}
+
+			a.email.SendTeamInvite(Team.Name, UserEmail)
+		} else {
+			_, err := a.db.TeamAddUser(TeamID, User.Id, m.Role)
+			if err != nil {
+				a.Failure(w, r, http.StatusInternalServerError, err)
+				return
+			}
+		}
+
+		a.completeOnboardingStep(r.Context().Value(contextKeyUserID).(string), onboardingStepInviteTeammate)
+
+		a.teamMembersSuccess(w, r, TeamID)
+	}
+}
+
+type teamMemberRoleRequestBody struct {
+	Role string `json:"role" enums:"MEMBER,ADMIN"`
+}
+
+// handleUpdateTeamMemberRole handles updating a team member's role
+// @Summary Update Team Member Role
+// @Description Updates a team member's role, the last team admin cannot be demoted
+// @Tags team
+// @Produce  json
+// @Param teamId path string true "the team ID"
+// @Param memberId path string true "the member's user ID"
+// @Param role body teamMemberRoleRequestBody true "the member's new role"
+// @Success 200 object standardJsonResponse{data=[]model.TeamUser}
+// @Failure 400 object standardJsonResponse{}
+// @Failure 403 object standardJsonResponse{}
+// @Failure 404 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /teams/{teamId}/members/{memberId} [put]
+func (a *api) handleUpdateTeamMemberRole() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		TeamID := vars["teamId"]
+		MemberID := vars["memberId"]
+
+		body, bodyErr := ioutil.ReadAll(r.Body)
+		if bodyErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, bodyErr.Error()))
+			return
+		}
+
+		var m = teamMemberRoleRequestBody{}
+		jsonErr := json.Unmarshal(body, &m)
+		if jsonErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, jsonErr.Error()))
+			return
+		}
+
+		if m.Role != "MEMBER" && m.Role != "ADMIN" {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, "INVALID_ROLE"))
+			return
+		}
+
+		err := a.db.TeamUpdateUserRole(TeamID, MemberID, m.Role)
+		if err != nil {
+			a.teamMemberFailure(w, r, err)
+			return
+		}
+
+		a.teamMembersSuccess(w, r, TeamID)
+	}
+}
+
+// handleRemoveTeamMember handles removing a member from the team
+// @Summary Remove Team Member
+// @Description Removes a member from the team, the last team admin cannot be removed
+// @Tags team
+// @Produce  json
+// @Param teamId path string true "the team ID"
+// @Param memberId path string true "the member's user ID"
+// @Success 200 object standardJsonResponse{data=[]model.TeamUser}
+// @Failure 400 object standardJsonResponse{}
+// @Failure 403 object standardJsonResponse{}
+// @Failure 404 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /teams/{teamId}/members/{memberId} [delete]
+func (a *api) handleRemoveTeamMember() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		TeamID := vars["teamId"]
+		MemberID := vars["memberId"]
+
+		err := a.db.TeamRemoveUser(TeamID, MemberID)
+		if err != nil {
+			a.teamMemberFailure(w, r, err)
+			return
+		}
+
+		a.teamMembersSuccess(w, r, TeamID)
+	}
+}
+
+// handleGetTeamBattleStates gets the teams battle state workflow
+// @Summary Get Team Battle States
+// @Description Get the battle states and allowed transitions for the teams battles
+// @Tags team
+// @Produce  json
+// @Param teamId path string true "the team ID"
+// @Success 200 object standardJsonResponse{data=model.BattleStateWorkflow}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /teams/{teamId}/battle-states [get]
+func (a *api) handleGetTeamBattleStates() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		TeamID := vars["teamId"]
+
+		Workflow, err := a.db.GetTeamBattleStateWorkflow(TeamID)
+		if err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		a.Success(w, r, http.StatusOK, Workflow, nil)
+	}
+}
+
+// handleUpdateTeamBattleStates sets the teams battle state workflow
+// @Summary Update Team Battle States
+// @Description Sets the battle states and allowed transitions for the teams battles, null reverts to the default open/closed workflow
+// @Tags team
+// @Produce  json
+// @Param teamId path string true "the team ID"
+// @Param workflow body model.BattleStateWorkflow false "battle state workflow"
+// @Success 200 object standardJsonResponse{data=model.BattleStateWorkflow}
+// @Failure 400 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /teams/{teamId}/battle-states [put]
+func (a *api) handleUpdateTeamBattleStates() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		TeamID := vars["teamId"]
+
+		body, bodyErr := ioutil.ReadAll(r.Body)
+		if bodyErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, bodyErr.Error()))
+			return
+		}
+
+		var Workflow *model.BattleStateWorkflow
+		jsonErr := json.Unmarshal(body, &Workflow)
+		if jsonErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, jsonErr.Error()))
+			return
+		}
+
+		if err := a.db.UpdateTeamBattleStateWorkflow(TeamID, Workflow); err != nil {
+			if err.Error() == "INVALID_STATE_WORKFLOW" {
+				a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, err.Error()))
+				return
+			}
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		Workflow, err := a.db.GetTeamBattleStateWorkflow(TeamID)
+		if err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		a.Success(w, r, http.StatusOK, Workflow, nil)
+	}
+}
+
+// handleGetTeamEstimationPolicy gets the teams estimation policy
+// @Summary Get Team Estimation Policy
+// @Description Get the estimate cap votes in the teams battles can't exceed, battles can override it
+// @Tags team
+// @Produce  json
+// @Param teamId path string true "the team ID"
+// @Success 200 object standardJsonResponse{data=model.TeamEstimationPolicy}
+// @Failure 404 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /teams/{teamId}/estimation-policy [get]
+func (a *api) handleGetTeamEstimationPolicy() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		Policy, err := a.db.GetTeamEstimationPolicy(vars["teamId"])
+		if err != nil {
+			a.Failure(w, r, http.StatusNotFound, Errorf(ENOTFOUND, err.Error()))
+			return
+		}
+
+		a.Success(w, r, http.StatusOK, Policy, nil)
+	}
+}
+
+// handleUpdateTeamEstimationPolicy sets the teams estimation policy
+// @Summary Update Team Estimation Policy
+// @Description Sets the estimate cap votes in the teams battles can't exceed to force splitting large stories, null removes the cap
+// @Tags team
+// @Produce  json
+// @Param teamId path string true "the team ID"
+// @Param policy body model.TeamEstimationPolicy true "estimation policy"
+// @Success 200 object standardJsonResponse{data=model.TeamEstimationPolicy}
+// @Failure 400 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /teams/{teamId}/estimation-policy [put]
+func (a *api) handleUpdateTeamEstimationPolicy() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		TeamID := vars["teamId"]
+
+		body, bodyErr := ioutil.ReadAll(r.Body)
+		if bodyErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, bodyErr.Error()))
+			return
+		}
+
+		var Policy = model.TeamEstimationPolicy{}
+		jsonErr := json.Unmarshal(body, &Policy)
+		if jsonErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, jsonErr.Error()))
+			return
+		}
+
+		if err := a.db.UpdateTeamEstimationPolicy(TeamID, &Policy); err != nil {
+			if err.Error() == "INVALID_ESTIMATE_CAP" {
+				a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, err.Error()))
+				return
+			}
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		a.Success(w, r, http.StatusOK, &Policy, nil)
+	}
+}
+
+// handleGetTeamEstimationAccuracy gets the estimated vs actual effort metrics of the teams battles
+// @Summary Get Team Estimation Accuracy
+// @Description Get the variance and over/under-estimation trend of the teams plans that have a recorded actual, plans without actuals are excluded
+// @Tags team
+// @Produce  json
+// @Param teamId path string true "the team ID"
+// @Success 200 object standardJsonResponse{data=model.EstimationAccuracy}
+// @Failure 403 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /teams/{teamId}/estimation-accuracy [get]
+func (a *api) handleGetTeamEstimationAccuracy() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		TeamID := vars["teamId"]
+
+		Accuracy, err := a.db.GetTeamEstimationAccuracy(TeamID)
+		if err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		a.Success(w, r, http.StatusOK, Accuracy, nil)
+	}
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"time"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"go.uber.org/zap"
+)
+
+const (
+	// teamAdminDemotionNotify the inactive team admin should be notified of their upcoming demotion
+	teamAdminDemotionNotify = "notify"
+	// teamAdminDemotionDemote the inactive team admin should be demoted
+	teamAdminDemotionDemote = "demote"
+)
+
+// teamAdminDemotionAction determines whether an inactive team admin should be notified, demoted, or left alone,
+// demotion only happens once they've been inactive long enough and were notified at least the notice days ago
+func teamAdminDemotionAction(Admin *model.InactiveTeamAdmin, Now time.Time, InactiveDays int, NoticeDays int) string {
+	day := 24 * time.Hour
+	if Now.Sub(Admin.LastActive) < time.Duration(InactiveDays-NoticeDays)*day {
+		return ""
+	}
+	if Admin.DemotionNoticeDate == nil {
+		return teamAdminDemotionNotify
+	}
+	if Now.Sub(Admin.LastActive) >= time.Duration(InactiveDays)*day &&
+		Now.Sub(*Admin.DemotionNoticeDate) >= time.Duration(NoticeDays)*day {
+		return teamAdminDemotionDemote
+	}
+
+	return ""
+}
+
+// demoteInactiveTeamAdmins notifies and demotes team admins that have been inactive per the policy
+func (a *api) demoteInactiveTeamAdmins() {
+	InactiveDays := a.config.DemoteInactiveTeamAdminsDays
+	NoticeDays := a.config.DemoteInactiveTeamAdminsNoticeDays
+	if NoticeDays > InactiveDays {
+		NoticeDays = InactiveDays
+	}
+
+	Admins, err := a.db.GetInactiveTeamAdmins(InactiveDays - NoticeDays)
+	if err != nil {
+		return
+	}
+
+	Now := time.Now()
+	for _, admin := range Admins {
+		switch teamAdminDemotionAction(admin, Now, InactiveDays, NoticeDays) {
+		case teamAdminDemotionNotify:
+			if err := a.db.SetTeamAdminDemotionNotice(admin.TeamId, admin.UserId); err != nil {
+				continue
+			}
+			if admin.UserEmail != "" {
+				a.email.SendTeamAdminDemotionNotice(admin.UserName, admin.UserEmail, admin.TeamName, NoticeDays)
+			}
+		case teamAdminDemotionDemote:
+			if err := a.db.DemoteInactiveTeamAdmin(admin); err != nil {
+				// the last remaining team admin is kept
+				if err.Error() != "TEAM_LAST_ADMIN" {
+					a.logger.Error("error demoting inactive team admin",
+						zap.String("team_id", admin.TeamId), zap.String("user_id", admin.UserId), zap.Error(err))
+				}
+				continue
+			}
+			a.logger.Info("demoted inactive team admin",
+				zap.String("team_id", admin.TeamId),
+				zap.String("user_id", admin.UserId),
+				zap.Time("last_active", admin.LastActive),
+			)
+		}
+	}
+}
+
+// inactiveTeamAdminSweeper periodically applies the inactive team admin demotion policy
+func (a *api) inactiveTeamAdminSweeper(Interval time.Duration) {
+	ticker := time.NewTicker(Interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		a.demoteInactiveTeamAdmins()
+	}
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+const (
+	// teamDigestPeriod the period of activity summarized in the team digest
+	teamDigestPeriod = 7 * 24 * time.Hour
+	// teamDigestClaimInterval the minimum time between a teams scheduled digests, slightly under a week
+	// so a scheduler running a little early the next week isn't skipped
+	teamDigestClaimInterval = 6 * 24 * time.Hour
+)
+
+// teamDigestDue whether the scheduled team digest should be sent in the current hour
+func teamDigestDue(Now time.Time, Weekday int, Hour int) bool {
+	Now = Now.UTC()
+
+	return int(Now.Weekday()) == Weekday && Now.Hour() == Hour
+}
+
+// sendTeamDigest emails the digest to the teams subscribed users
+func (a *api) sendTeamDigest(Digest *model.TeamDigest) {
+	for _, r := range Digest.Recipients {
+		a.email.SendTeamDigest(
+			r.UserName, r.UserEmail, r.QuietHours, Digest,
+			a.config.TeamDigestIncludeBattles, a.config.TeamDigestIncludePoints,
+		)
+	}
+}
+
+// sendScheduledTeamDigests emails the digest of every team with activity in the past week,
+// each team is claimed first so multiple instances don't send duplicates
+func (a *api) sendScheduledTeamDigests() {
+	Digests, err := a.db.GetTeamDigests(time.Now().Add(-teamDigestPeriod), "")
+	if err != nil {
+		return
+	}
+
+	for _, digest := range Digests {
+		if len(digest.Recipients) == 0 {
+			continue
+		}
+		if claimed, err := a.db.ClaimTeamDigest(digest.TeamId, teamDigestClaimInterval); err != nil || !claimed {
+			continue
+		}
+		a.sendTeamDigest(digest)
+		a.logger.Info("sent team digest",
+			zap.String("team_id", digest.TeamId),
+			zap.Int("recipients", len(digest.Recipients)),
+		)
+	}
+}
+
+// teamDigestScheduler checks hourly whether the weekly team digest is due
+func (a *api) teamDigestScheduler() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if teamDigestDue(time.Now(), a.config.TeamDigestWeekday, a.config.TeamDigestHour) {
+			a.sendScheduledTeamDigests()
+		}
+	}
+}
+
+// handleSendTeamDigest sends the teams digest now regardless of schedule
+// @Summary Send Team Digest
+// @Description Sends the teams weekly digest now to its subscribed users regardless of schedule, for testing
+// @Tags admin
+// @Produce  json
+// @Param teamId path string true "the team ID"
+// @Success 200 object standardJsonResponse{data=model.TeamDigest}
+// @Failure 403 object standardJsonResponse{}
+// @Failure 404 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /admin/teams/{teamId}/digest [post]
+func (a *api) handleSendTeamDigest() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		Digests, err := a.db.GetTeamDigests(time.Now().Add(-teamDigestPeriod), vars["teamId"])
+		if err != nil {
+			if err.Error() == "TEAM_NOT_FOUND" {
+				a.Failure(w, r, http.StatusNotFound, Errorf(ENOTFOUND, err.Error()))
+				return
+			}
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		Digest := Digests[0]
+		a.sendTeamDigest(Digest)
+
+		a.Success(w, r, http.StatusOK, Digest, nil)
+	}
+}
@@ -4,13 +4,17 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
 	"github.com/gorilla/mux"
+	"go.uber.org/zap"
 )
 
 // handleSessionUserProfile returns the users profile by session user ID
 // @Summary Get Session User Profile
-// @Description Gets a users profile by session user ID
+// @Description Gets a users profile by session user ID, for guests includes when their session expires
 // @Tags auth, user
 // @Produce  json
 // @Success 200 object standardJsonResponse{data=model.User}
@@ -28,6 +32,18 @@ func (a *api) handleSessionUserProfile() http.HandlerFunc {
 			return
 		}
 
+		Flags, FlagsErr := a.db.EvaluateFlags(User)
+		if FlagsErr != nil {
+			a.Failure(w, r, http.StatusInternalServerError, FlagsErr)
+			return
+		}
+		User.FeatureFlags = Flags
+		User.Onboarding = a.getOnboardingState(User.Id)
+		if ExpiresAt, ok := a.guestSessionExpiry(User); ok {
+			User.GuestSessionExpires = &ExpiresAt
+			User.GuestSessionRemaining = int64(time.Until(ExpiresAt).Seconds())
+		}
+
 		a.Success(w, r, http.StatusOK, User, nil)
 	}
 }
@@ -54,6 +70,14 @@ func (a *api) handleUserProfile() http.HandlerFunc {
 			return
 		}
 
+		Flags, FlagsErr := a.db.EvaluateFlags(User)
+		if FlagsErr != nil {
+			a.Failure(w, r, http.StatusInternalServerError, FlagsErr)
+			return
+		}
+		User.FeatureFlags = Flags
+		User.Onboarding = a.getOnboardingState(User.Id)
+
 		a.Success(w, r, http.StatusOK, User, nil)
 	}
 }
@@ -66,7 +90,33 @@ type userprofileUpdateRequestBody struct {
 	Locale               string `json:"locale"`
 	Company              string `json:"company"`
 	JobTitle             string `json:"jobTitle"`
-	Email                string `json:"email"`
+	// Email only updatable by admins, users change their own through the email change confirmation
+	Email string `json:"email"`
+	// TeamDigestEnabled subscribes to team digests, unchanged when omitted
+	TeamDigestEnabled *bool `json:"teamDigestEnabled"`
+}
+
+// ldapManagedFieldChanged returns the first directory managed profile field the update changes,
+// name and email aren't updatable by LDAP users so they're only compared when submitted
+func ldapManagedFieldChanged(ManagedFields []string, User *model.User, profile userprofileUpdateRequestBody) string {
+	for _, Field := range ManagedFields {
+		var changed bool
+		switch Field {
+		case "name":
+			changed = profile.Name != "" && profile.Name != User.Name
+		case "email":
+			changed = profile.Email != "" && !strings.EqualFold(profile.Email, User.Email)
+		case "company":
+			changed = profile.Company != User.Company
+		case "job_title":
+			changed = profile.JobTitle != User.JobTitle
+		}
+		if changed {
+			return Field
+		}
+	}
+
+	return ""
 }
 
 // handleUserProfileUpdate attempts to update users profile
@@ -77,6 +127,7 @@ type userprofileUpdateRequestBody struct {
 // @Param userId path string true "the user ID"
 // @Param user body userprofileUpdateRequestBody true "the user profile object to update"
 // @Success 200 object standardJsonResponse{data=model.User}
+// @Failure 400 object standardJsonResponse{}
 // @Failure 403 object standardJsonResponse{}
 // @Failure 500 object standardJsonResponse{}
 // @Security ApiKeyAuth
@@ -101,12 +152,43 @@ func (a *api) handleUserProfileUpdate() http.HandlerFunc {
 
 		UserID := vars["userId"]
 
+		// uploaded avatars can only be set by their uploader, so another users upload can't be claimed and later deleted
+		var PreviousAvatar string
+		if a.avatars != nil {
+			if Name := a.avatars.Name(profile.Avatar); Name != "" && !avatarOwnedBy(Name, UserID) {
+				a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, "INVALID_AVATAR"))
+				return
+			}
+			User, UserErr := a.db.GetUser(UserID)
+			if UserErr != nil {
+				a.Failure(w, r, http.StatusInternalServerError, UserErr)
+				return
+			}
+			PreviousAvatar = User.Avatar
+		}
+
+		if a.config.LdapEnabled && len(a.config.LdapManagedFields) > 0 {
+			User, UserErr := a.db.GetUser(UserID)
+			if UserErr != nil {
+				a.Failure(w, r, http.StatusInternalServerError, UserErr)
+				return
+			}
+			if ldapManagedFieldChanged(a.config.LdapManagedFields, User, profile) != "" {
+				a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, "FIELD_MANAGED_BY_DIRECTORY"))
+				return
+			}
+		}
+
 		if SessionUserType == adminUserType {
 			_, _, vErr := validateUserAccount(profile.Name, profile.Email)
 			if vErr != nil {
 				a.Failure(w, r, http.StatusBadRequest, vErr)
 				return
 			}
+			if err := a.db.CheckEmailAvailable(profile.Email, UserID); err != nil {
+				a.emailUnavailableFailure(w, r, err)
+				return
+			}
 			updateErr := a.db.UpdateUserAccount(UserID, profile.Name, profile.Email, profile.Avatar, profile.NotificationsEnabled, profile.Country, profile.Locale, profile.Company, profile.JobTitle)
 			if updateErr != nil {
 				a.Failure(w, r, http.StatusInternalServerError, updateErr)
@@ -129,6 +211,18 @@ func (a *api) handleUserProfileUpdate() http.HandlerFunc {
 			}
 		}
 
+		// a replaced uploaded avatar is removed so it stops counting towards the users storage
+		if PreviousAvatar != profile.Avatar {
+			a.deleteUploadedAvatar(UserID, PreviousAvatar)
+		}
+
+		if profile.TeamDigestEnabled != nil {
+			if err := a.db.SetUserTeamDigestEnabled(UserID, *profile.TeamDigestEnabled); err != nil {
+				a.Failure(w, r, http.StatusInternalServerError, err)
+				return
+			}
+		}
+
 		user, UserErr := a.db.GetUser(UserID)
 		if UserErr != nil {
 			a.Failure(w, r, http.StatusInternalServerError, UserErr)
@@ -163,13 +257,31 @@ func (a *api) handleUserDelete() http.HandlerFunc {
 			return
 		}
 
-		updateErr := a.db.DeleteUser(UserID)
-		if updateErr != nil {
-			a.Failure(w, r, http.StatusInternalServerError, updateErr)
-			return
+		// guests can't log back in to recover their account so are deleted immediately
+		RecoveryDays := a.config.UserDeleteGraceDays
+		if User.Type == guestUserType {
+			RecoveryDays = 0
 		}
 
-		a.email.SendDeleteConfirmation(User.Name, User.Email)
+		if RecoveryDays > 0 {
+			SessionIDs, updateErr := a.db.SoftDeleteUser(UserID)
+			if updateErr != nil {
+				a.Failure(w, r, http.StatusInternalServerError, updateErr)
+				return
+			}
+			for _, SessionID := range SessionIDs {
+				a.evictSession(SessionID)
+			}
+		} else {
+			updateErr := a.db.DeleteUser(UserID)
+			if updateErr != nil {
+				a.Failure(w, r, http.StatusInternalServerError, updateErr)
+				return
+			}
+			a.deleteUploadedAvatar(UserID, User.Avatar)
+		}
+
+		a.email.SendDeleteConfirmation(User.Name, User.Email, RecoveryDays)
 
 		// don't clear admins user cookies when deleting other users
 		if UserID == UserCookieID {
@@ -180,6 +292,51 @@ func (a *api) handleUserDelete() http.HandlerFunc {
 	}
 }
 
+// handleAnonymizeUser scrubs a users personal information as an alternative to deleting the account
+// @Summary Anonymize User
+// @Description Anonymizes a user, removing personal information while preserving their battle participation and votes
+// @Tags user
+// @Produce  json
+// @Param userId path string true "the user ID"
+// @Success 200 object standardJsonResponse{}
+// @Failure 403 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /users/{userId}/anonymize [patch]
+func (a *api) handleAnonymizeUser() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		UserID := vars["userId"]
+		UserCookieID := r.Context().Value(contextKeyUserID).(string)
+
+		User, UserErr := a.db.GetUser(UserID)
+		if UserErr != nil {
+			a.Failure(w, r, http.StatusInternalServerError, UserErr)
+			return
+		}
+
+		if err := a.db.AnonymizeUser(UserID); err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		a.deleteUploadedAvatar(UserID, User.Avatar)
+
+		a.logger.Info("user anonymized",
+			zap.String("user_id", UserID),
+			zap.String("acting_user_id", UserCookieID),
+			zap.String("acting_user_type", r.Context().Value(contextKeyUserType).(string)),
+		)
+
+		// don't clear admins user cookies when anonymizing other users
+		if UserID == UserCookieID {
+			a.clearUserCookies(w)
+		}
+
+		a.Success(w, r, http.StatusOK, nil, nil)
+	}
+}
+
 // handleVerifyRequest sends verification email
 // @Summary Request Verification Email
 // @Description Sends verification email
@@ -206,6 +363,114 @@ func (a *api) handleVerifyRequest() http.HandlerFunc {
 	}
 }
 
+type emailChangeRequestBody struct {
+	Email string `json:"email"`
+}
+
+// handleRequestEmailChange sends a confirmation link to the users requested new email
+// @Summary Request Email Change
+// @Description Stores the users pending new email and sends a confirmation link to it, the users email is unchanged until confirmed
+// @Description *Endpoint only available when LDAP and OIDC are not enabled
+// @Tags user
+// @Produce  json
+// @Param userId path string true "the user ID"
+// @Param email body emailChangeRequestBody true "the new email"
+// @Success 200 object standardJsonResponse{}
+// @Failure 400 object standardJsonResponse{}
+// @Failure 409 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /users/{userId}/email-change [post]
+func (a *api) handleRequestEmailChange() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		UserID := vars["userId"]
+
+		body, bodyErr := ioutil.ReadAll(r.Body)
+		if bodyErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, bodyErr.Error()))
+			return
+		}
+
+		var ec = emailChangeRequestBody{}
+		jsonErr := json.Unmarshal(body, &ec)
+		if jsonErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, jsonErr.Error()))
+			return
+		}
+
+		Email := strings.ToLower(strings.TrimSpace(ec.Email))
+		if vErr := validateUserEmail(Email); vErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, "INVALID_EMAIL"))
+			return
+		}
+		if err := a.db.CheckEmailAvailable(Email, UserID); err != nil {
+			a.emailUnavailableFailure(w, r, err)
+			return
+		}
+
+		User, ChangeID, err := a.db.RequestEmailChange(UserID, Email)
+		if err != nil {
+			switch err.Error() {
+			case "EMAIL_UNCHANGED", "USER_NOT_FOUND":
+				a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, err.Error()))
+			default:
+				a.Failure(w, r, http.StatusInternalServerError, err)
+			}
+			return
+		}
+
+		a.email.SendEmailChangeConfirmation(User.Name, Email, ChangeID)
+
+		a.Success(w, r, http.StatusOK, nil, nil)
+	}
+}
+
+type emailChangeConfirmRequestBody struct {
+	ChangeID string `json:"changeId"`
+}
+
+// handleConfirmEmailChange swaps the users email for the confirmed pending email
+// @Summary Confirm Email Change
+// @Description Changes the users email to the pending email the confirmation link was sent to
+// @Description *Endpoint only available when LDAP and OIDC are not enabled
+// @Tags auth
+// @Produce  json
+// @Param change body emailChangeConfirmRequestBody true "the email change confirmation"
+// @Success 200 object standardJsonResponse{}
+// @Failure 400 object standardJsonResponse{}
+// @Failure 409 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Router /auth/email-change [patch]
+func (a *api) handleConfirmEmailChange() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, bodyErr := ioutil.ReadAll(r.Body)
+		if bodyErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, bodyErr.Error()))
+			return
+		}
+
+		var ec = emailChangeConfirmRequestBody{}
+		jsonErr := json.Unmarshal(body, &ec)
+		if jsonErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, jsonErr.Error()))
+			return
+		}
+
+		if _, _, err := a.db.ConfirmEmailChange(ec.ChangeID); err != nil {
+			switch err.Error() {
+			case "EMAIL_IN_USE", "EMAIL_IN_USE_DELETED_ACCOUNT":
+				a.emailUnavailableFailure(w, r, err)
+			default:
+				a.tokenFailure(w, r, err)
+			}
+			return
+		}
+
+		a.Success(w, r, http.StatusOK, nil, nil)
+	}
+}
+
 // handleGetActiveCountries gets a list of registered users countries
 // @Summary Get Active Countries
 // @Description Gets a list of users countries
@@ -226,3 +491,72 @@ func (a *api) handleGetActiveCountries() http.HandlerFunc {
 		a.Success(w, r, http.StatusOK, countries, nil)
 	}
 }
+
+// handleGetUserQuietHours gets a users timezone and email quiet hours
+// @Summary Get User Quiet Hours
+// @Description Gets a users timezone and do not disturb window for non-urgent emails
+// @Tags user
+// @Produce  json
+// @Param userId path string true "the user ID"
+// @Success 200 object standardJsonResponse{data=model.QuietHours}
+// @Failure 403 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /users/{userId}/quiet-hours [get]
+func (a *api) handleGetUserQuietHours() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		Quiet, err := a.db.GetUserQuietHours(vars["userId"])
+		if err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		a.Success(w, r, http.StatusOK, Quiet, nil)
+	}
+}
+
+// handleUpdateUserQuietHours updates a users timezone and email quiet hours
+// @Summary Update User Quiet Hours
+// @Description Updates a users timezone and do not disturb window, non-urgent emails during the window are deferred until it ends while security emails still send immediately. Empty start and end clears the window.
+// @Tags user
+// @Produce  json
+// @Param userId path string true "the user ID"
+// @Param quietHours body model.QuietHours true "the timezone and quiet hours window (HH:MM)"
+// @Success 200 object standardJsonResponse{data=model.QuietHours}
+// @Failure 400 object standardJsonResponse{}
+// @Failure 403 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /users/{userId}/quiet-hours [put]
+func (a *api) handleUpdateUserQuietHours() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		var Quiet = model.QuietHours{}
+		body, bodyErr := ioutil.ReadAll(r.Body)
+		if bodyErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, bodyErr.Error()))
+			return
+		}
+
+		jsonErr := json.Unmarshal(body, &Quiet)
+		if jsonErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, jsonErr.Error()))
+			return
+		}
+
+		if err := validateQuietHours(&Quiet); err != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, err.Error()))
+			return
+		}
+
+		if err := a.db.UpdateUserQuietHours(vars["userId"], &Quiet); err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		a.Success(w, r, http.StatusOK, Quiet, nil)
+	}
+}
@@ -0,0 +1,212 @@
+package api
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+)
+
+// userExportManifestEntry describes a file within the users data export archive
+type userExportManifestEntry struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Error set when the files data couldn't be exported, the file is then left out of the archive
+	Error string `json:"error,omitempty"`
+}
+
+// userExportManifest lists the contents of the users data export archive
+type userExportManifest struct {
+	UserId      string                     `json:"userId"`
+	CreatedDate time.Time                  `json:"createdDate"`
+	Files       []*userExportManifestEntry `json:"files"`
+}
+
+// userExportArchive writes json entries into a zip archive while tracking them for the manifest
+type userExportArchive struct {
+	zw       *zip.Writer
+	manifest *userExportManifest
+}
+
+// create starts a new archive entry recording it in the manifest
+func (e *userExportArchive) create(Name string, Description string) (*json.Encoder, error) {
+	f, err := e.zw.Create(Name)
+	if err != nil {
+		return nil, err
+	}
+	e.manifest.Files = append(e.manifest.Files, &userExportManifestEntry{Name: Name, Description: Description})
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+
+	return enc, nil
+}
+
+// fail records in the manifest that the entry couldn't be exported instead of writing it
+func (e *userExportArchive) fail(Name string, Description string) {
+	e.manifest.Files = append(e.manifest.Files, &userExportManifestEntry{
+		Name: Name, Description: Description, Error: "EXPORT_FAILED",
+	})
+}
+
+// add writes a whole json archive entry
+func (e *userExportArchive) add(Name string, Description string, Data interface{}) error {
+	enc, err := e.create(Name, Description)
+	if err != nil {
+		return err
+	}
+
+	return enc.Encode(Data)
+}
+
+// handleUserDataExport downloads all the users personal data as a single json document
+// @Summary User Data Export
+// @Description Downloads the users profile, owned battles and their plans, votes, owned storyboards and their stories,
+// @Description and team memberships as a single json document for a data subject access request.
+// @Tags user
+// @Produce  json
+// @Param userId path string true "the user ID"
+// @Success 200 object model.UserDataExport
+// @Failure 403 object standardJsonResponse{}
+// @Failure 404 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /users/{userId}/export [get]
+func (a *api) handleUserDataExport() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		UserID := vars["userId"]
+
+		Export, err := a.db.ExportUserData(UserID)
+		if err != nil {
+			if err.Error() == "USER_NOT_FOUND" {
+				a.Failure(w, r, http.StatusNotFound, Errorf(ENOTFOUND, err.Error()))
+				return
+			}
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="thunderdome-user-%s.json"`, UserID))
+		w.Header().Set("Cache-Control", "no-store")
+
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(Export); err != nil {
+			a.logger.Error("error writing user data export", zap.String("user_id", UserID), zap.Error(err))
+		}
+	}
+}
+
+// handleUserDataExportArchive downloads the users data export as a zip archive of json files with a manifest
+// @Summary User Data Export Archive
+// @Description Downloads the same data as the user data export as a zip archive of json files, along with quiet hours,
+// @Description api key metadata, organization memberships and retros, with a manifest.json listing the archive contents.
+// @Tags user
+// @Produce  application/zip
+// @Param userId path string true "the user ID"
+// @Success 200 {file} binary
+// @Failure 403 object standardJsonResponse{}
+// @Failure 404 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /users/{userId}/export/archive [get]
+func (a *api) handleUserDataExportArchive() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		UserID := vars["userId"]
+
+		Export, err := a.db.ExportUserData(UserID)
+		if err != nil {
+			if err.Error() == "USER_NOT_FOUND" {
+				a.Failure(w, r, http.StatusNotFound, Errorf(ENOTFOUND, err.Error()))
+				return
+			}
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", `attachment; filename="thunderdome-user-export.zip"`)
+		w.Header().Set("Cache-Control", "no-store")
+
+		zw := zip.NewWriter(w)
+		archive := &userExportArchive{
+			zw: zw,
+			manifest: &userExportManifest{
+				UserId:      UserID,
+				CreatedDate: Export.CreatedDate,
+			},
+		}
+
+		// headers are already sent once writing starts, so errors can only be logged
+		if err := a.writeUserDataExport(archive, Export); err != nil {
+			a.logger.Error("error writing user data export", zap.String("user_id", UserID), zap.Error(err))
+		}
+		if err := archive.add("manifest.json", "the contents of this archive", archive.manifest); err != nil {
+			a.logger.Error("error writing user data export manifest", zap.String("user_id", UserID), zap.Error(err))
+		}
+		if err := zw.Close(); err != nil {
+			a.logger.Error("error closing user data export archive", zap.String("user_id", UserID), zap.Error(err))
+		}
+	}
+}
+
+// writeUserDataExport writes each section of the users data export to the archive,
+// followed by the data only included in the archive, data that fails to load is logged and marked failed in the manifest
+func (a *api) writeUserDataExport(archive *userExportArchive, Export *model.UserDataExport) error {
+	UserID := Export.User.Id
+
+	for _, e := range []struct {
+		name        string
+		description string
+		data        interface{}
+	}{
+		{"profile.json", "account profile including the avatar setting", Export.User},
+		{"battles.json", "battles owned along with their plans", Export.Battles},
+		{"votes.json", "votes cast on plans in any battle", Export.Votes},
+		{"storyboards.json", "storyboards owned along with their stories", Export.Storyboards},
+		{"teams.json", "team memberships", Export.Teams},
+	} {
+		if err := archive.add(e.name, e.description, e.data); err != nil {
+			return err
+		}
+	}
+
+	for _, e := range []struct {
+		name        string
+		description string
+		load        func() (interface{}, error)
+	}{
+		{"quiet-hours.json", "timezone and email quiet hours", func() (interface{}, error) {
+			return a.db.GetUserQuietHours(UserID)
+		}},
+		{"api-keys.json", "api key metadata, key secrets are never exported", func() (interface{}, error) {
+			return a.db.GetUserApiKeys(UserID)
+		}},
+		{"organizations.json", "organization memberships", func() (interface{}, error) {
+			return a.db.OrganizationListByUser(UserID, 1000, 0), nil
+		}},
+		{"retros.json", "retros participated in", func() (interface{}, error) {
+			return a.db.RetroGetByUser(UserID)
+		}},
+	} {
+		Data, err := e.load()
+		if err != nil {
+			a.logger.Error("error loading user data export file", zap.String("user_id", UserID), zap.String("file", e.name), zap.Error(err))
+			archive.fail(e.name, e.description)
+			continue
+		}
+		if err := archive.add(e.name, e.description, Data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// handleGetUserSessions gets the users active login sessions
+// @Summary Get User Sessions
+// @Description Gets the users active login sessions with the device and ip address they were created from
+// @Description and when they were last active, sessions unused past the idle timeout are ended
+// @Tags user
+// @Produce  json
+// @Param userId path string true "the user ID"
+// @Success 200 object standardJsonResponse{data=[]model.UserSession}
+// @Failure 403 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /users/{userId}/sessions [get]
+func (a *api) handleGetUserSessions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		Sessions, err := a.db.GetUserSessions(vars["userId"], a.requestSessionID(r))
+		if err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		a.Success(w, r, http.StatusOK, Sessions, nil)
+	}
+}
+
+// handleDeleteSession revokes one of the users login sessions, revoking the current session logs out
+// @Summary Delete User Session
+// @Description Revokes one of the users login sessions signing that device out, revoking the current session logs out
+// @Tags user
+// @Produce  json
+// @Param userId path string true "the user ID"
+// @Param sessionId path string true "the session ID from the users sessions"
+// @Success 200 object standardJsonResponse{}
+// @Failure 403 object standardJsonResponse{}
+// @Failure 404 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /users/{userId}/sessions/{sessionId} [delete]
+func (a *api) handleDeleteSession() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		SessionID, err := a.db.DeleteUserSession(vars["userId"], vars["sessionId"])
+		if err != nil {
+			a.Failure(w, r, http.StatusNotFound, Errorf(ENOTFOUND, err.Error()))
+			return
+		}
+		a.evictSession(SessionID)
+
+		if SessionID == a.requestSessionID(r) {
+			a.clearUserCookies(w)
+		}
+
+		a.Success(w, r, http.StatusOK, nil, nil)
+	}
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"github.com/gorilla/mux"
+)
+
+type userViewRequestBody struct {
+	Name   string          `json:"name"`
+	Filter json.RawMessage `json:"filter" swaggertype:"object"`
+}
+
+// getRequestViewFilter gets the filter of the users saved view referenced by the view query param,
+// an empty filter when not referenced, writes the failure response and returns false when the view can't be applied
+func (a *api) getRequestViewFilter(w http.ResponseWriter, r *http.Request, UserID string, List string) (*model.UserViewFilter, bool) {
+	ViewID := r.URL.Query().Get("view")
+	if ViewID == "" {
+		return &model.UserViewFilter{List: List}, true
+	}
+
+	View, err := a.db.GetUserView(UserID, ViewID)
+	if err != nil {
+		a.Failure(w, r, http.StatusNotFound, Errorf(ENOTFOUND, "VIEW_NOT_FOUND"))
+		return nil, false
+	}
+	if View.Filter.List != List {
+		a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, "INVALID_VIEW_FILTER"))
+		return nil, false
+	}
+
+	// team filtered views only apply while the user is still on the team
+	if View.Filter.TeamId != "" {
+		if Role, err := a.db.TeamUserRole(UserID, View.Filter.TeamId); err != nil || Role == "" {
+			a.Failure(w, r, http.StatusForbidden, Errorf(EUNAUTHORIZED, "REQUIRES_TEAM_USER"))
+			return nil, false
+		}
+	}
+
+	return View.Filter, true
+}
+
+// handleGetUserViews gets the users saved battle and storyboard list views
+// @Summary Get User Views
+// @Description Gets the users saved battle and storyboard list filters
+// @Tags user
+// @Produce  json
+// @Param userId path string true "the user ID"
+// @Success 200 object standardJsonResponse{data=[]model.UserView}
+// @Failure 403 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /users/{userId}/views [get]
+func (a *api) handleGetUserViews() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		Views, err := a.db.GetUserViews(vars["userId"])
+		if err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		a.Success(w, r, http.StatusOK, Views, nil)
+	}
+}
+
+// handleSaveUserView saves a battle or storyboard list view, replacing an existing view with the same name
+// @Summary Save User View
+// @Description Saves a battle or storyboard list filter by name to apply to the users battles or storyboards list with the view query param
+// @Tags user
+// @Produce  json
+// @Param userId path string true "the user ID"
+// @Param view body userViewRequestBody true "the view name and filter"
+// @Success 200 object standardJsonResponse{data=model.UserView}
+// @Failure 400 object standardJsonResponse{}
+// @Failure 403 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /users/{userId}/views [post]
+func (a *api) handleSaveUserView() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		var v = userViewRequestBody{}
+		body, bodyErr := ioutil.ReadAll(r.Body)
+		if bodyErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, bodyErr.Error()))
+			return
+		}
+
+		jsonErr := json.Unmarshal(body, &v)
+		if jsonErr != nil {
+			a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, jsonErr.Error()))
+			return
+		}
+
+		View, err := a.db.SaveUserView(vars["userId"], v.Name, string(v.Filter))
+		if err != nil {
+			switch err.Error() {
+			case "INVALID_VIEW_NAME", "INVALID_VIEW_FILTER", "VIEW_LIMIT_REACHED":
+				a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, err.Error()))
+			default:
+				a.Failure(w, r, http.StatusInternalServerError, err)
+			}
+			return
+		}
+
+		a.Success(w, r, http.StatusOK, View, nil)
+	}
+}
+
+// handleDeleteUserView deletes a users saved list view
+// @Summary Delete User View
+// @Description Deletes a users saved battle or storyboard list filter
+// @Tags user
+// @Produce  json
+// @Param userId path string true "the user ID"
+// @Param viewId path string true "the view ID"
+// @Success 200 object standardJsonResponse{}
+// @Failure 403 object standardJsonResponse{}
+// @Failure 500 object standardJsonResponse{}
+// @Security ApiKeyAuth
+// @Router /users/{userId}/views/{viewId} [delete]
+func (a *api) handleDeleteUserView() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		if err := a.db.DeleteUserView(vars["userId"], vars["viewId"]); err != nil {
+			a.Failure(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		a.Success(w, r, http.StatusOK, nil, nil)
+	}
+}
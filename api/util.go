@@ -7,9 +7,12 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/StevenWeathers/thunderdome-planning-poker/logging"
 	"github.com/StevenWeathers/thunderdome-planning-poker/model"
 	"github.com/go-ldap/ldap/v3"
 	"github.com/spf13/viper"
@@ -22,9 +25,15 @@ type userAccount struct {
 	Email string `json:"email" validate:"required,email"`
 }
 
-type userPassword struct {
-	Password1 string `json:"password1" validate:"required,min=6,max=72"`
-	Password2 string `json:"password2" validate:"required,min=6,max=72,eqfield=Password1"`
+// contains checks if a string is present in a slice
+func contains(s []string, str string) bool {
+	for _, v := range s {
+		if v == str {
+			return true
+		}
+	}
+
+	return false
 }
 
 // validateUserAccount makes sure user's name, email are valid before creating the account
@@ -42,39 +51,119 @@ func validateUserAccount(name string, email string) (UserName string, UserEmail
 	return name, email, nil
 }
 
+// validateUserEmail makes sure the email is valid e.g. before changing a users email to it
+func validateUserEmail(email string) error {
+	return validator.New().Var(email, "required,email")
+}
+
 // validateUserAccountWithPasswords makes sure user's name, email, and password are valid before creating the account
-func validateUserAccountWithPasswords(name string, email string, pwd1 string, pwd2 string) (UserName string, UserEmail string, UpdatedPassword string, validateErr error) {
+func validateUserAccountWithPasswords(name string, email string, pwd1 string, pwd2 string, policy passwordPolicy) (UserName string, UserEmail string, UpdatedPassword string, validateErr error) {
 	v := validator.New()
 	a := userAccount{
 		Name:  name,
 		Email: email,
 	}
-	p := userPassword{
-		Password1: pwd1,
-		Password2: pwd2,
-	}
 	aErr := v.Struct(a)
 	if aErr != nil {
 		return "", "", "", aErr
 	}
-	pErr := v.Struct(p)
-	if pErr != nil {
+	if _, pErr := validateUserPassword(pwd1, pwd2, policy); pErr != nil {
 		return "", "", "", pErr
 	}
 
 	return name, email, pwd1, nil
 }
 
-// validateUserPassword makes sure user password is valid before updating the password
-func validateUserPassword(pwd1 string, pwd2 string) (UpdatedPassword string, validateErr error) {
-	v := validator.New()
-	a := userPassword{
-		Password1: pwd1,
-		Password2: pwd2,
+// validateUserPassword makes sure user password matches its confirmation and meets the password policy
+func validateUserPassword(pwd1 string, pwd2 string, policy passwordPolicy) (UpdatedPassword string, validateErr error) {
+	if pwd1 == "" {
+		return "", errors.New("PASSWORD_REQUIRED")
+	}
+	if pwd1 != pwd2 {
+		return "", errors.New("PASSWORD_MISMATCH")
+	}
+	if err := validatePasswordStrength(pwd1, policy); err != nil {
+		return "", err
+	}
+
+	return pwd1, nil
+}
+
+// validateUserName makes sure user's name is not empty and doesn't contain any denied words
+func validateUserName(name string, denylist []string) error {
+	UserName := strings.ToLower(strings.TrimSpace(name))
+	if UserName == "" {
+		return errors.New("NAME_REQUIRED")
+	}
+
+	for _, word := range denylist {
+		word = strings.ToLower(strings.TrimSpace(word))
+		if word != "" && strings.Contains(UserName, word) {
+			return errors.New("NAME_NOT_ALLOWED")
+		}
+	}
+
+	return nil
+}
+
+// validateQuietHours makes sure the timezone is known and quiet hours are either both set as HH:MM or both empty
+func validateQuietHours(Quiet *model.QuietHours) error {
+	if Quiet.Timezone != "" {
+		if _, err := time.LoadLocation(Quiet.Timezone); err != nil {
+			return errors.New("INVALID_TIMEZONE")
+		}
+	}
+	if (Quiet.Start == "") != (Quiet.End == "") {
+		return errors.New("INVALID_QUIET_HOURS")
+	}
+	for _, t := range []string{Quiet.Start, Quiet.End} {
+		if t == "" {
+			continue
+		}
+		if _, err := time.Parse("15:04", t); err != nil {
+			return errors.New("INVALID_QUIET_HOURS")
+		}
+	}
+
+	return nil
+}
+
+// validateJoinName validates the user's name when a name is required to join
+func (a *api) validateJoinName(name string) error {
+	if !a.config.RequireNameToJoin {
+		return nil
 	}
-	err := v.Struct(a)
 
-	return pwd1, err
+	return validateUserName(name, a.config.NameDenylist)
+}
+
+// websocketOriginChecker returns a websocket upgrade origin check that allows same origin requests
+// along with any origin in the allowed origins list, a "*" allows any origin (for development)
+func websocketOriginChecker(AllowedOrigins []string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+
+		u, err := url.Parse(origin)
+		if err != nil {
+			return false
+		}
+
+		if strings.EqualFold(u.Host, r.Host) {
+			return true
+		}
+
+		for _, allowed := range AllowedOrigins {
+			allowed = strings.TrimSuffix(strings.TrimSpace(allowed), "/")
+			if allowed == "*" || strings.EqualFold(allowed, u.Scheme+"://"+u.Host) {
+				return true
+			}
+		}
+
+		return false
+	}
 }
 
 // createUserCookie creates the users cookie
@@ -100,6 +189,86 @@ func (a *api) createUserCookie(w http.ResponseWriter, UserID string) error {
 	return nil
 }
 
+// enforceSessionLimit ends the users oldest sessions beyond the maximum login sessions,
+// notifying any connected battle clients of the ended session
+func (a *api) enforceSessionLimit(User *model.User) {
+	if a.config.MaxUserSessions <= 0 || (User.Type == adminUserType && a.config.MaxUserSessionsAdminExempt) {
+		return
+	}
+
+	count, err := a.db.CountUserSessions(User.Id)
+	if err != nil {
+		return
+	}
+
+	for ; count > a.config.MaxUserSessions; count-- {
+		SessionID, err := a.db.DeleteOldestUserSession(User.Id)
+		if err != nil {
+			return
+		}
+		a.evictSession(SessionID)
+	}
+}
+
+// requestSessionID gets the SessionID from the requests session cookie, empty when missing or invalid
+func (a *api) requestSessionID(r *http.Request) string {
+	var SessionID string
+
+	if cookie, err := r.Cookie(a.config.SessionCookieName); err == nil {
+		_ = a.cookie.Decode(a.config.SessionCookieName, cookie.Value, &SessionID)
+	}
+
+	return SessionID
+}
+
+// rotateSession sets the session cookie to the fresh session, ending the requests pre-existing session
+// so a session id is never carried across a privilege change (session fixation)
+func (a *api) rotateSession(w http.ResponseWriter, r *http.Request, SessionID string) error {
+	if a.config.RotateSessionOnPrivilegeChange {
+		if PriorSessionID := a.requestSessionID(r); PriorSessionID != "" && PriorSessionID != SessionID {
+			if err := a.db.DeleteSession(PriorSessionID); err == nil {
+				a.evictSession(PriorSessionID)
+			}
+		}
+	}
+
+	// best effort, sessions are still usable without their client details
+	_ = a.db.SetSessionClient(SessionID, r.UserAgent(), a.requestRemoteIP(r))
+
+	return a.createSessionCookie(w, SessionID)
+}
+
+// evictSession notifies and disconnects the battle, storyboard, and retro clients connected with the ended session
+func (a *api) evictSession(SessionID string) {
+	a.battleService.EvictSession(SessionID)
+	a.storyboardService.EvictSession(SessionID)
+	a.retroService.EvictSession(SessionID)
+}
+
+// evictUser notifies and disconnects all of the users battle, storyboard, and retro clients,
+// including guests who have no session
+func (a *api) evictUser(UserID string) {
+	a.battleService.EvictUser(UserID)
+	a.storyboardService.EvictUser(UserID)
+	a.retroService.EvictUser(UserID)
+}
+
+// endUserSessions ends all of the users sessions after their privileges change so they sign in again
+// with a fresh session, notifying any connected clients of the ended sessions
+func (a *api) endUserSessions(UserID string) {
+	if !a.config.RotateSessionOnPrivilegeChange {
+		return
+	}
+
+	SessionIDs, err := a.db.DeleteUserSessions(UserID)
+	if err != nil {
+		return
+	}
+	for _, SessionID := range SessionIDs {
+		a.evictSession(SessionID)
+	}
+}
+
 // createSessionCookie creates the user's session cookie
 func (a *api) createSessionCookie(w http.ResponseWriter, SessionID string) error {
 	encoded, err := a.cookie.Encode(a.config.SessionCookieName, SessionID)
@@ -123,6 +292,42 @@ func (a *api) createSessionCookie(w http.ResponseWriter, SessionID string) error
 	return nil
 }
 
+// createFrontendCookie creates the UI's user cookie for logins that complete with a redirect instead of the UI
+func (a *api) createFrontendCookie(w http.ResponseWriter, User *model.User) error {
+	value, err := json.Marshal(map[string]interface{}{
+		"id":                   User.Id,
+		"name":                 User.Name,
+		"email":                User.Email,
+		"rank":                 User.Type,
+		"locale":               User.Locale,
+		"notificationsEnabled": User.NotificationsEnabled,
+	})
+	if err != nil {
+		return err
+	}
+
+	cookie := &http.Cookie{
+		Name:     a.config.FrontendCookieName,
+		Value:    url.PathEscape(string(value)),
+		Path:     a.config.PathPrefix + "/",
+		MaxAge:   86400 * 365,
+		SameSite: http.SameSiteStrictMode,
+	}
+	http.SetCookie(w, cookie)
+
+	return nil
+}
+
+// loginFailure the error to respond to a failed login with, disabled accounts are told so
+// as they are only reported once the credentials were verified
+func loginFailure(err error) error {
+	if err.Error() == "USER_DISABLED" {
+		return Errorf(EUNAUTHORIZED, "USER_DISABLED")
+	}
+
+	return Errorf(EINVALID, "INVALID_LOGIN")
+}
+
 // clearUserCookies wipes the frontend and backend cookies
 // used in the event of bad cookie reads
 func (a *api) clearUserCookies(w http.ResponseWriter) {
@@ -174,6 +379,12 @@ func (a *api) validateUserCookie(w http.ResponseWriter, r *http.Request) (string
 		return "", errors.New("NO_USER_COOKIE")
 	}
 
+	// guests past the max session lifetime are removed, this covers battle, retro and storyboard socket joins
+	if a.guestSessionExpired(UserID) {
+		a.clearUserCookies(w)
+		return "", errors.New("GUEST_SESSION_EXPIRED")
+	}
+
 	return UserID, nil
 }
 
@@ -248,6 +459,26 @@ func (a *api) Failure(w http.ResponseWriter, r *http.Request, code int, err erro
 	w.Write(response)
 }
 
+// emailUnavailableFailure responds with a conflict when the email belongs to an active or deleted account
+func (a *api) emailUnavailableFailure(w http.ResponseWriter, r *http.Request, err error) {
+	switch err.Error() {
+	case "EMAIL_IN_USE", "EMAIL_IN_USE_DELETED_ACCOUNT":
+		a.Failure(w, r, http.StatusConflict, Errorf(ECONFLICT, err.Error()))
+	default:
+		a.Failure(w, r, http.StatusInternalServerError, err)
+	}
+}
+
+// tokenFailure responds with a consistent failure for expired or invalid tokens e.g. reset, verify
+func (a *api) tokenFailure(w http.ResponseWriter, r *http.Request, err error) {
+	switch err.Error() {
+	case "TOKEN_EXPIRED", "TOKEN_NOT_FOUND", "PASSWORD_RECENTLY_USED":
+		a.Failure(w, r, http.StatusBadRequest, Errorf(EINVALID, err.Error()))
+	default:
+		a.Failure(w, r, http.StatusInternalServerError, err)
+	}
+}
+
 // getJSONRequestBody gets a JSON request body broken into a key/value map
 func getJSONRequestBody(r *http.Request, w http.ResponseWriter) map[string]interface{} {
 	body, _ := ioutil.ReadAll(r.Body) // check for errors
@@ -281,6 +512,35 @@ func getLimitOffsetFromRequest(r *http.Request) (limit int, offset int) {
 	return Limit, Offset
 }
 
+// getDateRangeFromRequest gets the from and to (inclusive) date query parameters from the request
+// defaulting to the last 30 days, returning the range as [from, to+1day)
+func getDateRangeFromRequest(r *http.Request, now time.Time) (from time.Time, to time.Time, err error) {
+	const layout = "2006-01-02"
+	query := r.URL.Query()
+
+	To := now.UTC().Truncate(24 * time.Hour)
+	if q := query.Get("to"); q != "" {
+		To, err = time.Parse(layout, q)
+		if err != nil {
+			return from, to, Errorf(EINVALID, "INVALID_DATE_RANGE")
+		}
+	}
+
+	From := To.AddDate(0, 0, -30)
+	if q := query.Get("from"); q != "" {
+		From, err = time.Parse(layout, q)
+		if err != nil {
+			return from, to, Errorf(EINVALID, "INVALID_DATE_RANGE")
+		}
+	}
+
+	if From.After(To) || To.Sub(From) > 366*24*time.Hour {
+		return from, to, Errorf(EINVALID, "INVALID_DATE_RANGE")
+	}
+
+	return From, To.AddDate(0, 0, 1), nil
+}
+
 // getSearchFromRequest gets the search query parameter from the request
 func getSearchFromRequest(r *http.Request) (search string, err error) {
 	v := validator.New()
@@ -332,18 +592,25 @@ func (a *api) authAndCreateUserLdap(UserName string, UserPassword string) (*mode
 	searchRequest := ldap.NewSearchRequest(viper.GetString("auth.ldap.basedn"),
 		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
 		fmt.Sprintf(viper.GetString("auth.ldap.filter"), ldap.EscapeFilter(UserName)),
-		[]string{"dn", viper.GetString("auth.ldap.mail_attr"), viper.GetString("auth.ldap.cn_attr")},
+		[]string{
+			"dn",
+			viper.GetString("auth.ldap.mail_attr"),
+			viper.GetString("auth.ldap.cn_attr"),
+			viper.GetString("auth.ldap.company_attr"),
+			viper.GetString("auth.ldap.job_title_attr"),
+			viper.GetString("auth.ldap.group_attr"),
+		},
 		nil,
 	)
 
 	sr, err := l.Search(searchRequest)
 	if err != nil {
-		a.logger.Error("Failed performing ldap search query", zap.String("username", sanitizeUserInputForLogs(UserName)), zap.Error(err))
+		a.logger.Error("Failed performing ldap search query", logging.Sensitive("username", sanitizeUserInputForLogs(UserName)), zap.Error(err))
 		return AuthedUser, SessionId, err
 	}
 
 	if len(sr.Entries) != 1 {
-		a.logger.Error("User does not exist or too many entries returned", zap.String("username", sanitizeUserInputForLogs(UserName)))
+		a.logger.Error("User does not exist or too many entries returned", logging.Sensitive("username", sanitizeUserInputForLogs(UserName)))
 		return AuthedUser, SessionId, errors.New("user not found")
 	}
 
@@ -353,17 +620,17 @@ func (a *api) authAndCreateUserLdap(UserName string, UserPassword string) (*mode
 
 	err = l.Bind(userdn, UserPassword)
 	if err != nil {
-		a.logger.Error("Failed authenticating user", zap.String("username", sanitizeUserInputForLogs(UserName)))
+		a.logger.Error("Failed authenticating user", logging.Sensitive("username", sanitizeUserInputForLogs(UserName)))
 		return AuthedUser, SessionId, err
 	}
 
 	AuthedUser, err = a.db.GetUserByEmail(useremail)
-	if AuthedUser.Disabled {
-		return nil, "", fmt.Errorf("user is disabled")
+	if AuthedUser != nil && AuthedUser.Disabled {
+		return nil, "", errors.New("USER_DISABLED")
 	}
 
 	if AuthedUser == nil {
-		a.logger.Error("User does not exist in database, auto-recruit", zap.String("useremail", sanitizeUserInputForLogs(useremail)))
+		a.logger.Error("User does not exist in database, auto-recruit", logging.Sensitive("useremail", sanitizeUserInputForLogs(useremail)))
 		newUser, verifyID, sessionId, err := a.db.CreateUserRegistered(usercn, useremail, "", "")
 		if err != nil {
 			a.logger.Error("Failed auto-creating new user", zap.Error(err))
@@ -384,5 +651,106 @@ func (a *api) authAndCreateUserLdap(UserName string, UserPassword string) (*mode
 		}
 	}
 
+	// keep the directory managed profile fields authoritative
+	if len(a.config.LdapManagedFields) > 0 {
+		directoryFields := map[string]string{
+			"name":      usercn,
+			"company":   sr.Entries[0].GetAttributeValue(viper.GetString("auth.ldap.company_attr")),
+			"job_title": sr.Entries[0].GetAttributeValue(viper.GetString("auth.ldap.job_title_attr")),
+		}
+		managedFields := make(map[string]string)
+		for field, value := range directoryFields {
+			if contains(a.config.LdapManagedFields, field) {
+				managedFields[field] = value
+			}
+		}
+		if err := a.db.UpdateUserDirectoryFields(AuthedUser.Id, managedFields); err != nil {
+			a.logger.Error("Failed syncing user directory fields", zap.Error(err))
+		} else if SyncedUser, err := a.db.GetUser(AuthedUser.Id); err == nil {
+			AuthedUser = SyncedUser
+		}
+	}
+
+	// reconcile the users type with their directory groups so removal from an admin group demotes them
+	if len(a.config.LdapAdminGroups) > 0 {
+		UserType := ldapUserType(sr.Entries[0].GetAttributeValues(viper.GetString("auth.ldap.group_attr")), a.config.LdapAdminGroups)
+		if AuthedUser.Type != UserType {
+			if err := a.db.SetUserType(AuthedUser.Id, UserType); err != nil {
+				a.logger.Error("Failed reconciling user type with ldap groups", zap.Error(err))
+			} else {
+				AuthedUser.Type = UserType
+			}
+		}
+	}
+
+	return AuthedUser, SessionId, nil
+}
+
+// ldapUserType the user type granted by the users LDAP group DNs, admin when a member of any admin group
+func ldapUserType(MemberOf []string, AdminGroups []string) string {
+	for _, Group := range MemberOf {
+		GroupDN, err := ldap.ParseDN(Group)
+		if err != nil {
+			continue
+		}
+		for _, AdminGroup := range AdminGroups {
+			if AdminDN, err := ldap.ParseDN(AdminGroup); err == nil && GroupDN.EqualFold(AdminDN) {
+				return adminUserType
+			}
+		}
+	}
+
+	return registeredUserType
+}
+
+// Authenticate using the verified OpenID Connect claims and if user does not exist, automatically add user as a verified user,
+// users with MFA enabled must login with their password and passcode as the provider doesn't satisfy it
+func (a *api) authAndCreateUserOIDC(Claims *oidcClaims) (*model.User, string, error) {
+	UserEmail := strings.ToLower(Claims.Email)
+
+	AuthedUser, _ := a.db.GetUserByEmail(UserEmail)
+	if AuthedUser != nil && AuthedUser.Disabled {
+		return nil, "", errors.New("USER_DISABLED")
+	}
+	if AuthedUser != nil {
+		User, err := a.db.GetUser(AuthedUser.Id)
+		if err != nil {
+			return nil, "", err
+		}
+		if User.MFAEnabled {
+			return nil, "", errors.New("MFA_REQUIRED")
+		}
+	}
+
+	if AuthedUser == nil {
+		a.logger.Info("User does not exist in database, auto-recruit", logging.Sensitive("useremail", sanitizeUserInputForLogs(UserEmail)))
+		newUser, verifyID, sessionId, err := a.db.CreateUserRegistered(oidcUserName(Claims), UserEmail, "", "")
+		if err != nil {
+			a.logger.Error("Failed auto-creating new user", zap.Error(err))
+			return nil, "", err
+		}
+		if err := a.db.VerifyUserAccount(verifyID); err != nil {
+			a.logger.Error("Failed verifying new user", zap.Error(err))
+			return nil, "", err
+		}
+
+		return newUser, sessionId, nil
+	}
+
+	SessionId, err := a.db.CreateSession(AuthedUser.Id)
+	if err != nil {
+		a.logger.Error("Failed creating user session", zap.Error(err))
+		return nil, "", err
+	}
+
 	return AuthedUser, SessionId, nil
 }
+
+// authAndCreateUserSocial logs in or creates the user with the social login providers verified email
+func (a *api) authAndCreateUserSocial(Identity *socialIdentity) (*model.User, string, error) {
+	return a.authAndCreateUserOIDC(&oidcClaims{
+		Email:             Identity.Email,
+		Name:              Identity.Name,
+		PreferredUsername: Identity.Username,
+	})
+}
@@ -1,7 +1,31 @@
 package api
 
 import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/api/battle"
+	"github.com/StevenWeathers/thunderdome-planning-poker/db"
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
 )
 
 // TestValidUserAccount calls validateUserAccountWithPasswords with valid user inputs for name, email, password1, and password2
@@ -10,7 +34,7 @@ func TestValidUserAccount(t *testing.T) {
 	Email := "thor@thunderdome.dev"
 	Password := "lokiIsAJoke"
 
-	name, email, password, err := validateUserAccountWithPasswords(Name, Email, Password, Password)
+	name, email, password, err := validateUserAccountWithPasswords(Name, Email, Password, Password, defaultPasswordPolicy)
 	if err != nil || (Name != name || Email != email || Password != password) {
 		t.Fatalf(`validateUserAccountWithPasswords = %v error`, err)
 	}
@@ -18,7 +42,7 @@ func TestValidUserAccount(t *testing.T) {
 
 // TestInvalidUserAccount calls validateUserAccountWithPasswords with invalid user input for email
 func TestInvalidUserAccount(t *testing.T) {
-	_, _, _, err := validateUserAccountWithPasswords("Thor", "thor", "lokiIsAJoke", "lokiIsAJoke")
+	_, _, _, err := validateUserAccountWithPasswords("Thor", "thor", "lokiIsAJoke", "lokiIsAJoke", defaultPasswordPolicy)
 	if err == nil {
 		t.Fatalf(`validateUserAccountWithPasswords = %v, want error`, err)
 	}
@@ -28,7 +52,7 @@ func TestInvalidUserAccount(t *testing.T) {
 func TestValidUserPassword(t *testing.T) {
 	Password := "lokiIsAJoke"
 
-	password, err := validateUserPassword(Password, Password)
+	password, err := validateUserPassword(Password, Password, defaultPasswordPolicy)
 	if err != nil || (Password != password) {
 		t.Fatalf(`validateUserAccountWithPasswords = %v error`, err)
 	}
@@ -38,8 +62,818 @@ func TestValidUserPassword(t *testing.T) {
 func TestInvalidUserPassword(t *testing.T) {
 	Password := "lokiIsAJoke"
 
-	_, err := validateUserPassword(Password, Password+"fail")
+	_, err := validateUserPassword(Password, Password+"fail", defaultPasswordPolicy)
 	if err == nil {
 		t.Fatalf(`validateUserAccountWithPasswords = %v, want error`, err)
 	}
 }
+
+// TestPasswordStrength calls validatePasswordStrength making sure each failed rule
+// is reported by its own error
+func TestPasswordStrength(t *testing.T) {
+	Policy := passwordPolicy{MinLength: 10, RequireSymbol: true, RequireNumber: true, RequireMixedCase: true}
+
+	cases := map[string]string{
+		"Mj0lnir!":              "PASSWORD_TOO_SHORT",
+		"stormbreaker1!":        "PASSWORD_REQUIRES_MIXED_CASE",
+		"Stormbreaker!":         "PASSWORD_REQUIRES_NUMBER",
+		"Stormbreaker1":         "PASSWORD_REQUIRES_SYMBOL",
+		"Stormbreaker1!":        "",
+		strings.Repeat("a", 73): "PASSWORD_TOO_LONG",
+	}
+	for Password, want := range cases {
+		err := validatePasswordStrength(Password, Policy)
+		if (err == nil && want != "") || (err != nil && err.Error() != want) {
+			t.Fatalf(`validatePasswordStrength(%s) = %v, want %s`, Password, err, want)
+		}
+	}
+
+	if err := validatePasswordStrength("Password123", defaultPasswordPolicy); err == nil || err.Error() != "PASSWORD_TOO_COMMON" {
+		t.Fatalf(`validatePasswordStrength(Password123) = %v, want PASSWORD_TOO_COMMON`, err)
+	}
+}
+
+// TestInvalidUserName calls validateUserName with an empty name and a denied name
+func TestInvalidUserName(t *testing.T) {
+	err := validateUserName("  ", []string{})
+	if err == nil || err.Error() != "NAME_REQUIRED" {
+		t.Fatalf(`validateUserName = %v, want NAME_REQUIRED`, err)
+	}
+
+	err = validateUserName("Loki the Trickster", []string{"trickster"})
+	if err == nil || err.Error() != "NAME_NOT_ALLOWED" {
+		t.Fatalf(`validateUserName = %v, want NAME_NOT_ALLOWED`, err)
+	}
+}
+
+// TestWebsocketOriginChecker calls websocketOriginChecker and makes sure same origin and allowed origins
+// are accepted while disallowed origins are rejected
+func TestWebsocketOriginChecker(t *testing.T) {
+	checkOrigin := websocketOriginChecker([]string{"https://asgard.thunderdome.dev"})
+
+	r := httptest.NewRequest("GET", "https://thunderdome.dev/api/arena/1", nil)
+	r.Header.Set("Origin", "https://thunderdome.dev")
+	if !checkOrigin(r) {
+		t.Fatalf(`checkOrigin = false for same origin, want true`)
+	}
+
+	r.Header.Set("Origin", "https://asgard.thunderdome.dev")
+	if !checkOrigin(r) {
+		t.Fatalf(`checkOrigin = false for allowed origin, want true`)
+	}
+
+	r.Header.Set("Origin", "https://jotunheim.dev")
+	if checkOrigin(r) {
+		t.Fatalf(`checkOrigin = true for disallowed origin, want false`)
+	}
+}
+
+// TestGuestSessionExpiry calls guestSessionExpiry with registered users and an unlimited lifetime making sure
+// they don't get an expiry, guest expiries come from the database
+func TestGuestSessionExpiry(t *testing.T) {
+	a := &api{config: &Config{GuestMaxSessionLifetime: 24}}
+	CreatedDate := time.Date(2022, 7, 4, 12, 0, 0, 0, time.UTC)
+
+	if _, ok := a.guestSessionExpiry(&model.User{Type: "REGISTERED", CreatedDate: CreatedDate}); ok {
+		t.Fatalf(`guestSessionExpiry = true for registered user, want false`)
+	}
+
+	a.config.GuestMaxSessionLifetime = 0
+	if _, ok := a.guestSessionExpiry(&model.User{Type: guestUserType, CreatedDate: CreatedDate}); ok {
+		t.Fatalf(`guestSessionExpiry = true with unlimited lifetime, want false`)
+	}
+}
+
+// TestValidateBatchRequest tests the batch size cap and sub-request method and path validation
+func TestValidateBatchRequest(t *testing.T) {
+	valid := batchRequestBody{Requests: []batchSubRequest{{Method: "get", Path: "/users/123"}}}
+	if err := validateBatchRequest(valid); err != nil {
+		t.Fatalf("expected valid batch, got %v", err)
+	}
+
+	if err := validateBatchRequest(batchRequestBody{}); err == nil {
+		t.Fatalf("expected empty batch to be invalid")
+	}
+
+	tooMany := batchRequestBody{Requests: make([]batchSubRequest, maxBatchRequests+1)}
+	for i := range tooMany.Requests {
+		tooMany.Requests[i] = batchSubRequest{Method: "GET", Path: "/alerts"}
+	}
+	if err := validateBatchRequest(tooMany); err == nil {
+		t.Fatalf("expected oversized batch to be invalid")
+	}
+
+	for _, sr := range []batchSubRequest{
+		{Method: "OPTIONS", Path: "/alerts"},
+		{Method: "GET", Path: "alerts"},
+		{Method: "GET", Path: "//example.com/alerts"},
+		{Method: "POST", Path: "/batch"},
+	} {
+		if err := validateBatchRequest(batchRequestBody{Requests: []batchSubRequest{sr}}); err == nil {
+			t.Fatalf("expected %s %s to be invalid", sr.Method, sr.Path)
+		}
+	}
+}
+
+// TestBatchResponseWriter tests the sub-request status defaults to 200 and only the first status is kept
+func TestBatchResponseWriter(t *testing.T) {
+	bw := newBatchResponseWriter()
+	_, _ = bw.Write([]byte(`{"success":true}`))
+	if bw.Status() != http.StatusOK {
+		t.Fatalf("expected default status 200, got %d", bw.Status())
+	}
+
+	bw = newBatchResponseWriter()
+	bw.WriteHeader(http.StatusNotFound)
+	bw.WriteHeader(http.StatusOK)
+	_, _ = bw.Write([]byte(`{"success":false}`))
+	if bw.Status() != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d", bw.Status())
+	}
+	if bw.body.String() != `{"success":false}` {
+		t.Fatalf("unexpected body %q", bw.body.String())
+	}
+}
+
+// TestResolveExportFormat tests locale resolution for export formatting with ISO fallback
+func TestResolveExportFormat(t *testing.T) {
+	d := time.Date(2022, 6, 28, 13, 4, 5, 0, time.UTC)
+
+	de := resolveExportFormat("de-AT")
+	if got := de.FormatDecimal(3.5, 1); got != "3,5" {
+		t.Fatalf("expected 3,5 got %s", got)
+	}
+	if got := de.FormatDate(d); got != "28.06.2022 13:04:05" {
+		t.Fatalf("expected 28.06.2022 13:04:05 got %s", got)
+	}
+	if de.FieldDelimiter != ';' {
+		t.Fatalf("expected semicolon delimiter for comma decimal locale")
+	}
+
+	iso := resolveExportFormat("xx")
+	if got := iso.FormatDecimal(3.5, 1); got != "3.5" {
+		t.Fatalf("expected 3.5 got %s", got)
+	}
+	if got := iso.FormatDate(d); got != "2022-06-28T13:04:05Z" {
+		t.Fatalf("expected ISO date got %s", got)
+	}
+}
+
+// TestValidateQuietHours calls validateQuietHours with no window, a valid overnight window and invalid inputs
+func TestValidateQuietHours(t *testing.T) {
+	if err := validateQuietHours(&model.QuietHours{}); err != nil {
+		t.Fatalf(`validateQuietHours = %v for no quiet hours, want nil`, err)
+	}
+
+	if err := validateQuietHours(&model.QuietHours{Timezone: "America/New_York", Start: "22:00", End: "07:00"}); err != nil {
+		t.Fatalf(`validateQuietHours = %v for overnight window, want nil`, err)
+	}
+
+	if err := validateQuietHours(&model.QuietHours{Timezone: "Asgard/Bifrost"}); err == nil || err.Error() != "INVALID_TIMEZONE" {
+		t.Fatalf(`validateQuietHours = %v, want INVALID_TIMEZONE`, err)
+	}
+
+	if err := validateQuietHours(&model.QuietHours{Start: "22:00"}); err == nil || err.Error() != "INVALID_QUIET_HOURS" {
+		t.Fatalf(`validateQuietHours = %v for missing end, want INVALID_QUIET_HOURS`, err)
+	}
+
+	if err := validateQuietHours(&model.QuietHours{Start: "25:00", End: "07:00"}); err == nil || err.Error() != "INVALID_QUIET_HOURS" {
+		t.Fatalf(`validateQuietHours = %v for invalid time, want INVALID_QUIET_HOURS`, err)
+	}
+}
+
+// TestTeamAdminDemotionAction calls teamAdminDemotionAction making sure inactive admins are notified
+// before being demoted and active admins are left alone
+func TestTeamAdminDemotionAction(t *testing.T) {
+	now := time.Now()
+	day := 24 * time.Hour
+
+	active := &model.InactiveTeamAdmin{LastActive: now.Add(-10 * day)}
+	if action := teamAdminDemotionAction(active, now, 90, 14); action != "" {
+		t.Fatalf(`teamAdminDemotionAction = %s for active admin, want none`, action)
+	}
+
+	unnotified := &model.InactiveTeamAdmin{LastActive: now.Add(-100 * day)}
+	if action := teamAdminDemotionAction(unnotified, now, 90, 14); action != teamAdminDemotionNotify {
+		t.Fatalf(`teamAdminDemotionAction = %s for unnotified admin, want notify`, action)
+	}
+
+	recentNotice := now.Add(-3 * day)
+	recentlyNotified := &model.InactiveTeamAdmin{LastActive: now.Add(-100 * day), DemotionNoticeDate: &recentNotice}
+	if action := teamAdminDemotionAction(recentlyNotified, now, 90, 14); action != "" {
+		t.Fatalf(`teamAdminDemotionAction = %s for recently notified admin, want none`, action)
+	}
+
+	oldNotice := now.Add(-14 * day)
+	notified := &model.InactiveTeamAdmin{LastActive: now.Add(-100 * day), DemotionNoticeDate: &oldNotice}
+	if action := teamAdminDemotionAction(notified, now, 90, 14); action != teamAdminDemotionDemote {
+		t.Fatalf(`teamAdminDemotionAction = %s for notified admin, want demote`, action)
+	}
+}
+
+// TestBuildOnboardingState calls buildOnboardingState making sure completed steps are marked
+// and the checklist is cleared when dismissed or complete
+func TestBuildOnboardingState(t *testing.T) {
+	Steps := []string{"create_battle", "set_avatar"}
+
+	State := buildOnboardingState(Steps, []string{"create_battle"}, false)
+	if State == nil || len(State.Steps) != 2 || !State.Steps[0].Completed || State.Steps[1].Completed {
+		t.Fatalf(`buildOnboardingState = %v, want create_battle completed and set_avatar incomplete`, State)
+	}
+
+	if State := buildOnboardingState(Steps, []string{"create_battle"}, true); State != nil {
+		t.Fatalf(`buildOnboardingState = %v for dismissed checklist, want nil`, State)
+	}
+
+	if State := buildOnboardingState(Steps, []string{"set_avatar", "create_battle"}, false); State != nil {
+		t.Fatalf(`buildOnboardingState = %v for completed checklist, want nil`, State)
+	}
+}
+
+// TestSiteVerifyCaptcha calls siteVerifyCaptcha.Verify against a fake provider
+// making sure missing, rejected and accepted tokens are handled
+func TestSiteVerifyCaptcha(t *testing.T) {
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("secret") == "secret" && r.PostFormValue("response") == "good" {
+			w.Write([]byte(`{"success": true}`))
+			return
+		}
+		w.Write([]byte(`{"success": false}`))
+	}))
+	defer provider.Close()
+
+	c := &siteVerifyCaptcha{verifyURL: provider.URL, secret: "secret", client: provider.Client()}
+
+	if err := c.Verify("", "127.0.0.1"); err == nil || err.Error() != "CAPTCHA_REQUIRED" {
+		t.Fatalf(`Verify = %v for empty token, want CAPTCHA_REQUIRED`, err)
+	}
+	if err := c.Verify("bad", "127.0.0.1"); err == nil || err.Error() != "CAPTCHA_INVALID" {
+		t.Fatalf(`Verify = %v for rejected token, want CAPTCHA_INVALID`, err)
+	}
+	if err := c.Verify("good", "127.0.0.1"); err != nil {
+		t.Fatalf(`Verify = %v for accepted token, want nil`, err)
+	}
+
+	if _, err := newCaptchaVerifier("unknown", "secret", http.DefaultClient); err == nil {
+		t.Fatalf(`newCaptchaVerifier = nil error for unsupported provider`)
+	}
+}
+
+// TestSessionRotation calls rotateSession with a pre-existing session cookie making sure the prior session row
+// is deleted and the cookie carries the fresh session id, requires a database so is skipped when DB_HOST isn't set
+func TestSessionRotation(t *testing.T) {
+	Host := os.Getenv("DB_HOST")
+	if Host == "" {
+		t.Skip("DB_HOST not set, skipping database test")
+	}
+	Port, _ := strconv.Atoi(os.Getenv("DB_PORT"))
+	if Port == 0 {
+		Port = 5432
+	}
+	database := db.New("", &db.Config{
+		Host:       Host,
+		Port:       Port,
+		User:       os.Getenv("DB_USER"),
+		Password:   os.Getenv("DB_PASS"),
+		Name:       os.Getenv("DB_NAME"),
+		SSLMode:    "disable",
+		AESHashkey: "therevengers",
+	}, zap.NewNop())
+
+	a := &api{
+		config: &Config{SessionCookieName: "session", RotateSessionOnPrivilegeChange: true},
+		cookie: newCookieKeyring([][]byte{[]byte("strongest-avenger-strongest-key!")}, nil),
+		db:     database,
+		logger: zap.NewNop(),
+	}
+	a.battleService = battle.New(database, zap.NewNop(), nil, nil, nil, nil, a.guestSessionExpiry, 0)
+
+	User, err := database.CreateUserGuest("Thor")
+	if err != nil {
+		t.Fatalf(`CreateUserGuest = %v, want nil`, err)
+	}
+	defer database.DeleteUser(User.Id)
+	PriorSessionID, err := database.CreateSession(User.Id)
+	if err != nil {
+		t.Fatalf(`CreateSession = %v, want nil`, err)
+	}
+	FreshSessionID, err := database.CreateSession(User.Id)
+	if err != nil {
+		t.Fatalf(`CreateSession = %v, want nil`, err)
+	}
+
+	fixated := httptest.NewRecorder()
+	if err := a.createSessionCookie(fixated, PriorSessionID); err != nil {
+		t.Fatalf(`createSessionCookie = %v, want nil`, err)
+	}
+	r := httptest.NewRequest("POST", "/api/auth", nil)
+	r.AddCookie(fixated.Result().Cookies()[0])
+
+	rotated := httptest.NewRecorder()
+	if err := a.rotateSession(rotated, r, FreshSessionID); err != nil {
+		t.Fatalf(`rotateSession = %v, want nil`, err)
+	}
+
+	if _, err := database.GetSessionUser(PriorSessionID); err == nil {
+		t.Fatalf(`GetSessionUser(prior session) = nil error after rotateSession, want the session deleted`)
+	}
+	if _, err := database.GetSessionUser(FreshSessionID); err != nil {
+		t.Fatalf(`GetSessionUser(fresh session) = %v after rotateSession, want nil`, err)
+	}
+
+	r = httptest.NewRequest("GET", "/api/auth/user", nil)
+	r.AddCookie(rotated.Result().Cookies()[0])
+	if SessionID := a.requestSessionID(r); SessionID != FreshSessionID {
+		t.Fatalf(`requestSessionID = %s after rotateSession, want %s`, SessionID, FreshSessionID)
+	}
+}
+
+// TestLdapManagedFieldChanged calls ldapManagedFieldChanged making sure only changes
+// to the directory managed fields are detected
+func TestLdapManagedFieldChanged(t *testing.T) {
+	User := &model.User{Name: "Thor", Email: "thor@asgard.com", Company: "Avengers", JobTitle: "God of Thunder"}
+	ManagedFields := []string{"email", "company"}
+
+	unchanged := userprofileUpdateRequestBody{Email: "Thor@Asgard.com", Company: "Avengers", JobTitle: "Worthy"}
+	if Field := ldapManagedFieldChanged(ManagedFields, User, unchanged); Field != "" {
+		t.Fatalf(`ldapManagedFieldChanged = %s, want ""`, Field)
+	}
+
+	changed := userprofileUpdateRequestBody{Name: "Loki", Company: "Frost Giants", JobTitle: "God of Thunder"}
+	if Field := ldapManagedFieldChanged(ManagedFields, User, changed); Field != "company" {
+		t.Fatalf(`ldapManagedFieldChanged = %s, want company`, Field)
+	}
+}
+
+// TestVerifyIDToken calls VerifyIDToken with a signed ID token making sure the signature,
+// nonce, audience and email_verified claim are verified
+func TestVerifyIDToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf(`rsa.GenerateKey = %v`, err)
+	}
+	p, _ := newOIDCProvider("https://sso.thunderdome.dev/realms/avengers", "thunderdome", "secret", "https://thunderdome.dev/api/auth/oidc/callback", http.DefaultClient)
+	p.keys["stark"] = &key.PublicKey
+
+	now := time.Now()
+	sign := func(claims map[string]interface{}) string {
+		header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "stark"})
+		payload, _ := json.Marshal(claims)
+		signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+		digest := sha256.Sum256([]byte(signed))
+		signature, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+	}
+	claims := map[string]interface{}{
+		"iss": "https://sso.thunderdome.dev/realms/avengers", "aud": "thunderdome", "exp": now.Add(time.Minute).Unix(),
+		"nonce": "shield", "email": "thor@thunderdome.dev", "email_verified": true, "name": "Thor",
+	}
+
+	Claims, err := p.VerifyIDToken(sign(claims), "shield", now)
+	if err != nil || Claims.Email != "thor@thunderdome.dev" || oidcUserName(Claims) != "Thor" {
+		t.Fatalf(`VerifyIDToken = %v, %v, want thor@thunderdome.dev claims`, Claims, err)
+	}
+
+	if _, err := p.VerifyIDToken(sign(claims), "hydra", now); err == nil {
+		t.Fatalf(`VerifyIDToken = nil error with the wrong nonce, want error`)
+	}
+
+	claims["aud"] = []string{"loki"}
+	if _, err := p.VerifyIDToken(sign(claims), "shield", now); err == nil {
+		t.Fatalf(`VerifyIDToken = nil error for another audience, want error`)
+	}
+
+	claims["aud"] = "thunderdome"
+	tampered := sign(claims)
+	if _, err := p.VerifyIDToken(tampered[:len(tampered)-4]+"AAAA", "shield", now); err == nil {
+		t.Fatalf(`VerifyIDToken = nil error with a tampered signature, want error`)
+	}
+
+	delete(claims, "email_verified")
+	if _, err := p.VerifyIDToken(sign(claims), "shield", now); err == nil {
+		t.Fatalf(`VerifyIDToken = nil error without email_verified, want error`)
+	}
+
+	// unknown key IDs only refetch the key set once per interval
+	fetches := 0
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		w.Write([]byte(`{"keys": []}`))
+	}))
+	defer jwks.Close()
+	p.discovery = &oidcDiscovery{Issuer: p.issuer, JWKSURI: jwks.URL}
+	for i := 0; i < 3; i++ {
+		if _, err := p.getKey("hydra"); err == nil {
+			t.Fatalf(`getKey = nil error for an unknown key, want error`)
+		}
+	}
+	if fetches != 1 {
+		t.Fatalf(`getKey fetched the key set %d times, want 1`, fetches)
+	}
+}
+
+// TestRequestClientIP calls requestClientIP making sure the trusted header is only used when configured
+// and the last X-Forwarded-For entry, added by the trusted proxy, is used
+func TestRequestClientIP(t *testing.T) {
+	r := httptest.NewRequest("GET", "/api/auth", nil)
+	r.RemoteAddr = "10.0.0.2:51234"
+	r.Header.Set("X-Forwarded-For", "6.6.6.6, 203.0.113.7")
+
+	if ip := requestClientIP(r, ""); ip != "10.0.0.2" {
+		t.Fatalf(`requestClientIP = %s without a trusted header, want 10.0.0.2`, ip)
+	}
+	if ip := requestClientIP(r, "X-Forwarded-For"); ip != "203.0.113.7" {
+		t.Fatalf(`requestClientIP = %s, want 203.0.113.7`, ip)
+	}
+	if ip := requestClientIP(r, "X-Real-IP"); ip != "10.0.0.2" {
+		t.Fatalf(`requestClientIP = %s without the trusted header set, want 10.0.0.2`, ip)
+	}
+}
+
+// TestAnonymizeReplayEventValue calls anonymizeReplayEventValue making sure user IDs within
+// json event values are pseudonymized and other values are left as is
+func TestAnonymizeReplayEventValue(t *testing.T) {
+	pseudonym := func(UserId string) string {
+		return "participant-" + UserId
+	}
+
+	got := anonymizeReplayEventValue(`{"planId":"p1","delegateId":"u2"}`, pseudonym)
+	if want := `{"delegateId":"participant-u2","planId":"p1"}`; got != want {
+		t.Fatalf(`anonymizeReplayEventValue = %s, want %s`, got, want)
+	}
+
+	got = anonymizeReplayEventValue(`{"plans":[{"id":"p1","votes":[{"warriorId":"u1","vote":"5"}]}]}`, pseudonym)
+	if want := `{"plans":[{"id":"p1","votes":[{"vote":"5","warriorId":"participant-u1"}]}]}`; got != want {
+		t.Fatalf(`anonymizeReplayEventValue = %s, want %s`, got, want)
+	}
+
+	if got := anonymizeReplayEventValue("p1", pseudonym); got != "p1" {
+		t.Fatalf(`anonymizeReplayEventValue = %s, want p1`, got)
+	}
+}
+
+// TestLoginAttemptLimiter calls loginAttemptLimiter making sure only attempts within the sliding window are counted
+func TestLoginAttemptLimiter(t *testing.T) {
+	l := newLoginAttemptLimiter(15 * time.Minute)
+	now := time.Now()
+
+	l.Record("10.0.0.1", now.Add(-20*time.Minute))
+	l.Record("10.0.0.1", now.Add(-10*time.Minute))
+	l.Record("10.0.0.1", now.Add(-5*time.Minute))
+	l.Record("10.0.0.2", now.Add(-5*time.Minute))
+
+	if Count := l.Count("10.0.0.1", now); Count != 2 {
+		t.Fatalf(`Count = %d, want 2`, Count)
+	}
+
+	if Count := l.Count("10.0.0.1", now.Add(11*time.Minute)); Count != 0 {
+		t.Fatalf(`Count = %d after the window, want 0`, Count)
+	}
+
+	if Count := l.Count("10.0.0.3", now); Count != 0 {
+		t.Fatalf(`Count = %d for an unknown ip, want 0`, Count)
+	}
+}
+
+// TestTeamDigestDue calls teamDigestDue making sure the digest is only due
+// in the configured UTC weekday and hour
+func TestTeamDigestDue(t *testing.T) {
+	// a Monday
+	Now := time.Date(2022, 7, 4, 9, 30, 0, 0, time.UTC)
+
+	if !teamDigestDue(Now, 1, 9) {
+		t.Fatalf(`teamDigestDue = false, want true for monday 9am`)
+	}
+	if teamDigestDue(Now, 1, 10) {
+		t.Fatalf(`teamDigestDue = true, want false for another hour`)
+	}
+	if teamDigestDue(Now, 2, 9) {
+		t.Fatalf(`teamDigestDue = true, want false for another weekday`)
+	}
+	if !teamDigestDue(Now.In(time.FixedZone("EST", -5*60*60)), 1, 9) {
+		t.Fatalf(`teamDigestDue = false, want true regardless of the times location`)
+	}
+}
+
+// rejectingCaptcha a captchaVerifier rejecting every token
+type rejectingCaptcha struct{}
+
+func (rejectingCaptcha) Verify(Token string, RemoteIP string) error {
+	return errors.New("CAPTCHA_INVALID")
+}
+
+// TestCaptchaGate calls captchaGate making sure rejected tokens fail with CAPTCHA_FAILED
+// only when CAPTCHA is enabled
+func TestCaptchaGate(t *testing.T) {
+	a := &api{config: &Config{}, captcha: rejectingCaptcha{}}
+	r := httptest.NewRequest("POST", "/api/auth/register", nil)
+
+	if !a.captchaGate(httptest.NewRecorder(), r, "") {
+		t.Fatalf(`captchaGate = false, want true when disabled`)
+	}
+
+	a.config.CaptchaEnabled = true
+	w := httptest.NewRecorder()
+	if a.captchaGate(w, r, "bot") {
+		t.Fatalf(`captchaGate = true, want false for a rejected token`)
+	}
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "CAPTCHA_FAILED") {
+		t.Fatalf(`captchaGate response = %d %s, want 400 CAPTCHA_FAILED`, w.Code, w.Body.String())
+	}
+
+	// quick battles create a guest account so are gated the same
+	viper.Set("config.allow_guests", true)
+	defer viper.Set("config.allow_guests", nil)
+	a.config.AllowQuickBattles = true
+	a.quickBattleCreates = newLoginAttemptLimiter(time.Hour)
+	w = httptest.NewRecorder()
+	a.quickBattleOnly(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatalf(`quickBattleOnly called the handler, want the rejected CAPTCHA to stop it`)
+	})(w, httptest.NewRequest("POST", "/api/quick-battles", strings.NewReader(`{"name": "Loki", "captchaToken": "bot"}`)))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "CAPTCHA_FAILED") {
+		t.Fatalf(`quickBattleOnly response = %d %s, want 400 CAPTCHA_FAILED`, w.Code, w.Body.String())
+	}
+}
+
+// TestNewOutboundHTTPClient calls newOutboundHTTPClient making sure requests go through the configured
+// proxy and an invalid proxy or CA cert file is rejected
+func TestNewOutboundHTTPClient(t *testing.T) {
+	Client, err := newOutboundHTTPClient(&Config{HTTPClientProxy: "http://proxy.avengers.local:3128", HTTPClientTimeout: 5})
+	if err != nil {
+		t.Fatalf(`newOutboundHTTPClient = %v`, err)
+	}
+	if Client.Timeout != 5*time.Second {
+		t.Fatalf(`newOutboundHTTPClient timeout = %v, want 5s`, Client.Timeout)
+	}
+
+	req, _ := http.NewRequest("GET", "https://hcaptcha.com/siteverify", nil)
+	ProxyURL, err := Client.Transport.(*http.Transport).Proxy(req)
+	if err != nil || ProxyURL == nil || ProxyURL.Host != "proxy.avengers.local:3128" {
+		t.Fatalf(`newOutboundHTTPClient proxy = %v, %v, want proxy.avengers.local:3128`, ProxyURL, err)
+	}
+
+	if _, err := newOutboundHTTPClient(&Config{HTTPClientProxy: "proxy"}); err == nil {
+		t.Fatalf(`newOutboundHTTPClient = nil error for invalid proxy`)
+	}
+	if _, err := newOutboundHTTPClient(&Config{HTTPClientCACertFile: "testdata/missing.pem"}); err == nil {
+		t.Fatalf(`newOutboundHTTPClient = nil error for missing ca cert file`)
+	}
+}
+
+// TestLdapUserType calls ldapUserType making sure membership of an admin group
+// is matched regardless of DN case and spacing
+func TestLdapUserType(t *testing.T) {
+	AdminGroups := []string{"CN=Thunderdome Admins,OU=Groups,DC=avengers,DC=local"}
+
+	if UserType := ldapUserType([]string{
+		"CN=Developers,OU=Groups,DC=avengers,DC=local",
+		"cn=thunderdome admins, ou=Groups, dc=avengers, dc=local",
+	}, AdminGroups); UserType != adminUserType {
+		t.Fatalf(`ldapUserType = %s, want %s for an admin group member`, UserType, adminUserType)
+	}
+
+	if UserType := ldapUserType([]string{"CN=Developers,OU=Groups,DC=avengers,DC=local"}, AdminGroups); UserType != registeredUserType {
+		t.Fatalf(`ldapUserType = %s, want %s after removal from the admin group`, UserType, registeredUserType)
+	}
+}
+
+// TestGithubPrimaryEmail calls githubPrimaryEmail making sure only the primary
+// email is used along with whether it's verified
+func TestGithubPrimaryEmail(t *testing.T) {
+	Emails := []githubEmail{
+		{Email: "thor@asgard.dev", Primary: false, Verified: true},
+		{Email: "thor@thunderdome.dev", Primary: true, Verified: false},
+	}
+
+	Email, Verified := githubPrimaryEmail(Emails)
+	if Email != "thor@thunderdome.dev" || Verified {
+		t.Fatalf(`githubPrimaryEmail = %s, %v, want thor@thunderdome.dev, false`, Email, Verified)
+	}
+
+	Emails[1].Verified = true
+	if Email, Verified := githubPrimaryEmail(Emails); Email != "thor@thunderdome.dev" || !Verified {
+		t.Fatalf(`githubPrimaryEmail = %s, %v, want thor@thunderdome.dev, true`, Email, Verified)
+	}
+
+	if Email, Verified := githubPrimaryEmail(nil); Email != "" || Verified {
+		t.Fatalf(`githubPrimaryEmail(nil) = %s, %v, want no email`, Email, Verified)
+	}
+}
+
+// TestValidateUserEmail calls validateUserEmail with valid and invalid emails
+func TestValidateUserEmail(t *testing.T) {
+	if err := validateUserEmail("thor@thunderdome.dev"); err != nil {
+		t.Fatalf(`validateUserEmail(thor@thunderdome.dev) = %v, want nil`, err)
+	}
+	for _, Email := range []string{"", "thor", "thor@"} {
+		if err := validateUserEmail(Email); err == nil {
+			t.Fatalf(`validateUserEmail(%q) = nil, want error`, Email)
+		}
+	}
+}
+
+// TestCreateAvatarThumbnail calls createAvatarThumbnail making sure images are cropped
+// to a square of the thumbnail size and that non images are rejected
+func TestCreateAvatarThumbnail(t *testing.T) {
+	buffer := new(bytes.Buffer)
+	if err := png.Encode(buffer, image.NewRGBA(image.Rect(0, 0, 40, 20))); err != nil {
+		t.Fatalf(`png.Encode = %v`, err)
+	}
+
+	Thumbnail, err := createAvatarThumbnail(buffer.Bytes(), 16)
+	if err != nil {
+		t.Fatalf(`createAvatarThumbnail(40x20 png, 16) = %v error`, err)
+	}
+	Config, err := png.DecodeConfig(bytes.NewReader(Thumbnail))
+	if err != nil || Config.Width != 16 || Config.Height != 16 {
+		t.Fatalf(`createAvatarThumbnail(40x20 png, 16) = %dx%d, %v, want 16x16 png`, Config.Width, Config.Height, err)
+	}
+
+	if _, err := createAvatarThumbnail([]byte("<svg></svg>"), 16); err == nil || err.Error() != "INVALID_AVATAR_TYPE" {
+		t.Fatalf(`createAvatarThumbnail(svg, 16) = %v, want INVALID_AVATAR_TYPE`, err)
+	}
+}
+
+// TestLocalAvatarStoreDelete calls localAvatarStore.Delete making sure only the users own uploads are removed
+// and the freed bytes are returned
+func TestLocalAvatarStoreDelete(t *testing.T) {
+	Store := &localAvatarStore{dir: t.TempDir(), urlPrefix: uploadedAvatarPath}
+	Owner := "0f8c7c2e-6a1b-4a4e-9d0c-1c2b3a4d5e6f"
+	URL, err := Store.Put(Owner+"-00ff00ff00ff00ff.png", []byte("avatar"))
+	if err != nil {
+		t.Fatalf(`Put = %v error`, err)
+	}
+
+	if Freed, err := Store.Delete("7a1b2c3d-4e5f-4a6b-8c7d-9e0f1a2b3c4d", URL); err != nil || Freed != 0 {
+		t.Fatalf(`Delete(other user) = %d, %v, want 0, nil`, Freed, err)
+	}
+	if _, err := os.Stat(filepath.Join(Store.dir, Store.Name(URL))); err != nil {
+		t.Fatalf(`Delete(other user) removed the owners avatar`)
+	}
+
+	if Freed, err := Store.Delete(Owner, URL); err != nil || Freed != int64(len("avatar")) {
+		t.Fatalf(`Delete(owner) = %d, %v, want %d, nil`, Freed, err, len("avatar"))
+	}
+	if _, err := os.Stat(filepath.Join(Store.dir, Store.Name(URL))); !os.IsNotExist(err) {
+		t.Fatalf(`Delete(owner) didn't remove the avatar`)
+	}
+}
+
+// TestStoryboardMarkdown calls storyboardMarkdown making sure the points total renders, goals and columns as headings,
+// stories as bullets with their color legend, points, and tags, and content as plain text
+func TestStoryboardMarkdown(t *testing.T) {
+	Storyboard := &model.Storyboard{
+		StoryboardName: "Checkout",
+		ColorLegend:    []*model.Color{{Color: "red", Legend: "Blocked"}},
+		Goals: []*model.StoryboardGoal{{
+			GoalName: "Pay",
+			Points:   3,
+			Columns: []*model.StoryboardColumn{{
+				ColumnName: "Card",
+				Points:     3,
+				Stories: []*model.StoryboardStory{{
+					StoryName:    "Enter card",
+					StoryContent: "<p>Validate the <b>number</b></p>",
+					StoryColor:   "red",
+					StoryPoints:  3,
+					StoryTags:    []string{"payments", "PCI"},
+					Comments:     []*model.StoryComment{{UserName: "Thor", Comment: "Use Luhn"}},
+				}},
+			}},
+		}},
+	}
+
+	want := "# Checkout\n\nTotal: 3 points\n\n## Pay (3 points)\n\n### Card (3 points)\n\n- **Enter card** (red (Blocked), 3 points, tags: payments, PCI)\n  Validate the number\n  - Thor: Use Luhn\n"
+	if got := string(storyboardMarkdown(Storyboard)); got != want {
+		t.Fatalf(`storyboardMarkdown = %q, want %q`, got, want)
+	}
+}
+
+// TestJiraSearchIssues calls SearchIssues making sure rate limited requests are retried,
+// result pages are followed until the total, and issues convert to linked plans
+func TestJiraSearchIssues(t *testing.T) {
+	var Requests int
+	jira := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Requests++
+		if Requests == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		if User, Token, ok := r.BasicAuth(); !ok || User != "user@example.com" || Token != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Query().Get("startAt") == "0" {
+			w.Write([]byte(`{"startAt": 0, "total": 3, "issues": [
+				{"key": "TD-1", "fields": {"summary": "One", "issuetype": {"name": "Bug"}}},
+				{"key": "TD-2", "fields": {"summary": "Two", "issuetype": {"name": "Sub-task"}}}
+			]}`))
+			return
+		}
+		w.Write([]byte(`{"startAt": 2, "total": 3, "issues": [
+			{"key": "TD-3", "fields": {"summary": "Three", "issuetype": {"name": "Improvement"}}}
+		]}`))
+	}))
+	defer jira.Close()
+
+	j := &jiraClient{client: jira.Client(), allowedHosts: []string{"127.0.0.1"}}
+	Issues, err := j.SearchIssues(context.Background(), jira.URL, "user@example.com", "token", "project = TD", 10)
+	if err != nil || len(Issues) != 3 || Requests != 3 {
+		t.Fatalf(`SearchIssues = %d issues, %v after %d requests, want 3 issues, nil after 3 requests`, len(Issues), err, Requests)
+	}
+	if Plan := jiraIssuePlan(jira.URL, Issues[1]); Plan.Type != "Subtask" || Plan.ReferenceId != "TD-2" || Plan.Link != jira.URL+"/browse/TD-2" {
+		t.Fatalf(`jiraIssuePlan = %+v, want a Subtask linking to TD-2`, Plan)
+	}
+	if Plan := jiraIssuePlan(jira.URL, Issues[2]); Plan.Type != "Story" {
+		t.Fatalf(`jiraIssuePlan type = %s for an unknown issue type, want Story`, Plan.Type)
+	}
+
+	if _, err := j.SearchIssues(context.Background(), jira.URL, "user@example.com", "wrong", "project = TD", 10); err == nil || err.Error() != "JIRA_UNAUTHORIZED" {
+		t.Fatalf(`SearchIssues = %v with a wrong token, want JIRA_UNAUTHORIZED`, err)
+	}
+	if _, err := normalizeJiraHost("http://example.atlassian.net"); err == nil {
+		t.Fatalf(`normalizeJiraHost = nil error for an http host, want INVALID_JIRA_HOST`)
+	}
+}
+
+// TestJiraCheckHost calls CheckHost making sure hosts resolving to loopback, private or link-local addresses
+// are rejected without an allow list, and only allowed hosts are accepted with one
+func TestJiraCheckHost(t *testing.T) {
+	j := &jiraClient{}
+	for _, Host := range []string{"https://127.0.0.1", "https://10.0.0.5", "https://169.254.169.254", "https://[::1]", "https://[fd00::1]"} {
+		if err := j.CheckHost(context.Background(), Host); err == nil || err.Error() != "JIRA_HOST_NOT_ALLOWED" {
+			t.Fatalf(`CheckHost(%s) = %v, want JIRA_HOST_NOT_ALLOWED`, Host, err)
+		}
+	}
+	if err := j.CheckHost(context.Background(), "https://93.184.216.34"); err != nil {
+		t.Fatalf(`CheckHost = %v for a public address, want nil`, err)
+	}
+
+	j = &jiraClient{allowedHosts: []string{"jira.avengers.local"}}
+	if err := j.CheckHost(context.Background(), "https://JIRA.avengers.local"); err != nil {
+		t.Fatalf(`CheckHost = %v for an allowed host, want nil`, err)
+	}
+	if err := j.CheckHost(context.Background(), "https://93.184.216.34"); err == nil {
+		t.Fatalf(`CheckHost = nil error for a host not in the allow list, want JIRA_HOST_NOT_ALLOWED`)
+	}
+
+	for _, Host := range []string{"https://[64:ff9b::a00:5]", "https://[2002:a00:5::1]"} {
+		if err := (&jiraClient{}).CheckHost(context.Background(), Host); err == nil {
+			t.Fatalf(`CheckHost(%s) = nil error for a translated private address, want JIRA_HOST_NOT_ALLOWED`, Host)
+		}
+	}
+
+	// connections are checked when dialed so a host passing CheckHost can't re-resolve to an internal address
+	jira := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"issues": []}`))
+	}))
+	defer jira.Close()
+	j = newJiraClient(jira.Client(), nil)
+	if err := j.get(context.Background(), jira.URL, "user@example.com", "token", &jiraSearchResult{}); err == nil || err.Error() != "JIRA_HOST_NOT_ALLOWED" {
+		t.Fatalf(`get = %v dialing a loopback address, want JIRA_HOST_NOT_ALLOWED`, err)
+	}
+
+	if got := jiraErrorMessages([]string{"bad\nfield", strings.Repeat("x", 300), "three", "four"}); got != "badfield "+strings.Repeat("x", 200)+" three" {
+		t.Fatalf(`jiraErrorMessages = %q, want the first three messages truncated without control characters`, got)
+	}
+}
+
+// TestWriteBattleCSV calls writeBattleCSV making sure each voter gets a column, unpointed plans
+// have empty points, and anonymous votes only appear in the votes column
+func TestWriteBattleCSV(t *testing.T) {
+	Plans := []*model.BattleExportPlan{
+		{Name: "Login", Type: "Story", ReferenceId: "TD-1", Points: "5", Votes: []*model.VoteDetail{
+			{UserId: "2", UserName: "Thor", VoteValue: "8"},
+			{UserId: "1", UserName: "Loki", VoteValue: "5"},
+		}},
+		{Name: "Logout", Type: "Bug", Votes: []*model.VoteDetail{{VoteValue: "3"}}},
+	}
+
+	var b bytes.Buffer
+	if err := writeBattleCSV(&b, Plans, isoExportFormat); err != nil {
+		t.Fatalf(`writeBattleCSV = %v, want nil`, err)
+	}
+	want := "Plan,Type,Reference ID,Link,Points,Skipped,Votes,Loki,Thor\n" +
+		"Login,Story,TD-1,,5,false,\"8, 5\",5,8\n" +
+		"Logout,Bug,,,,false,3,,\n"
+	if got := b.String(); got != want {
+		t.Fatalf(`writeBattleCSV = %q, want %q`, got, want)
+	}
+
+	// cells a spreadsheet would evaluate as a formula are prefixed with a quote
+	b.Reset()
+	Plans = []*model.BattleExportPlan{
+		{Name: "=HYPERLINK(\"http://example.com\")", Type: "Story", ReferenceId: "@SUM(A1)", Link: "+1", Votes: []*model.VoteDetail{
+			{UserId: "1", UserName: "-Loki", VoteValue: "5"},
+		}},
+	}
+	if err := writeBattleCSV(&b, Plans, isoExportFormat); err != nil {
+		t.Fatalf(`writeBattleCSV = %v, want nil`, err)
+	}
+	want = "Plan,Type,Reference ID,Link,Points,Skipped,Votes,'-Loki\n" +
+		"\"'=HYPERLINK(\"\"http://example.com\"\")\",Story,'@SUM(A1),'+1,,false,5,5\n"
+	if got := b.String(); got != want {
+		t.Fatalf(`writeBattleCSV = %q, want %q`, got, want)
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"strings"
+
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
 )
@@ -22,6 +24,10 @@ func InitConfig(logger *zap.Logger) {
 	viper.SetDefault("http.frontend_cookie_name", "warrior")
 	viper.SetDefault("http.domain", "thunderdome.dev")
 	viper.SetDefault("http.path_prefix", "")
+	viper.SetDefault("http.allowed_origins", []string{})
+	viper.SetDefault("http.cookie_hashkey_previous", []string{})
+	viper.SetDefault("http.cookie_key_retention_days", 365)
+	viper.SetDefault("http.client_ip_header", "")
 
 	viper.SetDefault("analytics.enabled", true)
 	viper.SetDefault("analytics.id", "UA-140245309-1")
@@ -37,6 +43,9 @@ func InitConfig(logger *zap.Logger) {
 	viper.SetDefault("smtp.port", "25")
 	viper.SetDefault("smtp.secure", true)
 	viper.SetDefault("smtp.sender", "no-reply@thunderdome.dev")
+	viper.SetDefault("smtp.template_dir", "")
+	viper.SetDefault("email.provider", "smtp")
+	viper.SetDefault("email.timeout", 10)
 
 	viper.SetDefault("config.aes_hashkey", "therevengers")
 	viper.SetDefault("config.allowedPointValues",
@@ -49,6 +58,7 @@ func InitConfig(logger *zap.Logger) {
 	viper.SetDefault("config.allow_guests", true)
 	viper.SetDefault("config.allow_registration", true)
 	viper.SetDefault("config.allow_jira_import", true)
+	viper.SetDefault("config.jira_allowed_hosts", []string{})
 	viper.SetDefault("config.default_locale", "en")
 	viper.SetDefault("config.friendly_ui_verbs", false)
 	viper.SetDefault("config.allow_external_api", true)
@@ -59,6 +69,70 @@ func InitConfig(logger *zap.Logger) {
 	viper.SetDefault("config.cleanup_retros_days_old", 180)
 	viper.SetDefault("config.cleanup_storyboards_days_old", 180)
 	viper.SetDefault("config.organizations_enabled", true)
+	viper.SetDefault("config.require_name_to_join", false)
+	viper.SetDefault("config.name_denylist", []string{})
+	viper.SetDefault("config.reset_token_ttl", 60)
+	viper.SetDefault("config.verify_token_ttl", 1440)
+	viper.SetDefault("config.invite_token_ttl", 10080)
+	viper.SetDefault("config.html_allowed_tags", []string{})
+	viper.SetDefault("config.guest_capabilities.can_create_battle", true)
+	viper.SetDefault("config.guest_capabilities.can_create_retro", true)
+	viper.SetDefault("config.guest_capabilities.can_create_storyboard", true)
+	viper.SetDefault("config.guest_capabilities.max_session_lifetime", 0)
+	viper.SetDefault("config.guest_capabilities.session_expiry_warning", 15)
+	viper.SetDefault("config.create_cooldown", 0)
+	viper.SetDefault("config.email_unique_including_deleted", false)
+	viper.SetDefault("config.user_delete_grace_days", 30)
+	viper.SetDefault("config.cleanup_deleted_emails_days_old", 180)
+	viper.SetDefault("config.max_plans_per_battle", 1000)
+	viper.SetDefault("config.max_user_views", 20)
+	viper.SetDefault("config.storage_quota_total_mb", 0)
+	viper.SetDefault("config.storage_quota_user_mb", 0)
+	viper.SetDefault("config.allow_quick_battles", false)
+	viper.SetDefault("config.quick_battle_ttl", 240)
+	viper.SetDefault("config.quick_battle_ip_limit", 10)
+	viper.SetDefault("config.battle_reopen_window_days", 30)
+	viper.SetDefault("config.captcha_provider", "")
+	viper.SetDefault("config.captcha_site_key", "")
+	viper.SetDefault("config.captcha_secret", "")
+	viper.SetDefault("config.captcha_on_auth_requests", false)
+	viper.SetDefault("http_client.proxy", "")
+	viper.SetDefault("http_client.timeout", 10)
+	viper.SetDefault("http_client.ca_cert_file", "")
+	viper.SetDefault("http_client.tls_insecure_skip_verify", false)
+	viper.SetDefault("ws.ping_interval", 54)
+	viper.SetDefault("ws.pong_timeout", 60)
+	viper.SetDefault("ws.message_rate", 20)
+	viper.SetDefault("ws.message_burst", 40)
+	viper.SetDefault("ws.max_dropped_messages", 100)
+	viper.SetDefault("avatar_upload.storage", "")
+	viper.SetDefault("avatar_upload.max_size_kb", 2048)
+	viper.SetDefault("avatar_upload.thumbnail_size", 256)
+	viper.SetDefault("avatar_upload.local_dir", "data/avatars")
+	viper.SetDefault("avatar_upload.s3_bucket", "")
+	viper.SetDefault("avatar_upload.s3_region", "us-east-1")
+	viper.SetDefault("avatar_upload.s3_endpoint", "")
+	viper.SetDefault("avatar_upload.s3_access_key_id", "")
+	viper.SetDefault("avatar_upload.s3_secret_access_key", "")
+	viper.SetDefault("avatar_upload.s3_public_url", "")
+	viper.SetDefault("config.require_verified_password_reset", false)
+	viper.SetDefault("config.demote_inactive_team_admins_days", 0)
+	viper.SetDefault("config.demote_inactive_team_admins_notice_days", 14)
+	viper.SetDefault("config.team_digest_enabled", false)
+	viper.SetDefault("config.team_digest_weekday", 1)
+	viper.SetDefault("config.team_digest_hour", 9)
+	viper.SetDefault("config.team_digest_include_battles", true)
+	viper.SetDefault("config.team_digest_include_points", true)
+	viper.SetDefault("config.storyboard_snapshot_interval", 15)
+	viper.SetDefault("config.storyboard_snapshot_retention", 10)
+	viper.SetDefault("config.storyboard_revision_limit", 50)
+	viper.SetDefault("config.abstain_vote", "☕")
+	viper.SetDefault("config.onboarding_steps", []string{"create_battle", "set_avatar", "invite_teammate"})
+	viper.SetDefault("config.max_user_sessions", 0)
+	viper.SetDefault("config.max_user_sessions_admin_exempt", false)
+	viper.SetDefault("config.rotate_session_on_privilege_change", true)
+	viper.SetDefault("config.log_redact_pii", false)
+	viper.SetDefault("config.log_redact_fields", []string{"email", "useremail", "username", "token", "session_id", "password"})
 
 	// feature flags
 	viper.SetDefault("feature.poker", true)
@@ -66,6 +140,16 @@ func InitConfig(logger *zap.Logger) {
 	viper.SetDefault("feature.storyboard", true)
 
 	viper.SetDefault("auth.method", "normal")
+	viper.SetDefault("auth.lockout.threshold", 10)
+	viper.SetDefault("auth.lockout.ip_threshold", 50)
+	viper.SetDefault("auth.lockout.window", 15)
+	viper.SetDefault("auth.session.idle_timeout", 0)
+	viper.SetDefault("auth.password.min_length", 6)
+	viper.SetDefault("auth.password.require_symbol", false)
+	viper.SetDefault("auth.password.require_number", false)
+	viper.SetDefault("auth.password.require_mixed_case", false)
+	viper.SetDefault("auth.password.history_depth", 0)
+	viper.SetDefault("auth.captcha.enabled", false)
 	viper.SetDefault("auth.ldap.url", "")
 	viper.SetDefault("auth.ldap.use_tls", true)
 	viper.SetDefault("auth.ldap.bindname", "")
@@ -74,6 +158,23 @@ func InitConfig(logger *zap.Logger) {
 	viper.SetDefault("auth.ldap.filter", "(&(objectClass=posixAccount)(mail=%s))")
 	viper.SetDefault("auth.ldap.mail_attr", "mail")
 	viper.SetDefault("auth.ldap.cn_attr", "cn")
+	viper.SetDefault("auth.ldap.company_attr", "company")
+	viper.SetDefault("auth.ldap.job_title_attr", "title")
+	viper.SetDefault("auth.ldap.managed_fields", []string{})
+	viper.SetDefault("auth.ldap.group_attr", "memberOf")
+	viper.SetDefault("auth.ldap.admin_groups", []string{})
+	viper.SetDefault("auth.oidc.issuer", "")
+	viper.SetDefault("auth.oidc.client_id", "")
+	viper.SetDefault("auth.oidc.client_secret", "")
+	viper.SetDefault("auth.oidc.redirect_url", "")
+	viper.SetDefault("auth.github.enabled", false)
+	viper.SetDefault("auth.github.client_id", "")
+	viper.SetDefault("auth.github.client_secret", "")
+	viper.SetDefault("auth.github.redirect_url", "")
+	viper.SetDefault("auth.google.enabled", false)
+	viper.SetDefault("auth.google.client_id", "")
+	viper.SetDefault("auth.google.client_secret", "")
+	viper.SetDefault("auth.google.redirect_url", "")
 
 	viper.BindEnv("http.cookie_hashkey", "COOKIE_HASHKEY")
 	viper.BindEnv("http.port", "PORT")
@@ -83,6 +184,10 @@ func InitConfig(logger *zap.Logger) {
 	viper.BindEnv("http.frontend_cookie_name", "FRONTEND_COOKIE_NAME")
 	viper.BindEnv("http.domain", "APP_DOMAIN")
 	viper.BindEnv("http.path_prefix", "PATH_PREFIX")
+	viper.BindEnv("http.allowed_origins", "ALLOWED_ORIGINS")
+	viper.BindEnv("http.cookie_hashkey_previous", "COOKIE_HASHKEY_PREVIOUS")
+	viper.BindEnv("http.cookie_key_retention_days", "COOKIE_KEY_RETENTION_DAYS")
+	viper.BindEnv("http.client_ip_header", "CLIENT_IP_HEADER")
 
 	viper.BindEnv("analytics.enabled", "ANALYTICS_ENABLED")
 	viper.BindEnv("analytics.id", "ANALYTICS_ID")
@@ -102,6 +207,13 @@ func InitConfig(logger *zap.Logger) {
 	viper.BindEnv("smtp.user", "SMTP_USER")
 	viper.BindEnv("smtp.pass", "SMTP_PASS")
 	viper.BindEnv("smtp.sender", "SMTP_SENDER")
+	viper.BindEnv("smtp.template_dir", "SMTP_TEMPLATE_DIR")
+	viper.BindEnv("email.provider", "EMAIL_PROVIDER")
+	viper.BindEnv("email.timeout", "EMAIL_TIMEOUT")
+	viper.BindEnv("email.sendgrid_api_key", "EMAIL_SENDGRID_API_KEY")
+	viper.BindEnv("email.ses_region", "EMAIL_SES_REGION")
+	viper.BindEnv("email.ses_access_key_id", "EMAIL_SES_ACCESS_KEY_ID")
+	viper.BindEnv("email.ses_secret_access_key", "EMAIL_SES_SECRET_ACCESS_KEY")
 
 	viper.BindEnv("config.aes_hashkey", "CONFIG_AES_HASHKEY")
 	viper.BindEnv("config.allowedPointValues", "CONFIG_POINTS_ALLOWED")
@@ -112,6 +224,7 @@ func InitConfig(logger *zap.Logger) {
 	viper.BindEnv("config.allow_guests", "CONFIG_ALLOW_GUESTS")
 	viper.BindEnv("config.allow_registration", "CONFIG_ALLOW_REGISTRATION")
 	viper.BindEnv("config.allow_jira_import", "CONFIG_ALLOW_JIRA_IMPORT")
+	viper.BindEnv("config.jira_allowed_hosts", "CONFIG_JIRA_ALLOWED_HOSTS")
 	viper.BindEnv("config.default_locale", "CONFIG_DEFAULT_LOCALE")
 	viper.BindEnv("config.friendly_ui_verbs", "CONFIG_FRIENDLY_UI_VERBS")
 	viper.BindEnv("config.allow_external_api", "CONFIG_ALLOW_EXTERNAL_API")
@@ -122,12 +235,86 @@ func InitConfig(logger *zap.Logger) {
 	viper.BindEnv("config.cleanup_retros_days_old", "CONFIG_CLEANUP_RETROS_DAYS_OLD")
 	viper.BindEnv("config.cleanup_storyboards_days_old", "CONFIG_CLEANUP_STORYBOARDS_DAYS_OLD")
 	viper.BindEnv("config.organizations_enabled", "CONFIG_ORGANIZATIONS_ENABLED")
+	viper.BindEnv("config.require_name_to_join", "CONFIG_REQUIRE_NAME_TO_JOIN")
+	viper.BindEnv("config.name_denylist", "CONFIG_NAME_DENYLIST")
+	viper.BindEnv("config.reset_token_ttl", "CONFIG_RESET_TOKEN_TTL")
+	viper.BindEnv("config.verify_token_ttl", "CONFIG_VERIFY_TOKEN_TTL")
+	viper.BindEnv("config.invite_token_ttl", "CONFIG_INVITE_TOKEN_TTL")
+	viper.BindEnv("config.html_allowed_tags", "CONFIG_HTML_ALLOWED_TAGS")
+	viper.BindEnv("config.guest_capabilities.can_create_battle", "CONFIG_GUEST_CAN_CREATE_BATTLE")
+	viper.BindEnv("config.guest_capabilities.can_create_retro", "CONFIG_GUEST_CAN_CREATE_RETRO")
+	viper.BindEnv("config.guest_capabilities.can_create_storyboard", "CONFIG_GUEST_CAN_CREATE_STORYBOARD")
+	viper.BindEnv("config.guest_capabilities.max_session_lifetime", "CONFIG_GUEST_MAX_SESSION_LIFETIME")
+	viper.BindEnv("config.guest_capabilities.session_expiry_warning", "CONFIG_GUEST_SESSION_EXPIRY_WARNING")
+	viper.BindEnv("config.create_cooldown", "CONFIG_CREATE_COOLDOWN")
+	viper.BindEnv("config.email_unique_including_deleted", "CONFIG_EMAIL_UNIQUE_INCLUDING_DELETED")
+	viper.BindEnv("config.user_delete_grace_days", "CONFIG_USER_DELETE_GRACE_DAYS")
+	viper.BindEnv("config.cleanup_deleted_emails_days_old", "CONFIG_CLEANUP_DELETED_EMAILS_DAYS_OLD")
+	viper.BindEnv("config.max_plans_per_battle", "CONFIG_MAX_PLANS_PER_BATTLE")
+	viper.BindEnv("config.max_user_views", "CONFIG_MAX_USER_VIEWS")
+	viper.BindEnv("config.storage_quota_total_mb", "CONFIG_STORAGE_QUOTA_TOTAL_MB")
+	viper.BindEnv("config.storage_quota_user_mb", "CONFIG_STORAGE_QUOTA_USER_MB")
+	viper.BindEnv("config.allow_quick_battles", "CONFIG_ALLOW_QUICK_BATTLES")
+	viper.BindEnv("config.quick_battle_ttl", "CONFIG_QUICK_BATTLE_TTL")
+	viper.BindEnv("config.quick_battle_ip_limit", "CONFIG_QUICK_BATTLE_IP_LIMIT")
+	viper.BindEnv("config.battle_reopen_window_days", "CONFIG_BATTLE_REOPEN_WINDOW_DAYS")
+	viper.BindEnv("config.captcha_provider", "CONFIG_CAPTCHA_PROVIDER")
+	viper.BindEnv("config.captcha_site_key", "CONFIG_CAPTCHA_SITE_KEY")
+	viper.BindEnv("config.captcha_secret", "CONFIG_CAPTCHA_SECRET")
+	viper.BindEnv("config.captcha_on_auth_requests", "CONFIG_CAPTCHA_ON_AUTH_REQUESTS")
+	viper.BindEnv("http_client.proxy", "HTTP_CLIENT_PROXY")
+	viper.BindEnv("http_client.timeout", "HTTP_CLIENT_TIMEOUT")
+	viper.BindEnv("http_client.ca_cert_file", "HTTP_CLIENT_CA_CERT_FILE")
+	viper.BindEnv("http_client.tls_insecure_skip_verify", "HTTP_CLIENT_TLS_INSECURE_SKIP_VERIFY")
+	viper.BindEnv("ws.ping_interval", "WS_PING_INTERVAL")
+	viper.BindEnv("ws.pong_timeout", "WS_PONG_TIMEOUT")
+	viper.BindEnv("ws.message_rate", "WS_MESSAGE_RATE")
+	viper.BindEnv("ws.message_burst", "WS_MESSAGE_BURST")
+	viper.BindEnv("ws.max_dropped_messages", "WS_MAX_DROPPED_MESSAGES")
+	viper.BindEnv("avatar_upload.storage", "AVATAR_UPLOAD_STORAGE")
+	viper.BindEnv("avatar_upload.max_size_kb", "AVATAR_UPLOAD_MAX_SIZE_KB")
+	viper.BindEnv("avatar_upload.thumbnail_size", "AVATAR_UPLOAD_THUMBNAIL_SIZE")
+	viper.BindEnv("avatar_upload.local_dir", "AVATAR_UPLOAD_LOCAL_DIR")
+	viper.BindEnv("avatar_upload.s3_bucket", "AVATAR_UPLOAD_S3_BUCKET")
+	viper.BindEnv("avatar_upload.s3_region", "AVATAR_UPLOAD_S3_REGION")
+	viper.BindEnv("avatar_upload.s3_endpoint", "AVATAR_UPLOAD_S3_ENDPOINT")
+	viper.BindEnv("avatar_upload.s3_access_key_id", "AVATAR_UPLOAD_S3_ACCESS_KEY_ID")
+	viper.BindEnv("avatar_upload.s3_secret_access_key", "AVATAR_UPLOAD_S3_SECRET_ACCESS_KEY")
+	viper.BindEnv("avatar_upload.s3_public_url", "AVATAR_UPLOAD_S3_PUBLIC_URL")
+	viper.BindEnv("config.require_verified_password_reset", "CONFIG_REQUIRE_VERIFIED_PASSWORD_RESET")
+	viper.BindEnv("config.demote_inactive_team_admins_days", "CONFIG_DEMOTE_INACTIVE_TEAM_ADMINS_DAYS")
+	viper.BindEnv("config.demote_inactive_team_admins_notice_days", "CONFIG_DEMOTE_INACTIVE_TEAM_ADMINS_NOTICE_DAYS")
+	viper.BindEnv("config.team_digest_enabled", "CONFIG_TEAM_DIGEST_ENABLED")
+	viper.BindEnv("config.team_digest_weekday", "CONFIG_TEAM_DIGEST_WEEKDAY")
+	viper.BindEnv("config.team_digest_hour", "CONFIG_TEAM_DIGEST_HOUR")
+	viper.BindEnv("config.team_digest_include_battles", "CONFIG_TEAM_DIGEST_INCLUDE_BATTLES")
+	viper.BindEnv("config.team_digest_include_points", "CONFIG_TEAM_DIGEST_INCLUDE_POINTS")
+	viper.BindEnv("config.storyboard_snapshot_interval", "CONFIG_STORYBOARD_SNAPSHOT_INTERVAL")
+	viper.BindEnv("config.storyboard_snapshot_retention", "CONFIG_STORYBOARD_SNAPSHOT_RETENTION")
+	viper.BindEnv("config.storyboard_revision_limit", "CONFIG_STORYBOARD_REVISION_LIMIT")
+	viper.BindEnv("config.abstain_vote", "CONFIG_ABSTAIN_VOTE")
+	viper.BindEnv("config.onboarding_steps", "CONFIG_ONBOARDING_STEPS")
+	viper.BindEnv("config.max_user_sessions", "CONFIG_MAX_USER_SESSIONS")
+	viper.BindEnv("config.max_user_sessions_admin_exempt", "CONFIG_MAX_USER_SESSIONS_ADMIN_EXEMPT")
+	viper.BindEnv("config.rotate_session_on_privilege_change", "CONFIG_ROTATE_SESSION_ON_PRIVILEGE_CHANGE")
+	viper.BindEnv("config.log_redact_pii", "CONFIG_LOG_REDACT_PII")
+	viper.BindEnv("config.log_redact_fields", "CONFIG_LOG_REDACT_FIELDS")
 
 	viper.BindEnv("feature.poker", "FEATURE_POKER")
 	viper.BindEnv("feature.retro", "FEATURE_RETRO")
 	viper.BindEnv("feature.storyboard", "FEATURE_STORYBOARD")
 
 	viper.BindEnv("auth.method", "AUTH_METHOD")
+	viper.BindEnv("auth.lockout.threshold", "AUTH_LOCKOUT_THRESHOLD")
+	viper.BindEnv("auth.lockout.ip_threshold", "AUTH_LOCKOUT_IP_THRESHOLD")
+	viper.BindEnv("auth.lockout.window", "AUTH_LOCKOUT_WINDOW")
+	viper.BindEnv("auth.session.idle_timeout", "AUTH_SESSION_IDLE_TIMEOUT")
+	viper.BindEnv("auth.password.min_length", "AUTH_PASSWORD_MIN_LENGTH")
+	viper.BindEnv("auth.password.require_symbol", "AUTH_PASSWORD_REQUIRE_SYMBOL")
+	viper.BindEnv("auth.password.require_number", "AUTH_PASSWORD_REQUIRE_NUMBER")
+	viper.BindEnv("auth.password.require_mixed_case", "AUTH_PASSWORD_REQUIRE_MIXED_CASE")
+	viper.BindEnv("auth.password.history_depth", "AUTH_PASSWORD_HISTORY_DEPTH")
+	viper.BindEnv("auth.captcha.enabled", "AUTH_CAPTCHA_ENABLED")
 	viper.BindEnv("auth.ldap.url", "AUTH_LDAP_URL")
 	viper.BindEnv("auth.ldap.use_tls", "AUTH_LDAP_USE_TLS")
 	viper.BindEnv("auth.ldap.bindname", "AUTH_LDAP_BINDNAME")
@@ -136,6 +323,23 @@ func InitConfig(logger *zap.Logger) {
 	viper.BindEnv("auth.ldap.filter", "AUTH_LDAP_FILTER")
 	viper.BindEnv("auth.ldap.mail_attr", "AUTH_LDAP_MAIL_ATTR")
 	viper.BindEnv("auth.ldap.cn_attr", "AUTH_LDAP_CN_ATTR")
+	viper.BindEnv("auth.ldap.company_attr", "AUTH_LDAP_COMPANY_ATTR")
+	viper.BindEnv("auth.ldap.job_title_attr", "AUTH_LDAP_JOB_TITLE_ATTR")
+	viper.BindEnv("auth.ldap.managed_fields", "AUTH_LDAP_MANAGED_FIELDS")
+	viper.BindEnv("auth.ldap.group_attr", "AUTH_LDAP_GROUP_ATTR")
+	viper.BindEnv("auth.ldap.admin_groups", "AUTH_LDAP_ADMIN_GROUPS")
+	viper.BindEnv("auth.oidc.issuer", "AUTH_OIDC_ISSUER")
+	viper.BindEnv("auth.oidc.client_id", "AUTH_OIDC_CLIENT_ID")
+	viper.BindEnv("auth.oidc.client_secret", "AUTH_OIDC_CLIENT_SECRET")
+	viper.BindEnv("auth.oidc.redirect_url", "AUTH_OIDC_REDIRECT_URL")
+	viper.BindEnv("auth.github.enabled", "AUTH_GITHUB_ENABLED")
+	viper.BindEnv("auth.github.client_id", "AUTH_GITHUB_CLIENT_ID")
+	viper.BindEnv("auth.github.client_secret", "AUTH_GITHUB_CLIENT_SECRET")
+	viper.BindEnv("auth.github.redirect_url", "AUTH_GITHUB_REDIRECT_URL")
+	viper.BindEnv("auth.google.enabled", "AUTH_GOOGLE_ENABLED")
+	viper.BindEnv("auth.google.client_id", "AUTH_GOOGLE_CLIENT_ID")
+	viper.BindEnv("auth.google.client_secret", "AUTH_GOOGLE_CLIENT_SECRET")
+	viper.BindEnv("auth.google.redirect_url", "AUTH_GOOGLE_REDIRECT_URL")
 
 	err := viper.ReadInConfig()
 	if err != nil {
@@ -144,3 +348,34 @@ func InitConfig(logger *zap.Logger) {
 		}
 	}
 }
+
+// captchaEnabled whether a CAPTCHA is required on auth requests,
+// config.captcha_on_auth_requests is the deprecated name of auth.captcha.enabled
+func captchaEnabled() bool {
+	return viper.GetBool("auth.captcha.enabled") || viper.GetBool("config.captcha_on_auth_requests")
+}
+
+// guestCapabilities gets the features guest users are allowed to use
+func guestCapabilities() map[string]bool {
+	return map[string]bool{
+		"can_create_battle":     viper.GetBool("config.guest_capabilities.can_create_battle"),
+		"can_create_retro":      viper.GetBool("config.guest_capabilities.can_create_retro"),
+		"can_create_storyboard": viper.GetBool("config.guest_capabilities.can_create_storyboard"),
+	}
+}
+
+// ldapAdminGroups gets the LDAP admin group DNs, from the environment they're separated by ; as DNs
+// commonly contain spaces and commas
+func ldapAdminGroups() []string {
+	if Groups, ok := viper.Get("auth.ldap.admin_groups").(string); ok {
+		var DNs []string
+		for _, DN := range strings.Split(Groups, ";") {
+			if DN = strings.TrimSpace(DN); DN != "" {
+				DNs = append(DNs, DN)
+			}
+		}
+		return DNs
+	}
+
+	return viper.GetStringSlice("auth.ldap.admin_groups")
+}
@@ -3,6 +3,8 @@ package db
 import (
 	"errors"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/StevenWeathers/thunderdome-planning-poker/model"
 	"go.uber.org/zap"
@@ -72,6 +74,118 @@ func (d *Database) GetAppStats() (*model.ApplicationStats, error) {
 	return &Appstats, nil
 }
 
+// instanceStatsCacheTTL how long the instance stats aggregates are cached for
+const instanceStatsCacheTTL = 5 * time.Minute
+
+// instanceStatsCache caches the instance stats aggregates by date range
+type instanceStatsCache struct {
+	sync.Mutex
+	stats map[string]*model.InstanceStats
+}
+
+func newInstanceStatsCache() *instanceStatsCache {
+	return &instanceStatsCache{
+		stats: make(map[string]*model.InstanceStats),
+	}
+}
+
+// get returns the cached stats for the key if not older than the cache TTL
+func (c *instanceStatsCache) get(key string, now time.Time) *model.InstanceStats {
+	c.Lock()
+	defer c.Unlock()
+
+	s, ok := c.stats[key]
+	if !ok || now.Sub(s.GeneratedDate) > instanceStatsCacheTTL {
+		return nil
+	}
+
+	return s
+}
+
+// set caches the stats for the key, dropping any expired entries
+func (c *instanceStatsCache) set(key string, s *model.InstanceStats) {
+	c.Lock()
+	defer c.Unlock()
+
+	for k, cs := range c.stats {
+		if s.GeneratedDate.Sub(cs.GeneratedDate) > instanceStatsCacheTTL {
+			delete(c.stats, k)
+		}
+	}
+	c.stats[key] = s
+}
+
+// GetInstanceStats gets instance wide usage stats, with active users and signups limited to the date range
+func (d *Database) GetInstanceStats(From time.Time, To time.Time) (*model.InstanceStats, error) {
+	now := time.Now()
+	cacheKey := From.Format("2006-01-02") + "_" + To.Format("2006-01-02")
+	if s := d.instanceStatsCache.get(cacheKey, now); s != nil {
+		return s, nil
+	}
+
+	var s = &model.InstanceStats{
+		Signups:       make([]*model.DateCount, 0),
+		From:          From,
+		To:            To,
+		GeneratedDate: now,
+	}
+
+	err := d.db.QueryRow(`
+		SELECT
+			(SELECT COUNT(*) FROM users),
+			(SELECT COUNT(*) FROM users WHERE last_active >= $1 AND last_active < $2),
+			(SELECT COUNT(*) FROM users WHERE type = 'GUEST'),
+			(SELECT COUNT(*) FROM battles),
+			(SELECT COUNT(DISTINCT battle_id) FROM battles_users WHERE active IS true),
+			(SELECT COUNT(*) FROM storyboard),
+			(SELECT COALESCE(SUM(vote_count), 0) FROM battles);
+		`,
+		From,
+		To,
+	).Scan(
+		&s.UserCount,
+		&s.ActiveUserCount,
+		&s.GuestUserCount,
+		&s.BattleCount,
+		&s.ActiveBattleCount,
+		&s.StoryboardCount,
+		&s.VoteCount,
+	)
+	if err != nil {
+		d.logger.Error("Unable to get instance stats", zap.Error(err))
+		return nil, errors.New("unable to get instance stats")
+	}
+
+	rows, err := d.db.Query(`
+		SELECT to_char(date_trunc('day', created_date), 'YYYY-MM-DD') AS day, COUNT(*)
+		FROM users
+		WHERE created_date >= $1 AND created_date < $2
+		GROUP BY day
+		ORDER BY day;
+		`,
+		From,
+		To,
+	)
+	if err != nil {
+		d.logger.Error("Unable to get instance signup stats", zap.Error(err))
+		return nil, errors.New("unable to get instance stats")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var dc model.DateCount
+		if err := rows.Scan(&dc.Date, &dc.Count); err != nil {
+			d.logger.Error("instance signup stats scan error", zap.Error(err))
+			return nil, errors.New("unable to get instance stats")
+		}
+		s.Signups = append(s.Signups, &dc)
+	}
+
+	d.instanceStatsCache.set(cacheKey, s)
+
+	return s, nil
+}
+
 // PromoteUser promotes a user to admin type
 func (d *Database) PromoteUser(UserID string) error {
 	if _, err := d.db.Exec(
@@ -98,30 +212,75 @@ func (d *Database) DemoteUser(UserID string) error {
 	return nil
 }
 
-// DisableUser disables a user from logging in
-func (d *Database) DisableUser(UserID string) error {
+// SetUserType sets a registered users type to ADMIN or REGISTERED, guest users are never changed
+func (d *Database) SetUserType(UserID string, UserType string) error {
+	if UserType != "ADMIN" && UserType != "REGISTERED" {
+		return errors.New("INVALID_USER_TYPE")
+	}
+
 	if _, err := d.db.Exec(
-		`call user_disable($1);`,
+		`UPDATE users SET type = $2, updated_date = NOW() WHERE id = $1 AND type <> 'GUEST';`,
 		UserID,
+		UserType,
 	); err != nil {
-		d.logger.Error("call user_disable error", zap.Error(err))
-		return errors.New("error attempting to disable user")
+		d.logger.Error("set user type query error", zap.Error(err))
+		return errors.New("error attempting to set user type")
 	}
 
 	return nil
 }
 
-// EnableUser enables a user allowing login
-func (d *Database) EnableUser(UserID string) error {
-	if _, err := d.db.Exec(
-		`call user_enable($1);`,
+// SetUserDisabled disables a user from logging in or re-enables them, disabling also ends all the users sessions
+// returning the ended session IDs so any open sockets can be closed
+func (d *Database) SetUserDisabled(UserID string, Disabled bool) ([]string, error) {
+	var SessionIDs = make([]string, 0)
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		d.logger.Error("set user disabled transaction error", zap.Error(err))
+		return nil, errors.New("error attempting to set user disabled")
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(
+		`UPDATE users SET disabled = $2, updated_date = NOW() WHERE id = $1;`,
 		UserID,
-	); err != nil {
-		d.logger.Error("call user_enable error", zap.Error(err))
-		return errors.New("error attempting to enable user")
+		Disabled,
+	)
+	if err != nil {
+		d.logger.Error("update user disabled query error", zap.Error(err))
+		return nil, errors.New("error attempting to set user disabled")
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return nil, errors.New("USER_NOT_FOUND")
 	}
 
-	return nil
+	if Disabled {
+		rows, err := tx.Query(
+			`DELETE FROM user_session WHERE user_id = $1 RETURNING session_id;`,
+			UserID,
+		)
+		if err != nil {
+			d.logger.Error("delete disabled user sessions query error", zap.Error(err))
+			return nil, errors.New("error attempting to set user disabled")
+		}
+		for rows.Next() {
+			var SessionID string
+			if err := rows.Scan(&SessionID); err != nil {
+				d.logger.Error("delete disabled user sessions query scan error", zap.Error(err))
+			} else {
+				SessionIDs = append(SessionIDs, SessionID)
+			}
+		}
+		rows.Close()
+	}
+
+	if err := tx.Commit(); err != nil {
+		d.logger.Error("set user disabled commit error", zap.Error(err))
+		return nil, errors.New("error attempting to set user disabled")
+	}
+
+	return SessionIDs, nil
 }
 
 // CleanBattles deletes battles older than {DaysOld} days
@@ -9,8 +9,21 @@ import (
 	"go.uber.org/zap"
 )
 
-// GenerateApiKey generates a new API key for a User
-func (d *Database) GenerateApiKey(UserID string, KeyName string) (*model.APIKey, error) {
+// validateApiKeyExpiry makes sure the api key either never expires or expires in the future
+func validateApiKeyExpiry(ExpireDate *time.Time, Now time.Time) error {
+	if ExpireDate != nil && !ExpireDate.After(Now) {
+		return errors.New("INVALID_APIKEY_EXPIRY")
+	}
+
+	return nil
+}
+
+// GenerateApiKey generates a new API key for a User, optionally expiring at ExpireDate
+func (d *Database) GenerateApiKey(UserID string, KeyName string, ExpireDate *time.Time) (*model.APIKey, error) {
+	if err := validateApiKeyExpiry(ExpireDate, time.Now()); err != nil {
+		return nil, err
+	}
+
 	apiPrefix, prefixErr := randomString(8)
 	if prefixErr != nil {
 		err := errors.New("error generating api prefix")
@@ -32,6 +45,7 @@ func (d *Database) GenerateApiKey(UserID string, KeyName string) (*model.APIKey,
 		Prefix:      apiPrefix,
 		Active:      true,
 		CreatedDate: time.Now(),
+		ExpireDate:  ExpireDate,
 	}
 	hashedKey := hashString(APIKEY.Key)
 	keyID := apiPrefix + "." + hashedKey
@@ -47,6 +61,21 @@ func (d *Database) GenerateApiKey(UserID string, KeyName string) (*model.APIKey,
 		return nil, errors.New("unable to create new api key")
 	}
 
+	if ExpireDate != nil {
+		if _, err := d.db.Exec(
+			`UPDATE api_keys SET expire_date = $2 WHERE id = $1;`,
+			keyID,
+			ExpireDate,
+		); err != nil {
+			d.logger.Error("update api key expire_date query error", zap.Error(err))
+			// never leave behind a key that outlives what was asked for
+			if _, err := d.db.Exec(`CALL user_apikey_delete($1, $2);`, keyID, UserID); err != nil {
+				d.logger.Error("call user_apikey_delete error", zap.Error(err))
+			}
+			return nil, errors.New("unable to create new api key")
+		}
+	}
+
 	return APIKEY, nil
 }
 
@@ -54,7 +83,7 @@ func (d *Database) GenerateApiKey(UserID string, KeyName string) (*model.APIKey,
 func (d *Database) GetUserApiKeys(UserID string) ([]*model.APIKey, error) {
 	var APIKeys = make([]*model.APIKey, 0)
 	rows, err := d.db.Query(
-		"SELECT id, name, user_id, active, created_date, updated_date FROM api_keys WHERE user_id = $1 ORDER BY created_date",
+		"SELECT id, name, user_id, active, created_date, updated_date, expire_date FROM api_keys WHERE user_id = $1 ORDER BY created_date",
 		UserID,
 	)
 	if err == nil {
@@ -70,6 +99,7 @@ func (d *Database) GetUserApiKeys(UserID string) ([]*model.APIKey, error) {
 				&ak.Active,
 				&ak.CreatedDate,
 				&ak.UpdatedDate,
+				&ak.ExpireDate,
 			); err != nil {
 				d.logger.Error("GetUserApiKeys scan error", zap.Error(err))
 			} else {
@@ -118,7 +148,7 @@ func (d *Database) DeleteUserApiKey(UserID string, KeyID string) ([]*model.APIKe
 	return keys, nil
 }
 
-// GetApiKeyUser checks to see if the API key exists and returns the User
+// GetApiKeyUser checks to see if the API key exists, is active, and hasn't expired then returns the User
 func (d *Database) GetApiKeyUser(APK string) (*model.User, error) {
 	User := &model.User{}
 
@@ -130,7 +160,8 @@ func (d *Database) GetApiKeyUser(APK string) (*model.User, error) {
 		SELECT u.id, u.name, u.email, u.type, u.avatar, u.verified, u.notifications_enabled, COALESCE(u.country, ''), COALESCE(u.locale, ''), COALESCE(u.company, ''), COALESCE(u.job_title, ''), u.created_date, u.updated_date, u.last_active 
 		FROM api_keys ak
 		LEFT JOIN users u ON u.id = ak.user_id
-		WHERE ak.id = $1 AND ak.active = true
+		WHERE ak.id = $1 AND ak.active = true AND (ak.expire_date IS NULL OR ak.expire_date > NOW())
+			AND u.disabled = false AND u.deleted_at IS NULL
 `,
 		keyID,
 	).Scan(
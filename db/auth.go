@@ -8,13 +8,14 @@ import (
 	"go.uber.org/zap"
 )
 
-// AuthUser authenticate the user
+// AuthUser authenticate the user, returning a new session or when the user has MFA enabled
+// a short-lived pre-auth token to exchange for a session with ValidateMFA
 func (d *Database) AuthUser(UserEmail string, UserPassword string) (*model.User, string, error) {
 	var user model.User
 	var passHash string
 
 	e := d.db.QueryRow(
-		`SELECT id, name, email, type, password, avatar, verified, notifications_enabled, COALESCE(locale, ''), disabled FROM users WHERE email = $1`,
+		`SELECT id, name, email, type, password, avatar, verified, notifications_enabled, COALESCE(locale, ''), disabled, mfa_enabled FROM users WHERE email = $1 AND deleted_at IS NULL`,
 		UserEmail,
 	).Scan(
 		&user.Id,
@@ -27,6 +28,7 @@ func (d *Database) AuthUser(UserEmail string, UserPassword string) (*model.User,
 		&user.NotificationsEnabled,
 		&user.Locale,
 		&user.Disabled,
+		&user.MFAEnabled,
 	)
 	if e != nil {
 		d.logger.Error("Unable to auth user", zap.Error(e))
@@ -38,7 +40,7 @@ func (d *Database) AuthUser(UserEmail string, UserPassword string) (*model.User,
 	}
 
 	if user.Disabled {
-		return nil, "", errors.New("user disabled")
+		return nil, "", errors.New("USER_DISABLED")
 	}
 
 	// check to see if the bcrypt cost has been updated, if not do so
@@ -49,6 +51,16 @@ func (d *Database) AuthUser(UserEmail string, UserPassword string) (*model.User,
 		}
 	}
 
+	// the session is only created once the MFA passcode is verified
+	if user.MFAEnabled {
+		MFAToken, tokenErr := d.createMFAToken(user.Id)
+		if tokenErr != nil {
+			return nil, "", tokenErr
+		}
+
+		return &user, MFAToken, nil
+	}
+
 	SessionId, sessErr := d.CreateSession(user.Id)
 	if sessErr != nil {
 		return nil, "", sessErr
@@ -73,37 +85,57 @@ func (d *Database) UserResetRequest(UserEmail string) (resetID string, UserName
 		return "", "", e
 	}
 
+	if err := d.setTokenExpiry(TokenTypeReset, ResetID.String); err != nil {
+		return "", "", err
+	}
+
 	return ResetID.String, name.String, nil
 }
 
+// ValidateResetToken checks the reset token exists and hasn't expired without consuming it,
+// so link prefetching by email scanners doesn't burn the token
+func (d *Database) ValidateResetToken(ResetID string) error {
+	return d.checkTokenExpiry(TokenTypeReset, ResetID)
+}
+
 // UserResetPassword resets the user's password to a new password
 func (d *Database) UserResetPassword(ResetID string, UserPassword string) (UserName string, UserEmail string, resetErr error) {
+	var UserID string
 	var name sql.NullString
 	var email sql.NullString
 
-	hashedPassword, hashErr := hashSaltPassword(UserPassword)
-	if hashErr != nil {
-		return "", "", hashErr
+	if err := d.checkTokenExpiry(TokenTypeReset, ResetID); err != nil {
+		return "", "", err
 	}
 
 	UserErr := d.db.QueryRow(`
 		SELECT
-			w.name, w.email
+			wr.user_id, w.name, w.email
 		FROM user_reset wr
 		LEFT JOIN users w ON w.id = wr.user_id
 		WHERE wr.reset_id = $1;
 		`,
 		ResetID,
-	).Scan(&name, &email)
+	).Scan(&UserID, &name, &email)
 	if UserErr != nil {
 		d.logger.Error("Unable to get user for password reset confirmation email", zap.Error(UserErr))
 		return "", "", UserErr
 	}
 
+	if err := d.checkPasswordHistory(UserID, UserPassword); err != nil {
+		return "", "", err
+	}
+
+	hashedPassword, hashErr := hashSaltPassword(UserPassword)
+	if hashErr != nil {
+		return "", "", hashErr
+	}
+
 	if _, err := d.db.Exec(
 		`call reset_user_password($1, $2)`, ResetID, hashedPassword); err != nil {
 		return "", "", err
 	}
+	d.recordPasswordHistory(UserID, hashedPassword)
 
 	return name.String, email.String, nil
 }
@@ -126,6 +158,10 @@ func (d *Database) UserUpdatePassword(UserID string, UserPassword string) (Name
 		return "", "", UserErr
 	}
 
+	if err := d.checkPasswordHistory(UserID, UserPassword); err != nil {
+		return "", "", err
+	}
+
 	hashedPassword, hashErr := hashSaltPassword(UserPassword)
 	if hashErr != nil {
 		return "", "", hashErr
@@ -135,6 +171,7 @@ func (d *Database) UserUpdatePassword(UserID string, UserPassword string) (Name
 		`call update_user_password($1, $2)`, UserID, hashedPassword); err != nil {
 		return "", "", err
 	}
+	d.recordPasswordHistory(UserID, hashedPassword)
 
 	return UserName.String, UserEmail.String, nil
 }
@@ -168,15 +205,35 @@ func (d *Database) UserVerifyRequest(UserId string) (*model.User, string, error)
 		return nil, VerifyId, err
 	}
 
+	if err := d.setTokenExpiry(TokenTypeVerify, VerifyId); err != nil {
+		return nil, VerifyId, err
+	}
+
 	return user, VerifyId, nil
 }
 
 // VerifyUserAccount updates a user account verified status
 func (d *Database) VerifyUserAccount(VerifyID string) error {
+	if err := d.checkTokenExpiry(TokenTypeVerify, VerifyID); err != nil {
+		return err
+	}
+
+	var UserID string
+	if err := d.db.QueryRow(
+		`SELECT user_id FROM user_verify WHERE verify_id = $1;`, VerifyID,
+	).Scan(&UserID); err != nil {
+		return err
+	}
+
 	if _, err := d.db.Exec(
 		`call verify_user_account($1)`, VerifyID); err != nil {
 		return err
 	}
 
+	// the user has now proven owning the email their team invites were sent to
+	if err := d.acceptTeamUserInvites(UserID); err != nil {
+		d.logger.Error("verify user accept team invites error", zap.Error(err))
+	}
+
 	return nil
 }
@@ -0,0 +1,120 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+// voteAttributionEvents the recordable battle events that attribute a vote to a user,
+// never recorded for permanently anonymous battles
+var voteAttributionEvents = []string{"vote", "retract_vote", "delegate_vote", "revoke_vote_delegation"}
+
+// anonymizeVotesQuery rebuilds a votes json array without who cast them, ordered by value so
+// the original voting order can't be used to attribute them either
+const anonymizeVotesQuery = `(SELECT COALESCE(jsonb_agg(jsonb_build_object('warriorId', '', 'vote', v->>'vote') ORDER BY v->>'vote'), '[]'::jsonb)
+	FROM jsonb_array_elements(%s) v)`
+
+// anonymizePlanVotes strips who voted what from a revealed plans votes leaving only the distribution
+func anonymizePlanVotes(Plan *model.Plan) {
+	if Plan.Active {
+		return
+	}
+
+	for _, v := range Plan.Votes {
+		v.UserId = ""
+		v.DelegateId = ""
+	}
+	sort.SliceStable(Plan.Votes, func(i, j int) bool {
+		return Plan.Votes[i].VoteValue < Plan.Votes[j].VoteValue
+	})
+	Plan.VoteDelegations = make([]*model.PlanVoteDelegation, 0)
+}
+
+// EnableBattlePermanentAnonymity makes the battles votes permanently anonymous, once revealed only
+// the distribution of votes is kept, it can't be disabled as attributions already discarded can't be restored
+func (d *Database) EnableBattlePermanentAnonymity(BattleID string) ([]*model.Plan, error) {
+	if _, err := d.db.Exec(
+		`UPDATE battles SET permanent_anonymity = true, updated_date = NOW() WHERE id = $1;`,
+		BattleID,
+	); err != nil {
+		d.logger.Error("update battle permanent_anonymity error", zap.Error(err))
+		return nil, errors.New("unable to enable battle permanent anonymity")
+	}
+
+	if _, err := d.db.Exec(
+		`DELETE FROM battle_event WHERE battle_id = $1 AND event_type = ANY($2);`,
+		BattleID,
+		pq.Array(voteAttributionEvents),
+	); err != nil {
+		d.logger.Error("delete battle vote events error", zap.Error(err))
+		return nil, errors.New("unable to enable battle permanent anonymity")
+	}
+
+	if err := d.anonymizeRevealedVotes(BattleID); err != nil {
+		return nil, err
+	}
+
+	return d.GetPlans(BattleID, ""), nil
+}
+
+// anonymizeRevealedVotes discards who voted what on the battles revealed plans, including their
+// merged vote history and delegations, when the battle is permanently anonymous
+func (d *Database) anonymizeRevealedVotes(BattleID string) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		d.logger.Error("anonymize votes transaction error", zap.Error(err))
+		return errors.New("unable to anonymize votes")
+	}
+	defer tx.Rollback()
+
+	var Anonymous bool
+	if err := tx.QueryRow(
+		`SELECT permanent_anonymity FROM battles WHERE id = $1;`,
+		BattleID,
+	).Scan(&Anonymous); err != nil {
+		d.logger.Error("get battle permanent_anonymity error", zap.Error(err))
+		return errors.New("unable to anonymize votes")
+	}
+	if !Anonymous {
+		return nil
+	}
+
+	if _, err := tx.Exec(
+		`UPDATE plans SET votes = `+fmt.Sprintf(anonymizeVotesQuery, "plans.votes")+`
+		WHERE battle_id = $1 AND active = false;`,
+		BattleID,
+	); err != nil {
+		d.logger.Error("anonymize plan votes query error", zap.Error(err))
+		return errors.New("unable to anonymize votes")
+	}
+
+	if _, err := tx.Exec(
+		`UPDATE plan_vote_history pvh SET votes = `+fmt.Sprintf(anonymizeVotesQuery, "pvh.votes")+`
+		FROM plans p WHERE p.id = pvh.plan_id AND p.battle_id = $1;`,
+		BattleID,
+	); err != nil {
+		d.logger.Error("anonymize plan vote history query error", zap.Error(err))
+		return errors.New("unable to anonymize votes")
+	}
+
+	if _, err := tx.Exec(
+		`DELETE FROM plan_vote_delegation pvd USING plans p
+		WHERE p.id = pvd.plan_id AND p.battle_id = $1 AND p.active = false;`,
+		BattleID,
+	); err != nil {
+		d.logger.Error("delete anonymous vote delegations query error", zap.Error(err))
+		return errors.New("unable to anonymize votes")
+	}
+
+	if err := tx.Commit(); err != nil {
+		d.logger.Error("anonymize votes commit error", zap.Error(err))
+		return errors.New("unable to anonymize votes")
+	}
+
+	return nil
+}
@@ -0,0 +1,152 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"go.uber.org/zap"
+)
+
+// validateBattleReopen makes sure the battle is closed and was closed within the reopen window,
+// a window of 0 days or an unrestricted reopen (admins) allows reopening at any time
+func validateBattleReopen(Closed bool, ClosedDate time.Time, Now time.Time, WindowDays int, Unrestricted bool) error {
+	if !Closed {
+		return errors.New("BATTLE_NOT_CLOSED")
+	}
+	if !Unrestricted && WindowDays > 0 && Now.Sub(ClosedDate) > time.Duration(WindowDays)*24*time.Hour {
+		return errors.New("BATTLE_REOPEN_WINDOW_EXPIRED")
+	}
+
+	return nil
+}
+
+// staleBattleCondition battles inactive for more than $1 days, the same activity measure as clean_battles
+const staleBattleCondition = `updated_date < (NOW() - $1 * interval '1 day')`
+
+// GetStaleBattles gets the open battles inactive for more than DaysInactive days
+func (d *Database) GetStaleBattles(DaysInactive int) ([]*model.Battle, error) {
+	var battles = make([]*model.Battle, 0)
+
+	rows, err := d.db.Query(
+		`SELECT id, name, updated_date FROM battles WHERE closed = false AND quick = false AND `+staleBattleCondition+`
+		ORDER BY updated_date;`,
+		DaysInactive,
+	)
+	if err != nil {
+		d.logger.Error("get stale battles query error", zap.Error(err))
+		return nil, errors.New("unable to get stale battles")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var b = &model.Battle{}
+		if err := rows.Scan(&b.Id, &b.Name, &b.UpdatedDate); err != nil {
+			d.logger.Error("get stale battles query scan error", zap.Error(err))
+		} else {
+			battles = append(battles, b)
+		}
+	}
+
+	return battles, nil
+}
+
+// IsBattleClosed checks whether the battle is closed
+func (d *Database) IsBattleClosed(BattleID string) (bool, error) {
+	var Closed bool
+
+	if err := d.db.QueryRow(
+		`SELECT closed FROM battles WHERE id = $1;`,
+		BattleID,
+	).Scan(&Closed); err != nil {
+		d.logger.Error("get battle closed query error", zap.Error(err))
+		return false, errors.New("BATTLE_NOT_FOUND")
+	}
+
+	return Closed, nil
+}
+
+// CloseBattle closes the battle ending any active voting, finalized estimates are kept,
+// battles following the default workflow are also moved to the closed state
+func (d *Database) CloseBattle(BattleID string) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		d.logger.Error("close battle transaction error", zap.Error(err))
+		return errors.New("unable to close battle")
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		`UPDATE plans SET active = false, voteend_time = NOW(), updated_date = NOW() WHERE battle_id = $1 AND active = true;`,
+		BattleID,
+	); err != nil {
+		d.logger.Error("close battle end plan voting query error", zap.Error(err))
+		return errors.New("unable to close battle")
+	}
+
+	if _, err := tx.Exec(
+		`UPDATE battles SET closed = true, closed_date = NOW(), voting_locked = true, state = CASE WHEN state = 'open' THEN 'closed' ELSE state END, updated_date = NOW() WHERE id = $1;`,
+		BattleID,
+	); err != nil {
+		d.logger.Error("close battle query error", zap.Error(err))
+		return errors.New("unable to close battle")
+	}
+
+	if err := tx.Commit(); err != nil {
+		d.logger.Error("close battle commit error", zap.Error(err))
+		return errors.New("unable to close battle")
+	}
+
+	return nil
+}
+
+// ReopenBattle reopens a closed battle within the reopen window and audits the reopen,
+// updated_date is refreshed so retention cleanup doesn't immediately remove it
+func (d *Database) ReopenBattle(BattleID string, UserID string, WindowDays int, Unrestricted bool) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		d.logger.Error("reopen battle transaction error", zap.Error(err))
+		return errors.New("unable to reopen battle")
+	}
+	defer tx.Rollback()
+
+	var Closed bool
+	var ClosedDate sql.NullTime
+	if err := tx.QueryRow(
+		`SELECT closed, closed_date FROM battles WHERE id = $1 FOR UPDATE;`,
+		BattleID,
+	).Scan(&Closed, &ClosedDate); err != nil {
+		d.logger.Error("reopen battle get battle query error", zap.Error(err))
+		return errors.New("BATTLE_NOT_FOUND")
+	}
+
+	if err := validateBattleReopen(Closed, ClosedDate.Time, time.Now(), WindowDays, Unrestricted); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(
+		`UPDATE battles SET closed = false, closed_date = NULL, state = CASE WHEN state = 'closed' THEN 'open' ELSE state END, updated_date = NOW() WHERE id = $1;`,
+		BattleID,
+	); err != nil {
+		d.logger.Error("reopen battle query error", zap.Error(err))
+		return errors.New("unable to reopen battle")
+	}
+
+	if _, err := tx.Exec(
+		`INSERT INTO battle_reopen (battle_id, user_id, closed_date) VALUES ($1, $2, $3);`,
+		BattleID,
+		UserID,
+		ClosedDate,
+	); err != nil {
+		d.logger.Error("insert battle reopen audit query error", zap.Error(err))
+		return errors.New("unable to reopen battle")
+	}
+
+	if err := tx.Commit(); err != nil {
+		d.logger.Error("reopen battle commit error", zap.Error(err))
+		return errors.New("unable to reopen battle")
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+// SetBattleRecording sets whether the battle session events are recorded
+func (d *Database) SetBattleRecording(BattleID string, Enabled bool) error {
+	if _, err := d.db.Exec(
+		`UPDATE battles SET recording_enabled = $2, updated_date = NOW() WHERE id = $1;`,
+		BattleID,
+		Enabled,
+	); err != nil {
+		d.logger.Error("update battle recording_enabled error", zap.Error(err))
+		return errors.New("unable to set battle recording")
+	}
+
+	return nil
+}
+
+// RecordBattleEvents records a batch of battle events in one insert, only keeping those of battles with recording enabled,
+// events attributing votes aren't recorded for permanently anonymous battles
+func (d *Database) RecordBattleEvents(Events []*model.BattleEvent) error {
+	if len(Events) == 0 {
+		return nil
+	}
+
+	BattleIDs := make([]string, 0, len(Events))
+	UserIDs := make([]string, 0, len(Events))
+	Types := make([]string, 0, len(Events))
+	Values := make([]string, 0, len(Events))
+	CreatedDates := make([]string, 0, len(Events))
+	for _, e := range Events {
+		BattleIDs = append(BattleIDs, e.BattleId)
+		UserIDs = append(UserIDs, e.UserId)
+		Types = append(Types, e.Type)
+		Values = append(Values, e.Value)
+		CreatedDates = append(CreatedDates, e.CreatedDate.Format(time.RFC3339Nano))
+	}
+
+	if _, err := d.db.Exec(
+		`INSERT INTO battle_event (battle_id, user_id, event_type, event_value, created_date)
+		SELECT e.battle_id, NULLIF(e.user_id, '')::UUID, e.event_type, e.event_value, e.created_date
+		FROM unnest($1::UUID[], $2::TEXT[], $3::TEXT[], $4::TEXT[], $5::TIMESTAMPTZ[])
+			AS e(battle_id, user_id, event_type, event_value, created_date)
+		JOIN battles b ON b.id = e.battle_id
+		WHERE b.recording_enabled AND NOT (b.permanent_anonymity AND e.event_type = ANY($6));`,
+		pq.Array(BattleIDs),
+		pq.Array(UserIDs),
+		pq.Array(Types),
+		pq.Array(Values),
+		pq.Array(CreatedDates),
+		pq.Array(voteAttributionEvents),
+	); err != nil {
+		d.logger.Error("insert battle_event error", zap.Error(err))
+		return errors.New("unable to record battle events")
+	}
+
+	return nil
+}
+
+// GetBattleEvents gets the ordered list of recorded battle events
+func (d *Database) GetBattleEvents(BattleID string) ([]*model.BattleEvent, error) {
+	var events = make([]*model.BattleEvent, 0)
+
+	rows, err := d.db.Query(
+		`SELECT COALESCE(be.user_id::TEXT, ''), COALESCE(u.name, ''), be.event_type, COALESCE(be.event_value, ''), be.created_date
+		FROM battle_event be
+		LEFT JOIN users u ON be.user_id = u.id
+		WHERE be.battle_id = $1
+		ORDER BY be.created_date;`,
+		BattleID,
+	)
+	if err != nil {
+		d.logger.Error("get battle_event query error", zap.Error(err))
+		return nil, errors.New("unable to get battle events")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e model.BattleEvent
+
+		if err := rows.Scan(
+			&e.UserId,
+			&e.UserName,
+			&e.Type,
+			&e.Value,
+			&e.CreatedDate,
+		); err != nil {
+			d.logger.Error("get battle_event query scan error", zap.Error(err))
+		} else {
+			events = append(events, &e)
+		}
+	}
+
+	return events, nil
+}
@@ -0,0 +1,73 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"go.uber.org/zap"
+)
+
+// ConfirmBattleUser checks the user leads or has joined the battle
+func (d *Database) ConfirmBattleUser(BattleID string, UserID string) error {
+	var Exists, IsUser bool
+
+	if err := d.db.QueryRow(
+		`SELECT EXISTS(SELECT 1 FROM battles WHERE id = $1),
+			EXISTS(SELECT 1 FROM battles_leaders WHERE battle_id = $1 AND user_id = $2)
+			OR EXISTS(SELECT 1 FROM battles_users WHERE battle_id = $1 AND user_id = $2 AND abandoned = false);`,
+		BattleID,
+		UserID,
+	).Scan(&Exists, &IsUser); err != nil {
+		d.logger.Error("confirm battle user query error", zap.Error(err))
+		return errors.New("BATTLE_NOT_FOUND")
+	}
+	if !Exists {
+		return errors.New("BATTLE_NOT_FOUND")
+	}
+	if !IsUser {
+		return errors.New("REQUIRES_BATTLE_USER")
+	}
+
+	return nil
+}
+
+// GetBattlePlans gets the battles plans in order with their final points and revealed votes
+// including the voters names, the votes of plans still being voted on are left out
+func (d *Database) GetBattlePlans(BattleID string) ([]*model.BattleExportPlan, error) {
+	var plans = make([]*model.BattleExportPlan, 0)
+
+	rows, err := d.db.Query(
+		`SELECT p.id, p.name, p.type, COALESCE(p.reference_id, ''), COALESCE(p.link, ''), p.points, p.active, p.skipped,
+			CASE WHEN p.active THEN '[]'::JSON ELSE COALESCE((
+				SELECT json_agg(json_build_object('warriorId', v->>'warriorId', 'name', COALESCE(u.name, ''), 'vote', v->>'vote') ORDER BY u.name, v->>'vote')
+				FROM jsonb_array_elements(p.votes) v
+				LEFT JOIN users u ON u.id::TEXT = v->>'warriorId'
+			), '[]') END
+		FROM plans p
+		WHERE p.battle_id = $1
+		ORDER BY p.position;`,
+		BattleID,
+	)
+	if err != nil {
+		d.logger.Error("get battle export plans query error", zap.Error(err))
+		return nil, errors.New("unable to get battle plans")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var Votes string
+		var p = &model.BattleExportPlan{}
+		if err := rows.Scan(&p.Id, &p.Name, &p.Type, &p.ReferenceId, &p.Link, &p.Points, &p.Active, &p.Skipped, &Votes); err != nil {
+			d.logger.Error("get battle export plans scan error", zap.Error(err))
+			return nil, errors.New("unable to get battle plans")
+		}
+		p.Votes = make([]*model.VoteDetail, 0)
+		if err := json.Unmarshal([]byte(Votes), &p.Votes); err != nil {
+			d.logger.Error("battle export plan votes json error", zap.Error(err))
+		}
+		plans = append(plans, p)
+	}
+
+	return plans, nil
+}
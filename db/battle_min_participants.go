@@ -0,0 +1,57 @@
+package db
+
+import (
+	"errors"
+
+	"go.uber.org/zap"
+)
+
+// maxBattleMinParticipants the highest minimum participants a battle can require
+const maxBattleMinParticipants = 100
+
+// enoughParticipants whether the participant count meets the minimum, a minimum of 0 requires none
+func enoughParticipants(MinParticipants int, Participants int) bool {
+	return Participants >= MinParticipants
+}
+
+// SetBattleMinParticipants sets the minimum active participants required before voting can start, 0 for no minimum
+func (d *Database) SetBattleMinParticipants(BattleID string, MinParticipants int) error {
+	if MinParticipants < 0 || MinParticipants > maxBattleMinParticipants {
+		return errors.New("INVALID_MIN_PARTICIPANTS")
+	}
+
+	if _, err := d.db.Exec(
+		`UPDATE battles SET min_participants = $2, updated_date = NOW() WHERE id = $1;`,
+		BattleID,
+		MinParticipants,
+	); err != nil {
+		d.logger.Error("update battle min_participants error", zap.Error(err))
+		return errors.New("unable to set battle min participants")
+	}
+
+	return nil
+}
+
+// ConfirmBattleParticipants confirms enough participants are connected to start voting,
+// spectators don't vote so they aren't counted
+func (d *Database) ConfirmBattleParticipants(BattleID string) error {
+	var MinParticipants int
+	var Participants int
+
+	if err := d.db.QueryRow(
+		`SELECT b.min_participants, (
+			SELECT COUNT(*) FROM battles_users bu
+			WHERE bu.battle_id = b.id AND bu.active = true AND bu.spectator = false
+		) FROM battles b WHERE b.id = $1;`,
+		BattleID,
+	).Scan(&MinParticipants, &Participants); err != nil {
+		d.logger.Error("get battle participants query error", zap.Error(err))
+		return errors.New("unable to get battle participants")
+	}
+
+	if !enoughParticipants(MinParticipants, Participants) {
+		return errors.New("NOT_ENOUGH_PARTICIPANTS")
+	}
+
+	return nil
+}
@@ -0,0 +1,137 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxBattleParkingLotItemLength the max number of characters of a parking lot item
+	maxBattleParkingLotItemLength = 500
+	// maxBattleParkingLotItems the max number of parking lot items per battle
+	maxBattleParkingLotItems = 100
+)
+
+// validateBattleParkingLotItem validates the parking lot item content and the battles current item count
+func validateBattleParkingLotItem(Content string, ItemCount int) error {
+	if strings.TrimSpace(Content) == "" {
+		return errors.New("PARKING_LOT_ITEM_REQUIRED")
+	}
+	if len([]rune(Content)) > maxBattleParkingLotItemLength {
+		return errors.New("PARKING_LOT_ITEM_TOO_LONG")
+	}
+	if ItemCount >= maxBattleParkingLotItems {
+		return errors.New("PARKING_LOT_LIMIT_REACHED")
+	}
+
+	return nil
+}
+
+// GetBattleParkingLot gets the battles parking lot items oldest first
+func (d *Database) GetBattleParkingLot(BattleID string) []*model.BattleParkingLotItem {
+	var items = make([]*model.BattleParkingLotItem, 0)
+	rows, err := d.db.Query(
+		`SELECT bpl.id, COALESCE(bpl.user_id::TEXT, ''), COALESCE(u.name, ''), bpl.content, bpl.resolved, bpl.created_date
+		FROM battle_parking_lot bpl
+		LEFT JOIN users u ON u.id = bpl.user_id
+		WHERE bpl.battle_id = $1
+		ORDER BY bpl.created_date;`,
+		BattleID,
+	)
+	if err != nil {
+		d.logger.Error("get battle parking lot query error", zap.Error(err))
+		return items
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var i model.BattleParkingLotItem
+		if err := rows.Scan(&i.Id, &i.UserId, &i.UserName, &i.Content, &i.Resolved, &i.CreatedDate); err != nil {
+			d.logger.Error("get battle parking lot scan error", zap.Error(err))
+			continue
+		}
+		items = append(items, &i)
+	}
+
+	return items
+}
+
+// AddBattleParkingLotItem adds a note to the battles parking lot
+func (d *Database) AddBattleParkingLotItem(BattleID string, UserID string, Content string) ([]*model.BattleParkingLotItem, error) {
+	var ItemCount int
+	err := d.db.QueryRow(
+		`SELECT COUNT(*) FROM battle_parking_lot WHERE battle_id = $1;`,
+		BattleID,
+	).Scan(&ItemCount)
+	if err != nil {
+		d.logger.Error("get battle parking lot count error", zap.Error(err))
+		return nil, errors.New("unable to add parking lot item")
+	}
+
+	SanitizedContent := d.htmlSanitizerPolicy.Sanitize(Content)
+	if err := validateBattleParkingLotItem(SanitizedContent, ItemCount); err != nil {
+		return nil, err
+	}
+
+	if _, err := d.db.Exec(
+		`INSERT INTO battle_parking_lot (battle_id, user_id, content) VALUES ($1, $2, $3);`,
+		BattleID,
+		UserID,
+		SanitizedContent,
+	); err != nil {
+		d.logger.Error("insert battle parking lot item error", zap.Error(err))
+		return nil, errors.New("unable to add parking lot item")
+	}
+
+	return d.GetBattleParkingLot(BattleID), nil
+}
+
+// ToggleBattleParkingLotItem toggles whether a battles parking lot item is resolved
+func (d *Database) ToggleBattleParkingLotItem(BattleID string, ItemID string) ([]*model.BattleParkingLotItem, error) {
+	if _, err := d.db.Exec(
+		`UPDATE battle_parking_lot SET resolved = NOT resolved, updated_date = NOW()
+		WHERE id = $2 AND battle_id = $1;`,
+		BattleID,
+		ItemID,
+	); err != nil {
+		d.logger.Error("toggle battle parking lot item error", zap.Error(err))
+		return nil, errors.New("unable to toggle parking lot item")
+	}
+
+	return d.GetBattleParkingLot(BattleID), nil
+}
+
+// RemoveBattleParkingLotItem removes a battles parking lot item, only its author or a battle leader can remove it
+func (d *Database) RemoveBattleParkingLotItem(BattleID string, UserID string, ItemID string) ([]*model.BattleParkingLotItem, error) {
+	var AuthorID sql.NullString
+	err := d.db.QueryRow(
+		`SELECT user_id FROM battle_parking_lot WHERE id = $2 AND battle_id = $1;`,
+		BattleID,
+		ItemID,
+	).Scan(&AuthorID)
+	if err != nil {
+		d.logger.Error("get battle parking lot item error", zap.Error(err))
+		return nil, errors.New("PARKING_LOT_ITEM_NOT_FOUND")
+	}
+
+	if AuthorID.String != UserID {
+		if err := d.ConfirmLeader(BattleID, UserID); err != nil {
+			return nil, errors.New("REQUIRES_AUTHOR_OR_LEADER")
+		}
+	}
+
+	if _, err := d.db.Exec(
+		`DELETE FROM battle_parking_lot WHERE id = $2 AND battle_id = $1;`,
+		BattleID,
+		ItemID,
+	); err != nil {
+		d.logger.Error("delete battle parking lot item error", zap.Error(err))
+		return nil, errors.New("unable to remove parking lot item")
+	}
+
+	return d.GetBattleParkingLot(BattleID), nil
+}
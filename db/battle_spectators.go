@@ -0,0 +1,65 @@
+package db
+
+import (
+	"errors"
+
+	"go.uber.org/zap"
+)
+
+// confirmBattleVoter checks the user isn't spectating the battle, spectators can see votes but not cast them
+func (d *Database) confirmBattleVoter(BattleID string, UserID string) error {
+	var Spectator bool
+
+	if err := d.db.QueryRow(
+		`SELECT EXISTS(SELECT 1 FROM battles_users WHERE battle_id = $1 AND user_id = $2 AND spectator = true);`,
+		BattleID,
+		UserID,
+	).Scan(&Spectator); err != nil {
+		d.logger.Error("get battle user spectator query error", zap.Error(err))
+		return errors.New("unable to confirm battle voter")
+	}
+	if Spectator {
+		return errors.New("SPECTATOR_CANNOT_VOTE")
+	}
+
+	return nil
+}
+
+// removeSpectatorVote removes the vote delegations of a user who became a spectator, both theirs and those to them,
+// and their vote from the battles open active plan so they don't count towards its estimate,
+// returns whether the plans changed
+func (d *Database) removeSpectatorVote(BattleID string, UserID string) bool {
+	var Changed bool
+
+	res, err := d.db.Exec(
+		`DELETE FROM plan_vote_delegation pvd USING plans p
+		WHERE pvd.plan_id = p.id AND p.battle_id = $1 AND pvd.active = true AND (pvd.user_id = $2 OR pvd.delegate_id = $2);`,
+		BattleID,
+		UserID,
+	)
+	if err != nil {
+		d.logger.Error("remove spectator vote delegations query error", zap.Error(err))
+	} else if revoked, _ := res.RowsAffected(); revoked > 0 {
+		Changed = true
+	}
+
+	var PlanID string
+	var Voted bool
+	if err := d.db.QueryRow(
+		`SELECT p.id, EXISTS(SELECT 1 FROM jsonb_array_elements(p.votes) v WHERE v->>'warriorId' = $2)
+		FROM battles b
+		JOIN plans p ON p.id = b.active_plan_id
+		WHERE b.id = $1 AND b.voting_locked = false;`,
+		BattleID,
+		UserID,
+	).Scan(&PlanID, &Voted); err != nil || !Voted {
+		return Changed
+	}
+
+	if _, err := d.db.Exec(`call retract_user_vote($1, $2);`, PlanID, UserID); err != nil {
+		d.logger.Error("call retract_user_vote for spectator error", zap.Error(err))
+		return Changed
+	}
+
+	return true
+}
@@ -0,0 +1,192 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"go.uber.org/zap"
+)
+
+// maxBattleStates the max number of states a battle state workflow can define
+const maxBattleStates = 20
+
+// DefaultBattleStateWorkflow the open/closed workflow used when a team hasn't defined its own
+func DefaultBattleStateWorkflow() *model.BattleStateWorkflow {
+	return &model.BattleStateWorkflow{
+		States: []string{"open", "closed"},
+		Transitions: map[string][]string{
+			"open":   {"closed"},
+			"closed": {"open"},
+		},
+	}
+}
+
+// validateBattleStateWorkflow makes sure the workflow states are unique and transitions only reference its states
+func validateBattleStateWorkflow(Workflow *model.BattleStateWorkflow) error {
+	if len(Workflow.States) == 0 || len(Workflow.States) > maxBattleStates {
+		return errors.New("INVALID_STATE_WORKFLOW")
+	}
+
+	for i, State := range Workflow.States {
+		if State == "" || State != strings.TrimSpace(State) || len(State) > 64 {
+			return errors.New("INVALID_STATE_WORKFLOW")
+		}
+		if contains(Workflow.States[:i], State) {
+			return errors.New("INVALID_STATE_WORKFLOW")
+		}
+	}
+
+	for From, To := range Workflow.Transitions {
+		if !contains(Workflow.States, From) {
+			return errors.New("INVALID_STATE_WORKFLOW")
+		}
+		for _, State := range To {
+			if !contains(Workflow.States, State) {
+				return errors.New("INVALID_STATE_WORKFLOW")
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateBattleStateTransition makes sure the workflow allows moving from one state to another,
+// a battle in a state the workflow no longer defines can move to any of the workflows states
+func validateBattleStateTransition(Workflow *model.BattleStateWorkflow, From string, To string) error {
+	if !contains(Workflow.States, To) || From == To {
+		return errors.New("INVALID_STATE_TRANSITION")
+	}
+	if contains(Workflow.States, From) && !contains(Workflow.Transitions[From], To) {
+		return errors.New("INVALID_STATE_TRANSITION")
+	}
+
+	return nil
+}
+
+// GetTeamBattleStateWorkflow gets the teams battle state workflow, the default workflow when not customized
+func (d *Database) GetTeamBattleStateWorkflow(TeamID string) (*model.BattleStateWorkflow, error) {
+	var Workflow sql.NullString
+
+	if TeamID != "" {
+		if err := d.db.QueryRow(
+			`SELECT battle_state_workflow FROM team WHERE id = $1;`,
+			TeamID,
+		).Scan(&Workflow); err != nil && err != sql.ErrNoRows {
+			d.logger.Error("get team battle state workflow query error", zap.Error(err))
+			return nil, errors.New("unable to get team battle state workflow")
+		}
+	}
+
+	if !Workflow.Valid {
+		return DefaultBattleStateWorkflow(), nil
+	}
+
+	var w = &model.BattleStateWorkflow{}
+	if err := json.Unmarshal([]byte(Workflow.String), w); err != nil {
+		d.logger.Error("team battle state workflow json error", zap.Error(err))
+		return DefaultBattleStateWorkflow(), nil
+	}
+
+	return w, nil
+}
+
+// UpdateTeamBattleStateWorkflow sets the teams battle state workflow, nil reverts to the default
+func (d *Database) UpdateTeamBattleStateWorkflow(TeamID string, Workflow *model.BattleStateWorkflow) error {
+	var WorkflowJSON sql.NullString
+
+	if Workflow != nil {
+		if err := validateBattleStateWorkflow(Workflow); err != nil {
+			return err
+		}
+		w, _ := json.Marshal(Workflow)
+		WorkflowJSON = sql.NullString{String: string(w), Valid: true}
+	}
+
+	if _, err := d.db.Exec(
+		`UPDATE team SET battle_state_workflow = $2, updated_date = NOW() WHERE id = $1;`,
+		TeamID,
+		WorkflowJSON,
+	); err != nil {
+		d.logger.Error("update team battle state workflow query error", zap.Error(err))
+		return errors.New("unable to update team battle state workflow")
+	}
+
+	return nil
+}
+
+// GetBattleStateWorkflow gets the battle state workflow of the battles team
+func (d *Database) GetBattleStateWorkflow(BattleID string) (*model.BattleStateWorkflow, error) {
+	var TeamID string
+
+	if err := d.db.QueryRow(
+		`SELECT team_id FROM team_battle WHERE battle_id = $1 LIMIT 1;`,
+		BattleID,
+	).Scan(&TeamID); err != nil && err != sql.ErrNoRows {
+		d.logger.Error("get battle team query error", zap.Error(err))
+	}
+
+	return d.GetTeamBattleStateWorkflow(TeamID)
+}
+
+// SetBattleState moves the battle to the state when its teams workflow allows the transition
+func (d *Database) SetBattleState(BattleID string, State string) error {
+	Workflow, err := d.GetBattleStateWorkflow(BattleID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		d.logger.Error("set battle state transaction error", zap.Error(err))
+		return errors.New("unable to set battle state")
+	}
+	defer tx.Rollback()
+
+	var CurrentState string
+	if err := tx.QueryRow(
+		`SELECT state FROM battles WHERE id = $1 FOR UPDATE;`,
+		BattleID,
+	).Scan(&CurrentState); err != nil {
+		d.logger.Error("set battle state get battle query error", zap.Error(err))
+		return errors.New("BATTLE_NOT_FOUND")
+	}
+
+	if err := validateBattleStateTransition(Workflow, CurrentState, State); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(
+		`UPDATE battles SET state = $2, updated_date = NOW() WHERE id = $1;`,
+		BattleID,
+		State,
+	); err != nil {
+		d.logger.Error("set battle state query error", zap.Error(err))
+		return errors.New("unable to set battle state")
+	}
+
+	if err := tx.Commit(); err != nil {
+		d.logger.Error("set battle state commit error", zap.Error(err))
+		return errors.New("unable to set battle state")
+	}
+
+	return nil
+}
+
+// initBattleState moves a battle still in the default initial state to the initial state of its teams workflow
+func (d *Database) initBattleState(TeamID string, BattleID string) {
+	Workflow, err := d.GetTeamBattleStateWorkflow(TeamID)
+	if err != nil {
+		return
+	}
+
+	if _, err := d.db.Exec(
+		`UPDATE battles SET state = $2 WHERE id = $1 AND state = 'open';`,
+		BattleID,
+		Workflow.States[0],
+	); err != nil {
+		d.logger.Error("init battle state query error", zap.Error(err))
+	}
+}
@@ -10,8 +10,12 @@ import (
 	"go.uber.org/zap"
 )
 
-//CreateBattle creates a new story pointing session (battle)
-func (d *Database) CreateBattle(LeaderID string, BattleName string, PointValuesAllowed []string, Plans []*model.Plan, AutoFinishVoting bool, PointAverageRounding string) (*model.Battle, error) {
+//CreateBattle creates a new story pointing session (battle) using the point scale, custom scales use the PointValuesAllowed
+func (d *Database) CreateBattle(LeaderID string, BattleName string, PointScale string, PointValuesAllowed []string, Plans []*model.Plan, AutoFinishVoting bool, PointAverageRounding string) (*model.Battle, error) {
+	PointValuesAllowed, PointScale, err := ResolvePointScale(PointScale, PointValuesAllowed)
+	if err != nil {
+		return nil, err
+	}
 	var pointValuesJSON, _ = json.Marshal(PointValuesAllowed)
 
 	var b = &model.Battle{
@@ -19,6 +23,7 @@ func (d *Database) CreateBattle(LeaderID string, BattleName string, PointValuesA
 		Users:              make([]*model.BattleUser, 0),
 		Plans:              make([]*model.Plan, 0),
 		VotingLocked:       true,
+		PointScale:         PointScale,
 		PointValuesAllowed: PointValuesAllowed,
 		AutoFinishVoting:   AutoFinishVoting,
 		Leaders:            make([]string, 0),
@@ -38,6 +43,10 @@ func (d *Database) CreateBattle(LeaderID string, BattleName string, PointValuesA
 		return nil, errors.New("error creating battle")
 	}
 
+	if _, err := d.db.Exec(`UPDATE battles SET point_scale = $2 WHERE id = $1;`, b.Id, PointScale); err != nil {
+		d.logger.Error("update battle point_scale query error", zap.Error(err))
+	}
+
 	for _, plan := range Plans {
 		plan.Votes = make([]*model.Vote, 0)
 
@@ -48,8 +57,8 @@ func (d *Database) CreateBattle(LeaderID string, BattleName string, PointValuesA
 			plan.Type,
 			plan.ReferenceId,
 			plan.Link,
-			plan.Description,
-			plan.AcceptanceCriteria,
+			d.htmlSanitizerPolicy.Sanitize(plan.Description),
+			d.htmlSanitizerPolicy.Sanitize(plan.AcceptanceCriteria),
 		).Scan(&plan.Id)
 		if e != nil {
 			d.logger.Error("insert plans error", zap.Error(e))
@@ -62,7 +71,11 @@ func (d *Database) CreateBattle(LeaderID string, BattleName string, PointValuesA
 }
 
 // ReviseBattle updates the battle by ID
-func (d *Database) ReviseBattle(BattleID string, BattleName string, PointValuesAllowed []string, AutoFinishVoting bool, PointAverageRounding string, JoinCode string, LeaderCode string) error {
+func (d *Database) ReviseBattle(BattleID string, BattleName string, PointScale string, PointValuesAllowed []string, AutoFinishVoting bool, PointAverageRounding string, JoinCode string, LeaderCode string) error {
+	PointValuesAllowed, PointScale, err := ResolvePointScale(PointScale, PointValuesAllowed)
+	if err != nil {
+		return err
+	}
 	var pointValuesJSON, _ = json.Marshal(PointValuesAllowed)
 	var encryptedJoinCode string
 	var encryptedLeaderCode string
@@ -85,9 +98,9 @@ func (d *Database) ReviseBattle(BattleID string, BattleName string, PointValuesA
 
 	if _, err := d.db.Exec(`
 		UPDATE battles
-		SET name = $2, point_values_allowed = $3, auto_finish_voting = $4, point_average_rounding = $5, join_code = $6, leader_code = $7, updated_date = NOW()
+		SET name = $2, point_values_allowed = $3, auto_finish_voting = $4, point_average_rounding = $5, join_code = $6, leader_code = $7, point_scale = $8, updated_date = NOW()
 		WHERE id = $1`,
-		BattleID, BattleName, string(pointValuesJSON), AutoFinishVoting, PointAverageRounding, encryptedJoinCode, encryptedLeaderCode,
+		BattleID, BattleName, string(pointValuesJSON), AutoFinishVoting, PointAverageRounding, encryptedJoinCode, encryptedLeaderCode, PointScale,
 	); err != nil {
 		d.logger.Error("update battle error", zap.Error(err))
 		return errors.New("unable to revise battle")
@@ -135,13 +148,14 @@ func (d *Database) GetBattle(BattleID string, UserID string) (*model.Battle, err
 	var leaders string
 	var JoinCode string
 	var LeaderCode string
+	var EstimateCap sql.NullFloat64
 	e := d.db.QueryRow(
 		`
-		SELECT b.id, b.name, b.voting_locked, b.active_plan_id, b.point_values_allowed, b.auto_finish_voting, b.point_average_rounding, COALESCE(b.join_code, ''), COALESCE(b.leader_code, ''), b.created_date, b.updated_date,
+		SELECT b.id, b.name, b.voting_locked, b.active_plan_id, b.point_values_allowed, b.auto_finish_voting, b.point_average_rounding, COALESCE(b.join_code, ''), COALESCE(b.leader_code, ''), b.recording_enabled, COALESCE(b.current_plan_id::TEXT, ''), b.auto_start_voting, b.paused, COALESCE(b.short_code, ''), b.quick, b.expire_date, b.require_ready_to_reveal, b.permanent_anonymity, `+battleEstimateCapQuery+`, b.min_participants, b.closed, b.closed_date, b.state, COALESCE(b.note_taker_id::TEXT, ''), b.point_scale, b.vote_timer_default, b.created_date, b.updated_date,
 		CASE WHEN COUNT(bl) = 0 THEN '[]'::json ELSE array_to_json(array_agg(bl.user_id)) END AS leaders
 		FROM battles b
 		LEFT JOIN battles_leaders bl ON b.id = bl.battle_id
-		WHERE b.id = $1
+		WHERE b.id = $1 AND (b.expire_date IS NULL OR b.expire_date > NOW())
 		GROUP BY b.id`,
 		BattleID,
 	).Scan(
@@ -154,6 +168,23 @@ func (d *Database) GetBattle(BattleID string, UserID string) (*model.Battle, err
 		&b.PointAverageRounding,
 		&JoinCode,
 		&LeaderCode,
+		&b.RecordingEnabled,
+		&b.CurrentPlanID,
+		&b.AutoStartVoting,
+		&b.Paused,
+		&b.ShortCode,
+		&b.Quick,
+		&b.ExpireDate,
+		&b.RequireReadyToReveal,
+		&b.PermanentAnonymity,
+		&EstimateCap,
+		&b.MinParticipants,
+		&b.Closed,
+		&b.ClosedDate,
+		&b.State,
+		&b.NoteTakerID,
+		&b.PointScale,
+		&b.VoteTimerDefault,
 		&b.CreatedDate,
 		&b.UpdatedDate,
 		&leaders,
@@ -166,6 +197,8 @@ func (d *Database) GetBattle(BattleID string, UserID string) (*model.Battle, err
 	_ = json.Unmarshal([]byte(leaders), &b.Leaders)
 	_ = json.Unmarshal([]byte(pv), &b.PointValuesAllowed)
 	b.ActivePlanID = ActivePlanID.String
+	b.EstimateCap = nullFloatPtr(EstimateCap)
+	b.AbstainVote = d.config.AbstainVote
 
 	isBattleLeader := contains(b.Leaders, UserID)
 
@@ -187,20 +220,129 @@ func (d *Database) GetBattle(BattleID string, UserID string) (*model.Battle, err
 
 	b.Users = d.GetBattleUsers(BattleID)
 	b.Plans = d.GetPlans(BattleID, UserID)
+	b.PlanLimit = d.GetBattlePlanLimit(BattleID)
+	b.ParkingLot = d.GetBattleParkingLot(BattleID)
 
 	return b, nil
 }
 
-// GetBattlesByUser gets a list of battles by UserID
-func (d *Database) GetBattlesByUser(UserID string, Limit int, Offset int) ([]*model.Battle, int, error) {
+// SetBattlePaused pauses or resumes the battle, on resume the active plans voting start time
+// is moved forward by the paused duration so voting timers resume where they left off
+func (d *Database) SetBattlePaused(BattleID string, Paused bool) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		d.logger.Error("set battle paused transaction error", zap.Error(err))
+		return errors.New("unable to set battle paused")
+	}
+	defer tx.Rollback()
+
+	if !Paused {
+		if _, err := tx.Exec(
+			`UPDATE plans SET votestart_time = votestart_time + (NOW() - b.paused_date),
+				vote_timer_end = vote_timer_end + (NOW() - b.paused_date)
+			FROM battles b WHERE b.id = $1 AND plans.battle_id = b.id AND plans.active = true
+			AND b.paused = true AND b.paused_date IS NOT NULL;`,
+			BattleID,
+		); err != nil {
+			d.logger.Error("update battle plan votestart_time on resume error", zap.Error(err))
+			return errors.New("unable to set battle paused")
+		}
+	}
+
+	if _, err := tx.Exec(
+		`UPDATE battles SET paused = $2, paused_date = CASE WHEN $2 THEN COALESCE(paused_date, NOW()) ELSE NULL END, updated_date = NOW()
+		WHERE id = $1;`,
+		BattleID,
+		Paused,
+	); err != nil {
+		d.logger.Error("update battle paused error", zap.Error(err))
+		return errors.New("unable to set battle paused")
+	}
+
+	if err := tx.Commit(); err != nil {
+		d.logger.Error("set battle paused commit error", zap.Error(err))
+		return errors.New("unable to set battle paused")
+	}
+
+	return nil
+}
+
+// GetBattlePaused gets whether the battle is paused
+func (d *Database) GetBattlePaused(BattleID string) (bool, error) {
+	var Paused bool
+
+	err := d.db.QueryRow(
+		`SELECT paused FROM battles WHERE id = $1;`,
+		BattleID,
+	).Scan(&Paused)
+	if err != nil {
+		d.logger.Error("get battle paused error", zap.Error(err))
+		return false, errors.New("BATTLE_NOT_FOUND")
+	}
+
+	return Paused, nil
+}
+
+const (
+	// battleShortCodeLength the number of characters in a battle short code
+	battleShortCodeLength = 6
+	// battleShortCodeAttempts how many times to regenerate a short code on collision
+	battleShortCodeAttempts = 5
+)
+
+// CreateBattleShortCode generates a unique human friendly short code for the battle, regenerating on collision
+func (d *Database) CreateBattleShortCode(BattleID string) (string, error) {
+	for i := 0; i < battleShortCodeAttempts; i++ {
+		code, err := randomShortCode(battleShortCodeLength)
+		if err != nil {
+			d.logger.Error("generate battle short_code error", zap.Error(err))
+			return "", errors.New("unable to create battle short code")
+		}
+
+		var ShortCode string
+		err = d.db.QueryRow(
+			`UPDATE battles SET short_code = $2, updated_date = NOW()
+			WHERE id = $1 AND NOT EXISTS (SELECT 1 FROM battles WHERE short_code = $2)
+			RETURNING short_code;`,
+			BattleID,
+			code,
+		).Scan(&ShortCode)
+		if err == nil {
+			return ShortCode, nil
+		}
+		d.logger.Warn("battle short_code collision, regenerating", zap.Error(err))
+	}
+
+	return "", errors.New("unable to create battle short code")
+}
+
+// GetBattleIDByShortCode resolves a battle short code (case-insensitive) to the battle ID
+func (d *Database) GetBattleIDByShortCode(ShortCode string) (string, error) {
+	var BattleID string
+
+	err := d.db.QueryRow(
+		`SELECT id FROM battles WHERE short_code = $1;`,
+		normalizeShortCode(ShortCode),
+	).Scan(&BattleID)
+	if err != nil {
+		return "", errors.New("BATTLE_NOT_FOUND")
+	}
+
+	return BattleID, nil
+}
+
+// GetBattlesByUser gets a list of battles by UserID, optionally filtered by battle state and the users tag
+func (d *Database) GetBattlesByUser(UserID string, State string, Tag string, Limit int, Offset int) ([]*model.Battle, int, error) {
+	Tag = strings.ToLower(strings.TrimSpace(Tag))
 	var Count int
 	var battles = make([]*model.Battle, 0)
 
 	e := d.db.QueryRow(`
 		SELECT COUNT(*) FROM battles b
 		LEFT JOIN battles_users bw ON b.id = bw.battle_id
-		WHERE bw.user_id = $1 AND bw.abandoned = false;
-	`, UserID).Scan(
+		WHERE bw.user_id = $1 AND bw.abandoned = false AND b.quick = false AND ($2 = '' OR b.state = $2)
+		AND ($3 = '' OR EXISTS(SELECT 1 FROM battle_tag bt WHERE bt.battle_id = b.id AND bt.user_id = $1 AND bt.tag = $3));
+	`, UserID, State, Tag).Scan(
 		&Count,
 	)
 	if e != nil {
@@ -208,17 +350,19 @@ func (d *Database) GetBattlesByUser(UserID string, Limit int, Offset int) ([]*mo
 	}
 
 	battleRows, battlesErr := d.db.Query(`
-		SELECT b.id, b.name, b.voting_locked, b.active_plan_id, b.point_values_allowed, b.auto_finish_voting, b.point_average_rounding, b.created_date, b.updated_date,
+		SELECT b.id, b.name, b.voting_locked, b.active_plan_id, b.point_values_allowed, b.auto_finish_voting, b.point_average_rounding, b.state, b.created_date, b.updated_date,
 		CASE WHEN COUNT(p) = 0 THEN '[]'::json ELSE array_to_json(array_agg(row_to_json(p))) END AS plans,
-		CASE WHEN COUNT(bl) = 0 THEN '[]'::json ELSE array_to_json(array_agg(bl.user_id)) END AS leaders
+		CASE WHEN COUNT(bl) = 0 THEN '[]'::json ELSE array_to_json(array_agg(bl.user_id)) END AS leaders,
+		(SELECT COALESCE(json_agg(bt.tag ORDER BY bt.tag), '[]') FROM battle_tag bt WHERE bt.battle_id = b.id AND bt.user_id = $1) AS tags
 		FROM battles b
 		LEFT JOIN plans p ON b.id = p.battle_id
 		LEFT JOIN battles_leaders bl ON b.id = bl.battle_id
 		LEFT JOIN battles_users bw ON b.id = bw.battle_id
-		WHERE bw.user_id = $1 AND bw.abandoned = false
+		WHERE bw.user_id = $1 AND bw.abandoned = false AND b.quick = false AND ($2 = '' OR b.state = $2)
+		AND ($5 = '' OR EXISTS(SELECT 1 FROM battle_tag bt WHERE bt.battle_id = b.id AND bt.user_id = $1 AND bt.tag = $5))
 		GROUP BY b.id ORDER BY b.created_date DESC
-		LIMIT $2 OFFSET $3
-	`, UserID, Limit, Offset)
+		LIMIT $3 OFFSET $4
+	`, UserID, State, Limit, Offset, Tag)
 	if battlesErr != nil {
 		return nil, Count, errors.New("not found")
 	}
@@ -228,6 +372,7 @@ func (d *Database) GetBattlesByUser(UserID string, Limit int, Offset int) ([]*mo
 		var plans string
 		var pv string
 		var leaders string
+		var tags string
 		var ActivePlanID sql.NullString
 		var b = &model.Battle{
 			Users:              make([]*model.BattleUser, 0),
@@ -245,16 +390,19 @@ func (d *Database) GetBattlesByUser(UserID string, Limit int, Offset int) ([]*mo
 			&pv,
 			&b.AutoFinishVoting,
 			&b.PointAverageRounding,
+			&b.State,
 			&b.CreatedDate,
 			&b.UpdatedDate,
 			&plans,
 			&leaders,
+			&tags,
 		); err != nil {
 			d.logger.Error("error getting battle by user", zap.Error(e))
 		} else {
 			_ = json.Unmarshal([]byte(plans), &b.Plans)
 			_ = json.Unmarshal([]byte(pv), &b.PointValuesAllowed)
 			_ = json.Unmarshal([]byte(leaders), &b.Leaders)
+			_ = json.Unmarshal([]byte(tags), &b.Tags)
 			b.ActivePlanID = ActivePlanID.String
 			battles = append(battles, b)
 		}
@@ -482,14 +630,21 @@ func (d *Database) DemoteBattleLeader(BattleID string, LeaderID string) ([]strin
 	return leaders, nil
 }
 
-// ToggleSpectator changes a battle users spectator status
-func (d *Database) ToggleSpectator(BattleID string, UserID string, Spectator bool) ([]*model.BattleUser, error) {
-	if _, err := d.db.Exec(
-		`UPDATE battles_users SET spectator = $3 WHERE battle_id = $1 AND user_id = $2`, BattleID, UserID, Spectator); err != nil {
+// ToggleSpectator changes a battle users spectator status, a user becoming a spectator has their vote
+// on the open active plan and their vote delegations removed so they don't count, returns whether the plans changed
+func (d *Database) ToggleSpectator(BattleID string, UserID string, Spectator bool) ([]*model.BattleUser, bool, error) {
+	res, err := d.db.Exec(
+		`UPDATE battles_users SET spectator = $3 WHERE battle_id = $1 AND user_id = $2`, BattleID, UserID, Spectator)
+	if err != nil {
 		d.logger.Error("update battle user spectator error", zap.Error(err))
-		return nil, err
+		return nil, false, err
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return nil, false, errors.New("BATTLE_USER_NOT_FOUND")
 	}
 
+	PlansChanged := Spectator && d.removeSpectatorVote(BattleID, UserID)
+
 	if _, err := d.db.Exec(
 		`UPDATE users SET last_active = NOW() WHERE id = $1`, UserID); err != nil {
 		d.logger.Error("error updating user last active timestamp", zap.Error(err))
@@ -497,7 +652,7 @@ func (d *Database) ToggleSpectator(BattleID string, UserID string, Spectator boo
 
 	users := d.GetBattleUsers(BattleID)
 
-	return users, nil
+	return users, PlansChanged, nil
 }
 
 // DeleteBattle removes all battle associations and the battle itself by BattleID
@@ -536,7 +691,7 @@ func (d *Database) GetBattles(Limit int, Offset int) ([]*model.Battle, int, erro
 	var Count int
 
 	e := d.db.QueryRow(
-		"SELECT COUNT(*) FROM battles;",
+		"SELECT COUNT(*) FROM battles WHERE quick = false;",
 	).Scan(
 		&Count,
 	)
@@ -549,6 +704,7 @@ func (d *Database) GetBattles(Limit int, Offset int) ([]*model.Battle, int, erro
 		CASE WHEN COUNT(bl) = 0 THEN '[]'::json ELSE array_to_json(array_agg(bl.user_id)) END AS leaders
 		FROM battles b
 		LEFT JOIN battles_leaders bl ON b.id = bl.battle_id
+		WHERE b.quick = false
 		GROUP BY b.id ORDER BY b.created_date DESC
 		LIMIT $1 OFFSET $2;
 	`, Limit, Offset)
@@ -599,7 +755,7 @@ func (d *Database) GetActiveBattles(Limit int, Offset int) ([]*model.Battle, int
 	var Count int
 
 	e := d.db.QueryRow(
-		"SELECT COUNT(DISTINCT bu.battle_id) FROM battles_users bu WHERE bu.active IS TRUE;",
+		"SELECT COUNT(DISTINCT bu.battle_id) FROM battles_users bu JOIN battles b ON b.id = bu.battle_id WHERE bu.active IS TRUE AND b.quick = false;",
 	).Scan(
 		&Count,
 	)
@@ -613,7 +769,7 @@ func (d *Database) GetActiveBattles(Limit int, Offset int) ([]*model.Battle, int
 		FROM battles_users bu
 		LEFT JOIN battles b ON b.id = bu.battle_id
 		LEFT JOIN battles_leaders bl ON b.id = bl.battle_id
-		WHERE bu.active IS TRUE GROUP BY b.id
+		WHERE bu.active IS TRUE AND b.quick = false GROUP BY b.id
 		LIMIT $1 OFFSET $2;
 	`, Limit, Offset)
 	if battlesErr != nil {
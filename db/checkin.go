@@ -178,12 +178,14 @@ func (d *Database) CheckinComment(
 		return errors.New("REQUIRES_TEAM_USER")
 	}
 
+	SanitizedComment := d.htmlSanitizerPolicy.Sanitize(Comment)
+
 	if _, err := d.db.Exec(`
 		INSERT INTO team_checkin_comment (checkin_id, user_id, comment) VALUES ($1, $2, $3);
 		`,
 		CheckinId,
 		UserId,
-		Comment,
+		SanitizedComment,
 	); err != nil {
 		return err
 	}
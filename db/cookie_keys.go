@@ -0,0 +1,76 @@
+package db
+
+import (
+	"encoding/base64"
+	"errors"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"go.uber.org/zap"
+)
+
+// AddCookieSigningKey stores a new cookie signing key encrypted, which becomes the current signer
+func (d *Database) AddCookieSigningKey(Key []byte) (*model.CookieSigningKey, error) {
+	var k = &model.CookieSigningKey{Key: Key, Current: true}
+
+	EncryptedKey, err := encrypt(base64.StdEncoding.EncodeToString(Key), d.config.AESHashkey)
+	if err != nil {
+		d.logger.Error("encrypt cookie signing key error", zap.Error(err))
+		return nil, errors.New("unable to add cookie signing key")
+	}
+
+	if err := d.db.QueryRow(
+		`INSERT INTO cookie_signing_key (signing_key) VALUES ($1) RETURNING id, created_date;`,
+		EncryptedKey,
+	).Scan(&k.Id, &k.CreatedDate); err != nil {
+		d.logger.Error("insert cookie signing key error", zap.Error(err))
+		return nil, errors.New("unable to add cookie signing key")
+	}
+
+	return k, nil
+}
+
+// GetCookieSigningKeys gets the rotated cookie signing keys newest (current) first, the current key is always
+// returned and previous keys are kept for the retention days from when they were superseded by a newer key
+func (d *Database) GetCookieSigningKeys(RetentionDays int) ([]*model.CookieSigningKey, error) {
+	var keys = make([]*model.CookieSigningKey, 0)
+
+	rows, err := d.db.Query(
+		`SELECT id, signing_key, created_date FROM (
+			SELECT id, signing_key, created_date,
+				LAG(created_date) OVER (ORDER BY created_date DESC) AS superseded_date
+			FROM cookie_signing_key
+		) csk
+		WHERE superseded_date IS NULL OR superseded_date > NOW() - make_interval(days => $1)
+		ORDER BY created_date DESC;`,
+		RetentionDays,
+	)
+	if err != nil {
+		d.logger.Error("get cookie signing keys query error", zap.Error(err))
+		return nil, errors.New("unable to get cookie signing keys")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var k model.CookieSigningKey
+		var EncryptedKey string
+
+		if err := rows.Scan(&k.Id, &EncryptedKey, &k.CreatedDate); err != nil {
+			d.logger.Error("get cookie signing keys query scan error", zap.Error(err))
+			return nil, errors.New("unable to get cookie signing keys")
+		}
+
+		DecryptedKey, err := decrypt(EncryptedKey, d.config.AESHashkey)
+		if err != nil {
+			d.logger.Error("decrypt cookie signing key error", zap.Error(err))
+			continue
+		}
+		if k.Key, err = base64.StdEncoding.DecodeString(DecryptedKey); err != nil {
+			continue
+		}
+		k.Current = len(keys) == 0
+
+		keys = append(keys, &k)
+	}
+
+	return keys, nil
+}
@@ -13,7 +13,6 @@ import (
 	"github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 	_ "github.com/lib/pq" // necessary for postgres
-	"github.com/microcosm-cc/bluemonday"
 )
 
 //go:embed migrations/*.sql
@@ -29,12 +28,13 @@ func New(AdminEmail string, config *Config, logger *zap.Logger) *Database {
 
 	// Do this once for each unique policy, and use the policy for the life of the program
 	// Policy creation/editing is not safe to use in multiple goroutines
-	bmp := bluemonday.UGCPolicy()
+	bmp := newHTMLSanitizerPolicy(config.HTMLAllowedTags)
 
 	var d = &Database{
 		// read environment variables and sets up database configuration values
 		config:              config,
 		htmlSanitizerPolicy: bmp,
+		instanceStatsCache:  newInstanceStatsCache(),
 		logger:              logger,
 	}
 
@@ -0,0 +1,118 @@
+package db
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"go.uber.org/zap"
+)
+
+// RequestEmailChange stores the users pending new email replacing any earlier pending change,
+// the users email is unchanged until confirmed, returns the user and the confirmation ID
+func (d *Database) RequestEmailChange(UserID string, NewEmail string) (*model.User, string, error) {
+	var ChangeID string
+	User := &model.User{Id: UserID}
+
+	if err := d.db.QueryRow(
+		`SELECT name, COALESCE(email, '') FROM users WHERE id = $1 AND type != 'GUEST';`,
+		UserID,
+	).Scan(&User.Name, &User.Email); err != nil {
+		d.logger.Error("get email change user query error", zap.Error(err))
+		return nil, "", errors.New("USER_NOT_FOUND")
+	}
+
+	if strings.EqualFold(User.Email, NewEmail) {
+		return nil, "", errors.New("EMAIL_UNCHANGED")
+	}
+
+	if err := d.db.QueryRow(
+		`INSERT INTO user_email_change (user_id, email) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET change_id = gen_random_uuid(), email = EXCLUDED.email, created_date = NOW()
+		RETURNING change_id;`,
+		UserID,
+		strings.ToLower(NewEmail),
+	).Scan(&ChangeID); err != nil {
+		d.logger.Error("insert user email change query error", zap.Error(err))
+		return nil, "", errors.New("unable to request email change")
+	}
+
+	if err := d.setTokenExpiry(TokenTypeEmailChange, ChangeID); err != nil {
+		return nil, "", errors.New("unable to request email change")
+	}
+
+	return User, ChangeID, nil
+}
+
+// ConfirmEmailChange swaps the users email for the pending one, the new email is verified as
+// the confirmation link was sent to it, returns the updated user and their previous email
+func (d *Database) ConfirmEmailChange(ChangeID string) (*model.User, string, error) {
+	if err := d.checkTokenExpiry(TokenTypeEmailChange, ChangeID); err != nil {
+		return nil, "", err
+	}
+
+	var UserID string
+	var NewEmail string
+	if err := d.db.QueryRow(
+		`SELECT user_id, email FROM user_email_change WHERE change_id = $1;`,
+		ChangeID,
+	).Scan(&UserID, &NewEmail); err != nil {
+		d.logger.Error("get user email change query error", zap.Error(err))
+		return nil, "", errors.New("TOKEN_NOT_FOUND")
+	}
+
+	// the email may have been taken since the change was requested
+	if err := d.CheckEmailAvailable(NewEmail, UserID); err != nil {
+		return nil, "", err
+	}
+
+	User, err := d.GetUser(UserID)
+	if err != nil {
+		return nil, "", err
+	}
+	OldEmail := User.Email
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		d.logger.Error("confirm email change transaction error", zap.Error(err))
+		return nil, "", errors.New("unable to confirm email change")
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		`UPDATE users SET email = $2, verified = true, updated_date = NOW() WHERE id = $1;`,
+		UserID,
+		NewEmail,
+	); err != nil {
+		d.logger.Error("update user email query error", zap.Error(err))
+		return nil, "", errors.New("unable to confirm email change")
+	}
+
+	// outstanding verify and reset links were sent to the previous email
+	for _, query := range []string{
+		`DELETE FROM user_email_change WHERE user_id = $1;`,
+		`DELETE FROM user_verify WHERE user_id = $1;`,
+		`DELETE FROM user_reset WHERE user_id = $1;`,
+	} {
+		if _, err := tx.Exec(query, UserID); err != nil {
+			d.logger.Error("confirm email change cleanup query error", zap.Error(err))
+			return nil, "", errors.New("unable to confirm email change")
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		d.logger.Error("confirm email change commit error", zap.Error(err))
+		return nil, "", errors.New("unable to confirm email change")
+	}
+
+	// confirming the change proves owning the new email, accepting its team invites
+	if err := d.acceptTeamUserInvites(UserID); err != nil {
+		d.logger.Error("confirm email change accept team invites error", zap.Error(err))
+	}
+
+	User.Email = NewEmail
+	User.Verified = true
+	User.GravatarHash = createGravatarHash(NewEmail)
+
+	return User, OldEmail, nil
+}
@@ -0,0 +1,55 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"go.uber.org/zap"
+)
+
+// DeferEmail stores a non-urgent email to be sent once SendAt has passed
+func (d *Database) DeferEmail(UserName string, UserEmail string, Subject string, Body string, SendAt time.Time) error {
+	if _, err := d.db.Exec(
+		`INSERT INTO email_deferred (user_name, user_email, subject, body, send_at) VALUES ($1, $2, $3, $4, $5);`,
+		UserName,
+		UserEmail,
+		Subject,
+		Body,
+		SendAt,
+	); err != nil {
+		d.logger.Error("defer email query error", zap.Error(err))
+		return errors.New("unable to defer email")
+	}
+
+	return nil
+}
+
+// ClaimDueEmails removes and returns up to Limit deferred emails whose send time has passed,
+// locked rows are skipped so multiple instances never claim the same email
+func (d *Database) ClaimDueEmails(Limit int) ([]*model.DeferredEmail, error) {
+	var Emails = make([]*model.DeferredEmail, 0)
+
+	rows, err := d.db.Query(
+		`DELETE FROM email_deferred WHERE id IN (
+			SELECT id FROM email_deferred WHERE send_at <= NOW() ORDER BY send_at LIMIT $1 FOR UPDATE SKIP LOCKED
+		) RETURNING id, user_name, user_email, subject, body, send_at;`,
+		Limit,
+	)
+	if err != nil {
+		d.logger.Error("claim due emails query error", zap.Error(err))
+		return nil, errors.New("unable to get deferred emails")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e model.DeferredEmail
+		if err := rows.Scan(&e.Id, &e.UserName, &e.UserEmail, &e.Subject, &e.Body, &e.SendAt); err != nil {
+			d.logger.Error("claim due emails query scan error", zap.Error(err))
+		} else {
+			Emails = append(Emails, &e)
+		}
+	}
+
+	return Emails, nil
+}
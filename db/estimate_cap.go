@@ -0,0 +1,108 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"go.uber.org/zap"
+)
+
+// battleEstimateCapQuery selects the battles estimate cap, its own cap overrides the lowest cap of its teams
+const battleEstimateCapQuery = `COALESCE(b.max_estimate, (
+		SELECT MIN(t.max_estimate) FROM team_battle tb JOIN team t ON t.id = tb.team_id WHERE tb.battle_id = b.id
+	))::FLOAT`
+
+// estimateExceedsCap whether the vote is a numeric estimate above the cap, non numeric cards e.g. ? are never capped
+func estimateExceedsCap(VoteValue string, Cap *float64) bool {
+	if Cap == nil {
+		return false
+	}
+
+	Estimate, ok := parsePlanPoints(VoteValue)
+
+	return ok && Estimate > *Cap
+}
+
+// validateEstimateCap makes sure the cap is either unset or a positive estimate
+func validateEstimateCap(Cap *float64) error {
+	if Cap != nil && *Cap <= 0 {
+		return errors.New("INVALID_ESTIMATE_CAP")
+	}
+
+	return nil
+}
+
+// nullFloatPtr converts the nullable float to a pointer, nil when null
+func nullFloatPtr(v sql.NullFloat64) *float64 {
+	if !v.Valid {
+		return nil
+	}
+
+	return &v.Float64
+}
+
+// getBattleEstimateCap gets the battles effective estimate cap, nil when there is none
+func (d *Database) getBattleEstimateCap(BattleID string) (*float64, error) {
+	var Cap sql.NullFloat64
+	if err := d.db.QueryRow(
+		`SELECT `+battleEstimateCapQuery+` FROM battles b WHERE b.id = $1;`,
+		BattleID,
+	).Scan(&Cap); err != nil {
+		d.logger.Error("get battle estimate cap query error", zap.Error(err))
+		return nil, errors.New("BATTLE_NOT_FOUND")
+	}
+
+	return nullFloatPtr(Cap), nil
+}
+
+// SetBattleEstimateCap sets the battles own estimate cap, nil falls back to its teams policy,
+// returns the battles effective cap
+func (d *Database) SetBattleEstimateCap(BattleID string, Cap *float64) (*float64, error) {
+	if err := validateEstimateCap(Cap); err != nil {
+		return nil, err
+	}
+
+	if _, err := d.db.Exec(
+		`UPDATE battles SET max_estimate = $2, updated_date = NOW() WHERE id = $1;`,
+		BattleID,
+		Cap,
+	); err != nil {
+		d.logger.Error("update battle max_estimate query error", zap.Error(err))
+		return nil, errors.New("unable to set battle estimate cap")
+	}
+
+	return d.getBattleEstimateCap(BattleID)
+}
+
+// GetTeamEstimationPolicy gets the teams estimation policy
+func (d *Database) GetTeamEstimationPolicy(TeamID string) (*model.TeamEstimationPolicy, error) {
+	var MaxEstimate sql.NullFloat64
+	if err := d.db.QueryRow(
+		`SELECT max_estimate::FLOAT FROM team WHERE id = $1;`,
+		TeamID,
+	).Scan(&MaxEstimate); err != nil {
+		d.logger.Error("get team estimation policy query error", zap.Error(err))
+		return nil, errors.New("TEAM_NOT_FOUND")
+	}
+
+	return &model.TeamEstimationPolicy{MaxEstimate: nullFloatPtr(MaxEstimate)}, nil
+}
+
+// UpdateTeamEstimationPolicy sets the teams estimation policy
+func (d *Database) UpdateTeamEstimationPolicy(TeamID string, Policy *model.TeamEstimationPolicy) error {
+	if err := validateEstimateCap(Policy.MaxEstimate); err != nil {
+		return err
+	}
+
+	if _, err := d.db.Exec(
+		`UPDATE team SET max_estimate = $2, updated_date = NOW() WHERE id = $1;`,
+		TeamID,
+		Policy.MaxEstimate,
+	); err != nil {
+		d.logger.Error("update team estimation policy query error", zap.Error(err))
+		return errors.New("unable to update team estimation policy")
+	}
+
+	return nil
+}
@@ -0,0 +1,154 @@
+package db
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"go.uber.org/zap"
+)
+
+// estimationSample a plans estimate and its recorded actual effort
+type estimationSample struct {
+	Estimate float64
+	Actual   float64
+	Date     time.Time
+}
+
+// parsePlanPoints parses a numeric plan estimate including fractions e.g. 1/2, false for non-numeric points e.g. ?
+func parsePlanPoints(Points string) (float64, bool) {
+	Points = strings.TrimSpace(Points)
+	if parts := strings.Split(Points, "/"); len(parts) == 2 {
+		n, nErr := strconv.ParseFloat(parts[0], 64)
+		d, dErr := strconv.ParseFloat(parts[1], 64)
+		if nErr != nil || dErr != nil || d == 0 {
+			return 0, false
+		}
+		return n / d, true
+	}
+
+	v, err := strconv.ParseFloat(Points, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, false
+	}
+
+	return v, true
+}
+
+// computeEstimationAccuracy computes the variance metrics and monthly over/under-estimation trend of the samples
+func computeEstimationAccuracy(Samples []estimationSample) *model.EstimationAccuracy {
+	var accuracy = &model.EstimationAccuracy{
+		Trend: make([]*model.EstimationAccuracyPeriod, 0),
+	}
+	var periods = make(map[string]*model.EstimationAccuracyPeriod)
+	var percentageSamples int
+
+	for _, s := range Samples {
+		Variance := s.Actual - s.Estimate
+
+		accuracy.SampleCount++
+		accuracy.MeanVariance += Variance
+		accuracy.MeanAbsoluteVariance += math.Abs(Variance)
+		if s.Actual != 0 {
+			accuracy.MeanAbsolutePercentage += math.Abs(Variance/s.Actual) * 100
+			percentageSamples++
+		}
+
+		Period := s.Date.UTC().Format("2006-01")
+		p, ok := periods[Period]
+		if !ok {
+			p = &model.EstimationAccuracyPeriod{Period: Period}
+			periods[Period] = p
+			accuracy.Trend = append(accuracy.Trend, p)
+		}
+		p.SampleCount++
+		p.MeanVariance += Variance
+
+		switch {
+		case Variance < 0:
+			accuracy.OverEstimated++
+			p.OverEstimated++
+		case Variance > 0:
+			accuracy.UnderEstimated++
+			p.UnderEstimated++
+		default:
+			accuracy.Accurate++
+		}
+	}
+
+	if accuracy.SampleCount > 0 {
+		accuracy.MeanVariance /= float64(accuracy.SampleCount)
+		accuracy.MeanAbsoluteVariance /= float64(accuracy.SampleCount)
+	}
+	if percentageSamples > 0 {
+		accuracy.MeanAbsolutePercentage /= float64(percentageSamples)
+	}
+	for _, p := range accuracy.Trend {
+		p.MeanVariance /= float64(p.SampleCount)
+	}
+
+	return accuracy
+}
+
+// RecordPlanActual records the actual points/hours of a plan after the sprint, nil clears it
+func (d *Database) RecordPlanActual(BattleID string, PlanID string, ActualPoints *float64) error {
+	if ActualPoints != nil && (*ActualPoints < 0 || math.IsNaN(*ActualPoints) || math.IsInf(*ActualPoints, 0)) {
+		return errors.New("INVALID_ACTUAL_POINTS")
+	}
+
+	res, err := d.db.Exec(
+		`UPDATE plans SET actual_points = $3,
+			actual_recorded_date = CASE WHEN $3::DOUBLE PRECISION IS NULL THEN NULL ELSE NOW() END,
+			updated_date = NOW()
+		WHERE id = $2 AND battle_id = $1;`,
+		BattleID,
+		PlanID,
+		ActualPoints,
+	)
+	if err != nil {
+		d.logger.Error("record plan actual query error", zap.Error(err))
+		return errors.New("unable to record plan actual")
+	}
+	if updated, _ := res.RowsAffected(); updated == 0 {
+		return errors.New("PLAN_NOT_FOUND")
+	}
+
+	return nil
+}
+
+// GetTeamEstimationAccuracy gets the estimation accuracy of the teams battles plans that have a recorded actual,
+// plans without an actual or with a non-numeric estimate aren't included
+func (d *Database) GetTeamEstimationAccuracy(TeamID string) (*model.EstimationAccuracy, error) {
+	rows, err := d.db.Query(
+		`SELECT p.points, p.actual_points, p.created_date
+		FROM plans p
+		JOIN team_battle tb ON tb.battle_id = p.battle_id
+		WHERE tb.team_id = $1 AND p.actual_points IS NOT NULL AND p.points <> ''
+		ORDER BY p.created_date;`,
+		TeamID,
+	)
+	if err != nil {
+		d.logger.Error("get team estimation accuracy query error", zap.Error(err))
+		return nil, errors.New("unable to get estimation accuracy")
+	}
+	defer rows.Close()
+
+	var Samples []estimationSample
+	for rows.Next() {
+		var Points string
+		var s estimationSample
+		if err := rows.Scan(&Points, &s.Actual, &s.Date); err != nil {
+			d.logger.Error("get team estimation accuracy query scan error", zap.Error(err))
+			continue
+		}
+		if Estimate, ok := parsePlanPoints(Points); ok {
+			s.Estimate = Estimate
+			Samples = append(Samples, s)
+		}
+	}
+
+	return computeEstimationAccuracy(Samples), nil
+}
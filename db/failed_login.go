@@ -0,0 +1,73 @@
+package db
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// RecordFailedLogin records a failed login attempt for the email, the email is stored hashed
+func (d *Database) RecordFailedLogin(Email string) error {
+	if _, err := d.db.Exec(
+		`INSERT INTO user_failed_login (email_hash) VALUES ($1);`,
+		hashString(strings.ToLower(Email)),
+	); err != nil {
+		d.logger.Error("insert failed login query error", zap.Error(err))
+		return errors.New("unable to record failed login")
+	}
+
+	return nil
+}
+
+// GetFailedLoginCount gets the number of failed login attempts for the email within the window
+func (d *Database) GetFailedLoginCount(Email string, Window time.Duration) (int, error) {
+	var Count int
+	if err := d.db.QueryRow(
+		`SELECT COUNT(*) FROM user_failed_login WHERE email_hash = $1 AND created_date > NOW() - make_interval(secs => $2);`,
+		hashString(strings.ToLower(Email)),
+		Window.Seconds(),
+	).Scan(&Count); err != nil {
+		d.logger.Error("get failed login count query error", zap.Error(err))
+		return 0, errors.New("unable to get failed login count")
+	}
+
+	return Count, nil
+}
+
+// ResetFailedLogins clears the failed login attempts for the email after a successful login
+func (d *Database) ResetFailedLogins(Email string) error {
+	if _, err := d.db.Exec(
+		`DELETE FROM user_failed_login WHERE email_hash = $1;`,
+		hashString(strings.ToLower(Email)),
+	); err != nil {
+		d.logger.Error("delete failed logins query error", zap.Error(err))
+		return errors.New("unable to reset failed logins")
+	}
+
+	return nil
+}
+
+// CleanFailedLogins deletes failed login attempts older than the window
+func (d *Database) CleanFailedLogins(Window time.Duration) error {
+	if _, err := d.db.Exec(
+		`DELETE FROM user_failed_login WHERE created_date <= NOW() - make_interval(secs => $1);`,
+		Window.Seconds(),
+	); err != nil {
+		d.logger.Error("clean failed logins query error", zap.Error(err))
+		return errors.New("unable to clean failed logins")
+	}
+
+	return nil
+}
+
+// FailedLoginSweeper periodically deletes failed login attempts outside the window, intended to be run as a goroutine
+func (d *Database) FailedLoginSweeper(Interval time.Duration, Window time.Duration) {
+	ticker := time.NewTicker(Interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		_ = d.CleanFailedLogins(Window)
+	}
+}
@@ -0,0 +1,159 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"go.uber.org/zap"
+)
+
+// GetFeatureFlags gets the list of feature flags
+func (d *Database) GetFeatureFlags() ([]*model.FeatureFlag, error) {
+	var flags = make([]*model.FeatureFlag, 0)
+
+	rows, err := d.db.Query(
+		`SELECT id, name, COALESCE(description, ''), enabled, roles, user_ids, percentage, created_date, updated_date
+		FROM feature_flag ORDER BY name;`,
+	)
+	if err != nil {
+		d.logger.Error("get feature flags query error", zap.Error(err))
+		return nil, errors.New("unable to get feature flags")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var f model.FeatureFlag
+		var roles string
+		var userIds string
+
+		if err := rows.Scan(
+			&f.Id,
+			&f.Name,
+			&f.Description,
+			&f.Enabled,
+			&roles,
+			&userIds,
+			&f.Percentage,
+			&f.CreatedDate,
+			&f.UpdatedDate,
+		); err != nil {
+			d.logger.Error("get feature flags query scan error", zap.Error(err))
+			return nil, errors.New("unable to get feature flags")
+		}
+		_ = json.Unmarshal([]byte(roles), &f.Roles)
+		_ = json.Unmarshal([]byte(userIds), &f.UserIds)
+
+		flags = append(flags, &f)
+	}
+
+	return flags, nil
+}
+
+// CreateFeatureFlag creates a feature flag
+func (d *Database) CreateFeatureFlag(Flag *model.FeatureFlag) error {
+	roles, _ := json.Marshal(nonNilStrings(Flag.Roles))
+	userIds, _ := json.Marshal(nonNilStrings(Flag.UserIds))
+
+	if _, err := d.db.Exec(
+		`INSERT INTO feature_flag (name, description, enabled, roles, user_ids, percentage)
+		VALUES ($1, $2, $3, $4, $5, $6);`,
+		Flag.Name,
+		Flag.Description,
+		Flag.Enabled,
+		string(roles),
+		string(userIds),
+		Flag.Percentage,
+	); err != nil {
+		d.logger.Error("insert feature flag error", zap.Error(err))
+		return errors.New("error attempting to add new feature flag")
+	}
+
+	return nil
+}
+
+// UpdateFeatureFlag updates a feature flag
+func (d *Database) UpdateFeatureFlag(Flag *model.FeatureFlag) error {
+	roles, _ := json.Marshal(nonNilStrings(Flag.Roles))
+	userIds, _ := json.Marshal(nonNilStrings(Flag.UserIds))
+
+	if _, err := d.db.Exec(
+		`UPDATE feature_flag
+		SET name = $2, description = $3, enabled = $4, roles = $5, user_ids = $6, percentage = $7, updated_date = NOW()
+		WHERE id = $1;`,
+		Flag.Id,
+		Flag.Name,
+		Flag.Description,
+		Flag.Enabled,
+		string(roles),
+		string(userIds),
+		Flag.Percentage,
+	); err != nil {
+		d.logger.Error("update feature flag error", zap.Error(err))
+		return errors.New("error attempting to update feature flag")
+	}
+
+	return nil
+}
+
+// DeleteFeatureFlag deletes a feature flag
+func (d *Database) DeleteFeatureFlag(FlagID string) error {
+	if _, err := d.db.Exec(
+		`DELETE FROM feature_flag WHERE id = $1;`,
+		FlagID,
+	); err != nil {
+		d.logger.Error("delete feature flag error", zap.Error(err))
+		return errors.New("error attempting to delete feature flag")
+	}
+
+	return nil
+}
+
+// EvaluateFlags resolves the set of feature flags for the user
+func (d *Database) EvaluateFlags(User *model.User) (map[string]bool, error) {
+	flags, err := d.GetFeatureFlags()
+	if err != nil {
+		return nil, err
+	}
+
+	resolved := make(map[string]bool, len(flags))
+	for _, f := range flags {
+		resolved[f.Name] = evaluateFlag(f, User)
+	}
+
+	return resolved, nil
+}
+
+// evaluateFlag checks whether an enabled flag targets the user by role (user type), user id, or percentage rollout,
+// an enabled flag without targeting rules applies to all users
+func evaluateFlag(Flag *model.FeatureFlag, User *model.User) bool {
+	if !Flag.Enabled {
+		return false
+	}
+	if len(Flag.Roles) == 0 && len(Flag.UserIds) == 0 && Flag.Percentage == 0 {
+		return true
+	}
+	if contains(Flag.Roles, User.Type) || contains(Flag.UserIds, User.Id) {
+		return true
+	}
+
+	return flagBucket(Flag.Name, User.Id) < Flag.Percentage
+}
+
+// flagBucket deterministically places the user in a 0-99 bucket per flag so rollouts are stable
+func flagBucket(FlagName string, UserID string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(FlagName + ":" + UserID))
+
+	return int(h.Sum32() % 100)
+}
+
+// nonNilStrings returns an empty slice for nil so it's stored as an empty json array
+func nonNilStrings(s []string) []string {
+	if s == nil {
+		return make([]string, 0)
+	}
+
+	return s
+}
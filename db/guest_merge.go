@@ -0,0 +1,186 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// guestMergeAuthoredColumns the columns of what a user created, moved to the registered user as is
+var guestMergeAuthoredColumns = []struct {
+	table  string
+	column string
+}{
+	{"battles", "owner_id"},
+	{"battle_event", "user_id"},
+	{"battle_reopen", "user_id"},
+	{"plan_discussion", "user_id"},
+	{"battle_parking_lot", "user_id"},
+	{"battles", "note_taker_id"},
+	{"storyboard", "owner_id"},
+	{"storyboard_story_comment", "user_id"},
+	{"storyboard_revision", "user_id"},
+	{"storyboard_snapshot", "user_id"},
+	{"storyboard_template", "owner_id"},
+	{"retro", "owner_id"},
+	{"retro_item", "user_id"},
+}
+
+// guestMergeMemberships the user_id tables keyed per user, only rows the registered user doesn't already have are moved,
+// the rest are removed with the guest
+var guestMergeMemberships = []struct {
+	table string
+	keys  string
+}{
+	{"battles_leaders", "battle_id"},
+	{"battles_users", "battle_id"},
+	{"battle_tag", "battle_id, tag"},
+	{"plan_poll_response", "plan_id"},
+	{"plan_vote_delegation", "plan_id"},
+	{"plan_reveal_ready", "plan_id"},
+	{"storyboard_user", "storyboard_id"},
+	{"storyboard_tag", "storyboard_id, tag"},
+	{"retro_user", "retro_id"},
+	{"retro_group_vote", "retro_id, group_id"},
+	{"user_view", "name"},
+}
+
+// MergeGuestIntoUser moves the guests battle participation, votes, and storyboard and retro authorship
+// to the registered user then deletes the guest and its sessions
+func (d *Database) MergeGuestIntoUser(GuestID string, UserID string) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		d.logger.Error("merge guest transaction error", zap.Error(err))
+		return errors.New("unable to merge guest")
+	}
+	defer tx.Rollback()
+
+	var UserType string
+	if err := tx.QueryRow(
+		`SELECT type FROM users WHERE id = $1 AND deleted_at IS NULL;`,
+		UserID,
+	).Scan(&UserType); err != nil || UserType == "GUEST" {
+		return errors.New("USER_NOT_FOUND")
+	}
+
+	if err := d.mergeGuestIntoUser(tx, GuestID, UserID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		d.logger.Error("merge guest commit error", zap.Error(err))
+		return errors.New("unable to merge guest")
+	}
+
+	return nil
+}
+
+// mergeGuestIntoUser merges the guest into the user within the transaction
+func (d *Database) mergeGuestIntoUser(tx *sql.Tx, GuestID string, UserID string) error {
+	var GuestType string
+	if err := tx.QueryRow(
+		`SELECT type FROM users WHERE id = $1 FOR UPDATE;`,
+		GuestID,
+	).Scan(&GuestType); err != nil || GuestType != "GUEST" {
+		return errors.New("GUEST_NOT_FOUND")
+	}
+
+	for _, c := range guestMergeAuthoredColumns {
+		if _, err := tx.Exec(
+			fmt.Sprintf(`UPDATE %[1]s SET %[2]s = $2 WHERE %[2]s = $1;`, c.table, c.column),
+			GuestID,
+			UserID,
+		); err != nil {
+			d.logger.Error("merge guest query error", zap.String("table", c.table), zap.Error(err))
+			return errors.New("unable to merge guest")
+		}
+	}
+
+	// the guests delegations to the user would become delegations to themselves once moved
+	if _, err := tx.Exec(
+		`DELETE FROM plan_vote_delegation WHERE user_id = $1 AND delegate_id = $2;`,
+		GuestID,
+		UserID,
+	); err != nil {
+		d.logger.Error("merge guest delete delegations to user query error", zap.Error(err))
+		return errors.New("unable to merge guest")
+	}
+
+	for _, m := range guestMergeMemberships {
+		if _, err := tx.Exec(
+			fmt.Sprintf(
+				`UPDATE %[1]s SET user_id = $2 WHERE user_id = $1 AND (%[2]s) NOT IN (SELECT %[2]s FROM %[1]s WHERE user_id = $2);`,
+				m.table, m.keys,
+			),
+			GuestID,
+			UserID,
+		); err != nil {
+			d.logger.Error("merge guest query error", zap.String("table", m.table), zap.Error(err))
+			return errors.New("unable to merge guest")
+		}
+	}
+
+	// the guest's delegations to them follow, except where the user delegated to the guest
+	if _, err := tx.Exec(
+		`UPDATE plan_vote_delegation SET delegate_id = $2 WHERE delegate_id = $1 AND user_id != $2;`,
+		GuestID,
+		UserID,
+	); err != nil {
+		d.logger.Error("merge guest vote delegates query error", zap.Error(err))
+		return errors.New("unable to merge guest")
+	}
+
+	// votes are kept in the plans and plan vote history votes json keyed by the voters id
+	for _, table := range []string{"plans", "plan_vote_history"} {
+		if _, err := tx.Exec(
+			fmt.Sprintf(`UPDATE %s SET votes = (
+				SELECT jsonb_agg(
+					CASE WHEN v->>'warriorId' = $1 THEN jsonb_set(v, '{warriorId}', to_jsonb($2::TEXT)) ELSE v END
+				) FROM jsonb_array_elements(votes) v
+			)
+			WHERE votes @> jsonb_build_array(jsonb_build_object('warriorId', $1::TEXT))
+				AND NOT votes @> jsonb_build_array(jsonb_build_object('warriorId', $2::TEXT));`, table),
+			GuestID,
+			UserID,
+		); err != nil {
+			d.logger.Error("merge guest votes query error", zap.String("table", table), zap.Error(err))
+			return errors.New("unable to merge guest")
+		}
+		// the users own vote wins where both voted on the plan
+		if _, err := tx.Exec(
+			fmt.Sprintf(`UPDATE %s SET votes = (
+				SELECT COALESCE(jsonb_agg(v), '[]'::jsonb) FROM jsonb_array_elements(votes) v WHERE v->>'warriorId' != $1
+			)
+			WHERE votes @> jsonb_build_array(jsonb_build_object('warriorId', $1::TEXT));`, table),
+			GuestID,
+		); err != nil {
+			d.logger.Error("merge guest duplicate votes query error", zap.String("table", table), zap.Error(err))
+			return errors.New("unable to merge guest")
+		}
+	}
+
+	// the guests uploads are still stored so their usage moves to the user to keep the instance total accurate
+	if _, err := tx.Exec(
+		`INSERT INTO user_storage (user_id, used_bytes)
+		SELECT $2, used_bytes FROM user_storage WHERE user_id = $1
+		ON CONFLICT (user_id) DO UPDATE SET used_bytes = user_storage.used_bytes + EXCLUDED.used_bytes, updated_date = NOW();`,
+		GuestID,
+		UserID,
+	); err != nil {
+		d.logger.Error("merge guest storage query error", zap.Error(err))
+		return errors.New("unable to merge guest")
+	}
+
+	if _, err := tx.Exec(`DELETE FROM user_session WHERE user_id = $1;`, GuestID); err != nil {
+		d.logger.Error("merge guest delete sessions query error", zap.Error(err))
+		return errors.New("unable to merge guest")
+	}
+	if _, err := tx.Exec(`DELETE FROM users WHERE id = $1;`, GuestID); err != nil {
+		d.logger.Error("merge guest delete guest query error", zap.Error(err))
+		return errors.New("unable to merge guest")
+	}
+
+	return nil
+}
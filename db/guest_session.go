@@ -0,0 +1,73 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// GetGuestSessionRemaining gets how long until the guest exceeds the max session lifetime in hours, negative once expired,
+// computed in the database as created_date has no timezone
+func (d *Database) GetGuestSessionRemaining(UserID string, MaxLifetime int) (time.Duration, error) {
+	var Seconds float64
+
+	if err := d.db.QueryRow(
+		`SELECT EXTRACT(EPOCH FROM (created_date + make_interval(hours => $2)) - NOW())
+		FROM users WHERE id = $1 AND type = 'GUEST';`,
+		UserID,
+		MaxLifetime,
+	).Scan(&Seconds); err != nil {
+		return 0, errors.New("GUEST_USER_NOT_FOUND")
+	}
+
+	return time.Duration(Seconds * float64(time.Second)), nil
+}
+
+// PurgeExpiredGuests deletes the guests that have exceeded the max session lifetime in hours,
+// calling OnPurged with each purged guests ID and avatar so their uploaded avatar can be removed
+func (d *Database) PurgeExpiredGuests(MaxLifetime int, OnPurged func(UserID string, Avatar string)) error {
+	rows, err := d.db.Query(
+		`SELECT id, COALESCE(avatar, '') FROM users
+		WHERE type = 'GUEST' AND created_date + make_interval(hours => $1) <= NOW();`,
+		MaxLifetime,
+	)
+	if err != nil {
+		d.logger.Error("get expired guests query error", zap.Error(err))
+		return errors.New("unable to purge expired guests")
+	}
+
+	var Users [][2]string
+	for rows.Next() {
+		var UserID, Avatar string
+		if err := rows.Scan(&UserID, &Avatar); err != nil {
+			d.logger.Error("get expired guests query scan error", zap.Error(err))
+		} else {
+			Users = append(Users, [2]string{UserID, Avatar})
+		}
+	}
+	rows.Close()
+
+	for _, u := range Users {
+		if err := d.DeleteUser(u[0]); err != nil {
+			d.logger.Error("purge expired guest error", zap.String("user_id", u[0]), zap.Error(err))
+			continue
+		}
+		if OnPurged != nil {
+			OnPurged(u[0], u[1])
+		}
+	}
+
+	return nil
+}
+
+// ExpiredGuestSweeper periodically purges guests past the max session lifetime in hours,
+// so guests that never make another request are still removed
+func (d *Database) ExpiredGuestSweeper(Interval time.Duration, MaxLifetime int, OnPurged func(UserID string, Avatar string)) {
+	ticker := time.NewTicker(Interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		_ = d.PurgeExpiredGuests(MaxLifetime, OnPurged)
+	}
+}
@@ -0,0 +1,126 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"go.uber.org/zap"
+)
+
+// jiraInstanceOwner the condition for the jira instance owned by the user ($1) or team ($2)
+const jiraInstanceOwner = `((NULLIF($2::TEXT, '') IS NULL AND user_id = NULLIF($1::TEXT, '')::UUID) OR team_id = NULLIF($2, '')::UUID)`
+
+// SaveJiraInstance creates or replaces the Jira connection of the user or team (when TeamID is set),
+// the API token is encrypted at rest
+func (d *Database) SaveJiraInstance(UserID string, TeamID string, Host string, ClientMail string, AccessToken string) (*model.JiraInstance, error) {
+	var j model.JiraInstance
+	var OwnerUserID, OwnerTeamID string
+	if TeamID != "" {
+		OwnerTeamID = TeamID
+	} else {
+		OwnerUserID = UserID
+	}
+
+	EncryptedToken, err := encrypt(AccessToken, d.config.AESHashkey)
+	if err != nil {
+		d.logger.Error("encrypt jira access token error", zap.Error(err))
+		return nil, errors.New("unable to save jira instance")
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		d.logger.Error("save jira instance transaction error", zap.Error(err))
+		return nil, errors.New("unable to save jira instance")
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM jira_instance WHERE `+jiraInstanceOwner+`;`, OwnerUserID, OwnerTeamID); err != nil {
+		d.logger.Error("replace jira instance query error", zap.Error(err))
+		return nil, errors.New("unable to save jira instance")
+	}
+
+	if err := tx.QueryRow(
+		`INSERT INTO jira_instance (user_id, team_id, host, client_mail, access_token)
+		VALUES (NULLIF($1, '')::UUID, NULLIF($2, '')::UUID, $3, $4, $5)
+		RETURNING id, COALESCE(user_id::TEXT, ''), COALESCE(team_id::TEXT, ''), host, client_mail, created_date, updated_date;`,
+		OwnerUserID,
+		OwnerTeamID,
+		Host,
+		ClientMail,
+		EncryptedToken,
+	).Scan(&j.Id, &j.UserId, &j.TeamId, &j.Host, &j.ClientMail, &j.CreatedDate, &j.UpdatedDate); err != nil {
+		d.logger.Error("insert jira instance query error", zap.Error(err))
+		return nil, errors.New("unable to save jira instance")
+	}
+
+	if err := tx.Commit(); err != nil {
+		d.logger.Error("save jira instance commit error", zap.Error(err))
+		return nil, errors.New("unable to save jira instance")
+	}
+
+	return &j, nil
+}
+
+// GetJiraInstance gets the Jira connection of the user or team (when TeamID is set) without its API token
+func (d *Database) GetJiraInstance(UserID string, TeamID string) (*model.JiraInstance, error) {
+	var j model.JiraInstance
+
+	err := d.db.QueryRow(
+		`SELECT id, COALESCE(user_id::TEXT, ''), COALESCE(team_id::TEXT, ''), host, client_mail, created_date, updated_date
+		FROM jira_instance WHERE `+jiraInstanceOwner+`;`,
+		UserID,
+		TeamID,
+	).Scan(&j.Id, &j.UserId, &j.TeamId, &j.Host, &j.ClientMail, &j.CreatedDate, &j.UpdatedDate)
+	if err == sql.ErrNoRows {
+		return nil, errors.New("JIRA_INSTANCE_NOT_FOUND")
+	}
+	if err != nil {
+		d.logger.Error("get jira instance query error", zap.Error(err))
+		return nil, errors.New("unable to get jira instance")
+	}
+
+	return &j, nil
+}
+
+// GetJiraInstanceToken gets the decrypted API token of the user or teams (when TeamID is set) Jira connection
+func (d *Database) GetJiraInstanceToken(UserID string, TeamID string) (*model.JiraInstance, string, error) {
+	var j model.JiraInstance
+	var EncryptedToken string
+
+	err := d.db.QueryRow(
+		`SELECT id, COALESCE(user_id::TEXT, ''), COALESCE(team_id::TEXT, ''), host, client_mail, access_token, created_date, updated_date
+		FROM jira_instance WHERE `+jiraInstanceOwner+`;`,
+		UserID,
+		TeamID,
+	).Scan(&j.Id, &j.UserId, &j.TeamId, &j.Host, &j.ClientMail, &EncryptedToken, &j.CreatedDate, &j.UpdatedDate)
+	if err == sql.ErrNoRows {
+		return nil, "", errors.New("JIRA_INSTANCE_NOT_FOUND")
+	}
+	if err != nil {
+		d.logger.Error("get jira instance token query error", zap.Error(err))
+		return nil, "", errors.New("unable to get jira instance")
+	}
+
+	AccessToken, err := decrypt(EncryptedToken, d.config.AESHashkey)
+	if err != nil {
+		d.logger.Error("decrypt jira access token error", zap.Error(err))
+		return nil, "", errors.New("unable to get jira instance")
+	}
+
+	return &j, AccessToken, nil
+}
+
+// DeleteJiraInstance removes the Jira connection of the user or team (when TeamID is set)
+func (d *Database) DeleteJiraInstance(UserID string, TeamID string) error {
+	res, err := d.db.Exec(`DELETE FROM jira_instance WHERE `+jiraInstanceOwner+`;`, UserID, TeamID)
+	if err != nil {
+		d.logger.Error("delete jira instance query error", zap.Error(err))
+		return errors.New("unable to delete jira instance")
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return errors.New("JIRA_INSTANCE_NOT_FOUND")
+	}
+
+	return nil
+}
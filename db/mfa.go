@@ -0,0 +1,435 @@
+package db
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"go.uber.org/zap"
+)
+
+const (
+	// totpStep the TOTP time step
+	totpStep = 30 * time.Second
+	// mfaRecoveryCodeCount the number of recovery codes generated when enabling MFA
+	mfaRecoveryCodeCount = 10
+	// maxMFAAttempts the max passcode attempts per pre-auth token before it's discarded
+	maxMFAAttempts = 5
+	// maxUserMFAFailures the max failed passcodes per user across pre-auth tokens and MFA changes before they're locked out
+	maxUserMFAFailures = 10
+	// mfaLockoutDuration how long a user is locked out of MFA after too many failed passcodes
+	mfaLockoutDuration = 15 * time.Minute
+)
+
+// mfaEncoding the unpadded base32 encoding of TOTP secrets used by authenticator apps
+var mfaEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewMFASecret generates a random base32 TOTP secret
+func NewMFASecret() (string, error) {
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+
+	return mfaEncoding.EncodeToString(secret), nil
+}
+
+// totpCode generates the 6 digit RFC 6238 TOTP code of the secret for the time step
+func totpCode(Secret string, Step int64) (string, error) {
+	key, err := mfaEncoding.DecodeString(strings.ToUpper(Secret))
+	if err != nil {
+		return "", err
+	}
+
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(Step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%06d", code%1000000), nil
+}
+
+// validateTOTP checks the passcode against the secret within a ±1 time step window, steps at or before
+// LastStep are rejected so a code can't be replayed, returns the matched step
+func validateTOTP(Secret string, Passcode string, Now time.Time, LastStep int64) (int64, bool) {
+	current := Now.Unix() / int64(totpStep.Seconds())
+
+	for _, Step := range []int64{current - 1, current, current + 1} {
+		if Step <= LastStep {
+			continue
+		}
+		if code, err := totpCode(Secret, Step); err == nil && hmac.Equal([]byte(code), []byte(Passcode)) {
+			return Step, true
+		}
+	}
+
+	return 0, false
+}
+
+// generateMFARecoveryCodes generates the single use recovery codes e.g. abcde-fghij
+func generateMFARecoveryCodes() ([]string, error) {
+	codes := make([]string, 0, mfaRecoveryCodeCount)
+
+	for i := 0; i < mfaRecoveryCodeCount; i++ {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(mfaEncoding.EncodeToString(b))[:10]
+		codes = append(codes, code[:5]+"-"+code[5:])
+	}
+
+	return codes, nil
+}
+
+// normalizeMFARecoveryCode normalizes a recovery code as entered for hashing
+func normalizeMFARecoveryCode(Code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(Code), " ", ""))
+}
+
+// UserEnableMFA enables TOTP two-factor authentication once the passcode confirms the
+// authenticator app has the secret, returns the single use recovery codes (only shown once)
+func (d *Database) UserEnableMFA(UserID string, Secret string, Passcode string) ([]string, error) {
+	Step, valid := validateTOTP(Secret, Passcode, time.Now(), 0)
+	if !valid {
+		return nil, errors.New("INVALID_MFA_PASSCODE")
+	}
+
+	EncryptedSecret, err := encrypt(Secret, d.config.AESHashkey)
+	if err != nil {
+		d.logger.Error("encrypt mfa secret error", zap.Error(err))
+		return nil, errors.New("unable to enable mfa")
+	}
+
+	RecoveryCodes, err := generateMFARecoveryCodes()
+	if err != nil {
+		d.logger.Error("generate mfa recovery codes error", zap.Error(err))
+		return nil, errors.New("unable to enable mfa")
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		d.logger.Error("enable mfa transaction error", zap.Error(err))
+		return nil, errors.New("unable to enable mfa")
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		`UPDATE users SET mfa_enabled = true, mfa_secret = $2, mfa_last_step = $3, updated_date = NOW() WHERE id = $1;`,
+		UserID,
+		EncryptedSecret,
+		Step,
+	); err != nil {
+		d.logger.Error("enable mfa query error", zap.Error(err))
+		return nil, errors.New("unable to enable mfa")
+	}
+
+	if _, err := tx.Exec(`DELETE FROM user_mfa_recovery_code WHERE user_id = $1;`, UserID); err != nil {
+		d.logger.Error("delete mfa recovery codes query error", zap.Error(err))
+		return nil, errors.New("unable to enable mfa")
+	}
+
+	for _, Code := range RecoveryCodes {
+		if _, err := tx.Exec(
+			`INSERT INTO user_mfa_recovery_code (user_id, code_hash) VALUES ($1, $2);`,
+			UserID,
+			hashString(normalizeMFARecoveryCode(Code)),
+		); err != nil {
+			d.logger.Error("insert mfa recovery code query error", zap.Error(err))
+			return nil, errors.New("unable to enable mfa")
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		d.logger.Error("enable mfa commit error", zap.Error(err))
+		return nil, errors.New("unable to enable mfa")
+	}
+
+	return RecoveryCodes, nil
+}
+
+// UserDisableMFA disables two-factor authentication removing the secret and recovery codes
+func (d *Database) UserDisableMFA(UserID string) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		d.logger.Error("disable mfa transaction error", zap.Error(err))
+		return errors.New("unable to disable mfa")
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		`UPDATE users SET mfa_enabled = false, mfa_secret = NULL, mfa_last_step = NULL, updated_date = NOW() WHERE id = $1;`,
+		UserID,
+	); err != nil {
+		d.logger.Error("disable mfa query error", zap.Error(err))
+		return errors.New("unable to disable mfa")
+	}
+
+	if _, err := tx.Exec(`DELETE FROM user_mfa_recovery_code WHERE user_id = $1;`, UserID); err != nil {
+		d.logger.Error("delete mfa recovery codes query error", zap.Error(err))
+		return errors.New("unable to disable mfa")
+	}
+
+	if err := tx.Commit(); err != nil {
+		d.logger.Error("disable mfa commit error", zap.Error(err))
+		return errors.New("unable to disable mfa")
+	}
+
+	return nil
+}
+
+// ConfirmMFAChange re-authenticates the user before their MFA is enabled or disabled, with their password or
+// when MFA is already enabled a current passcode or an unused recovery code (which is consumed),
+// failed passcodes count towards the users MFA lockout
+func (d *Database) ConfirmMFAChange(UserID string, Password string, Passcode string) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		d.logger.Error("confirm mfa change transaction error", zap.Error(err))
+		return errors.New("unable to confirm mfa change")
+	}
+	defer tx.Rollback()
+
+	var PassHash string
+	var MFAEnabled bool
+	var EncryptedSecret string
+	var LastStep sql.NullInt64
+	var Locked bool
+	if err := tx.QueryRow(
+		`SELECT COALESCE(password, ''), mfa_enabled, COALESCE(mfa_secret, ''), mfa_last_step,
+			COALESCE(mfa_locked_until > NOW(), false)
+		FROM users WHERE id = $1 FOR UPDATE;`,
+		UserID,
+	).Scan(&PassHash, &MFAEnabled, &EncryptedSecret, &LastStep, &Locked); err != nil {
+		d.logger.Error("confirm mfa change get user query error", zap.Error(err))
+		return errors.New("USER_NOT_FOUND")
+	}
+
+	if Password != "" && PassHash != "" && comparePasswords(PassHash, Password) {
+		return nil
+	}
+
+	Passcode = strings.TrimSpace(Passcode)
+	if !MFAEnabled || Passcode == "" {
+		return errors.New("INVALID_MFA_REAUTH")
+	}
+	if Locked {
+		return errors.New("MFA_LOCKED")
+	}
+
+	Secret, err := decrypt(EncryptedSecret, d.config.AESHashkey)
+	if err != nil {
+		d.logger.Error("decrypt mfa secret error", zap.Error(err))
+		return errors.New("unable to confirm mfa change")
+	}
+
+	if Step, valid := validateTOTP(Secret, Passcode, time.Now(), LastStep.Int64); valid {
+		if _, err := tx.Exec(`UPDATE users SET mfa_last_step = $2 WHERE id = $1;`, UserID, Step); err != nil {
+			d.logger.Error("update mfa last step query error", zap.Error(err))
+			return errors.New("unable to confirm mfa change")
+		}
+	} else {
+		res, err := tx.Exec(
+			`DELETE FROM user_mfa_recovery_code WHERE user_id = $1 AND code_hash = $2;`,
+			UserID,
+			hashString(normalizeMFARecoveryCode(Passcode)),
+		)
+		if err != nil {
+			d.logger.Error("consume mfa recovery code query error", zap.Error(err))
+			return errors.New("unable to confirm mfa change")
+		}
+		if consumed, _ := res.RowsAffected(); consumed == 0 {
+			tx.Rollback()
+			d.recordFailedMFA(UserID)
+			return errors.New("INVALID_MFA_REAUTH")
+		}
+	}
+
+	if err := resetFailedMFA(tx, UserID); err != nil {
+		d.logger.Error("reset mfa failed attempts query error", zap.Error(err))
+		return errors.New("unable to confirm mfa change")
+	}
+
+	if err := tx.Commit(); err != nil {
+		d.logger.Error("confirm mfa change commit error", zap.Error(err))
+		return errors.New("unable to confirm mfa change")
+	}
+
+	return nil
+}
+
+// createMFAToken creates the short-lived pre-auth token exchanged for a session once the passcode is verified,
+// refused while the user is locked out of MFA so new tokens don't grant new passcode attempts
+func (d *Database) createMFAToken(UserID string) (string, error) {
+	var Locked bool
+	if err := d.db.QueryRow(
+		`SELECT COALESCE(mfa_locked_until > NOW(), false) FROM users WHERE id = $1;`,
+		UserID,
+	).Scan(&Locked); err != nil {
+		d.logger.Error("get mfa lockout query error", zap.Error(err))
+		return "", errors.New("unable to create mfa token")
+	}
+	if Locked {
+		return "", errors.New("MFA_LOCKED")
+	}
+
+	TokenID, err := randomBase64String(32)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := d.db.Exec(
+		`INSERT INTO user_mfa_token (token_id, user_id) VALUES ($1, $2);`,
+		TokenID,
+		UserID,
+	); err != nil {
+		d.logger.Error("insert mfa token query error", zap.Error(err))
+		return "", errors.New("unable to create mfa token")
+	}
+
+	if err := d.setTokenExpiry(TokenTypeMFA, TokenID); err != nil {
+		return "", err
+	}
+
+	return TokenID, nil
+}
+
+// ValidateMFA verifies the pre-auth tokens passcode (or an unused recovery code, which is consumed)
+// and creates the users session, the token is discarded once used or after too many attempts
+// and the user is locked out of MFA after too many failed passcodes across tokens
+func (d *Database) ValidateMFA(TokenID string, Passcode string) (*model.User, string, error) {
+	if err := d.checkTokenExpiry(TokenTypeMFA, TokenID); err != nil {
+		return nil, "", errors.New("INVALID_MFA_TOKEN")
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		d.logger.Error("validate mfa transaction error", zap.Error(err))
+		return nil, "", errors.New("unable to validate mfa")
+	}
+	defer tx.Rollback()
+
+	var user model.User
+	var EncryptedSecret string
+	var LastStep sql.NullInt64
+	var Attempts int
+	var Locked bool
+	if err := tx.QueryRow(
+		`SELECT u.id, u.name, u.email, u.type, u.avatar, u.verified, u.notifications_enabled, COALESCE(u.locale, ''), u.disabled,
+			COALESCE(u.mfa_secret, ''), u.mfa_last_step, umt.attempts, COALESCE(u.mfa_locked_until > NOW(), false)
+		FROM user_mfa_token umt
+		JOIN users u ON u.id = umt.user_id
+		WHERE umt.token_id = $1
+		FOR UPDATE;`,
+		TokenID,
+	).Scan(
+		&user.Id, &user.Name, &user.Email, &user.Type, &user.Avatar, &user.Verified, &user.NotificationsEnabled, &user.Locale, &user.Disabled,
+		&EncryptedSecret, &LastStep, &Attempts, &Locked,
+	); err != nil {
+		d.logger.Error("validate mfa get user query error", zap.Error(err))
+		return nil, "", errors.New("INVALID_MFA_TOKEN")
+	}
+
+	if user.Disabled || Attempts >= maxMFAAttempts {
+		return nil, "", errors.New("INVALID_MFA_TOKEN")
+	}
+	if Locked {
+		return nil, "", errors.New("MFA_LOCKED")
+	}
+
+	Secret, err := decrypt(EncryptedSecret, d.config.AESHashkey)
+	if err != nil {
+		d.logger.Error("decrypt mfa secret error", zap.Error(err))
+		return nil, "", errors.New("unable to validate mfa")
+	}
+
+	Passcode = strings.TrimSpace(Passcode)
+	Step, valid := validateTOTP(Secret, Passcode, time.Now(), LastStep.Int64)
+	if valid {
+		if _, err := tx.Exec(`UPDATE users SET mfa_last_step = $2 WHERE id = $1;`, user.Id, Step); err != nil {
+			d.logger.Error("update mfa last step query error", zap.Error(err))
+			return nil, "", errors.New("unable to validate mfa")
+		}
+	} else {
+		// fall back to a recovery code, consuming it
+		res, err := tx.Exec(
+			`DELETE FROM user_mfa_recovery_code WHERE user_id = $1 AND code_hash = $2;`,
+			user.Id,
+			hashString(normalizeMFARecoveryCode(Passcode)),
+		)
+		if err != nil {
+			d.logger.Error("consume mfa recovery code query error", zap.Error(err))
+			return nil, "", errors.New("unable to validate mfa")
+		}
+		if consumed, _ := res.RowsAffected(); consumed == 0 {
+			tx.Rollback()
+			if _, err := d.db.Exec(
+				`UPDATE user_mfa_token SET attempts = attempts + 1 WHERE token_id = $1;`,
+				TokenID,
+			); err != nil {
+				d.logger.Error("update mfa token attempts query error", zap.Error(err))
+			}
+			d.recordFailedMFA(user.Id)
+			return nil, "", errors.New("INVALID_MFA_PASSCODE")
+		}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM user_mfa_token WHERE token_id = $1;`, TokenID); err != nil {
+		d.logger.Error("delete mfa token query error", zap.Error(err))
+		return nil, "", errors.New("unable to validate mfa")
+	}
+
+	if err := resetFailedMFA(tx, user.Id); err != nil {
+		d.logger.Error("reset mfa failed attempts query error", zap.Error(err))
+		return nil, "", errors.New("unable to validate mfa")
+	}
+
+	if err := tx.Commit(); err != nil {
+		d.logger.Error("validate mfa commit error", zap.Error(err))
+		return nil, "", errors.New("unable to validate mfa")
+	}
+
+	SessionId, err := d.CreateSession(user.Id)
+	if err != nil {
+		return nil, "", err
+	}
+	user.MFAEnabled = true
+
+	return &user, SessionId, nil
+}
+
+// recordFailedMFA counts a failed passcode against the user, locking them out of MFA
+// once they reach the max failures and starting the count over
+func (d *Database) recordFailedMFA(UserID string) {
+	if _, err := d.db.Exec(
+		`UPDATE users SET
+			mfa_failed_attempts = CASE WHEN mfa_failed_attempts + 1 >= $2 THEN 0 ELSE mfa_failed_attempts + 1 END,
+			mfa_locked_until = CASE WHEN mfa_failed_attempts + 1 >= $2 THEN NOW() + make_interval(secs => $3) ELSE mfa_locked_until END
+		WHERE id = $1;`,
+		UserID,
+		maxUserMFAFailures,
+		mfaLockoutDuration.Seconds(),
+	); err != nil {
+		d.logger.Error("record mfa failed attempt query error", zap.Error(err))
+	}
+}
+
+// resetFailedMFA clears the users failed passcodes once they've entered a valid one
+func resetFailedMFA(tx *sql.Tx, UserID string) error {
+	_, err := tx.Exec(
+		`UPDATE users SET mfa_failed_attempts = 0, mfa_locked_until = NULL WHERE id = $1;`,
+		UserID,
+	)
+	return err
+}
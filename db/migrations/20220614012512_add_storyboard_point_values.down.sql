@@ -0,0 +1,3 @@
+DROP PROCEDURE revise_storyboard_point_values(UUID, JSONB);
+
+ALTER TABLE storyboard DROP COLUMN point_values_allowed;
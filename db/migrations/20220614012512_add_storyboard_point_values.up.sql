@@ -0,0 +1,12 @@
+ALTER TABLE storyboard ADD COLUMN point_values_allowed JSONB DEFAULT '[0, 1, 2, 3, 5, 8, 13, 20, 40, 100]'::JSONB;
+
+-- Revise a Storyboard allowed story point values
+CREATE PROCEDURE revise_storyboard_point_values(storyboardId UUID, pointValues JSONB)
+LANGUAGE plpgsql AS $$
+BEGIN
+    UPDATE storyboard SET point_values_allowed = pointValues, updated_date = NOW()
+        WHERE id = storyboardId;
+
+    COMMIT;
+END;
+$$;
@@ -0,0 +1,5 @@
+DROP TRIGGER team_user_invite_accept ON users;
+DROP FUNCTION team_user_invite_accept();
+DROP PROCEDURE team_user_role_update(UUID, UUID, VARCHAR);
+DROP PROCEDURE team_user_invite_add(UUID, VARCHAR, VARCHAR);
+DROP TABLE team_user_invite;
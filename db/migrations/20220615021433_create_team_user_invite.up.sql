@@ -0,0 +1,43 @@
+CREATE TABLE team_user_invite (
+    "team_id" uuid NOT NULL REFERENCES "team" ("id") ON DELETE CASCADE,
+    "email" varchar(320) NOT NULL,
+    "role" varchar(16) NOT NULL DEFAULT 'MEMBER',
+    "created_date" timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY ("team_id", "email")
+);
+
+-- Invite (non-existing) User to Team --
+CREATE OR REPLACE PROCEDURE team_user_invite_add(teamId UUID, userEmail VARCHAR(320), userRole VARCHAR(16))
+AS $$
+BEGIN
+    INSERT INTO team_user_invite (team_id, email, role) VALUES (teamId, LOWER(userEmail), userRole)
+        ON CONFLICT (team_id, email) DO UPDATE SET role = EXCLUDED.role;
+    UPDATE team SET updated_date = NOW() WHERE id = teamId;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Update Team User Role --
+CREATE OR REPLACE PROCEDURE team_user_role_update(teamId UUID, userId UUID, userRole VARCHAR(16))
+AS $$
+BEGIN
+    UPDATE team_user SET role = userRole, updated_date = NOW() WHERE team_id = teamId AND user_id = userId;
+    UPDATE team SET updated_date = NOW() WHERE id = teamId;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Add invited user to teams once registered --
+CREATE OR REPLACE FUNCTION team_user_invite_accept() RETURNS trigger AS $$
+BEGIN
+    IF NEW.email IS NOT NULL THEN
+        INSERT INTO team_user (team_id, user_id, role)
+            SELECT tui.team_id, NEW.id, tui.role FROM team_user_invite tui WHERE tui.email = LOWER(NEW.email)
+            ON CONFLICT DO NOTHING;
+        DELETE FROM team_user_invite WHERE email = LOWER(NEW.email);
+    END IF;
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER team_user_invite_accept AFTER INSERT ON users
+    FOR EACH ROW EXECUTE PROCEDURE team_user_invite_accept();
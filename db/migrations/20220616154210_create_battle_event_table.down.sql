@@ -0,0 +1,3 @@
+DROP TABLE battle_event;
+
+ALTER TABLE battles DROP COLUMN recording_enabled;
@@ -0,0 +1,13 @@
+ALTER TABLE battles ADD COLUMN recording_enabled BOOL DEFAULT false;
+
+CREATE TABLE battle_event (
+    "id" uuid NOT NULL DEFAULT uuid_generate_v4(),
+    "battle_id" uuid NOT NULL REFERENCES "battles" ("id") ON DELETE CASCADE,
+    "user_id" uuid,
+    "event_type" varchar(64) NOT NULL,
+    "event_value" text,
+    "created_date" timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY ("id")
+);
+
+CREATE INDEX battle_event_battle_id_idx ON battle_event (battle_id, created_date);
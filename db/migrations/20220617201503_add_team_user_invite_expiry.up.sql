@@ -0,0 +1,16 @@
+ALTER TABLE team_user_invite ADD COLUMN expire_date timestamptz DEFAULT (now() + '7 days'::interval);
+
+-- Add invited user to teams once registered, ignoring expired invites --
+CREATE OR REPLACE FUNCTION team_user_invite_accept() RETURNS trigger AS $$
+BEGIN
+    IF NEW.email IS NOT NULL THEN
+        INSERT INTO team_user (team_id, user_id, role)
+            SELECT tui.team_id, NEW.id, tui.role FROM team_user_invite tui
+            WHERE tui.email = LOWER(NEW.email) AND NOW() < tui.expire_date
+            ON CONFLICT DO NOTHING;
+        DELETE FROM team_user_invite WHERE email = LOWER(NEW.email);
+    END IF;
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
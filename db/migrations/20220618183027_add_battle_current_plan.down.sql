@@ -0,0 +1,2 @@
+ALTER TABLE battles DROP COLUMN auto_start_voting;
+ALTER TABLE battles DROP COLUMN current_plan_id;
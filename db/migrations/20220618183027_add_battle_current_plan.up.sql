@@ -0,0 +1,2 @@
+ALTER TABLE battles ADD COLUMN current_plan_id UUID REFERENCES plans (id) ON DELETE SET NULL;
+ALTER TABLE battles ADD COLUMN auto_start_voting BOOL DEFAULT false;
@@ -0,0 +1,13 @@
+CREATE TABLE team_apikey (
+    "id" text NOT NULL,
+    "team_id" uuid NOT NULL REFERENCES "team" ("id") ON DELETE CASCADE,
+    "name" varchar(256) NOT NULL,
+    "scopes" jsonb NOT NULL DEFAULT '["read"]'::jsonb,
+    "active" bool NOT NULL DEFAULT true,
+    "created_by" uuid REFERENCES "users" ("id") ON DELETE SET NULL,
+    "created_date" timestamptz NOT NULL DEFAULT now(),
+    "updated_date" timestamptz NOT NULL DEFAULT now(),
+    "last_used_date" timestamptz,
+    PRIMARY KEY ("id"),
+    UNIQUE ("team_id", "name")
+);
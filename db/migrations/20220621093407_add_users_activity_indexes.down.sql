@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS users_created_date_idx;
+DROP INDEX IF EXISTS users_last_active_idx;
@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS users_created_date_idx ON users (created_date);
+CREATE INDEX IF NOT EXISTS users_last_active_idx ON users (last_active);
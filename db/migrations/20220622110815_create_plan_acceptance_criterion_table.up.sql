@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS plan_acceptance_criterion (
+    id UUID NOT NULL DEFAULT uuid_generate_v4() PRIMARY KEY,
+    plan_id UUID NOT NULL REFERENCES plans (id) ON DELETE CASCADE,
+    content TEXT NOT NULL,
+    checked BOOL DEFAULT false,
+    created_date TIMESTAMP DEFAULT NOW(),
+    updated_date TIMESTAMP DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS plan_acceptance_criterion_plan_id_idx ON plan_acceptance_criterion (plan_id);
+
+-- migrate existing freeform acceptance criteria as a single item
+INSERT INTO plan_acceptance_criterion (plan_id, content)
+    SELECT id, acceptance_criteria FROM plans
+    WHERE acceptance_criteria IS NOT NULL AND acceptance_criteria != '';
@@ -0,0 +1 @@
+ALTER TABLE battles ADD COLUMN short_code VARCHAR(8) UNIQUE;
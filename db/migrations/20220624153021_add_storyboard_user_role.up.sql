@@ -0,0 +1,19 @@
+ALTER TABLE storyboard_user ADD COLUMN role VARCHAR(16) NOT NULL DEFAULT 'PARTICIPANT';
+
+-- Get Storyboard Users
+DROP FUNCTION get_storyboard_users(storyboardId UUID);
+CREATE FUNCTION get_storyboard_users(storyboardId UUID) RETURNS table (
+    id UUID, name VARCHAR(256), active BOOL, avatar varchar(128), email varchar(320), role VARCHAR(16)
+) AS $$
+BEGIN
+    RETURN QUERY
+        SELECT
+			w.id, w.name, su.active, w.avatar, COALESCE(w.email, ''),
+			CASE WHEN s.owner_id = w.id THEN 'FACILITATOR'::VARCHAR(16) ELSE su.role END
+		FROM storyboard_user su
+		LEFT JOIN users w ON su.user_id = w.id
+		LEFT JOIN storyboard s ON su.storyboard_id = s.id
+		WHERE su.storyboard_id = storyboardId
+		ORDER BY w.name;
+END;
+$$ LANGUAGE plpgsql;
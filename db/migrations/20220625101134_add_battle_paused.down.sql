@@ -0,0 +1,2 @@
+ALTER TABLE battles DROP COLUMN paused;
+ALTER TABLE battles DROP COLUMN paused_date;
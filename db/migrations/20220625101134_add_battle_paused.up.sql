@@ -0,0 +1,2 @@
+ALTER TABLE battles ADD COLUMN paused BOOL DEFAULT false;
+ALTER TABLE battles ADD COLUMN paused_date TIMESTAMP;
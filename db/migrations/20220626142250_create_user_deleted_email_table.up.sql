@@ -0,0 +1,5 @@
+-- hashed emails of deleted accounts, used to prevent re-registering until purged
+CREATE TABLE IF NOT EXISTS user_deleted_email (
+    email_hash TEXT NOT NULL PRIMARY KEY,
+    deleted_date TIMESTAMP DEFAULT NOW()
+);
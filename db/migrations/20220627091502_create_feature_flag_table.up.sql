@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS feature_flag (
+    id UUID NOT NULL DEFAULT gen_random_uuid() PRIMARY KEY,
+    name VARCHAR(64) NOT NULL UNIQUE,
+    description TEXT,
+    enabled BOOL DEFAULT false,
+    roles JSONB DEFAULT '[]'::JSONB,
+    user_ids JSONB DEFAULT '[]'::JSONB,
+    percentage INTEGER DEFAULT 0 CHECK (percentage >= 0 AND percentage <= 100),
+    created_date TIMESTAMP DEFAULT NOW(),
+    updated_date TIMESTAMP DEFAULT NOW()
+);
@@ -0,0 +1,2 @@
+ALTER TABLE team DROP COLUMN max_plans_per_battle;
+ALTER TABLE organization DROP COLUMN max_plans_per_battle;
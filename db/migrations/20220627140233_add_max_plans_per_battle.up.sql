@@ -0,0 +1,2 @@
+ALTER TABLE team ADD COLUMN max_plans_per_battle INTEGER;
+ALTER TABLE organization ADD COLUMN max_plans_per_battle INTEGER;
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS user_storage (
+    user_id UUID NOT NULL PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    used_bytes BIGINT NOT NULL DEFAULT 0 CHECK (used_bytes >= 0),
+    quota_bytes BIGINT,
+    updated_date TIMESTAMP DEFAULT NOW()
+);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS battles_quick_expire_date_idx;
+ALTER TABLE battles DROP COLUMN quick;
+ALTER TABLE battles DROP COLUMN expire_date;
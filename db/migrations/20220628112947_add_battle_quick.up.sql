@@ -0,0 +1,3 @@
+ALTER TABLE battles ADD COLUMN quick BOOL DEFAULT false;
+ALTER TABLE battles ADD COLUMN expire_date TIMESTAMP;
+CREATE INDEX IF NOT EXISTS battles_quick_expire_date_idx ON battles (expire_date) WHERE quick = true;
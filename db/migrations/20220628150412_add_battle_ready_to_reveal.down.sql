@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS plan_reveal_ready;
+ALTER TABLE battles DROP COLUMN require_ready_to_reveal;
@@ -0,0 +1,7 @@
+ALTER TABLE battles ADD COLUMN require_ready_to_reveal BOOL DEFAULT false;
+CREATE TABLE IF NOT EXISTS plan_reveal_ready (
+    plan_id UUID NOT NULL REFERENCES plans(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_date TIMESTAMP DEFAULT NOW(),
+    PRIMARY KEY (plan_id, user_id)
+);
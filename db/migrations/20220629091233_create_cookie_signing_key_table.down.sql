@@ -0,0 +1 @@
+DROP TABLE IF EXISTS cookie_signing_key;
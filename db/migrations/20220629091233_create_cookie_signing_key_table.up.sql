@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS cookie_signing_key (
+    id UUID NOT NULL DEFAULT gen_random_uuid() PRIMARY KEY,
+    signing_key TEXT NOT NULL,
+    created_date TIMESTAMP DEFAULT NOW()
+);
@@ -0,0 +1,3 @@
+ALTER TABLE users ADD COLUMN timezone VARCHAR(64);
+ALTER TABLE users ADD COLUMN quiet_hours_start TIME;
+ALTER TABLE users ADD COLUMN quiet_hours_end TIME;
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS battle_parking_lot (
+    id UUID NOT NULL DEFAULT uuid_generate_v4() PRIMARY KEY,
+    battle_id UUID NOT NULL REFERENCES battles (id) ON DELETE CASCADE,
+    user_id UUID REFERENCES users (id) ON DELETE SET NULL,
+    content TEXT NOT NULL,
+    resolved BOOL DEFAULT false,
+    created_date TIMESTAMP DEFAULT NOW(),
+    updated_date TIMESTAMP DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS battle_parking_lot_battle_id_idx ON battle_parking_lot (battle_id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS team_admin_demotion;
+ALTER TABLE team_user DROP COLUMN demotion_notice_date;
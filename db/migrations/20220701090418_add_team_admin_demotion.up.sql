@@ -0,0 +1,10 @@
+ALTER TABLE team_user ADD COLUMN demotion_notice_date TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS team_admin_demotion (
+    id UUID NOT NULL DEFAULT uuid_generate_v4() PRIMARY KEY,
+    team_id UUID NOT NULL REFERENCES team (id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    last_active TIMESTAMP,
+    demoted_date TIMESTAMP DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS team_admin_demotion_team_id_idx ON team_admin_demotion (team_id);
@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN onboarding_dismissed;
+ALTER TABLE users DROP COLUMN onboarding_completed_steps;
@@ -0,0 +1,2 @@
+ALTER TABLE users ADD COLUMN onboarding_completed_steps TEXT[] DEFAULT '{}';
+ALTER TABLE users ADD COLUMN onboarding_dismissed BOOL DEFAULT false;
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS plan_vote_history (
+    id UUID NOT NULL DEFAULT uuid_generate_v4() PRIMARY KEY,
+    plan_id UUID NOT NULL REFERENCES plans (id) ON DELETE CASCADE,
+    merged_plan_name VARCHAR(256),
+    merged_plan_points VARCHAR(3) DEFAULT '',
+    votes JSONB DEFAULT '[]'::jsonb,
+    created_date TIMESTAMP DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS plan_vote_history_plan_id_idx ON plan_vote_history (plan_id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS plan_poll_response;
+DROP TABLE IF EXISTS plan_poll;
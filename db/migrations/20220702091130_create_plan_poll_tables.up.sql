@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS plan_poll (
+    plan_id UUID NOT NULL REFERENCES plans (id) ON DELETE CASCADE PRIMARY KEY,
+    question VARCHAR(256) NOT NULL,
+    open BOOL DEFAULT true,
+    created_date TIMESTAMP DEFAULT NOW(),
+    updated_date TIMESTAMP DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS plan_poll_response (
+    plan_id UUID NOT NULL REFERENCES plan_poll (plan_id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    response VARCHAR(16) NOT NULL,
+    created_date TIMESTAMP DEFAULT NOW(),
+    PRIMARY KEY (plan_id, user_id)
+);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS battle_reopen;
+ALTER TABLE battles DROP COLUMN closed_date;
+ALTER TABLE battles DROP COLUMN closed;
@@ -0,0 +1,11 @@
+ALTER TABLE battles ADD COLUMN closed BOOL DEFAULT false;
+ALTER TABLE battles ADD COLUMN closed_date TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS battle_reopen (
+    id UUID NOT NULL DEFAULT uuid_generate_v4() PRIMARY KEY,
+    battle_id UUID NOT NULL REFERENCES battles (id) ON DELETE CASCADE,
+    user_id UUID REFERENCES users (id) ON DELETE SET NULL,
+    closed_date TIMESTAMP,
+    reopened_date TIMESTAMP DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS battle_reopen_battle_id_idx ON battle_reopen (battle_id);
@@ -0,0 +1,3 @@
+ALTER TABLE team DROP COLUMN battle_state_workflow;
+DROP INDEX IF EXISTS battles_state_idx;
+ALTER TABLE battles DROP COLUMN state;
@@ -0,0 +1,4 @@
+ALTER TABLE battles ADD COLUMN state VARCHAR(64) NOT NULL DEFAULT 'open';
+UPDATE battles SET state = 'closed' WHERE closed = true;
+CREATE INDEX IF NOT EXISTS battles_state_idx ON battles (state);
+ALTER TABLE team ADD COLUMN battle_state_workflow JSONB;
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS user_view (
+    id UUID NOT NULL DEFAULT uuid_generate_v4() PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name VARCHAR(256) NOT NULL,
+    filter JSONB NOT NULL,
+    created_date TIMESTAMP DEFAULT NOW(),
+    updated_date TIMESTAMP DEFAULT NOW(),
+    UNIQUE (user_id, name)
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS plan_discussion;
+ALTER TABLE battles DROP COLUMN note_taker_id;
@@ -0,0 +1,11 @@
+ALTER TABLE battles ADD COLUMN note_taker_id UUID REFERENCES users (id) ON DELETE SET NULL;
+
+CREATE TABLE IF NOT EXISTS plan_discussion (
+    id UUID NOT NULL DEFAULT uuid_generate_v4() PRIMARY KEY,
+    plan_id UUID NOT NULL REFERENCES plans (id) ON DELETE CASCADE,
+    user_id UUID REFERENCES users (id) ON DELETE SET NULL,
+    content TEXT NOT NULL,
+    created_date TIMESTAMP DEFAULT NOW(),
+    updated_date TIMESTAMP DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS plan_discussion_plan_id_idx ON plan_discussion (plan_id);
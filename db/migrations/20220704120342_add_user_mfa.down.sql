@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS user_mfa_token;
+DROP TABLE IF EXISTS user_mfa_recovery_code;
+ALTER TABLE users DROP COLUMN mfa_last_step;
+ALTER TABLE users DROP COLUMN mfa_secret;
+ALTER TABLE users DROP COLUMN mfa_enabled;
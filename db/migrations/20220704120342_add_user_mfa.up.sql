@@ -0,0 +1,18 @@
+ALTER TABLE users ADD COLUMN mfa_enabled BOOL NOT NULL DEFAULT false;
+ALTER TABLE users ADD COLUMN mfa_secret TEXT;
+ALTER TABLE users ADD COLUMN mfa_last_step BIGINT;
+
+CREATE TABLE IF NOT EXISTS user_mfa_recovery_code (
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL,
+    created_date TIMESTAMP DEFAULT NOW(),
+    PRIMARY KEY (user_id, code_hash)
+);
+
+CREATE TABLE IF NOT EXISTS user_mfa_token (
+    token_id TEXT NOT NULL PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created_date TIMESTAMP DEFAULT NOW(),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    expire_date TIMESTAMP DEFAULT NOW() + INTERVAL '5 minutes'
+);
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS user_failed_login (
+    email_hash TEXT NOT NULL,
+    created_date TIMESTAMP DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS user_failed_login_email_hash_idx ON user_failed_login (email_hash, created_date);
@@ -0,0 +1,2 @@
+ALTER TABLE plans DROP COLUMN actual_recorded_date;
+ALTER TABLE plans DROP COLUMN actual_points;
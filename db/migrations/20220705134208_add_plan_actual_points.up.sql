@@ -0,0 +1,2 @@
+ALTER TABLE plans ADD COLUMN actual_points DOUBLE PRECISION;
+ALTER TABLE plans ADD COLUMN actual_recorded_date TIMESTAMP;
@@ -0,0 +1,6 @@
+DROP TRIGGER IF EXISTS compact_plan_positions ON plans;
+DROP FUNCTION IF EXISTS compact_plan_positions();
+DROP TRIGGER IF EXISTS assign_plan_position ON plans;
+DROP FUNCTION IF EXISTS assign_plan_position();
+ALTER TABLE plans DROP CONSTRAINT IF EXISTS plans_battle_id_position_key;
+ALTER TABLE plans DROP COLUMN IF EXISTS position;
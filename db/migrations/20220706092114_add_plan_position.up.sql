@@ -0,0 +1,39 @@
+ALTER TABLE plans ADD COLUMN position INTEGER;
+
+UPDATE plans p SET position = o.position
+FROM (
+    SELECT id, ROW_NUMBER() OVER (PARTITION BY battle_id ORDER BY created_date, id) AS position FROM plans
+) o
+WHERE p.id = o.id;
+
+ALTER TABLE plans ALTER COLUMN position SET NOT NULL;
+ALTER TABLE plans ADD CONSTRAINT plans_battle_id_position_key UNIQUE (battle_id, position) DEFERRABLE INITIALLY DEFERRED;
+
+-- assigns the next position under a lock on the battle so concurrent adds never collide
+CREATE OR REPLACE FUNCTION assign_plan_position() RETURNS TRIGGER AS $$
+BEGIN
+    PERFORM 1 FROM battles WHERE id = NEW.battle_id FOR UPDATE;
+    SELECT COALESCE(MAX(position), 0) + 1 INTO NEW.position FROM plans WHERE battle_id = NEW.battle_id;
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER assign_plan_position BEFORE INSERT ON plans
+    FOR EACH ROW EXECUTE PROCEDURE assign_plan_position();
+
+-- closes the gap left by a deleted plan so positions stay contiguous
+CREATE OR REPLACE FUNCTION compact_plan_positions() RETURNS TRIGGER AS $$
+BEGIN
+    UPDATE plans p SET position = o.position
+    FROM (
+        SELECT id, ROW_NUMBER() OVER (ORDER BY position) AS position FROM plans WHERE battle_id = OLD.battle_id
+    ) o
+    WHERE p.id = o.id AND p.position <> o.position;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER compact_plan_positions AFTER DELETE ON plans
+    FOR EACH ROW EXECUTE PROCEDURE compact_plan_positions();
@@ -0,0 +1,3 @@
+ALTER TABLE user_session DROP COLUMN IF EXISTS user_agent;
+ALTER TABLE user_session DROP COLUMN IF EXISTS ip_address;
+ALTER TABLE user_session DROP COLUMN IF EXISTS last_seen;
@@ -0,0 +1,4 @@
+ALTER TABLE user_session ADD COLUMN user_agent VARCHAR(256);
+ALTER TABLE user_session ADD COLUMN ip_address VARCHAR(64);
+ALTER TABLE user_session ADD COLUMN last_seen TIMESTAMP DEFAULT NOW();
+UPDATE user_session SET last_seen = created_date;
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS plan_vote_delegation (
+    plan_id UUID NOT NULL REFERENCES plans (id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    delegate_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    vote VARCHAR(3),
+    active BOOLEAN NOT NULL DEFAULT true,
+    created_date TIMESTAMP DEFAULT NOW(),
+    voted_date TIMESTAMP,
+    PRIMARY KEY (plan_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS plan_vote_delegation_delegate_id_idx ON plan_vote_delegation (plan_id, delegate_id);
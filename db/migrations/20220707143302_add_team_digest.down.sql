@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN IF EXISTS team_digest_enabled;
+ALTER TABLE team DROP COLUMN IF EXISTS digest_sent_date;
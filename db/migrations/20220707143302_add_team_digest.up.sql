@@ -0,0 +1,2 @@
+ALTER TABLE users ADD COLUMN team_digest_enabled BOOLEAN NOT NULL DEFAULT true;
+ALTER TABLE team ADD COLUMN digest_sent_date TIMESTAMP;
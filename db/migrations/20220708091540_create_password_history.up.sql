@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS password_history (
+    id UUID NOT NULL DEFAULT uuid_generate_v4() PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    password_hash TEXT NOT NULL,
+    created_date TIMESTAMP DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS password_history_user_id_idx ON password_history (user_id, created_date DESC);
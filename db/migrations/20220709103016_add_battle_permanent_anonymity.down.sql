@@ -0,0 +1 @@
+ALTER TABLE battles DROP COLUMN IF EXISTS permanent_anonymity;
@@ -0,0 +1 @@
+ALTER TABLE battles ADD COLUMN IF NOT EXISTS permanent_anonymity BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS storyboard_snapshot (
+    id UUID NOT NULL DEFAULT gen_random_uuid() PRIMARY KEY,
+    storyboard_id UUID NOT NULL REFERENCES storyboard(id) ON DELETE CASCADE,
+    user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    automatic BOOL NOT NULL DEFAULT false,
+    goal_count INTEGER NOT NULL DEFAULT 0,
+    story_count INTEGER NOT NULL DEFAULT 0,
+    data JSONB NOT NULL,
+    created_date TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS storyboard_snapshot_storyboard_id_idx ON storyboard_snapshot (storyboard_id, created_date DESC);
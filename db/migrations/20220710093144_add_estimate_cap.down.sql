@@ -0,0 +1,2 @@
+ALTER TABLE battles DROP COLUMN IF EXISTS max_estimate;
+ALTER TABLE team DROP COLUMN IF EXISTS max_estimate;
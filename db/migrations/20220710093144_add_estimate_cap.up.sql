@@ -0,0 +1,2 @@
+ALTER TABLE team ADD COLUMN IF NOT EXISTS max_estimate NUMERIC;
+ALTER TABLE battles ADD COLUMN IF NOT EXISTS max_estimate NUMERIC;
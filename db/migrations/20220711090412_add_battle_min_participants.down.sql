@@ -0,0 +1 @@
+ALTER TABLE battles DROP COLUMN IF EXISTS min_participants;
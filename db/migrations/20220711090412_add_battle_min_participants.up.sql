@@ -0,0 +1 @@
+ALTER TABLE battles ADD COLUMN IF NOT EXISTS min_participants SMALLINT NOT NULL DEFAULT 0;
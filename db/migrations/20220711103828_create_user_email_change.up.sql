@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS user_email_change (
+    change_id UUID NOT NULL DEFAULT gen_random_uuid() PRIMARY KEY,
+    user_id UUID NOT NULL UNIQUE REFERENCES users (id) ON DELETE CASCADE,
+    email VARCHAR(320) NOT NULL,
+    created_date TIMESTAMP DEFAULT NOW(),
+    expire_date TIMESTAMP DEFAULT NOW() + INTERVAL '24 hours'
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS storyboard_tag;
+DROP TABLE IF EXISTS battle_tag;
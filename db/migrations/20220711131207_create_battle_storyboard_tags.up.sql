@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS battle_tag (
+    battle_id UUID NOT NULL REFERENCES battles (id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    tag VARCHAR(32) NOT NULL,
+    created_date TIMESTAMP DEFAULT NOW(),
+    PRIMARY KEY (battle_id, user_id, tag)
+);
+CREATE INDEX IF NOT EXISTS battle_tag_user_tag_idx ON battle_tag (user_id, tag);
+
+CREATE TABLE IF NOT EXISTS storyboard_tag (
+    storyboard_id UUID NOT NULL REFERENCES storyboard (id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    tag VARCHAR(32) NOT NULL,
+    created_date TIMESTAMP DEFAULT NOW(),
+    PRIMARY KEY (storyboard_id, user_id, tag)
+);
+CREATE INDEX IF NOT EXISTS storyboard_tag_user_tag_idx ON storyboard_tag (user_id, tag);
@@ -0,0 +1,2 @@
+UPDATE users SET avatar = 'robohash' WHERE LENGTH(avatar) > 128;
+ALTER TABLE users ALTER COLUMN avatar TYPE VARCHAR(128);
@@ -0,0 +1,2 @@
+-- uploaded avatars are stored as their URL
+ALTER TABLE users ALTER COLUMN avatar TYPE VARCHAR(512);
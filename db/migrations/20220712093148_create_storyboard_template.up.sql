@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS storyboard_template (
+    id UUID NOT NULL PRIMARY KEY DEFAULT gen_random_uuid(),
+    name VARCHAR(256) NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    owner_id UUID REFERENCES users (id) ON DELETE SET NULL,
+    team_id UUID REFERENCES team (id) ON DELETE CASCADE,
+    is_default BOOL NOT NULL DEFAULT false,
+    goals JSONB NOT NULL DEFAULT '[]'::JSONB,
+    columns JSONB NOT NULL DEFAULT '[]'::JSONB,
+    created_date TIMESTAMPTZ DEFAULT NOW(),
+    updated_date TIMESTAMPTZ DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS storyboard_template_owner_id_idx ON storyboard_template (owner_id);
+CREATE INDEX IF NOT EXISTS storyboard_template_team_id_idx ON storyboard_template (team_id);
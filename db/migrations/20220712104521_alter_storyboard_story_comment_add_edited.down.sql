@@ -0,0 +1 @@
+ALTER TABLE storyboard_story_comment DROP COLUMN IF EXISTS edited;
@@ -0,0 +1 @@
+ALTER TABLE storyboard_story_comment ADD COLUMN IF NOT EXISTS edited BOOL NOT NULL DEFAULT false;
@@ -0,0 +1 @@
+ALTER TABLE storyboard_goal DROP COLUMN IF EXISTS locked;
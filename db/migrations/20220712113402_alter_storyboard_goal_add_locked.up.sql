@@ -0,0 +1 @@
+ALTER TABLE storyboard_goal ADD COLUMN IF NOT EXISTS locked BOOL NOT NULL DEFAULT false;
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS storyboard_revision (
+    id UUID NOT NULL DEFAULT gen_random_uuid() PRIMARY KEY,
+    storyboard_id UUID NOT NULL REFERENCES storyboard(id) ON DELETE CASCADE,
+    user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    action VARCHAR(32) NOT NULL,
+    undone BOOL NOT NULL DEFAULT false,
+    data JSONB NOT NULL,
+    created_date TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS storyboard_revision_storyboard_id_idx ON storyboard_revision (storyboard_id, created_date DESC);
@@ -0,0 +1 @@
+ALTER TABLE storyboard_story DROP COLUMN IF EXISTS tags;
@@ -0,0 +1 @@
+ALTER TABLE storyboard_story ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
@@ -0,0 +1 @@
+ALTER TABLE battles ADD COLUMN IF NOT EXISTS point_scale VARCHAR(16) NOT NULL DEFAULT 'custom';
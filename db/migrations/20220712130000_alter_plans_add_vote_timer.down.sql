@@ -0,0 +1,2 @@
+ALTER TABLE plans DROP COLUMN IF EXISTS vote_timer_end;
+ALTER TABLE battles DROP COLUMN IF EXISTS vote_timer_default;
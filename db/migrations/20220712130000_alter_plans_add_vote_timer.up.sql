@@ -0,0 +1,2 @@
+ALTER TABLE plans ADD COLUMN IF NOT EXISTS vote_timer_end TIMESTAMPTZ;
+ALTER TABLE battles ADD COLUMN IF NOT EXISTS vote_timer_default INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS jira_instance;
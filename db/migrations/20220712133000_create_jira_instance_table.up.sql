@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS jira_instance (
+    id UUID NOT NULL DEFAULT gen_random_uuid() PRIMARY KEY,
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    team_id UUID REFERENCES team(id) ON DELETE CASCADE,
+    host VARCHAR(256) NOT NULL,
+    client_mail VARCHAR(320) NOT NULL,
+    access_token TEXT NOT NULL,
+    created_date TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_date TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CONSTRAINT jira_instance_owner_check CHECK ((user_id IS NULL) <> (team_id IS NULL))
+);
+CREATE UNIQUE INDEX IF NOT EXISTS jira_instance_user_id_idx ON jira_instance (user_id) WHERE user_id IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS jira_instance_team_id_idx ON jira_instance (team_id) WHERE team_id IS NOT NULL;
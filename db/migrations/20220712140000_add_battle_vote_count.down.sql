@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS count_battle_votes ON plans;
+DROP FUNCTION IF EXISTS count_battle_votes();
+ALTER TABLE battles DROP COLUMN IF EXISTS vote_count;
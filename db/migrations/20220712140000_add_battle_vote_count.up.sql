@@ -0,0 +1,31 @@
+ALTER TABLE battles ADD COLUMN IF NOT EXISTS vote_count INTEGER NOT NULL DEFAULT 0;
+
+UPDATE battles b SET vote_count = v.vote_count
+FROM (
+    SELECT battle_id, SUM(jsonb_array_length(votes)) AS vote_count FROM plans GROUP BY battle_id
+) v
+WHERE b.id = v.battle_id;
+
+-- keeps the battles vote count in step with its plans votes so instance stats don't expand every plans votes
+CREATE OR REPLACE FUNCTION count_battle_votes() RETURNS TRIGGER AS $$
+DECLARE
+    delta INTEGER;
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        delta := COALESCE(jsonb_array_length(NEW.votes), 0);
+    ELSIF TG_OP = 'DELETE' THEN
+        delta := -COALESCE(jsonb_array_length(OLD.votes), 0);
+    ELSE
+        delta := COALESCE(jsonb_array_length(NEW.votes), 0) - COALESCE(jsonb_array_length(OLD.votes), 0);
+    END IF;
+
+    IF delta <> 0 THEN
+        UPDATE battles SET vote_count = vote_count + delta WHERE id = COALESCE(NEW.battle_id, OLD.battle_id);
+    END IF;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER count_battle_votes AFTER INSERT OR DELETE OR UPDATE OF votes ON plans
+    FOR EACH ROW EXECUTE PROCEDURE count_battle_votes();
@@ -0,0 +1,50 @@
+-- Revisions recorded the whole storyboard, undo now records only the goal, column, or story changed --
+DELETE FROM storyboard_revision;
+
+-- Move a Storyboard Story in place keeping its ID, comments, points, and tags --
+CREATE OR REPLACE PROCEDURE move_story(storyId UUID, goalId UUID, columnId UUID, placeBefore TEXT)
+LANGUAGE plpgsql AS $$
+DECLARE storyboardId UUID;
+DECLARE srcColumnId UUID;
+DECLARE srcSortOrder INTEGER;
+DECLARE targetSortOrder INTEGER;
+BEGIN
+    SELECT storyboard_id, column_id, sort_order INTO storyboardId, srcColumnId, srcSortOrder
+    FROM storyboard_story WHERE id = storyId FOR UPDATE;
+
+    -- Take the story out of the source column
+    UPDATE storyboard_story SET sort_order = NULL WHERE id = storyId;
+    UPDATE storyboard_story ss SET sort_order = (t.sort_order - 1)
+    FROM (
+        SELECT id, sort_order FROM storyboard_story
+        WHERE column_id = srcColumnId AND sort_order > srcSortOrder
+        ORDER BY sort_order ASC
+        FOR UPDATE
+    ) AS t
+    WHERE ss.id = t.id;
+
+    -- Get target sort order, the end of the column when the story to place before isn't in it
+    IF placeBefore <> '' THEN
+        SELECT sort_order INTO targetSortOrder FROM storyboard_story WHERE column_id = columnId AND id = placeBefore::UUID;
+    END IF;
+    IF targetSortOrder IS NULL THEN
+        SELECT coalesce(max(sort_order), 0) + 1 INTO targetSortOrder FROM storyboard_story WHERE column_id = columnId;
+    END IF;
+
+    -- Update sort order for any story that should come after the moved story
+    UPDATE storyboard_story ss SET sort_order = (t.sort_order + 1)
+    FROM (
+        SELECT id, sort_order FROM storyboard_story
+        WHERE column_id = columnId AND sort_order >= targetSortOrder
+        ORDER BY sort_order DESC
+        FOR UPDATE
+    ) AS t
+    WHERE ss.id = t.id;
+
+    UPDATE storyboard_story
+    SET goal_id = goalId, column_id = columnId, sort_order = targetSortOrder, updated_date = NOW()
+    WHERE id = storyId;
+
+    UPDATE storyboard SET updated_date = NOW() WHERE id = storyboardId;
+END;
+$$;
@@ -0,0 +1,3 @@
+-- Team invites are accepted once the user verifies they own the invited email, not on registration --
+DROP TRIGGER IF EXISTS team_user_invite_accept ON users;
+DROP FUNCTION IF EXISTS team_user_invite_accept();
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS email_deferred (
+    id UUID NOT NULL DEFAULT uuid_generate_v4() PRIMARY KEY,
+    user_name VARCHAR(256) NOT NULL,
+    user_email VARCHAR(320) NOT NULL,
+    subject TEXT NOT NULL,
+    body TEXT NOT NULL,
+    send_at TIMESTAMPTZ NOT NULL,
+    created_date TIMESTAMPTZ DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS email_deferred_send_at_idx ON email_deferred (send_at);
@@ -0,0 +1,40 @@
+package db
+
+import (
+	"errors"
+
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+// GetOnboardingState gets the users completed onboarding checklist steps and whether the checklist was dismissed
+func (d *Database) GetOnboardingState(UserID string) ([]string, bool, error) {
+	var CompletedSteps []string
+	var Dismissed bool
+
+	err := d.db.QueryRow(
+		`SELECT COALESCE(onboarding_completed_steps, '{}'), COALESCE(onboarding_dismissed, false) FROM users WHERE id = $1;`,
+		UserID,
+	).Scan(pq.Array(&CompletedSteps), &Dismissed)
+	if err != nil {
+		d.logger.Error("get user onboarding state query error", zap.Error(err))
+		return nil, false, errors.New("user not found")
+	}
+
+	return CompletedSteps, Dismissed, nil
+}
+
+// UpdateOnboardingState sets the users completed onboarding checklist steps and whether the checklist was dismissed
+func (d *Database) UpdateOnboardingState(UserID string, CompletedSteps []string, Dismissed bool) error {
+	if _, err := d.db.Exec(
+		`UPDATE users SET onboarding_completed_steps = $2, onboarding_dismissed = $3 WHERE id = $1;`,
+		UserID,
+		pq.Array(CompletedSteps),
+		Dismissed,
+	); err != nil {
+		d.logger.Error("update user onboarding state query error", zap.Error(err))
+		return errors.New("unable to update onboarding state")
+	}
+
+	return nil
+}
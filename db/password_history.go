@@ -0,0 +1,86 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordReused whether the password matches any of the bcrypt hashes
+func passwordReused(Hashes []string, Password string) bool {
+	for _, Hash := range Hashes {
+		if bcrypt.CompareHashAndPassword([]byte(Hash), []byte(Password)) == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkPasswordHistory rejects the password when it's the users current password
+// or one of their last PasswordHistoryDepth passwords, disabled when the depth is 0
+func (d *Database) checkPasswordHistory(UserID string, Password string) error {
+	if d.config.PasswordHistoryDepth <= 0 {
+		return nil
+	}
+
+	rows, err := d.db.Query(
+		`(SELECT password FROM users WHERE id = $1 AND password IS NOT NULL)
+		UNION ALL
+		(SELECT password_hash FROM password_history WHERE user_id = $1 ORDER BY created_date DESC LIMIT $2);`,
+		UserID,
+		d.config.PasswordHistoryDepth,
+	)
+	if err != nil {
+		d.logger.Error("get password history query error", zap.Error(err))
+		return errors.New("unable to check password history")
+	}
+	defer rows.Close()
+
+	var Hashes []string
+	for rows.Next() {
+		var Hash sql.NullString
+		if err := rows.Scan(&Hash); err != nil {
+			d.logger.Error("get password history query scan error", zap.Error(err))
+			continue
+		}
+		if Hash.Valid {
+			Hashes = append(Hashes, Hash.String)
+		}
+	}
+
+	if passwordReused(Hashes, Password) {
+		return errors.New("PASSWORD_RECENTLY_USED")
+	}
+
+	return nil
+}
+
+// recordPasswordHistory adds the users new password hash to their history,
+// pruning the entries beyond the history depth
+func (d *Database) recordPasswordHistory(UserID string, PasswordHash string) {
+	if d.config.PasswordHistoryDepth <= 0 {
+		return
+	}
+
+	if _, err := d.db.Exec(
+		`INSERT INTO password_history (user_id, password_hash) VALUES ($1, $2);`,
+		UserID,
+		PasswordHash,
+	); err != nil {
+		d.logger.Error("insert password history query error", zap.Error(err))
+		return
+	}
+
+	if _, err := d.db.Exec(
+		`DELETE FROM password_history WHERE user_id = $1 AND id NOT IN (
+			SELECT id FROM password_history WHERE user_id = $1 ORDER BY created_date DESC LIMIT $2
+		);`,
+		UserID,
+		d.config.PasswordHistoryDepth,
+	); err != nil {
+		d.logger.Error("prune password history query error", zap.Error(err))
+	}
+}
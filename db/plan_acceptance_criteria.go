@@ -0,0 +1,102 @@
+package db
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxPlanAcceptanceCriterionLength the max number of characters of an acceptance criterion
+	maxPlanAcceptanceCriterionLength = 500
+	// maxPlanAcceptanceCriteria the max number of acceptance criteria items per plan
+	maxPlanAcceptanceCriteria = 25
+)
+
+// validatePlanAcceptanceCriterion validates the acceptance criterion content and the plans current item count
+func validatePlanAcceptanceCriterion(Content string, ItemCount int) error {
+	if strings.TrimSpace(Content) == "" {
+		return errors.New("ACCEPTANCE_CRITERION_REQUIRED")
+	}
+	if len([]rune(Content)) > maxPlanAcceptanceCriterionLength {
+		return errors.New("ACCEPTANCE_CRITERION_TOO_LONG")
+	}
+	if ItemCount >= maxPlanAcceptanceCriteria {
+		return errors.New("ACCEPTANCE_CRITERIA_LIMIT_REACHED")
+	}
+
+	return nil
+}
+
+// AddPlanAcceptanceCriterion adds an acceptance criteria checklist item to a battle plan
+func (d *Database) AddPlanAcceptanceCriterion(BattleID string, PlanID string, Content string) ([]*model.Plan, error) {
+	var ItemCount int
+	err := d.db.QueryRow(
+		`SELECT COUNT(pac.id) FROM plans p
+		LEFT JOIN plan_acceptance_criterion pac ON pac.plan_id = p.id
+		WHERE p.id = $2 AND p.battle_id = $1
+		GROUP BY p.id;`,
+		BattleID,
+		PlanID,
+	).Scan(&ItemCount)
+	if err != nil {
+		d.logger.Error("get plan acceptance criteria count error", zap.Error(err))
+		return nil, errors.New("PLAN_NOT_FOUND")
+	}
+
+	SanitizedContent := d.htmlSanitizerPolicy.Sanitize(Content)
+	if err := validatePlanAcceptanceCriterion(SanitizedContent, ItemCount); err != nil {
+		return nil, err
+	}
+
+	if _, err := d.db.Exec(
+		`INSERT INTO plan_acceptance_criterion (plan_id, content) VALUES ($1, $2);`,
+		PlanID,
+		SanitizedContent,
+	); err != nil {
+		d.logger.Error("insert plan acceptance criterion error", zap.Error(err))
+		return nil, errors.New("unable to add plan acceptance criterion")
+	}
+
+	plans := d.GetPlans(BattleID, "")
+
+	return plans, nil
+}
+
+// TogglePlanAcceptanceCriterion toggles whether a battle plans acceptance criteria checklist item is checked
+func (d *Database) TogglePlanAcceptanceCriterion(BattleID string, PlanID string, CriterionID string) ([]*model.Plan, error) {
+	if _, err := d.db.Exec(
+		`UPDATE plan_acceptance_criterion SET checked = NOT checked, updated_date = NOW()
+		WHERE id = $3 AND plan_id = (SELECT id FROM plans WHERE id = $2 AND battle_id = $1);`,
+		BattleID,
+		PlanID,
+		CriterionID,
+	); err != nil {
+		d.logger.Error("toggle plan acceptance criterion error", zap.Error(err))
+		return nil, errors.New("unable to toggle plan acceptance criterion")
+	}
+
+	plans := d.GetPlans(BattleID, "")
+
+	return plans, nil
+}
+
+// RemovePlanAcceptanceCriterion removes an acceptance criteria checklist item from a battle plan
+func (d *Database) RemovePlanAcceptanceCriterion(BattleID string, PlanID string, CriterionID string) ([]*model.Plan, error) {
+	if _, err := d.db.Exec(
+		`DELETE FROM plan_acceptance_criterion
+		WHERE id = $3 AND plan_id = (SELECT id FROM plans WHERE id = $2 AND battle_id = $1);`,
+		BattleID,
+		PlanID,
+		CriterionID,
+	); err != nil {
+		d.logger.Error("delete plan acceptance criterion error", zap.Error(err))
+		return nil, errors.New("unable to remove plan acceptance criterion")
+	}
+
+	plans := d.GetPlans(BattleID, "")
+
+	return plans, nil
+}
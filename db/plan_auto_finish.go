@@ -0,0 +1,48 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+
+	"go.uber.org/zap"
+)
+
+// GetBattleAutoFinishState gets whether the battle finishes voting once everyone has voted and its active plan if voting is open,
+// battles requiring readiness to reveal wait for everyone to be ready instead
+func (d *Database) GetBattleAutoFinishState(BattleID string) (bool, string, error) {
+	var AutoFinishVoting bool
+	var ActivePlanID sql.NullString
+
+	if err := d.db.QueryRow(
+		`SELECT auto_finish_voting AND NOT require_ready_to_reveal AND NOT paused,
+			CASE WHEN voting_locked THEN NULL ELSE active_plan_id::TEXT END
+		FROM battles WHERE id = $1;`,
+		BattleID,
+	).Scan(&AutoFinishVoting, &ActivePlanID); err != nil {
+		d.logger.Error("get battle auto finish state query error", zap.Error(err))
+		return false, "", errors.New("unable to get battle auto finish state")
+	}
+
+	return AutoFinishVoting, ActivePlanID.String, nil
+}
+
+// PlanAllVoted checks whether every active non spectator participant has voted on the plan,
+// so disconnected users and spectators don't hold up voting
+func (d *Database) PlanAllVoted(BattleID string, PlanID string) (bool, error) {
+	var ParticipantCount, VotedCount int
+
+	if err := d.db.QueryRow(
+		`SELECT COUNT(*),
+			COUNT(*) FILTER (WHERE p.votes @> jsonb_build_array(jsonb_build_object('warriorId', bu.user_id::TEXT)))
+		FROM battles_users bu
+		JOIN plans p ON p.id = $2 AND p.battle_id = bu.battle_id
+		WHERE bu.battle_id = $1 AND bu.active = true AND bu.spectator = false;`,
+		BattleID,
+		PlanID,
+	).Scan(&ParticipantCount, &VotedCount); err != nil {
+		d.logger.Error("get plan all voted query error", zap.Error(err))
+		return false, errors.New("unable to get plan votes")
+	}
+
+	return ParticipantCount > 0 && VotedCount >= ParticipantCount, nil
+}
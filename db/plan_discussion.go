@@ -0,0 +1,219 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxPlanDiscussionEntryLength the max number of characters of a discussion transcript entry
+	maxPlanDiscussionEntryLength = 5000
+	// maxPlanDiscussionEntries the max number of discussion transcript entries per plan
+	maxPlanDiscussionEntries = 500
+)
+
+// planDiscussionQuery selects the plans discussion transcript as a json array oldest first
+const planDiscussionQuery = `SELECT json_agg(json_build_object(
+		'id', pd.id, 'userId', COALESCE(pd.user_id::TEXT, ''), 'userName', COALESCE(u.name, ''), 'content', pd.content,
+		'createdDate', pd.created_date::TIMESTAMPTZ, 'updatedDate', pd.updated_date::TIMESTAMPTZ
+	) ORDER BY pd.created_date)
+	FROM plan_discussion pd LEFT JOIN users u ON u.id = pd.user_id
+	WHERE pd.plan_id = plans.id`
+
+// validatePlanDiscussionEntry validates the discussion entry content and the plans current entry count
+func validatePlanDiscussionEntry(Content string, EntryCount int) error {
+	if strings.TrimSpace(Content) == "" {
+		return errors.New("DISCUSSION_ENTRY_REQUIRED")
+	}
+	if len([]rune(Content)) > maxPlanDiscussionEntryLength {
+		return errors.New("DISCUSSION_ENTRY_TOO_LONG")
+	}
+	if EntryCount >= maxPlanDiscussionEntries {
+		return errors.New("DISCUSSION_LIMIT_REACHED")
+	}
+
+	return nil
+}
+
+// GetPlanDiscussion gets the plans discussion transcript oldest first
+func (d *Database) GetPlanDiscussion(PlanID string) []*model.PlanDiscussionEntry {
+	var entries = make([]*model.PlanDiscussionEntry, 0)
+	rows, err := d.db.Query(
+		`SELECT pd.id, COALESCE(pd.user_id::TEXT, ''), COALESCE(u.name, ''), pd.content, pd.created_date, pd.updated_date
+		FROM plan_discussion pd
+		LEFT JOIN users u ON u.id = pd.user_id
+		WHERE pd.plan_id = $1
+		ORDER BY pd.created_date;`,
+		PlanID,
+	)
+	if err != nil {
+		d.logger.Error("get plan discussion query error", zap.Error(err))
+		return entries
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e model.PlanDiscussionEntry
+		if err := rows.Scan(&e.Id, &e.UserId, &e.UserName, &e.Content, &e.CreatedDate, &e.UpdatedDate); err != nil {
+			d.logger.Error("get plan discussion scan error", zap.Error(err))
+			continue
+		}
+		entries = append(entries, &e)
+	}
+
+	return entries
+}
+
+// confirmNoteTaker confirms the user is a battle leader or the battles designated note-taker
+func (d *Database) confirmNoteTaker(BattleID string, UserID string) error {
+	var NoteTakerID sql.NullString
+	if err := d.db.QueryRow(
+		`SELECT note_taker_id FROM battles WHERE id = $1;`,
+		BattleID,
+	).Scan(&NoteTakerID); err != nil {
+		d.logger.Error("get battle note taker query error", zap.Error(err))
+		return errors.New("BATTLE_NOT_FOUND")
+	}
+
+	if NoteTakerID.String != UserID {
+		if err := d.ConfirmLeader(BattleID, UserID); err != nil {
+			return errors.New("REQUIRES_LEADER_OR_NOTE_TAKER")
+		}
+	}
+
+	return nil
+}
+
+// SetBattleNoteTaker designates the battle user that can take discussion notes alongside the leaders, empty clears it
+func (d *Database) SetBattleNoteTaker(BattleID string, NoteTakerID string) error {
+	var NoteTaker sql.NullString
+	if NoteTakerID != "" {
+		var InBattle bool
+		if err := d.db.QueryRow(
+			`SELECT EXISTS(SELECT 1 FROM battles_users WHERE battle_id = $1 AND user_id = $2 AND abandoned = false);`,
+			BattleID,
+			NoteTakerID,
+		).Scan(&InBattle); err != nil || !InBattle {
+			return errors.New("NOTE_TAKER_NOT_IN_BATTLE")
+		}
+		NoteTaker = sql.NullString{String: NoteTakerID, Valid: true}
+	}
+
+	if _, err := d.db.Exec(
+		`UPDATE battles SET note_taker_id = $2, updated_date = NOW() WHERE id = $1;`,
+		BattleID,
+		NoteTaker,
+	); err != nil {
+		d.logger.Error("set battle note taker query error", zap.Error(err))
+		return errors.New("unable to set note taker")
+	}
+
+	return nil
+}
+
+// AddPlanDiscussionEntry appends a markdown note to the plans discussion transcript,
+// only a battle leader or the designated note-taker can take notes
+func (d *Database) AddPlanDiscussionEntry(BattleID string, UserID string, PlanID string, Content string) ([]*model.PlanDiscussionEntry, error) {
+	if err := d.confirmNoteTaker(BattleID, UserID); err != nil {
+		return nil, err
+	}
+
+	var EntryCount int
+	if err := d.db.QueryRow(
+		`SELECT COUNT(pd.id) FROM plans p LEFT JOIN plan_discussion pd ON pd.plan_id = p.id
+		WHERE p.id = $2 AND p.battle_id = $1 GROUP BY p.id;`,
+		BattleID,
+		PlanID,
+	).Scan(&EntryCount); err != nil {
+		d.logger.Error("get plan discussion count error", zap.Error(err))
+		return nil, errors.New("PLAN_NOT_FOUND")
+	}
+
+	SanitizedContent := d.htmlSanitizerPolicy.Sanitize(Content)
+	if err := validatePlanDiscussionEntry(SanitizedContent, EntryCount); err != nil {
+		return nil, err
+	}
+
+	if _, err := d.db.Exec(
+		`INSERT INTO plan_discussion (plan_id, user_id, content) VALUES ($1, $2, $3);`,
+		PlanID,
+		UserID,
+		SanitizedContent,
+	); err != nil {
+		d.logger.Error("insert plan discussion entry error", zap.Error(err))
+		return nil, errors.New("unable to add discussion entry")
+	}
+
+	return d.GetPlanDiscussion(PlanID), nil
+}
+
+// getPlanDiscussionEntryAuthor gets the discussion entries plan and author making sure
+// the user is its author or a battle leader
+func (d *Database) getPlanDiscussionEntryAuthor(BattleID string, UserID string, EntryID string) (string, error) {
+	var PlanID string
+	var AuthorID sql.NullString
+	if err := d.db.QueryRow(
+		`SELECT pd.plan_id, pd.user_id FROM plan_discussion pd
+		JOIN plans p ON p.id = pd.plan_id
+		WHERE pd.id = $2 AND p.battle_id = $1;`,
+		BattleID,
+		EntryID,
+	).Scan(&PlanID, &AuthorID); err != nil {
+		d.logger.Error("get plan discussion entry error", zap.Error(err))
+		return "", errors.New("DISCUSSION_ENTRY_NOT_FOUND")
+	}
+
+	if AuthorID.String != UserID {
+		if err := d.ConfirmLeader(BattleID, UserID); err != nil {
+			return "", errors.New("REQUIRES_AUTHOR_OR_LEADER")
+		}
+	}
+
+	return PlanID, nil
+}
+
+// EditPlanDiscussionEntry edits a discussion transcript entry, only its author or a battle leader can edit it
+func (d *Database) EditPlanDiscussionEntry(BattleID string, UserID string, EntryID string, Content string) (string, []*model.PlanDiscussionEntry, error) {
+	PlanID, err := d.getPlanDiscussionEntryAuthor(BattleID, UserID, EntryID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	SanitizedContent := d.htmlSanitizerPolicy.Sanitize(Content)
+	if err := validatePlanDiscussionEntry(SanitizedContent, 0); err != nil {
+		return "", nil, err
+	}
+
+	if _, err := d.db.Exec(
+		`UPDATE plan_discussion SET content = $2, updated_date = NOW() WHERE id = $1;`,
+		EntryID,
+		SanitizedContent,
+	); err != nil {
+		d.logger.Error("update plan discussion entry error", zap.Error(err))
+		return "", nil, errors.New("unable to edit discussion entry")
+	}
+
+	return PlanID, d.GetPlanDiscussion(PlanID), nil
+}
+
+// DeletePlanDiscussionEntry deletes a discussion transcript entry, only its author or a battle leader can delete it
+func (d *Database) DeletePlanDiscussionEntry(BattleID string, UserID string, EntryID string) (string, []*model.PlanDiscussionEntry, error) {
+	PlanID, err := d.getPlanDiscussionEntryAuthor(BattleID, UserID, EntryID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if _, err := d.db.Exec(
+		`DELETE FROM plan_discussion WHERE id = $1;`,
+		EntryID,
+	); err != nil {
+		d.logger.Error("delete plan discussion entry error", zap.Error(err))
+		return "", nil, errors.New("unable to delete discussion entry")
+	}
+
+	return PlanID, d.GetPlanDiscussion(PlanID), nil
+}
@@ -0,0 +1,102 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+
+	"go.uber.org/zap"
+)
+
+// planLimitQuery resolves the teams plan limit override, falling back to its organizations
+const planLimitQuery = `SELECT COALESCE(t.max_plans_per_battle, o.max_plans_per_battle)
+	FROM team t
+	LEFT JOIN organization_team ot ON ot.team_id = t.id
+	LEFT JOIN department_team dt ON dt.team_id = t.id
+	LEFT JOIN organization_department od ON od.id = dt.department_id
+	LEFT JOIN organization o ON o.id = COALESCE(ot.organization_id, od.organization_id)
+	WHERE t.id = $1 LIMIT 1;`
+
+// GetTeamPlanLimit gets the max plans per battle for the team, using the team or organization override
+// when set otherwise the configured default, 0 is unlimited
+func (d *Database) GetTeamPlanLimit(TeamID string) int {
+	var Limit sql.NullInt64
+
+	if TeamID != "" {
+		if err := d.db.QueryRow(planLimitQuery, TeamID).Scan(&Limit); err != nil && err != sql.ErrNoRows {
+			d.logger.Error("get team plan limit query error", zap.Error(err))
+		}
+	}
+
+	if Limit.Valid {
+		return int(Limit.Int64)
+	}
+
+	return d.config.MaxPlansPerBattle
+}
+
+// GetBattlePlanLimit gets the max plans for the battle based on its team association
+func (d *Database) GetBattlePlanLimit(BattleID string) int {
+	var TeamID string
+
+	if err := d.db.QueryRow(
+		`SELECT team_id FROM team_battle WHERE battle_id = $1 LIMIT 1;`,
+		BattleID,
+	).Scan(&TeamID); err != nil && err != sql.ErrNoRows {
+		d.logger.Error("get battle team query error", zap.Error(err))
+	}
+
+	return d.GetTeamPlanLimit(TeamID)
+}
+
+// CheckBattlePlanCapacity returns PLAN_LIMIT_REACHED error when adding the number of plans
+// would exceed the battles plan limit
+func (d *Database) CheckBattlePlanCapacity(BattleID string, Adding int) error {
+	var Count int
+
+	if err := d.db.QueryRow(
+		`SELECT COUNT(*) FROM plans WHERE battle_id = $1;`,
+		BattleID,
+	).Scan(&Count); err != nil {
+		d.logger.Error("get battle plan count query error", zap.Error(err))
+		return errors.New("unable to get battle plan count")
+	}
+
+	if planLimitExceeded(d.GetBattlePlanLimit(BattleID), Count, Adding) {
+		return errors.New("PLAN_LIMIT_REACHED")
+	}
+
+	return nil
+}
+
+// planLimitExceeded checks whether adding plans to the existing count exceeds the limit, 0 is unlimited
+func planLimitExceeded(Limit int, Count int, Adding int) bool {
+	return Limit > 0 && Count+Adding > Limit
+}
+
+// TeamSetPlanLimit sets the teams max plans per battle override, nil reverts to the default
+func (d *Database) TeamSetPlanLimit(TeamID string, Limit *int) error {
+	if _, err := d.db.Exec(
+		`UPDATE team SET max_plans_per_battle = $2, updated_date = NOW() WHERE id = $1;`,
+		TeamID,
+		Limit,
+	); err != nil {
+		d.logger.Error("set team plan limit query error", zap.Error(err))
+		return errors.New("unable to set team plan limit")
+	}
+
+	return nil
+}
+
+// OrganizationSetPlanLimit sets the organizations max plans per battle override, nil reverts to the default
+func (d *Database) OrganizationSetPlanLimit(OrgID string, Limit *int) error {
+	if _, err := d.db.Exec(
+		`UPDATE organization SET max_plans_per_battle = $2, updated_date = NOW() WHERE id = $1;`,
+		OrgID,
+		Limit,
+	); err != nil {
+		d.logger.Error("set organization plan limit query error", zap.Error(err))
+		return errors.New("unable to set organization plan limit")
+	}
+
+	return nil
+}
@@ -0,0 +1,152 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"go.uber.org/zap"
+)
+
+// mergePlan the fields of a plan relevant to merging
+type mergePlan struct {
+	Name        string
+	Description string
+	Points      string
+	Active      bool
+}
+
+// validatePlanMerge makes sure the plans can be merged, a plan can't be merged with itself or while
+// being voted on, and finalized plans can only be merged when their points agree
+func validatePlanMerge(PrimaryID string, SecondaryID string, Primary mergePlan, Secondary mergePlan) error {
+	if PrimaryID == SecondaryID {
+		return errors.New("MERGE_SAME_PLAN")
+	}
+	if Primary.Active || Secondary.Active {
+		return errors.New("PLAN_VOTING_ACTIVE")
+	}
+	if Primary.Points != "" && Secondary.Points != "" && Primary.Points != Secondary.Points {
+		return errors.New("PLANS_FINALIZED_DIFFERENT_POINTS")
+	}
+
+	return nil
+}
+
+// mergedPlanDescription concatenates the plan descriptions
+func mergedPlanDescription(Primary string, Secondary string) string {
+	if Primary == "" {
+		return Secondary
+	}
+	if Secondary == "" {
+		return Primary
+	}
+
+	return Primary + "\n\n" + Secondary
+}
+
+// MergeBattlePlans merges a duplicate plan into the primary plan, keeping the primary plans votes while
+// archiving the secondary plans votes into the primarys vote history, then deletes the secondary plan
+func (d *Database) MergeBattlePlans(BattleID string, PrimaryID string, SecondaryID string) ([]*model.Plan, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		d.logger.Error("merge battle plans transaction error", zap.Error(err))
+		return nil, errors.New("unable to merge plans")
+	}
+	defer tx.Rollback()
+
+	getPlan := func(PlanID string) (mergePlan, error) {
+		var p mergePlan
+		var Description sql.NullString
+		err := tx.QueryRow(
+			`SELECT name, description, points, active FROM plans WHERE id = $2 AND battle_id = $1 FOR UPDATE;`,
+			BattleID,
+			PlanID,
+		).Scan(&p.Name, &Description, &p.Points, &p.Active)
+		p.Description = Description.String
+
+		return p, err
+	}
+
+	Primary, err := getPlan(PrimaryID)
+	if err != nil {
+		d.logger.Error("merge battle plans get primary plan error", zap.Error(err))
+		return nil, errors.New("PLAN_NOT_FOUND")
+	}
+	Secondary, err := getPlan(SecondaryID)
+	if err != nil {
+		d.logger.Error("merge battle plans get secondary plan error", zap.Error(err))
+		return nil, errors.New("PLAN_NOT_FOUND")
+	}
+
+	if err := validatePlanMerge(PrimaryID, SecondaryID, Primary, Secondary); err != nil {
+		return nil, err
+	}
+
+	Points := Primary.Points
+	if Points == "" {
+		Points = Secondary.Points
+	}
+
+	if _, err := tx.Exec(
+		`INSERT INTO plan_vote_history (plan_id, merged_plan_name, merged_plan_points, votes)
+		SELECT $1, name, points, votes FROM plans WHERE id = $2;`,
+		PrimaryID,
+		SecondaryID,
+	); err != nil {
+		d.logger.Error("merge battle plans archive votes error", zap.Error(err))
+		return nil, errors.New("unable to merge plans")
+	}
+
+	if _, err := tx.Exec(
+		`UPDATE plan_vote_history SET plan_id = $1 WHERE plan_id = $2;`,
+		PrimaryID,
+		SecondaryID,
+	); err != nil {
+		d.logger.Error("merge battle plans move vote history error", zap.Error(err))
+		return nil, errors.New("unable to merge plans")
+	}
+
+	if _, err := tx.Exec(
+		`UPDATE plan_acceptance_criterion SET plan_id = $1, updated_date = NOW() WHERE plan_id = $2;`,
+		PrimaryID,
+		SecondaryID,
+	); err != nil {
+		d.logger.Error("merge battle plans move acceptance criteria error", zap.Error(err))
+		return nil, errors.New("unable to merge plans")
+	}
+
+	if _, err := tx.Exec(
+		`UPDATE plans SET description = $2, points = $3, updated_date = NOW() WHERE id = $1;`,
+		PrimaryID,
+		mergedPlanDescription(Primary.Description, Secondary.Description),
+		Points,
+	); err != nil {
+		d.logger.Error("merge battle plans update primary plan error", zap.Error(err))
+		return nil, errors.New("unable to merge plans")
+	}
+
+	if _, err := tx.Exec(
+		`UPDATE battles SET current_plan_id = $2, updated_date = NOW() WHERE id = $1 AND current_plan_id = $3;`,
+		BattleID,
+		PrimaryID,
+		SecondaryID,
+	); err != nil {
+		d.logger.Error("merge battle plans update current plan error", zap.Error(err))
+		return nil, errors.New("unable to merge plans")
+	}
+
+	if _, err := tx.Exec(
+		`DELETE FROM plans WHERE id = $1;`,
+		SecondaryID,
+	); err != nil {
+		d.logger.Error("merge battle plans delete secondary plan error", zap.Error(err))
+		return nil, errors.New("unable to merge plans")
+	}
+
+	if err := tx.Commit(); err != nil {
+		d.logger.Error("merge battle plans commit error", zap.Error(err))
+		return nil, errors.New("unable to merge plans")
+	}
+
+	return d.GetPlans(BattleID, ""), nil
+}
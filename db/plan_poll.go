@@ -0,0 +1,121 @@
+package db
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"go.uber.org/zap"
+)
+
+// maxPlanPollQuestionLength the max number of characters of a plan poll question
+const maxPlanPollQuestionLength = 256
+
+// planPollResponses the allowed plan poll responses
+var planPollResponses = []string{"yes", "no", "unsure"}
+
+// validatePlanPollQuestion validates the plan poll question
+func validatePlanPollQuestion(Question string) error {
+	if strings.TrimSpace(Question) == "" {
+		return errors.New("POLL_QUESTION_REQUIRED")
+	}
+	if len([]rune(Question)) > maxPlanPollQuestionLength {
+		return errors.New("POLL_QUESTION_TOO_LONG")
+	}
+
+	return nil
+}
+
+// pollNeedsClarification whether at least a third of the responses are unsure,
+// signaling the plan needs clarification before estimating
+func pollNeedsClarification(Poll *model.PlanPoll) bool {
+	total := Poll.Yes + Poll.No + Poll.Unsure
+
+	return total > 0 && Poll.Unsure*3 >= total
+}
+
+// StartPlanPoll starts a warm-up poll on the plan replacing any previous poll
+func (d *Database) StartPlanPoll(BattleID string, PlanID string, Question string) ([]*model.Plan, error) {
+	SanitizedQuestion := d.htmlSanitizerPolicy.Sanitize(Question)
+	if err := validatePlanPollQuestion(SanitizedQuestion); err != nil {
+		return nil, err
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		d.logger.Error("start plan poll transaction error", zap.Error(err))
+		return nil, errors.New("unable to start plan poll")
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(
+		`INSERT INTO plan_poll (plan_id, question)
+		SELECT id, $3 FROM plans WHERE id = $2 AND battle_id = $1
+		ON CONFLICT (plan_id) DO UPDATE SET question = EXCLUDED.question, open = true, updated_date = NOW();`,
+		BattleID,
+		PlanID,
+		SanitizedQuestion,
+	)
+	if err != nil {
+		d.logger.Error("start plan poll query error", zap.Error(err))
+		return nil, errors.New("unable to start plan poll")
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return nil, errors.New("PLAN_NOT_FOUND")
+	}
+
+	if _, err := tx.Exec(`DELETE FROM plan_poll_response WHERE plan_id = $1;`, PlanID); err != nil {
+		d.logger.Error("clear plan poll responses query error", zap.Error(err))
+		return nil, errors.New("unable to start plan poll")
+	}
+
+	if err := tx.Commit(); err != nil {
+		d.logger.Error("start plan poll commit error", zap.Error(err))
+		return nil, errors.New("unable to start plan poll")
+	}
+
+	return d.GetPlans(BattleID, ""), nil
+}
+
+// RespondPlanPoll sets the users response to the plans open poll
+func (d *Database) RespondPlanPoll(BattleID string, PlanID string, UserID string, Response string) ([]*model.Plan, error) {
+	if !contains(planPollResponses, Response) {
+		return nil, errors.New("INVALID_POLL_RESPONSE")
+	}
+
+	res, err := d.db.Exec(
+		`INSERT INTO plan_poll_response (plan_id, user_id, response)
+		SELECT pp.plan_id, $3, $4 FROM plan_poll pp
+		JOIN plans p ON p.id = pp.plan_id
+		WHERE pp.plan_id = $2 AND p.battle_id = $1 AND pp.open = true
+		ON CONFLICT (plan_id, user_id) DO UPDATE SET response = EXCLUDED.response;`,
+		BattleID,
+		PlanID,
+		UserID,
+		Response,
+	)
+	if err != nil {
+		d.logger.Error("respond plan poll query error", zap.Error(err))
+		return nil, errors.New("unable to respond to plan poll")
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return nil, errors.New("POLL_NOT_OPEN")
+	}
+
+	return d.GetPlans(BattleID, ""), nil
+}
+
+// ClosePlanPoll closes the plans poll to further responses keeping the results
+func (d *Database) ClosePlanPoll(BattleID string, PlanID string) ([]*model.Plan, error) {
+	if _, err := d.db.Exec(
+		`UPDATE plan_poll SET open = false, updated_date = NOW()
+		WHERE plan_id = (SELECT id FROM plans WHERE id = $2 AND battle_id = $1);`,
+		BattleID,
+		PlanID,
+	); err != nil {
+		d.logger.Error("close plan poll query error", zap.Error(err))
+		return nil, errors.New("unable to close plan poll")
+	}
+
+	return d.GetPlans(BattleID, ""), nil
+}
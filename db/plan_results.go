@@ -0,0 +1,102 @@
+package db
+
+import (
+	"sort"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+)
+
+// UnsureVote the reserved vote of a participant who doesn't understand the plan well enough to estimate it
+const UnsureVote = "?"
+
+// isReservedVote whether the vote is one of the reserved non estimate votes, accepted whatever the battles point values
+func (d *Database) isReservedVote(VoteValue string) bool {
+	return VoteValue == UnsureVote || (d.config.AbstainVote != "" && VoteValue == d.config.AbstainVote)
+}
+
+// computePlanVoteResults summarizes the plans votes, unsure and abstain votes are counted separately
+// and left out of the estimate stats. The average, median and spread are only computed when every
+// estimate is numeric, non numeric scales (e.g. t-shirt sizes) only get the mode
+func computePlanVoteResults(Votes []*model.Vote, AbstainVote string) *model.PlanVoteResults {
+	var results = &model.PlanVoteResults{
+		Mode: make([]string, 0),
+	}
+	var Estimates []float64
+	var Numeric = true
+	var Counts = make(map[string]int)
+
+	for _, vote := range Votes {
+		switch {
+		case vote.VoteValue == UnsureVote:
+			results.UnsureCount++
+		case AbstainVote != "" && vote.VoteValue == AbstainVote:
+			results.AbstainCount++
+		default:
+			results.EstimateCount++
+			Counts[vote.VoteValue]++
+			if Estimate, ok := parsePlanPoints(vote.VoteValue); ok {
+				Estimates = append(Estimates, Estimate)
+			} else {
+				Numeric = false
+			}
+		}
+	}
+	if results.EstimateCount == 0 {
+		return results
+	}
+
+	results.Mode = voteModes(Counts)
+	results.Consensus = len(Counts) == 1
+	if !Numeric {
+		return results
+	}
+
+	sort.Float64s(Estimates)
+	var Sum float64
+	for _, Estimate := range Estimates {
+		Sum += Estimate
+	}
+	Average := Sum / float64(len(Estimates))
+	Median := Estimates[len(Estimates)/2]
+	if len(Estimates)%2 == 0 {
+		Median = (Estimates[len(Estimates)/2-1] + Median) / 2
+	}
+	Min, Max := Estimates[0], Estimates[len(Estimates)-1]
+	results.Average = &Average
+	results.Median = &Median
+	results.Min = &Min
+	results.Max = &Max
+	// equal estimates written differently e.g. 0.5 and 1/2 are still a consensus
+	results.Consensus = Min == Max
+
+	return results
+}
+
+// voteModes gets the most common votes, ties in ascending order numerically when numeric
+func voteModes(Counts map[string]int) []string {
+	var Modes = make([]string, 0)
+	var Top int
+
+	for Value, Count := range Counts {
+		switch {
+		case Count > Top:
+			Top = Count
+			Modes = append(Modes[:0], Value)
+		case Count == Top:
+			Modes = append(Modes, Value)
+		}
+	}
+	sort.Slice(Modes, func(i, j int) bool {
+		a, aNumeric := parsePlanPoints(Modes[i])
+		b, bNumeric := parsePlanPoints(Modes[j])
+		if aNumeric && bNumeric {
+			return a < b
+		}
+		if aNumeric != bNumeric {
+			return aNumeric
+		}
+		return Modes[i] < Modes[j]
+	})
+
+	return Modes
+}
@@ -0,0 +1,98 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"go.uber.org/zap"
+)
+
+// SetBattleRequireReadyToReveal sets whether all participants must be ready before the votes are revealed
+func (d *Database) SetBattleRequireReadyToReveal(BattleID string, RequireReadyToReveal bool) error {
+	if _, err := d.db.Exec(
+		`UPDATE battles SET require_ready_to_reveal = $2, updated_date = NOW() WHERE id = $1;`,
+		BattleID,
+		RequireReadyToReveal,
+	); err != nil {
+		d.logger.Error("update battle require_ready_to_reveal error", zap.Error(err))
+		return errors.New("unable to set battle require ready to reveal")
+	}
+
+	return nil
+}
+
+// GetBattleRevealState gets whether the battle requires readiness to reveal and its active plan if voting is open
+func (d *Database) GetBattleRevealState(BattleID string) (bool, string, error) {
+	var RequireReadyToReveal bool
+	var ActivePlanID sql.NullString
+
+	if err := d.db.QueryRow(
+		`SELECT require_ready_to_reveal, CASE WHEN voting_locked THEN NULL ELSE active_plan_id::TEXT END
+		FROM battles WHERE id = $1;`,
+		BattleID,
+	).Scan(&RequireReadyToReveal, &ActivePlanID); err != nil {
+		d.logger.Error("get battle reveal state query error", zap.Error(err))
+		return false, "", errors.New("unable to get battle reveal state")
+	}
+
+	return RequireReadyToReveal, ActivePlanID.String, nil
+}
+
+// SetPlanRevealReady sets whether the user is ready to reveal the plans votes
+func (d *Database) SetPlanRevealReady(PlanID string, UserID string, Ready bool) error {
+	var err error
+	if Ready {
+		_, err = d.db.Exec(
+			`INSERT INTO plan_reveal_ready (plan_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING;`,
+			PlanID,
+			UserID,
+		)
+	} else {
+		_, err = d.db.Exec(
+			`DELETE FROM plan_reveal_ready WHERE plan_id = $1 AND user_id = $2;`,
+			PlanID,
+			UserID,
+		)
+	}
+	if err != nil {
+		d.logger.Error("set plan reveal ready query error", zap.Error(err))
+		return errors.New("unable to set ready to reveal")
+	}
+
+	return nil
+}
+
+// GetPlanRevealReadiness gets the ready to reveal users among the battles active non spectator participants,
+// so disconnected users no longer count towards readiness
+func (d *Database) GetPlanRevealReadiness(BattleID string, PlanID string) (*model.RevealReadiness, error) {
+	var ReadyUsers string
+	var rr = &model.RevealReadiness{
+		PlanId:       PlanID,
+		ReadyUserIds: make([]string, 0),
+	}
+
+	if err := d.db.QueryRow(
+		`SELECT COUNT(*),
+			COALESCE(json_agg(bu.user_id) FILTER (WHERE prr.user_id IS NOT NULL), '[]')
+		FROM battles_users bu
+		LEFT JOIN plan_reveal_ready prr ON prr.plan_id = $2 AND prr.user_id = bu.user_id
+		WHERE bu.battle_id = $1 AND bu.active = true AND bu.spectator = false;`,
+		BattleID,
+		PlanID,
+	).Scan(&rr.ParticipantCount, &ReadyUsers); err != nil {
+		d.logger.Error("get plan reveal readiness query error", zap.Error(err))
+		return nil, errors.New("unable to get reveal readiness")
+	}
+	_ = json.Unmarshal([]byte(ReadyUsers), &rr.ReadyUserIds)
+	rr.ReadyCount = len(rr.ReadyUserIds)
+	rr.AllReady = allReadyToReveal(rr)
+
+	return rr, nil
+}
+
+// allReadyToReveal checks that every participant is ready to reveal
+func allReadyToReveal(rr *model.RevealReadiness) bool {
+	return rr.ParticipantCount > 0 && rr.ReadyCount >= rr.ParticipantCount
+}
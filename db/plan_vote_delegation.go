@@ -0,0 +1,248 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"go.uber.org/zap"
+)
+
+// setDelegatedVoteQuery sets the users vote in the plans votes, unlike set_user_vote it doesn't
+// touch the users last active as they didn't cast it themselves
+const setDelegatedVoteQuery = `UPDATE plans SET votes = COALESCE(
+		(SELECT jsonb_agg(v) FROM jsonb_array_elements(votes) v WHERE v->>'warriorId' <> $2), '[]'::jsonb
+	) || jsonb_build_array(jsonb_build_object('warriorId', $2::TEXT, 'vote', $3::TEXT))
+	WHERE id = $1;`
+
+// removeDelegatedVoteQuery removes the users vote from the plans votes
+const removeDelegatedVoteQuery = `UPDATE plans SET votes = COALESCE(
+		(SELECT jsonb_agg(v) FROM jsonb_array_elements(votes) v WHERE v->>'warriorId' <> $2), '[]'::jsonb
+	)
+	WHERE id = $1;`
+
+// attributeDelegatedVotes marks the plans votes cast by a delegate so they're attributed to them
+// and can be told apart from votes the users cast themselves
+func attributeDelegatedVotes(Plan *model.Plan) {
+	delegates := make(map[string]string)
+	for _, dg := range Plan.VoteDelegations {
+		if dg.Voted {
+			delegates[dg.UserId] = dg.DelegateId
+		}
+	}
+
+	for _, v := range Plan.Votes {
+		v.DelegateId = delegates[v.UserId]
+	}
+}
+
+// DelegatePlanVote delegates the users vote for the active plan to another participant,
+// the delegates vote counts for both until the votes are revealed
+func (d *Database) DelegatePlanVote(BattleID string, UserID string, PlanID string, DelegateID string) ([]*model.Plan, error) {
+	if DelegateID == "" || DelegateID == UserID {
+		return nil, errors.New("INVALID_VOTE_DELEGATE")
+	}
+
+	// spectators can't vote so they've no vote to delegate
+	if err := d.confirmBattleVoter(BattleID, UserID); err != nil {
+		return nil, err
+	}
+
+	var Active bool
+	if err := d.db.QueryRow(
+		`SELECT active FROM plans WHERE id = $1 AND battle_id = $2;`,
+		PlanID,
+		BattleID,
+	).Scan(&Active); err != nil {
+		d.logger.Error("get plan for vote delegation query error", zap.Error(err))
+		return nil, errors.New("PLAN_NOT_FOUND")
+	}
+	if !Active {
+		return nil, errors.New("PLAN_VOTING_NOT_ACTIVE")
+	}
+
+	var InBattle bool
+	if err := d.db.QueryRow(
+		`SELECT EXISTS(SELECT 1 FROM battles_users WHERE battle_id = $1 AND user_id = $2 AND abandoned = false AND spectator = false);`,
+		BattleID,
+		DelegateID,
+	).Scan(&InBattle); err != nil || !InBattle {
+		return nil, errors.New("VOTE_DELEGATE_NOT_IN_BATTLE")
+	}
+
+	// delegations don't chain, the delegate must be voting for themselves and the user can't be a delegate
+	var Chained bool
+	if err := d.db.QueryRow(
+		`SELECT EXISTS(
+			SELECT 1 FROM plan_vote_delegation WHERE plan_id = $1 AND active = true
+			AND (user_id = $3 OR delegate_id = $2)
+		);`,
+		PlanID,
+		UserID,
+		DelegateID,
+	).Scan(&Chained); err != nil {
+		d.logger.Error("check vote delegation chain query error", zap.Error(err))
+		return nil, errors.New("unable to delegate vote")
+	}
+	if Chained {
+		return nil, errors.New("VOTE_DELEGATION_CHAIN")
+	}
+
+	// the delegates vote so far, if any, is cast for the user straight away
+	var DelegateVote sql.NullString
+	if err := d.db.QueryRow(
+		`SELECT v->>'vote' FROM plans p, jsonb_array_elements(p.votes) v WHERE p.id = $1 AND v->>'warriorId' = $2;`,
+		PlanID,
+		DelegateID,
+	).Scan(&DelegateVote); err != nil && err != sql.ErrNoRows {
+		d.logger.Error("get vote delegate vote query error", zap.Error(err))
+		return nil, errors.New("unable to delegate vote")
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		d.logger.Error("delegate vote transaction error", zap.Error(err))
+		return nil, errors.New("unable to delegate vote")
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		`INSERT INTO plan_vote_delegation (plan_id, user_id, delegate_id, vote, voted_date)
+		VALUES ($1, $2, $3, $4, CASE WHEN $4::VARCHAR IS NULL THEN NULL ELSE NOW() END)
+		ON CONFLICT (plan_id, user_id) DO UPDATE SET delegate_id = EXCLUDED.delegate_id, vote = EXCLUDED.vote,
+			voted_date = EXCLUDED.voted_date, active = true, created_date = NOW();`,
+		PlanID,
+		UserID,
+		DelegateID,
+		DelegateVote,
+	); err != nil {
+		d.logger.Error("insert vote delegation query error", zap.Error(err))
+		return nil, errors.New("unable to delegate vote")
+	}
+
+	// the users own vote is replaced by the delegates
+	if DelegateVote.Valid {
+		_, err = tx.Exec(setDelegatedVoteQuery, PlanID, UserID, DelegateVote.String)
+	} else {
+		_, err = tx.Exec(removeDelegatedVoteQuery, PlanID, UserID)
+	}
+	if err != nil {
+		d.logger.Error("set delegated vote query error", zap.Error(err))
+		return nil, errors.New("unable to delegate vote")
+	}
+
+	if err := tx.Commit(); err != nil {
+		d.logger.Error("delegate vote commit error", zap.Error(err))
+		return nil, errors.New("unable to delegate vote")
+	}
+
+	return d.GetPlans(BattleID, ""), nil
+}
+
+// RevokePlanVoteDelegation revokes the users active vote delegation for the plan,
+// removing any vote the delegate cast for them
+func (d *Database) RevokePlanVoteDelegation(BattleID string, UserID string, PlanID string) ([]*model.Plan, error) {
+	var Vote sql.NullString
+	if err := d.db.QueryRow(
+		`DELETE FROM plan_vote_delegation pvd USING plans p
+		WHERE pvd.plan_id = $2 AND pvd.user_id = $3 AND pvd.active = true AND p.id = pvd.plan_id AND p.battle_id = $1
+		RETURNING pvd.vote;`,
+		BattleID,
+		PlanID,
+		UserID,
+	).Scan(&Vote); err != nil {
+		d.logger.Error("revoke vote delegation query error", zap.Error(err))
+		return nil, errors.New("VOTE_DELEGATION_NOT_FOUND")
+	}
+
+	if Vote.Valid {
+		if _, err := d.db.Exec(removeDelegatedVoteQuery, PlanID, UserID); err != nil {
+			d.logger.Error("remove delegated vote query error", zap.Error(err))
+			return nil, errors.New("unable to revoke vote delegation")
+		}
+	}
+
+	return d.GetPlans(BattleID, ""), nil
+}
+
+// applyDelegatedVotes casts the delegates vote for the users that delegated to them,
+// a user voting for themselves ends their own delegation
+func (d *Database) applyDelegatedVotes(UserID string, PlanID string, VoteValue string) {
+	if _, err := d.db.Exec(
+		`DELETE FROM plan_vote_delegation WHERE plan_id = $1 AND user_id = $2 AND active = true;`,
+		PlanID,
+		UserID,
+	); err != nil {
+		d.logger.Error("end own vote delegation query error", zap.Error(err))
+	}
+
+	rows, err := d.db.Query(
+		`UPDATE plan_vote_delegation SET vote = $3, voted_date = NOW()
+		WHERE plan_id = $1 AND delegate_id = $2 AND active = true
+		RETURNING user_id;`,
+		PlanID,
+		UserID,
+		VoteValue,
+	)
+	if err != nil {
+		d.logger.Error("apply delegated votes query error", zap.Error(err))
+		return
+	}
+	defer rows.Close()
+
+	var Delegators []string
+	for rows.Next() {
+		var DelegatorID string
+		if err := rows.Scan(&DelegatorID); err == nil {
+			Delegators = append(Delegators, DelegatorID)
+		}
+	}
+
+	for _, DelegatorID := range Delegators {
+		if _, err := d.db.Exec(setDelegatedVoteQuery, PlanID, DelegatorID, VoteValue); err != nil {
+			d.logger.Error("set delegated vote query error", zap.Error(err))
+		}
+	}
+}
+
+// retractDelegatedVotes retracts the votes the delegate cast for the users that delegated to them
+func (d *Database) retractDelegatedVotes(UserID string, PlanID string) {
+	rows, err := d.db.Query(
+		`UPDATE plan_vote_delegation SET vote = NULL, voted_date = NULL
+		WHERE plan_id = $1 AND delegate_id = $2 AND active = true AND vote IS NOT NULL
+		RETURNING user_id;`,
+		PlanID,
+		UserID,
+	)
+	if err != nil {
+		d.logger.Error("retract delegated votes query error", zap.Error(err))
+		return
+	}
+	defer rows.Close()
+
+	var Delegators []string
+	for rows.Next() {
+		var DelegatorID string
+		if err := rows.Scan(&DelegatorID); err == nil {
+			Delegators = append(Delegators, DelegatorID)
+		}
+	}
+
+	for _, DelegatorID := range Delegators {
+		if _, err := d.db.Exec(removeDelegatedVoteQuery, PlanID, DelegatorID); err != nil {
+			d.logger.Error("remove delegated vote query error", zap.Error(err))
+		}
+	}
+}
+
+// expireVoteDelegations ends the battles active vote delegations once votes are revealed,
+// they're kept to attribute the delegated votes
+func (d *Database) expireVoteDelegations(BattleID string) {
+	if _, err := d.db.Exec(
+		`UPDATE plan_vote_delegation SET active = false
+		WHERE active = true AND plan_id IN (SELECT id FROM plans WHERE battle_id = $1);`,
+		BattleID,
+	); err != nil {
+		d.logger.Error("expire vote delegations query error", zap.Error(err))
+	}
+}
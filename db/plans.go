@@ -3,6 +3,7 @@ package db
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 
 	"github.com/StevenWeathers/thunderdome-planning-poker/model"
 	"go.uber.org/zap"
@@ -13,8 +14,28 @@ func (d *Database) GetPlans(BattleID string, UserID string) []*model.Plan {
 	var plans = make([]*model.Plan, 0)
 	planRows, plansErr := d.db.Query(
 		`SELECT
-			id, name, type, reference_id, link, description, acceptance_criteria, points, active, skipped, votestart_time, voteend_time, votes
-			FROM plans WHERE battle_id = $1 ORDER BY created_date
+			id, name, type, reference_id, link, description, acceptance_criteria, points, active, skipped, votestart_time, voteend_time, vote_timer_end, votes,
+			COALESCE(
+				(SELECT json_agg(json_build_object('id', pac.id, 'content', pac.content, 'checked', pac.checked) ORDER BY pac.created_date)
+				FROM plan_acceptance_criterion pac WHERE pac.plan_id = plans.id), '[]'
+			),
+			COALESCE(
+				(SELECT json_build_object(
+					'question', pp.question, 'open', pp.open,
+					'yes', COUNT(ppr.user_id) FILTER (WHERE ppr.response = 'yes'),
+					'no', COUNT(ppr.user_id) FILTER (WHERE ppr.response = 'no'),
+					'unsure', COUNT(ppr.user_id) FILTER (WHERE ppr.response = 'unsure')
+				)
+				FROM plan_poll pp LEFT JOIN plan_poll_response ppr ON ppr.plan_id = pp.plan_id
+				WHERE pp.plan_id = plans.id GROUP BY pp.plan_id), 'null'
+			),
+			COALESCE((`+planDiscussionQuery+`), '[]'),
+			COALESCE((SELECT json_agg(json_build_object(
+				'userId', pvd.user_id, 'delegateId', pvd.delegate_id, 'active', pvd.active, 'voted', pvd.vote IS NOT NULL
+			) ORDER BY pvd.created_date) FROM plan_vote_delegation pvd WHERE pvd.plan_id = plans.id), '[]'),
+			actual_points, position,
+			(SELECT b.permanent_anonymity FROM battles b WHERE b.id = plans.battle_id)
+			FROM plans WHERE battle_id = $1 ORDER BY position
 		`,
 		BattleID,
 	)
@@ -22,17 +43,25 @@ func (d *Database) GetPlans(BattleID string, UserID string) []*model.Plan {
 		defer planRows.Close()
 		for planRows.Next() {
 			var v string
+			var ac string
+			var poll string
+			var discussion string
+			var delegations string
+			var anonymous bool
 			var ReferenceID sql.NullString
 			var Link sql.NullString
 			var Description sql.NullString
 			var AcceptanceCriteria sql.NullString
 			var p = &model.Plan{
-				Votes:   make([]*model.Vote, 0),
-				Active:  false,
-				Skipped: false,
+				Votes:                   make([]*model.Vote, 0),
+				AcceptanceCriteriaItems: make([]*model.PlanAcceptanceCriterion, 0),
+				Discussion:              make([]*model.PlanDiscussionEntry, 0),
+				VoteDelegations:         make([]*model.PlanVoteDelegation, 0),
+				Active:                  false,
+				Skipped:                 false,
 			}
 			if err := planRows.Scan(
-				&p.Id, &p.Name, &p.Type, &ReferenceID, &Link, &Description, &AcceptanceCriteria, &p.Points, &p.Active, &p.Skipped, &p.VoteStartTime, &p.VoteEndTime, &v,
+				&p.Id, &p.Name, &p.Type, &ReferenceID, &Link, &Description, &AcceptanceCriteria, &p.Points, &p.Active, &p.Skipped, &p.VoteStartTime, &p.VoteEndTime, &p.VoteTimerEnd, &v, &ac, &poll, &discussion, &delegations, &p.ActualPoints, &p.Position, &anonymous,
 			); err != nil {
 				d.logger.Error("get battle plans query error", zap.Error(err))
 			} else {
@@ -44,6 +73,32 @@ func (d *Database) GetPlans(BattleID string, UserID string) []*model.Plan {
 				if err != nil {
 					d.logger.Error("get battle plans query scan error", zap.Error(err))
 				}
+				err = json.Unmarshal([]byte(ac), &p.AcceptanceCriteriaItems)
+				if err != nil {
+					d.logger.Error("get battle plans acceptance criteria scan error", zap.Error(err))
+				}
+				err = json.Unmarshal([]byte(poll), &p.Poll)
+				if err != nil {
+					d.logger.Error("get battle plans poll scan error", zap.Error(err))
+				}
+				err = json.Unmarshal([]byte(discussion), &p.Discussion)
+				if err != nil {
+					d.logger.Error("get battle plans discussion scan error", zap.Error(err))
+				}
+				err = json.Unmarshal([]byte(delegations), &p.VoteDelegations)
+				if err != nil {
+					d.logger.Error("get battle plans vote delegations scan error", zap.Error(err))
+				}
+				attributeDelegatedVotes(p)
+				if anonymous {
+					anonymizePlanVotes(p)
+				}
+				if p.Poll != nil {
+					p.Poll.NeedsClarification = pollNeedsClarification(p.Poll)
+				}
+				if !p.Active && len(p.Votes) > 0 {
+					p.Results = computePlanVoteResults(p.Votes, d.config.AbstainVote)
+				}
 
 				// don't send others vote values to client, prevent sneaky devs from peaking at votes
 				for i := range p.Votes {
@@ -75,25 +130,129 @@ func (d *Database) CreatePlan(BattleID string, PlanName string, PlanType string,
 	return plans, nil
 }
 
+// ImportPlans adds the plans to the battle in order, skipping those whose reference ID
+// the battle already has so importing the same stories again doesn't duplicate them
+func (d *Database) ImportPlans(BattleID string, Plans []*model.Plan) ([]*model.Plan, int, error) {
+	var Imported int
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		d.logger.Error("import plans transaction error", zap.Error(err))
+		return nil, 0, errors.New("unable to import plans")
+	}
+	defer tx.Rollback()
+
+	for _, plan := range Plans {
+		res, err := tx.Exec(
+			`INSERT INTO plans (battle_id, name, type, reference_id, link, description, acceptance_criteria)
+			SELECT $1, $2, $3, $4, $5, $6, $7
+			WHERE $4 = '' OR NOT EXISTS (SELECT 1 FROM plans WHERE battle_id = $1 AND reference_id = $4);`,
+			BattleID,
+			plan.Name,
+			plan.Type,
+			plan.ReferenceId,
+			plan.Link,
+			d.htmlSanitizerPolicy.Sanitize(plan.Description),
+			d.htmlSanitizerPolicy.Sanitize(plan.AcceptanceCriteria),
+		)
+		if err != nil {
+			d.logger.Error("import plans insert error", zap.Error(err))
+			return nil, 0, errors.New("unable to import plans")
+		}
+		if rows, _ := res.RowsAffected(); rows > 0 {
+			Imported++
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		d.logger.Error("import plans commit error", zap.Error(err))
+		return nil, 0, errors.New("unable to import plans")
+	}
+
+	return d.GetPlans(BattleID, ""), Imported, nil
+}
+
+// GetUserActiveVotes gets the users own votes for the battles active plan(s), never other users votes
+func (d *Database) GetUserActiveVotes(BattleID string, UserID string) ([]*model.UserPlanVote, error) {
+	var votes = make([]*model.UserPlanVote, 0)
+
+	rows, err := d.db.Query(
+		`SELECT p.id, v->>'vote'
+		FROM plans p, jsonb_array_elements(p.votes) v
+		WHERE p.battle_id = $1 AND p.active = true AND v->>'warriorId' = $2;`,
+		BattleID,
+		UserID,
+	)
+	if err != nil {
+		d.logger.Error("get user active votes query error", zap.Error(err))
+		return nil, errors.New("unable to get user votes")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var v model.UserPlanVote
+		if err := rows.Scan(&v.PlanId, &v.VoteValue); err != nil {
+			d.logger.Error("get user active votes scan error", zap.Error(err))
+			return nil, errors.New("unable to get user votes")
+		}
+		votes = append(votes, &v)
+	}
+
+	return votes, nil
+}
+
 // ActivatePlanVoting sets the plan by ID to active, wipes any previous votes/points, and disables votingLock
 func (d *Database) ActivatePlanVoting(BattleID string, PlanID string) ([]*model.Plan, error) {
+	if _, err := d.db.Exec(
+		`DELETE FROM plan_reveal_ready WHERE plan_id = $1;`, PlanID,
+	); err != nil {
+		d.logger.Error("clear plan reveal ready error", zap.Error(err))
+	}
 	if _, err := d.db.Exec(
 		`call activate_plan_voting($1, $2);`, BattleID, PlanID,
 	); err != nil {
 		d.logger.Error("call activate_plan_voting error", zap.Error(err))
 	}
+	d.clearPlanVoteTimer(PlanID)
+	// votes are wiped so any prior delegations are too
+	if _, err := d.db.Exec(
+		`DELETE FROM plan_vote_delegation WHERE plan_id = $1;`, PlanID,
+	); err != nil {
+		d.logger.Error("clear plan vote delegations error", zap.Error(err))
+	}
 
 	plans := d.GetPlans(BattleID, "")
 
 	return plans, nil
 }
 
-// SetVote sets a users vote for the plan
-func (d *Database) SetVote(BattleID string, UserID string, PlanID string, VoteValue string) (BattlePlans []*model.Plan, AllUsersVoted bool) {
+// SetVote sets a users vote for the plan, rejecting spectators and estimates above the battles estimate cap
+func (d *Database) SetVote(BattleID string, UserID string, PlanID string, VoteValue string) (BattlePlans []*model.Plan, AllUsersVoted bool, err error) {
+	if err := d.confirmBattleVoter(BattleID, UserID); err != nil {
+		return nil, false, err
+	}
+	Cap, err := d.getBattleEstimateCap(BattleID)
+	if err != nil {
+		return nil, false, err
+	}
+	if estimateExceedsCap(VoteValue, Cap) {
+		return nil, false, errors.New("ESTIMATE_EXCEEDS_CAP")
+	}
+	PointValues, err := d.getBattlePointValues(BattleID)
+	if err != nil {
+		return nil, false, err
+	}
+	// battles created before point values were required accept any vote,
+	// the unsure and abstain votes are always accepted
+	if len(PointValues) > 0 && !contains(PointValues, VoteValue) && !d.isReservedVote(VoteValue) {
+		return nil, false, errors.New("INVALID_VOTE")
+	}
+
 	if _, err := d.db.Exec(
 		`call set_user_vote($1, $2, $3);`, PlanID, UserID, VoteValue); err != nil {
 		d.logger.Error("call set_user_vote error", zap.Error(err))
 	}
+	d.applyDelegatedVotes(UserID, PlanID, VoteValue)
 
 	Plans := d.GetPlans(BattleID, "")
 	ActiveUsers := d.GetBattleActiveUsers(BattleID)
@@ -118,7 +277,7 @@ func (d *Database) SetVote(BattleID string, UserID string, PlanID string, VoteVa
 		}
 	}
 
-	return Plans, AllVoted
+	return Plans, AllVoted, nil
 }
 
 // RetractVote removes a users vote for the plan
@@ -128,6 +287,7 @@ func (d *Database) RetractVote(BattleID string, UserID string, PlanID string) ([
 		d.logger.Error("call retract_user_vote error", zap.Error(err))
 		return nil, err
 	}
+	d.retractDelegatedVotes(UserID, PlanID)
 
 	plans := d.GetPlans(BattleID, "")
 
@@ -140,6 +300,11 @@ func (d *Database) EndPlanVoting(BattleID string, PlanID string) ([]*model.Plan,
 		`call end_plan_voting($1, $2);`, BattleID, PlanID); err != nil {
 		d.logger.Error("call end_plan_voting error", zap.Error(err))
 	}
+	d.clearPlanVoteTimer(PlanID)
+	d.expireVoteDelegations(BattleID)
+	if err := d.anonymizeRevealedVotes(BattleID); err != nil {
+		return nil, err
+	}
 
 	plans := d.GetPlans(BattleID, "")
 
@@ -152,6 +317,11 @@ func (d *Database) SkipPlan(BattleID string, PlanID string) ([]*model.Plan, erro
 		`call skip_plan_voting($1, $2);`, BattleID, PlanID); err != nil {
 		d.logger.Error("call skip_plan_voting error", zap.Error(err))
 	}
+	d.clearPlanVoteTimer(PlanID)
+	d.expireVoteDelegations(BattleID)
+	if err := d.anonymizeRevealedVotes(BattleID); err != nil {
+		return nil, err
+	}
 
 	plans := d.GetPlans(BattleID, "")
 
@@ -196,3 +366,82 @@ func (d *Database) FinalizePlan(BattleID string, PlanID string, PlanPoints strin
 
 	return plans, nil
 }
+
+// SetCurrentPlan sets the plan the battle should focus on, returning whether voting should auto start
+func (d *Database) SetCurrentPlan(BattleID string, PlanID string) (bool, error) {
+	var AutoStartVoting bool
+
+	err := d.db.QueryRow(
+		`UPDATE battles SET current_plan_id = p.id, updated_date = NOW()
+		FROM plans p WHERE battles.id = $1 AND p.id = $2 AND p.battle_id = battles.id
+		RETURNING battles.auto_start_voting;`,
+		BattleID,
+		PlanID,
+	).Scan(&AutoStartVoting)
+	if err != nil {
+		d.logger.Error("set battle current_plan_id error", zap.Error(err))
+		return false, errors.New("PLAN_NOT_FOUND")
+	}
+
+	return AutoStartVoting, nil
+}
+
+// GetAdjacentPlanID gets the plan ID before (Step -1) or after (Step 1) the battle's current plan
+func (d *Database) GetAdjacentPlanID(BattleID string, Step int) (string, error) {
+	var CurrentPlanID string
+
+	err := d.db.QueryRow(
+		`SELECT COALESCE(current_plan_id::TEXT, '') FROM battles WHERE id = $1;`,
+		BattleID,
+	).Scan(&CurrentPlanID)
+	if err != nil {
+		d.logger.Error("get battle current_plan_id error", zap.Error(err))
+		return "", errors.New("BATTLE_NOT_FOUND")
+	}
+
+	return adjacentPlanID(d.GetPlans(BattleID, ""), CurrentPlanID, Step)
+}
+
+// adjacentPlanID finds the plan ID Step positions away from the current plan,
+// starting from the first (or last when going back) plan when there is no current plan
+func adjacentPlanID(Plans []*model.Plan, CurrentPlanID string, Step int) (string, error) {
+	if len(Plans) == 0 {
+		return "", errors.New("NO_PLANS")
+	}
+
+	index := -1
+	for i, p := range Plans {
+		if p.Id == CurrentPlanID {
+			index = i
+			break
+		}
+	}
+
+	if index == -1 {
+		if Step < 0 {
+			return Plans[len(Plans)-1].Id, nil
+		}
+		return Plans[0].Id, nil
+	}
+
+	next := index + Step
+	if next < 0 || next >= len(Plans) {
+		return "", errors.New("NO_MORE_PLANS")
+	}
+
+	return Plans[next].Id, nil
+}
+
+// SetBattleAutoStartVoting sets whether voting starts when the battle's current plan changes
+func (d *Database) SetBattleAutoStartVoting(BattleID string, AutoStartVoting bool) error {
+	if _, err := d.db.Exec(
+		`UPDATE battles SET auto_start_voting = $2, updated_date = NOW() WHERE id = $1;`,
+		BattleID,
+		AutoStartVoting,
+	); err != nil {
+		d.logger.Error("update battle auto_start_voting error", zap.Error(err))
+		return errors.New("unable to set battle auto start voting")
+	}
+
+	return nil
+}
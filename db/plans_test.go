@@ -0,0 +1,99 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// newTestDatabase connects to the test database from the DB_ environment, skipping the test when DB_HOST isn't set
+func newTestDatabase(t *testing.T) *Database {
+	Host := os.Getenv("DB_HOST")
+	if Host == "" {
+		t.Skip("DB_HOST not set, skipping database test")
+	}
+	Port, _ := strconv.Atoi(os.Getenv("DB_PORT"))
+	if Port == 0 {
+		Port = 5432
+	}
+
+	return New("", &Config{
+		Host:       Host,
+		Port:       Port,
+		User:       os.Getenv("DB_USER"),
+		Password:   os.Getenv("DB_PASS"),
+		Name:       os.Getenv("DB_NAME"),
+		SSLMode:    "disable",
+		AESHashkey: "therevengers",
+	}, zap.NewNop())
+}
+
+// TestCreatePlanConcurrentPositions adds plans to the same battle concurrently and makes sure
+// every plan gets a unique contiguous position, requires a database so is skipped when DB_HOST isn't set
+func TestCreatePlanConcurrentPositions(t *testing.T) {
+	d := newTestDatabase(t)
+
+	var BattleID string
+	if err := d.db.QueryRow(`INSERT INTO battles (name) VALUES ('plan position stress test') RETURNING id;`).Scan(&BattleID); err != nil {
+		t.Fatalf(`expected battle to be created, got error: %v`, err)
+	}
+	defer d.db.Exec(`DELETE FROM battles WHERE id = $1;`, BattleID)
+
+	PlanCount := 50
+	var wg sync.WaitGroup
+	for i := 0; i < PlanCount; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			d.CreatePlan(BattleID, fmt.Sprintf("plan %d", i), "Story", "", "", "", "")
+		}(i)
+	}
+	wg.Wait()
+
+	Plans := d.GetPlans(BattleID, "")
+	if len(Plans) != PlanCount {
+		t.Fatalf(`expected %d plans, got %d`, PlanCount, len(Plans))
+	}
+	for i, p := range Plans {
+		if p.Position != i+1 {
+			t.Fatalf(`expected plan %d to have position %d, got %d`, i, i+1, p.Position)
+		}
+	}
+}
+
+// TestGetBattleVoteTimerPaused gets the voting timer of a paused battle making sure the remaining time
+// is what was left when paused, requires a database so is skipped when DB_HOST isn't set
+func TestGetBattleVoteTimerPaused(t *testing.T) {
+	d := newTestDatabase(t)
+
+	var BattleID, PlanID string
+	if err := d.db.QueryRow(`INSERT INTO battles (name) VALUES ('vote timer test') RETURNING id;`).Scan(&BattleID); err != nil {
+		t.Fatalf(`expected battle to be created, got error: %v`, err)
+	}
+	defer d.db.Exec(`DELETE FROM battles WHERE id = $1;`, BattleID)
+	if err := d.db.QueryRow(
+		`INSERT INTO plans (battle_id, name, vote_timer_end) VALUES ($1, 'timed plan', NOW() + INTERVAL '90 seconds') RETURNING id;`,
+		BattleID,
+	).Scan(&PlanID); err != nil {
+		t.Fatalf(`expected plan to be created, got error: %v`, err)
+	}
+	if _, err := d.db.Exec(
+		`UPDATE battles SET active_plan_id = $2, voting_locked = false, paused = true, paused_date = NOW() - INTERVAL '30 seconds' WHERE id = $1;`,
+		BattleID,
+		PlanID,
+	); err != nil {
+		t.Fatalf(`expected battle to be paused, got error: %v`, err)
+	}
+
+	Timer, err := d.GetBattleVoteTimer(BattleID)
+	if err != nil || Timer == nil {
+		t.Fatalf(`GetBattleVoteTimer = %v, %v, want a timer`, Timer, err)
+	}
+	if !Timer.Paused || Timer.Remaining != 120 {
+		t.Fatalf(`GetBattleVoteTimer = paused %v with %d seconds remaining, want paused with 120`, Timer.Paused, Timer.Remaining)
+	}
+}
@@ -0,0 +1,73 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// PointScaleCustom the battle creators own list of point values
+	PointScaleCustom = "custom"
+	// maxCustomPointValues the max number of point values in a custom scale
+	maxCustomPointValues = 25
+	// maxPointValueLength the max length of a custom scale point value
+	maxPointValueLength = 8
+)
+
+// pointScales the preset point value scales a battle can use
+var pointScales = map[string][]string{
+	"fibonacci":     {"0", "1", "2", "3", "5", "8", "13", "21", "34", "?"},
+	"tshirt":        {"XS", "S", "M", "L", "XL", "XXL", "?"},
+	"powers_of_two": {"0", "1", "2", "4", "8", "16", "32", "64", "?"},
+}
+
+// ResolvePointScale gets the point values of the scale, the custom scale (or no scale)
+// uses the given values which must be unique, non empty, and within the entry and length caps
+func ResolvePointScale(Scale string, Values []string) ([]string, string, error) {
+	if Scale == "" {
+		Scale = PointScaleCustom
+	}
+	if Preset, ok := pointScales[Scale]; ok {
+		return append([]string(nil), Preset...), Scale, nil
+	}
+	if Scale != PointScaleCustom {
+		return nil, "", errors.New("INVALID_POINT_SCALE")
+	}
+
+	if len(Values) == 0 || len(Values) > maxCustomPointValues {
+		return nil, "", errors.New("INVALID_POINT_VALUES")
+	}
+	var resolved = make([]string, 0, len(Values))
+	seen := make(map[string]struct{})
+	for _, Value := range Values {
+		Value = strings.TrimSpace(Value)
+		if _, ok := seen[Value]; ok || Value == "" || utf8.RuneCountInString(Value) > maxPointValueLength {
+			return nil, "", errors.New("INVALID_POINT_VALUES")
+		}
+		seen[Value] = struct{}{}
+		resolved = append(resolved, Value)
+	}
+
+	return resolved, Scale, nil
+}
+
+// getBattlePointValues gets the point values votes in the battle must be one of
+func (d *Database) getBattlePointValues(BattleID string) ([]string, error) {
+	var PointValues []string
+	var pv string
+
+	if err := d.db.QueryRow(
+		`SELECT point_values_allowed FROM battles WHERE id = $1;`,
+		BattleID,
+	).Scan(&pv); err != nil {
+		d.logger.Error("get battle point values query error", zap.Error(err))
+		return nil, errors.New("BATTLE_NOT_FOUND")
+	}
+	_ = json.Unmarshal([]byte(pv), &PointValues)
+
+	return PointValues, nil
+}
@@ -0,0 +1,60 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// SetBattleQuick marks the battle as an unlisted quick battle that expires after the TTL
+func (d *Database) SetBattleQuick(BattleID string, TTL time.Duration) (time.Time, error) {
+	var ExpireDate time.Time
+
+	if err := d.db.QueryRow(
+		`UPDATE battles SET quick = true, expire_date = NOW() + make_interval(secs => $2)
+		WHERE id = $1 RETURNING expire_date;`,
+		BattleID,
+		TTL.Seconds(),
+	).Scan(&ExpireDate); err != nil {
+		d.logger.Error("set battle quick query error", zap.Error(err))
+		return ExpireDate, errors.New("unable to set quick battle expiry")
+	}
+
+	return ExpireDate, nil
+}
+
+// CleanExpiredQuickBattles deletes expired quick battles along with their guest creator and participants
+// when the guest has no other battles, retros, or storyboards
+func (d *Database) CleanExpiredQuickBattles() error {
+	if _, err := d.db.Exec(
+		`WITH expired AS (
+			DELETE FROM battles WHERE quick = true AND expire_date < NOW() RETURNING id, owner_id
+		)
+		DELETE FROM users u
+		WHERE u.type = 'GUEST' AND u.id IN (
+			SELECT owner_id FROM expired
+			UNION SELECT bu.user_id FROM battles_users bu WHERE bu.battle_id IN (SELECT id FROM expired)
+		)
+		AND NOT EXISTS (
+			SELECT 1 FROM battles_users bu WHERE bu.user_id = u.id AND bu.battle_id NOT IN (SELECT id FROM expired)
+		)
+		AND NOT EXISTS (SELECT 1 FROM retro r WHERE r.owner_id = u.id)
+		AND NOT EXISTS (SELECT 1 FROM storyboard s WHERE s.owner_id = u.id);`,
+	); err != nil {
+		d.logger.Error("clean expired quick battles query error", zap.Error(err))
+		return errors.New("unable to clean expired quick battles")
+	}
+
+	return nil
+}
+
+// QuickBattleSweeper periodically deletes expired quick battles
+func (d *Database) QuickBattleSweeper(Interval time.Duration) {
+	ticker := time.NewTicker(Interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		_ = d.CleanExpiredQuickBattles()
+	}
+}
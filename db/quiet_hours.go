@@ -0,0 +1,51 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"go.uber.org/zap"
+)
+
+// GetUserQuietHours gets a users timezone and quiet hours
+func (d *Database) GetUserQuietHours(UserID string) (*model.QuietHours, error) {
+	return d.getQuietHours("id", UserID)
+}
+
+// GetUserQuietHoursByEmail gets a users timezone and quiet hours by their email
+func (d *Database) GetUserQuietHoursByEmail(UserEmail string) (*model.QuietHours, error) {
+	return d.getQuietHours("email", UserEmail)
+}
+
+func (d *Database) getQuietHours(Column string, Value string) (*model.QuietHours, error) {
+	var q model.QuietHours
+	var Timezone sql.NullString
+
+	err := d.db.QueryRow(
+		`SELECT timezone, COALESCE(to_char(quiet_hours_start, 'HH24:MI'), ''), COALESCE(to_char(quiet_hours_end, 'HH24:MI'), '')
+		FROM users WHERE `+Column+` = $1;`,
+		Value,
+	).Scan(&Timezone, &q.Start, &q.End)
+	if err != nil {
+		d.logger.Error("get user quiet hours query error", zap.Error(err))
+		return nil, errors.New("user not found")
+	}
+	q.Timezone = Timezone.String
+
+	return &q, nil
+}
+
+// UpdateUserQuietHours sets a users timezone and quiet hours, empty start and end clears the quiet hours
+func (d *Database) UpdateUserQuietHours(UserID string, Quiet *model.QuietHours) error {
+	if _, err := d.db.Exec(
+		`UPDATE users SET timezone = NULLIF($2, ''), quiet_hours_start = NULLIF($3, '')::TIME,
+		quiet_hours_end = NULLIF($4, '')::TIME, updated_date = NOW() WHERE id = $1;`,
+		UserID, Quiet.Timezone, Quiet.Start, Quiet.End,
+	); err != nil {
+		d.logger.Error("update user quiet hours query error", zap.Error(err))
+		return errors.New("error updating user quiet hours")
+	}
+
+	return nil
+}
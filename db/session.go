@@ -30,11 +30,16 @@ func (d *Database) CreateSession(UserId string) (string, error) {
 // GetSessionUser gets a user session by sessionId
 func (d *Database) GetSessionUser(SessionId string) (*model.User, error) {
 	User := &model.User{}
+	var Idle bool
 
 	e := d.db.QueryRow(`
-		SELECT id, name, email, type, avatar, verified, notifications_enabled, country, locale, company, job_title, created_date, updated_date, last_active 
-		FROM user_session_get($1);`,
+		SELECT s.id, s.name, s.email, s.type, s.avatar, s.verified, s.notifications_enabled, s.country, s.locale, s.company, s.job_title, s.created_date, s.updated_date, s.last_active, u.disabled,
+			($2 > 0 AND COALESCE(us.last_seen, us.created_date) < NOW() - make_interval(mins => $2))
+		FROM user_session_get($1) s
+		JOIN users u ON u.id = s.id AND u.deleted_at IS NULL
+		JOIN user_session us ON us.session_id = $1;`,
 		SessionId,
+		d.config.SessionIdleTimeout,
 	).Scan(
 		&User.Id,
 		&User.Name,
@@ -49,17 +54,127 @@ func (d *Database) GetSessionUser(SessionId string) (*model.User, error) {
 		&User.JobTitle,
 		&User.CreatedDate,
 		&User.UpdatedDate,
-		&User.LastActive)
+		&User.LastActive,
+		&User.Disabled,
+		&Idle)
 	if e != nil {
 		d.logger.Error("user_session_get query error", zap.Error(e))
 		return nil, errors.New("active session match not found")
 	}
 
+	// disabling ends the users sessions, this catches any created concurrently
+	if User.Disabled {
+		return nil, errors.New("USER_DISABLED")
+	}
+
+	// an idle session is ended rather than refreshed
+	if Idle {
+		_ = d.DeleteSession(SessionId)
+		return nil, errors.New("SESSION_IDLE_TIMEOUT")
+	}
+
 	User.GravatarHash = createGravatarHash(User.Email)
 
 	return User, nil
 }
 
+// TouchSession marks the session as last seen now, only once a minute to avoid a write on every request,
+// returns SESSION_NOT_FOUND once the session has ended
+func (d *Database) TouchSession(SessionId string) error {
+	var Exists bool
+	if err := d.db.QueryRow(
+		`WITH touched AS (
+			UPDATE user_session SET last_seen = NOW() WHERE session_id = $1 AND last_seen < NOW() - INTERVAL '1 minute'
+		)
+		SELECT EXISTS(SELECT 1 FROM user_session WHERE session_id = $1);`,
+		SessionId,
+	).Scan(&Exists); err != nil {
+		d.logger.Error("update user session last seen query error", zap.Error(err))
+		return errors.New("unable to touch session")
+	}
+	if !Exists {
+		return errors.New("SESSION_NOT_FOUND")
+	}
+
+	return nil
+}
+
+// SetSessionClient stores the user agent and ip address the session was created from
+func (d *Database) SetSessionClient(SessionId string, UserAgent string, IPAddress string) error {
+	if len(UserAgent) > 256 {
+		UserAgent = UserAgent[:256]
+	}
+	if len(IPAddress) > 64 {
+		IPAddress = IPAddress[:64]
+	}
+
+	if _, err := d.db.Exec(
+		`UPDATE user_session SET user_agent = $2, ip_address = $3 WHERE session_id = $1;`,
+		SessionId,
+		UserAgent,
+		IPAddress,
+	); err != nil {
+		d.logger.Error("set user session client query error", zap.Error(err))
+		return errors.New("unable to set session client")
+	}
+
+	return nil
+}
+
+// GetUserSessions gets the users unexpired sessions most recently seen first,
+// the current session is flagged when CurrentSessionId is one of them
+func (d *Database) GetUserSessions(UserId string, CurrentSessionId string) ([]*model.UserSession, error) {
+	var sessions = make([]*model.UserSession, 0)
+	CurrentID := ""
+	if CurrentSessionId != "" {
+		CurrentID = hashString(CurrentSessionId)
+	}
+
+	rows, err := d.db.Query(
+		`SELECT session_id, COALESCE(user_agent, ''), COALESCE(ip_address, ''), created_date,
+			COALESCE(last_seen, created_date), expire_date
+		FROM user_session WHERE user_id = $1 AND expire_date > NOW()
+		ORDER BY last_seen DESC;`,
+		UserId,
+	)
+	if err != nil {
+		d.logger.Error("get user sessions query error", zap.Error(err))
+		return nil, errors.New("unable to get user sessions")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var SessionId string
+		var s model.UserSession
+		if err := rows.Scan(&SessionId, &s.UserAgent, &s.IPAddress, &s.CreatedDate, &s.LastSeen, &s.ExpireDate); err != nil {
+			d.logger.Error("get user sessions query scan error", zap.Error(err))
+			continue
+		}
+		s.Id = hashString(SessionId)
+		s.Current = s.Id == CurrentID
+		sessions = append(sessions, &s)
+	}
+
+	return sessions, nil
+}
+
+// DeleteUserSession deletes the users session by its hashed Id returning the session id
+func (d *Database) DeleteUserSession(UserId string, Id string) (string, error) {
+	var SessionId string
+
+	if err := d.db.QueryRow(
+		`DELETE FROM user_session WHERE user_id = $1 AND encode(sha256(session_id::bytea), 'hex') = $2
+		RETURNING session_id;`,
+		UserId,
+		Id,
+	).Scan(&SessionId); err != nil {
+		d.logger.Error("delete user session query error", zap.Error(err))
+		return "", errors.New("SESSION_NOT_FOUND")
+	}
+
+	return SessionId, nil
+}
+
 // DeleteSession deletes a user authenticated session
 func (d *Database) DeleteSession(SessionId string) error {
 	if _, sessionErr := d.db.Exec(`
@@ -73,3 +188,61 @@ func (d *Database) DeleteSession(SessionId string) error {
 
 	return nil
 }
+
+// CountUserSessions counts a users unexpired sessions
+func (d *Database) CountUserSessions(UserId string) (int, error) {
+	var count int
+
+	if err := d.db.QueryRow(
+		`SELECT COUNT(*) FROM user_session WHERE user_id = $1 AND expire_date > NOW();`,
+		UserId,
+	).Scan(&count); err != nil {
+		d.logger.Error("count user sessions query error", zap.Error(err))
+		return 0, errors.New("unable to count user sessions")
+	}
+
+	return count, nil
+}
+
+// DeleteOldestUserSession deletes the users oldest session returning its session id
+func (d *Database) DeleteOldestUserSession(UserId string) (string, error) {
+	var SessionId string
+
+	if err := d.db.QueryRow(
+		`DELETE FROM user_session WHERE session_id = (
+			SELECT session_id FROM user_session WHERE user_id = $1 ORDER BY created_date ASC LIMIT 1
+		) RETURNING session_id;`,
+		UserId,
+	).Scan(&SessionId); err != nil {
+		d.logger.Error("delete oldest user session query error", zap.Error(err))
+		return "", errors.New("unable to delete oldest user session")
+	}
+
+	return SessionId, nil
+}
+
+// DeleteUserSessions deletes all of the users sessions returning their session ids
+func (d *Database) DeleteUserSessions(UserId string) ([]string, error) {
+	var SessionIds = make([]string, 0)
+
+	rows, err := d.db.Query(
+		`DELETE FROM user_session WHERE user_id = $1 RETURNING session_id;`,
+		UserId,
+	)
+	if err != nil {
+		d.logger.Error("delete user sessions query error", zap.Error(err))
+		return nil, errors.New("unable to delete user sessions")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var SessionId string
+		if err := rows.Scan(&SessionId); err != nil {
+			d.logger.Error("delete user sessions query scan error", zap.Error(err))
+		} else {
+			SessionIds = append(SessionIds, SessionId)
+		}
+	}
+
+	return SessionIds, nil
+}
@@ -0,0 +1,150 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"go.uber.org/zap"
+)
+
+// ReserveStorage records the bytes about to be stored by the user, returning STORAGE_QUOTA_EXCEEDED
+// when it would exceed the users or instances quota, usage is tracked incrementally as uploads are stored
+func (d *Database) ReserveStorage(UserID string, Bytes int64) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		d.logger.Error("reserve storage transaction error", zap.Error(err))
+		return errors.New("unable to reserve storage")
+	}
+	defer tx.Rollback()
+
+	var UsedBytes int64
+	var QuotaBytes sql.NullInt64
+	if err := tx.QueryRow(
+		`INSERT INTO user_storage (user_id) VALUES ($1)
+		ON CONFLICT (user_id) DO UPDATE SET updated_date = NOW()
+		RETURNING used_bytes, quota_bytes;`,
+		UserID,
+	).Scan(&UsedBytes, &QuotaBytes); err != nil {
+		d.logger.Error("reserve storage get usage query error", zap.Error(err))
+		return errors.New("unable to reserve storage")
+	}
+
+	UserQuota := d.config.StorageQuotaPerUser
+	if QuotaBytes.Valid {
+		UserQuota = QuotaBytes.Int64
+	}
+	if storageQuotaExceeded(UserQuota, UsedBytes, Bytes) {
+		return errors.New("STORAGE_QUOTA_EXCEEDED")
+	}
+
+	if d.config.StorageQuotaTotal > 0 {
+		// reservations are serialized while checking the instance total so concurrent ones can't both fit under it
+		if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext('user_storage_total'));`); err != nil {
+			d.logger.Error("reserve storage lock query error", zap.Error(err))
+			return errors.New("unable to reserve storage")
+		}
+
+		var TotalBytes int64
+		if err := tx.QueryRow(
+			`SELECT COALESCE(SUM(used_bytes), 0) FROM user_storage;`,
+		).Scan(&TotalBytes); err != nil {
+			d.logger.Error("reserve storage get total usage query error", zap.Error(err))
+			return errors.New("unable to reserve storage")
+		}
+		if storageQuotaExceeded(d.config.StorageQuotaTotal, TotalBytes, Bytes) {
+			return errors.New("STORAGE_QUOTA_EXCEEDED")
+		}
+	}
+
+	if _, err := tx.Exec(
+		`UPDATE user_storage SET used_bytes = used_bytes + $2 WHERE user_id = $1;`,
+		UserID,
+		Bytes,
+	); err != nil {
+		d.logger.Error("reserve storage update usage query error", zap.Error(err))
+		return errors.New("unable to reserve storage")
+	}
+
+	if err := tx.Commit(); err != nil {
+		d.logger.Error("reserve storage commit error", zap.Error(err))
+		return errors.New("unable to reserve storage")
+	}
+
+	return nil
+}
+
+// ReleaseStorage removes the bytes from the users storage usage when an upload is deleted or replaced
+func (d *Database) ReleaseStorage(UserID string, Bytes int64) error {
+	if _, err := d.db.Exec(
+		`UPDATE user_storage SET used_bytes = GREATEST(used_bytes - $2, 0), updated_date = NOW() WHERE user_id = $1;`,
+		UserID,
+		Bytes,
+	); err != nil {
+		d.logger.Error("release storage query error", zap.Error(err))
+		return errors.New("unable to release storage")
+	}
+
+	return nil
+}
+
+// storageQuotaExceeded checks whether adding bytes to the used bytes exceeds the quota, 0 is unlimited
+func storageQuotaExceeded(Quota int64, Used int64, Adding int64) bool {
+	return Quota > 0 && Used+Adding > Quota
+}
+
+// GetStorageUsage gets a list of users storage usage ordered by most used along with the instance total
+func (d *Database) GetStorageUsage(Limit int, Offset int) ([]*model.StorageUsage, int64, int, error) {
+	var usage = make([]*model.StorageUsage, 0)
+	var TotalBytes int64
+	var Count int
+
+	if err := d.db.QueryRow(
+		`SELECT COALESCE(SUM(used_bytes), 0), COUNT(*) FROM user_storage;`,
+	).Scan(&TotalBytes, &Count); err != nil {
+		d.logger.Error("get storage usage total query error", zap.Error(err))
+		return nil, TotalBytes, Count, errors.New("unable to get storage usage")
+	}
+
+	rows, err := d.db.Query(
+		`SELECT us.user_id, COALESCE(u.name, ''), us.used_bytes, COALESCE(us.quota_bytes, $3)
+		FROM user_storage us
+		LEFT JOIN users u ON u.id = us.user_id
+		ORDER BY us.used_bytes DESC
+		LIMIT $1 OFFSET $2;`,
+		Limit,
+		Offset,
+		d.config.StorageQuotaPerUser,
+	)
+	if err != nil {
+		d.logger.Error("get storage usage query error", zap.Error(err))
+		return nil, TotalBytes, Count, errors.New("unable to get storage usage")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var su model.StorageUsage
+		if err := rows.Scan(&su.UserId, &su.UserName, &su.UsedBytes, &su.QuotaBytes); err != nil {
+			d.logger.Error("get storage usage query scan error", zap.Error(err))
+			return nil, TotalBytes, Count, errors.New("unable to get storage usage")
+		}
+		usage = append(usage, &su)
+	}
+
+	return usage, TotalBytes, Count, nil
+}
+
+// SetUserStorageQuota overrides the users storage quota in bytes, nil reverts to the default
+func (d *Database) SetUserStorageQuota(UserID string, QuotaBytes *int64) error {
+	if _, err := d.db.Exec(
+		`INSERT INTO user_storage (user_id, quota_bytes) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET quota_bytes = EXCLUDED.quota_bytes, updated_date = NOW();`,
+		UserID,
+		QuotaBytes,
+	); err != nil {
+		d.logger.Error("set user storage quota query error", zap.Error(err))
+		return errors.New("unable to set user storage quota")
+	}
+
+	return nil
+}
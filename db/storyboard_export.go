@@ -0,0 +1,54 @@
+package db
+
+import (
+	"errors"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"go.uber.org/zap"
+)
+
+// GetStoryboardFull gets the storyboard with its goals, columns, stories, and their comments
+// including the comment authors names, for exporting
+func (d *Database) GetStoryboardFull(StoryboardID string) (*model.Storyboard, error) {
+	Storyboard, err := d.GetStoryboard(StoryboardID)
+	if err != nil {
+		return nil, errors.New("STORYBOARD_NOT_FOUND")
+	}
+	// the join code is never exported
+	Storyboard.JoinCode = ""
+
+	rows, err := d.db.Query(
+		`SELECT DISTINCT u.id, u.name
+		FROM storyboard_story_comment sc
+		JOIN users u ON u.id = sc.user_id
+		WHERE sc.storyboard_id = $1;`,
+		StoryboardID,
+	)
+	if err != nil {
+		d.logger.Error("get storyboard comment authors query error", zap.Error(err))
+		return nil, errors.New("unable to get storyboard")
+	}
+	defer rows.Close()
+
+	var UserNames = make(map[string]string)
+	for rows.Next() {
+		var UserID, UserName string
+		if err := rows.Scan(&UserID, &UserName); err != nil {
+			d.logger.Error("get storyboard comment authors query scan error", zap.Error(err))
+			return nil, errors.New("unable to get storyboard")
+		}
+		UserNames[UserID] = UserName
+	}
+
+	for _, goal := range Storyboard.Goals {
+		for _, column := range goal.Columns {
+			for _, story := range column.Stories {
+				for _, comment := range story.Comments {
+					comment.UserName = UserNames[comment.UserID]
+				}
+			}
+		}
+	}
+
+	return Storyboard, nil
+}
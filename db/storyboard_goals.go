@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"github.com/lib/pq"
 	"go.uber.org/zap"
 )
 
@@ -94,5 +95,130 @@ func (d *Database) GetStoryboardGoals(StoryboardID string) []*model.StoryboardGo
 		}
 	}
 
+	d.setStoryboardGoalLocks(StoryboardID, goals)
+	d.setStoryboardStoryTags(StoryboardID, goals)
+	sumStoryboardGoalPoints(goals)
+
 	return goals
 }
+
+// setStoryboardGoalLocks sets which of the storyboards goals are locked
+func (d *Database) setStoryboardGoalLocks(StoryboardID string, goals []*model.StoryboardGoal) {
+	rows, err := d.db.Query(
+		`SELECT id FROM storyboard_goal WHERE storyboard_id = $1 AND locked;`,
+		StoryboardID,
+	)
+	if err != nil {
+		d.logger.Error("get storyboard locked goals query error", zap.Error(err))
+		return
+	}
+	defer rows.Close()
+
+	var locked = make(map[string]bool)
+	for rows.Next() {
+		var GoalID string
+		if err := rows.Scan(&GoalID); err != nil {
+			d.logger.Error("get storyboard locked goals query scan error", zap.Error(err))
+			continue
+		}
+		locked[GoalID] = true
+	}
+
+	for _, goal := range goals {
+		goal.Locked = locked[goal.GoalID]
+	}
+}
+
+// setStoryboardStoryTags sets the tags of the storyboards stories
+func (d *Database) setStoryboardStoryTags(StoryboardID string, goals []*model.StoryboardGoal) {
+	var tags = make(map[string][]string)
+
+	rows, err := d.db.Query(
+		`SELECT id, tags FROM storyboard_story WHERE storyboard_id = $1 AND cardinality(tags) > 0;`,
+		StoryboardID,
+	)
+	if err != nil {
+		d.logger.Error("get storyboard story tags query error", zap.Error(err))
+	} else {
+		defer rows.Close()
+		for rows.Next() {
+			var StoryID string
+			var StoryTags []string
+			if err := rows.Scan(&StoryID, pq.Array(&StoryTags)); err != nil {
+				d.logger.Error("get storyboard story tags query scan error", zap.Error(err))
+				continue
+			}
+			tags[StoryID] = StoryTags
+		}
+	}
+
+	for _, goal := range goals {
+		for _, column := range goal.Columns {
+			for _, story := range column.Stories {
+				story.StoryTags = tags[story.StoryID]
+				if story.StoryTags == nil {
+					story.StoryTags = make([]string, 0)
+				}
+			}
+		}
+	}
+}
+
+// SetStoryboardGoalLock locks or unlocks the storyboard goal
+func (d *Database) SetStoryboardGoalLock(StoryboardID string, GoalID string, Locked bool) error {
+	res, err := d.db.Exec(
+		`UPDATE storyboard_goal SET locked = $3, updated_date = NOW() WHERE id = $2 AND storyboard_id = $1;`,
+		StoryboardID,
+		GoalID,
+		Locked,
+	)
+	if err != nil {
+		d.logger.Error("set storyboard goal lock query error", zap.Error(err))
+		return errors.New("unable to set goal lock")
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return errors.New("GOAL_NOT_FOUND")
+	}
+
+	return nil
+}
+
+// StoryboardGoalLocked checks whether the goal, the goal of the column, or the goal of the story is locked,
+// any of them can be empty
+func (d *Database) StoryboardGoalLocked(StoryboardID string, GoalID string, ColumnID string, StoryID string) bool {
+	var Locked bool
+
+	if err := d.db.QueryRow(
+		`SELECT EXISTS(
+			SELECT 1 FROM storyboard_goal g
+			WHERE g.storyboard_id = $1 AND g.locked AND (
+				g.id::TEXT = $2
+				OR g.id IN (SELECT goal_id FROM storyboard_column WHERE id::TEXT = $3)
+				OR g.id IN (SELECT goal_id FROM storyboard_story WHERE id::TEXT = $4)
+			)
+		);`,
+		StoryboardID,
+		GoalID,
+		ColumnID,
+		StoryID,
+	).Scan(&Locked); err != nil {
+		d.logger.Error("get storyboard goal locked query error", zap.Error(err))
+	}
+
+	return Locked
+}
+
+// sumStoryboardGoalPoints totals the story points of each goal column and goal, and counts each stories comments
+func sumStoryboardGoalPoints(goals []*model.StoryboardGoal) {
+	for _, goal := range goals {
+		goal.Points = 0
+		for _, column := range goal.Columns {
+			column.Points = 0
+			for _, story := range column.Stories {
+				column.Points += story.StoryPoints
+				story.CommentCount = len(story.Comments)
+			}
+			goal.Points += column.Points
+		}
+	}
+}
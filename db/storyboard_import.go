@@ -0,0 +1,269 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxImportGoals the max number of goals in a storyboard import
+	maxImportGoals = 50
+	// maxImportColumns the max number of columns in a storyboard import
+	maxImportColumns = 250
+	// maxImportStories the max number of stories in a storyboard import
+	maxImportStories = 2500
+	// maxImportNameLength the max length of goal, column, and story names matching the db columns
+	maxImportNameLength = 256
+)
+
+// storyColors the story colors available in the storyboard color legend
+var storyColors = map[string]struct{}{
+	"gray": {}, "red": {}, "orange": {}, "yellow": {}, "green": {},
+	"teal": {}, "blue": {}, "indigo": {}, "purple": {}, "pink": {},
+}
+
+// validateStoryboardImport validates each section of the import and the references between them
+// returning the list of problems found, e.g. stories[3].columnKey UNKNOWN_COLUMN
+func validateStoryboardImport(i *model.StoryboardImport) []string {
+	var problems []string
+	invalid := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+
+	if strings.TrimSpace(i.Name) == "" || len(i.Name) > maxImportNameLength {
+		invalid("name INVALID_NAME")
+	}
+	if len(i.Goals) > maxImportGoals {
+		invalid("goals TOO_MANY_GOALS")
+	}
+	if len(i.Columns) > maxImportColumns {
+		invalid("columns TOO_MANY_COLUMNS")
+	}
+	if len(i.Stories) > maxImportStories {
+		invalid("stories TOO_MANY_STORIES")
+	}
+	if len(problems) > 0 {
+		return problems
+	}
+
+	goals := make(map[string]struct{})
+	for n, g := range i.Goals {
+		if g == nil {
+			invalid("goals[%d] MISSING_GOAL", n)
+			continue
+		}
+		if _, ok := goals[g.Key]; ok || g.Key == "" {
+			invalid("goals[%d].key DUPLICATE_OR_MISSING_KEY", n)
+		}
+		if strings.TrimSpace(g.Name) == "" || len(g.Name) > maxImportNameLength {
+			invalid("goals[%d].name INVALID_NAME", n)
+		}
+		goals[g.Key] = struct{}{}
+	}
+
+	columns := make(map[string]struct{})
+	for n, c := range i.Columns {
+		if c == nil {
+			invalid("columns[%d] MISSING_COLUMN", n)
+			continue
+		}
+		if _, ok := columns[c.Key]; ok || c.Key == "" {
+			invalid("columns[%d].key DUPLICATE_OR_MISSING_KEY", n)
+		}
+		if _, ok := goals[c.GoalKey]; !ok {
+			invalid("columns[%d].goalKey UNKNOWN_GOAL", n)
+		}
+		if len(c.Name) > maxImportNameLength {
+			invalid("columns[%d].name INVALID_NAME", n)
+		}
+		columns[c.Key] = struct{}{}
+	}
+
+	for n, s := range i.Stories {
+		if s == nil {
+			invalid("stories[%d] MISSING_STORY", n)
+			continue
+		}
+		if _, ok := columns[s.ColumnKey]; !ok {
+			invalid("stories[%d].columnKey UNKNOWN_COLUMN", n)
+		}
+		if len(s.Name) > maxImportNameLength {
+			invalid("stories[%d].name INVALID_NAME", n)
+		}
+		if _, ok := storyColors[s.Color]; s.Color != "" && !ok {
+			invalid("stories[%d].color INVALID_COLOR", n)
+		}
+		if s.Points < 0 {
+			invalid("stories[%d].points INVALID_POINTS", n)
+		}
+		if _, err := normalizeStoryTags(s.Tags); err != nil {
+			invalid("stories[%d].tags %s", n, err.Error())
+		}
+	}
+
+	return problems
+}
+
+// ImportStoryboard creates a storyboard owned by the user from the import in a single transaction
+func (d *Database) ImportStoryboard(OwnerID string, Import *model.StoryboardImport) (*model.Storyboard, []string, error) {
+	if problems := validateStoryboardImport(Import); len(problems) > 0 {
+		return nil, problems, errors.New("INVALID_STORYBOARD_IMPORT")
+	}
+
+	var b = &model.Storyboard{
+		OwnerID:        OwnerID,
+		StoryboardName: Import.Name,
+		Users:          make([]*model.StoryboardUser, 0),
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		d.logger.Error("import storyboard transaction error", zap.Error(err))
+		return nil, nil, errors.New("error importing storyboard")
+	}
+	defer tx.Rollback()
+
+	if err := tx.QueryRow(
+		`INSERT INTO storyboard (owner_id, name) VALUES ($1, $2) RETURNING id;`,
+		OwnerID,
+		Import.Name,
+	).Scan(&b.StoryboardID); err != nil {
+		d.logger.Error("import storyboard insert error", zap.Error(err))
+		return nil, nil, errors.New("error importing storyboard")
+	}
+
+	if err := d.insertStoryboardImport(tx, b.StoryboardID, Import, 0); err != nil {
+		return nil, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		d.logger.Error("import storyboard commit error", zap.Error(err))
+		return nil, nil, errors.New("error importing storyboard")
+	}
+
+	return b, nil, nil
+}
+
+// insertStoryboardImport inserts the imports goals, columns, and stories into the storyboard,
+// the goals are sorted after GoalSortOffset so they can be added after the storyboards existing goals
+func (d *Database) insertStoryboardImport(tx *sql.Tx, StoryboardID string, Import *model.StoryboardImport, GoalSortOffset int) error {
+	// goals
+	goalNames := make([]string, 0, len(Import.Goals))
+	for _, g := range Import.Goals {
+		goalNames = append(goalNames, g.Name)
+	}
+	goalIDs, err := d.importBatch(tx,
+		`INSERT INTO storyboard_goal (storyboard_id, name, sort_order)
+		SELECT $1, g.name, g.sort_order + $3 FROM unnest($2::TEXT[]) WITH ORDINALITY AS g(name, sort_order)
+		RETURNING sort_order::TEXT, id;`,
+		StoryboardID, pq.Array(goalNames), GoalSortOffset,
+	)
+	if err != nil {
+		return err
+	}
+	goalIDsByKey := make(map[string]string)
+	for n, g := range Import.Goals {
+		goalIDsByKey[g.Key] = goalIDs[strconv.Itoa(GoalSortOffset+n+1)]
+	}
+
+	// columns, sort order is per goal
+	columnGoalIDs := make([]string, 0, len(Import.Columns))
+	columnNames := make([]string, 0, len(Import.Columns))
+	columnSortOrders := make([]int64, 0, len(Import.Columns))
+	goalColumnCount := make(map[string]int64)
+	for _, c := range Import.Columns {
+		goalColumnCount[c.GoalKey]++
+		columnGoalIDs = append(columnGoalIDs, goalIDsByKey[c.GoalKey])
+		columnNames = append(columnNames, c.Name)
+		columnSortOrders = append(columnSortOrders, goalColumnCount[c.GoalKey])
+	}
+	columnIDs, err := d.importBatch(tx,
+		`INSERT INTO storyboard_column (storyboard_id, goal_id, name, sort_order)
+		SELECT $1, c.goal_id, c.name, c.sort_order
+		FROM unnest($2::UUID[], $3::TEXT[], $4::INTEGER[]) AS c(goal_id, name, sort_order)
+		RETURNING goal_id::TEXT || ':' || sort_order::TEXT, id;`,
+		StoryboardID, pq.Array(columnGoalIDs), pq.Array(columnNames), pq.Array(columnSortOrders),
+	)
+	if err != nil {
+		return err
+	}
+	columnIDsByKey := make(map[string]string)
+	columnGoalIDsByKey := make(map[string]string)
+	for n, c := range Import.Columns {
+		columnIDsByKey[c.Key] = columnIDs[columnGoalIDs[n]+":"+strconv.FormatInt(columnSortOrders[n], 10)]
+		columnGoalIDsByKey[c.Key] = goalIDsByKey[c.GoalKey]
+	}
+
+	// stories, sort order is per column
+	var storyGoalIDs, storyColumnIDs, storyNames, storyContents, storyColors, storyTags []string
+	var storyPoints, storySortOrders []int64
+	var storyClosed []bool
+	columnStoryCount := make(map[string]int64)
+	for _, s := range Import.Stories {
+		columnStoryCount[s.ColumnKey]++
+		color := s.Color
+		if color == "" {
+			color = "gray"
+		}
+		storyGoalIDs = append(storyGoalIDs, columnGoalIDsByKey[s.ColumnKey])
+		storyColumnIDs = append(storyColumnIDs, columnIDsByKey[s.ColumnKey])
+		storyNames = append(storyNames, s.Name)
+		storyContents = append(storyContents, d.htmlSanitizerPolicy.Sanitize(s.Content))
+		storyColors = append(storyColors, color)
+		// the tags are passed as json as unnest would flatten a two dimensional array
+		tags, _ := normalizeStoryTags(s.Tags)
+		tagsJSON, _ := json.Marshal(tags)
+		storyTags = append(storyTags, string(tagsJSON))
+		storyPoints = append(storyPoints, int64(s.Points))
+		storyClosed = append(storyClosed, s.Closed)
+		storySortOrders = append(storySortOrders, columnStoryCount[s.ColumnKey])
+	}
+	if len(Import.Stories) > 0 {
+		if _, err := d.importBatch(tx,
+			`INSERT INTO storyboard_story (storyboard_id, goal_id, column_id, name, content, color, points, closed, sort_order, tags)
+			SELECT $1, s.goal_id, s.column_id, s.name, s.content, s.color, s.points, s.closed, s.sort_order,
+				ARRAY(SELECT jsonb_array_elements_text(s.tags::JSONB))
+			FROM unnest($2::UUID[], $3::UUID[], $4::TEXT[], $5::TEXT[], $6::TEXT[], $7::INTEGER[], $8::BOOL[], $9::INTEGER[], $10::TEXT[])
+			AS s(goal_id, column_id, name, content, color, points, closed, sort_order, tags)
+			RETURNING id::TEXT, id;`,
+			StoryboardID, pq.Array(storyGoalIDs), pq.Array(storyColumnIDs), pq.Array(storyNames), pq.Array(storyContents),
+			pq.Array(storyColors), pq.Array(storyPoints), pq.Array(storyClosed), pq.Array(storySortOrders), pq.Array(storyTags),
+		); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// importBatch executes a batch insert returning the inserted ids keyed by the first returned column
+// as the order of returned rows isn't guaranteed
+func (d *Database) importBatch(tx *sql.Tx, query string, args ...interface{}) (map[string]string, error) {
+	ids := make(map[string]string)
+
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		d.logger.Error("import storyboard batch insert error", zap.Error(err))
+		return nil, errors.New("error importing storyboard")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key, id string
+		if err := rows.Scan(&key, &id); err != nil {
+			d.logger.Error("import storyboard batch insert scan error", zap.Error(err))
+			return nil, errors.New("error importing storyboard")
+		}
+		ids[key] = id
+	}
+
+	return ids, rows.Err()
+}
@@ -0,0 +1,467 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+const (
+	// storyboardUndoRevert sets the goal, column, or story fields back to what they were
+	storyboardUndoRevert = "revert"
+	// storyboardUndoRestore re-creates the deleted goal, column, or story with its IDs and contents
+	storyboardUndoRestore = "restore"
+	// storyboardUndoRemove deletes the added goal, column, or story
+	storyboardUndoRemove = "remove"
+	// storyboardUndoMove moves the story back to where it was
+	storyboardUndoMove = "move"
+)
+
+// StoryboardRevisionUndo how to undo a storyboard revision, holding only the goal, column, or story
+// it changed as they were before the change
+type StoryboardRevisionUndo struct {
+	Operation   string          `json:"operation"`
+	Goal        *revisionGoal   `json:"goal,omitempty"`
+	Column      *revisionColumn `json:"column,omitempty"`
+	Story       *revisionStory  `json:"story,omitempty"`
+	PlaceBefore string          `json:"placeBefore,omitempty"`
+
+	// scopeID and existing are the parent an entity is being added to and the IDs already in it
+	scopeID  string
+	existing []string
+}
+
+// revisionGoal a goal as it was before a revision
+type revisionGoal struct {
+	Id          string            `json:"id"`
+	Name        string            `json:"name"`
+	SortOrder   int               `json:"sortOrder"`
+	Locked      bool              `json:"locked"`
+	CreatedDate time.Time         `json:"createdDate"`
+	Columns     []*revisionColumn `json:"columns,omitempty"`
+}
+
+// revisionColumn a column as it was before a revision
+type revisionColumn struct {
+	Id          string           `json:"id"`
+	GoalId      string           `json:"goalId"`
+	Name        string           `json:"name"`
+	SortOrder   int              `json:"sortOrder"`
+	CreatedDate time.Time        `json:"createdDate"`
+	Stories     []*revisionStory `json:"stories,omitempty"`
+}
+
+// revisionStory a story as it was before a revision
+type revisionStory struct {
+	Id          string             `json:"id"`
+	GoalId      string             `json:"goalId"`
+	ColumnId    string             `json:"columnId"`
+	Name        string             `json:"name"`
+	Color       string             `json:"color"`
+	Content     string             `json:"content"`
+	SortOrder   int                `json:"sortOrder"`
+	Points      int                `json:"points"`
+	Closed      bool               `json:"closed"`
+	Tags        []string           `json:"tags"`
+	CreatedDate time.Time          `json:"createdDate"`
+	Comments    []*revisionComment `json:"comments,omitempty"`
+}
+
+// revisionComment a comment of a story as it was before a revision
+type revisionComment struct {
+	Id          string    `json:"id"`
+	UserId      string    `json:"userId"`
+	Comment     string    `json:"comment"`
+	Edited      bool      `json:"edited"`
+	CreatedDate time.Time `json:"createdDate"`
+	UpdatedDate time.Time `json:"updatedDate"`
+}
+
+// PrepareStoryboardRevisionUndo gets how to undo the operation from the goal, column, or story it's about to change,
+// nil when it can't be undone
+func (d *Database) PrepareStoryboardRevisionUndo(StoryboardID string, Action string, GoalID string, ColumnID string, StoryID string) *StoryboardRevisionUndo {
+	var err error
+	var Undo = &StoryboardRevisionUndo{Operation: storyboardUndoRevert}
+
+	switch Action {
+	case "add_goal":
+		Undo.Operation = storyboardUndoRemove
+		Undo.scopeID = StoryboardID
+		Undo.existing, err = d.revisionEntityIDs(`SELECT id FROM storyboard_goal WHERE storyboard_id = $1;`, StoryboardID)
+	case "add_column":
+		Undo.Operation = storyboardUndoRemove
+		Undo.scopeID = GoalID
+		Undo.existing, err = d.revisionEntityIDs(`SELECT id FROM storyboard_column WHERE goal_id = $1;`, GoalID)
+	case "add_story":
+		Undo.Operation = storyboardUndoRemove
+		Undo.scopeID = ColumnID
+		Undo.existing, err = d.revisionEntityIDs(`SELECT id FROM storyboard_story WHERE column_id = $1;`, ColumnID)
+	case "revise_goal":
+		Undo.Goal, err = d.revisionGoal(StoryboardID, GoalID, false)
+	case "delete_goal":
+		Undo.Operation = storyboardUndoRestore
+		Undo.Goal, err = d.revisionGoal(StoryboardID, GoalID, true)
+	case "revise_column":
+		Undo.Column, err = d.revisionColumn(StoryboardID, ColumnID, false)
+	case "delete_column":
+		Undo.Operation = storyboardUndoRestore
+		Undo.Column, err = d.revisionColumn(StoryboardID, ColumnID, true)
+	case "move_story":
+		Undo.Operation = storyboardUndoMove
+		Undo.Story, err = d.revisionStory(StoryboardID, StoryID, false)
+		if err == nil {
+			// the story that came after it, empty when it was last in its column
+			err = d.db.QueryRow(
+				`SELECT COALESCE((
+					SELECT id::TEXT FROM storyboard_story
+					WHERE column_id = $1 AND sort_order > $2 ORDER BY sort_order LIMIT 1
+				), '');`,
+				Undo.Story.ColumnId,
+				Undo.Story.SortOrder,
+			).Scan(&Undo.PlaceBefore)
+		}
+	case "delete_story":
+		Undo.Operation = storyboardUndoRestore
+		Undo.Story, err = d.revisionStory(StoryboardID, StoryID, true)
+	case "update_story_name", "update_story_content", "update_story_color",
+		"set_story_tags", "update_story_points", "update_story_closed":
+		Undo.Story, err = d.revisionStory(StoryboardID, StoryID, false)
+	default:
+		return nil
+	}
+	if err != nil {
+		d.logger.Error("prepare storyboard revision undo query error", zap.Error(err), zap.String("action", Action))
+		return nil
+	}
+
+	return Undo
+}
+
+// resolveAddedStoryboardEntity finds the goal, column, or story the operation added to the scope
+func (d *Database) resolveAddedStoryboardEntity(Action string, Undo *StoryboardRevisionUndo) error {
+	var table string
+	var scopeColumn string
+	switch Action {
+	case "add_goal":
+		table, scopeColumn = "storyboard_goal", "storyboard_id"
+	case "add_column":
+		table, scopeColumn = "storyboard_column", "goal_id"
+	case "add_story":
+		table, scopeColumn = "storyboard_story", "column_id"
+	default:
+		return errors.New("unable to create storyboard revision")
+	}
+
+	var ID string
+	if err := d.db.QueryRow(
+		`SELECT id FROM `+table+` WHERE `+scopeColumn+` = $1 AND NOT (id::TEXT = ANY($2))
+		ORDER BY created_date DESC LIMIT 1;`,
+		Undo.scopeID,
+		pq.Array(Undo.existing),
+	).Scan(&ID); err != nil {
+		d.logger.Error("get added storyboard entity query error", zap.Error(err), zap.String("action", Action))
+		return errors.New("unable to create storyboard revision")
+	}
+
+	switch Action {
+	case "add_goal":
+		Undo.Goal = &revisionGoal{Id: ID}
+	case "add_column":
+		Undo.Column = &revisionColumn{Id: ID, GoalId: Undo.scopeID}
+	case "add_story":
+		Undo.Story = &revisionStory{Id: ID, ColumnId: Undo.scopeID}
+	}
+
+	return nil
+}
+
+// applyStoryboardRevisionUndo reverts the goal, column, or story the revision changed
+func (d *Database) applyStoryboardRevisionUndo(tx *sql.Tx, StoryboardID string, Undo *StoryboardRevisionUndo) error {
+	switch {
+	case Undo.Operation == storyboardUndoRevert && Undo.Goal != nil:
+		_, err := tx.Exec(
+			`UPDATE storyboard_goal SET name = $3, updated_date = NOW() WHERE id = $2 AND storyboard_id = $1;`,
+			StoryboardID, Undo.Goal.Id, Undo.Goal.Name,
+		)
+		return err
+	case Undo.Operation == storyboardUndoRevert && Undo.Column != nil:
+		_, err := tx.Exec(
+			`UPDATE storyboard_column SET name = $3, updated_date = NOW() WHERE id = $2 AND storyboard_id = $1;`,
+			StoryboardID, Undo.Column.Id, Undo.Column.Name,
+		)
+		return err
+	case Undo.Operation == storyboardUndoRevert && Undo.Story != nil:
+		s := Undo.Story
+		_, err := tx.Exec(
+			`UPDATE storyboard_story
+			SET name = $3, color = $4, content = $5, points = $6, closed = $7, tags = $8, updated_date = NOW()
+			WHERE id = $2 AND storyboard_id = $1;`,
+			StoryboardID, s.Id, s.Name, s.Color, s.Content, s.Points, s.Closed, pq.Array(s.Tags),
+		)
+		return err
+	case Undo.Operation == storyboardUndoMove && Undo.Story != nil:
+		_, err := tx.Exec(
+			`call move_story($1, $2, $3, $4);`,
+			Undo.Story.Id, Undo.Story.GoalId, Undo.Story.ColumnId, Undo.PlaceBefore,
+		)
+		return err
+	case Undo.Operation == storyboardUndoRemove && Undo.Goal != nil:
+		return removeRevisionEntity(tx, "storyboard_goal", "storyboard_id", StoryboardID, Undo.Goal.Id)
+	case Undo.Operation == storyboardUndoRemove && Undo.Column != nil:
+		return removeRevisionEntity(tx, "storyboard_column", "goal_id", StoryboardID, Undo.Column.Id)
+	case Undo.Operation == storyboardUndoRemove && Undo.Story != nil:
+		return removeRevisionEntity(tx, "storyboard_story", "column_id", StoryboardID, Undo.Story.Id)
+	case Undo.Operation == storyboardUndoRestore && Undo.Goal != nil:
+		if err := shiftRevisionSortOrder(tx, "storyboard_goal", "storyboard_id", StoryboardID, Undo.Goal.SortOrder, 1); err != nil {
+			return err
+		}
+		return insertRevisionGoal(tx, StoryboardID, Undo.Goal)
+	case Undo.Operation == storyboardUndoRestore && Undo.Column != nil:
+		if err := shiftRevisionSortOrder(tx, "storyboard_column", "goal_id", Undo.Column.GoalId, Undo.Column.SortOrder, 1); err != nil {
+			return err
+		}
+		return insertRevisionColumn(tx, StoryboardID, Undo.Column)
+	case Undo.Operation == storyboardUndoRestore && Undo.Story != nil:
+		if err := shiftRevisionSortOrder(tx, "storyboard_story", "column_id", Undo.Story.ColumnId, Undo.Story.SortOrder, 1); err != nil {
+			return err
+		}
+		return insertRevisionStory(tx, StoryboardID, Undo.Story)
+	}
+
+	return errors.New("unknown storyboard revision undo")
+}
+
+// removeRevisionEntity deletes the goal, column, or story closing the gap in its parents sort order,
+// its columns, stories, and comments are deleted with it
+func removeRevisionEntity(tx *sql.Tx, Table string, ScopeColumn string, StoryboardID string, ID string) error {
+	var ScopeID string
+	var SortOrder int
+
+	if err := tx.QueryRow(
+		`DELETE FROM `+Table+` WHERE id = $2 AND storyboard_id = $1 RETURNING `+ScopeColumn+`, sort_order;`,
+		StoryboardID,
+		ID,
+	).Scan(&ScopeID, &SortOrder); err != nil {
+		return err
+	}
+
+	return shiftRevisionSortOrder(tx, Table, ScopeColumn, ScopeID, SortOrder, -1)
+}
+
+// shiftRevisionSortOrder moves the sort order of the goals, columns, or stories in the scope from SortOrder on by Offset,
+// negating them in between so the rows can't collide on the unique sort order while shifting
+func shiftRevisionSortOrder(tx *sql.Tx, Table string, ScopeColumn string, ScopeID string, SortOrder int, Offset int) error {
+	if _, err := tx.Exec(
+		`UPDATE `+Table+` SET sort_order = -(sort_order + $3) WHERE `+ScopeColumn+` = $1 AND sort_order >= $2;`,
+		ScopeID,
+		SortOrder,
+		Offset,
+	); err != nil {
+		return err
+	}
+
+	_, err := tx.Exec(
+		`UPDATE `+Table+` SET sort_order = -sort_order WHERE `+ScopeColumn+` = $1 AND sort_order < 0;`,
+		ScopeID,
+	)
+	return err
+}
+
+// insertRevisionGoal re-creates the goal with its columns, stories, and comments
+func insertRevisionGoal(tx *sql.Tx, StoryboardID string, g *revisionGoal) error {
+	if _, err := tx.Exec(
+		`INSERT INTO storyboard_goal (id, storyboard_id, name, sort_order, locked, created_date)
+		VALUES ($1, $2, $3, $4, $5, $6);`,
+		g.Id, StoryboardID, g.Name, g.SortOrder, g.Locked, g.CreatedDate,
+	); err != nil {
+		return err
+	}
+
+	for _, c := range g.Columns {
+		if err := insertRevisionColumn(tx, StoryboardID, c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// insertRevisionColumn re-creates the column with its stories and comments
+func insertRevisionColumn(tx *sql.Tx, StoryboardID string, c *revisionColumn) error {
+	if _, err := tx.Exec(
+		`INSERT INTO storyboard_column (id, storyboard_id, goal_id, name, sort_order, created_date)
+		VALUES ($1, $2, $3, $4, $5, $6);`,
+		c.Id, StoryboardID, c.GoalId, c.Name, c.SortOrder, c.CreatedDate,
+	); err != nil {
+		return err
+	}
+
+	for _, s := range c.Stories {
+		if err := insertRevisionStory(tx, StoryboardID, s); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// insertRevisionStory re-creates the story with its comments, skipping comments of users since deleted
+func insertRevisionStory(tx *sql.Tx, StoryboardID string, s *revisionStory) error {
+	if _, err := tx.Exec(
+		`INSERT INTO storyboard_story
+			(id, storyboard_id, goal_id, column_id, name, color, content, sort_order, points, closed, tags, created_date)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12);`,
+		s.Id, StoryboardID, s.GoalId, s.ColumnId, s.Name, s.Color, s.Content, s.SortOrder,
+		s.Points, s.Closed, pq.Array(s.Tags), s.CreatedDate,
+	); err != nil {
+		return err
+	}
+
+	for _, c := range s.Comments {
+		if _, err := tx.Exec(
+			`INSERT INTO storyboard_story_comment
+				(id, storyboard_id, story_id, user_id, comment, edited, created_date, updated_date)
+			SELECT $1, $2, $3, u.id, $5, $6, $7, $8 FROM users u WHERE u.id::TEXT = $4;`,
+			c.Id, StoryboardID, s.Id, c.UserId, c.Comment, c.Edited, c.CreatedDate, c.UpdatedDate,
+		); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// revisionEntityIDs gets the IDs of the goals, columns, or stories in a scope
+func (d *Database) revisionEntityIDs(Query string, ScopeID string) ([]string, error) {
+	var IDs = make([]string, 0)
+
+	rows, err := d.db.Query(Query, ScopeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ID string
+		if err := rows.Scan(&ID); err != nil {
+			return nil, err
+		}
+		IDs = append(IDs, ID)
+	}
+
+	return IDs, rows.Err()
+}
+
+// revisionGoal gets the goal as it is, with its columns, stories, and comments when Contents is true
+func (d *Database) revisionGoal(StoryboardID string, GoalID string, Contents bool) (*revisionGoal, error) {
+	var g revisionGoal
+
+	if err := d.db.QueryRow(
+		`SELECT id, COALESCE(name, ''), sort_order, locked, created_date
+		FROM storyboard_goal WHERE id = $2 AND storyboard_id = $1;`,
+		StoryboardID,
+		GoalID,
+	).Scan(&g.Id, &g.Name, &g.SortOrder, &g.Locked, &g.CreatedDate); err != nil {
+		return nil, err
+	}
+
+	if Contents {
+		ColumnIDs, err := d.revisionEntityIDs(
+			`SELECT id FROM storyboard_column WHERE goal_id = $1 ORDER BY sort_order;`,
+			g.Id,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, ColumnID := range ColumnIDs {
+			c, err := d.revisionColumn(StoryboardID, ColumnID, true)
+			if err != nil {
+				return nil, err
+			}
+			g.Columns = append(g.Columns, c)
+		}
+	}
+
+	return &g, nil
+}
+
+// revisionColumn gets the column as it is, with its stories and comments when Contents is true
+func (d *Database) revisionColumn(StoryboardID string, ColumnID string, Contents bool) (*revisionColumn, error) {
+	var c revisionColumn
+
+	if err := d.db.QueryRow(
+		`SELECT id, goal_id, COALESCE(name, ''), sort_order, created_date
+		FROM storyboard_column WHERE id = $2 AND storyboard_id = $1;`,
+		StoryboardID,
+		ColumnID,
+	).Scan(&c.Id, &c.GoalId, &c.Name, &c.SortOrder, &c.CreatedDate); err != nil {
+		return nil, err
+	}
+
+	if Contents {
+		StoryIDs, err := d.revisionEntityIDs(
+			`SELECT id FROM storyboard_story WHERE column_id = $1 ORDER BY sort_order;`,
+			c.Id,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, StoryID := range StoryIDs {
+			s, err := d.revisionStory(StoryboardID, StoryID, true)
+			if err != nil {
+				return nil, err
+			}
+			c.Stories = append(c.Stories, s)
+		}
+	}
+
+	return &c, nil
+}
+
+// revisionStory gets the story as it is, with its comments when Contents is true
+func (d *Database) revisionStory(StoryboardID string, StoryID string, Contents bool) (*revisionStory, error) {
+	var s revisionStory
+
+	if err := d.db.QueryRow(
+		`SELECT id, goal_id, column_id, COALESCE(name, ''), COALESCE(color, 'gray'), COALESCE(content, ''),
+			sort_order, COALESCE(points, 0), COALESCE(closed, false), tags, created_date
+		FROM storyboard_story WHERE id = $2 AND storyboard_id = $1;`,
+		StoryboardID,
+		StoryID,
+	).Scan(
+		&s.Id, &s.GoalId, &s.ColumnId, &s.Name, &s.Color, &s.Content,
+		&s.SortOrder, &s.Points, &s.Closed, pq.Array(&s.Tags), &s.CreatedDate,
+	); err != nil {
+		return nil, err
+	}
+
+	if Contents {
+		rows, err := d.db.Query(
+			`SELECT id, COALESCE(user_id::TEXT, ''), COALESCE(comment, ''), edited, created_date, updated_date
+			FROM storyboard_story_comment WHERE story_id = $1 ORDER BY created_date;`,
+			s.Id,
+		)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var c revisionComment
+			if err := rows.Scan(&c.Id, &c.UserId, &c.Comment, &c.Edited, &c.CreatedDate, &c.UpdatedDate); err != nil {
+				return nil, err
+			}
+			s.Comments = append(s.Comments, &c)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &s, nil
+}
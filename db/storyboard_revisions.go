@@ -0,0 +1,149 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"go.uber.org/zap"
+)
+
+// CreateStoryboardRevision records the users change to the storyboard with how to undo it,
+// pruning the storyboards revisions beyond the configured limit
+func (d *Database) CreateStoryboardRevision(StoryboardID string, UserID string, Action string, Undo *StoryboardRevisionUndo) error {
+	if d.config.StoryboardRevisionLimit <= 0 || Undo == nil {
+		return nil
+	}
+
+	// the ID of an added goal, column, or story is only known once it's been added
+	if Undo.Operation == storyboardUndoRemove {
+		if err := d.resolveAddedStoryboardEntity(Action, Undo); err != nil {
+			return err
+		}
+	}
+
+	Data, err := json.Marshal(Undo)
+	if err != nil {
+		d.logger.Error("storyboard revision json error", zap.Error(err))
+		return errors.New("unable to create storyboard revision")
+	}
+
+	if _, err := d.db.Exec(
+		`INSERT INTO storyboard_revision (storyboard_id, user_id, action, data)
+		VALUES ($1, NULLIF($2, '')::UUID, $3, $4);`,
+		StoryboardID,
+		UserID,
+		Action,
+		string(Data),
+	); err != nil {
+		d.logger.Error("insert storyboard revision query error", zap.Error(err))
+		return errors.New("unable to create storyboard revision")
+	}
+
+	if _, err := d.db.Exec(
+		`DELETE FROM storyboard_revision WHERE id IN (
+			SELECT id FROM storyboard_revision WHERE storyboard_id = $1
+			ORDER BY created_date DESC OFFSET $2
+		);`,
+		StoryboardID,
+		d.config.StoryboardRevisionLimit,
+	); err != nil {
+		d.logger.Error("prune storyboard revisions query error", zap.Error(err))
+	}
+
+	return nil
+}
+
+// GetStoryboardRevisions gets the storyboards revision history newest first, without their contents
+func (d *Database) GetStoryboardRevisions(StoryboardID string) ([]*model.StoryboardRevision, error) {
+	var revisions = make([]*model.StoryboardRevision, 0)
+
+	rows, err := d.db.Query(
+		`SELECT sr.id, COALESCE(sr.user_id::TEXT, ''), COALESCE(u.name, ''), sr.action, sr.undone, sr.created_date
+		FROM storyboard_revision sr
+		LEFT JOIN users u ON u.id = sr.user_id
+		WHERE sr.storyboard_id = $1
+		ORDER BY sr.created_date DESC;`,
+		StoryboardID,
+	)
+	if err != nil {
+		d.logger.Error("get storyboard revisions query error", zap.Error(err))
+		return nil, errors.New("unable to get storyboard revisions")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r model.StoryboardRevision
+		if err := rows.Scan(&r.Id, &r.UserId, &r.UserName, &r.Action, &r.Undone, &r.CreatedDate); err != nil {
+			d.logger.Error("get storyboard revisions scan error", zap.Error(err))
+			continue
+		}
+		revisions = append(revisions, &r)
+	}
+
+	return revisions, nil
+}
+
+// UndoStoryboardRevision reverts the users most recent change to the storyboard in place, only touching the goal, column,
+// or story it changed, refused when someone else has since changed the storyboard as undoing it would discard their change
+func (d *Database) UndoStoryboardRevision(StoryboardID string, UserID string) ([]*model.StoryboardGoal, error) {
+	var RevisionID string
+	var RevisionUserID string
+	var Data string
+	var Undo StoryboardRevisionUndo
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		d.logger.Error("undo storyboard revision transaction error", zap.Error(err))
+		return nil, errors.New("unable to undo storyboard revision")
+	}
+	defer tx.Rollback()
+
+	// the storyboard is locked so concurrent undos can't revert over each other
+	if _, err := tx.Exec(`SELECT id FROM storyboard WHERE id = $1 FOR UPDATE;`, StoryboardID); err != nil {
+		d.logger.Error("undo storyboard revision lock query error", zap.Error(err))
+		return nil, errors.New("unable to undo storyboard revision")
+	}
+
+	if err := tx.QueryRow(
+		`SELECT id, COALESCE(user_id::TEXT, ''), data FROM storyboard_revision
+		WHERE storyboard_id = $1 AND undone = false AND created_date >= (
+			SELECT MAX(created_date) FROM storyboard_revision WHERE storyboard_id = $1 AND user_id = $2 AND undone = false
+		)
+		ORDER BY created_date DESC LIMIT 1;`,
+		StoryboardID,
+		UserID,
+	).Scan(&RevisionID, &RevisionUserID, &Data); err != nil {
+		return nil, errors.New("NOTHING_TO_UNDO")
+	}
+	if RevisionUserID != UserID {
+		return nil, errors.New("UNDO_CONFLICT")
+	}
+
+	if err := json.Unmarshal([]byte(Data), &Undo); err != nil {
+		d.logger.Error("storyboard revision json error", zap.Error(err))
+		return nil, errors.New("unable to undo storyboard revision")
+	}
+
+	if err := d.applyStoryboardRevisionUndo(tx, StoryboardID, &Undo); err != nil {
+		d.logger.Error("undo storyboard revision query error", zap.Error(err))
+		return nil, errors.New("unable to undo storyboard revision")
+	}
+
+	if _, err := tx.Exec(`UPDATE storyboard_revision SET undone = true WHERE id = $1;`, RevisionID); err != nil {
+		d.logger.Error("undo storyboard revision update query error", zap.Error(err))
+		return nil, errors.New("unable to undo storyboard revision")
+	}
+
+	if _, err := tx.Exec(`UPDATE storyboard SET updated_date = NOW() WHERE id = $1;`, StoryboardID); err != nil {
+		d.logger.Error("undo storyboard revision update storyboard query error", zap.Error(err))
+		return nil, errors.New("unable to undo storyboard revision")
+	}
+
+	if err := tx.Commit(); err != nil {
+		d.logger.Error("undo storyboard revision commit error", zap.Error(err))
+		return nil, errors.New("unable to undo storyboard revision")
+	}
+
+	return d.GetStoryboardGoals(StoryboardID), nil
+}
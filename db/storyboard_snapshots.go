@@ -0,0 +1,251 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxManualStoryboardSnapshots the max number of manual snapshots kept per storyboard, oldest are pruned first
+	maxManualStoryboardSnapshots = 25
+	// maxScheduledStoryboardSnapshots the max number of storyboards snapshot per scheduled run
+	maxScheduledStoryboardSnapshots = 100
+)
+
+// storyboardSnapshotData converts the storyboards goals into the storyboard import schema
+// with the goal and column IDs as their keys
+func storyboardSnapshotData(Name string, Goals []*model.StoryboardGoal) *model.StoryboardImport {
+	var data = &model.StoryboardImport{
+		Name:    Name,
+		Goals:   make([]*model.StoryboardImportGoal, 0, len(Goals)),
+		Columns: make([]*model.StoryboardImportColumn, 0),
+		Stories: make([]*model.StoryboardImportStory, 0),
+	}
+
+	for _, g := range Goals {
+		data.Goals = append(data.Goals, &model.StoryboardImportGoal{Key: g.GoalID, Name: g.GoalName})
+		for _, c := range g.Columns {
+			data.Columns = append(data.Columns, &model.StoryboardImportColumn{Key: c.ColumnID, GoalKey: g.GoalID, Name: c.ColumnName})
+			for _, s := range c.Stories {
+				data.Stories = append(data.Stories, &model.StoryboardImportStory{
+					ColumnKey: c.ColumnID,
+					Name:      s.StoryName,
+					Content:   s.StoryContent,
+					Color:     s.StoryColor,
+					Points:    s.StoryPoints,
+					Closed:    s.StoryClosed,
+					Tags:      s.StoryTags,
+				})
+			}
+		}
+	}
+
+	return data
+}
+
+// createStoryboardSnapshot snapshots the storyboards current goals, columns, and stories then prunes
+// its snapshots of the same kind beyond the Retention count
+func (d *Database) createStoryboardSnapshot(StoryboardID string, UserID string, Automatic bool, Retention int) error {
+	var Name string
+	if err := d.db.QueryRow(
+		`SELECT name FROM storyboard WHERE id = $1;`,
+		StoryboardID,
+	).Scan(&Name); err != nil {
+		d.logger.Error("get storyboard snapshot name query error", zap.Error(err))
+		return errors.New("STORYBOARD_NOT_FOUND")
+	}
+
+	Data := storyboardSnapshotData(Name, d.GetStoryboardGoals(StoryboardID))
+	DataJSON, err := json.Marshal(Data)
+	if err != nil {
+		d.logger.Error("storyboard snapshot json error", zap.Error(err))
+		return errors.New("unable to snapshot storyboard")
+	}
+
+	if _, err := d.db.Exec(
+		`INSERT INTO storyboard_snapshot (storyboard_id, user_id, automatic, goal_count, story_count, data)
+		VALUES ($1, NULLIF($2, '')::UUID, $3, $4, $5, $6);`,
+		StoryboardID,
+		UserID,
+		Automatic,
+		len(Data.Goals),
+		len(Data.Stories),
+		string(DataJSON),
+	); err != nil {
+		d.logger.Error("insert storyboard snapshot query error", zap.Error(err))
+		return errors.New("unable to snapshot storyboard")
+	}
+
+	if _, err := d.db.Exec(
+		`DELETE FROM storyboard_snapshot WHERE id IN (
+			SELECT id FROM storyboard_snapshot WHERE storyboard_id = $1 AND automatic = $2
+			ORDER BY created_date DESC OFFSET $3
+		);`,
+		StoryboardID,
+		Automatic,
+		Retention,
+	); err != nil {
+		d.logger.Error("prune storyboard snapshots query error", zap.Error(err))
+	}
+
+	return nil
+}
+
+// CreateStoryboardSnapshot snapshots the storyboard on the users request
+func (d *Database) CreateStoryboardSnapshot(StoryboardID string, UserID string) ([]*model.StoryboardSnapshot, error) {
+	if err := d.createStoryboardSnapshot(StoryboardID, UserID, false, maxManualStoryboardSnapshots); err != nil {
+		return nil, err
+	}
+
+	return d.GetStoryboardSnapshots(StoryboardID)
+}
+
+// SnapshotChangedStoryboards snapshots storyboards changed since their last snapshot that are either
+// in use or were changed since the given time, keeping the latest Retention automatic snapshots of each
+func (d *Database) SnapshotChangedStoryboards(ChangedSince time.Time, Retention int) (int, error) {
+	rows, err := d.db.Query(
+		`SELECT s.id FROM storyboard s
+		WHERE s.updated_date > COALESCE(
+			(SELECT MAX(ss.created_date) FROM storyboard_snapshot ss WHERE ss.storyboard_id = s.id), '-infinity'
+		) AND (
+			s.updated_date > $1
+			OR EXISTS(SELECT 1 FROM storyboard_user su WHERE su.storyboard_id = s.id AND su.active = true)
+		)
+		ORDER BY s.updated_date
+		LIMIT $2;`,
+		ChangedSince,
+		maxScheduledStoryboardSnapshots,
+	)
+	if err != nil {
+		d.logger.Error("get changed storyboards query error", zap.Error(err))
+		return 0, errors.New("unable to snapshot storyboards")
+	}
+
+	var StoryboardIDs []string
+	for rows.Next() {
+		var StoryboardID string
+		if err := rows.Scan(&StoryboardID); err != nil {
+			d.logger.Error("get changed storyboards scan error", zap.Error(err))
+			continue
+		}
+		StoryboardIDs = append(StoryboardIDs, StoryboardID)
+	}
+	rows.Close()
+
+	var Snapshots int
+	for _, StoryboardID := range StoryboardIDs {
+		if err := d.createStoryboardSnapshot(StoryboardID, "", true, Retention); err != nil {
+			continue
+		}
+		Snapshots++
+	}
+
+	return Snapshots, nil
+}
+
+// GetStoryboardSnapshots gets the storyboards snapshots newest first, without their contents
+func (d *Database) GetStoryboardSnapshots(StoryboardID string) ([]*model.StoryboardSnapshot, error) {
+	var snapshots = make([]*model.StoryboardSnapshot, 0)
+
+	rows, err := d.db.Query(
+		`SELECT id, COALESCE(user_id::TEXT, ''), automatic, goal_count, story_count, created_date
+		FROM storyboard_snapshot WHERE storyboard_id = $1
+		ORDER BY created_date DESC;`,
+		StoryboardID,
+	)
+	if err != nil {
+		d.logger.Error("get storyboard snapshots query error", zap.Error(err))
+		return nil, errors.New("unable to get storyboard snapshots")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s model.StoryboardSnapshot
+		if err := rows.Scan(&s.Id, &s.UserId, &s.Automatic, &s.GoalCount, &s.StoryCount, &s.CreatedDate); err != nil {
+			d.logger.Error("get storyboard snapshots scan error", zap.Error(err))
+			continue
+		}
+		snapshots = append(snapshots, &s)
+	}
+
+	return snapshots, nil
+}
+
+// GetStoryboardSnapshot gets the storyboards snapshot including its contents
+func (d *Database) GetStoryboardSnapshot(StoryboardID string, SnapshotID string) (*model.StoryboardSnapshot, error) {
+	var s model.StoryboardSnapshot
+	var data string
+
+	if err := d.db.QueryRow(
+		`SELECT id, COALESCE(user_id::TEXT, ''), automatic, goal_count, story_count, created_date, data
+		FROM storyboard_snapshot WHERE storyboard_id = $1 AND id = $2;`,
+		StoryboardID,
+		SnapshotID,
+	).Scan(&s.Id, &s.UserId, &s.Automatic, &s.GoalCount, &s.StoryCount, &s.CreatedDate, &data); err != nil {
+		d.logger.Error("get storyboard snapshot query error", zap.Error(err))
+		return nil, errors.New("SNAPSHOT_NOT_FOUND")
+	}
+
+	if err := json.Unmarshal([]byte(data), &s.Data); err != nil {
+		d.logger.Error("storyboard snapshot json error", zap.Error(err))
+		return nil, errors.New("unable to get storyboard snapshot")
+	}
+
+	return &s, nil
+}
+
+// RestoreStoryboardSnapshot replaces the storyboards goals, columns, and stories with the snapshots,
+// the current state is snapshot first so the restore can itself be rolled back
+func (d *Database) RestoreStoryboardSnapshot(StoryboardID string, UserID string, SnapshotID string) ([]*model.StoryboardGoal, error) {
+	Snapshot, err := d.GetStoryboardSnapshot(StoryboardID, SnapshotID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := d.createStoryboardSnapshot(StoryboardID, UserID, false, maxManualStoryboardSnapshots); err != nil {
+		return nil, err
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		d.logger.Error("restore storyboard snapshot transaction error", zap.Error(err))
+		return nil, errors.New("unable to restore storyboard snapshot")
+	}
+	defer tx.Rollback()
+
+	if err := d.replaceStoryboardGoals(tx, StoryboardID, Snapshot.Data); err != nil {
+		return nil, errors.New("unable to restore storyboard snapshot")
+	}
+
+	if err := tx.Commit(); err != nil {
+		d.logger.Error("restore storyboard snapshot commit error", zap.Error(err))
+		return nil, errors.New("unable to restore storyboard snapshot")
+	}
+
+	return d.GetStoryboardGoals(StoryboardID), nil
+}
+
+// replaceStoryboardGoals replaces the storyboards goals, columns, and stories with the datas
+func (d *Database) replaceStoryboardGoals(tx *sql.Tx, StoryboardID string, Data *model.StoryboardImport) error {
+	// columns, stories, and their comments cascade with the goals
+	if _, err := tx.Exec(`DELETE FROM storyboard_goal WHERE storyboard_id = $1;`, StoryboardID); err != nil {
+		d.logger.Error("replace storyboard goals delete goals query error", zap.Error(err))
+		return err
+	}
+
+	if err := d.insertStoryboardImport(tx, StoryboardID, Data, 0); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`UPDATE storyboard SET updated_date = NOW() WHERE id = $1;`, StoryboardID); err != nil {
+		d.logger.Error("replace storyboard goals update query error", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
@@ -2,7 +2,11 @@ package db
 
 import (
 	"errors"
+	"strings"
+	"unicode/utf8"
+
 	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"github.com/lib/pq"
 	"go.uber.org/zap"
 )
 
@@ -51,10 +55,12 @@ func (d *Database) ReviseStoryContent(StoryboardID string, userID string, StoryI
 		return nil, errors.New("Incorrect permissions")
 	}
 
+	SanitizedContent := d.htmlSanitizerPolicy.Sanitize(StoryContent)
+
 	if _, err := d.db.Exec(
 		`call update_story_content($1, $2);`,
 		StoryID,
-		StoryContent,
+		SanitizedContent,
 	); err != nil {
 		d.logger.Error("call update_story_content error", zap.Error(err))
 	}
@@ -71,6 +77,10 @@ func (d *Database) ReviseStoryColor(StoryboardID string, userID string, StoryID
 		return nil, errors.New("Incorrect permissions")
 	}
 
+	if _, ok := storyColors[StoryColor]; !ok {
+		return nil, errors.New("INVALID_COLOR")
+	}
+
 	if _, err := d.db.Exec(
 		`call update_story_color($1, $2);`,
 		StoryID,
@@ -84,13 +94,52 @@ func (d *Database) ReviseStoryColor(StoryboardID string, userID string, StoryID
 	return goals, nil
 }
 
-// ReviseStoryPoints updates the story points by ID
+// ReviseStoryTags replaces the story tags by ID
+func (d *Database) ReviseStoryTags(StoryboardID string, userID string, StoryID string, Tags []string) ([]*model.StoryboardGoal, error) {
+	err := d.ConfirmStoryboardOwner(StoryboardID, userID)
+	if err != nil {
+		return nil, errors.New("Incorrect permissions")
+	}
+
+	Tags, err = normalizeStoryTags(Tags)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := d.db.Exec(
+		`WITH story AS (
+			UPDATE storyboard_story SET tags = $3, updated_date = NOW() WHERE id = $2 AND storyboard_id = $1 RETURNING storyboard_id
+		)
+		UPDATE storyboard SET updated_date = NOW() WHERE id IN (SELECT storyboard_id FROM story);`,
+		StoryboardID,
+		StoryID,
+		pq.Array(Tags),
+	); err != nil {
+		d.logger.Error("update story tags query error", zap.Error(err))
+	}
+
+	goals := d.GetStoryboardGoals(StoryboardID)
+
+	return goals, nil
+}
+
+// ReviseStoryPoints updates the story points by ID, points must be in the storyboards allowed values (or 0 to clear)
 func (d *Database) ReviseStoryPoints(StoryboardID string, userID string, StoryID string, Points int) ([]*model.StoryboardGoal, error) {
 	err := d.ConfirmStoryboardOwner(StoryboardID, userID)
 	if err != nil {
 		return nil, errors.New("Incorrect permissions")
 	}
 
+	if Points != 0 {
+		PointValues, err := d.GetStoryboardPointValues(StoryboardID)
+		if err != nil {
+			return nil, err
+		}
+		if !containsInt(PointValues, Points) {
+			return nil, errors.New("INVALID_POINT_VALUE")
+		}
+	}
+
 	if _, err := d.db.Exec(
 		`call update_story_points($1, $2);`,
 		StoryID,
@@ -163,16 +212,31 @@ func (d *Database) DeleteStoryboardStory(StoryboardID string, userID string, Sto
 	return goals, nil
 }
 
-// AddStoryComment adds a comment to a story
-func (d *Database) AddStoryComment(StoryboardID string, UserID string, StoryID string, Comment string) ([]*model.StoryboardGoal, error) {
-	if _, err := d.db.Exec(
-		`call story_comment_add($1, $2, $3, $4);`,
+// CreateStoryComment adds the users comment to a story of the storyboard
+func (d *Database) CreateStoryComment(StoryboardID string, UserID string, StoryID string, Comment string) ([]*model.StoryboardGoal, error) {
+	SanitizedComment := d.htmlSanitizerPolicy.Sanitize(Comment)
+	if strings.TrimSpace(SanitizedComment) == "" {
+		return nil, errors.New("INVALID_STORY_COMMENT")
+	}
+
+	res, err := d.db.Exec(
+		`WITH c AS (
+			INSERT INTO storyboard_story_comment (storyboard_id, story_id, user_id, comment)
+			SELECT ss.storyboard_id, ss.id, $3, $4 FROM storyboard_story ss WHERE ss.id = $2 AND ss.storyboard_id = $1
+			RETURNING storyboard_id
+		)
+		UPDATE storyboard SET updated_date = NOW() WHERE id IN (SELECT storyboard_id FROM c);`,
 		StoryboardID,
 		StoryID,
 		UserID,
-		Comment,
-	); err != nil {
-		d.logger.Error("call story_comment_add error", zap.Error(err))
+		SanitizedComment,
+	)
+	if err != nil {
+		d.logger.Error("create story comment query error", zap.Error(err))
+		return nil, errors.New("unable to add story comment")
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return nil, errors.New("STORY_NOT_FOUND")
 	}
 
 	goals := d.GetStoryboardGoals(StoryboardID)
@@ -180,15 +244,31 @@ func (d *Database) AddStoryComment(StoryboardID string, UserID string, StoryID s
 	return goals, nil
 }
 
-// EditStoryComment edits a story comment
-func (d *Database) EditStoryComment(StoryboardID string, CommentID string, Comment string) ([]*model.StoryboardGoal, error) {
-	if _, err := d.db.Exec(
-		`call story_comment_edit($1, $2, $3);`,
+// EditStoryComment edits the users story comment marking it as edited
+func (d *Database) EditStoryComment(StoryboardID string, UserID string, CommentID string, Comment string) ([]*model.StoryboardGoal, error) {
+	SanitizedComment := d.htmlSanitizerPolicy.Sanitize(Comment)
+	if strings.TrimSpace(SanitizedComment) == "" {
+		return nil, errors.New("INVALID_STORY_COMMENT")
+	}
+
+	res, err := d.db.Exec(
+		`WITH c AS (
+			UPDATE storyboard_story_comment SET comment = $4, edited = true, updated_date = NOW()
+			WHERE id = $2 AND storyboard_id = $1 AND user_id = $3
+			RETURNING storyboard_id
+		)
+		UPDATE storyboard SET updated_date = NOW() WHERE id IN (SELECT storyboard_id FROM c);`,
 		StoryboardID,
 		CommentID,
-		Comment,
-	); err != nil {
-		d.logger.Error("call story_comment_edit error", zap.Error(err))
+		UserID,
+		SanitizedComment,
+	)
+	if err != nil {
+		d.logger.Error("edit story comment query error", zap.Error(err))
+		return nil, errors.New("unable to edit story comment")
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return nil, errors.New("STORY_COMMENT_NOT_FOUND")
 	}
 
 	goals := d.GetStoryboardGoals(StoryboardID)
@@ -196,17 +276,52 @@ func (d *Database) EditStoryComment(StoryboardID string, CommentID string, Comme
 	return goals, nil
 }
 
-// DeleteStoryComment deletes a story comment
-func (d *Database) DeleteStoryComment(StoryboardID string, CommentID string) ([]*model.StoryboardGoal, error) {
-	if _, err := d.db.Exec(
-		`call story_comment_delete($1, $2);`,
+// DeleteStoryComment deletes a story comment, only its author or the storyboards owner can delete it
+func (d *Database) DeleteStoryComment(StoryboardID string, UserID string, CommentID string) ([]*model.StoryboardGoal, error) {
+	res, err := d.db.Exec(
+		`WITH c AS (
+			DELETE FROM storyboard_story_comment sc USING storyboard s
+			WHERE sc.id = $2 AND sc.storyboard_id = $1 AND s.id = sc.storyboard_id AND (sc.user_id = $3 OR s.owner_id = $3)
+			RETURNING sc.storyboard_id
+		)
+		UPDATE storyboard SET updated_date = NOW() WHERE id IN (SELECT storyboard_id FROM c);`,
 		StoryboardID,
 		CommentID,
-	); err != nil {
-		d.logger.Error("call story_comment_delete error", zap.Error(err))
+		UserID,
+	)
+	if err != nil {
+		d.logger.Error("delete story comment query error", zap.Error(err))
+		return nil, errors.New("unable to delete story comment")
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return nil, errors.New("STORY_COMMENT_NOT_FOUND")
 	}
 
 	goals := d.GetStoryboardGoals(StoryboardID)
 
 	return goals, nil
 }
+
+// normalizeStoryTags trims the free-form story tags dropping case insensitive duplicates,
+// tags can't be empty or longer than the max tag length and stories have at most the max tags
+func normalizeStoryTags(Tags []string) ([]string, error) {
+	var normalized = make([]string, 0, len(Tags))
+	seen := make(map[string]struct{})
+
+	for _, Tag := range Tags {
+		Tag = strings.TrimSpace(Tag)
+		if Tag == "" || utf8.RuneCountInString(Tag) > maxTagLength {
+			return nil, errors.New("INVALID_TAG")
+		}
+		if _, ok := seen[strings.ToLower(Tag)]; ok {
+			continue
+		}
+		seen[strings.ToLower(Tag)] = struct{}{}
+		normalized = append(normalized, Tag)
+	}
+	if len(normalized) > maxResourceTags {
+		return nil, errors.New("TAG_LIMIT_REACHED")
+	}
+
+	return normalized, nil
+}
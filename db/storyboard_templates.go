@@ -0,0 +1,207 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"go.uber.org/zap"
+)
+
+// storyboardTemplateVisible the condition for the templates the user ($1) can use,
+// their own, their teams, and the default templates
+const storyboardTemplateVisible = `(st.is_default
+	OR (st.team_id IS NULL AND st.owner_id = $1)
+	OR st.team_id IN (SELECT team_id FROM team_user WHERE user_id = $1))`
+
+// storyboardTemplateImport gets the templates goals and columns in the storyboard import schema
+func storyboardTemplateImport(Template *model.StoryboardTemplate) *model.StoryboardImport {
+	return &model.StoryboardImport{
+		Name:    Template.Name,
+		Goals:   Template.Goals,
+		Columns: Template.Columns,
+		Stories: make([]*model.StoryboardImportStory, 0),
+	}
+}
+
+// CreateStoryboardTemplate creates a storyboard template returning the problems found when its structure is invalid
+func (d *Database) CreateStoryboardTemplate(Template *model.StoryboardTemplate) (*model.StoryboardTemplate, []string, error) {
+	if Template.Goals == nil {
+		Template.Goals = make([]*model.StoryboardImportGoal, 0)
+	}
+	if Template.Columns == nil {
+		Template.Columns = make([]*model.StoryboardImportColumn, 0)
+	}
+	problems := validateStoryboardImport(storyboardTemplateImport(Template))
+	if len(Template.Goals) == 0 {
+		problems = append(problems, "goals NO_GOALS")
+	}
+	if len(problems) > 0 {
+		return nil, problems, errors.New("INVALID_STORYBOARD_TEMPLATE")
+	}
+
+	Goals, _ := json.Marshal(Template.Goals)
+	Columns, _ := json.Marshal(Template.Columns)
+
+	if err := d.db.QueryRow(
+		`INSERT INTO storyboard_template (name, description, owner_id, team_id, is_default, goals, columns)
+		VALUES ($1, $2, NULLIF($3, '')::UUID, NULLIF($4, '')::UUID, $5, $6, $7)
+		RETURNING id, created_date;`,
+		Template.Name,
+		Template.Description,
+		Template.OwnerId,
+		Template.TeamId,
+		Template.IsDefault,
+		string(Goals),
+		string(Columns),
+	).Scan(&Template.Id, &Template.CreatedDate); err != nil {
+		d.logger.Error("create storyboard template query error", zap.Error(err))
+		return nil, nil, errors.New("unable to create storyboard template")
+	}
+
+	return Template, nil, nil
+}
+
+// GetStoryboardTemplates gets the storyboard templates the user can use, default templates first
+func (d *Database) GetStoryboardTemplates(UserID string) ([]*model.StoryboardTemplate, error) {
+	var Templates = make([]*model.StoryboardTemplate, 0)
+
+	rows, err := d.db.Query(
+		`SELECT st.id, st.name, st.description, COALESCE(st.owner_id::TEXT, ''), COALESCE(st.team_id::TEXT, ''),
+			st.is_default, st.goals, st.columns, st.created_date
+		FROM storyboard_template st
+		WHERE `+storyboardTemplateVisible+`
+		ORDER BY st.is_default DESC, st.name;`,
+		UserID,
+	)
+	if err != nil {
+		d.logger.Error("get storyboard templates query error", zap.Error(err))
+		return nil, errors.New("unable to get storyboard templates")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		Template, err := d.scanStoryboardTemplate(rows.Scan)
+		if err != nil {
+			return nil, errors.New("unable to get storyboard templates")
+		}
+		Templates = append(Templates, Template)
+	}
+
+	return Templates, nil
+}
+
+// GetStoryboardTemplate gets a storyboard template the user can use
+func (d *Database) GetStoryboardTemplate(UserID string, TemplateID string) (*model.StoryboardTemplate, error) {
+	Template, err := d.scanStoryboardTemplate(d.db.QueryRow(
+		`SELECT st.id, st.name, st.description, COALESCE(st.owner_id::TEXT, ''), COALESCE(st.team_id::TEXT, ''),
+			st.is_default, st.goals, st.columns, st.created_date
+		FROM storyboard_template st
+		WHERE st.id = $2 AND `+storyboardTemplateVisible+`;`,
+		UserID,
+		TemplateID,
+	).Scan)
+	if err != nil {
+		return nil, errors.New("TEMPLATE_NOT_FOUND")
+	}
+
+	return Template, nil
+}
+
+// scanStoryboardTemplate scans a storyboard template row
+func (d *Database) scanStoryboardTemplate(scan func(dest ...interface{}) error) (*model.StoryboardTemplate, error) {
+	var t model.StoryboardTemplate
+	var Goals, Columns string
+
+	if err := scan(
+		&t.Id, &t.Name, &t.Description, &t.OwnerId, &t.TeamId, &t.IsDefault, &Goals, &Columns, &t.CreatedDate,
+	); err != nil {
+		d.logger.Error("storyboard template query scan error", zap.Error(err))
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(Goals), &t.Goals); err != nil {
+		d.logger.Error("storyboard template goals json error", zap.Error(err))
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(Columns), &t.Columns); err != nil {
+		d.logger.Error("storyboard template columns json error", zap.Error(err))
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+// CreateStoryboardFromTemplate creates a storyboard owned by the user with the templates goals and columns
+func (d *Database) CreateStoryboardFromTemplate(OwnerID string, TemplateID string, StoryboardName string, JoinCode string, PointValuesAllowed []int) (*model.Storyboard, error) {
+	Template, err := d.GetStoryboardTemplate(OwnerID, TemplateID)
+	if err != nil {
+		return nil, err
+	}
+
+	Storyboard, err := d.CreateStoryboard(OwnerID, StoryboardName, JoinCode, PointValuesAllowed)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := d.applyStoryboardTemplate(Storyboard.StoryboardID, Template); err != nil {
+		if _, err := d.db.Exec(`DELETE FROM storyboard WHERE id = $1;`, Storyboard.StoryboardID); err != nil {
+			d.logger.Error("delete storyboard created from template query error", zap.Error(err))
+		}
+		return nil, err
+	}
+	Storyboard.Goals = d.GetStoryboardGoals(Storyboard.StoryboardID)
+
+	return Storyboard, nil
+}
+
+// ApplyStoryboardTemplate adds the templates goals and columns after the storyboards existing goals
+func (d *Database) ApplyStoryboardTemplate(StoryboardID string, UserID string, TemplateID string) ([]*model.StoryboardGoal, error) {
+	Template, err := d.GetStoryboardTemplate(UserID, TemplateID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := d.applyStoryboardTemplate(StoryboardID, Template); err != nil {
+		return nil, err
+	}
+
+	return d.GetStoryboardGoals(StoryboardID), nil
+}
+
+// applyStoryboardTemplate inserts the templates goals and columns after the storyboards existing goals
+func (d *Database) applyStoryboardTemplate(StoryboardID string, Template *model.StoryboardTemplate) error {
+	var GoalSortOffset int
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		d.logger.Error("apply storyboard template transaction error", zap.Error(err))
+		return errors.New("unable to apply storyboard template")
+	}
+	defer tx.Rollback()
+
+	// the storyboard is locked so goals added concurrently can't take the same sort order
+	if err := tx.QueryRow(
+		`SELECT (SELECT COALESCE(MAX(sort_order), 0) FROM storyboard_goal WHERE storyboard_id = s.id)
+		FROM storyboard s WHERE s.id = $1 FOR UPDATE;`,
+		StoryboardID,
+	).Scan(&GoalSortOffset); err != nil {
+		d.logger.Error("apply storyboard template query error", zap.Error(err))
+		return errors.New("STORYBOARD_NOT_FOUND")
+	}
+
+	if err := d.insertStoryboardImport(tx, StoryboardID, storyboardTemplateImport(Template), GoalSortOffset); err != nil {
+		return errors.New("unable to apply storyboard template")
+	}
+
+	if _, err := tx.Exec(`UPDATE storyboard SET updated_date = NOW() WHERE id = $1;`, StoryboardID); err != nil {
+		d.logger.Error("apply storyboard template update query error", zap.Error(err))
+		return errors.New("unable to apply storyboard template")
+	}
+
+	if err := tx.Commit(); err != nil {
+		d.logger.Error("apply storyboard template commit error", zap.Error(err))
+		return errors.New("unable to apply storyboard template")
+	}
+
+	return nil
+}
@@ -3,14 +3,42 @@ package db
 import (
 	"encoding/json"
 	"errors"
+	"strings"
+
 	"github.com/StevenWeathers/thunderdome-planning-poker/model"
 	"go.uber.org/zap"
 )
 
-//CreateStoryboard adds a new storyboard to the db
-func (d *Database) CreateStoryboard(OwnerID string, StoryboardName string, JoinCode string) (*model.Storyboard, error) {
+// maxStoryPointValue the largest point value a storyboard can allow for its stories
+const maxStoryPointValue = 1000
+
+// validateStoryboardPointValues validates the storyboards allowed story point values
+// are unique, between 0 and the max point value, and within the max number of values
+func validateStoryboardPointValues(PointValues []int) error {
+	if len(PointValues) == 0 || len(PointValues) > maxCustomPointValues {
+		return errors.New("INVALID_POINT_VALUES")
+	}
+	seen := make(map[int]struct{})
+	for _, Value := range PointValues {
+		if _, ok := seen[Value]; ok || Value < 0 || Value > maxStoryPointValue {
+			return errors.New("INVALID_POINT_VALUES")
+		}
+		seen[Value] = struct{}{}
+	}
+
+	return nil
+}
+
+//CreateStoryboard adds a new storyboard to the db, using the default allowed story point values when none are provided
+func (d *Database) CreateStoryboard(OwnerID string, StoryboardName string, JoinCode string, PointValuesAllowed []int) (*model.Storyboard, error) {
 	var encryptedJoinCode string
 
+	if len(PointValuesAllowed) > 0 {
+		if err := validateStoryboardPointValues(PointValuesAllowed); err != nil {
+			return nil, err
+		}
+	}
+
 	if JoinCode != "" {
 		EncryptedCode, codeErr := encrypt(JoinCode, d.config.AESHashkey)
 		if codeErr != nil {
@@ -37,6 +65,18 @@ func (d *Database) CreateStoryboard(OwnerID string, StoryboardName string, JoinC
 		return nil, errors.New("error creating storyboard")
 	}
 
+	// when point values are provided replace the default allowed story point values
+	if len(PointValuesAllowed) > 0 {
+		pointValuesJSON, _ := json.Marshal(PointValuesAllowed)
+		if _, err := d.db.Exec(
+			`call revise_storyboard_point_values($1, $2);`,
+			b.StoryboardID,
+			string(pointValuesJSON),
+		); err != nil {
+			d.logger.Error("call revise_storyboard_point_values error", zap.Error(err))
+		}
+	}
+
 	// if a join code is set than add owner to retro_user
 	// this prevents them from having to enter join code on initial create to enter
 	if JoinCode != "" {
@@ -78,6 +118,7 @@ func (d *Database) EditStoryboard(StoryboardID string, StoryboardName string, Jo
 // GetStoryboard gets a storyboard by ID
 func (d *Database) GetStoryboard(StoryboardID string) (*model.Storyboard, error) {
 	var cl string
+	var pv string
 	var JoinCode string
 	var b = &model.Storyboard{
 		StoryboardID:   StoryboardID,
@@ -87,17 +128,19 @@ func (d *Database) GetStoryboard(StoryboardID string) (*model.Storyboard, error)
 		Goals:          make([]*model.StoryboardGoal, 0),
 		ColorLegend:    make([]*model.Color, 0),
 		Personas:       make([]*model.StoryboardPersona, 0),
+		PointValues:    make([]int, 0),
 	}
 
 	// get storyboard
 	e := d.db.QueryRow(
-		`SELECT id, name, owner_id, color_legend, COALESCE(join_code, ''), created_date, updated_date FROM storyboard WHERE id = $1`,
+		`SELECT id, name, owner_id, color_legend, COALESCE(point_values_allowed, '[]'::JSONB), COALESCE(join_code, ''), created_date, updated_date FROM storyboard WHERE id = $1`,
 		StoryboardID,
 	).Scan(
 		&b.StoryboardID,
 		&b.StoryboardName,
 		&b.OwnerID,
 		&cl,
+		&pv,
 		&JoinCode,
 		&b.CreatedDate,
 		&b.UpdatedDate,
@@ -112,6 +155,11 @@ func (d *Database) GetStoryboard(StoryboardID string) (*model.Storyboard, error)
 		d.logger.Error("color legend json error", zap.Error(clErr))
 	}
 
+	pvErr := json.Unmarshal([]byte(pv), &b.PointValues)
+	if pvErr != nil {
+		d.logger.Error("point values json error", zap.Error(pvErr))
+	}
+
 	b.Users = d.GetStoryboardUsers(StoryboardID)
 	b.Goals = d.GetStoryboardGoals(StoryboardID)
 	b.Personas = d.GetStoryboardPersonas(StoryboardID)
@@ -127,12 +175,16 @@ func (d *Database) GetStoryboard(StoryboardID string) (*model.Storyboard, error)
 	return b, nil
 }
 
-// GetStoryboardsByUser gets a list of storyboards by UserID
-func (d *Database) GetStoryboardsByUser(UserID string) ([]*model.Storyboard, int, error) {
+// GetStoryboardsByUser gets a list of storyboards by UserID, optionally filtered by the users tag
+func (d *Database) GetStoryboardsByUser(UserID string, Tag string) ([]*model.Storyboard, int, error) {
+	Tag = strings.ToLower(strings.TrimSpace(Tag))
 	var storyboards = make([]*model.Storyboard, 0)
 	storyboardRows, storyboardsErr := d.db.Query(`
-		SELECT * FROM get_storyboards_by_user($1);
-	`, UserID)
+		SELECT s.id, s.name, s.owner_id,
+		(SELECT COALESCE(json_agg(st.tag ORDER BY st.tag), '[]') FROM storyboard_tag st WHERE st.storyboard_id = s.id AND st.user_id = $1) AS tags
+		FROM get_storyboards_by_user($1) s
+		WHERE $2 = '' OR EXISTS(SELECT 1 FROM storyboard_tag st WHERE st.storyboard_id = s.id AND st.user_id = $1 AND st.tag = $2);
+	`, UserID, Tag)
 	if storyboardsErr != nil {
 		return nil, 0, errors.New("Not found")
 	}
@@ -145,13 +197,16 @@ func (d *Database) GetStoryboardsByUser(UserID string) ([]*model.Storyboard, int
 			StoryboardName: "",
 			Users:          make([]*model.StoryboardUser, 0),
 		}
+		var tags string
 		if err := storyboardRows.Scan(
 			&b.StoryboardID,
 			&b.StoryboardName,
 			&b.OwnerID,
+			&tags,
 		); err != nil {
 			d.logger.Error("get_storyboards_by_user query scan error", zap.Error(err))
 		} else {
+			_ = json.Unmarshal([]byte(tags), &b.Tags)
 			storyboards = append(storyboards, b)
 		}
 	}
@@ -175,6 +230,79 @@ func (d *Database) ConfirmStoryboardOwner(StoryboardID string, userID string) er
 	return nil
 }
 
+// ConfirmStoryboardUser confirms the user is the storyboards owner or has joined it
+func (d *Database) ConfirmStoryboardUser(StoryboardID string, UserID string) error {
+	var IsUser bool
+	e := d.db.QueryRow(
+		`SELECT EXISTS(
+			SELECT 1 FROM storyboard s
+			LEFT JOIN storyboard_user su ON su.storyboard_id = s.id AND su.user_id = $2
+			WHERE s.id = $1 AND (s.owner_id = $2 OR (su.user_id IS NOT NULL AND su.abandoned = false))
+		);`,
+		StoryboardID,
+		UserID,
+	).Scan(&IsUser)
+	if e != nil {
+		d.logger.Error("confirm storyboard user query error", zap.Error(e))
+		return errors.New("STORYBOARD_NOT_FOUND")
+	}
+
+	if !IsUser {
+		return errors.New("REQUIRES_STORYBOARD_USER")
+	}
+
+	return nil
+}
+
+// Storyboard user roles, facilitators are the storyboard owners
+const (
+	StoryboardRoleFacilitator = "FACILITATOR"
+	StoryboardRoleParticipant = "PARTICIPANT"
+	StoryboardRoleContributor = "CONTRIBUTOR"
+	StoryboardRoleViewer      = "VIEWER"
+)
+
+// GetStoryboardUserRole gets the users role in the storyboard, owners are always facilitators
+func (d *Database) GetStoryboardUserRole(StoryboardID string, UserID string) (string, error) {
+	var Role string
+
+	e := d.db.QueryRow(
+		`SELECT CASE WHEN s.owner_id = $2 THEN 'FACILITATOR' ELSE COALESCE(su.role, 'PARTICIPANT') END
+		FROM storyboard s
+		LEFT JOIN storyboard_user su ON su.storyboard_id = s.id AND su.user_id = $2
+		WHERE s.id = $1;`,
+		StoryboardID,
+		UserID,
+	).Scan(&Role)
+	if e != nil {
+		d.logger.Error("get storyboard user role query error", zap.Error(e))
+		return "", errors.New("STORYBOARD_NOT_FOUND")
+	}
+
+	return Role, nil
+}
+
+// SetStoryboardUserRole sets the role of a (non owner) user in the storyboard
+func (d *Database) SetStoryboardUserRole(StoryboardID string, UserID string, Role string) ([]*model.StoryboardUser, error) {
+	if Role != StoryboardRoleParticipant && Role != StoryboardRoleContributor && Role != StoryboardRoleViewer {
+		return nil, errors.New("INVALID_STORYBOARD_ROLE")
+	}
+
+	if _, err := d.db.Exec(
+		`UPDATE storyboard_user SET role = $3 WHERE storyboard_id = $1 AND user_id = $2;`,
+		StoryboardID,
+		UserID,
+		Role,
+	); err != nil {
+		d.logger.Error("update storyboard user role error", zap.Error(err))
+		return nil, errors.New("unable to set storyboard user role")
+	}
+
+	users := d.GetStoryboardUsers(StoryboardID)
+
+	return users, nil
+}
+
 // GetStoryboardUser gets a user from db by ID and checks storyboard active status
 func (d *Database) GetStoryboardUser(StoryboardID string, UserID string) (*model.StoryboardUser, error) {
 	var active bool
@@ -212,7 +340,7 @@ func (d *Database) GetStoryboardUsers(StoryboardID string) []*model.StoryboardUs
 		defer rows.Close()
 		for rows.Next() {
 			var w model.StoryboardUser
-			if err := rows.Scan(&w.UserID, &w.UserName, &w.Active, &w.Avatar, &w.GravatarHash); err != nil {
+			if err := rows.Scan(&w.UserID, &w.UserName, &w.Active, &w.Avatar, &w.GravatarHash, &w.Role); err != nil {
 				d.logger.Error("get_storyboard_users query scan error", zap.Error(err))
 			} else {
 				if w.GravatarHash != "" {
@@ -372,6 +500,53 @@ func (d *Database) StoryboardReviseColorLegend(StoryboardID string, UserID strin
 	return storyboard, nil
 }
 
+// StoryboardRevisePointValues revises the storyboard allowed story point values by StoryboardID
+func (d *Database) StoryboardRevisePointValues(StoryboardID string, UserID string, PointValues []int) (*model.Storyboard, error) {
+	err := d.ConfirmStoryboardOwner(StoryboardID, UserID)
+	if err != nil {
+		return nil, errors.New("Incorrect permissions")
+	}
+	if err := validateStoryboardPointValues(PointValues); err != nil {
+		return nil, err
+	}
+
+	pointValuesJSON, _ := json.Marshal(PointValues)
+	if _, err := d.db.Exec(
+		`call revise_storyboard_point_values($1, $2);`,
+		StoryboardID,
+		string(pointValuesJSON),
+	); err != nil {
+		d.logger.Error("call revise_storyboard_point_values error", zap.Error(err))
+		return nil, err
+	}
+
+	storyboard, err := d.GetStoryboard(StoryboardID)
+	if err != nil {
+		return nil, errors.New("Unable to revise point values")
+	}
+
+	return storyboard, nil
+}
+
+// GetStoryboardPointValues gets the allowed story point values for the storyboard
+func (d *Database) GetStoryboardPointValues(StoryboardID string) ([]int, error) {
+	var pv string
+	var pointValues = make([]int, 0)
+
+	err := d.db.QueryRow(
+		`SELECT COALESCE(point_values_allowed, '[]'::JSONB) FROM storyboard WHERE id = $1`,
+		StoryboardID,
+	).Scan(&pv)
+	if err != nil {
+		d.logger.Error("get storyboard point values query error", zap.Error(err))
+		return nil, errors.New("Storyboard Not found")
+	}
+
+	_ = json.Unmarshal([]byte(pv), &pointValues)
+
+	return pointValues, nil
+}
+
 // DeleteStoryboard removes all storyboard associations and the storyboard itself from DB by StoryboardID
 func (d *Database) DeleteStoryboard(StoryboardID string, userID string) error {
 	err := d.ConfirmStoryboardOwner(StoryboardID, userID)
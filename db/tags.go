@@ -0,0 +1,205 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// maxTagLength the max length of a tag
+	maxTagLength = 32
+	// maxResourceTags the max number of tags a user can put on a battle or storyboard
+	maxResourceTags = 10
+)
+
+// tagPattern the allowed tag charset, lowercase letters, numbers, dashes and underscores
+var tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// tagTables the tag table, its resource id column, and the query confirming the user
+// is part of the resource for each taggable resource
+var tagTables = map[string]struct {
+	table    string
+	idColumn string
+	member   string
+}{
+	"battle": {
+		"battle_tag", "battle_id",
+		`SELECT EXISTS(SELECT 1 FROM battles_users WHERE battle_id = $1 AND user_id = $2 AND abandoned = false);`,
+	},
+	"storyboard": {
+		"storyboard_tag", "storyboard_id",
+		`SELECT EXISTS(SELECT 1 FROM storyboard_user WHERE storyboard_id = $1 AND user_id = $2 AND abandoned = false);`,
+	},
+}
+
+// normalizeTag trims and lowercases the tag making sure it's a valid tag
+func normalizeTag(Tag string) (string, error) {
+	Tag = strings.ToLower(strings.TrimSpace(Tag))
+	if len(Tag) > maxTagLength || !tagPattern.MatchString(Tag) {
+		return "", errors.New("INVALID_TAG")
+	}
+
+	return Tag, nil
+}
+
+// addTag tags the resource for the user, returning the users tags of the resource
+func (d *Database) addTag(Resource string, ResourceID string, UserID string, Tag string) ([]string, error) {
+	t := tagTables[Resource]
+
+	Tag, err := normalizeTag(Tag)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		d.logger.Error("add tag transaction error", zap.Error(err))
+		return nil, errors.New("unable to add tag")
+	}
+	defer tx.Rollback()
+
+	var IsMember bool
+	if err := tx.QueryRow(t.member, ResourceID, UserID).Scan(&IsMember); err != nil {
+		d.logger.Error("add tag confirm user query error", zap.Error(err))
+		return nil, errors.New("unable to add tag")
+	}
+	if !IsMember {
+		return nil, errors.New(strings.ToUpper(Resource) + "_NOT_FOUND")
+	}
+
+	// lock the user so concurrent adds can't exceed the cap
+	if _, err := tx.Exec(`SELECT id FROM users WHERE id = $1 FOR UPDATE;`, UserID); err != nil {
+		d.logger.Error("add tag lock user query error", zap.Error(err))
+		return nil, errors.New("unable to add tag")
+	}
+
+	var Count int
+	var Exists bool
+	if err := tx.QueryRow(
+		`SELECT COUNT(*), COALESCE(bool_or(tag = $3), false) FROM `+t.table+` WHERE `+t.idColumn+` = $1 AND user_id = $2;`,
+		ResourceID,
+		UserID,
+		Tag,
+	).Scan(&Count, &Exists); err != nil {
+		d.logger.Error("add tag count query error", zap.Error(err))
+		return nil, errors.New("unable to add tag")
+	}
+	if !Exists && Count >= maxResourceTags {
+		return nil, errors.New("TAG_LIMIT_REACHED")
+	}
+
+	if _, err := tx.Exec(
+		`INSERT INTO `+t.table+` (`+t.idColumn+`, user_id, tag) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING;`,
+		ResourceID,
+		UserID,
+		Tag,
+	); err != nil {
+		d.logger.Error("add tag query error", zap.Error(err))
+		return nil, errors.New("unable to add tag")
+	}
+
+	if err := tx.Commit(); err != nil {
+		d.logger.Error("add tag commit error", zap.Error(err))
+		return nil, errors.New("unable to add tag")
+	}
+
+	return d.getTags(Resource, ResourceID, UserID)
+}
+
+// removeTag removes the users tag from the resource, returning the users remaining tags of the resource
+func (d *Database) removeTag(Resource string, ResourceID string, UserID string, Tag string) ([]string, error) {
+	t := tagTables[Resource]
+
+	if _, err := d.db.Exec(
+		`DELETE FROM `+t.table+` WHERE `+t.idColumn+` = $1 AND user_id = $2 AND tag = $3;`,
+		ResourceID,
+		UserID,
+		strings.ToLower(strings.TrimSpace(Tag)),
+	); err != nil {
+		d.logger.Error("remove tag query error", zap.Error(err))
+		return nil, errors.New("unable to remove tag")
+	}
+
+	return d.getTags(Resource, ResourceID, UserID)
+}
+
+// getTags gets the users tags of the resource
+func (d *Database) getTags(Resource string, ResourceID string, UserID string) ([]string, error) {
+	t := tagTables[Resource]
+	var Tags string
+
+	if err := d.db.QueryRow(
+		`SELECT COALESCE(json_agg(tag ORDER BY tag), '[]') FROM `+t.table+` WHERE `+t.idColumn+` = $1 AND user_id = $2;`,
+		ResourceID,
+		UserID,
+	).Scan(&Tags); err != nil {
+		d.logger.Error("get tags query error", zap.Error(err))
+		return nil, errors.New("unable to get tags")
+	}
+
+	var tags = make([]string, 0)
+	_ = json.Unmarshal([]byte(Tags), &tags)
+
+	return tags, nil
+}
+
+// AddBattleTag tags the battle for the user
+func (d *Database) AddBattleTag(BattleID string, UserID string, Tag string) ([]string, error) {
+	return d.addTag("battle", BattleID, UserID, Tag)
+}
+
+// RemoveBattleTag removes the users tag from the battle
+func (d *Database) RemoveBattleTag(BattleID string, UserID string, Tag string) ([]string, error) {
+	return d.removeTag("battle", BattleID, UserID, Tag)
+}
+
+// AddStoryboardTag tags the storyboard for the user
+func (d *Database) AddStoryboardTag(StoryboardID string, UserID string, Tag string) ([]string, error) {
+	return d.addTag("storyboard", StoryboardID, UserID, Tag)
+}
+
+// RemoveStoryboardTag removes the users tag from the storyboard
+func (d *Database) RemoveStoryboardTag(StoryboardID string, UserID string, Tag string) ([]string, error) {
+	return d.removeTag("storyboard", StoryboardID, UserID, Tag)
+}
+
+// GetUserTags gets the tags the user has used on battles and storyboards starting with the prefix,
+// most used first for autocompletion
+func (d *Database) GetUserTags(UserID string, Prefix string, Limit int) ([]string, error) {
+	var tags = make([]string, 0)
+	// escape LIKE wildcards, valid tags can contain _
+	Prefix = escapeLikePattern(strings.ToLower(strings.TrimSpace(Prefix)))
+
+	rows, err := d.db.Query(
+		`SELECT tag FROM (
+			SELECT tag FROM battle_tag WHERE user_id = $1
+			UNION ALL
+			SELECT tag FROM storyboard_tag WHERE user_id = $1
+		) t WHERE tag LIKE $2 || '%'
+		GROUP BY tag ORDER BY COUNT(*) DESC, tag
+		LIMIT $3;`,
+		UserID,
+		Prefix,
+		Limit,
+	)
+	if err != nil {
+		d.logger.Error("get user tags query error", zap.Error(err))
+		return nil, errors.New("unable to get tags")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var Tag string
+		if err := rows.Scan(&Tag); err != nil {
+			d.logger.Error("get user tags scan error", zap.Error(err))
+			continue
+		}
+		tags = append(tags, Tag)
+	}
+
+	return tags, nil
+}
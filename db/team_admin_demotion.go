@@ -0,0 +1,98 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"go.uber.org/zap"
+)
+
+// GetInactiveTeamAdmins gets registered team admins that haven't been active for the number of days,
+// first clearing demotion notices of admins that have been active since being notified
+func (d *Database) GetInactiveTeamAdmins(InactiveDays int) ([]*model.InactiveTeamAdmin, error) {
+	var admins = make([]*model.InactiveTeamAdmin, 0)
+
+	if _, err := d.db.Exec(
+		`UPDATE team_user tu SET demotion_notice_date = NULL
+		FROM users u
+		WHERE u.id = tu.user_id AND tu.demotion_notice_date IS NOT NULL AND u.last_active > tu.demotion_notice_date;`,
+	); err != nil {
+		d.logger.Error("clear team admin demotion notices query error", zap.Error(err))
+	}
+
+	rows, err := d.db.Query(
+		`SELECT t.id, t.name, u.id, u.name, COALESCE(u.email, ''), u.last_active, tu.demotion_notice_date
+		FROM team_user tu
+		JOIN team t ON t.id = tu.team_id
+		JOIN users u ON u.id = tu.user_id
+		WHERE tu.role = 'ADMIN' AND u.type != 'GUEST'
+		AND u.last_active < NOW() - make_interval(days => $1)
+		ORDER BY t.id, u.last_active;`,
+		InactiveDays,
+	)
+	if err != nil {
+		d.logger.Error("get inactive team admins query error", zap.Error(err))
+		return nil, errors.New("unable to get inactive team admins")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a model.InactiveTeamAdmin
+		var NoticeDate sql.NullTime
+		if err := rows.Scan(
+			&a.TeamId, &a.TeamName, &a.UserId, &a.UserName, &a.UserEmail, &a.LastActive, &NoticeDate,
+		); err != nil {
+			d.logger.Error("get inactive team admins scan error", zap.Error(err))
+			continue
+		}
+		if NoticeDate.Valid {
+			a.DemotionNoticeDate = &NoticeDate.Time
+		}
+		admins = append(admins, &a)
+	}
+
+	return admins, nil
+}
+
+// SetTeamAdminDemotionNotice records that the team admin was notified of their upcoming demotion
+func (d *Database) SetTeamAdminDemotionNotice(TeamID string, UserID string) error {
+	if _, err := d.db.Exec(
+		`UPDATE team_user SET demotion_notice_date = NOW() WHERE team_id = $1 AND user_id = $2;`,
+		TeamID,
+		UserID,
+	); err != nil {
+		d.logger.Error("set team admin demotion notice query error", zap.Error(err))
+		return errors.New("unable to set team admin demotion notice")
+	}
+
+	return nil
+}
+
+// DemoteInactiveTeamAdmin demotes an inactive team admin to member and audits the demotion,
+// the last remaining team admin is never demoted
+func (d *Database) DemoteInactiveTeamAdmin(Admin *model.InactiveTeamAdmin) error {
+	if err := d.TeamUpdateUserRole(Admin.TeamId, Admin.UserId, "MEMBER"); err != nil {
+		return err
+	}
+
+	if _, err := d.db.Exec(
+		`UPDATE team_user SET demotion_notice_date = NULL WHERE team_id = $1 AND user_id = $2;`,
+		Admin.TeamId,
+		Admin.UserId,
+	); err != nil {
+		d.logger.Error("clear team admin demotion notice query error", zap.Error(err))
+	}
+
+	if _, err := d.db.Exec(
+		`INSERT INTO team_admin_demotion (team_id, user_id, last_active) VALUES ($1, $2, $3);`,
+		Admin.TeamId,
+		Admin.UserId,
+		Admin.LastActive,
+	); err != nil {
+		d.logger.Error("insert team admin demotion audit query error", zap.Error(err))
+		return errors.New("unable to audit team admin demotion")
+	}
+
+	return nil
+}
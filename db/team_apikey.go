@@ -0,0 +1,165 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"go.uber.org/zap"
+)
+
+// TeamAPIKeyPrefix prefix used to distinguish team API keys from user API keys
+const TeamAPIKeyPrefix = "tk_"
+
+// CreateTeamAPIKey generates a new API key for a Team with the given scopes
+func (d *Database) CreateTeamAPIKey(TeamID string, UserID string, KeyName string, Scopes []string) (*model.TeamAPIKey, error) {
+	apiPrefix, prefixErr := randomString(8)
+	if prefixErr != nil {
+		d.logger.Error("error generating team api prefix", zap.Error(prefixErr))
+		return nil, errors.New("error generating api prefix")
+	}
+	apiPrefix = TeamAPIKeyPrefix + apiPrefix
+
+	apiSecret, secretErr := randomString(32)
+	if secretErr != nil {
+		d.logger.Error("error generating team api secret", zap.Error(secretErr))
+		return nil, errors.New("error generating api secret")
+	}
+
+	APIKEY := &model.TeamAPIKey{
+		Name:      KeyName,
+		Key:       apiPrefix + "." + apiSecret,
+		TeamId:    TeamID,
+		Prefix:    apiPrefix,
+		Scopes:    Scopes,
+		Active:    true,
+		CreatedBy: UserID,
+	}
+	hashedKey := hashString(APIKEY.Key)
+	APIKEY.Id = apiPrefix + "." + hashedKey
+	scopesJSON, _ := json.Marshal(Scopes)
+
+	e := d.db.QueryRow(
+		`INSERT INTO team_apikey (id, team_id, name, scopes, created_by) VALUES ($1, $2, $3, $4, $5)
+		RETURNING created_date, updated_date;`,
+		APIKEY.Id,
+		TeamID,
+		KeyName,
+		string(scopesJSON),
+		UserID,
+	).Scan(&APIKEY.CreatedDate, &APIKEY.UpdatedDate)
+	if e != nil {
+		d.logger.Error("insert team_apikey query error", zap.Error(e))
+		return nil, errors.New("unable to create new team api key")
+	}
+
+	return APIKEY, nil
+}
+
+// GetTeamAPIKeys gets a list of api keys for a team
+func (d *Database) GetTeamAPIKeys(TeamID string) ([]*model.TeamAPIKey, error) {
+	var APIKeys = make([]*model.TeamAPIKey, 0)
+
+	rows, err := d.db.Query(
+		`SELECT id, team_id, name, scopes, active, COALESCE(created_by::TEXT, ''), created_date, updated_date, last_used_date
+		FROM team_apikey WHERE team_id = $1 ORDER BY created_date;`,
+		TeamID,
+	)
+	if err != nil {
+		d.logger.Error("get team_apikey query error", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		ak, err := scanTeamAPIKey(rows)
+		if err != nil {
+			d.logger.Error("get team_apikey query scan error", zap.Error(err))
+		} else {
+			APIKeys = append(APIKeys, ak)
+		}
+	}
+
+	return APIKeys, nil
+}
+
+// UpdateTeamAPIKey updates a team api key (active column only)
+func (d *Database) UpdateTeamAPIKey(TeamID string, KeyID string, Active bool) ([]*model.TeamAPIKey, error) {
+	if _, err := d.db.Exec(
+		`UPDATE team_apikey SET active = $3, updated_date = NOW() WHERE id = $1 AND team_id = $2;`,
+		KeyID,
+		TeamID,
+		Active,
+	); err != nil {
+		d.logger.Error("update team_apikey query error", zap.Error(err))
+		return nil, err
+	}
+
+	return d.GetTeamAPIKeys(TeamID)
+}
+
+// DeleteTeamAPIKey removes a team api key
+func (d *Database) DeleteTeamAPIKey(TeamID string, KeyID string) ([]*model.TeamAPIKey, error) {
+	if _, err := d.db.Exec(
+		`DELETE FROM team_apikey WHERE id = $1 AND team_id = $2;`,
+		KeyID,
+		TeamID,
+	); err != nil {
+		d.logger.Error("delete team_apikey query error", zap.Error(err))
+		return nil, err
+	}
+
+	return d.GetTeamAPIKeys(TeamID)
+}
+
+// GetTeamAPIKey checks to see if the active team API key exists, recording its use
+func (d *Database) GetTeamAPIKey(APK string) (*model.TeamAPIKey, error) {
+	splitKey := strings.Split(APK, ".")
+	hashedKey := hashString(APK)
+	keyID := splitKey[0] + "." + hashedKey
+
+	row := d.db.QueryRow(
+		`UPDATE team_apikey SET last_used_date = NOW() WHERE id = $1 AND active = true
+		RETURNING id, team_id, name, scopes, active, COALESCE(created_by::TEXT, ''), created_date, updated_date, last_used_date;`,
+		keyID,
+	)
+	ak, err := scanTeamAPIKey(row)
+	if err != nil {
+		d.logger.Error("get team_apikey query error", zap.Error(err))
+		return nil, errors.New("active team API Key match not found")
+	}
+
+	return ak, nil
+}
+
+// scanTeamAPIKey scans a team api key row
+func scanTeamAPIKey(row interface{ Scan(...interface{}) error }) (*model.TeamAPIKey, error) {
+	var ak model.TeamAPIKey
+	var scopes string
+	var lastUsed sql.NullTime
+
+	if err := row.Scan(
+		&ak.Id,
+		&ak.TeamId,
+		&ak.Name,
+		&scopes,
+		&ak.Active,
+		&ak.CreatedBy,
+		&ak.CreatedDate,
+		&ak.UpdatedDate,
+		&lastUsed,
+	); err != nil {
+		return nil, err
+	}
+
+	ak.Prefix = strings.Split(ak.Id, ".")[0]
+	ak.Scopes = make([]string, 0)
+	_ = json.Unmarshal([]byte(scopes), &ak.Scopes)
+	if lastUsed.Valid {
+		ak.LastUsedDate = &lastUsed.Time
+	}
+
+	return &ak, nil
+}
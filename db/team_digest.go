@@ -0,0 +1,165 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+// GetTeamDigests gets the summary of each teams estimation activity since the time along with
+// the team users subscribed to the digest, only teams with activity are included unless TeamID is
+// specified in which case only that team is included regardless of activity
+func (d *Database) GetTeamDigests(Since time.Time, TeamID string) ([]*model.TeamDigest, error) {
+	digests := make([]*model.TeamDigest, 0)
+	digestsByTeam := make(map[string]*model.TeamDigest)
+	teamDigest := func(ID string, Name string) *model.TeamDigest {
+		if _, ok := digestsByTeam[ID]; !ok {
+			digestsByTeam[ID] = &model.TeamDigest{
+				TeamId:           ID,
+				TeamName:         Name,
+				CompletedBattles: make([]string, 0),
+				Recipients:       make([]*model.TeamDigestRecipient, 0),
+			}
+			digests = append(digests, digestsByTeam[ID])
+		}
+		return digestsByTeam[ID]
+	}
+
+	if TeamID != "" {
+		var TeamName string
+		if err := d.db.QueryRow(`SELECT name FROM team WHERE id = $1;`, TeamID).Scan(&TeamName); err != nil {
+			d.logger.Error("get team digest team query error", zap.Error(err))
+			return nil, errors.New("TEAM_NOT_FOUND")
+		}
+		teamDigest(TeamID, TeamName)
+	}
+
+	battleRows, err := d.db.Query(
+		`SELECT t.id, t.name, b.name
+		FROM team_battle tb
+		JOIN team t ON t.id = tb.team_id
+		JOIN battles b ON b.id = tb.battle_id
+		WHERE b.closed = true AND b.closed_date >= $1 AND ($2 = '' OR t.id::TEXT = $2)
+		ORDER BY b.closed_date;`,
+		Since,
+		TeamID,
+	)
+	if err != nil {
+		d.logger.Error("get team digest battles query error", zap.Error(err))
+		return nil, errors.New("unable to get team digests")
+	}
+	defer battleRows.Close()
+	for battleRows.Next() {
+		var ID, Name, BattleName string
+		if err := battleRows.Scan(&ID, &Name, &BattleName); err != nil {
+			d.logger.Error("get team digest battles query scan error", zap.Error(err))
+			continue
+		}
+		digest := teamDigest(ID, Name)
+		digest.CompletedBattles = append(digest.CompletedBattles, BattleName)
+	}
+
+	// grouped by points value so the non-numeric values can be skipped when totaling
+	pointRows, err := d.db.Query(
+		`SELECT t.id, t.name, p.points, COUNT(p.id)
+		FROM team_battle tb
+		JOIN team t ON t.id = tb.team_id
+		JOIN plans p ON p.battle_id = tb.battle_id
+		WHERE p.points <> '' AND p.updated_date >= $1 AND ($2 = '' OR t.id::TEXT = $2)
+		GROUP BY t.id, t.name, p.points;`,
+		Since,
+		TeamID,
+	)
+	if err != nil {
+		d.logger.Error("get team digest points query error", zap.Error(err))
+		return nil, errors.New("unable to get team digests")
+	}
+	defer pointRows.Close()
+	for pointRows.Next() {
+		var ID, Name, Points string
+		var Count int
+		if err := pointRows.Scan(&ID, &Name, &Points, &Count); err != nil {
+			d.logger.Error("get team digest points query scan error", zap.Error(err))
+			continue
+		}
+		digest := teamDigest(ID, Name)
+		digest.PlansEstimated += Count
+		if v, ok := parsePlanPoints(Points); ok {
+			digest.PointsEstimated += v * float64(Count)
+		}
+	}
+
+	if len(digests) == 0 {
+		return digests, nil
+	}
+
+	TeamIDs := make([]string, 0, len(digests))
+	for _, digest := range digests {
+		TeamIDs = append(TeamIDs, digest.TeamId)
+	}
+	recipientRows, err := d.db.Query(
+		`SELECT tu.team_id, u.id, u.name, u.email, u.timezone,
+			COALESCE(to_char(u.quiet_hours_start, 'HH24:MI'), ''), COALESCE(to_char(u.quiet_hours_end, 'HH24:MI'), '')
+		FROM team_user tu
+		JOIN users u ON u.id = tu.user_id
+		WHERE tu.team_id::TEXT = ANY($1) AND u.email IS NOT NULL AND u.email <> '' AND u.type <> 'GUEST'
+			AND u.disabled = false AND u.notifications_enabled = true AND u.team_digest_enabled = true;`,
+		pq.Array(TeamIDs),
+	)
+	if err != nil {
+		d.logger.Error("get team digest recipients query error", zap.Error(err))
+		return nil, errors.New("unable to get team digests")
+	}
+	defer recipientRows.Close()
+	for recipientRows.Next() {
+		var ID string
+		var Timezone sql.NullString
+		r := &model.TeamDigestRecipient{QuietHours: &model.QuietHours{}}
+		if err := recipientRows.Scan(
+			&ID, &r.UserId, &r.UserName, &r.UserEmail, &Timezone, &r.QuietHours.Start, &r.QuietHours.End,
+		); err != nil {
+			d.logger.Error("get team digest recipients query scan error", zap.Error(err))
+			continue
+		}
+		r.QuietHours.Timezone = Timezone.String
+		digestsByTeam[ID].Recipients = append(digestsByTeam[ID].Recipients, r)
+	}
+
+	return digests, nil
+}
+
+// ClaimTeamDigest marks the teams digest as sent unless it was already sent within the interval,
+// returns whether the caller should send it so multiple instances don't send duplicate digests
+func (d *Database) ClaimTeamDigest(TeamID string, Interval time.Duration) (bool, error) {
+	res, err := d.db.Exec(
+		`UPDATE team SET digest_sent_date = NOW()
+		WHERE id = $1 AND (digest_sent_date IS NULL OR digest_sent_date <= NOW() - make_interval(secs => $2));`,
+		TeamID,
+		Interval.Seconds(),
+	)
+	if err != nil {
+		d.logger.Error("claim team digest query error", zap.Error(err))
+		return false, errors.New("unable to claim team digest")
+	}
+	claimed, _ := res.RowsAffected()
+
+	return claimed == 1, nil
+}
+
+// SetUserTeamDigestEnabled subscribes or unsubscribes the user from their teams digests
+func (d *Database) SetUserTeamDigestEnabled(UserID string, Enabled bool) error {
+	if _, err := d.db.Exec(
+		`UPDATE users SET team_digest_enabled = $2, updated_date = NOW() WHERE id = $1;`,
+		UserID,
+		Enabled,
+	); err != nil {
+		d.logger.Error("set user team digest enabled query error", zap.Error(err))
+		return errors.New("unable to update team digest preference")
+	}
+
+	return nil
+}
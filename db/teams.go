@@ -1,6 +1,7 @@
 package db
 
 import (
+	"database/sql"
 	"errors"
 	"github.com/StevenWeathers/thunderdome-planning-poker/model"
 	"go.uber.org/zap"
@@ -163,28 +164,195 @@ func (d *Database) TeamUserList(TeamID string, Limit int, Offset int) ([]*model.
 	return users, userCount, nil
 }
 
-// TeamRemoveUser removes a user from a team
-func (d *Database) TeamRemoveUser(TeamID string, UserID string) error {
+// TeamInviteUser invites a user by email (who isn't registered) to a team, adding them once they've verified the email
+func (d *Database) TeamInviteUser(TeamID string, UserEmail string, Role string) error {
 	_, err := d.db.Exec(
-		`CALL team_user_remove($1, $2);`,
+		`CALL team_user_invite_add($1, $2, $3);`,
+		TeamID,
+		UserEmail,
+		Role,
+	)
+
+	if err != nil {
+		d.logger.Error("team_user_invite_add query error", zap.Error(err))
+		return err
+	}
+
+	if _, err := d.db.Exec(
+		`UPDATE team_user_invite SET expire_date = NOW() + ($3 * INTERVAL '1 minute') WHERE team_id = $1 AND email = LOWER($2);`,
 		TeamID,
+		UserEmail,
+		tokenTTL(d.config.TokenTTL, TokenTypeInvite),
+	); err != nil {
+		d.logger.Error("set team_user_invite expiry error", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// acceptTeamUserInvites adds the user to the teams their email was invited to, only once they've verified
+// owning the email so registering with someone else's email doesn't grant their invites
+func (d *Database) acceptTeamUserInvites(UserID string) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		d.logger.Error("accept team invites transaction error", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		`INSERT INTO team_user (team_id, user_id, role)
+		SELECT tui.team_id, u.id, tui.role FROM team_user_invite tui
+		JOIN users u ON tui.email = LOWER(u.email)
+		WHERE u.id = $1 AND u.verified AND u.deleted_at IS NULL AND NOW() < tui.expire_date
+		ON CONFLICT DO NOTHING;`,
+		UserID,
+	); err != nil {
+		d.logger.Error("accept team invites query error", zap.Error(err))
+		return err
+	}
+
+	if _, err := tx.Exec(
+		`DELETE FROM team_user_invite WHERE email = (SELECT LOWER(email) FROM users WHERE id = $1 AND verified);`,
 		UserID,
+	); err != nil {
+		d.logger.Error("delete accepted team invites query error", zap.Error(err))
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// TeamUpdateUserRole updates a team user's role, preventing demotion of the last team admin
+func (d *Database) TeamUpdateUserRole(TeamID string, UserID string, Role string) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		d.logger.Error("team_user_role_update transaction error", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback()
+
+	if Role != "ADMIN" {
+		lastAdmin, err := d.teamUserIsLastAdmin(tx, TeamID, UserID)
+		if err != nil {
+			return err
+		}
+		if lastAdmin {
+			return errors.New("TEAM_LAST_ADMIN")
+		}
+	}
+
+	if _, err := tx.Exec(
+		`CALL team_user_role_update($1, $2, $3);`,
+		TeamID,
+		UserID,
+		Role,
+	); err != nil {
+		d.logger.Error("team_user_role_update query error", zap.Error(err))
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		d.logger.Error("team_user_role_update commit error", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// teamUserIsLastAdmin checks whether the user is the only remaining admin of the team,
+// locking the teams admin rows until the transaction ends so concurrent demotions or removals
+// of the other admins wait and then see the result
+func (d *Database) teamUserIsLastAdmin(tx *sql.Tx, TeamID string, UserID string) (bool, error) {
+	rows, err := tx.Query(
+		`SELECT user_id FROM team_user WHERE team_id = $1 AND role = 'ADMIN' ORDER BY user_id FOR UPDATE;`,
+		TeamID,
 	)
+	if err != nil {
+		d.logger.Error("get team admins query error", zap.Error(err))
+		return false, err
+	}
+
+	var adminCount int
+	var isAdmin bool
+	for rows.Next() {
+		var AdminID string
+		if err := rows.Scan(&AdminID); err != nil {
+			rows.Close()
+			d.logger.Error("get team admins query scan error", zap.Error(err))
+			return false, err
+		}
+		adminCount++
+		if AdminID == UserID {
+			isAdmin = true
+		}
+	}
+	rows.Close()
+
+	if !isAdmin {
+		var userRole string
+		if err := tx.QueryRow(
+			`SELECT role FROM team_user WHERE team_id = $1 AND user_id = $2;`,
+			TeamID,
+			UserID,
+		).Scan(&userRole); err != nil {
+			d.logger.Error("get team user role query error", zap.Error(err))
+			return false, errors.New("TEAM_USER_NOT_FOUND")
+		}
+		return false, nil
+	}
+
+	return adminCount <= 1, nil
+}
 
+// TeamRemoveUser removes a user from a team, preventing removal of the last team admin
+func (d *Database) TeamRemoveUser(TeamID string, UserID string) error {
+	tx, err := d.db.Begin()
 	if err != nil {
+		d.logger.Error("team_user_remove transaction error", zap.Error(err))
+		return err
+	}
+	defer tx.Rollback()
+
+	lastAdmin, err := d.teamUserIsLastAdmin(tx, TeamID, UserID)
+	if err != nil {
+		return err
+	}
+	if lastAdmin {
+		return errors.New("TEAM_LAST_ADMIN")
+	}
+
+	if _, err := tx.Exec(
+		`CALL team_user_remove($1, $2);`,
+		TeamID,
+		UserID,
+	); err != nil {
 		d.logger.Error("team_user_remove query error", zap.Error(err))
 		return err
 	}
 
+	if err := tx.Commit(); err != nil {
+		d.logger.Error("team_user_remove commit error", zap.Error(err))
+		return err
+	}
+
 	return nil
 }
 
-// TeamBattleList gets a list of team battles
-func (d *Database) TeamBattleList(TeamID string, Limit int, Offset int) []*model.Battle {
+// TeamBattleList gets a list of team battles, optionally filtered by battle state
+func (d *Database) TeamBattleList(TeamID string, State string, Limit int, Offset int) []*model.Battle {
 	var battles = make([]*model.Battle, 0)
 	rows, err := d.db.Query(
-		`SELECT id, name FROM team_battle_list($1, $2, $3);`,
+		`SELECT b.id, b.name, b.state
+		FROM team_battle tb
+		JOIN battles b ON tb.battle_id = b.id
+		WHERE tb.team_id = $1 AND ($2 = '' OR b.state = $2)
+		ORDER BY tb.created_date
+		LIMIT $3
+		OFFSET $4;`,
 		TeamID,
+		State,
 		Limit,
 		Offset,
 	)
@@ -197,6 +365,7 @@ func (d *Database) TeamBattleList(TeamID string, Limit int, Offset int) []*model
 			if err := rows.Scan(
 				&tb.Id,
 				&tb.Name,
+				&tb.State,
 			); err != nil {
 				d.logger.Error("team_battle_list query scan error", zap.Error(err))
 			} else {
@@ -210,6 +379,80 @@ func (d *Database) TeamBattleList(TeamID string, Limit int, Offset int) []*model
 	return battles
 }
 
+// TeamManifest gets the combined inventory of battles and storyboards associated to the team
+// with plan/story and participant counts, ordered by newest first
+func (d *Database) TeamManifest(TeamID string, Limit int, Offset int) ([]*model.TeamManifestEntry, int, error) {
+	var entries = make([]*model.TeamManifestEntry, 0)
+	var Count int
+
+	rows, err := d.db.Query(
+		`SELECT type, id, name, owner_id, owner_name, status, item_count, participant_count, created_date, COUNT(*) OVER()
+		FROM (
+			SELECT 'battle' AS type, b.id, b.name, COALESCE(b.owner_id::TEXT, '') AS owner_id, COALESCE(u.name, '') AS owner_name,
+				CASE
+					WHEN b.paused THEN 'PAUSED'
+					WHEN NOT b.voting_locked THEN 'VOTING'
+					WHEN EXISTS(SELECT 1 FROM battles_users bu WHERE bu.battle_id = b.id AND bu.active) THEN 'ACTIVE'
+					ELSE 'IDLE'
+				END AS status,
+				(SELECT COUNT(*) FROM plans p WHERE p.battle_id = b.id) AS item_count,
+				(SELECT COUNT(*) FROM battles_users bu WHERE bu.battle_id = b.id) AS participant_count,
+				b.created_date::TIMESTAMPTZ AS created_date
+			FROM team_battle tb
+			JOIN battles b ON b.id = tb.battle_id
+			LEFT JOIN users u ON u.id = b.owner_id
+			WHERE tb.team_id = $1
+			UNION ALL
+			SELECT 'storyboard' AS type, s.id, s.name, COALESCE(s.owner_id::TEXT, '') AS owner_id, COALESCE(u.name, '') AS owner_name,
+				CASE
+					WHEN EXISTS(SELECT 1 FROM storyboard_user su WHERE su.storyboard_id = s.id AND su.active) THEN 'ACTIVE'
+					ELSE 'IDLE'
+				END AS status,
+				(SELECT COUNT(*) FROM storyboard_story ss WHERE ss.storyboard_id = s.id) AS item_count,
+				(SELECT COUNT(*) FROM storyboard_user su WHERE su.storyboard_id = s.id) AS participant_count,
+				s.created_date AS created_date
+			FROM team_storyboard ts
+			JOIN storyboard s ON s.id = ts.storyboard_id
+			LEFT JOIN users u ON u.id = s.owner_id
+			WHERE ts.team_id = $1
+		) manifest
+		ORDER BY created_date DESC, id
+		LIMIT $2 OFFSET $3;`,
+		TeamID,
+		Limit,
+		Offset,
+	)
+	if err != nil {
+		d.logger.Error("team manifest query error", zap.Error(err))
+		return nil, Count, errors.New("unable to get team manifest")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e model.TeamManifestEntry
+
+		if err := rows.Scan(
+			&e.Type,
+			&e.Id,
+			&e.Name,
+			&e.OwnerId,
+			&e.OwnerName,
+			&e.Status,
+			&e.ItemCount,
+			&e.ParticipantCount,
+			&e.CreatedDate,
+			&Count,
+		); err != nil {
+			d.logger.Error("team manifest query scan error", zap.Error(err))
+			return nil, Count, errors.New("unable to get team manifest")
+		}
+
+		entries = append(entries, &e)
+	}
+
+	return entries, Count, nil
+}
+
 // TeamAddBattle adds a battle to a team
 func (d *Database) TeamAddBattle(TeamID string, BattleID string) error {
 	_, err := d.db.Exec(
@@ -223,6 +466,8 @@ func (d *Database) TeamAddBattle(TeamID string, BattleID string) error {
 		return err
 	}
 
+	d.initBattleState(TeamID, BattleID)
+
 	return nil
 }
 
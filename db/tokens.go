@@ -0,0 +1,145 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// token types with a configurable TTL
+const (
+	TokenTypeReset  = "reset"
+	TokenTypeVerify = "verify"
+	TokenTypeInvite = "invite"
+	TokenTypeMFA    = "mfa"
+	// TokenTypeEmailChange confirms a users new email, it shares the verify tokens configured TTL
+	TokenTypeEmailChange = "email_change"
+)
+
+// tokenTTLDefaults the default TTL in minutes for each token type
+var tokenTTLDefaults = map[string]int{
+	TokenTypeReset:       60,
+	TokenTypeVerify:      60 * 24,
+	TokenTypeInvite:      60 * 24 * 7,
+	TokenTypeMFA:         5,
+	TokenTypeEmailChange: 60 * 24,
+}
+
+// tokenTTLMaximums the enforced maximum TTL in minutes for each token type
+var tokenTTLMaximums = map[string]int{
+	TokenTypeReset:       60 * 24,
+	TokenTypeVerify:      60 * 24 * 7,
+	TokenTypeInvite:      60 * 24 * 30,
+	TokenTypeMFA:         15,
+	TokenTypeEmailChange: 60 * 24 * 7,
+}
+
+// tokenTables the table and id column storing each token type
+var tokenTables = map[string]struct {
+	table    string
+	idColumn string
+}{
+	TokenTypeReset:       {"user_reset", "reset_id"},
+	TokenTypeVerify:      {"user_verify", "verify_id"},
+	TokenTypeMFA:         {"user_mfa_token", "token_id"},
+	TokenTypeEmailChange: {"user_email_change", "change_id"},
+}
+
+// tokenTTL gets the configured TTL in minutes for the token type, falling back to the default
+// and never exceeding the token type's maximum
+func tokenTTL(TokenTTL map[string]int, TokenType string) int {
+	ttl, ok := TokenTTL[TokenType]
+	if !ok || ttl <= 0 {
+		ttl = tokenTTLDefaults[TokenType]
+	}
+	if ttl > tokenTTLMaximums[TokenType] {
+		ttl = tokenTTLMaximums[TokenType]
+	}
+
+	return ttl
+}
+
+// TokenTTL gets the effective TTL in minutes for the token type from the configured TTLs
+func TokenTTL(Configured map[string]int, TokenType string) int {
+	return tokenTTL(Configured, TokenType)
+}
+
+// setTokenExpiry sets the token's expiration based on the token type's TTL
+func (d *Database) setTokenExpiry(TokenType string, TokenID string) error {
+	t := tokenTables[TokenType]
+
+	if _, err := d.db.Exec(
+		`UPDATE `+t.table+` SET expire_date = NOW() + ($2 * INTERVAL '1 minute') WHERE `+t.idColumn+` = $1;`,
+		TokenID,
+		tokenTTL(d.config.TokenTTL, TokenType),
+	); err != nil {
+		d.logger.Error("set token expiry error", zap.Error(err), zap.String("token_type", TokenType))
+		return err
+	}
+
+	return nil
+}
+
+// checkTokenExpiry confirms the token exists and hasn't expired, removing it when expired,
+// the expiry is compared in the database as expire_date has no timezone
+func (d *Database) checkTokenExpiry(TokenType string, TokenID string) error {
+	var valid bool
+	t := tokenTables[TokenType]
+
+	err := d.db.QueryRow(
+		`SELECT NOW() < expire_date FROM `+t.table+` WHERE `+t.idColumn+` = $1;`,
+		TokenID,
+	).Scan(&valid)
+	if err == sql.ErrNoRows {
+		return errors.New("TOKEN_NOT_FOUND")
+	}
+	if err != nil {
+		d.logger.Error("check token expiry error", zap.Error(err), zap.String("token_type", TokenType))
+		return err
+	}
+
+	if !valid {
+		if _, err := d.db.Exec(
+			`DELETE FROM `+t.table+` WHERE `+t.idColumn+` = $1;`,
+			TokenID,
+		); err != nil {
+			d.logger.Error("delete expired token error", zap.Error(err), zap.String("token_type", TokenType))
+		}
+		return errors.New("TOKEN_EXPIRED")
+	}
+
+	return nil
+}
+
+// CleanExpiredTokens deletes all expired tokens
+func (d *Database) CleanExpiredTokens() error {
+	for TokenType, t := range tokenTables {
+		if _, err := d.db.Exec(
+			`DELETE FROM ` + t.table + ` WHERE expire_date <= NOW();`,
+		); err != nil {
+			d.logger.Error("clean expired tokens error", zap.Error(err), zap.String("token_type", TokenType))
+			return err
+		}
+	}
+
+	if _, err := d.db.Exec(
+		`DELETE FROM team_user_invite WHERE expire_date <= NOW();`,
+	); err != nil {
+		d.logger.Error("clean expired tokens error", zap.Error(err), zap.String("token_type", TokenTypeInvite))
+		return err
+	}
+
+	return nil
+}
+
+// TokenSweeper periodically cleans up expired tokens, intended to be run as a goroutine
+func (d *Database) TokenSweeper(Interval time.Duration) {
+	ticker := time.NewTicker(Interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		_ = d.CleanExpiredTokens()
+	}
+}
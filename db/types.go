@@ -16,6 +16,30 @@ type Config struct {
 	Name       string
 	SSLMode    string
 	AESHashkey string
+	// TokenTTL the TTL in minutes by token type e.g. reset, verify, invite
+	TokenTTL map[string]int
+	// HTMLAllowedTags the HTML tags allowed in user provided rich text, defaults to user generated content policy when empty
+	HTMLAllowedTags []string
+	// EmailUniqueIncludingDeleted prevents re-registering an email of a deleted account until purged
+	EmailUniqueIncludingDeleted bool
+	// MaxPlansPerBattle the default cap on plans per battle, overridable per team or organization, 0 is unlimited
+	MaxPlansPerBattle int
+	// PasswordHistoryDepth the number of previous passwords a user can't reuse, 0 disables
+	PasswordHistoryDepth int
+	// MaxUserViews the max saved list views per user, 0 is unlimited
+	MaxUserViews int
+	// StorageQuotaTotal the max upload storage in bytes for the instance, 0 is unlimited
+	StorageQuotaTotal int64
+	// StorageQuotaPerUser the default max upload storage in bytes per user, 0 is unlimited
+	StorageQuotaPerUser int64
+	// UserDeleteGraceDays the days a deleted user can be restored before being purged, 0 deletes immediately
+	UserDeleteGraceDays int
+	// SessionIdleTimeout the minutes a session can go unused before it's ended, 0 disables
+	SessionIdleTimeout int
+	// StoryboardRevisionLimit the number of revisions kept per storyboard, 0 disables recording revisions
+	StoryboardRevisionLimit int
+	// AbstainVote the reserved vote of a participant abstaining from estimating, empty disables it
+	AbstainVote string
 }
 
 // Database contains all the methods to interact with DB
@@ -23,5 +47,6 @@ type Database struct {
 	config              *Config
 	db                  *sql.DB
 	htmlSanitizerPolicy *bluemonday.Policy
+	instanceStatsCache  *instanceStatsCache
 	logger              *zap.Logger
 }
@@ -0,0 +1,124 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// SoftDeleteUser marks the user deleted so they can be restored within the grace period before being purged,
+// ending their sessions immediately and returning the ended session IDs so any open sockets can be closed
+func (d *Database) SoftDeleteUser(UserID string) ([]string, error) {
+	var SessionIDs = make([]string, 0)
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		d.logger.Error("soft delete user transaction error", zap.Error(err))
+		return nil, errors.New("error attempting to delete user")
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(
+		`UPDATE users SET deleted_at = NOW(), updated_date = NOW() WHERE id = $1 AND deleted_at IS NULL;`,
+		UserID,
+	)
+	if err != nil {
+		d.logger.Error("soft delete user query error", zap.Error(err))
+		return nil, errors.New("error attempting to delete user")
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return nil, errors.New("USER_NOT_FOUND")
+	}
+
+	rows, err := tx.Query(
+		`DELETE FROM user_session WHERE user_id = $1 RETURNING session_id;`,
+		UserID,
+	)
+	if err != nil {
+		d.logger.Error("delete soft deleted user sessions query error", zap.Error(err))
+		return nil, errors.New("error attempting to delete user")
+	}
+	for rows.Next() {
+		var SessionID string
+		if err := rows.Scan(&SessionID); err != nil {
+			d.logger.Error("delete soft deleted user sessions query scan error", zap.Error(err))
+		} else {
+			SessionIDs = append(SessionIDs, SessionID)
+		}
+	}
+	rows.Close()
+
+	if err := tx.Commit(); err != nil {
+		d.logger.Error("soft delete user commit error", zap.Error(err))
+		return nil, errors.New("error attempting to delete user")
+	}
+
+	return SessionIDs, nil
+}
+
+// RestoreUser restores a soft deleted user that hasn't yet passed the grace period
+func (d *Database) RestoreUser(UserID string) error {
+	res, err := d.db.Exec(
+		`UPDATE users SET deleted_at = NULL, updated_date = NOW()
+		WHERE id = $1 AND deleted_at IS NOT NULL AND deleted_at > NOW() - make_interval(days => $2);`,
+		UserID,
+		d.config.UserDeleteGraceDays,
+	)
+	if err != nil {
+		d.logger.Error("restore user query error", zap.Error(err))
+		return errors.New("error attempting to restore user")
+	}
+	if rows, _ := res.RowsAffected(); rows == 0 {
+		return errors.New("DELETED_USER_NOT_FOUND")
+	}
+
+	return nil
+}
+
+// PurgeDeletedUsers permanently deletes the soft deleted users whose grace period has passed,
+// calling OnPurged with each purged users ID and avatar so their uploaded avatar can be removed
+func (d *Database) PurgeDeletedUsers(OnPurged func(UserID string, Avatar string)) error {
+	rows, err := d.db.Query(
+		`SELECT id, COALESCE(avatar, '') FROM users WHERE deleted_at IS NOT NULL AND deleted_at <= NOW() - make_interval(days => $1);`,
+		d.config.UserDeleteGraceDays,
+	)
+	if err != nil {
+		d.logger.Error("get purgeable deleted users query error", zap.Error(err))
+		return errors.New("unable to purge deleted users")
+	}
+
+	var Users [][2]string
+	for rows.Next() {
+		var UserID, Avatar string
+		if err := rows.Scan(&UserID, &Avatar); err != nil {
+			d.logger.Error("get purgeable deleted users query scan error", zap.Error(err))
+		} else {
+			Users = append(Users, [2]string{UserID, Avatar})
+		}
+	}
+	rows.Close()
+
+	// purged one at a time, the grace period has already held the email so its deleted email hash is dropped
+	for _, u := range Users {
+		if err := d.deleteUser(u[0], false); err != nil {
+			d.logger.Error("purge deleted user error", zap.String("user_id", u[0]), zap.Error(err))
+			continue
+		}
+		if OnPurged != nil {
+			OnPurged(u[0], u[1])
+		}
+	}
+
+	return nil
+}
+
+// DeletedUserSweeper periodically purges soft deleted users past the grace period
+func (d *Database) DeletedUserSweeper(Interval time.Duration, OnPurged func(UserID string, Avatar string)) {
+	ticker := time.NewTicker(Interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		_ = d.PurgeDeletedUsers(OnPurged)
+	}
+}
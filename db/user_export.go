@@ -0,0 +1,211 @@
+package db
+
+import (
+	"errors"
+	"time"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"go.uber.org/zap"
+)
+
+// ExportUserData gathers the users profile, owned battles with their plans, votes, owned storyboards with their stories,
+// and team memberships into a single document for a data subject access request
+func (d *Database) ExportUserData(UserID string) (*model.UserDataExport, error) {
+	User, err := d.GetUser(UserID)
+	if err != nil {
+		return nil, errors.New("USER_NOT_FOUND")
+	}
+
+	Export := &model.UserDataExport{
+		User:        User,
+		CreatedDate: time.Now().UTC(),
+	}
+
+	if Export.Battles, err = d.exportUserBattles(UserID); err != nil {
+		return nil, err
+	}
+	if Export.Votes, err = d.exportUserVotes(UserID); err != nil {
+		return nil, err
+	}
+	if Export.Storyboards, err = d.exportUserStoryboards(UserID); err != nil {
+		return nil, err
+	}
+	if Export.Teams, err = d.exportUserTeams(UserID); err != nil {
+		return nil, err
+	}
+
+	return Export, nil
+}
+
+// exportUserBattles gets the battles the user owns along with their plans
+func (d *Database) exportUserBattles(UserID string) ([]*model.UserDataExportBattle, error) {
+	var Battles = make([]*model.UserDataExportBattle, 0)
+	var BattleIndex = make(map[string]*model.UserDataExportBattle)
+
+	rows, err := d.db.Query(
+		`SELECT id, COALESCE(name, ''), created_date FROM battles WHERE owner_id = $1 ORDER BY created_date;`,
+		UserID,
+	)
+	if err != nil {
+		d.logger.Error("export user battles query error", zap.Error(err))
+		return nil, errors.New("unable to export user battles")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		b := &model.UserDataExportBattle{Plans: make([]*model.UserDataExportPlan, 0)}
+		if err := rows.Scan(&b.Id, &b.Name, &b.CreatedDate); err != nil {
+			d.logger.Error("export user battles query scan error", zap.Error(err))
+			return nil, errors.New("unable to export user battles")
+		}
+		Battles = append(Battles, b)
+		BattleIndex[b.Id] = b
+	}
+
+	planRows, err := d.db.Query(
+		`SELECT p.battle_id, p.id, COALESCE(p.name, ''), COALESCE(p.type, ''), COALESCE(p.reference_id, ''),
+			COALESCE(p.link, ''), COALESCE(p.description, ''), COALESCE(p.acceptance_criteria, ''),
+			COALESCE(p.points, ''), p.created_date
+		FROM plans p
+		JOIN battles b ON b.id = p.battle_id
+		WHERE b.owner_id = $1
+		ORDER BY p.created_date;`,
+		UserID,
+	)
+	if err != nil {
+		d.logger.Error("export user battle plans query error", zap.Error(err))
+		return nil, errors.New("unable to export user battles")
+	}
+	defer planRows.Close()
+
+	for planRows.Next() {
+		var BattleID string
+		p := &model.UserDataExportPlan{}
+		if err := planRows.Scan(
+			&BattleID, &p.Id, &p.Name, &p.Type, &p.ReferenceId,
+			&p.Link, &p.Description, &p.AcceptanceCriteria, &p.Points, &p.CreatedDate,
+		); err != nil {
+			d.logger.Error("export user battle plans query scan error", zap.Error(err))
+			return nil, errors.New("unable to export user battles")
+		}
+		if b, ok := BattleIndex[BattleID]; ok {
+			b.Plans = append(b.Plans, p)
+		}
+	}
+
+	return Battles, nil
+}
+
+// exportUserVotes gets the votes the user cast in any battle, including votes cast on their behalf by a delegate
+func (d *Database) exportUserVotes(UserID string) ([]*model.UserDataExportVote, error) {
+	var Votes = make([]*model.UserDataExportVote, 0)
+
+	rows, err := d.db.Query(
+		`SELECT p.battle_id, p.id, COALESCE(p.name, ''), COALESCE(v->>'vote', '')
+		FROM plans p, jsonb_array_elements(p.votes) v
+		WHERE v->>'warriorId' = $1
+		ORDER BY p.created_date;`,
+		UserID,
+	)
+	if err != nil {
+		d.logger.Error("export user votes query error", zap.Error(err))
+		return nil, errors.New("unable to export user votes")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		v := &model.UserDataExportVote{}
+		if err := rows.Scan(&v.BattleId, &v.PlanId, &v.PlanName, &v.VoteValue); err != nil {
+			d.logger.Error("export user votes query scan error", zap.Error(err))
+			return nil, errors.New("unable to export user votes")
+		}
+		Votes = append(Votes, v)
+	}
+
+	return Votes, nil
+}
+
+// exportUserStoryboards gets the storyboards the user owns along with their stories
+func (d *Database) exportUserStoryboards(UserID string) ([]*model.UserDataExportStoryboard, error) {
+	var Storyboards = make([]*model.UserDataExportStoryboard, 0)
+	var StoryboardIndex = make(map[string]*model.UserDataExportStoryboard)
+
+	rows, err := d.db.Query(
+		`SELECT id, COALESCE(name, ''), created_date FROM storyboard WHERE owner_id = $1 ORDER BY created_date;`,
+		UserID,
+	)
+	if err != nil {
+		d.logger.Error("export user storyboards query error", zap.Error(err))
+		return nil, errors.New("unable to export user storyboards")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		sb := &model.UserDataExportStoryboard{Stories: make([]*model.UserDataExportStory, 0)}
+		if err := rows.Scan(&sb.Id, &sb.Name, &sb.CreatedDate); err != nil {
+			d.logger.Error("export user storyboards query scan error", zap.Error(err))
+			return nil, errors.New("unable to export user storyboards")
+		}
+		Storyboards = append(Storyboards, sb)
+		StoryboardIndex[sb.Id] = sb
+	}
+
+	storyRows, err := d.db.Query(
+		`SELECT ss.storyboard_id, ss.id, COALESCE(ss.name, ''), COALESCE(ss.content, ''),
+			COALESCE(ss.points, 0), ss.closed, ss.created_date
+		FROM storyboard_story ss
+		JOIN storyboard s ON s.id = ss.storyboard_id
+		WHERE s.owner_id = $1
+		ORDER BY ss.created_date;`,
+		UserID,
+	)
+	if err != nil {
+		d.logger.Error("export user storyboard stories query error", zap.Error(err))
+		return nil, errors.New("unable to export user storyboards")
+	}
+	defer storyRows.Close()
+
+	for storyRows.Next() {
+		var StoryboardID string
+		s := &model.UserDataExportStory{}
+		if err := storyRows.Scan(&StoryboardID, &s.Id, &s.Name, &s.Content, &s.Points, &s.Closed, &s.CreatedDate); err != nil {
+			d.logger.Error("export user storyboard stories query scan error", zap.Error(err))
+			return nil, errors.New("unable to export user storyboards")
+		}
+		if sb, ok := StoryboardIndex[StoryboardID]; ok {
+			sb.Stories = append(sb.Stories, s)
+		}
+	}
+
+	return Storyboards, nil
+}
+
+// exportUserTeams gets the teams the user is a member of
+func (d *Database) exportUserTeams(UserID string) ([]*model.UserDataExportTeam, error) {
+	var Teams = make([]*model.UserDataExportTeam, 0)
+
+	rows, err := d.db.Query(
+		`SELECT t.id, COALESCE(t.name, ''), tu.role
+		FROM team_user tu
+		JOIN team t ON t.id = tu.team_id
+		WHERE tu.user_id = $1
+		ORDER BY t.name;`,
+		UserID,
+	)
+	if err != nil {
+		d.logger.Error("export user teams query error", zap.Error(err))
+		return nil, errors.New("unable to export user teams")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		t := &model.UserDataExportTeam{}
+		if err := rows.Scan(&t.Id, &t.Name, &t.Role); err != nil {
+			d.logger.Error("export user teams query scan error", zap.Error(err))
+			return nil, errors.New("unable to export user teams")
+		}
+		Teams = append(Teams, t)
+	}
+
+	return Teams, nil
+}
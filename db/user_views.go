@@ -0,0 +1,168 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"go.uber.org/zap"
+)
+
+// userViewLists the lists a saved view can be applied to
+var userViewLists = []string{"battles", "storyboards"}
+
+// parseUserViewFilter parses the filter JSON making sure it only uses the supported filters
+func parseUserViewFilter(FilterJSON string) (*model.UserViewFilter, error) {
+	var Filter = &model.UserViewFilter{}
+
+	decoder := json.NewDecoder(bytes.NewReader([]byte(FilterJSON)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(Filter); err != nil {
+		return nil, errors.New("INVALID_VIEW_FILTER")
+	}
+
+	if !contains(userViewLists, Filter.List) {
+		return nil, errors.New("INVALID_VIEW_FILTER")
+	}
+	// storyboards don't have states
+	if Filter.List != "battles" && Filter.State != "" {
+		return nil, errors.New("INVALID_VIEW_FILTER")
+	}
+	if len(Filter.State) > 64 || len(Filter.TeamId) > 36 {
+		return nil, errors.New("INVALID_VIEW_FILTER")
+	}
+	if Filter.Tag != "" {
+		Tag, err := normalizeTag(Filter.Tag)
+		if err != nil {
+			return nil, errors.New("INVALID_VIEW_FILTER")
+		}
+		Filter.Tag = Tag
+	}
+
+	return Filter, nil
+}
+
+// SaveUserView saves the users list view by name, replacing an existing view with the same name
+func (d *Database) SaveUserView(UserID string, Name string, FilterJSON string) (*model.UserView, error) {
+	Name = strings.TrimSpace(Name)
+	if Name == "" || len(Name) > 256 {
+		return nil, errors.New("INVALID_VIEW_NAME")
+	}
+
+	Filter, err := parseUserViewFilter(FilterJSON)
+	if err != nil {
+		return nil, err
+	}
+	NormalizedFilter, _ := json.Marshal(Filter)
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		d.logger.Error("save user view transaction error", zap.Error(err))
+		return nil, errors.New("unable to save view")
+	}
+	defer tx.Rollback()
+
+	// lock the user so concurrent saves can't exceed the cap
+	if _, err := tx.Exec(`SELECT id FROM users WHERE id = $1 FOR UPDATE;`, UserID); err != nil {
+		d.logger.Error("save user view lock user query error", zap.Error(err))
+		return nil, errors.New("unable to save view")
+	}
+
+	var Count int
+	var Exists bool
+	if err := tx.QueryRow(
+		`SELECT COUNT(*), COALESCE(bool_or(name = $2), false) FROM user_view WHERE user_id = $1;`,
+		UserID,
+		Name,
+	).Scan(&Count, &Exists); err != nil {
+		d.logger.Error("save user view count query error", zap.Error(err))
+		return nil, errors.New("unable to save view")
+	}
+
+	if !Exists && d.config.MaxUserViews > 0 && Count >= d.config.MaxUserViews {
+		return nil, errors.New("VIEW_LIMIT_REACHED")
+	}
+
+	var View = &model.UserView{Name: Name, Filter: Filter}
+	if err := tx.QueryRow(
+		`INSERT INTO user_view (user_id, name, filter) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, name) DO UPDATE SET filter = EXCLUDED.filter, updated_date = NOW()
+		RETURNING id, created_date, updated_date;`,
+		UserID,
+		Name,
+		string(NormalizedFilter),
+	).Scan(&View.Id, &View.CreatedDate, &View.UpdatedDate); err != nil {
+		d.logger.Error("save user view query error", zap.Error(err))
+		return nil, errors.New("unable to save view")
+	}
+
+	if err := tx.Commit(); err != nil {
+		d.logger.Error("save user view commit error", zap.Error(err))
+		return nil, errors.New("unable to save view")
+	}
+
+	return View, nil
+}
+
+// GetUserViews gets the users saved list views
+func (d *Database) GetUserViews(UserID string) ([]*model.UserView, error) {
+	var Views = make([]*model.UserView, 0)
+
+	rows, err := d.db.Query(
+		`SELECT id, name, filter, created_date, updated_date FROM user_view WHERE user_id = $1 ORDER BY name;`,
+		UserID,
+	)
+	if err != nil {
+		d.logger.Error("get user views query error", zap.Error(err))
+		return nil, errors.New("unable to get views")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var Filter string
+		var View = &model.UserView{Filter: &model.UserViewFilter{}}
+
+		if err := rows.Scan(&View.Id, &View.Name, &Filter, &View.CreatedDate, &View.UpdatedDate); err != nil {
+			d.logger.Error("get user views query scan error", zap.Error(err))
+		} else {
+			_ = json.Unmarshal([]byte(Filter), View.Filter)
+			Views = append(Views, View)
+		}
+	}
+
+	return Views, nil
+}
+
+// GetUserView gets a users saved list view by ID
+func (d *Database) GetUserView(UserID string, ViewID string) (*model.UserView, error) {
+	var Filter string
+	var View = &model.UserView{Filter: &model.UserViewFilter{}}
+
+	if err := d.db.QueryRow(
+		`SELECT id, name, filter, created_date, updated_date FROM user_view WHERE id = $1 AND user_id = $2;`,
+		ViewID,
+		UserID,
+	).Scan(&View.Id, &View.Name, &Filter, &View.CreatedDate, &View.UpdatedDate); err != nil {
+		d.logger.Error("get user view query error", zap.Error(err))
+		return nil, errors.New("VIEW_NOT_FOUND")
+	}
+	_ = json.Unmarshal([]byte(Filter), View.Filter)
+
+	return View, nil
+}
+
+// DeleteUserView deletes a users saved list view
+func (d *Database) DeleteUserView(UserID string, ViewID string) error {
+	if _, err := d.db.Exec(
+		`DELETE FROM user_view WHERE id = $1 AND user_id = $2;`,
+		ViewID,
+		UserID,
+	); err != nil {
+		d.logger.Error("delete user view query error", zap.Error(err))
+		return errors.New("unable to delete view")
+	}
+
+	return nil
+}
@@ -3,34 +3,48 @@ package db
 import (
 	"database/sql"
 	"errors"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/StevenWeathers/thunderdome-planning-poker/model"
 	"go.uber.org/zap"
 )
 
-// GetRegisteredUsers gets a list of registered users
-func (d *Database) GetRegisteredUsers(Limit int, Offset int) ([]*model.User, int, error) {
+// GetUsers gets a page of registered users optionally filtered by a case-insensitive name or email search,
+// along with the total count of matching users
+func (d *Database) GetUsers(Search string, Limit int, Offset int) ([]*model.User, int, error) {
 	var users = make([]*model.User, 0)
 	var Count int
+	Search = escapeLikePattern(strings.TrimSpace(Search))
 
 	err := d.db.QueryRow(
-		"SELECT COUNT(*) FROM users WHERE email IS NOT NULL;",
+		`SELECT COUNT(*) FROM users
+		WHERE email IS NOT NULL AND deleted_at IS NULL
+			AND ($1 = '' OR name ILIKE '%' || $1 || '%' OR email ILIKE '%' || $1 || '%');`,
+		Search,
 	).Scan(
 		&Count,
 	)
 	if err != nil {
-		d.logger.Error("get registered users query error", zap.Error(err))
+		d.logger.Error("get users count query error", zap.Error(err))
 	}
 
 	rows, err := d.db.Query(
-		`
-		SELECT id, name, email, type, avatar, verified, country, company, job_title, disabled
-		FROM registered_users_list($1, $2);`,
+		`SELECT id, name, email, type, avatar, verified, COALESCE(country, ''), COALESCE(company, ''), COALESCE(job_title, ''), disabled
+		FROM users
+		WHERE email IS NOT NULL AND deleted_at IS NULL
+			AND ($1 = '' OR name ILIKE '%' || $1 || '%' OR email ILIKE '%' || $1 || '%')
+		ORDER BY created_date
+		LIMIT $2
+		OFFSET $3;`,
+		Search,
 		Limit,
 		Offset,
 	)
 	if err != nil {
-		return nil, Count, err
+		d.logger.Error("get users query error", zap.Error(err))
+		return nil, Count, errors.New("unable to get users")
 	}
 
 	defer rows.Close()
@@ -49,7 +63,7 @@ func (d *Database) GetRegisteredUsers(Limit int, Offset int) ([]*model.User, int
 			&w.JobTitle,
 			&w.Disabled,
 		); err != nil {
-			d.logger.Error("registered_users_list query scan error", zap.Error(err))
+			d.logger.Error("get users query scan error", zap.Error(err))
 		} else {
 			w.GravatarHash = createGravatarHash(w.Email)
 			users = append(users, &w)
@@ -69,7 +83,7 @@ func (d *Database) GetUser(UserID string) (*model.User, error) {
 	var UserJobTitle sql.NullString
 
 	err := d.db.QueryRow(
-		"SELECT id, name, email, type, avatar, verified, notifications_enabled, country, locale, company, job_title, created_date, updated_date, last_active, disabled FROM users WHERE id = $1",
+		"SELECT id, name, email, type, avatar, verified, notifications_enabled, country, locale, company, job_title, created_date, updated_date, last_active, disabled, mfa_enabled, team_digest_enabled FROM users WHERE id = $1 AND deleted_at IS NULL",
 		UserID,
 	).Scan(
 		&w.Id,
@@ -87,6 +101,8 @@ func (d *Database) GetUser(UserID string) (*model.User, error) {
 		&w.UpdatedDate,
 		&w.LastActive,
 		&w.Disabled,
+		&w.MFAEnabled,
+		&w.TeamDigestEnabled,
 	)
 	if err != nil {
 		d.logger.Error("get user query error", zap.Error(err))
@@ -107,7 +123,27 @@ func (d *Database) GetUser(UserID string) (*model.User, error) {
 	return &w, nil
 }
 
-// GetGuestUser gets a guest user by ID
+// GetLastCreateTime gets when the user last created a battle, retro, or storyboard, zero time if never
+func (d *Database) GetLastCreateTime(UserID string) (time.Time, error) {
+	var LastCreated sql.NullTime
+
+	err := d.db.QueryRow(
+		`SELECT GREATEST(
+			(SELECT MAX(created_date) FROM battles WHERE owner_id = $1),
+			(SELECT MAX(created_date) FROM retro WHERE owner_id = $1),
+			(SELECT MAX(created_date) FROM storyboard WHERE owner_id = $1)
+		) AT TIME ZONE current_setting('TimeZone');`,
+		UserID,
+	).Scan(&LastCreated)
+	if err != nil {
+		d.logger.Error("get user last create time error", zap.Error(err))
+		return time.Time{}, errors.New("unable to get user last create time")
+	}
+
+	return LastCreated.Time, nil
+}
+
+// GetGuestUser gets a guest user by ID, disabled and deleted guests are not found
 func (d *Database) GetGuestUser(UserID string) (*model.User, error) {
 	var w model.User
 	var UserEmail sql.NullString
@@ -119,7 +155,7 @@ func (d *Database) GetGuestUser(UserID string) (*model.User, error) {
 	err := d.db.QueryRow(`
 SELECT id, name, email, type, avatar, verified, notifications_enabled, country, locale, company, job_title, created_date, updated_date, last_active
 FROM users
-WHERE id = $1 AND type = 'GUEST';
+WHERE id = $1 AND type = 'GUEST' AND disabled = false AND deleted_at IS NULL;
 `,
 		UserID,
 	).Scan(
@@ -157,7 +193,7 @@ WHERE id = $1 AND type = 'GUEST';
 func (d *Database) GetUserByEmail(UserEmail string) (*model.User, error) {
 	var w model.User
 	err := d.db.QueryRow(
-		"SELECT id, name, email, type, verified, disabled FROM users WHERE email = $1",
+		"SELECT id, name, email, type, verified, disabled FROM users WHERE email = $1 AND deleted_at IS NULL",
 		UserEmail,
 	).Scan(
 		&w.Id,
@@ -207,33 +243,44 @@ func (d *Database) CreateUserRegistered(UserName string, UserEmail string, UserP
 		GravatarHash: createGravatarHash(UserEmail),
 	}
 
+	tx, err := d.db.Begin()
+	if err != nil {
+		d.logger.Error("register user transaction error", zap.Error(err))
+		return nil, "", "", errors.New("unable to register user")
+	}
+	defer tx.Rollback()
+
+	if err := tx.QueryRow(
+		`SELECT userId, verifyId FROM register_user($1, $2, $3, $4);`,
+		UserName,
+		UserEmail,
+		hashedPassword,
+		UserType,
+	).Scan(&User.Id, &verifyID); err != nil {
+		d.logger.Error("register_user query error", zap.Error(err))
+		return nil, "", "", errors.New("a user with that email already exists")
+	}
+
+	// carry over the history of the guest registering, a registered active user is left as is
 	if ActiveUserID != "" {
-		err := d.db.QueryRow(
-			`SELECT userId, verifyId FROM register_existing_user($1, $2, $3, $4, $5);`,
+		var ActiveUserType string
+		if err := tx.QueryRow(
+			`SELECT type FROM users WHERE id = $1;`,
 			ActiveUserID,
-			UserName,
-			UserEmail,
-			hashedPassword,
-			UserType,
-		).Scan(&User.Id, &verifyID)
-		if err != nil {
-			d.logger.Error("register_existing_user query error", zap.Error(err))
-			return nil, "", "", errors.New("a user with that email already exists")
-		}
-	} else {
-		err := d.db.QueryRow(
-			`SELECT userId, verifyId FROM register_user($1, $2, $3, $4);`,
-			UserName,
-			UserEmail,
-			hashedPassword,
-			UserType,
-		).Scan(&User.Id, &verifyID)
-		if err != nil {
-			d.logger.Error("register_user query error", zap.Error(err))
-			return nil, "", "", errors.New("a user with that email already exists")
+		).Scan(&ActiveUserType); err == nil && ActiveUserType == "GUEST" {
+			if err := d.mergeGuestIntoUser(tx, ActiveUserID, User.Id); err != nil {
+				return nil, "", "", err
+			}
 		}
 	}
 
+	if err := tx.Commit(); err != nil {
+		d.logger.Error("register user commit error", zap.Error(err))
+		return nil, "", "", errors.New("unable to register user")
+	}
+
+	_ = d.setTokenExpiry(TokenTypeVerify, verifyID)
+
 	sessionId, sessErr := d.CreateSession(User.Id)
 	if sessErr != nil {
 		return nil, "", "", sessErr
@@ -272,6 +319,8 @@ func (d *Database) CreateUser(UserName string, UserEmail string, UserPassword st
 		return nil, "", errors.New("a user with that email already exists")
 	}
 
+	_ = d.setTokenExpiry(TokenTypeVerify, verifyID)
+
 	return User, verifyID, nil
 }
 
@@ -320,6 +369,40 @@ func (d *Database) UpdateUserProfileLdap(UserID string, UserAvatar string, Notif
 	return nil
 }
 
+// ldapDirectoryFieldLengths the directory managed profile fields and their column lengths
+var ldapDirectoryFieldLengths = map[string]int{"name": 64, "company": 256, "job_title": 128}
+
+// UpdateUserDirectoryFields syncs the directory managed profile fields (name, company, job_title) of an LDAP user,
+// email isn't synced as LDAP users are matched by it
+func (d *Database) UpdateUserDirectoryFields(UserID string, Fields map[string]string) error {
+	var sets []string
+	var args = []interface{}{UserID}
+	for Field, Value := range Fields {
+		MaxLength, ok := ldapDirectoryFieldLengths[Field]
+		if !ok {
+			continue
+		}
+		if r := []rune(Value); len(r) > MaxLength {
+			Value = string(r[:MaxLength])
+		}
+		args = append(args, Value)
+		sets = append(sets, fmt.Sprintf("%s = $%d", Field, len(args)))
+	}
+	if len(sets) == 0 {
+		return nil
+	}
+
+	if _, err := d.db.Exec(
+		`UPDATE users SET `+strings.Join(sets, ", ")+`, updated_date = NOW() WHERE id = $1;`,
+		args...,
+	); err != nil {
+		d.logger.Error("update user directory fields query error", zap.Error(err))
+		return errors.New("unable to sync users directory fields")
+	}
+
+	return nil
+}
+
 // UpdateUserAccount updates the users profile including email (excludes: password)
 func (d *Database) UpdateUserAccount(UserID string, UserName string, UserEmail string, UserAvatar string, NotificationsEnabled bool, Country string, Locale string, Company string, JobTitle string) error {
 	if UserAvatar == "" {
@@ -345,6 +428,20 @@ func (d *Database) UpdateUserAccount(UserID string, UserName string, UserEmail s
 
 // DeleteUser deletes a user
 func (d *Database) DeleteUser(UserID string) error {
+	return d.deleteUser(UserID, d.config.EmailUniqueIncludingDeleted)
+}
+
+// deleteUser deletes a user, when KeepEmail is set recording the users email hash so it can't be
+// registered again, otherwise dropping any recorded hash for the email
+func (d *Database) deleteUser(UserID string, KeepEmail bool) error {
+	var UserEmail sql.NullString
+	if err := d.db.QueryRow(
+		`SELECT email FROM users WHERE id = $1;`,
+		UserID,
+	).Scan(&UserEmail); err != nil {
+		d.logger.Error("get deleted user email query error", zap.Error(err))
+	}
+
 	if _, err := d.db.Exec(
 		`call delete_user($1);`,
 		UserID,
@@ -353,6 +450,121 @@ func (d *Database) DeleteUser(UserID string) error {
 		return errors.New("error attempting to delete user")
 	}
 
+	if UserEmail.String != "" && KeepEmail {
+		if _, err := d.db.Exec(
+			`INSERT INTO user_deleted_email (email_hash) VALUES ($1) ON CONFLICT (email_hash) DO UPDATE SET deleted_date = NOW();`,
+			hashString(strings.ToLower(UserEmail.String)),
+		); err != nil {
+			d.logger.Error("insert user_deleted_email error", zap.Error(err))
+		}
+	} else if UserEmail.String != "" {
+		if _, err := d.db.Exec(
+			`DELETE FROM user_deleted_email WHERE email_hash = $1;`,
+			hashString(strings.ToLower(UserEmail.String)),
+		); err != nil {
+			d.logger.Error("delete user_deleted_email error", zap.Error(err))
+		}
+	}
+
+	return nil
+}
+
+// AnonymizeUser scrubs the users personal information while keeping the user row and their
+// non-personal associations (battle participation, votes) intact, and revokes their sessions and credentials
+func (d *Database) AnonymizeUser(UserID string) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		d.logger.Error("anonymize user begin transaction error", zap.Error(err))
+		return errors.New("error attempting to anonymize user")
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		`UPDATE users SET
+			name = 'Deleted User',
+			email = CONCAT('deleted-', md5(random()::TEXT), '@anonymized.invalid'),
+			password = NULL, avatar = 'identicon', verified = false, notifications_enabled = false,
+			country = NULL, locale = NULL, company = NULL, job_title = NULL,
+			mfa_enabled = false, mfa_secret = NULL, mfa_last_step = NULL,
+			updated_date = NOW()
+		WHERE id = $1;`,
+		UserID,
+	); err != nil {
+		d.logger.Error("anonymize user query error", zap.Error(err))
+		return errors.New("error attempting to anonymize user")
+	}
+
+	for _, q := range []string{
+		`DELETE FROM user_session WHERE user_id = $1;`,
+		`DELETE FROM user_reset WHERE user_id = $1;`,
+		`DELETE FROM user_verify WHERE user_id = $1;`,
+		`DELETE FROM api_keys WHERE user_id = $1;`,
+		`DELETE FROM user_email_change WHERE user_id = $1;`,
+		`DELETE FROM user_mfa_recovery_code WHERE user_id = $1;`,
+		`DELETE FROM user_mfa_token WHERE user_id = $1;`,
+		`DELETE FROM password_history WHERE user_id = $1;`,
+	} {
+		if _, err := tx.Exec(q, UserID); err != nil {
+			d.logger.Error("anonymize user credentials query error", zap.Error(err))
+			return errors.New("error attempting to anonymize user")
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		d.logger.Error("anonymize user commit error", zap.Error(err))
+		return errors.New("error attempting to anonymize user")
+	}
+
+	return nil
+}
+
+// CheckEmailAvailable checks the email isn't used by another active account, or by a soft deleted account
+// still within its grace period, and when enabled by a deleted account, returning EMAIL_IN_USE or EMAIL_IN_USE_DELETED_ACCOUNT
+func (d *Database) CheckEmailAvailable(UserEmail string, UserID string) error {
+	var InUse bool
+	var Deleted bool
+	if err := d.db.QueryRow(
+		`SELECT EXISTS(SELECT 1 FROM users WHERE LOWER(email) = LOWER($1) AND id::TEXT != $2 AND deleted_at IS NULL),
+			EXISTS(SELECT 1 FROM users WHERE LOWER(email) = LOWER($1) AND id::TEXT != $2 AND deleted_at IS NOT NULL);`,
+		UserEmail,
+		UserID,
+	).Scan(&InUse, &Deleted); err != nil {
+		d.logger.Error("check user email in use query error", zap.Error(err))
+		return errors.New("unable to check email")
+	}
+	if InUse {
+		return errors.New("EMAIL_IN_USE")
+	}
+	if Deleted {
+		return errors.New("EMAIL_IN_USE_DELETED_ACCOUNT")
+	}
+
+	if d.config.EmailUniqueIncludingDeleted {
+		if err := d.db.QueryRow(
+			`SELECT EXISTS(SELECT 1 FROM user_deleted_email WHERE email_hash = $1);`,
+			hashString(strings.ToLower(UserEmail)),
+		).Scan(&InUse); err != nil {
+			d.logger.Error("check deleted user email query error", zap.Error(err))
+			return errors.New("unable to check email")
+		}
+		if InUse {
+			return errors.New("EMAIL_IN_USE_DELETED_ACCOUNT")
+		}
+	}
+
+	return nil
+}
+
+// PurgeDeletedUserEmails purges the deleted account emails older than DaysOld allowing them to be registered again
+func (d *Database) PurgeDeletedUserEmails(DaysOld int) error {
+	if _, err := d.db.Exec(
+		`DELETE FROM user_deleted_email WHERE deleted_date < (NOW() - $1 * interval '1 day');`,
+		DaysOld,
+	); err != nil {
+		d.logger.Error("purge user_deleted_email error", zap.Error(err))
+		return errors.New("error attempting to purge deleted user emails")
+	}
+
 	return nil
 }
 
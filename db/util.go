@@ -10,7 +10,9 @@ import (
 	"encoding/hex"
 	"io"
 	"math/big"
+	"strings"
 
+	"github.com/microcosm-cc/bluemonday"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -25,6 +27,34 @@ func contains(s []string, str string) bool {
 	return false
 }
 
+// containsInt checks if an int is present in a slice
+func containsInt(s []int, i int) bool {
+	for _, v := range s {
+		if v == i {
+			return true
+		}
+	}
+
+	return false
+}
+
+// newHTMLSanitizerPolicy creates the sanitizer policy for user provided rich text (e.g. comments, descriptions),
+// falling back to the bluemonday user generated content policy when no allowed tags are configured
+func newHTMLSanitizerPolicy(AllowedTags []string) *bluemonday.Policy {
+	if len(AllowedTags) == 0 {
+		return bluemonday.UGCPolicy()
+	}
+
+	p := bluemonday.NewPolicy()
+	p.AllowElements(AllowedTags...)
+	p.AllowStandardURLs()
+	p.AllowAttrs("href").OnElements("a")
+	p.AllowAttrs("src", "alt").OnElements("img")
+	p.RequireNoFollowOnLinks(true)
+
+	return p
+}
+
 // random generates a random secure byte of X length
 func random(length int) ([]byte, error) {
 	chars := "-_+=!$0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
@@ -45,6 +75,32 @@ func random(length int) ([]byte, error) {
 	return bytes, nil
 }
 
+// shortCodeChars characters used in human friendly codes, excluding ambiguous characters e.g. 0/O and 1/I/L
+const shortCodeChars = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+
+// randomShortCode generates a random human friendly code of X length
+func randomShortCode(length int) (string, error) {
+	code := make([]byte, length)
+
+	for i := 0; i < length; i++ {
+		num, err := rand.Int(rand.Reader, big.NewInt(int64(len(shortCodeChars))))
+		if err != nil {
+			return "", err
+		}
+		code[i] = shortCodeChars[num.Int64()]
+	}
+
+	return string(code), nil
+}
+
+// normalizeShortCode uppercases and strips separators from a user entered short code
+func normalizeShortCode(code string) string {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	code = strings.ReplaceAll(code, "-", "")
+
+	return strings.ReplaceAll(code, " ", "")
+}
+
 // randomString returns a random secure string of X length
 func randomString(l int) (string, error) {
 	s, err := random(l)
@@ -167,3 +223,8 @@ func createGravatarHash(email string) string {
 	gh := md5.Sum([]byte(email))
 	return hex.EncodeToString(gh[:])
 }
+
+// escapeLikePattern escapes the LIKE wildcards in user input so it's matched literally
+func escapeLikePattern(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -1,7 +1,13 @@
 package db
 
 import (
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"golang.org/x/crypto/bcrypt"
 )
 
 // TestHashString calls hashString and makes sure the return is not the same as the input
@@ -24,3 +30,667 @@ func TestHashString(t *testing.T) {
 		t.Fatalf(`expected HashedResult1: %s to match HashedString: %s`, HashedResult1, HashedString)
 	}
 }
+
+// TestTokenTTL calls tokenTTL and makes sure the default is used when not configured
+// and the configured TTL never exceeds the token type's maximum
+func TestTokenTTL(t *testing.T) {
+	if ttl := tokenTTL(map[string]int{}, TokenTypeReset); ttl != tokenTTLDefaults[TokenTypeReset] {
+		t.Fatalf(`expected tokenTTL: %d to match default: %d`, ttl, tokenTTLDefaults[TokenTypeReset])
+	}
+
+	if ttl := tokenTTL(map[string]int{TokenTypeReset: 30}, TokenTypeReset); ttl != 30 {
+		t.Fatalf(`expected tokenTTL: %d to match configured: 30`, ttl)
+	}
+
+	if ttl := tokenTTL(map[string]int{TokenTypeReset: 999999}, TokenTypeReset); ttl != tokenTTLMaximums[TokenTypeReset] {
+		t.Fatalf(`expected tokenTTL: %d to match maximum: %d`, ttl, tokenTTLMaximums[TokenTypeReset])
+	}
+}
+
+// TestAdjacentPlanID calls adjacentPlanID and makes sure plans are navigated sequentially
+func TestAdjacentPlanID(t *testing.T) {
+	Plans := []*model.Plan{{Id: "mjolnir"}, {Id: "stormbreaker"}, {Id: "gungnir"}}
+
+	if PlanID, _ := adjacentPlanID(Plans, "", 1); PlanID != "mjolnir" {
+		t.Fatalf(`expected PlanID: %s to match first plan: mjolnir`, PlanID)
+	}
+
+	if PlanID, _ := adjacentPlanID(Plans, "mjolnir", 1); PlanID != "stormbreaker" {
+		t.Fatalf(`expected PlanID: %s to match next plan: stormbreaker`, PlanID)
+	}
+
+	if PlanID, _ := adjacentPlanID(Plans, "stormbreaker", -1); PlanID != "mjolnir" {
+		t.Fatalf(`expected PlanID: %s to match previous plan: mjolnir`, PlanID)
+	}
+
+	if _, err := adjacentPlanID(Plans, "gungnir", 1); err == nil {
+		t.Fatalf(`expected adjacentPlanID after last plan to error`)
+	}
+}
+
+// TestHTMLSanitizerPolicy calls newHTMLSanitizerPolicy with default and configured allowed tags
+// and makes sure known XSS payloads are neutralized
+func TestHTMLSanitizerPolicy(t *testing.T) {
+	XSSPayloads := []string{
+		`<script>alert('loki')</script>`,
+		`<img src=x onerror="alert('loki')">`,
+		`<a href="javascript:alert('loki')">hammer</a>`,
+		`<svg onload="alert('loki')"></svg>`,
+		`<iframe src="https://jotunheim.dev"></iframe>`,
+		`<p style="background:url(javascript:alert('loki'))" onclick="alert('loki')">hammer</p>`,
+	}
+	Policies := map[string][]string{
+		"default":    {},
+		"configured": {"p", "a", "img", "strong"},
+	}
+
+	for name, AllowedTags := range Policies {
+		p := newHTMLSanitizerPolicy(AllowedTags)
+		for _, payload := range XSSPayloads {
+			sanitized := strings.ToLower(p.Sanitize(payload))
+			for _, unsafe := range []string{"<script", "onerror", "javascript:", "onload", "<iframe", "onclick", "<svg"} {
+				if strings.Contains(sanitized, unsafe) {
+					t.Fatalf(`expected %s policy to neutralize payload: %s, got: %s`, name, payload, sanitized)
+				}
+			}
+		}
+	}
+
+	configured := newHTMLSanitizerPolicy([]string{"strong"})
+	if sanitized := configured.Sanitize(`<strong>Thor</strong><em>Odinson</em>`); sanitized != `<strong>Thor</strong>Odinson` {
+		t.Fatalf(`expected configured policy to only allow strong tag, got: %s`, sanitized)
+	}
+}
+
+// TestValidatePlanAcceptanceCriterion calls validatePlanAcceptanceCriterion with empty, too long, and over the limit items
+func TestValidatePlanAcceptanceCriterion(t *testing.T) {
+	if err := validatePlanAcceptanceCriterion("Mjolnir can be lifted", 0); err != nil {
+		t.Fatalf(`validatePlanAcceptanceCriterion = %v, want nil`, err)
+	}
+
+	if err := validatePlanAcceptanceCriterion("  ", 0); err == nil || err.Error() != "ACCEPTANCE_CRITERION_REQUIRED" {
+		t.Fatalf(`validatePlanAcceptanceCriterion = %v, want ACCEPTANCE_CRITERION_REQUIRED`, err)
+	}
+
+	if err := validatePlanAcceptanceCriterion(strings.Repeat("a", maxPlanAcceptanceCriterionLength+1), 0); err == nil || err.Error() != "ACCEPTANCE_CRITERION_TOO_LONG" {
+		t.Fatalf(`validatePlanAcceptanceCriterion = %v, want ACCEPTANCE_CRITERION_TOO_LONG`, err)
+	}
+
+	if err := validatePlanAcceptanceCriterion("Mjolnir can be lifted", maxPlanAcceptanceCriteria); err == nil || err.Error() != "ACCEPTANCE_CRITERIA_LIMIT_REACHED" {
+		t.Fatalf(`validatePlanAcceptanceCriterion = %v, want ACCEPTANCE_CRITERIA_LIMIT_REACHED`, err)
+	}
+}
+
+// TestRandomShortCode calls randomShortCode and normalizeShortCode making sure codes avoid ambiguous characters
+// and user entered codes are case-insensitive
+func TestRandomShortCode(t *testing.T) {
+	code, err := randomShortCode(6)
+	if err != nil || len(code) != 6 {
+		t.Fatalf(`randomShortCode = %q, %v, want 6 character code`, code, err)
+	}
+	if strings.ContainsAny(code, "0O1IL") {
+		t.Fatalf(`randomShortCode = %q, want no ambiguous characters`, code)
+	}
+
+	if n := normalizeShortCode(" abc-23x "); n != "ABC23X" {
+		t.Fatalf(`normalizeShortCode = %q, want ABC23X`, n)
+	}
+}
+
+// TestValidateStoryboardImport calls validateStoryboardImport with a valid import, one with invalid references and one with null entries
+func TestValidateStoryboardImport(t *testing.T) {
+	i := &model.StoryboardImport{
+		Name:    "Asgard Rebuild",
+		Goals:   []*model.StoryboardImportGoal{{Key: "g1", Name: "Walls"}},
+		Columns: []*model.StoryboardImportColumn{{Key: "c1", GoalKey: "g1", Name: "Foundation"}},
+		Stories: []*model.StoryboardImportStory{{ColumnKey: "c1", Name: "Dig", Color: "blue", Points: 3}},
+	}
+	if problems := validateStoryboardImport(i); len(problems) != 0 {
+		t.Fatalf(`validateStoryboardImport = %v, want no problems`, problems)
+	}
+
+	i.Columns = append(i.Columns, &model.StoryboardImportColumn{Key: "c1", GoalKey: "g2"})
+	i.Stories = append(i.Stories, &model.StoryboardImportStory{ColumnKey: "c3", Color: "rainbow"})
+	want := []string{
+		"columns[1].key DUPLICATE_OR_MISSING_KEY",
+		"columns[1].goalKey UNKNOWN_GOAL",
+		"stories[1].columnKey UNKNOWN_COLUMN",
+		"stories[1].color INVALID_COLOR",
+	}
+	problems := validateStoryboardImport(i)
+	if strings.Join(problems, ",") != strings.Join(want, ",") {
+		t.Fatalf(`validateStoryboardImport = %v, want %v`, problems, want)
+	}
+
+	i.Goals = append(i.Goals, nil)
+	i.Columns = []*model.StoryboardImportColumn{nil}
+	i.Stories = []*model.StoryboardImportStory{nil}
+	want = []string{
+		"goals[1] MISSING_GOAL",
+		"columns[0] MISSING_COLUMN",
+		"stories[0] MISSING_STORY",
+	}
+	problems = validateStoryboardImport(i)
+	if strings.Join(problems, ",") != strings.Join(want, ",") {
+		t.Fatalf(`validateStoryboardImport = %v, want %v`, problems, want)
+	}
+}
+
+// TestEvaluateFlag tests feature flag targeting by role, user id and percentage rollout
+func TestEvaluateFlag(t *testing.T) {
+	user := &model.User{Id: "f8b1d2c0-0000-4000-8000-000000000001", Type: "REGISTERED"}
+
+	if evaluateFlag(&model.FeatureFlag{Name: "beta", Enabled: false}, user) {
+		t.Fatalf("expected disabled flag to be off")
+	}
+	if !evaluateFlag(&model.FeatureFlag{Name: "beta", Enabled: true}, user) {
+		t.Fatalf("expected enabled flag without rules to be on")
+	}
+	if !evaluateFlag(&model.FeatureFlag{Name: "beta", Enabled: true, Roles: []string{"REGISTERED"}}, user) {
+		t.Fatalf("expected role targeted flag to be on")
+	}
+	if evaluateFlag(&model.FeatureFlag{Name: "beta", Enabled: true, Roles: []string{"ADMIN"}}, user) {
+		t.Fatalf("expected flag targeting another role to be off")
+	}
+	if !evaluateFlag(&model.FeatureFlag{Name: "beta", Enabled: true, Roles: []string{"ADMIN"}, UserIds: []string{user.Id}}, user) {
+		t.Fatalf("expected user id targeted flag to be on")
+	}
+	if !evaluateFlag(&model.FeatureFlag{Name: "beta", Enabled: true, Percentage: 100}, user) {
+		t.Fatalf("expected 100 percent rollout to be on")
+	}
+	bucket := flagBucket("beta", user.Id)
+	if evaluateFlag(&model.FeatureFlag{Name: "beta", Enabled: true, Roles: []string{"ADMIN"}, Percentage: bucket}, user) {
+		t.Fatalf("expected rollout below users bucket to be off")
+	}
+	if !evaluateFlag(&model.FeatureFlag{Name: "beta", Enabled: true, Roles: []string{"ADMIN"}, Percentage: bucket + 1}, user) {
+		t.Fatalf("expected rollout above users bucket to be on")
+	}
+}
+
+// TestPlanLimitExceeded tests the plan limit check including unlimited
+func TestPlanLimitExceeded(t *testing.T) {
+	if planLimitExceeded(0, 5000, 1) {
+		t.Fatalf("expected 0 limit to be unlimited")
+	}
+	if planLimitExceeded(10, 9, 1) {
+		t.Fatalf("expected adding up to the limit to be allowed")
+	}
+	if !planLimitExceeded(10, 9, 2) {
+		t.Fatalf("expected adding past the limit to be rejected")
+	}
+}
+
+// TestStorageQuotaExceeded tests the storage quota check including unlimited
+func TestStorageQuotaExceeded(t *testing.T) {
+	if storageQuotaExceeded(0, 1<<40, 1) {
+		t.Fatalf("expected 0 quota to be unlimited")
+	}
+	if storageQuotaExceeded(100, 60, 40) {
+		t.Fatalf("expected usage up to the quota to be allowed")
+	}
+	if !storageQuotaExceeded(100, 60, 41) {
+		t.Fatalf("expected usage past the quota to be rejected")
+	}
+}
+
+// TestAllReadyToReveal tests that reveal requires every participant be ready
+func TestAllReadyToReveal(t *testing.T) {
+	if allReadyToReveal(&model.RevealReadiness{ReadyCount: 0, ParticipantCount: 0}) {
+		t.Fatalf("expected no participants to not be ready")
+	}
+	if allReadyToReveal(&model.RevealReadiness{ReadyCount: 2, ParticipantCount: 3}) {
+		t.Fatalf("expected partial readiness to not be ready")
+	}
+	if !allReadyToReveal(&model.RevealReadiness{ReadyCount: 3, ParticipantCount: 3}) {
+		t.Fatalf("expected all participants ready")
+	}
+}
+
+// TestValidatePlanMerge calls validatePlanMerge making sure a plan can't be merged with itself, while voting,
+// or when both are finalized with different points
+func TestValidatePlanMerge(t *testing.T) {
+	if err := validatePlanMerge("1", "2", mergePlan{Points: "3"}, mergePlan{}); err != nil {
+		t.Fatalf(`validatePlanMerge = %v, want nil`, err)
+	}
+
+	if err := validatePlanMerge("1", "1", mergePlan{}, mergePlan{}); err == nil || err.Error() != "MERGE_SAME_PLAN" {
+		t.Fatalf(`validatePlanMerge = %v, want MERGE_SAME_PLAN`, err)
+	}
+
+	if err := validatePlanMerge("1", "2", mergePlan{}, mergePlan{Active: true}); err == nil || err.Error() != "PLAN_VOTING_ACTIVE" {
+		t.Fatalf(`validatePlanMerge = %v, want PLAN_VOTING_ACTIVE`, err)
+	}
+
+	if err := validatePlanMerge("1", "2", mergePlan{Points: "3"}, mergePlan{Points: "5"}); err == nil || err.Error() != "PLANS_FINALIZED_DIFFERENT_POINTS" {
+		t.Fatalf(`validatePlanMerge = %v, want PLANS_FINALIZED_DIFFERENT_POINTS`, err)
+	}
+}
+
+// TestPollNeedsClarification calls pollNeedsClarification making sure a plan needs clarification
+// once at least a third of the responses are unsure
+func TestPollNeedsClarification(t *testing.T) {
+	if pollNeedsClarification(&model.PlanPoll{}) {
+		t.Fatalf(`pollNeedsClarification = true for no responses, want false`)
+	}
+
+	if pollNeedsClarification(&model.PlanPoll{Yes: 5, No: 1, Unsure: 2}) {
+		t.Fatalf(`pollNeedsClarification = true for mostly yes, want false`)
+	}
+
+	if !pollNeedsClarification(&model.PlanPoll{Yes: 4, Unsure: 2}) {
+		t.Fatalf(`pollNeedsClarification = false for a third unsure, want true`)
+	}
+}
+
+// TestValidateBattleReopen calls validateBattleReopen making sure only closed battles within the window
+// can be reopened unless unrestricted
+func TestValidateBattleReopen(t *testing.T) {
+	now := time.Now()
+	closedDate := now.Add(-10 * 24 * time.Hour)
+
+	if err := validateBattleReopen(false, time.Time{}, now, 7, false); err == nil || err.Error() != "BATTLE_NOT_CLOSED" {
+		t.Fatalf(`validateBattleReopen = %v, want BATTLE_NOT_CLOSED`, err)
+	}
+
+	if err := validateBattleReopen(true, closedDate, now, 7, false); err == nil || err.Error() != "BATTLE_REOPEN_WINDOW_EXPIRED" {
+		t.Fatalf(`validateBattleReopen = %v, want BATTLE_REOPEN_WINDOW_EXPIRED`, err)
+	}
+
+	if err := validateBattleReopen(true, closedDate, now, 7, true); err != nil {
+		t.Fatalf(`validateBattleReopen = %v for unrestricted reopen, want nil`, err)
+	}
+
+	if err := validateBattleReopen(true, closedDate, now, 30, false); err != nil {
+		t.Fatalf(`validateBattleReopen = %v within window, want nil`, err)
+	}
+}
+
+// TestValidateBattleStateTransition calls validateBattleStateTransition making sure only
+// transitions allowed by the workflow are accepted
+func TestValidateBattleStateTransition(t *testing.T) {
+	Workflow := &model.BattleStateWorkflow{
+		States: []string{"draft", "estimating", "reviewed", "done"},
+		Transitions: map[string][]string{
+			"draft":      {"estimating"},
+			"estimating": {"reviewed", "draft"},
+			"reviewed":   {"done", "estimating"},
+		},
+	}
+
+	if err := validateBattleStateWorkflow(Workflow); err != nil {
+		t.Fatalf(`validateBattleStateWorkflow = %v, want nil`, err)
+	}
+	if err := validateBattleStateTransition(Workflow, "draft", "estimating"); err != nil {
+		t.Fatalf(`validateBattleStateTransition = %v for allowed transition, want nil`, err)
+	}
+	if err := validateBattleStateTransition(Workflow, "draft", "done"); err == nil || err.Error() != "INVALID_STATE_TRANSITION" {
+		t.Fatalf(`validateBattleStateTransition = %v for disallowed transition, want INVALID_STATE_TRANSITION`, err)
+	}
+	if err := validateBattleStateTransition(Workflow, "done", "draft"); err == nil {
+		t.Fatalf(`validateBattleStateTransition = nil for transition from final state, want INVALID_STATE_TRANSITION`)
+	}
+	if err := validateBattleStateTransition(Workflow, "open", "draft"); err != nil {
+		t.Fatalf(`validateBattleStateTransition = %v for state no longer in workflow, want nil`, err)
+	}
+
+	Invalid := &model.BattleStateWorkflow{
+		States:      []string{"draft", "done"},
+		Transitions: map[string][]string{"draft": {"archived"}},
+	}
+	if err := validateBattleStateWorkflow(Invalid); err == nil {
+		t.Fatalf(`validateBattleStateWorkflow = nil for transition to unknown state, want INVALID_STATE_WORKFLOW`)
+	}
+}
+
+// TestParseUserViewFilter calls parseUserViewFilter making sure only the supported filters are accepted
+func TestParseUserViewFilter(t *testing.T) {
+	Filter, err := parseUserViewFilter(`{"list": "battles", "state": "estimating", "teamId": "2d4fdca4-6da4-4b27-8b52-4b2fe5d0b0b1"}`)
+	if err != nil || Filter.List != "battles" || Filter.State != "estimating" {
+		t.Fatalf(`parseUserViewFilter = %v, %v, want battles view filtered by state`, Filter, err)
+	}
+
+	for _, FilterJSON := range []string{
+		`{"list": "retros"}`,
+		`{"list": "storyboards", "state": "open"}`,
+		`{"list": "battles", "owner": "thor"}`,
+		`not json`,
+	} {
+		if _, err := parseUserViewFilter(FilterJSON); err == nil || err.Error() != "INVALID_VIEW_FILTER" {
+			t.Fatalf(`parseUserViewFilter(%s) = %v, want INVALID_VIEW_FILTER`, FilterJSON, err)
+		}
+	}
+}
+
+// TestValidatePlanDiscussionEntry calls validatePlanDiscussionEntry making sure empty, too long
+// and over the per plan limit entries are rejected
+func TestValidatePlanDiscussionEntry(t *testing.T) {
+	if err := validatePlanDiscussionEntry("Agreed to split the **API** work out", 0); err != nil {
+		t.Fatalf(`validatePlanDiscussionEntry = %v, want nil`, err)
+	}
+	if err := validatePlanDiscussionEntry("  ", 0); err == nil || err.Error() != "DISCUSSION_ENTRY_REQUIRED" {
+		t.Fatalf(`validatePlanDiscussionEntry = %v for empty entry, want DISCUSSION_ENTRY_REQUIRED`, err)
+	}
+	if err := validatePlanDiscussionEntry(strings.Repeat("a", maxPlanDiscussionEntryLength+1), 0); err == nil || err.Error() != "DISCUSSION_ENTRY_TOO_LONG" {
+		t.Fatalf(`validatePlanDiscussionEntry = %v for long entry, want DISCUSSION_ENTRY_TOO_LONG`, err)
+	}
+	if err := validatePlanDiscussionEntry("note", maxPlanDiscussionEntries); err == nil || err.Error() != "DISCUSSION_LIMIT_REACHED" {
+		t.Fatalf(`validatePlanDiscussionEntry = %v at the limit, want DISCUSSION_LIMIT_REACHED`, err)
+	}
+}
+
+// TestValidateTOTP calls validateTOTP with the RFC 6238 test secret making sure codes are accepted
+// within ±1 time step and can't be replayed
+func TestValidateTOTP(t *testing.T) {
+	Secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	Now := time.Unix(1111111109, 0)
+
+	if code, err := totpCode(Secret, Now.Unix()/30); err != nil || code != "081804" {
+		t.Fatalf(`totpCode = %s, %v, want 081804`, code, err)
+	}
+
+	Step, valid := validateTOTP(Secret, "081804", Now.Add(30*time.Second), 0)
+	if !valid || Step != Now.Unix()/30 {
+		t.Fatalf(`validateTOTP = %d, %v for previous step code, want valid`, Step, valid)
+	}
+	if _, valid := validateTOTP(Secret, "081804", Now.Add(90*time.Second), 0); valid {
+		t.Fatalf(`validateTOTP = valid for code outside the window, want invalid`)
+	}
+	if _, valid := validateTOTP(Secret, "081804", Now, Step); valid {
+		t.Fatalf(`validateTOTP = valid for replayed code, want invalid`)
+	}
+}
+
+// TestComputeEstimationAccuracy calls computeEstimationAccuracy making sure over and underestimates
+// are averaged overall and per month, skipping non-numeric estimates via parsePlanPoints
+func TestComputeEstimationAccuracy(t *testing.T) {
+	if v, ok := parsePlanPoints("1/2"); !ok || v != 0.5 {
+		t.Fatalf(`parsePlanPoints("1/2") = %v, %v, want 0.5, true`, v, ok)
+	}
+	if _, ok := parsePlanPoints("?"); ok {
+		t.Fatalf(`parsePlanPoints("?") = true, want false`)
+	}
+
+	June := time.Date(2022, 6, 10, 0, 0, 0, 0, time.UTC)
+	July := time.Date(2022, 7, 10, 0, 0, 0, 0, time.UTC)
+	Accuracy := computeEstimationAccuracy([]estimationSample{
+		{Estimate: 3, Actual: 5, Date: June},
+		{Estimate: 8, Actual: 4, Date: June},
+		{Estimate: 5, Actual: 5, Date: July},
+	})
+
+	if Accuracy.SampleCount != 3 || Accuracy.UnderEstimated != 1 || Accuracy.OverEstimated != 1 || Accuracy.Accurate != 1 {
+		t.Fatalf(`computeEstimationAccuracy counts = %+v, want 3 samples 1 under 1 over 1 accurate`, Accuracy)
+	}
+	if Accuracy.MeanVariance != -2.0/3 || Accuracy.MeanAbsoluteVariance != 2 {
+		t.Fatalf(`computeEstimationAccuracy variance = %v, %v, want -0.67, 2`, Accuracy.MeanVariance, Accuracy.MeanAbsoluteVariance)
+	}
+	if len(Accuracy.Trend) != 2 || Accuracy.Trend[0].Period != "2022-06" || Accuracy.Trend[0].MeanVariance != -1 {
+		t.Fatalf(`computeEstimationAccuracy trend = %+v, want 2022-06 mean variance -1 then 2022-07`, Accuracy.Trend)
+	}
+}
+
+// TestAttributeDelegatedVotes calls attributeDelegatedVotes making sure only votes
+// a delegate cast are attributed to them
+func TestAttributeDelegatedVotes(t *testing.T) {
+	Plan := &model.Plan{
+		Votes: []*model.Vote{
+			{UserId: "thor", VoteValue: "5"},
+			{UserId: "loki", VoteValue: "5"},
+			{UserId: "hulk", VoteValue: "3"},
+		},
+		VoteDelegations: []*model.PlanVoteDelegation{
+			{UserId: "loki", DelegateId: "thor", Voted: true},
+			{UserId: "hulk", DelegateId: "thor", Voted: false},
+		},
+	}
+
+	attributeDelegatedVotes(Plan)
+
+	if Plan.Votes[0].DelegateId != "" {
+		t.Fatalf(`expected thor's own vote to have no delegate, got %s`, Plan.Votes[0].DelegateId)
+	}
+	if Plan.Votes[1].DelegateId != "thor" {
+		t.Fatalf(`expected loki's vote to be attributed to thor, got %s`, Plan.Votes[1].DelegateId)
+	}
+	if Plan.Votes[2].DelegateId != "" {
+		t.Fatalf(`expected hulk's own vote to have no delegate, got %s`, Plan.Votes[2].DelegateId)
+	}
+}
+
+// TestPasswordReused calls passwordReused making sure only a password matching
+// one of the previous hashes is detected
+func TestPasswordReused(t *testing.T) {
+	var Hashes []string
+	for _, Password := range []string{"mjolnir", "stormbreaker"} {
+		Hash, err := bcrypt.GenerateFromPassword([]byte(Password), bcrypt.MinCost)
+		if err != nil {
+			t.Fatalf(`bcrypt.GenerateFromPassword = %v`, err)
+		}
+		Hashes = append(Hashes, string(Hash))
+	}
+
+	if !passwordReused(Hashes, "stormbreaker") {
+		t.Fatalf(`passwordReused = false, want true for a previous password`)
+	}
+	if passwordReused(Hashes, "gungnir") {
+		t.Fatalf(`passwordReused = true, want false for a new password`)
+	}
+	if passwordReused(nil, "mjolnir") {
+		t.Fatalf(`passwordReused = true, want false without history`)
+	}
+}
+
+// TestAnonymizePlanVotes calls anonymizePlanVotes making sure revealed votes keep only
+// their distribution while the active plans votes are left for the running vote
+func TestAnonymizePlanVotes(t *testing.T) {
+	Revealed := &model.Plan{
+		Votes: []*model.Vote{
+			{UserId: "thor", VoteValue: "8"},
+			{UserId: "loki", VoteValue: "3", DelegateId: "thor"},
+		},
+		VoteDelegations: []*model.PlanVoteDelegation{
+			{UserId: "loki", DelegateId: "thor", Voted: true},
+		},
+	}
+
+	anonymizePlanVotes(Revealed)
+
+	for _, v := range Revealed.Votes {
+		if v.UserId != "" || v.DelegateId != "" {
+			t.Fatalf(`expected revealed vote to be anonymous, got %+v`, v)
+		}
+	}
+	if Revealed.Votes[0].VoteValue != "3" || Revealed.Votes[1].VoteValue != "8" {
+		t.Fatalf(`expected revealed votes ordered by value, got %s, %s`, Revealed.Votes[0].VoteValue, Revealed.Votes[1].VoteValue)
+	}
+	if len(Revealed.VoteDelegations) != 0 {
+		t.Fatalf(`expected revealed vote delegations to be dropped, got %d`, len(Revealed.VoteDelegations))
+	}
+
+	Active := &model.Plan{
+		Active: true,
+		Votes:  []*model.Vote{{UserId: "thor", VoteValue: "8"}},
+	}
+
+	anonymizePlanVotes(Active)
+
+	if Active.Votes[0].UserId != "thor" {
+		t.Fatalf(`expected active plan votes to keep who voted, got %s`, Active.Votes[0].UserId)
+	}
+}
+
+// TestStoryboardSnapshotData calls storyboardSnapshotData making sure the snapshot
+// references its goals and columns by key and is a valid storyboard import
+func TestStoryboardSnapshotData(t *testing.T) {
+	Goals := []*model.StoryboardGoal{
+		{GoalID: "g1", GoalName: "Checkout", Columns: []*model.StoryboardColumn{
+			{ColumnID: "c1", ColumnName: "Cart", Stories: []*model.StoryboardStory{
+				{StoryName: "Add item", StoryColor: "blue", StoryPoints: 3},
+				{StoryName: "Remove item", StoryColor: "red", StoryClosed: true},
+			}},
+			{ColumnID: "c2", ColumnName: "Payment", Stories: []*model.StoryboardStory{}},
+		}},
+	}
+
+	Data := storyboardSnapshotData("Shop", Goals)
+
+	if len(Data.Goals) != 1 || len(Data.Columns) != 2 || len(Data.Stories) != 2 {
+		t.Fatalf(`storyboardSnapshotData = %d goals, %d columns, %d stories, want 1, 2, 2`, len(Data.Goals), len(Data.Columns), len(Data.Stories))
+	}
+	if Data.Columns[1].GoalKey != "g1" || Data.Stories[1].ColumnKey != "c1" || !Data.Stories[1].Closed {
+		t.Fatalf(`storyboardSnapshotData didn't keep the goal, column, and story references`)
+	}
+	if problems := validateStoryboardImport(Data); len(problems) > 0 {
+		t.Fatalf(`validateStoryboardImport(snapshot) = %v, want no problems`, problems)
+	}
+}
+
+// TestEstimateExceedsCap calls estimateExceedsCap making sure only numeric
+// estimates above the cap are rejected
+func TestEstimateExceedsCap(t *testing.T) {
+	Cap := 13.0
+
+	for _, Vote := range []string{"21", "40", "100"} {
+		if !estimateExceedsCap(Vote, &Cap) {
+			t.Fatalf(`estimateExceedsCap(%s, 13) = false, want true`, Vote)
+		}
+	}
+	for _, Vote := range []string{"13", "1/2", "?", "☕️"} {
+		if estimateExceedsCap(Vote, &Cap) {
+			t.Fatalf(`estimateExceedsCap(%s, 13) = true, want false`, Vote)
+		}
+	}
+	if estimateExceedsCap("100", nil) {
+		t.Fatalf(`estimateExceedsCap(100, nil) = true, want false without a cap`)
+	}
+}
+
+// TestValidateApiKeyExpiry calls validateApiKeyExpiry making sure keys can
+// never expire or expire in the future but not already be expired
+func TestValidateApiKeyExpiry(t *testing.T) {
+	Now := time.Now()
+	Future := Now.Add(24 * time.Hour)
+	Past := Now.Add(-time.Minute)
+
+	if err := validateApiKeyExpiry(nil, Now); err != nil {
+		t.Fatalf(`validateApiKeyExpiry(nil) = %v, want nil`, err)
+	}
+	if err := validateApiKeyExpiry(&Future, Now); err != nil {
+		t.Fatalf(`validateApiKeyExpiry(future) = %v, want nil`, err)
+	}
+	if err := validateApiKeyExpiry(&Past, Now); err == nil {
+		t.Fatalf(`validateApiKeyExpiry(past) = nil, want INVALID_APIKEY_EXPIRY`)
+	}
+	if err := validateApiKeyExpiry(&Now, Now); err == nil {
+		t.Fatalf(`validateApiKeyExpiry(now) = nil, want INVALID_APIKEY_EXPIRY`)
+	}
+}
+
+// TestEnoughParticipants calls enoughParticipants making sure voting can
+// only start once the minimum is met and that 0 requires no participants
+func TestEnoughParticipants(t *testing.T) {
+	if !enoughParticipants(0, 0) {
+		t.Fatalf(`enoughParticipants(0, 0) = false, want true without a minimum`)
+	}
+	if enoughParticipants(3, 2) {
+		t.Fatalf(`enoughParticipants(3, 2) = true, want false`)
+	}
+	if !enoughParticipants(3, 3) || !enoughParticipants(3, 5) {
+		t.Fatalf(`enoughParticipants(3, 3+) = false, want true`)
+	}
+}
+
+// TestNormalizeTag calls normalizeTag making sure tags are lowercased and
+// trimmed, and that invalid characters and lengths are rejected
+func TestNormalizeTag(t *testing.T) {
+	if Tag, err := normalizeTag("  Sprint-42_Q3 "); err != nil || Tag != "sprint-42_q3" {
+		t.Fatalf(`normalizeTag("  Sprint-42_Q3 ") = %s, %v, want sprint-42_q3, nil`, Tag, err)
+	}
+	for _, Tag := range []string{"", " ", "-sprint", "two words", "emoji😀", "semi;colon", strings.Repeat("a", maxTagLength+1)} {
+		if _, err := normalizeTag(Tag); err == nil {
+			t.Fatalf(`normalizeTag(%q) = nil error, want INVALID_TAG`, Tag)
+		}
+	}
+}
+
+// TestEscapeLikePattern calls escapeLikePattern making sure LIKE wildcards
+// and the escape character are matched literally
+func TestEscapeLikePattern(t *testing.T) {
+	if Pattern := escapeLikePattern(`50%_off\`); Pattern != `50\%\_off\\` {
+		t.Fatalf(`escapeLikePattern("50%%_off\\") = %s, want 50\%%\_off\\`, Pattern)
+	}
+	if Pattern := escapeLikePattern("jane.doe@example.com"); Pattern != "jane.doe@example.com" {
+		t.Fatalf(`escapeLikePattern("jane.doe@example.com") = %s, want unchanged`, Pattern)
+	}
+}
+
+// TestResolvePointScale calls ResolvePointScale making sure preset scales get their values,
+// custom values are trimmed, and unknown scales and oversized or duplicate custom values are rejected
+func TestResolvePointScale(t *testing.T) {
+	if Values, Scale, err := ResolvePointScale("tshirt", []string{"1"}); err != nil || Scale != "tshirt" || Values[0] != "XS" {
+		t.Fatalf(`ResolvePointScale("tshirt") = %v, %s, %v, want the t-shirt sizes`, Values, Scale, err)
+	}
+	if Values, Scale, err := ResolvePointScale("", []string{" 1 ", "2", "?"}); err != nil || Scale != PointScaleCustom || Values[0] != "1" {
+		t.Fatalf(`ResolvePointScale("", [" 1 ", "2", "?"]) = %v, %s, %v, want [1 2 ?], custom, nil`, Values, Scale, err)
+	}
+	if _, _, err := ResolvePointScale("dice", nil); err == nil || err.Error() != "INVALID_POINT_SCALE" {
+		t.Fatalf(`ResolvePointScale("dice") = %v, want INVALID_POINT_SCALE`, err)
+	}
+	TooMany := make([]string, maxCustomPointValues+1)
+	for i := range TooMany {
+		TooMany[i] = strconv.Itoa(i)
+	}
+	for _, Values := range [][]string{nil, {"1", "1"}, {"1", ""}, {strings.Repeat("9", maxPointValueLength+1)}, TooMany} {
+		if _, _, err := ResolvePointScale(PointScaleCustom, Values); err == nil {
+			t.Fatalf(`ResolvePointScale("custom", %v) = nil error, want INVALID_POINT_VALUES`, Values)
+		}
+	}
+}
+
+// TestValidateStoryboardPointValues calls validateStoryboardPointValues with the default values
+// and values that are empty, duplicated, negative or above the max point value
+func TestValidateStoryboardPointValues(t *testing.T) {
+	if err := validateStoryboardPointValues([]int{0, 1, 2, 3, 5, 8, 13, 20, 40, 100}); err != nil {
+		t.Fatalf(`validateStoryboardPointValues = %v for the default values, want nil`, err)
+	}
+	for _, Values := range [][]int{nil, {1, 1}, {-1}, {maxStoryPointValue + 1}} {
+		if err := validateStoryboardPointValues(Values); err == nil {
+			t.Fatalf(`validateStoryboardPointValues(%v) = nil error, want INVALID_POINT_VALUES`, Values)
+		}
+	}
+}
+
+// TestComputePlanVoteResults calls computePlanVoteResults making sure unsure and abstain votes
+// are counted separately and left out of the average and median
+func TestComputePlanVoteResults(t *testing.T) {
+	Votes := []*model.Vote{{VoteValue: "1"}, {VoteValue: "3"}, {VoteValue: "8"}, {VoteValue: "13"}, {VoteValue: "?"}, {VoteValue: "☕"}}
+	Results := computePlanVoteResults(Votes, "☕")
+	if Results.EstimateCount != 4 || Results.UnsureCount != 1 || Results.AbstainCount != 1 {
+		t.Fatalf(`computePlanVoteResults counts = %+v, want 4 estimates, 1 unsure, 1 abstain`, Results)
+	}
+	if Results.Average == nil || *Results.Average != 6.25 || Results.Median == nil || *Results.Median != 5.5 {
+		t.Fatalf(`computePlanVoteResults = %v average, %v median, want 6.25, 5.5`, Results.Average, Results.Median)
+	}
+
+	if Results := computePlanVoteResults([]*model.Vote{{VoteValue: "☕"}, {VoteValue: "?"}}, "☕"); Results.Average != nil || Results.Median != nil {
+		t.Fatalf(`computePlanVoteResults with only abstain and unsure votes = %+v, want no average or median`, Results)
+	}
+}
+
+// TestComputePlanVoteResultsSpread calls computePlanVoteResults making sure numeric estimates get their
+// mode, spread and consensus while non numeric scales only get the mode
+func TestComputePlanVoteResultsSpread(t *testing.T) {
+	Results := computePlanVoteResults([]*model.Vote{{VoteValue: "8"}, {VoteValue: "3"}, {VoteValue: "8"}, {VoteValue: "3"}, {VoteValue: "13"}}, "")
+	if strings.Join(Results.Mode, ",") != "3,8" || *Results.Min != 3 || *Results.Max != 13 || Results.Consensus {
+		t.Fatalf(`computePlanVoteResults = mode %v, min %v, max %v, consensus %t, want 3,8, 3, 13, false`, Results.Mode, *Results.Min, *Results.Max, Results.Consensus)
+	}
+
+	if Results := computePlanVoteResults([]*model.Vote{{VoteValue: "1/2"}, {VoteValue: "0.5"}, {VoteValue: "?"}}, ""); !Results.Consensus {
+		t.Fatalf(`computePlanVoteResults consensus = false for 1/2 and 0.5, want true`)
+	}
+
+	Results = computePlanVoteResults([]*model.Vote{{VoteValue: "M"}, {VoteValue: "L"}, {VoteValue: "M"}}, "")
+	if strings.Join(Results.Mode, ",") != "M" || Results.Average != nil || Results.Min != nil || Results.Consensus {
+		t.Fatalf(`computePlanVoteResults for t-shirt sizes = %+v, want only the mode M`, Results)
+	}
+}
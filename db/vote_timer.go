@@ -0,0 +1,140 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"go.uber.org/zap"
+)
+
+// maxVoteTimerSeconds the longest a voting timer can run for
+const maxVoteTimerSeconds = 3600
+
+// voteTimerRemainingSQL the whole seconds left on the plans (p) voting timer, rounded up and never negative,
+// computed against the database clock and frozen at the battles (b) paused date while paused
+const voteTimerRemainingSQL = `CEIL(GREATEST(EXTRACT(EPOCH FROM p.vote_timer_end - CASE WHEN b.paused THEN COALESCE(b.paused_date, NOW()) ELSE NOW() END), 0))::INTEGER`
+
+// validVoteTimerSeconds whether the timer duration is positive and within the max
+func validVoteTimerSeconds(Seconds int) bool {
+	return Seconds > 0 && Seconds <= maxVoteTimerSeconds
+}
+
+// SetBattleVoteTimerDefault sets the duration in seconds voting timers run for when started without one, 0 for none
+func (d *Database) SetBattleVoteTimerDefault(BattleID string, Seconds int) error {
+	if Seconds != 0 && !validVoteTimerSeconds(Seconds) {
+		return errors.New("INVALID_VOTE_TIMER")
+	}
+
+	if _, err := d.db.Exec(
+		`UPDATE battles SET vote_timer_default = $2, updated_date = NOW() WHERE id = $1;`,
+		BattleID,
+		Seconds,
+	); err != nil {
+		d.logger.Error("update battle vote_timer_default error", zap.Error(err))
+		return errors.New("unable to set battle vote timer default")
+	}
+
+	return nil
+}
+
+// StartPlanVoteTimer starts the voting timer of the battles active plan, using the battles
+// default duration when Seconds is 0, replacing any timer already running
+func (d *Database) StartPlanVoteTimer(BattleID string, Seconds int) (*model.VoteTimer, error) {
+	if Seconds == 0 {
+		if err := d.db.QueryRow(
+			`SELECT vote_timer_default FROM battles WHERE id = $1;`,
+			BattleID,
+		).Scan(&Seconds); err != nil {
+			d.logger.Error("get battle vote_timer_default error", zap.Error(err))
+			return nil, errors.New("BATTLE_NOT_FOUND")
+		}
+	}
+	if !validVoteTimerSeconds(Seconds) {
+		return nil, errors.New("INVALID_VOTE_TIMER")
+	}
+
+	return d.updatePlanVoteTimer(BattleID, `NOW() + make_interval(secs => $2)`, Seconds, false)
+}
+
+// ExtendPlanVoteTimer adds the seconds to the running voting timer of the battles active plan
+func (d *Database) ExtendPlanVoteTimer(BattleID string, Seconds int) (*model.VoteTimer, error) {
+	if !validVoteTimerSeconds(Seconds) {
+		return nil, errors.New("INVALID_VOTE_TIMER")
+	}
+
+	return d.updatePlanVoteTimer(BattleID, `p.vote_timer_end + make_interval(secs => $2)`, Seconds, true)
+}
+
+// updatePlanVoteTimer sets the vote timer end of the battles active plan while voting is open,
+// only changing a running timer when Running is true
+func (d *Database) updatePlanVoteTimer(BattleID string, End string, Seconds int, Running bool) (*model.VoteTimer, error) {
+	var t model.VoteTimer
+
+	if err := d.db.QueryRow(
+		`UPDATE plans p SET vote_timer_end = `+End+`
+		FROM battles b
+		WHERE b.id = $1 AND p.id = b.active_plan_id AND b.voting_locked = false AND b.paused = false
+			AND ($3 = false OR p.vote_timer_end IS NOT NULL)
+		RETURNING p.id, p.vote_timer_end, `+voteTimerRemainingSQL+`;`,
+		BattleID,
+		Seconds,
+		Running,
+	).Scan(&t.PlanId, &t.EndTime, &t.Remaining); err != nil {
+		if err != sql.ErrNoRows {
+			d.logger.Error("update plan vote_timer_end error", zap.Error(err))
+		}
+		return nil, errors.New("VOTE_TIMER_NOT_ACTIVE")
+	}
+
+	return &t, nil
+}
+
+// CancelPlanVoteTimer stops the voting timer of the battles active plan, returning the plans ID
+func (d *Database) CancelPlanVoteTimer(BattleID string) (string, error) {
+	var PlanID string
+
+	if err := d.db.QueryRow(
+		`UPDATE plans p SET vote_timer_end = NULL
+		FROM battles b
+		WHERE b.id = $1 AND p.id = b.active_plan_id AND p.vote_timer_end IS NOT NULL
+		RETURNING p.id;`,
+		BattleID,
+	).Scan(&PlanID); err != nil {
+		return "", errors.New("VOTE_TIMER_NOT_ACTIVE")
+	}
+
+	return PlanID, nil
+}
+
+// GetBattleVoteTimer gets the running voting timer of the battles active plan, nil when there isn't one,
+// while the battle is paused the remaining time is as it was when paused
+func (d *Database) GetBattleVoteTimer(BattleID string) (*model.VoteTimer, error) {
+	var t model.VoteTimer
+
+	err := d.db.QueryRow(
+		`SELECT p.id, p.vote_timer_end, b.paused, `+voteTimerRemainingSQL+`
+		FROM battles b
+		JOIN plans p ON p.id = b.active_plan_id
+		WHERE b.id = $1 AND b.voting_locked = false AND p.vote_timer_end IS NOT NULL;`,
+		BattleID,
+	).Scan(&t.PlanId, &t.EndTime, &t.Paused, &t.Remaining)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		d.logger.Error("get battle vote timer query error", zap.Error(err))
+		return nil, errors.New("unable to get battle vote timer")
+	}
+	return &t, nil
+}
+
+// clearPlanVoteTimer stops the plans voting timer once its voting starts over or ends
+func (d *Database) clearPlanVoteTimer(PlanID string) {
+	if _, err := d.db.Exec(
+		`UPDATE plans SET vote_timer_end = NULL WHERE id = $1 AND vote_timer_end IS NOT NULL;`,
+		PlanID,
+	); err != nil {
+		d.logger.Error("clear plan vote_timer_end error", zap.Error(err))
+	}
+}
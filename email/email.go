@@ -2,88 +2,95 @@
 package email
 
 import (
-	"crypto/tls"
-	"fmt"
+	"html/template"
 	"net/mail"
-	"net/smtp"
 	"strconv"
 	"time"
 
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
 	"github.com/matcornic/hermes/v2"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
 )
 
-// smtpServer data to smtp server
-type smtpServer struct {
-	host string
-	port string
-}
-
-// Address URI to smtp server
-func (s *smtpServer) Address() string {
-	return s.host + ":" + s.port
-}
-
-var smtpServerConfig = smtpServer{}
-var tlsConfig = &tls.Config{}
-var smtpFrom = mail.Address{}
-var smtpAuth smtp.Auth
-
 // Config contains all the mail server values
 type Config struct {
-	AppURL       string
-	SenderName   string
-	smtpHost     string
-	smtpPort     string
-	smtpSecure   bool
-	smtpIdentity string
-	smtpUser     string
-	smtpPass     string
-	smtpSender   string
+	AppURL     string
+	SenderName string
+	// Locale used to select email template overrides e.g. welcome.es.html
+	Locale string
+	// TemplateDir directory of email template overrides, embedded templates are used when empty
+	TemplateDir string
+	// Provider the transport emails are sent with, smtp (default), sendgrid, or ses
+	Provider   string
+	smtpSender string
+	// ResetTokenTTL minutes a password reset link is valid for
+	ResetTokenTTL int
+	// VerifyTokenTTL minutes an email verification or change link is valid for
+	VerifyTokenTTL int
+}
+
+// DeferredEmailStore persists emails deferred for quiet hours so they survive a restart
+type DeferredEmailStore interface {
+	DeferEmail(UserName string, UserEmail string, Subject string, Body string, SendAt time.Time) error
+	ClaimDueEmails(Limit int) ([]*model.DeferredEmail, error)
 }
 
 // Email contains all the methods to send application emails
 type Email struct {
-	config *Config
-	logger *zap.Logger
+	config    *Config
+	templates map[string]*template.Template
+	logger    *zap.Logger
+	// sender delivers emails with the configured provider
+	sender EmailSender
+	from   mail.Address
+	// deferred holds non-urgent emails until the recipients quiet hours have passed
+	deferred DeferredEmailStore
 }
 
 // New creates a new instance of Email
-func New(AppDomain string, PathPrefix string, logger *zap.Logger) *Email {
+func New(AppDomain string, PathPrefix string, ResetTokenTTL int, VerifyTokenTTL int, Deferred DeferredEmailStore, logger *zap.Logger) *Email {
 	var AppURL string = "https://" + AppDomain + PathPrefix + "/"
 	var m = &Email{
 		// read environment variables and sets up mailserver configuration values
 		config: &Config{
-			AppURL:       AppURL,
-			SenderName:   "Thunderdome",
-			smtpHost:     viper.GetString("smtp.host"),
-			smtpPort:     viper.GetString("smtp.port"),
-			smtpSecure:   viper.GetBool("smtp.secure"),
-			smtpIdentity: viper.GetString("smtp.identity"),
-			smtpUser:     viper.GetString("smtp.user"),
-			smtpPass:     viper.GetString("smtp.pass"),
-			smtpSender:   viper.GetString("smtp.sender"),
+			AppURL:         AppURL,
+			SenderName:     "Thunderdome",
+			Locale:         viper.GetString("config.default_locale"),
+			TemplateDir:    viper.GetString("smtp.template_dir"),
+			Provider:       viper.GetString("email.provider"),
+			smtpSender:     viper.GetString("smtp.sender"),
+			ResetTokenTTL:  ResetTokenTTL,
+			VerifyTokenTTL: VerifyTokenTTL,
 		},
-		logger: logger,
+		templates: make(map[string]*template.Template),
+		logger:    logger,
+		deferred:  Deferred,
 	}
 
-	// smtp server configuration.
-	smtpServerConfig = smtpServer{host: m.config.smtpHost, port: m.config.smtpPort}
-
-	// smtp sender info
-	smtpFrom = mail.Address{
-		Name:    m.config.SenderName,
-		Address: m.config.smtpSender,
+	// email template overrides are validated at startup so a bad template can't break sending later
+	if m.config.TemplateDir != "" {
+		templates, err := loadTemplateOverrides(m.config.TemplateDir)
+		if err != nil {
+			logger.Fatal("error loading email template overrides", zap.Error(err))
+		}
+		for name := range templates {
+			logger.Info("loaded email template override", zap.String("template", name))
+		}
+		m.templates = templates
 	}
 
-	// TLS config
-	tlsConfig = &tls.Config{
-		InsecureSkipVerify: !m.config.smtpSecure,
-		ServerName:         m.config.smtpHost,
+	sender, err := newSender(m.config.Provider, time.Duration(viper.GetInt("email.timeout"))*time.Second)
+	if err != nil {
+		logger.Fatal("error configuring email provider", zap.Error(err))
 	}
+	m.sender = sender
 
-	smtpAuth = smtp.PlainAuth(m.config.smtpIdentity, m.config.smtpUser, m.config.smtpPass, m.config.smtpHost)
+	// sender info
+	m.from = mail.Address{
+		Name:    m.config.SenderName,
+		Address: m.config.smtpSender,
+	}
 
 	return m
 }
@@ -114,75 +121,17 @@ func (m *Email) generateBody(Body hermes.Body) (emailBody string, generateErr er
 	return emailBody, nil
 }
 
-// Send - utility function to send emails
+// Send - utility function to send emails with the configured provider
 func (m *Email) Send(UserName string, UserEmail string, Subject string, Body string) error {
 	to := mail.Address{
 		Name:    UserName,
 		Address: UserEmail,
 	}
 
-	// Setup headers
-	headers := make(map[string]string)
-	headers["From"] = smtpFrom.String()
-	headers["To"] = to.String()
-	headers["Subject"] = Subject
-	headers["MIME-version"] = "1.0"
-	headers["Content-Type"] = "text/html"
-
-	// Setup message
-	message := ""
-	for k, v := range headers {
-		message += fmt.Sprintf("%s: %s\r\n", k, v)
-	}
-	message += "\r\n" + Body
-
-	c, err := smtp.Dial(smtpServerConfig.Address())
-	if err != nil {
-		m.logger.Error("Error dialing SMTP", zap.Error(err))
-		return err
-	}
-
-	c.StartTLS(tlsConfig)
-
-	// Auth
-	if m.config.smtpSecure {
-		if err = c.Auth(smtpAuth); err != nil {
-			m.logger.Error("Error authenticating SMTP", zap.Error(err))
-			return err
-		}
-	}
-
-	// To && From
-	if err = c.Mail(smtpFrom.Address); err != nil {
-		m.logger.Error("Error setting SMTP from", zap.Error(err))
+	if err := m.sender.Send(m.from, to, Subject, Body); err != nil {
+		m.logger.Error("Error sending email", zap.String("provider", m.config.Provider), zap.Error(err))
 		return err
 	}
 
-	if err = c.Rcpt(to.Address); err != nil {
-		m.logger.Error("Error setting SMTP to", zap.Error(err))
-		return err
-	}
-
-	// Data
-	w, err := c.Data()
-	if err != nil {
-		m.logger.Error("Error setting SMTP data", zap.Error(err))
-		return err
-	}
-
-	_, err = w.Write([]byte(message))
-	if err != nil {
-		m.logger.Error("Error sending email", zap.Error(err))
-		return err
-	}
-
-	err = w.Close()
-	if err != nil {
-		m.logger.Error("Error closing SMTP", zap.Error(err))
-		return err
-	}
-
-	c.Quit()
-
 	return nil
 }
@@ -0,0 +1,101 @@
+package email
+
+import (
+	"time"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"go.uber.org/zap"
+)
+
+// quietHoursEnd returns when the users quiet hours window ends if now falls inside it
+func quietHoursEnd(Quiet *model.QuietHours, now time.Time) (time.Time, bool) {
+	if Quiet == nil || Quiet.Start == "" || Quiet.End == "" || Quiet.Start == Quiet.End {
+		return time.Time{}, false
+	}
+
+	loc := time.UTC
+	if Quiet.Timezone != "" {
+		l, err := time.LoadLocation(Quiet.Timezone)
+		if err != nil {
+			return time.Time{}, false
+		}
+		loc = l
+	}
+	start, err := time.Parse("15:04", Quiet.Start)
+	if err != nil {
+		return time.Time{}, false
+	}
+	end, err := time.Parse("15:04", Quiet.End)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	local := now.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+	startMinute := start.Hour()*60 + start.Minute()
+	endMinute := end.Hour()*60 + end.Minute()
+
+	var inWindow bool
+	if startMinute < endMinute {
+		inWindow = minute >= startMinute && minute < endMinute
+	} else {
+		// window spans midnight e.g. 22:00 - 07:00
+		inWindow = minute >= startMinute || minute < endMinute
+	}
+	if !inWindow {
+		return time.Time{}, false
+	}
+
+	windowEnd := time.Date(local.Year(), local.Month(), local.Day(), end.Hour(), end.Minute(), 0, 0, loc)
+	if !windowEnd.After(local) {
+		windowEnd = windowEnd.AddDate(0, 0, 1)
+	}
+
+	return windowEnd, true
+}
+
+// sendNonUrgent sends a non-urgent email, deferring it until the users quiet hours have passed
+func (m *Email) sendNonUrgent(Quiet *model.QuietHours, UserName string, UserEmail string, Subject string, Body string) error {
+	sendAt, deferred := quietHoursEnd(Quiet, time.Now())
+	if !deferred {
+		return m.Send(UserName, UserEmail, Subject, Body)
+	}
+
+	m.logger.Info("deferring email until quiet hours end",
+		zap.String("subject", Subject),
+		zap.Time("send_at", sendAt),
+	)
+
+	return m.deferred.DeferEmail(UserName, UserEmail, Subject, Body, sendAt)
+}
+
+// deferredEmailBatchSize the max deferred emails sent each sweep
+const deferredEmailBatchSize = 100
+
+// sendDueEmails sends the deferred emails whose quiet hours have passed, a failed send is logged and not retried
+func (m *Email) sendDueEmails() {
+	for {
+		Emails, err := m.deferred.ClaimDueEmails(deferredEmailBatchSize)
+		if err != nil {
+			return
+		}
+		for _, e := range Emails {
+			if err := m.Send(e.UserName, e.UserEmail, e.Subject, e.Body); err != nil {
+				m.logger.Error("Error sending deferred email", zap.String("email_id", e.Id), zap.Error(err))
+			}
+		}
+		if len(Emails) < deferredEmailBatchSize {
+			return
+		}
+	}
+}
+
+// DeferredEmailSweeper periodically sends the deferred emails whose quiet hours have passed, intended to be run as a goroutine
+func (m *Email) DeferredEmailSweeper(Interval time.Duration) {
+	ticker := time.NewTicker(Interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		m.sendDueEmails()
+	}
+}
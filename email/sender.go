@@ -0,0 +1,79 @@
+package email
+
+import (
+	"errors"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/httpclient"
+	"github.com/spf13/viper"
+)
+
+// EmailSender delivers an email through a mail transport
+type EmailSender interface {
+	Send(From mail.Address, To mail.Address, Subject string, Body string) error
+}
+
+// newSender creates the sender for the email.provider, erroring when the provider is unknown
+// or its credentials are missing so misconfiguration is caught at startup rather than on send
+func newSender(Provider string, Timeout time.Duration) (EmailSender, error) {
+	switch strings.ToLower(Provider) {
+	case "", "smtp":
+		return newSMTPSender(
+			viper.GetString("smtp.host"),
+			viper.GetString("smtp.port"),
+			viper.GetBool("smtp.secure"),
+			viper.GetString("smtp.identity"),
+			viper.GetString("smtp.user"),
+			viper.GetString("smtp.pass"),
+			Timeout,
+		), nil
+	case "sendgrid":
+		APIKey := viper.GetString("email.sendgrid_api_key")
+		if APIKey == "" {
+			return nil, errors.New("email.sendgrid_api_key is required for the sendgrid email provider")
+		}
+		client, err := newHTTPClient(Timeout)
+		if err != nil {
+			return nil, err
+		}
+		return &sendgridSender{
+			endpoint: sendgridEndpoint,
+			apiKey:   APIKey,
+			client:   client,
+		}, nil
+	case "ses":
+		Region := viper.GetString("email.ses_region")
+		AccessKeyID := viper.GetString("email.ses_access_key_id")
+		SecretAccessKey := viper.GetString("email.ses_secret_access_key")
+		if Region == "" || AccessKeyID == "" || SecretAccessKey == "" {
+			return nil, errors.New("email.ses_region, email.ses_access_key_id and email.ses_secret_access_key are required for the ses email provider")
+		}
+		client, err := newHTTPClient(Timeout)
+		if err != nil {
+			return nil, err
+		}
+		return &sesSender{
+			endpoint:        "https://email." + Region + ".amazonaws.com" + sesSendPath,
+			region:          Region,
+			accessKeyID:     AccessKeyID,
+			secretAccessKey: SecretAccessKey,
+			client:          client,
+		}, nil
+	default:
+		return nil, errors.New("unsupported email provider " + Provider)
+	}
+}
+
+// newHTTPClient creates the client email provider API requests are sent with,
+// using the http_client proxy and TLS settings shared with outbound integration requests
+func newHTTPClient(Timeout time.Duration) (*http.Client, error) {
+	return httpclient.New(httpclient.Config{
+		Proxy:                 viper.GetString("http_client.proxy"),
+		Timeout:               Timeout,
+		CACertFile:            viper.GetString("http_client.ca_cert_file"),
+		TLSInsecureSkipVerify: viper.GetBool("http_client.tls_insecure_skip_verify"),
+	})
+}
@@ -0,0 +1,78 @@
+package email
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/mail"
+)
+
+// sendgridEndpoint the SendGrid v3 mail send API
+const sendgridEndpoint = "https://api.sendgrid.com/v3/mail/send"
+
+// sendgridSender sends emails with the SendGrid API
+type sendgridSender struct {
+	endpoint string
+	apiKey   string
+	client   *http.Client
+}
+
+// sendgridAddress an email address in the SendGrid API
+type sendgridAddress struct {
+	Email string `json:"email"`
+	Name  string `json:"name,omitempty"`
+}
+
+// Send delivers the email with the SendGrid API
+func (s *sendgridSender) Send(From mail.Address, To mail.Address, Subject string, Body string) error {
+	type personalization struct {
+		To []sendgridAddress `json:"to"`
+	}
+	type content struct {
+		Type  string `json:"type"`
+		Value string `json:"value"`
+	}
+	payload, err := json.Marshal(struct {
+		Personalizations []personalization `json:"personalizations"`
+		From             sendgridAddress   `json:"from"`
+		Subject          string            `json:"subject"`
+		Content          []content         `json:"content"`
+	}{
+		Personalizations: []personalization{{To: []sendgridAddress{{Email: To.Address, Name: To.Name}}}},
+		From:             sendgridAddress{Email: From.Address, Name: From.Name},
+		Subject:          Subject,
+		Content:          []content{{Type: "text/html", Value: Body}},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("sending email with sendgrid: %w", err)
+	}
+	defer resp.Body.Close()
+
+	return providerResponseError("sendgrid", resp)
+}
+
+// providerResponseError gets the error of an unsuccessful email provider API response including its message
+func providerResponseError(Provider string, resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	Message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	return fmt.Errorf("sending email with %s: status %d: %s", Provider, resp.StatusCode, bytes.TrimSpace(Message))
+}
@@ -0,0 +1,76 @@
+package email
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"time"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/sigv4"
+)
+
+// sesSendPath the Amazon SES v2 send email API path
+const sesSendPath = "/v2/email/outbound-emails"
+
+// sesSender sends emails with the Amazon SES v2 API, requests are signed with AWS Signature Version 4
+type sesSender struct {
+	endpoint        string
+	region          string
+	accessKeyID     string
+	secretAccessKey string
+	client          *http.Client
+}
+
+// Send delivers the email with the SES API
+func (s *sesSender) Send(From mail.Address, To mail.Address, Subject string, Body string) error {
+	type data struct {
+		Data    string `json:"Data"`
+		Charset string `json:"Charset"`
+	}
+	var message struct {
+		FromEmailAddress string `json:"FromEmailAddress"`
+		Destination      struct {
+			ToAddresses []string `json:"ToAddresses"`
+		} `json:"Destination"`
+		Content struct {
+			Simple struct {
+				Subject data `json:"Subject"`
+				Body    struct {
+					Html data `json:"Html"`
+				} `json:"Body"`
+			} `json:"Simple"`
+		} `json:"Content"`
+	}
+	message.FromEmailAddress = From.String()
+	message.Destination.ToAddresses = []string{To.String()}
+	message.Content.Simple.Subject = data{Data: Subject, Charset: "UTF-8"}
+	message.Content.Simple.Body.Html = data{Data: Body, Charset: "UTF-8"}
+
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	s.sign(req, payload, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("sending email with ses: %w", err)
+	}
+	defer resp.Body.Close()
+
+	return providerResponseError("ses", resp)
+}
+
+// sign adds the AWS Signature Version 4 authorization of the request for the ses service
+func (s *sesSender) sign(req *http.Request, Payload []byte, Now time.Time) {
+	req.Header.Set("X-Amz-Content-Sha256", sigv4.PayloadHash(Payload))
+	sigv4.Sign(req, Payload, Now, s.region, "ses", s.accessKeyID, s.secretAccessKey)
+}
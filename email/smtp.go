@@ -0,0 +1,104 @@
+package email
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"time"
+)
+
+// smtpSender sends emails through an SMTP server
+type smtpSender struct {
+	host      string
+	port      string
+	secure    bool
+	auth      smtp.Auth
+	tlsConfig *tls.Config
+	timeout   time.Duration
+}
+
+// newSMTPSender creates a sender for the SMTP server, authenticating when secure
+func newSMTPSender(Host string, Port string, Secure bool, Identity string, User string, Pass string, Timeout time.Duration) *smtpSender {
+	return &smtpSender{
+		host:   Host,
+		port:   Port,
+		secure: Secure,
+		auth:   smtp.PlainAuth(Identity, User, Pass, Host),
+		tlsConfig: &tls.Config{
+			InsecureSkipVerify: !Secure,
+			ServerName:         Host,
+		},
+		timeout: Timeout,
+	}
+}
+
+// Send delivers the email through the SMTP server, the whole exchange must finish within the timeout
+func (s *smtpSender) Send(From mail.Address, To mail.Address, Subject string, Body string) error {
+	// Setup headers
+	headers := make(map[string]string)
+	headers["From"] = From.String()
+	headers["To"] = To.String()
+	headers["Subject"] = Subject
+	headers["MIME-version"] = "1.0"
+	headers["Content-Type"] = "text/html"
+
+	// Setup message
+	message := ""
+	for k, v := range headers {
+		message += fmt.Sprintf("%s: %s\r\n", k, v)
+	}
+	message += "\r\n" + Body
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(s.host, s.port), s.timeout)
+	if err != nil {
+		return fmt.Errorf("dialing smtp: %w", err)
+	}
+	if s.timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(s.timeout))
+	}
+
+	c, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("dialing smtp: %w", err)
+	}
+	defer c.Close()
+
+	c.StartTLS(s.tlsConfig)
+
+	// Auth
+	if s.secure {
+		if err = c.Auth(s.auth); err != nil {
+			return fmt.Errorf("authenticating smtp: %w", err)
+		}
+	}
+
+	// To && From
+	if err = c.Mail(From.Address); err != nil {
+		return fmt.Errorf("setting smtp from: %w", err)
+	}
+
+	if err = c.Rcpt(To.Address); err != nil {
+		return fmt.Errorf("setting smtp to: %w", err)
+	}
+
+	// Data
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("setting smtp data: %w", err)
+	}
+
+	if _, err = w.Write([]byte(message)); err != nil {
+		return fmt.Errorf("sending email: %w", err)
+	}
+
+	if err = w.Close(); err != nil {
+		return fmt.Errorf("closing smtp: %w", err)
+	}
+
+	c.Quit()
+
+	return nil
+}
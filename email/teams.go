@@ -0,0 +1,165 @@
+package email
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
+	"github.com/matcornic/hermes/v2"
+	"go.uber.org/zap"
+)
+
+// SendTeamInvite sends an invite email to a user (not yet registered) added to a team
+func (m *Email) SendTeamInvite(TeamName string, UserEmail string) error {
+	emailBody, err := m.renderBody(
+		"team_invite",
+		templateData{Name: UserEmail, Email: UserEmail, TeamName: TeamName, Link: m.config.AppURL + "register"},
+		hermes.Body{
+			Name: UserEmail,
+			Intros: []string{
+				"You've been invited to join the team " + TeamName + " in Thunderdome.",
+			},
+			Actions: []hermes.Action{
+				{
+					Instructions: "Register with this email and verify it to automatically join the team.",
+					Button: hermes.Button{
+						Color: "#22BC66",
+						Text:  "Register",
+						Link:  m.config.AppURL + "register",
+					},
+				},
+				{
+					Instructions: "Need help, or have questions? Visit our Github page",
+					Button: hermes.Button{
+						Text: "Github Repo",
+						Link: "https://github.com/StevenWeathers/thunderdome-planning-poker/",
+					},
+				},
+			},
+		},
+	)
+	if err != nil {
+		m.logger.Error("Error Generating Team Invite Email HTML", zap.Error(err))
+		return err
+	}
+
+	sendErr := m.Send(
+		UserEmail,
+		UserEmail,
+		"You've been invited to a Thunderdome team",
+		emailBody,
+	)
+	if sendErr != nil {
+		m.logger.Error("Error sending Team Invite Email", zap.Error(sendErr))
+		return sendErr
+	}
+
+	return nil
+}
+
+// SendTeamAdminDemotionNotice notifies an inactive team admin they will soon be demoted to member
+func (m *Email) SendTeamAdminDemotionNotice(UserName string, UserEmail string, TeamName string, NoticeDays int) error {
+	emailBody, err := m.renderBody(
+		"team_admin_demotion_notice",
+		templateData{Name: UserName, Email: UserEmail, TeamName: TeamName, Link: m.config.AppURL + "login"},
+		hermes.Body{
+			Name: UserName,
+			Intros: []string{
+				"You haven't been active in Thunderdome for a while, your admin role for the team " + TeamName +
+					" will be changed to member in " + strconv.Itoa(NoticeDays) + " days.",
+			},
+			Actions: []hermes.Action{
+				{
+					Instructions: "Login to keep your team admin role.",
+					Button: hermes.Button{
+						Color: "#22BC66",
+						Text:  "Login",
+						Link:  m.config.AppURL + "login",
+					},
+				},
+			},
+		},
+	)
+	if err != nil {
+		m.logger.Error("Error Generating Team Admin Demotion Notice Email HTML", zap.Error(err))
+		return err
+	}
+
+	sendErr := m.Send(
+		UserName,
+		UserEmail,
+		"Your Thunderdome team admin role is expiring",
+		emailBody,
+	)
+	if sendErr != nil {
+		m.logger.Error("Error sending Team Admin Demotion Notice Email", zap.Error(sendErr))
+		return sendErr
+	}
+
+	return nil
+}
+
+// SendTeamDigest sends the teams weekly summary of completed battles and points estimated,
+// IncludeBattles and IncludePoints control which sections are included
+func (m *Email) SendTeamDigest(UserName string, UserEmail string, Quiet *model.QuietHours, Digest *model.TeamDigest, IncludeBattles bool, IncludePoints bool) error {
+	var Dictionary []hermes.Entry
+	if IncludeBattles {
+		Completed := "None"
+		if len(Digest.CompletedBattles) > 0 {
+			Completed = strings.Join(Digest.CompletedBattles, ", ")
+		}
+		Dictionary = append(Dictionary,
+			hermes.Entry{Key: "Battles completed", Value: strconv.Itoa(len(Digest.CompletedBattles))},
+			hermes.Entry{Key: "Completed", Value: Completed},
+		)
+	}
+	if IncludePoints {
+		Dictionary = append(Dictionary,
+			hermes.Entry{Key: "Stories estimated", Value: strconv.Itoa(Digest.PlansEstimated)},
+			hermes.Entry{Key: "Points estimated", Value: strconv.FormatFloat(Digest.PointsEstimated, 'f', -1, 64)},
+		)
+	}
+
+	emailBody, err := m.renderBody(
+		"team_digest",
+		templateData{Name: UserName, Email: UserEmail, TeamName: Digest.TeamName, Link: m.config.AppURL + "team/" + Digest.TeamId},
+		hermes.Body{
+			Name: UserName,
+			Intros: []string{
+				"Here's what the team " + Digest.TeamName + " estimated in Thunderdome this week.",
+			},
+			Dictionary: Dictionary,
+			Actions: []hermes.Action{
+				{
+					Instructions: "View the team in Thunderdome.",
+					Button: hermes.Button{
+						Color: "#22BC66",
+						Text:  "View Team",
+						Link:  m.config.AppURL + "team/" + Digest.TeamId,
+					},
+				},
+			},
+			Outros: []string{
+				"You can unsubscribe from team digests in your profile notification preferences.",
+			},
+		},
+	)
+	if err != nil {
+		m.logger.Error("Error Generating Team Digest Email HTML", zap.Error(err))
+		return err
+	}
+
+	sendErr := m.sendNonUrgent(
+		Quiet,
+		UserName,
+		UserEmail,
+		"Your Thunderdome team digest for "+Digest.TeamName,
+		emailBody,
+	)
+	if sendErr != nil {
+		m.logger.Error("Error sending Team Digest Email", zap.Error(sendErr))
+		return sendErr
+	}
+
+	return nil
+}
@@ -0,0 +1,92 @@
+package email
+
+import (
+	"bytes"
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/matcornic/hermes/v2"
+	"go.uber.org/zap"
+)
+
+// templateNames the emails that can have their embedded template overridden
+var templateNames = map[string]struct{}{
+	"welcome":                    {},
+	"email_verification":         {},
+	"email_change_confirmation":  {},
+	"forgot_password":            {},
+	"password_reset":             {},
+	"password_update":            {},
+	"delete_confirmation":        {},
+	"email_update":               {},
+	"merged_update":              {},
+	"team_invite":                {},
+	"team_admin_demotion_notice": {},
+	"team_digest":                {},
+}
+
+// templateData the values available to email template overrides
+type templateData struct {
+	Name     string
+	Email    string
+	Link     string
+	TeamName string
+	AppURL   string
+	// RecoveryDays the days a deleted account can still be restored, 0 when it can't
+	RecoveryDays int
+}
+
+// loadTemplateOverrides parses the email template overrides in the directory
+// named {template}.html or {template}.{locale}.html keyed by the filename without extension
+func loadTemplateOverrides(Dir string) (map[string]*template.Template, error) {
+	templates := make(map[string]*template.Template)
+
+	files, err := filepath.Glob(filepath.Join(Dir, "*.html"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		key := strings.TrimSuffix(filepath.Base(file), ".html")
+		name := strings.SplitN(key, ".", 2)[0]
+		if _, ok := templateNames[name]; !ok {
+			continue
+		}
+
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		tmpl, err := template.New(key).Parse(string(content))
+		if err != nil {
+			return nil, err
+		}
+		templates[key] = tmpl
+	}
+
+	return templates, nil
+}
+
+// renderBody renders the email body from the template override for the locale when present
+// falling back to the template override without locale and then the embedded hermes body
+func (m *Email) renderBody(Name string, Data templateData, Body hermes.Body) (string, error) {
+	tmpl, ok := m.templates[Name+"."+m.config.Locale]
+	if !ok {
+		tmpl, ok = m.templates[Name]
+	}
+	if !ok {
+		return m.generateBody(Body)
+	}
+
+	Data.AppURL = m.config.AppURL
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, Data); err != nil {
+		m.logger.Error("Error executing email template override", zap.String("template", tmpl.Name()), zap.Error(err))
+		return m.generateBody(Body)
+	}
+
+	return b.String(), nil
+}
@@ -1,13 +1,18 @@
 package email
 
 import (
+	"fmt"
+
+	"github.com/StevenWeathers/thunderdome-planning-poker/model"
 	"github.com/matcornic/hermes/v2"
 	"go.uber.org/zap"
 )
 
 // SendWelcome sends the welcome email to new registered user
 func (m *Email) SendWelcome(UserName string, UserEmail string, VerifyID string) error {
-	emailBody, err := m.generateBody(
+	emailBody, err := m.renderBody(
+		"welcome",
+		templateData{Name: UserName, Email: UserEmail, Link: m.config.AppURL + "verify-account/" + VerifyID},
 		hermes.Body{
 			Name: UserName,
 			Intros: []string{
@@ -15,7 +20,7 @@ func (m *Email) SendWelcome(UserName string, UserEmail string, VerifyID string)
 			},
 			Actions: []hermes.Action{
 				{
-					Instructions: "Please validate your email, the following link will expire in 24 hours.",
+					Instructions: "Please validate your email, the following link will expire in " + expiresIn(m.config.VerifyTokenTTL) + ".",
 					Button: hermes.Button{
 						Color: "#22BC66",
 						Text:  "Verify Account",
@@ -54,7 +59,9 @@ func (m *Email) SendWelcome(UserName string, UserEmail string, VerifyID string)
 
 // SendEmailVerification sends the verification email to registered user
 func (m *Email) SendEmailVerification(UserName string, UserEmail string, VerifyID string) error {
-	emailBody, err := m.generateBody(
+	emailBody, err := m.renderBody(
+		"email_verification",
+		templateData{Name: UserName, Email: UserEmail, Link: m.config.AppURL + "verify-account/" + VerifyID},
 		hermes.Body{
 			Name: UserName,
 			Intros: []string{
@@ -62,7 +69,7 @@ func (m *Email) SendEmailVerification(UserName string, UserEmail string, VerifyI
 			},
 			Actions: []hermes.Action{
 				{
-					Instructions: "Please validate your email, the following link will expire in 24 hours.",
+					Instructions: "Please validate your email, the following link will expire in " + expiresIn(m.config.VerifyTokenTTL) + ".",
 					Button: hermes.Button{
 						Color: "#22BC66",
 						Text:  "Verify Account",
@@ -98,9 +105,62 @@ func (m *Email) SendEmailVerification(UserName string, UserEmail string, VerifyI
 	return nil
 }
 
+// SendEmailChangeConfirmation sends the confirmation link to the users requested new email
+func (m *Email) SendEmailChangeConfirmation(UserName string, NewEmail string, ChangeID string) error {
+	emailBody, err := m.renderBody(
+		"email_change_confirmation",
+		templateData{Name: UserName, Email: NewEmail, Link: m.config.AppURL + "confirm-email-change/" + ChangeID},
+		hermes.Body{
+			Name: UserName,
+			Intros: []string{
+				"A change of your Thunderdome account email to this address was requested.",
+			},
+			Actions: []hermes.Action{
+				{
+					Instructions: "Please confirm the change, your account email won't change until you do. The following link will expire in " + expiresIn(m.config.VerifyTokenTTL) + ".",
+					Button: hermes.Button{
+						Color: "#22BC66",
+						Text:  "Confirm Email Change",
+						Link:  m.config.AppURL + "confirm-email-change/" + ChangeID,
+					},
+				},
+				{
+					Instructions: "Need help, or have questions? Visit our Github page",
+					Button: hermes.Button{
+						Text: "Github Repo",
+						Link: "https://github.com/StevenWeathers/thunderdome-planning-poker/",
+					},
+				},
+			},
+			Outros: []string{
+				"If you didn't request this change, you can ignore this email.",
+			},
+		},
+	)
+	if err != nil {
+		m.logger.Error("Error Generating Email Change Confirmation Email HTML", zap.Error(err))
+		return err
+	}
+
+	sendErr := m.Send(
+		UserName,
+		NewEmail,
+		"Confirm your new Thunderdome account email",
+		emailBody,
+	)
+	if sendErr != nil {
+		m.logger.Error("Error sending Email Change Confirmation Email", zap.Error(sendErr))
+		return sendErr
+	}
+
+	return nil
+}
+
 // SendForgotPassword Sends a Forgot Password reset email to user
 func (m *Email) SendForgotPassword(UserName string, UserEmail string, ResetID string) error {
-	emailBody, err := m.generateBody(
+	emailBody, err := m.renderBody(
+		"forgot_password",
+		templateData{Name: UserName, Email: UserEmail, Link: m.config.AppURL + "reset-password/" + ResetID},
 		hermes.Body{
 			Name: UserName,
 			Intros: []string{
@@ -108,7 +168,7 @@ func (m *Email) SendForgotPassword(UserName string, UserEmail string, ResetID st
 			},
 			Actions: []hermes.Action{
 				{
-					Instructions: "Reset your password now, the following link will expire within an hour of the original request.",
+					Instructions: "Reset your password now, the following link will expire within " + expiresIn(m.config.ResetTokenTTL) + " of the original request.",
 					Button: hermes.Button{
 						Text: "Reset Password",
 						Link: m.config.AppURL + "reset-password/" + ResetID,
@@ -145,7 +205,9 @@ func (m *Email) SendForgotPassword(UserName string, UserEmail string, ResetID st
 
 // SendPasswordReset Sends a Reset Password confirmation email to user
 func (m *Email) SendPasswordReset(UserName string, UserEmail string) error {
-	emailBody, err := m.generateBody(
+	emailBody, err := m.renderBody(
+		"password_reset",
+		templateData{Name: UserName, Email: UserEmail},
 		hermes.Body{
 			Name: UserName,
 			Intros: []string{
@@ -183,7 +245,9 @@ func (m *Email) SendPasswordReset(UserName string, UserEmail string) error {
 
 // SendPasswordUpdate Sends an Update Password confirmation email to user
 func (m *Email) SendPasswordUpdate(UserName string, UserEmail string) error {
-	emailBody, err := m.generateBody(
+	emailBody, err := m.renderBody(
+		"password_update",
+		templateData{Name: UserName, Email: UserEmail},
 		hermes.Body{
 			Name: UserName,
 			Intros: []string{
@@ -219,14 +283,25 @@ func (m *Email) SendPasswordUpdate(UserName string, UserEmail string) error {
 	return nil
 }
 
-// SendDeleteConfirmation Sends an delete account confirmation email to user
-func (m *Email) SendDeleteConfirmation(UserName string, UserEmail string) error {
-	emailBody, err := m.generateBody(
+// SendDeleteConfirmation Sends an delete account confirmation email to user,
+// mentioning how many days they have to ask an admin to restore it when it can be
+func (m *Email) SendDeleteConfirmation(UserName string, UserEmail string, RecoveryDays int) error {
+	Intros := []string{
+		"Your Thunderdome account was successfully been deleted.",
+	}
+	if RecoveryDays > 0 {
+		Intros = append(Intros, fmt.Sprintf(
+			"Your account will be permanently removed after %d days, if this was a mistake contact an administrator before then to restore it.",
+			RecoveryDays,
+		))
+	}
+
+	emailBody, err := m.renderBody(
+		"delete_confirmation",
+		templateData{Name: UserName, Email: UserEmail, RecoveryDays: RecoveryDays},
 		hermes.Body{
-			Name: UserName,
-			Intros: []string{
-				"Your Thunderdome account was successfully been deleted.",
-			},
+			Name:   UserName,
+			Intros: Intros,
 			Actions: []hermes.Action{
 				{
 					Instructions: "Need help, or have questions? Visit our Github page",
@@ -258,8 +333,10 @@ func (m *Email) SendDeleteConfirmation(UserName string, UserEmail string) error
 }
 
 // SendEmailUpdate Sends an Update Email confirmation email to user
-func (m *Email) SendEmailUpdate(UserName string, UserEmail string) error {
-	emailBody, err := m.generateBody(
+func (m *Email) SendEmailUpdate(UserName string, UserEmail string, Quiet *model.QuietHours) error {
+	emailBody, err := m.renderBody(
+		"email_update",
+		templateData{Name: UserName, Email: UserEmail},
 		hermes.Body{
 			Name: UserName,
 			Intros: []string{
@@ -281,7 +358,8 @@ func (m *Email) SendEmailUpdate(UserName string, UserEmail string) error {
 		return err
 	}
 
-	sendErr := m.Send(
+	sendErr := m.sendNonUrgent(
+		Quiet,
 		UserName,
 		UserEmail,
 		"Your Thunderdome account email has been updated.",
@@ -296,8 +374,10 @@ func (m *Email) SendEmailUpdate(UserName string, UserEmail string) error {
 }
 
 // SendMergedUpdate Sends an Update Email confirmation email to user
-func (m *Email) SendMergedUpdate(UserName string, UserEmail string) error {
-	emailBody, err := m.generateBody(
+func (m *Email) SendMergedUpdate(UserName string, UserEmail string, Quiet *model.QuietHours) error {
+	emailBody, err := m.renderBody(
+		"merged_update",
+		templateData{Name: UserName, Email: UserEmail},
 		hermes.Body{
 			Name: UserName,
 			Intros: []string{
@@ -319,7 +399,8 @@ func (m *Email) SendMergedUpdate(UserName string, UserEmail string) error {
 		return err
 	}
 
-	sendErr := m.Send(
+	sendErr := m.sendNonUrgent(
+		Quiet,
 		UserName,
 		UserEmail,
 		"Your Thunderdome duplicate accounts have been merged.",
@@ -332,3 +413,22 @@ func (m *Email) SendMergedUpdate(UserName string, UserEmail string) error {
 
 	return nil
 }
+
+// expiresIn describes a links TTL in minutes in the largest whole unit e.g. 24 hours, 7 days
+func expiresIn(Minutes int) string {
+	unit := func(n int, name string) string {
+		if n == 1 {
+			return "1 " + name
+		}
+		return fmt.Sprintf("%d %ss", n, name)
+	}
+
+	switch {
+	case Minutes >= 60*24 && Minutes%(60*24) == 0:
+		return unit(Minutes/(60*24), "day")
+	case Minutes >= 60 && Minutes%60 == 0:
+		return unit(Minutes/60, "hour")
+	default:
+		return unit(Minutes, "minute")
+	}
+}
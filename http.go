@@ -42,21 +42,96 @@ func (s *server) routes() {
 
 	// api (used by the webapp but can be enabled for external use)
 	apiConfig := &api.Config{
-		AppDomain:            s.config.AppDomain,
-		FrontendCookieName:   s.config.FrontendCookieName,
-		SecureCookieName:     viper.GetString("http.backend_cookie_name"),
-		SecureCookieFlag:     viper.GetBool("http.secure_cookie"),
-		SessionCookieName:    viper.GetString("http.session_cookie_name"),
-		PathPrefix:           s.config.PathPrefix,
-		ExternalAPIEnabled:   s.config.ExternalAPIEnabled,
-		UserAPIKeyLimit:      s.config.UserAPIKeyLimit,
-		LdapEnabled:          s.config.LdapEnabled,
-		FeaturePoker:         viper.GetBool("feature.poker"),
-		FeatureRetro:         viper.GetBool("feature.retro"),
-		FeatureStoryboard:    viper.GetBool("feature.storyboard"),
-		OrganizationsEnabled: viper.GetBool("config.organizations_enabled"),
+		AppDomain:                          s.config.AppDomain,
+		FrontendCookieName:                 s.config.FrontendCookieName,
+		SecureCookieName:                   viper.GetString("http.backend_cookie_name"),
+		SecureCookieFlag:                   viper.GetBool("http.secure_cookie"),
+		SessionCookieName:                  viper.GetString("http.session_cookie_name"),
+		PathPrefix:                         s.config.PathPrefix,
+		ExternalAPIEnabled:                 s.config.ExternalAPIEnabled,
+		UserAPIKeyLimit:                    s.config.UserAPIKeyLimit,
+		LdapEnabled:                        s.config.LdapEnabled,
+		LdapManagedFields:                  viper.GetStringSlice("auth.ldap.managed_fields"),
+		LdapAdminGroups:                    ldapAdminGroups(),
+		OIDCEnabled:                        viper.GetString("auth.method") == "oidc",
+		OIDCIssuer:                         viper.GetString("auth.oidc.issuer"),
+		OIDCClientID:                       viper.GetString("auth.oidc.client_id"),
+		OIDCClientSecret:                   viper.GetString("auth.oidc.client_secret"),
+		OIDCRedirectURL:                    viper.GetString("auth.oidc.redirect_url"),
+		GithubLoginEnabled:                 viper.GetBool("auth.github.enabled"),
+		GithubClientID:                     viper.GetString("auth.github.client_id"),
+		GithubClientSecret:                 viper.GetString("auth.github.client_secret"),
+		GithubRedirectURL:                  viper.GetString("auth.github.redirect_url"),
+		GoogleLoginEnabled:                 viper.GetBool("auth.google.enabled"),
+		GoogleClientID:                     viper.GetString("auth.google.client_id"),
+		GoogleClientSecret:                 viper.GetString("auth.google.client_secret"),
+		GoogleRedirectURL:                  viper.GetString("auth.google.redirect_url"),
+		FeaturePoker:                       viper.GetBool("feature.poker"),
+		FeatureRetro:                       viper.GetBool("feature.retro"),
+		FeatureStoryboard:                  viper.GetBool("feature.storyboard"),
+		OrganizationsEnabled:               viper.GetBool("config.organizations_enabled"),
+		RequireNameToJoin:                  viper.GetBool("config.require_name_to_join"),
+		NameDenylist:                       viper.GetStringSlice("config.name_denylist"),
+		AllowedOrigins:                     viper.GetStringSlice("http.allowed_origins"),
+		GuestCapabilities:                  guestCapabilities(),
+		GuestMaxSessionLifetime:            viper.GetInt("config.guest_capabilities.max_session_lifetime"),
+		GuestSessionExpiryWarning:          viper.GetInt("config.guest_capabilities.session_expiry_warning"),
+		UserDeleteGraceDays:                viper.GetInt("config.user_delete_grace_days"),
+		CreateCooldown:                     viper.GetInt("config.create_cooldown"),
+		StorageQuotaTotal:                  viper.GetInt64("config.storage_quota_total_mb") * 1024 * 1024,
+		CookieKeyRetentionDays:             viper.GetInt("http.cookie_key_retention_days"),
+		ClientIPHeader:                     viper.GetString("http.client_ip_header"),
+		AllowQuickBattles:                  viper.GetBool("config.allow_quick_battles"),
+		AllowJiraImport:                    viper.GetBool("config.allow_jira_import"),
+		JiraAllowedHosts:                   viper.GetStringSlice("config.jira_allowed_hosts"),
+		RequireVerifiedPasswordReset:       viper.GetBool("config.require_verified_password_reset"),
+		DemoteInactiveTeamAdminsDays:       viper.GetInt("config.demote_inactive_team_admins_days"),
+		DemoteInactiveTeamAdminsNoticeDays: viper.GetInt("config.demote_inactive_team_admins_notice_days"),
+		TeamDigestEnabled:                  viper.GetBool("config.team_digest_enabled"),
+		TeamDigestWeekday:                  viper.GetInt("config.team_digest_weekday"),
+		TeamDigestHour:                     viper.GetInt("config.team_digest_hour"),
+		TeamDigestIncludeBattles:           viper.GetBool("config.team_digest_include_battles"),
+		TeamDigestIncludePoints:            viper.GetBool("config.team_digest_include_points"),
+		StoryboardSnapshotInterval:         viper.GetInt("config.storyboard_snapshot_interval"),
+		StoryboardSnapshotRetention:        viper.GetInt("config.storyboard_snapshot_retention"),
+		OnboardingSteps:                    viper.GetStringSlice("config.onboarding_steps"),
+		MaxUserSessions:                    viper.GetInt("config.max_user_sessions"),
+		BattleReopenWindowDays:             viper.GetInt("config.battle_reopen_window_days"),
+		RotateSessionOnPrivilegeChange:     viper.GetBool("config.rotate_session_on_privilege_change"),
+		LoginLockoutThreshold:              viper.GetInt("auth.lockout.threshold"),
+		LoginLockoutIPThreshold:            viper.GetInt("auth.lockout.ip_threshold"),
+		LoginLockoutWindow:                 viper.GetInt("auth.lockout.window"),
+		PasswordMinLength:                  viper.GetInt("auth.password.min_length"),
+		PasswordRequireSymbol:              viper.GetBool("auth.password.require_symbol"),
+		PasswordRequireNumber:              viper.GetBool("auth.password.require_number"),
+		PasswordRequireMixedCase:           viper.GetBool("auth.password.require_mixed_case"),
+		CaptchaProvider:                    viper.GetString("config.captcha_provider"),
+		CaptchaSecret:                      viper.GetString("config.captcha_secret"),
+		CaptchaEnabled:                     captchaEnabled(),
+		MaxUserSessionsAdminExempt:         viper.GetBool("config.max_user_sessions_admin_exempt"),
+		QuickBattleTTL:                     viper.GetInt("config.quick_battle_ttl"),
+		QuickBattleIPLimit:                 viper.GetInt("config.quick_battle_ip_limit"),
+		HTTPClientProxy:                    viper.GetString("http_client.proxy"),
+		HTTPClientTimeout:                  viper.GetInt("http_client.timeout"),
+		HTTPClientCACertFile:               viper.GetString("http_client.ca_cert_file"),
+		HTTPClientTLSInsecureSkipVerify:    viper.GetBool("http_client.tls_insecure_skip_verify"),
+		WebsocketPingInterval:              viper.GetInt("ws.ping_interval"),
+		WebsocketPongTimeout:               viper.GetInt("ws.pong_timeout"),
+		WebsocketMessageRate:               viper.GetFloat64("ws.message_rate"),
+		WebsocketMessageBurst:              viper.GetInt("ws.message_burst"),
+		WebsocketMaxDroppedMessages:        viper.GetInt("ws.max_dropped_messages"),
+		AvatarUploadStorage:                viper.GetString("avatar_upload.storage"),
+		AvatarUploadMaxSize:                viper.GetInt64("avatar_upload.max_size_kb") * 1024,
+		AvatarUploadThumbnailSize:          viper.GetInt("avatar_upload.thumbnail_size"),
+		AvatarUploadLocalDir:               viper.GetString("avatar_upload.local_dir"),
+		AvatarUploadS3Bucket:               viper.GetString("avatar_upload.s3_bucket"),
+		AvatarUploadS3Region:               viper.GetString("avatar_upload.s3_region"),
+		AvatarUploadS3Endpoint:             viper.GetString("avatar_upload.s3_endpoint"),
+		AvatarUploadS3AccessKeyID:          viper.GetString("avatar_upload.s3_access_key_id"),
+		AvatarUploadS3SecretAccessKey:      viper.GetString("avatar_upload.s3_secret_access_key"),
+		AvatarUploadS3PublicURL:            viper.GetString("avatar_upload.s3_public_url"),
 	}
-	api.Init(apiConfig, s.router, s.db, s.email, s.cookie, s.logger)
+	api.Init(apiConfig, s.router, s.db, s.email, s.cookieKeys, s.logger)
 
 	// static assets
 	s.router.PathPrefix("/static/").Handler(http.StripPrefix(s.config.PathPrefix, staticHandler))
@@ -124,9 +199,23 @@ func (s *server) handleIndex(FSS fs.FS) http.HandlerFunc {
 		CleanupStoryboardsDaysOld int
 		ShowActiveCountries       bool
 		LdapEnabled               bool
+		OIDCEnabled               bool
+		GithubLoginEnabled        bool
+		GoogleLoginEnabled        bool
 		FeaturePoker              bool
 		FeatureRetro              bool
 		FeatureStoryboard         bool
+		RequireNameToJoin         bool
+		GuestCapabilities         map[string]bool
+		GuestSessionExpiryWarning int
+		AllowQuickBattles         bool
+		CaptchaProvider           string
+		CaptchaSiteKey            string
+		CaptchaEnabled            bool
+		PasswordMinLength         int
+		PasswordRequireSymbol     bool
+		PasswordRequireNumber     bool
+		PasswordRequireMixedCase  bool
 	}
 	type UIConfig struct {
 		AnalyticsEnabled bool
@@ -160,9 +249,23 @@ func (s *server) handleIndex(FSS fs.FS) http.HandlerFunc {
 		CleanupStoryboardsDaysOld: viper.GetInt("config.cleanup_storyboards_days_old"),
 		ShowActiveCountries:       viper.GetBool("config.show_active_countries"),
 		LdapEnabled:               s.config.LdapEnabled,
+		OIDCEnabled:               viper.GetString("auth.method") == "oidc",
+		GithubLoginEnabled:        viper.GetBool("auth.github.enabled") && viper.GetString("auth.method") != "ldap",
+		GoogleLoginEnabled:        viper.GetBool("auth.google.enabled") && viper.GetString("auth.method") != "ldap",
 		FeaturePoker:              viper.GetBool("feature.poker"),
 		FeatureRetro:              viper.GetBool("feature.retro"),
 		FeatureStoryboard:         viper.GetBool("feature.storyboard"),
+		RequireNameToJoin:         viper.GetBool("config.require_name_to_join"),
+		AllowQuickBattles:         viper.GetBool("config.allow_quick_battles") && viper.GetBool("config.allow_guests"),
+		GuestCapabilities:         guestCapabilities(),
+		GuestSessionExpiryWarning: viper.GetInt("config.guest_capabilities.session_expiry_warning"),
+		CaptchaProvider:           viper.GetString("config.captcha_provider"),
+		CaptchaSiteKey:            viper.GetString("config.captcha_site_key"),
+		CaptchaEnabled:            captchaEnabled() && viper.GetString("config.captcha_provider") != "",
+		PasswordMinLength:         viper.GetInt("auth.password.min_length"),
+		PasswordRequireSymbol:     viper.GetBool("auth.password.require_symbol"),
+		PasswordRequireNumber:     viper.GetBool("auth.password.require_number"),
+		PasswordRequireMixedCase:  viper.GetBool("auth.password.require_mixed_case"),
 	}
 
 	data := UIConfig{
@@ -0,0 +1,74 @@
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Config the http_client settings shared by outbound integration and email provider requests
+type Config struct {
+	// Proxy URL requests go through, empty uses the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment
+	Proxy string
+	// Timeout until requests, dials and TLS handshakes time out
+	Timeout time.Duration
+	// CACertFile path to a PEM file of CA certificates trusted in addition to the system CAs
+	CACertFile string
+	// TLSInsecureSkipVerify whether TLS certificate verification is skipped
+	TLSInsecureSkipVerify bool
+}
+
+// New creates an outbound HTTP client, requests go through the configured proxy
+// or otherwise the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment
+func New(config Config) (*http.Client, error) {
+	proxy := http.ProxyFromEnvironment
+	if config.Proxy != "" {
+		ProxyURL, err := url.Parse(config.Proxy)
+		if err != nil || ProxyURL.Host == "" {
+			return nil, errors.New("invalid http client proxy " + config.Proxy)
+		}
+		proxy = http.ProxyURL(ProxyURL)
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: config.TLSInsecureSkipVerify,
+	}
+	// trust the CA of an intercepting proxy alongside the system CAs
+	if config.CACertFile != "" {
+		PEM, err := ioutil.ReadFile(config.CACertFile)
+		if err != nil {
+			return nil, err
+		}
+		RootCAs, err := x509.SystemCertPool()
+		if err != nil || RootCAs == nil {
+			RootCAs = x509.NewCertPool()
+		}
+		if !RootCAs.AppendCertsFromPEM(PEM) {
+			return nil, errors.New("no certificates found in http client ca cert file " + config.CACertFile)
+		}
+		tlsConfig.RootCAs = RootCAs
+	}
+
+	return &http.Client{
+		Timeout: config.Timeout,
+		Transport: &http.Transport{
+			Proxy: proxy,
+			DialContext: (&net.Dialer{
+				Timeout:   config.Timeout,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			TLSClientConfig:       tlsConfig,
+			TLSHandshakeTimeout:   config.Timeout,
+			ResponseHeaderTimeout: config.Timeout,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			ExpectContinueTimeout: time.Second,
+		},
+	}, nil
+}
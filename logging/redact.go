@@ -0,0 +1,107 @@
+// Package logging provides log redaction of personal information for Thunderdome
+package logging
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// redactedValue replaces redacted values in log output
+const redactedValue = "[REDACTED]"
+
+// emailPattern matches email addresses within logged strings
+var emailPattern = regexp.MustCompile(`[a-zA-Z0-9._%+\-]+@[a-zA-Z0-9.\-]+\.[a-zA-Z]{2,}`)
+
+// sensitiveValue a logged value marked as personal information
+type sensitiveValue string
+
+// String returns the raw value, used when redaction is disabled
+func (s sensitiveValue) String() string {
+	return string(s)
+}
+
+// Sensitive constructs a field whose value is redacted when PII redaction is enabled
+func Sensitive(Key string, Value string) zap.Field {
+	return zap.Stringer(Key, sensitiveValue(Value))
+}
+
+// NewRedactingLogger wraps the logger to redact sensitive fields, the named fields, and email addresses
+func NewRedactingLogger(logger *zap.Logger, Fields []string) *zap.Logger {
+	return logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return newRedactCore(core, Fields)
+	}))
+}
+
+// redactCore a zapcore.Core that redacts fields before writing them
+type redactCore struct {
+	zapcore.Core
+	fields map[string]struct{}
+}
+
+func newRedactCore(core zapcore.Core, Fields []string) *redactCore {
+	fields := make(map[string]struct{}, len(Fields))
+	for _, f := range Fields {
+		fields[strings.ToLower(strings.TrimSpace(f))] = struct{}{}
+	}
+
+	return &redactCore{Core: core, fields: fields}
+}
+
+// With adds redacted structured context to the core
+func (c *redactCore) With(Fields []zapcore.Field) zapcore.Core {
+	return &redactCore{Core: c.Core.With(c.redact(Fields)), fields: c.fields}
+}
+
+// Check adds this core to the checked entry so Write redacts before the wrapped core writes
+func (c *redactCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+
+	return ce
+}
+
+// Write redacts the entry message and fields then writes them to the wrapped core
+func (c *redactCore) Write(ent zapcore.Entry, Fields []zapcore.Field) error {
+	ent.Message = redactEmails(ent.Message)
+
+	return c.Core.Write(ent, c.redact(Fields))
+}
+
+// redact replaces sensitive and configured field values and emails within string and error values
+func (c *redactCore) redact(Fields []zapcore.Field) []zapcore.Field {
+	redacted := make([]zapcore.Field, 0, len(Fields))
+	for _, f := range Fields {
+		if _, ok := c.fields[strings.ToLower(f.Key)]; ok {
+			redacted = append(redacted, zap.String(f.Key, redactedValue))
+			continue
+		}
+
+		switch f.Type {
+		case zapcore.StringerType:
+			if _, ok := f.Interface.(sensitiveValue); ok {
+				f = zap.String(f.Key, redactedValue)
+			}
+		case zapcore.StringType:
+			f.String = redactEmails(f.String)
+		case zapcore.ErrorType:
+			if err, ok := f.Interface.(error); ok {
+				f = zap.String(f.Key, redactEmails(err.Error()))
+			}
+		case zapcore.ReflectType:
+			f = zap.String(f.Key, redactEmails(fmt.Sprintf("%v", f.Interface)))
+		}
+		redacted = append(redacted, f)
+	}
+
+	return redacted
+}
+
+// redactEmails replaces any email addresses within the string
+func redactEmails(s string) string {
+	return emailPattern.ReplaceAllString(s, redactedValue)
+}
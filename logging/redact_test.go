@@ -0,0 +1,53 @@
+package logging
+
+import (
+	"errors"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// TestNewRedactingLogger logs sensitive fields, configured fields, and emails making sure they are redacted
+// while other fields are left alone
+func TestNewRedactingLogger(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	logger := NewRedactingLogger(zap.New(core), []string{"token"}).With(zap.String("session_user", "thor@thunderdome.dev"))
+
+	logger.Error("unable to email loki@thunderdome.dev",
+		Sensitive("username", "Thor Odinson"),
+		zap.String("token", "mjolnir"),
+		zap.String("battle_id", "asgard"),
+		zap.Error(errors.New("odin@thunderdome.dev not found")),
+	)
+
+	entry := logs.All()[0]
+	if entry.Message != "unable to email [REDACTED]" {
+		t.Fatalf(`message = %s, want email redacted`, entry.Message)
+	}
+
+	fields := entry.ContextMap()
+	want := map[string]string{
+		"session_user": redactedValue,
+		"username":     redactedValue,
+		"token":        redactedValue,
+		"battle_id":    "asgard",
+		"error":        "[REDACTED] not found",
+	}
+	for key, value := range want {
+		if fields[key] != value {
+			t.Fatalf(`field %s = %v, want %s`, key, fields[key], value)
+		}
+	}
+}
+
+// TestSensitiveWithoutRedaction makes sure sensitive fields log their raw value when redaction isn't enabled
+func TestSensitiveWithoutRedaction(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	zap.New(core).Info("login", Sensitive("username", "Thor Odinson"))
+
+	if value := logs.All()[0].ContextMap()["username"]; value != "Thor Odinson" {
+		t.Fatalf(`username = %v, want Thor Odinson`, value)
+	}
+}
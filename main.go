@@ -9,10 +9,10 @@ import (
 
 	"github.com/StevenWeathers/thunderdome-planning-poker/db"
 	"github.com/StevenWeathers/thunderdome-planning-poker/email"
+	"github.com/StevenWeathers/thunderdome-planning-poker/logging"
 	"go.uber.org/zap"
 
 	"github.com/gorilla/mux"
-	"github.com/gorilla/securecookie"
 	"github.com/spf13/viper"
 )
 
@@ -51,12 +51,12 @@ type Config struct {
 }
 
 type server struct {
-	config *Config
-	router *mux.Router
-	email  *email.Email
-	cookie *securecookie.SecureCookie
-	db     *db.Database
-	logger *zap.Logger
+	config     *Config
+	router     *mux.Router
+	email      *email.Email
+	cookieKeys [][]byte
+	db         *db.Database
+	logger     *zap.Logger
 }
 
 func main() {
@@ -68,7 +68,14 @@ func main() {
 
 	InitConfig(logger)
 
-	cookieHashkey := viper.GetString("http.cookie_hashkey")
+	if viper.GetBool("config.log_redact_pii") {
+		logger = logging.NewRedactingLogger(logger, viper.GetStringSlice("config.log_redact_fields"))
+	}
+
+	cookieKeys := [][]byte{[]byte(viper.GetString("http.cookie_hashkey"))}
+	for _, key := range viper.GetStringSlice("http.cookie_hashkey_previous") {
+		cookieKeys = append(cookieKeys, []byte(key))
+	}
 	pathPrefix := viper.GetString("http.path_prefix")
 	router := mux.NewRouter()
 
@@ -91,21 +98,54 @@ func main() {
 			UserAPIKeyLimit:    viper.GetInt("config.user_apikey_limit"),
 			LdapEnabled:        viper.GetString("auth.method") == "ldap",
 		},
-		router: router,
-		cookie: securecookie.New([]byte(cookieHashkey), nil),
-		logger: logger,
+		router:     router,
+		cookieKeys: cookieKeys,
+		logger:     logger,
+	}
+
+	tokenTTL := map[string]int{
+		db.TokenTypeReset:       viper.GetInt("config.reset_token_ttl"),
+		db.TokenTypeVerify:      viper.GetInt("config.verify_token_ttl"),
+		db.TokenTypeInvite:      viper.GetInt("config.invite_token_ttl"),
+		db.TokenTypeEmailChange: viper.GetInt("config.verify_token_ttl"),
 	}
 
-	s.email = email.New(s.config.AppDomain, s.config.PathPrefix, s.logger)
 	s.db = db.New(s.config.AdminEmail, &db.Config{
-		Host:       viper.GetString("db.host"),
-		Port:       viper.GetInt("db.port"),
-		User:       viper.GetString("db.user"),
-		Password:   viper.GetString("db.pass"),
-		Name:       viper.GetString("db.name"),
-		SSLMode:    viper.GetString("db.sslmode"),
-		AESHashkey: viper.GetString("config.aes_hashkey"),
+		Host:                        viper.GetString("db.host"),
+		Port:                        viper.GetInt("db.port"),
+		User:                        viper.GetString("db.user"),
+		Password:                    viper.GetString("db.pass"),
+		Name:                        viper.GetString("db.name"),
+		SSLMode:                     viper.GetString("db.sslmode"),
+		AESHashkey:                  viper.GetString("config.aes_hashkey"),
+		TokenTTL:                    tokenTTL,
+		HTMLAllowedTags:             viper.GetStringSlice("config.html_allowed_tags"),
+		EmailUniqueIncludingDeleted: viper.GetBool("config.email_unique_including_deleted"),
+		MaxPlansPerBattle:           viper.GetInt("config.max_plans_per_battle"),
+		MaxUserViews:                viper.GetInt("config.max_user_views"),
+		PasswordHistoryDepth:        viper.GetInt("auth.password.history_depth"),
+		StorageQuotaTotal:           viper.GetInt64("config.storage_quota_total_mb") * 1024 * 1024,
+		StorageQuotaPerUser:         viper.GetInt64("config.storage_quota_user_mb") * 1024 * 1024,
+		UserDeleteGraceDays:         viper.GetInt("config.user_delete_grace_days"),
+		SessionIdleTimeout:          viper.GetInt("auth.session.idle_timeout"),
+		StoryboardRevisionLimit:     viper.GetInt("config.storyboard_revision_limit"),
+		AbstainVote:                 viper.GetString("config.abstain_vote"),
 	}, s.logger)
+	s.email = email.New(
+		s.config.AppDomain, s.config.PathPrefix,
+		db.TokenTTL(tokenTTL, db.TokenTypeReset), db.TokenTTL(tokenTTL, db.TokenTypeVerify),
+		s.db,
+		s.logger,
+	)
+
+	// periodically send emails deferred for the recipients quiet hours
+	go s.email.DeferredEmailSweeper(time.Minute)
+	// periodically clean up expired tokens
+	go s.db.TokenSweeper(time.Hour)
+	// periodically clean up failed logins outside the lockout window
+	go s.db.FailedLoginSweeper(time.Hour, time.Duration(viper.GetInt("auth.lockout.window"))*time.Minute)
+	// periodically clean up expired quick battles
+	go s.db.QuickBattleSweeper(5 * time.Minute)
 
 	s.routes()
 
@@ -27,6 +27,29 @@ type ApplicationStats struct {
 	StoryboardColumnCount     int `json:"storyboardColumnCount"`
 	StoryboardStoryCount      int `json:"storyboardStoryCount"`
 	StoryboardPersonaCount    int `json:"storyboardPersonaCount"`
+	// StoryboardReapedConnectionCount storyboard websocket connections closed for missing the pong deadline since the app started
+	StoryboardReapedConnectionCount int64 `json:"storyboardReapedConnectionCount"`
+}
+
+// InstanceStats includes product usage statistics for the instance over a date range
+type InstanceStats struct {
+	UserCount         int          `json:"userCount"`
+	ActiveUserCount   int          `json:"activeUserCount"`
+	GuestUserCount    int          `json:"guestUserCount"`
+	BattleCount       int          `json:"battleCount"`
+	ActiveBattleCount int          `json:"activeBattleCount"`
+	StoryboardCount   int          `json:"storyboardCount"`
+	VoteCount         int          `json:"voteCount"`
+	Signups           []*DateCount `json:"signups"`
+	From              time.Time    `json:"from"`
+	To                time.Time    `json:"to"`
+	GeneratedDate     time.Time    `json:"generatedDate"`
+}
+
+// DateCount is a count for a single day used in time series stats
+type DateCount struct {
+	Date  string `json:"date"`
+	Count int    `json:"count"`
 }
 
 type Alert struct {
@@ -40,3 +63,32 @@ type Alert struct {
 	CreatedDate    time.Time `json:"createdDate" db:"created_date"`
 	UpdatedDate    time.Time `json:"updatedDate" db:"updated_date"`
 }
+
+// FeatureFlag a feature flag with targeting rules, when enabled with no rules it applies to all users
+type FeatureFlag struct {
+	Id          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Enabled     bool      `json:"enabled"`
+	Roles       []string  `json:"roles"`
+	UserIds     []string  `json:"userIds"`
+	Percentage  int       `json:"percentage"`
+	CreatedDate time.Time `json:"createdDate"`
+	UpdatedDate time.Time `json:"updatedDate"`
+}
+
+// StorageUsage a users upload storage usage and quota, a QuotaBytes of 0 is unlimited
+type StorageUsage struct {
+	UserId     string `json:"userId"`
+	UserName   string `json:"userName"`
+	UsedBytes  int64  `json:"usedBytes"`
+	QuotaBytes int64  `json:"quotaBytes"`
+}
+
+// CookieSigningKey a rotated cookie signing key, the key itself is never exposed
+type CookieSigningKey struct {
+	Id          string    `json:"id"`
+	Key         []byte    `json:"-"`
+	Current     bool      `json:"current"`
+	CreatedDate time.Time `json:"createdDate"`
+}
@@ -16,41 +16,231 @@ type BattleUser struct {
 
 // Battle aka arena
 type Battle struct {
-	Id                   string        `json:"id"`
-	Name                 string        `json:"name"`
-	Users                []*BattleUser `json:"users"`
-	Plans                []*Plan       `json:"plans"`
-	VotingLocked         bool          `json:"votingLocked"`
-	ActivePlanID         string        `json:"activePlanId"`
-	PointValuesAllowed   []string      `json:"pointValuesAllowed"`
-	AutoFinishVoting     bool          `json:"autoFinishVoting"`
-	Leaders              []string      `json:"leaders"`
-	PointAverageRounding string        `json:"pointAverageRounding"`
-	JoinCode             string        `json:"joinCode"`
-	LeaderCode           string        `json:"leaderCode,omitempty"`
-	CreatedDate          time.Time     `json:"createdDate"`
-	UpdatedDate          time.Time     `json:"updatedDate"`
+	Id                   string                  `json:"id"`
+	Name                 string                  `json:"name"`
+	Users                []*BattleUser           `json:"users"`
+	Plans                []*Plan                 `json:"plans"`
+	VotingLocked         bool                    `json:"votingLocked"`
+	ActivePlanID         string                  `json:"activePlanId"`
+	CurrentPlanID        string                  `json:"currentPlanId"`
+	AutoStartVoting      bool                    `json:"autoStartVoting"`
+	Paused               bool                    `json:"paused"`
+	PointScale           string                  `json:"pointScale"`
+	VoteTimerDefault     int                     `json:"voteTimerDefault"`
+	PointValuesAllowed   []string                `json:"pointValuesAllowed"`
+	AbstainVote          string                  `json:"abstainVote"`
+	AutoFinishVoting     bool                    `json:"autoFinishVoting"`
+	Leaders              []string                `json:"leaders"`
+	PointAverageRounding string                  `json:"pointAverageRounding"`
+	JoinCode             string                  `json:"joinCode"`
+	LeaderCode           string                  `json:"leaderCode,omitempty"`
+	RecordingEnabled     bool                    `json:"recordingEnabled"`
+	ShortCode            string                  `json:"shortCode"`
+	PlanLimit            int                     `json:"planLimit"`
+	Quick                bool                    `json:"quick"`
+	RequireReadyToReveal bool                    `json:"requireReadyToReveal"`
+	PermanentAnonymity   bool                    `json:"permanentAnonymity"`
+	EstimateCap          *float64                `json:"estimateCap"`
+	MinParticipants      int                     `json:"minParticipants"`
+	Tags                 []string                `json:"tags,omitempty"`
+	Closed               bool                    `json:"closed"`
+	NoteTakerID          string                  `json:"noteTakerId"`
+	State                string                  `json:"state"`
+	ClosedDate           *time.Time              `json:"closedDate,omitempty"`
+	ParkingLot           []*BattleParkingLotItem `json:"parkingLot"`
+	ExpireDate           *time.Time              `json:"expireDate,omitempty"`
+	CreatedDate          time.Time               `json:"createdDate"`
+	UpdatedDate          time.Time               `json:"updatedDate"`
+}
+
+// BattleStateWorkflow the battle states a team tracks and the allowed transitions between them,
+// the first state is the initial state of the teams battles
+type BattleStateWorkflow struct {
+	States      []string            `json:"states"`
+	Transitions map[string][]string `json:"transitions"`
+}
+
+// BattleParkingLotItem a tangential topic raised during a battle to revisit later
+type BattleParkingLotItem struct {
+	Id          string    `json:"id"`
+	UserId      string    `json:"userId"`
+	UserName    string    `json:"userName"`
+	Content     string    `json:"content"`
+	Resolved    bool      `json:"resolved"`
+	CreatedDate time.Time `json:"createdDate"`
+}
+
+// BattleEvent a recorded battle event
+type BattleEvent struct {
+	BattleId    string    `json:"-"`
+	UserId      string    `json:"userId"`
+	UserName    string    `json:"userName"`
+	Type        string    `json:"type"`
+	Value       string    `json:"value"`
+	CreatedDate time.Time `json:"createdDate"`
+}
+
+// BattleReplay a recorded battle session ending with the final plan estimates
+type BattleReplay struct {
+	BattleId string         `json:"battleId"`
+	Events   []*BattleEvent `json:"events"`
+	Plans    []*Plan        `json:"plans"`
 }
 
 // Vote structure
 type Vote struct {
 	UserId    string `json:"warriorId"`
 	VoteValue string `json:"vote"`
+	// DelegateId the participant that cast the vote on the users behalf, empty when the user voted
+	DelegateId string `json:"delegateId,omitempty"`
+}
+
+// UserPlanVote a users own vote for a plan, used to restore their vote on reconnect
+type UserPlanVote struct {
+	PlanId    string `json:"planId"`
+	VoteValue string `json:"vote"`
+}
+
+// PlanAcceptanceCriterion a checkable acceptance criteria item of a plan
+type PlanAcceptanceCriterion struct {
+	Id      string `json:"id"`
+	Content string `json:"content"`
+	Checked bool   `json:"checked"`
 }
 
 // Plan aka Story structure
 type Plan struct {
-	Id                 string    `json:"id"`
-	Name               string    `json:"name"`
-	Type               string    `json:"type"`
-	ReferenceId        string    `json:"referenceId"`
-	Link               string    `json:"link"`
-	Description        string    `json:"description"`
-	AcceptanceCriteria string    `json:"acceptanceCriteria"`
-	Votes              []*Vote   `json:"votes"`
-	Points             string    `json:"points"`
-	Active             bool      `json:"active"`
-	Skipped            bool      `json:"skipped"`
-	VoteStartTime      time.Time `json:"voteStartTime"`
-	VoteEndTime        time.Time `json:"voteEndTime"`
+	Id                      string                     `json:"id"`
+	Name                    string                     `json:"name"`
+	Type                    string                     `json:"type"`
+	ReferenceId             string                     `json:"referenceId"`
+	Link                    string                     `json:"link"`
+	Description             string                     `json:"description"`
+	AcceptanceCriteria      string                     `json:"acceptanceCriteria"`
+	AcceptanceCriteriaItems []*PlanAcceptanceCriterion `json:"acceptanceCriteriaItems"`
+	Votes                   []*Vote                    `json:"votes"`
+	Points                  string                     `json:"points"`
+	Active                  bool                       `json:"active"`
+	Skipped                 bool                       `json:"skipped"`
+	VoteStartTime           time.Time                  `json:"voteStartTime"`
+	VoteEndTime             time.Time                  `json:"voteEndTime"`
+	VoteTimerEnd            *time.Time                 `json:"voteTimerEnd"`
+	Poll                    *PlanPoll                  `json:"poll"`
+	Discussion              []*PlanDiscussionEntry     `json:"discussion"`
+	// ActualPoints the actual effort recorded after the sprint, nil when not tracked
+	ActualPoints *float64 `json:"actualPoints"`
+	// VoteDelegations the participants voting on behalf of others for the plan
+	VoteDelegations []*PlanVoteDelegation `json:"voteDelegations"`
+	// Position the server assigned order of the plan within the battle starting at 1
+	Position int `json:"position"`
+	// Results the summary of the plans revealed votes, nil while voting is open
+	Results *PlanVoteResults `json:"results"`
+}
+
+// PlanVoteResults the summary of a plans revealed votes, the average, median and spread
+// only cover numeric estimates and are nil when the battles scale isn't numeric
+type PlanVoteResults struct {
+	Average *float64 `json:"average"`
+	Median  *float64 `json:"median"`
+	// Mode the most common estimates, more than one when tied
+	Mode []string `json:"mode"`
+	// Consensus whether every estimate is the same
+	Consensus bool `json:"consensus"`
+	// Min the lowest numeric estimate, with Max the spread of the estimates
+	Min           *float64 `json:"min"`
+	Max           *float64 `json:"max"`
+	EstimateCount int      `json:"estimateCount"`
+	UnsureCount   int      `json:"unsureCount"`
+	AbstainCount  int      `json:"abstainCount"`
+}
+
+// BattleExportPlan a plan with its final points and who voted what for battle exports
+type BattleExportPlan struct {
+	Id          string `json:"id"`
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	ReferenceId string `json:"referenceId"`
+	Link        string `json:"link"`
+	Points      string `json:"points"`
+	Active      bool   `json:"active"`
+	Skipped     bool   `json:"skipped"`
+	// Votes the revealed votes, empty while voting is open and without who voted in anonymous battles
+	Votes []*VoteDetail `json:"votes"`
+}
+
+// VoteDetail a vote with the name of who cast it
+type VoteDetail struct {
+	UserId    string `json:"warriorId"`
+	UserName  string `json:"name"`
+	VoteValue string `json:"vote"`
+}
+
+// VoteTimer the countdown to the automatic reveal of the active plans votes
+type VoteTimer struct {
+	PlanId    string    `json:"planId"`
+	EndTime   time.Time `json:"endTime"`
+	Remaining int       `json:"remainingSeconds"`
+	Paused    bool      `json:"paused"`
+}
+
+// PlanVoteDelegation a participant voting on another users behalf for a plan,
+// expires (no longer active) once the plans votes are revealed
+type PlanVoteDelegation struct {
+	UserId     string `json:"userId"`
+	DelegateId string `json:"delegateId"`
+	Active     bool   `json:"active"`
+	// Voted whether the delegate has cast the users vote
+	Voted bool `json:"voted"`
+}
+
+// PlanDiscussionEntry a markdown note of the running discussion transcript taken while estimating a plan
+type PlanDiscussionEntry struct {
+	Id          string    `json:"id"`
+	UserId      string    `json:"userId"`
+	UserName    string    `json:"userName"`
+	Content     string    `json:"content"`
+	CreatedDate time.Time `json:"createdDate"`
+	UpdatedDate time.Time `json:"updatedDate"`
+}
+
+// PlanPoll a warm-up poll asked before estimating a plan e.g. does everyone understand the story,
+// responses are tallied without affecting the estimate
+type PlanPoll struct {
+	Question           string `json:"question"`
+	Open               bool   `json:"open"`
+	Yes                int    `json:"yes"`
+	No                 int    `json:"no"`
+	Unsure             int    `json:"unsure"`
+	NeedsClarification bool   `json:"needsClarification"`
+}
+
+// RevealReadiness the participants ready to reveal the active plans votes
+type RevealReadiness struct {
+	PlanId           string   `json:"planId"`
+	ReadyUserIds     []string `json:"readyUserIds"`
+	ReadyCount       int      `json:"readyCount"`
+	ParticipantCount int      `json:"participantCount"`
+	AllReady         bool     `json:"allReady"`
+}
+
+// EstimationAccuracy estimated vs actual effort metrics of plans with recorded actuals,
+// Variance is actual minus estimate so positive values are underestimates
+type EstimationAccuracy struct {
+	SampleCount            int                         `json:"sampleCount"`
+	MeanVariance           float64                     `json:"meanVariance"`
+	MeanAbsoluteVariance   float64                     `json:"meanAbsoluteVariance"`
+	MeanAbsolutePercentage float64                     `json:"meanAbsolutePercentage"`
+	OverEstimated          int                         `json:"overEstimated"`
+	UnderEstimated         int                         `json:"underEstimated"`
+	Accurate               int                         `json:"accurate"`
+	Trend                  []*EstimationAccuracyPeriod `json:"trend"`
+}
+
+// EstimationAccuracyPeriod the estimation accuracy of a month for the over/under-estimation trend
+type EstimationAccuracyPeriod struct {
+	Period         string  `json:"period"`
+	SampleCount    int     `json:"sampleCount"`
+	MeanVariance   float64 `json:"meanVariance"`
+	OverEstimated  int     `json:"overEstimated"`
+	UnderEstimated int     `json:"underEstimated"`
 }
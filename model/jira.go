@@ -0,0 +1,15 @@
+package model
+
+import "time"
+
+// JiraInstance the Jira connection a user or team imports stories from, the API token
+// is stored encrypted and never included
+type JiraInstance struct {
+	Id          string    `json:"id"`
+	UserId      string    `json:"userId,omitempty"`
+	TeamId      string    `json:"teamId,omitempty"`
+	Host        string    `json:"host"`
+	ClientMail  string    `json:"clientMail"`
+	CreatedDate time.Time `json:"createdDate"`
+	UpdatedDate time.Time `json:"updatedDate"`
+}
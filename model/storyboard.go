@@ -1,5 +1,7 @@
 package model
 
+import "time"
+
 // StoryboardUser aka user
 type StoryboardUser struct {
 	UserID       string `json:"id"`
@@ -8,6 +10,7 @@ type StoryboardUser struct {
 	Avatar       string `json:"avatar"`
 	Abandoned    bool   `json:"abandoned"`
 	GravatarHash string `json:"gravatarHash"`
+	Role         string `json:"role"`
 }
 
 // Storyboard A story mapping board
@@ -20,6 +23,8 @@ type Storyboard struct {
 	ColorLegend    []*Color             `json:"color_legend"`
 	Personas       []*StoryboardPersona `json:"personas"`
 	JoinCode       string               `json:"joinCode"`
+	PointValues    []int                `json:"pointValuesAllowed"`
+	Tags           []string             `json:"tags,omitempty"`
 	CreatedDate    string               `json:"createdDate" db:"created_date"`
 	UpdatedDate    string               `json:"updatedDate" db:"updated_date"`
 }
@@ -30,6 +35,9 @@ type StoryboardGoal struct {
 	GoalName  string              `json:"name"`
 	Columns   []*StoryboardColumn `json:"columns"`
 	SortOrder int                 `json:"sort_order"`
+	Points    int                 `json:"points"`
+	// Locked only facilitators can add, move, or delete the goals stories
+	Locked bool `json:"locked"`
 }
 
 // StoryboardColumn A column in a storyboard goal
@@ -38,6 +46,7 @@ type StoryboardColumn struct {
 	ColumnName string             `json:"name"`
 	Stories    []*StoryboardStory `json:"stories"`
 	SortOrder  int                `json:"sort_order"`
+	Points     int                `json:"points"`
 }
 
 // StoryboardStory A story in a storyboard goal column
@@ -48,8 +57,10 @@ type StoryboardStory struct {
 	StoryColor   string          `json:"color"`
 	StoryPoints  int             `json:"points"`
 	StoryClosed  bool            `json:"closed"`
+	StoryTags    []string        `json:"tags"`
 	SortOrder    int             `json:"sort_order"`
 	Comments     []*StoryComment `json:"comments"`
+	CommentCount int             `json:"comment_count"`
 }
 
 // StoryComment A story comment by a user
@@ -57,7 +68,9 @@ type StoryComment struct {
 	ID          string `json:"id"`
 	StoryID     string `json:"story_id"`
 	UserID      string `json:"user_id"`
+	UserName    string `json:"user_name,omitempty"`
 	Comment     string `json:"comment"`
+	Edited      bool   `json:"edited"`
 	CreateDate  string `json:"created_date"`
 	UpdatedDate string `json:"updated_date"`
 }
@@ -69,3 +82,72 @@ type StoryboardPersona struct {
 	Role        string `json:"role"`
 	Description string `json:"description"`
 }
+
+// StoryboardImport the schema for importing a storyboard, columns and stories reference
+// their goal and column by the Key given in the import
+type StoryboardImport struct {
+	Name    string                    `json:"name"`
+	Goals   []*StoryboardImportGoal   `json:"goals"`
+	Columns []*StoryboardImportColumn `json:"columns"`
+	Stories []*StoryboardImportStory  `json:"stories"`
+}
+
+// StoryboardImportGoal a goal (row) to import
+type StoryboardImportGoal struct {
+	Key  string `json:"key"`
+	Name string `json:"name"`
+}
+
+// StoryboardImportColumn a column to import into the goal by GoalKey
+type StoryboardImportColumn struct {
+	Key     string `json:"key"`
+	GoalKey string `json:"goalKey"`
+	Name    string `json:"name"`
+}
+
+// StoryboardImportStory a story to import into the column by ColumnKey
+type StoryboardImportStory struct {
+	ColumnKey string   `json:"columnKey"`
+	Name      string   `json:"name"`
+	Content   string   `json:"content"`
+	Color     string   `json:"color"`
+	Points    int      `json:"points"`
+	Closed    bool     `json:"closed"`
+	Tags      []string `json:"tags,omitempty"`
+}
+
+// StoryboardSnapshot a point in time copy of a storyboards goals, columns, and stories it can be restored to
+type StoryboardSnapshot struct {
+	Id          string    `json:"id"`
+	UserId      string    `json:"userId"`
+	Automatic   bool      `json:"automatic"`
+	GoalCount   int       `json:"goalCount"`
+	StoryCount  int       `json:"storyCount"`
+	CreatedDate time.Time `json:"createdDate"`
+	// Data the snapshot contents in the storyboard import schema, only included when getting a single snapshot
+	Data *StoryboardImport `json:"data,omitempty"`
+}
+
+// StoryboardRevision a change made to a storyboards goals, columns, or stories, recording the goal, column, or story as it was before it so it can be undone
+type StoryboardRevision struct {
+	Id          string    `json:"id"`
+	UserId      string    `json:"userId"`
+	UserName    string    `json:"userName"`
+	Action      string    `json:"action"`
+	Undone      bool      `json:"undone"`
+	CreatedDate time.Time `json:"createdDate"`
+}
+
+// StoryboardTemplate a reusable structure of goals and columns storyboards can be created from,
+// owned by a user or a team, default templates are available to everyone
+type StoryboardTemplate struct {
+	Id          string                    `json:"id"`
+	Name        string                    `json:"name"`
+	Description string                    `json:"description"`
+	OwnerId     string                    `json:"ownerId"`
+	TeamId      string                    `json:"teamId,omitempty"`
+	IsDefault   bool                      `json:"isDefault"`
+	Goals       []*StoryboardImportGoal   `json:"goals"`
+	Columns     []*StoryboardImportColumn `json:"columns"`
+	CreatedDate time.Time                 `json:"createdDate"`
+}
@@ -9,6 +9,12 @@ type Team struct {
 	UpdatedDate time.Time `json:"updatedDate"`
 }
 
+// TeamEstimationPolicy the teams policy for its battles votes
+type TeamEstimationPolicy struct {
+	// MaxEstimate the highest estimate that can be voted in the teams battles, null is no cap
+	MaxEstimate *float64 `json:"maxEstimate"`
+}
+
 type TeamUser struct {
 	Id           string `json:"id"`
 	Name         string `json:"name"`
@@ -18,6 +24,21 @@ type TeamUser struct {
 	GravatarHash string `json:"gravatarHash"`
 }
 
+// TeamAPIKey a team service API key not tied to an individual user
+type TeamAPIKey struct {
+	Id           string     `json:"id"`
+	Prefix       string     `json:"prefix"`
+	TeamId       string     `json:"teamId"`
+	Name         string     `json:"name"`
+	Key          string     `json:"apiKey"`
+	Scopes       []string   `json:"scopes"`
+	Active       bool       `json:"active"`
+	CreatedBy    string     `json:"createdBy"`
+	CreatedDate  time.Time  `json:"createdDate"`
+	UpdatedDate  time.Time  `json:"updatedDate"`
+	LastUsedDate *time.Time `json:"lastUsedDate"`
+}
+
 type TeamCheckin struct {
 	Id          string            `json:"id"`
 	User        *TeamUser         `json:"user"`
@@ -40,3 +61,49 @@ type CheckinComment struct {
 	CreateDate  string `json:"created_date"`
 	UpdatedDate string `json:"updated_date"`
 }
+
+// TeamManifestEntry a battle or storyboard associated to the team, used for governance audits
+type TeamManifestEntry struct {
+	Type             string    `json:"type" enums:"battle,storyboard"`
+	Id               string    `json:"id"`
+	Name             string    `json:"name"`
+	OwnerId          string    `json:"ownerId"`
+	OwnerName        string    `json:"ownerName"`
+	Status           string    `json:"status" enums:"ACTIVE,IDLE,PAUSED,VOTING"`
+	ItemCount        int       `json:"itemCount"`
+	ParticipantCount int       `json:"participantCount"`
+	CreatedDate      time.Time `json:"createdDate"`
+}
+
+// InactiveTeamAdmin a team admin that hasn't been active, used by the inactive admin demotion policy
+type InactiveTeamAdmin struct {
+	TeamId             string     `json:"teamId"`
+	TeamName           string     `json:"teamName"`
+	UserId             string     `json:"userId"`
+	UserName           string     `json:"userName"`
+	UserEmail          string     `json:"userEmail"`
+	LastActive         time.Time  `json:"lastActive"`
+	DemotionNoticeDate *time.Time `json:"demotionNoticeDate"`
+}
+
+// TeamDigest the teams weekly summary of estimation activity
+type TeamDigest struct {
+	TeamId   string `json:"teamId"`
+	TeamName string `json:"teamName"`
+	// CompletedBattles the names of the teams battles closed during the period
+	CompletedBattles []string `json:"completedBattles"`
+	// PlansEstimated the number of the teams plans given points during the period
+	PlansEstimated int `json:"plansEstimated"`
+	// PointsEstimated the total numeric points of the plans estimated during the period
+	PointsEstimated float64 `json:"pointsEstimated"`
+	// Recipients the team users subscribed to the digest
+	Recipients []*TeamDigestRecipient `json:"recipients"`
+}
+
+// TeamDigestRecipient a team user subscribed to the team digest
+type TeamDigestRecipient struct {
+	UserId     string      `json:"userId"`
+	UserName   string      `json:"userName"`
+	UserEmail  string      `json:"userEmail"`
+	QuietHours *QuietHours `json:"quietHours"`
+}
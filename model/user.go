@@ -4,32 +4,167 @@ import "time"
 
 // User aka user
 type User struct {
-	Id                   string    `json:"id"`
-	Name                 string    `json:"name"`
-	Email                string    `json:"email"`
-	Type                 string    `json:"rank"`
-	Avatar               string    `json:"avatar"`
-	Verified             bool      `json:"verified"`
-	NotificationsEnabled bool      `json:"notificationsEnabled"`
-	Country              string    `json:"country"`
-	Locale               string    `json:"locale"`
-	Company              string    `json:"company"`
-	JobTitle             string    `json:"jobTitle"`
-	GravatarHash         string    `json:"gravatarHash"`
-	CreatedDate          time.Time `json:"createdDate"`
-	UpdatedDate          time.Time `json:"updatedDate"`
-	LastActive           time.Time `json:"lastActive"`
-	Disabled             bool      `json:"disabled"`
+	Id                   string           `json:"id"`
+	Name                 string           `json:"name"`
+	Email                string           `json:"email"`
+	Type                 string           `json:"rank"`
+	Avatar               string           `json:"avatar"`
+	Verified             bool             `json:"verified"`
+	NotificationsEnabled bool             `json:"notificationsEnabled"`
+	Country              string           `json:"country"`
+	Locale               string           `json:"locale"`
+	Company              string           `json:"company"`
+	JobTitle             string           `json:"jobTitle"`
+	GravatarHash         string           `json:"gravatarHash"`
+	CreatedDate          time.Time        `json:"createdDate"`
+	UpdatedDate          time.Time        `json:"updatedDate"`
+	LastActive           time.Time        `json:"lastActive"`
+	Disabled             bool             `json:"disabled"`
+	MFAEnabled           bool             `json:"mfaEnabled"`
+	TeamDigestEnabled    bool             `json:"teamDigestEnabled"`
+	FeatureFlags         map[string]bool  `json:"featureFlags,omitempty"`
+	Onboarding           *OnboardingState `json:"onboarding,omitempty"`
+	// GuestSessionExpires when the guests session expires, only set for guests with a limited session lifetime
+	GuestSessionExpires *time.Time `json:"guestSessionExpires,omitempty"`
+	// GuestSessionRemaining seconds remaining in the guests session for a countdown
+	GuestSessionRemaining int64 `json:"guestSessionRemaining,omitempty"`
 }
 
 // APIKey structure
 type APIKey struct {
+	Id          string     `json:"id"`
+	Prefix      string     `json:"prefix"`
+	UserId      string     `json:"userId"`
+	Name        string     `json:"name"`
+	Key         string     `json:"apiKey"`
+	Active      bool       `json:"active"`
+	CreatedDate time.Time  `json:"createdDate"`
+	UpdatedDate time.Time  `json:"updatedDate"`
+	ExpireDate  *time.Time `json:"expireDate,omitempty"`
+}
+
+// QuietHours a users do not disturb window for non-urgent emails, in HH:MM local to the timezone
+type QuietHours struct {
+	Timezone string `json:"timezone"`
+	Start    string `json:"start"`
+	End      string `json:"end"`
+}
+
+// DeferredEmail a non-urgent email held until the recipients quiet hours have passed
+type DeferredEmail struct {
+	Id        string    `json:"id"`
+	UserName  string    `json:"userName"`
+	UserEmail string    `json:"userEmail"`
+	Subject   string    `json:"subject"`
+	Body      string    `json:"body"`
+	SendAt    time.Time `json:"sendAt"`
+}
+
+// OnboardingStep a step of the new user onboarding checklist
+type OnboardingStep struct {
+	Name      string `json:"name"`
+	Completed bool   `json:"completed"`
+}
+
+// OnboardingState a users progress through the onboarding checklist
+type OnboardingState struct {
+	Steps []*OnboardingStep `json:"steps"`
+}
+
+// UserViewFilter the supported filters of a saved battle or storyboard list view
+type UserViewFilter struct {
+	// List the list the view applies to, battles or storyboards
+	List string `json:"list"`
+	// State only battles in the state
+	State string `json:"state,omitempty"`
+	// TeamId only the teams battles or storyboards
+	TeamId string `json:"teamId,omitempty"`
+	// Tag only the battles or storyboards the user tagged with it
+	Tag string `json:"tag,omitempty"`
+}
+
+// UserView a users saved battle or storyboard list filter
+type UserView struct {
+	Id          string          `json:"id"`
+	Name        string          `json:"name"`
+	Filter      *UserViewFilter `json:"filter"`
+	CreatedDate time.Time       `json:"createdDate"`
+	UpdatedDate time.Time       `json:"updatedDate"`
+}
+
+// UserSession a users active login session, Id is a hash of the session so the secret session id is never exposed
+type UserSession struct {
+	Id          string    `json:"id"`
+	UserAgent   string    `json:"userAgent"`
+	IPAddress   string    `json:"ipAddress"`
+	CreatedDate time.Time `json:"createdDate"`
+	LastSeen    time.Time `json:"lastSeen"`
+	ExpireDate  time.Time `json:"expireDate"`
+	// Current whether the session is the one making the request
+	Current bool `json:"current"`
+}
+
+// UserDataExportPlan a plan (story) in a battle the user owns
+type UserDataExportPlan struct {
+	Id                 string    `json:"id"`
+	Name               string    `json:"name"`
+	Type               string    `json:"type"`
+	ReferenceId        string    `json:"referenceId"`
+	Link               string    `json:"link"`
+	Description        string    `json:"description"`
+	AcceptanceCriteria string    `json:"acceptanceCriteria"`
+	Points             string    `json:"points"`
+	CreatedDate        time.Time `json:"createdDate"`
+}
+
+// UserDataExportBattle a battle the user owns along with its plans
+type UserDataExportBattle struct {
+	Id          string                `json:"id"`
+	Name        string                `json:"name"`
+	CreatedDate time.Time             `json:"createdDate"`
+	Plans       []*UserDataExportPlan `json:"plans"`
+}
+
+// UserDataExportVote a vote the user cast on a plan in any battle
+type UserDataExportVote struct {
+	BattleId  string `json:"battleId"`
+	PlanId    string `json:"planId"`
+	PlanName  string `json:"planName"`
+	VoteValue string `json:"vote"`
+}
+
+// UserDataExportStory a story in a storyboard the user owns
+type UserDataExportStory struct {
 	Id          string    `json:"id"`
-	Prefix      string    `json:"prefix"`
-	UserId      string    `json:"userId"`
 	Name        string    `json:"name"`
-	Key         string    `json:"apiKey"`
-	Active      bool      `json:"active"`
+	Content     string    `json:"content"`
+	Points      int       `json:"points"`
+	Closed      bool      `json:"closed"`
 	CreatedDate time.Time `json:"createdDate"`
-	UpdatedDate time.Time `json:"updatedDate"`
+}
+
+// UserDataExportStoryboard a storyboard the user owns along with its stories
+type UserDataExportStoryboard struct {
+	Id          string                 `json:"id"`
+	Name        string                 `json:"name"`
+	CreatedDate time.Time              `json:"createdDate"`
+	Stories     []*UserDataExportStory `json:"stories"`
+}
+
+// UserDataExportTeam a team the user is a member of
+type UserDataExportTeam struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+// UserDataExport all the personal data held about a user for a data subject access request,
+// guests only have a profile and whatever they participated in
+type UserDataExport struct {
+	User        *User                       `json:"user"`
+	Battles     []*UserDataExportBattle     `json:"battles"`
+	Votes       []*UserDataExportVote       `json:"votes"`
+	Storyboards []*UserDataExportStoryboard `json:"storyboards"`
+	Teams       []*UserDataExportTeam       `json:"teams"`
+	CreatedDate time.Time                   `json:"createdDate"`
 }
@@ -0,0 +1,87 @@
+// Package sigv4 provides AWS Signature Version 4 request signing for the Amazon APIs Thunderdome calls
+package sigv4
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Sign adds the AWS Signature Version 4 authorization of the request for the service,
+// signing the host, content type and any x-amz- headers
+func Sign(req *http.Request, Payload []byte, Now time.Time, Region string, Service string, AccessKeyID string, SecretAccessKey string) {
+	Now = Now.UTC()
+	AmzDate := Now.Format("20060102T150405Z")
+	Date := Now.Format("20060102")
+	req.Header.Set("X-Amz-Date", AmzDate)
+
+	Host := req.Host
+	if Host == "" {
+		Host = req.URL.Host
+	}
+	Headers := map[string]string{"host": Host}
+	for Name, Values := range req.Header {
+		Name = strings.ToLower(Name)
+		if Name == "content-type" || strings.HasPrefix(Name, "x-amz-") {
+			Headers[Name] = strings.TrimSpace(strings.Join(Values, ","))
+		}
+	}
+	Names := make([]string, 0, len(Headers))
+	for Name := range Headers {
+		Names = append(Names, Name)
+	}
+	sort.Strings(Names)
+
+	var CanonicalHeaders strings.Builder
+	for _, Name := range Names {
+		CanonicalHeaders.WriteString(Name + ":" + Headers[Name] + "\n")
+	}
+	SignedHeaders := strings.Join(Names, ";")
+
+	Path := req.URL.EscapedPath()
+	if Path == "" {
+		Path = "/"
+	}
+	CanonicalRequest := strings.Join([]string{
+		req.Method,
+		Path,
+		req.URL.RawQuery,
+		CanonicalHeaders.String(),
+		SignedHeaders,
+		PayloadHash(Payload),
+	}, "\n")
+
+	Scope := Date + "/" + Region + "/" + Service + "/aws4_request"
+	StringToSign := "AWS4-HMAC-SHA256\n" + AmzDate + "\n" + Scope + "\n" + PayloadHash([]byte(CanonicalRequest))
+
+	Key := hmacSHA256([]byte("AWS4"+SecretAccessKey), Date)
+	Key = hmacSHA256(Key, Region)
+	Key = hmacSHA256(Key, Service)
+	Key = hmacSHA256(Key, "aws4_request")
+	Signature := hex.EncodeToString(hmacSHA256(Key, StringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		AccessKeyID, Scope, SignedHeaders, Signature,
+	))
+}
+
+// PayloadHash the hex encoded SHA-256 hash of the payload e.g. for the X-Amz-Content-Sha256 header
+func PayloadHash(Payload []byte) string {
+	Hash := sha256.Sum256(Payload)
+
+	return hex.EncodeToString(Hash[:])
+}
+
+// hmacSHA256 the HMAC-SHA256 of the data with the key
+func hmacSHA256(Key []byte, Data string) []byte {
+	h := hmac.New(sha256.New, Key)
+	h.Write([]byte(Data))
+
+	return h.Sum(nil)
+}
@@ -0,0 +1,31 @@
+package sigv4
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSign calls Sign with requests from the AWS Signature Version 4 test suite
+// making sure the authorization matches the published signatures
+func TestSign(t *testing.T) {
+	Now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	Secret := "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+
+	req, _ := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	Sign(req, []byte{}, Now, "us-east-1", "service", "AKIDEXAMPLE", Secret)
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Fatalf(`Sign get-vanilla = %s, want %s`, got, want)
+	}
+
+	Payload := []byte("Param1=value1")
+	req, _ = http.NewRequest(http.MethodPost, "https://example.amazonaws.com/", strings.NewReader(string(Payload)))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	Sign(req, Payload, Now, "us-east-1", "service", "AKIDEXAMPLE", Secret)
+	want = "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Fatalf(`Sign post-x-www-form-urlencoded = %s, want %s`, got, want)
+	}
+}